@@ -0,0 +1,401 @@
+package bitflow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReadPosition describes how far a SampleInputStream has read its input.
+type ReadPosition struct {
+	// Header is the byte offset where the currently valid header starts.
+	Header int64 `json:"header"`
+
+	// Offset is the byte offset directly after the last sample.
+	Offset int64 `json:"offset"`
+}
+
+// ReadProgressTracker can be configured in a SampleReader to observe and control the samples forwarded by
+// a SampleInputStream. SkipSample and ForwardSample are called sequentially, in the order of the samples
+// in the input stream. Different input streams can call the methods concurrently.
+type ReadProgressTracker interface {
+	// ResumePosition is called once before reading a source. If the result is true, the input stream
+	// reads the header at the returned header offset and then continues reading at the returned offset.
+	// Seekable inputs (e.g. files) are positioned directly, other inputs skip the data without parsing it.
+	ResumePosition(source string) (ReadPosition, bool)
+
+	// SkipSample is called for every sample before it is forwarded. Returning true drops the sample.
+	SkipSample(source string, sample *Sample) bool
+
+	// ForwardSample is called for every sample that was not skipped. It must call forward, which passes the sample
+	// to the subsequent processing step, and record the progress if forward returns no error.
+	// The position parameter describes the end of the sample in the input stream.
+	ForwardSample(source string, sample *Sample, position ReadPosition, forward func() error) error
+}
+
+// StatefulProcessor can be implemented by SampleProcessors that hold internal state, which should be
+// restored when a pipeline resumes from a checkpoint. The Checkpointer calls StoreState while the data sources
+// are blocked and after all CheckpointFlushers were flushed, so no samples are in flight. Steps that modify their
+// state in background goroutines must still synchronize access to it.
+// A nil result of StoreState means that there is no state to store.
+type StatefulProcessor interface {
+	SampleProcessor
+	StoreState() ([]byte, error)
+	RestoreState(state []byte) error
+}
+
+// CheckpointFlusher can be implemented by SampleProcessors that buffer samples asynchronously, e.g. in queues or
+// output buffers. Before a checkpoint is taken, FlushCheckpoint is called while the data sources are blocked.
+// It must return after all samples received so far were persisted or forwarded to the subsequent step.
+// The samples are then part of the checkpoint, so they will not be read again when the pipeline resumes.
+type CheckpointFlusher interface {
+	FlushCheckpoint() error
+}
+
+// PipelineCheckpoint collects the StatefulProcessors and CheckpointFlushers of a pipeline. Steps are identified by
+// their position in the pipeline, so the processors are collected when the PipelineCheckpoint is created and
+// later changes of the pipeline are ignored. Steps that contain further pipelines, like forks, store and flush
+// their subpipelines themselves.
+type PipelineCheckpoint struct {
+	Steps    map[string]StatefulProcessor
+	Flushers []CheckpointFlusher // In the order of the pipeline
+}
+
+// NewPipelineCheckpoint collects the StatefulProcessors and CheckpointFlushers of the given pipeline.
+func NewPipelineCheckpoint(pipe *SamplePipeline) *PipelineCheckpoint {
+	res := &PipelineCheckpoint{Steps: make(map[string]StatefulProcessor)}
+	for i, proc := range pipe.Processors {
+		if stateful, ok := proc.(StatefulProcessor); ok {
+			res.Steps[strconv.Itoa(i)+": "+proc.String()] = stateful
+		}
+		if flusher, ok := proc.(CheckpointFlusher); ok {
+			res.Flushers = append(res.Flushers, flusher)
+		}
+	}
+	return res
+}
+
+// Flush calls FlushCheckpoint on all CheckpointFlushers, in the order of the pipeline, so that samples flushed
+// by one step are also flushed by the subsequent steps.
+func (p *PipelineCheckpoint) Flush() error {
+	for _, flusher := range p.Flushers {
+		if err := flusher.FlushCheckpoint(); err != nil {
+			return fmt.Errorf("Failed to flush %v: %v", flusher, err)
+		}
+	}
+	return nil
+}
+
+// Store returns the state of all StatefulProcessors that have a state.
+func (p *PipelineCheckpoint) Store() (map[string]json.RawMessage, error) {
+	res := make(map[string]json.RawMessage, len(p.Steps))
+	for key, step := range p.Steps {
+		state, err := step.StoreState()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to store state of step %v: %v", key, err)
+		}
+		if state != nil {
+			res[key] = state
+		}
+	}
+	return res, nil
+}
+
+// Restore restores the StatefulProcessors that have an entry in the given states.
+func (p *PipelineCheckpoint) Restore(states map[string]json.RawMessage) error {
+	for key, step := range p.Steps {
+		if state, ok := states[key]; ok {
+			if err := step.RestoreState(state); err != nil {
+				return fmt.Errorf("Failed to restore state of step %v: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SourceCheckpoint stores the progress of reading one input (e.g. file or TCP endpoint).
+type SourceCheckpoint struct {
+	ReadPosition
+	Samples  int       `json:"samples"`
+	LastTime time.Time `json:"last_time"`
+}
+
+// CheckpointData is the content of a checkpoint file.
+type CheckpointData struct {
+	Created time.Time                   `json:"created"`
+	Sources map[string]SourceCheckpoint `json:"sources"`
+	Steps   map[string]json.RawMessage  `json:"steps,omitempty"`
+}
+
+// Checkpointer periodically persists the reading progress of data sources and the state of StatefulProcessors
+// to a file. When a pipeline is restarted with the same checkpoint file, the data sources continue reading
+// after the last processed sample and the StatefulProcessors are restored to their previous state.
+// Files are positioned at the stored byte offset, while samples received over the network are skipped
+// based on their timestamp.
+//
+// To take a consistent checkpoint, the Checkpointer blocks all data sources until the samples that are currently
+// forwarded have been processed. It then flushes all CheckpointFlushers, so that buffered samples reach the sinks,
+// and stores the read positions together with the step states.
+type Checkpointer struct {
+	File     string
+	Interval time.Duration
+
+	barrier  sync.RWMutex // Locked for reading while a sample is forwarded, and for writing while a checkpoint is taken
+	saveLock sync.Mutex   // Serializes writing the checkpoint file
+	lock     sync.Mutex
+	restored CheckpointData
+	current  map[string]SourceCheckpoint
+	pipe     *PipelineCheckpoint
+	next     *PipelineCheckpoint
+}
+
+// NewCheckpointer creates a new Checkpointer and loads the given checkpoint file, if it exists.
+func NewCheckpointer(file string, interval time.Duration) (*Checkpointer, error) {
+	c := &Checkpointer{
+		File:     file,
+		Interval: interval,
+		current:  make(map[string]SourceCheckpoint),
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.restored); err != nil {
+		return nil, fmt.Errorf("Failed to parse checkpoint file %v: %v", file, err)
+	}
+	for source, progress := range c.restored.Sources {
+		c.current[source] = progress
+	}
+	log.Printf("Resuming from checkpoint %v (created %v, %v source(s))", file, c.restored.Created, len(c.restored.Sources))
+	return c, nil
+}
+
+// Tracker returns a ReadProgressTracker that records the progress in the receiving Checkpointer.
+// If byTimestamp is true, samples are skipped when their timestamp is not newer than the timestamp of the last
+// checkpointed sample. Otherwise, reading continues at the byte offset after the last processed sample.
+func (c *Checkpointer) Tracker(byTimestamp bool) ReadProgressTracker {
+	return &checkpointTracker{c, byTimestamp}
+}
+
+// RegisterPipeline makes the receiving Checkpointer store the state of all StatefulProcessors in the given pipeline
+// and flush its CheckpointFlushers before every checkpoint, see NewPipelineCheckpoint.
+// The first registered pipeline is restored from the loaded checkpoint immediately. A pipeline that is registered
+// while another pipeline is running (e.g. when reloading the script) replaces the running pipeline when Task() is
+// called for the new pipeline, see Task().
+func (c *Checkpointer) RegisterPipeline(pipe *SamplePipeline) error {
+	checkpoint := NewPipelineCheckpoint(pipe)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.pipe == nil {
+		c.pipe = checkpoint
+		return c.pipe.Restore(c.restored.Steps)
+	}
+	c.next = checkpoint
+	return nil
+}
+
+// Save writes the current progress to the checkpoint file. The file is replaced atomically.
+func (c *Checkpointer) Save() error {
+	c.saveLock.Lock()
+	defer c.saveLock.Unlock()
+	return c.save()
+}
+
+func (c *Checkpointer) save() error {
+	data, err := c.snapshot()
+	if err != nil {
+		return err
+	}
+	encoded, err := json.MarshalIndent(&data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := c.File + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, encoded, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, c.File); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.restored = data
+	c.lock.Unlock()
+	return nil
+}
+
+// snapshot blocks the data sources, flushes the pipeline and collects the read positions and step states,
+// so that the checkpoint covers exactly the samples that reached the sinks or the state of a step.
+func (c *Checkpointer) snapshot() (CheckpointData, error) {
+	c.barrier.Lock()
+	defer c.barrier.Unlock()
+	c.lock.Lock()
+	pipe := c.pipe
+	c.lock.Unlock()
+
+	data := CheckpointData{
+		Created: time.Now(),
+		Sources: make(map[string]SourceCheckpoint, len(c.current)),
+	}
+	if pipe != nil {
+		if err := pipe.Flush(); err != nil {
+			return data, err
+		}
+		steps, err := pipe.Store()
+		if err != nil {
+			return data, err
+		}
+		data.Steps = steps
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for source, progress := range c.current {
+		data.Sources[source] = progress
+	}
+	return data, nil
+}
+
+// Task returns a golib.Task that saves checkpoints in the configured interval. Another checkpoint is saved
+// when the task is stopped, which can happen before the pipeline is fully drained, so Save() should be called
+// after the pipeline finished.
+// A new Task must be created for every started pipeline, after the previous pipeline finished. If a new pipeline
+// was registered through RegisterPipeline, the state of the finished pipeline is saved and the new pipeline
+// is restored from it, before the new pipeline starts.
+func (c *Checkpointer) Task() golib.Task {
+	if err := c.swapPipeline(); err != nil {
+		log.Errorln(err)
+	}
+	return &golib.LoopTask{
+		Description: c.String(),
+		StopHook: func() {
+			if err := c.Save(); err != nil {
+				log.Errorln("Error saving checkpoint:", err)
+			}
+		},
+		Loop: func(stop golib.StopChan) error {
+			if stop.WaitTimeout(c.Interval) {
+				if err := c.Save(); err != nil {
+					log.Errorln("Error saving checkpoint:", err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func (c *Checkpointer) swapPipeline() error {
+	c.saveLock.Lock()
+	defer c.saveLock.Unlock()
+	c.lock.Lock()
+	next := c.next
+	c.lock.Unlock()
+	if next == nil {
+		return nil
+	}
+	if err := c.save(); err != nil {
+		return fmt.Errorf("Failed to save checkpoint of the previous pipeline: %v", err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pipe, c.next = next, nil
+	return c.pipe.Restore(c.restored.Steps)
+}
+
+func (c *Checkpointer) String() string {
+	return fmt.Sprintf("Checkpoint to %v (every %v)", c.File, c.Interval)
+}
+
+type checkpointTracker struct {
+	*Checkpointer
+	byTimestamp bool
+}
+
+func (t *checkpointTracker) ResumePosition(source string) (ReadPosition, bool) {
+	if t.byTimestamp {
+		return ReadPosition{}, false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	progress, ok := t.current[source]
+	return progress.ReadPosition, ok && progress.Offset > 0
+}
+
+func (t *checkpointTracker) SkipSample(source string, sample *Sample) bool {
+	if !t.byTimestamp {
+		return false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	restored, ok := t.restored.Sources[source]
+	return ok && !sample.Time.After(restored.LastTime)
+}
+
+// ForwardSample holds the barrier while forwarding the sample, so that a checkpoint is never taken between
+// forwarding a sample and recording its position.
+func (t *checkpointTracker) ForwardSample(source string, sample *Sample, position ReadPosition, forward func() error) error {
+	t.barrier.RLock()
+	defer t.barrier.RUnlock()
+	if err := forward(); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	progress := t.current[source]
+	progress.Samples++
+	progress.LastTime = sample.Time
+	progress.ReadPosition = position
+	t.current[source] = progress
+	return nil
+}
+
+// encodeSamples marshals the given samples in the binary format, so that StatefulProcessors can store buffered
+// samples in their state. All samples must match the header.
+func encodeSamples(header *Header, samples []*Sample) ([]byte, error) {
+	var buf bytes.Buffer
+	var marshaller BinaryMarshaller
+	if err := marshaller.WriteHeader(header, true, &buf); err != nil {
+		return nil, err
+	}
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return nil, fmt.Errorf("Sample has %v values, but header has %v fields", len(sample.Values), len(header.Fields))
+		}
+		if err := marshaller.WriteSample(sample, header, true, &buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeSamples reads the header and samples written by encodeSamples.
+func decodeSamples(data []byte) (*Header, []*Sample, error) {
+	var marshaller BinaryMarshaller
+	reader := bufio.NewReader(bytes.NewReader(data))
+	header, _, err := marshaller.Read(reader, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var samples []*Sample
+	for {
+		newHeader, sample, err := marshaller.ReadSample(reader, header, 0)
+		if err == io.EOF {
+			return &header.Header, samples, nil
+		} else if err != nil {
+			return nil, nil, err
+		} else if newHeader != nil {
+			return nil, nil, fmt.Errorf("Unexpected header after %v sample(s)", len(samples))
+		}
+		samples = append(samples, sample)
+	}
+}
@@ -0,0 +1,407 @@
+package bitflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CheckpointTestSuite struct {
+	testSuiteBase
+	dir string
+}
+
+func TestCheckpoint(t *testing.T) {
+	suite.Run(t, new(CheckpointTestSuite))
+}
+
+func (suite *CheckpointTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "checkpoint-tests")
+	suite.NoError(err)
+	suite.dir = dir
+}
+
+func (suite *CheckpointTestSuite) TearDownTest() {
+	suite.NoError(os.RemoveAll(suite.dir))
+}
+
+// countingStatefulProcessor counts the received samples and stores the counter as its state
+type countingStatefulProcessor struct {
+	DroppingSampleProcessor
+	count int64
+}
+
+func (p *countingStatefulProcessor) Sample(_ *Sample, _ *Header) error {
+	atomic.AddInt64(&p.count, 1)
+	return nil
+}
+
+func (p *countingStatefulProcessor) StoreState() ([]byte, error) {
+	return json.Marshal(atomic.LoadInt64(&p.count))
+}
+
+func (p *countingStatefulProcessor) RestoreState(state []byte) error {
+	var count int64
+	err := json.Unmarshal(state, &count)
+	atomic.StoreInt64(&p.count, count)
+	return err
+}
+
+// checkpointTestSink stores the received values and fails after a configured number of samples
+type checkpointTestSink struct {
+	DroppingSampleProcessor
+	failAfter int
+	values    [][]Value
+	fields    [][]string
+}
+
+func (s *checkpointTestSink) Sample(sample *Sample, header *Header) error {
+	if s.failAfter > 0 && len(s.values) >= s.failAfter {
+		return errors.New("test sink failure")
+	}
+	s.values = append(s.values, sample.Values)
+	s.fields = append(s.fields, header.Fields)
+	return nil
+}
+
+// nonSeekableReader hides the Seek method of the wrapped reader
+type nonSeekableReader struct {
+	io.Reader
+}
+
+func (nonSeekableReader) Close() error {
+	return nil
+}
+
+func (suite *CheckpointTestSuite) newCheckpointer(name string) *Checkpointer {
+	c, err := NewCheckpointer(path.Join(suite.dir, name+".json"), time.Hour)
+	suite.NoError(err)
+	return c
+}
+
+// writeSamples writes one sample with the fields a, followed by four samples with the fields a and b
+func (suite *CheckpointTestSuite) writeSamples(m Marshaller) []byte {
+	var buf closingBuffer
+	stream := (&SampleWriter{ParallelSampleHandler: parallel_handler}).Open(&buf, m)
+	t := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	suite.NoError(stream.Sample(&Sample{Values: []Value{1}, Time: t}, &Header{Fields: []string{"a"}}))
+	header := &Header{Fields: []string{"a", "b"}}
+	for i := 2; i <= 5; i++ {
+		suite.NoError(stream.Sample(&Sample{Values: []Value{Value(i), Value(i * 10)}, Time: t.Add(time.Duration(i) * time.Second)}, header))
+	}
+	suite.NoError(stream.Close())
+	return buf.Bytes()
+}
+
+func (suite *CheckpointTestSuite) read(tracker ReadProgressTracker, input io.ReadCloser, sink SampleSink) error {
+	reader := &SampleReader{ParallelSampleHandler: parallel_handler, Tracker: tracker}
+	stream := reader.Open(input, sink)
+	_, err := stream.ReadSamples("test-source")
+	_ = stream.Close()
+	return err
+}
+
+func (suite *CheckpointTestSuite) TestStoreAndRestore() {
+	c := suite.newCheckpointer("checkpoint")
+	first := &countingStatefulProcessor{count: 3}
+	pipe := new(SamplePipeline).Add(new(NoopProcessor)).Add(first)
+	suite.NoError(c.RegisterPipeline(pipe))
+	last := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	suite.NoError(c.Tracker(false).ForwardSample("file", &Sample{Time: last}, ReadPosition{Header: 10, Offset: 50}, func() error { return nil }))
+	suite.NoError(c.Save())
+
+	restored := suite.newCheckpointer("checkpoint")
+	second := new(countingStatefulProcessor)
+	suite.NoError(restored.RegisterPipeline(new(SamplePipeline).Add(new(NoopProcessor)).Add(second)))
+	suite.Equal(int64(3), second.count)
+	pos, ok := restored.Tracker(false).ResumePosition("file")
+	suite.True(ok)
+	suite.Equal(ReadPosition{Header: 10, Offset: 50}, pos)
+	_, ok = restored.Tracker(false).ResumePosition("other-file")
+	suite.False(ok)
+	_, ok = restored.Tracker(true).ResumePosition("file")
+	suite.False(ok, "Sources tracked by timestamp must not be positioned")
+
+	// Steps are identified by their position in the pipeline
+	other := new(countingStatefulProcessor)
+	suite.NoError(suite.newCheckpointer("checkpoint").RegisterPipeline(new(SamplePipeline).Add(other)))
+	suite.Equal(int64(0), other.count)
+}
+
+func (suite *CheckpointTestSuite) TestRestoreReloadedPipeline() {
+	c := suite.newCheckpointer("checkpoint")
+	first := &countingStatefulProcessor{count: 5}
+	suite.NoError(c.RegisterPipeline(new(SamplePipeline).Add(first)))
+	c.Task()
+
+	second := new(countingStatefulProcessor)
+	suite.NoError(c.RegisterPipeline(new(SamplePipeline).Add(second)))
+	suite.Equal(int64(0), second.count, "The reloaded pipeline must not be restored while the old pipeline is running")
+	first.count = 7
+	c.Task()
+	suite.Equal(int64(7), second.count, "The reloaded pipeline must continue with the state of the finished pipeline")
+
+	second.count = 8
+	suite.NoError(c.Save())
+	third := new(countingStatefulProcessor)
+	suite.NoError(suite.newCheckpointer("checkpoint").RegisterPipeline(new(SamplePipeline).Add(third)))
+	suite.Equal(int64(8), third.count)
+}
+
+func (suite *CheckpointTestSuite) testResume(m Marshaller, seekable bool) {
+	name := m.String() + "-" + strconv.FormatBool(seekable)
+	data := suite.writeSamples(m)
+	open := func() io.ReadCloser {
+		if seekable {
+			file := path.Join(suite.dir, name)
+			suite.NoError(ioutil.WriteFile(file, data, 0644))
+			f, err := os.Open(file)
+			suite.NoError(err)
+			return &SynchronizedReadCloser{ReadCloser: f}
+		}
+		return nonSeekableReader{bytes.NewReader(data)}
+	}
+
+	c := suite.newCheckpointer(name)
+	sink := &checkpointTestSink{failAfter: 3}
+	_ = suite.read(c.Tracker(false), open(), sink) // The sink error can be hidden by the end of the input
+	suite.Equal([][]Value{{1}, {2, 20}, {3, 30}}, sink.values)
+	suite.NoError(c.Save())
+
+	sink = new(checkpointTestSink)
+	suite.NoError(suite.read(suite.newCheckpointer(name).Tracker(false), open(), sink))
+	suite.Equal([][]Value{{4, 40}, {5, 50}}, sink.values, "Reading must continue after the last checkpointed sample")
+	suite.Equal([][]string{{"a", "b"}, {"a", "b"}}, sink.fields, "The header of the checkpointed sample must be restored")
+}
+
+func (suite *CheckpointTestSuite) TestResumeCsv() {
+	suite.testResume(new(CsvMarshaller), true)
+	suite.testResume(new(CsvMarshaller), false)
+}
+
+func (suite *CheckpointTestSuite) TestResumeBinary() {
+	suite.testResume(new(BinaryMarshaller), true)
+	suite.testResume(new(BinaryMarshaller), false)
+}
+
+func (suite *CheckpointTestSuite) TestSkipByTimestamp() {
+	data := suite.writeSamples(new(CsvMarshaller))
+	c := suite.newCheckpointer("checkpoint")
+	sink := &checkpointTestSink{failAfter: 2}
+	_ = suite.read(c.Tracker(true), nonSeekableReader{bytes.NewReader(data)}, sink)
+	suite.Len(sink.values, 2)
+	suite.NoError(c.Save())
+
+	sink = new(checkpointTestSink)
+	suite.NoError(suite.read(suite.newCheckpointer("checkpoint").Tracker(true), nonSeekableReader{bytes.NewReader(data)}, sink))
+	suite.Equal([][]Value{{3, 30}, {4, 40}, {5, 50}}, sink.values)
+}
+
+// TestConcurrentSave should be executed with the -race flag
+func (suite *CheckpointTestSuite) TestConcurrentSave() {
+	const numSamples = 2000
+	var buf closingBuffer
+	stream := (&SampleWriter{ParallelSampleHandler: parallel_handler}).Open(&buf, new(CsvMarshaller))
+	header := &Header{Fields: []string{"a"}}
+	for i := 0; i < numSamples; i++ {
+		suite.NoError(stream.Sample(&Sample{Values: []Value{Value(i)}}, header))
+	}
+	suite.NoError(stream.Close())
+
+	c := suite.newCheckpointer("checkpoint")
+	proc := new(countingStatefulProcessor)
+	suite.NoError(c.RegisterPipeline(new(SamplePipeline).Add(proc)))
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				suite.NoError(c.Save())
+			}
+		}
+	}()
+	err := suite.read(c.Tracker(false), nonSeekableReader{bytes.NewReader(buf.Bytes())}, proc)
+	close(done)
+	wg.Wait()
+	suite.NoError(err)
+	suite.NoError(c.Save())
+
+	content, err := ioutil.ReadFile(c.File)
+	suite.NoError(err)
+	var data CheckpointData
+	suite.NoError(json.Unmarshal(content, &data))
+	suite.Equal(numSamples, data.Sources["test-source"].Samples)
+	suite.Equal(int64(buf.Len()), data.Sources["test-source"].Offset)
+	suite.Equal(strconv.Itoa(numSamples), string(data.Steps["0: "+proc.String()]))
+}
+
+// flushingTestProcessor buffers the samples until FlushCheckpoint is called and stores the number of
+// flushed samples as its state
+type flushingTestProcessor struct {
+	DroppingSampleProcessor
+	buffered int
+	flushed  int
+}
+
+func (p *flushingTestProcessor) Sample(_ *Sample, _ *Header) error {
+	p.buffered++
+	return nil
+}
+
+func (p *flushingTestProcessor) FlushCheckpoint() error {
+	p.flushed += p.buffered
+	p.buffered = 0
+	return nil
+}
+
+func (p *flushingTestProcessor) StoreState() ([]byte, error) {
+	return json.Marshal(p.flushed)
+}
+
+func (p *flushingTestProcessor) RestoreState(state []byte) error {
+	return json.Unmarshal(state, &p.flushed)
+}
+
+func (suite *CheckpointTestSuite) readCheckpoint(c *Checkpointer) CheckpointData {
+	content, err := ioutil.ReadFile(c.File)
+	suite.NoError(err)
+	var data CheckpointData
+	suite.NoError(json.Unmarshal(content, &data))
+	return data
+}
+
+func (suite *CheckpointTestSuite) TestSaveWaitsForForwardedSample() {
+	c := suite.newCheckpointer("checkpoint")
+	proc := new(flushingTestProcessor)
+	suite.NoError(c.RegisterPipeline(new(SamplePipeline).Add(proc)))
+
+	forwarding, release := make(chan struct{}), make(chan struct{})
+	forwarded := make(chan error)
+	go func() {
+		forwarded <- c.Tracker(false).ForwardSample("source", new(Sample), ReadPosition{Offset: 10}, func() error {
+			close(forwarding)
+			<-release
+			return proc.Sample(nil, nil)
+		})
+	}()
+	<-forwarding
+	saved := make(chan error)
+	go func() {
+		saved <- c.Save()
+	}()
+	select {
+	case <-saved:
+		suite.Fail("The checkpoint must not be saved while a sample is forwarded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	suite.NoError(<-forwarded)
+	suite.NoError(<-saved)
+
+	// The position and the state are stored together, after the buffered sample was flushed
+	data := suite.readCheckpoint(c)
+	suite.Equal(int64(10), data.Sources["source"].Offset)
+	suite.Equal("1", string(data.Steps["0: "+proc.String()]))
+}
+
+func (suite *CheckpointTestSuite) TestFailedSampleNotRecorded() {
+	c := suite.newCheckpointer("checkpoint")
+	err := c.Tracker(false).ForwardSample("source", new(Sample), ReadPosition{Offset: 10}, func() error {
+		return errors.New("failed")
+	})
+	suite.EqualError(err, "failed")
+	suite.NoError(c.Save())
+	suite.Empty(suite.readCheckpoint(c).Sources)
+}
+
+func (suite *CheckpointTestSuite) TestFlushOutputStream() {
+	var buf closingBuffer
+	stream := (&SampleWriter{ParallelSampleHandler: parallel_handler}).OpenBuffered(&buf, new(CsvMarshaller), 4096)
+	header := &Header{Fields: []string{"a"}}
+	for i := 0; i < 3; i++ {
+		suite.NoError(stream.Sample(&Sample{Values: []Value{Value(i)}}, header))
+	}
+	suite.NoError(stream.Flush())
+	suite.Equal(4, bytes.Count(buf.Bytes(), []byte("\n")), "All samples must be written after flushing")
+	suite.NoError(stream.Close())
+	suite.NoError(stream.Flush(), "Flushing a closed stream must not block")
+}
+
+func (suite *CheckpointTestSuite) testSamples(num int) []*Sample {
+	samples := make([]*Sample, num)
+	for i := range samples {
+		samples[i] = &Sample{Values: []Value{Value(i)}, Time: time.Unix(int64(i), 0)}
+		samples[i].SetTag("host", "a")
+	}
+	return samples
+}
+
+func (suite *CheckpointTestSuite) TestBatchState() {
+	header := &Header{Fields: []string{"a"}}
+	batch := &BatchProcessor{FlushTags: []string{"host"}}
+	var wg sync.WaitGroup
+	batch.SetSink(new(checkpointTestSink))
+	batch.Start(&wg)
+	for _, sample := range suite.testSamples(3) {
+		suite.NoError(batch.Sample(sample, header))
+	}
+	state, err := batch.StoreState()
+	suite.NoError(err)
+	batch.Close()
+	wg.Wait()
+
+	restored := &BatchProcessor{FlushTags: []string{"host"}}
+	sink := new(checkpointTestSink)
+	restored.SetSink(sink)
+	suite.NoError(restored.RestoreState(state))
+	restored.Start(&wg)
+	sample := &Sample{Values: []Value{3}, Time: time.Unix(3, 0)}
+	sample.SetTag("host", "a")
+	suite.NoError(restored.Sample(sample, header))
+	suite.Empty(sink.values, "The restored batch must not be flushed, because the header and tags did not change")
+	restored.Close()
+	wg.Wait()
+	suite.Equal([][]Value{{0}, {1}, {2}, {3}}, sink.values)
+
+	empty, err := new(BatchProcessor).StoreState()
+	suite.NoError(err)
+	suite.Nil(empty)
+}
+
+func (suite *CheckpointTestSuite) TestWindowState() {
+	header := &Header{Fields: []string{"a"}}
+	window := &WindowProcessor{Mode: TumblingWindow, Size: 10 * time.Second, KeyTags: []string{"host"}}
+	window.SetSink(new(checkpointTestSink))
+	for _, sample := range suite.testSamples(3) {
+		suite.NoError(window.Sample(sample, header))
+	}
+	state, err := window.StoreState()
+	suite.NoError(err)
+
+	restored := &WindowProcessor{Mode: TumblingWindow, Size: 10 * time.Second, KeyTags: []string{"host"}}
+	sink := new(checkpointTestSink)
+	restored.SetSink(sink)
+	suite.NoError(restored.RestoreState(state))
+	sample := &Sample{Values: []Value{3}, Time: time.Unix(3, 0)}
+	sample.SetTag("host", "a")
+	suite.NoError(restored.Sample(sample, header))
+	suite.Empty(sink.values)
+	restored.Close()
+	suite.Equal([][]Value{{0}, {1}, {2}, {3}}, sink.values)
+}
@@ -26,6 +26,7 @@ const (
 	FileEndpoint      = EndpointType("file")
 	StdEndpoint       = EndpointType("std")
 	HttpEndpoint      = EndpointType("http")
+	TailEndpoint      = EndpointType("tail")
 	EmptyEndpoint     = EndpointType("empty")
 
 	UndefinedFormat  = MarshallingFormat("")
@@ -34,6 +35,11 @@ const (
 	BinaryFormat     = MarshallingFormat("bin")
 	PrometheusFormat = MarshallingFormat("prometheus")
 
+	// Parameters that can be appended to URL endpoints as query parameters to override the parallel
+	// marshalling configuration of the EndpointFactory for one endpoint, e.g. tcp://host:port?parallel=8&buffer=100
+	ParallelParsersParam = "parallel"
+	BufferedSamplesParam = "buffer"
+
 	tcp_download_retry_interval = 1000 * time.Millisecond
 	tcp_dial_timeout            = 2000 * time.Millisecond
 )
@@ -58,6 +64,8 @@ var DefaultEndpointFactory = EndpointFactory{
 	FlagOutputTcpListenBuffer: 0,
 	FlagFilesAppend:           false,
 	FlagFileVanishedCheck:     0,
+	FlagCheckpointInterval:    10 * time.Second,
+	FlagTailPollInterval:      DefaultTailPollInterval,
 }
 
 func init() {
@@ -82,6 +90,19 @@ type EndpointFactory struct {
 	FlagFilesAppend       bool
 	FlagFileVanishedCheck time.Duration
 
+	// File output rotation flags
+
+	FlagFilesRotateSize     int // Megabytes
+	FlagFilesRotateInterval time.Duration
+	FlagFilesCompress       bool
+	FlagFilesRetain         int
+	FlagFilesRetainAge      time.Duration
+
+	// Followed file input flags
+
+	FlagTailPollInterval time.Duration
+	FlagTailOffsetsFile  string
+
 	// TCP input/output flags
 
 	FlagOutputTcpListenBuffer uint
@@ -94,6 +115,16 @@ type EndpointFactory struct {
 
 	FlagParallelHandler ParallelSampleHandler
 
+	// Checkpoint flags
+
+	FlagCheckpointFile     string
+	FlagCheckpointInterval time.Duration
+
+	// Checkpoints is created by CreateInput(), if FlagCheckpointFile is set. It records the progress
+	// of all created file and TCP data sources. It is also initialized, if the checkpoint file already exists,
+	// so that the created data sources skip all samples that were already processed.
+	Checkpoints *Checkpointer
+
 	// CustomDataSources can be filled by client code before EndpointFactory.CreateInput or similar
 	// methods to allow creation of custom data sources. The map key is a short name of the data source
 	// that can be used in URL endpoint descriptions. The parameter for the function will be
@@ -112,6 +143,11 @@ type EndpointFactory struct {
 	// methods to allow custom marshalling formats in output files, network connections and so on.
 	Marshallers map[MarshallingFormat]func() Marshaller
 
+	// Unmarshallers can be filled by client code to allow reading custom marshalling formats. Since the format
+	// of input data is only auto-detected for the builtin formats, custom formats must be specified
+	// explicitly in the input endpoint, e.g. format+file://data.txt
+	Unmarshallers map[MarshallingFormat]func() Unmarshaller
+
 	// CustomGeneralFlags, CustomInputFlags and CustomOutputFlags lets client code
 	// register custom command line flags that configure aspects of endpoints created
 	// through CustomDataSources and CustomDataSinks.
@@ -131,6 +167,7 @@ func (f *EndpointFactory) Clear() {
 	f.CustomDataSources = make(map[EndpointType]func(string) (SampleSource, error))
 	f.CustomDataSinks = make(map[EndpointType]func(string) (SampleProcessor, error))
 	f.Marshallers = make(map[MarshallingFormat]func() Marshaller)
+	f.Unmarshallers = make(map[MarshallingFormat]func() Unmarshaller)
 	f.CustomGeneralFlags = nil
 	f.CustomInputFlags = nil
 	f.CustomOutputFlags = nil
@@ -164,6 +201,12 @@ func RegisterBuiltinMarshallers(factory *EndpointFactory) {
 	factory.Marshallers[PrometheusFormat] = func() Marshaller {
 		return PrometheusMarshaller{}
 	}
+	factory.Unmarshallers[CsvFormat] = func() Unmarshaller {
+		return new(CsvMarshaller)
+	}
+	factory.Unmarshallers[BinaryFormat] = func() Unmarshaller {
+		return new(BinaryMarshaller)
+	}
 }
 
 func (f *EndpointFactory) ParseParameters(params map[string]string) (err error) {
@@ -215,6 +258,15 @@ func (f *EndpointFactory) ParseParameters(params map[string]string) (err error)
 	uintParam(&f.FlagOutputTcpListenBuffer, "listen-buffer")
 	boolParam(&f.FlagFilesAppend, "files-append")
 	durationParam(&f.FlagFileVanishedCheck, "files-check-output")
+	intParam(&f.FlagFilesRotateSize, "files-rotate-size")
+	durationParam(&f.FlagFilesRotateInterval, "files-rotate-interval")
+	boolParam(&f.FlagFilesCompress, "files-compress")
+	intParam(&f.FlagFilesRetain, "files-retain")
+	durationParam(&f.FlagFilesRetainAge, "files-retain-age")
+	strParam(&f.FlagCheckpointFile, "checkpoint")
+	durationParam(&f.FlagCheckpointInterval, "checkpoint-interval")
+	durationParam(&f.FlagTailPollInterval, "tail-poll")
+	strParam(&f.FlagTailOffsetsFile, "tail-offsets")
 
 	if err == nil && len(params) > 0 {
 		err = fmt.Errorf("Unexpected parameters for EndpointFactory: %v", params)
@@ -257,6 +309,10 @@ func (f *EndpointFactory) RegisterInputFlagsTo(fs *flag.FlagSet) {
 	fs.BoolVar(&f.FlagInputFilesRobust, "files-robust", f.FlagInputFilesRobust, "When encountering errors while reading files, print warnings instead of failing.")
 	fs.UintVar(&f.FlagInputTcpAcceptLimit, "listen-limit", f.FlagInputTcpAcceptLimit, "Limit number of simultaneous TCP connections accepted for incoming data.")
 	fs.BoolVar(&f.FlagTcpSourceDropErrors, "tcp-drop-err", f.FlagTcpSourceDropErrors, "Don't print errors when establishing active TCP input connection fails")
+	fs.StringVar(&f.FlagCheckpointFile, "checkpoint", f.FlagCheckpointFile, "Periodically store the progress of file and TCP inputs and the state of stateful processing steps in the given file. If the file exists, processing resumes from the stored checkpoint.")
+	fs.DurationVar(&f.FlagCheckpointInterval, "checkpoint-interval", f.FlagCheckpointInterval, "Interval for storing checkpoints, see -checkpoint.")
+	fs.DurationVar(&f.FlagTailPollInterval, "tail-poll", f.FlagTailPollInterval, "For followed files (tail://<glob pattern>), check for new files and appended data in the given interval.")
+	fs.StringVar(&f.FlagTailOffsetsFile, "tail-offsets", f.FlagTailOffsetsFile, "For followed files (tail://<glob pattern>), store the number of samples read from every file in the given file. If the file exists, reading resumes from the stored offsets.")
 	for _, factoryFunc := range f.CustomInputFlags {
 		factoryFunc(fs)
 	}
@@ -267,6 +323,11 @@ func (f *EndpointFactory) RegisterOutputFlagsTo(fs *flag.FlagSet) {
 	fs.UintVar(&f.FlagOutputTcpListenBuffer, "listen-buffer", f.FlagOutputTcpListenBuffer, "When listening for outgoing connections, store a number of samples in a ring buffer that will be delivered first to all established connections.")
 	fs.BoolVar(&f.FlagFilesAppend, "files-append", f.FlagFilesAppend, "For file output, do no create new files by incrementing the suffix and append to existing files.")
 	fs.DurationVar(&f.FlagFileVanishedCheck, "files-check-output", f.FlagFileVanishedCheck, "For file output, check if the output file vanished or changed in regular intervals. Reopen the file in that case.")
+	fs.IntVar(&f.FlagFilesRotateSize, "files-rotate-size", f.FlagFilesRotateSize, "For file output, open a new file after the given number of megabytes was written to the current file.")
+	fs.DurationVar(&f.FlagFilesRotateInterval, "files-rotate-interval", f.FlagFilesRotateInterval, "For file output, open a new file in the given interval, aligned to the wall clock (e.g. every full hour).")
+	fs.BoolVar(&f.FlagFilesCompress, "files-compress", f.FlagFilesCompress, "For file output, compress rotated files with gzip.")
+	fs.IntVar(&f.FlagFilesRetain, "files-retain", f.FlagFilesRetain, "For file output, only keep the given number of rotated files and delete older ones.")
+	fs.DurationVar(&f.FlagFilesRetainAge, "files-retain-age", f.FlagFilesRetainAge, "For file output, delete rotated files that were not modified for the given duration.")
 	fs.BoolVar(&f.FlagTcpLogReceivedData, "tcp-log-received", f.FlagTcpLogReceivedData, "For all TCP output connections, log received data, which is usually not expected.")
 	for _, factoryFunc := range f.CustomOutputFlags {
 		factoryFunc(fs)
@@ -286,20 +347,42 @@ func (f *EndpointFactory) Reader(um Unmarshaller) SampleReader {
 func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 	var result SampleSource
 	inputType := UndefinedEndpoint
+	inputFormat := UndefinedFormat
+	var inputHandler ParallelSampleHandler
 	for _, input := range inputs {
 		endpoint, err := f.ParseEndpointDescription(input, false)
 		if err != nil {
 			return nil, err
 		}
 		if endpoint.Format != UndefinedFormat {
-			return nil, fmt.Errorf("Format cannot be specified for data input: %v", input)
+			if _, ok := f.Unmarshallers[endpoint.Format]; !ok {
+				return nil, fmt.Errorf("Format '%v' cannot be specified for data input: %v", endpoint.Format, input)
+			}
+		}
+		handler, err := endpoint.ParallelHandler(f.FlagParallelHandler)
+		if err != nil {
+			return nil, err
 		}
 		if result == nil {
 			reader := f.Reader(nil) // nil as Unmarshaller makes the SampleSource auto-detect the format
+			reader.ParallelSampleHandler = handler
+			inputHandler = handler
+			if endpoint.Format != UndefinedFormat {
+				reader.Unmarshaller, err = f.CreateUnmarshaller(endpoint.Format)
+				if err != nil {
+					return nil, err
+				}
+			}
+			inputFormat = endpoint.Format
 			if f.FlagSourceTag != "" {
 				reader.Handler = sourceTagger(f.FlagSourceTag)
 			}
 			inputType = endpoint.Type
+			if endpoint.Type == FileEndpoint || endpoint.Type == TcpEndpoint || endpoint.Type == HttpEndpoint || endpoint.Type == TcpListenEndpoint {
+				if reader.Tracker, err = f.checkpointTracker(endpoint.Type != FileEndpoint); err != nil {
+					return nil, err
+				}
+			}
 			switch endpoint.Type {
 			case StdEndpoint:
 				source := NewConsoleSource()
@@ -331,6 +414,15 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 				}
 				source.Reader = reader
 				result = source
+			case TailEndpoint:
+				source := &TailSource{
+					Patterns:     []string{endpoint.Target},
+					PollInterval: f.FlagTailPollInterval,
+					OffsetsFile:  f.FlagTailOffsetsFile,
+					IoBuffer:     f.FlagIoBuffer,
+				}
+				source.Reader = reader
+				result = source
 			default:
 				if factory, ok := f.CustomDataSources[endpoint.Type]; ok && endpoint.IsCustomType {
 					var factoryErr error
@@ -346,6 +438,12 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 			if inputType != endpoint.Type {
 				return nil, fmt.Errorf("Please provide only one data source (Provided %v and %v)", inputType, endpoint.Type)
 			}
+			if inputFormat != endpoint.Format {
+				return nil, fmt.Errorf("All inputs must have the same format (Provided '%v' and '%v')", inputFormat, endpoint.Format)
+			}
+			if inputHandler != handler {
+				return nil, fmt.Errorf("All inputs must have the same parallel marshalling parameters (Provided %v and %v)", inputHandler, handler)
+			}
 			if endpoint.IsCustomType {
 				return nil, fmt.Errorf("Cannot define multiple sources for custom input type '%v'", inputType)
 			}
@@ -360,6 +458,9 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 			case FileEndpoint:
 				source := result.(*FileSource)
 				source.FileNames = append(source.FileNames, endpoint.Target)
+			case TailEndpoint:
+				source := result.(*TailSource)
+				source.Patterns = append(source.Patterns, endpoint.Target)
 			default:
 				return nil, errors.New("Unknown endpoint type: " + string(endpoint.Type))
 			}
@@ -368,6 +469,20 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 	return result, nil
 }
 
+func (f *EndpointFactory) checkpointTracker(byTimestamp bool) (ReadProgressTracker, error) {
+	if f.FlagCheckpointFile == "" {
+		return nil, nil
+	}
+	if f.Checkpoints == nil {
+		checkpoints, err := NewCheckpointer(f.FlagCheckpointFile, f.FlagCheckpointInterval)
+		if err != nil {
+			return nil, err
+		}
+		f.Checkpoints = checkpoints
+	}
+	return f.Checkpoints.Tracker(byTimestamp), nil
+}
+
 // Writer returns an instance of SampleWriter, configured by the values stored in the EndpointFactory.
 func (f *EndpointFactory) Writer() SampleWriter {
 	return SampleWriter{f.FlagParallelHandler}
@@ -381,6 +496,10 @@ func (f *EndpointFactory) CreateOutput(output string) (SampleProcessor, error) {
 	if err != nil {
 		return nil, err
 	}
+	handler, err := endpoint.ParallelHandler(f.FlagParallelHandler)
+	if err != nil {
+		return nil, err
+	}
 	var marshaller Marshaller
 	if format := endpoint.OutputFormat(); format != UndefinedFormat {
 		marshaller, err = f.CreateMarshaller(format)
@@ -407,6 +526,11 @@ func (f *EndpointFactory) CreateOutput(output string) (SampleProcessor, error) {
 			CleanFiles:        f.FlagOutputFilesClean,
 			Append:            f.FlagFilesAppend,
 			VanishedFileCheck: f.FlagFileVanishedCheck,
+			RotateSize:        int64(f.FlagFilesRotateSize) * 1024 * 1024,
+			RotateInterval:    f.FlagFilesRotateInterval,
+			CompressRotated:   f.FlagFilesCompress,
+			RetainFiles:       f.FlagFilesRetain,
+			RetainAge:         f.FlagFilesRetainAge,
 		}
 		marshallingSink = &sink.AbstractMarshallingSampleOutput
 		resultSink = sink
@@ -462,7 +586,7 @@ func (f *EndpointFactory) CreateOutput(output string) (SampleProcessor, error) {
 	}
 	if marshallingSink != nil {
 		marshallingSink.SetMarshaller(marshaller)
-		marshallingSink.Writer = f.Writer()
+		marshallingSink.Writer = SampleWriter{handler}
 	}
 	return resultSink, nil
 }
@@ -475,6 +599,14 @@ func (f *EndpointFactory) CreateMarshaller(format MarshallingFormat) (Marshaller
 	return factory(), nil
 }
 
+func (f *EndpointFactory) CreateUnmarshaller(format MarshallingFormat) (Unmarshaller, error) {
+	factory, ok := f.Unmarshallers[format]
+	if !ok {
+		return nil, fmt.Errorf("Unknown unmarshaller format: %v", format)
+	}
+	return factory(), nil
+}
+
 // IsConsoleOutput returns true if the given processor will output to the standard output when started.
 func IsConsoleOutput(sink SampleProcessor) bool {
 	writer, ok1 := sink.(*WriterSink)
@@ -483,7 +615,8 @@ func IsConsoleOutput(sink SampleProcessor) bool {
 }
 
 // EndpointDescription describes a data endpoint, regardless of the data direction
-// (input or output).
+// (input or output). Params contains the query parameters that configure the parallel
+// marshalling for this endpoint, see ParallelHandler().
 type EndpointDescription struct {
 	Format       MarshallingFormat
 	Type         EndpointType
@@ -492,6 +625,29 @@ type EndpointDescription struct {
 	Params       map[string]string
 }
 
+// ParallelHandler returns the given ParallelSampleHandler, modified by the ParallelParsersParam and
+// BufferedSamplesParam parameters of the endpoint.
+func (e EndpointDescription) ParallelHandler(handler ParallelSampleHandler) (ParallelSampleHandler, error) {
+	intParam := func(name string, min int, target *int) error {
+		if str, ok := e.Params[name]; ok {
+			val, err := strconv.Atoi(str)
+			if err == nil && val < min {
+				err = fmt.Errorf("Must be at least %v", min)
+			}
+			if err != nil {
+				return fmt.Errorf("Invalid value '%v' for endpoint parameter '%v': %v", str, name, err)
+			}
+			*target = val
+		}
+		return nil
+	}
+	if err := intParam(ParallelParsersParam, 1, &handler.ParallelParsers); err != nil {
+		return handler, err
+	}
+	err := intParam(BufferedSamplesParam, 0, &handler.BufferedSamples)
+	return handler, err
+}
+
 // OutputFormat returns the MarshallingFormat that should be used when sending
 // data to the described endpoint.
 func (e EndpointDescription) OutputFormat() MarshallingFormat {
@@ -547,18 +703,20 @@ func (f *EndpointFactory) ParseEndpointDescription(endpoint string, isOutput boo
 
 // ParseUrlEndpointDescription parses the endpoint string as a URL endpoint description.
 // It has the form:
-//   format+transport://target
+//   format+transport://target?parameters
 //
 // One of the format and transport parts must be specified, optionally both.
 // If one of format or transport is missing, it will be guessed.
 // The order does not matter. The 'target' part must not be empty.
+// The optional query parameters ParallelParsersParam and BufferedSamplesParam are moved from the target
+// to the Params field, except for custom transport types. Other query parameters remain in the target.
 func (f *EndpointFactory) ParseUrlEndpointDescription(endpoint string) (res EndpointDescription, err error) {
 	urlParts := strings.SplitN(endpoint, "://", 2)
 	if len(urlParts) != 2 || urlParts[0] == "" || urlParts[1] == "" {
 		err = fmt.Errorf("Invalid URL endpoint: %v", endpoint)
 		return
 	}
-	target := urlParts[1]
+	target, params := splitEndpointParams(urlParts[1])
 	res.Target = target
 	for _, part := range strings.Split(urlParts[0], "+") {
 		// TODO unclean: this parsing method is used for both marshalling/unmarshalling endpoints
@@ -574,7 +732,7 @@ func (f *EndpointFactory) ParseUrlEndpointDescription(endpoint string) (res Endp
 				return
 			}
 			switch EndpointType(part) {
-			case TcpEndpoint, TcpListenEndpoint, FileEndpoint, HttpEndpoint:
+			case TcpEndpoint, TcpListenEndpoint, FileEndpoint, HttpEndpoint, TailEndpoint:
 				res.Type = EndpointType(part)
 			case StdEndpoint:
 				if target != stdTransportTarget {
@@ -598,12 +756,47 @@ func (f *EndpointFactory) ParseUrlEndpointDescription(endpoint string) (res Endp
 	if res.IsCustomType && res.Format != UndefinedFormat {
 		err = fmt.Errorf("Cannot define the data format for transport '%v'", res.Type)
 	}
+	if res.IsCustomType {
+		res.Target = urlParts[1]
+	} else if len(params) > 0 && err == nil {
+		res.Params = params
+		_, err = res.ParallelHandler(ParallelSampleHandler{})
+	}
 	return
 }
 
+// splitEndpointParams removes the query parameters ParallelParsersParam and BufferedSamplesParam from the
+// given endpoint target. The target is returned unchanged, if it does not contain any of these parameters.
+func splitEndpointParams(target string) (string, map[string]string) {
+	index := strings.LastIndex(target, "?")
+	if index < 0 {
+		return target, nil
+	}
+	query, err := url.ParseQuery(target[index+1:])
+	if err != nil {
+		return target, nil
+	}
+	params := make(map[string]string)
+	for _, name := range []string{ParallelParsersParam, BufferedSamplesParam} {
+		if values, ok := query[name]; ok {
+			params[name] = strings.Join(values, "")
+			delete(query, name)
+		}
+	}
+	if len(params) == 0 {
+		return target, nil
+	}
+	target = target[:index]
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target, params
+}
+
 func (f *EndpointFactory) isMarshallingFormat(formatName string) bool {
-	_, ok := f.Marshallers[MarshallingFormat(formatName)]
-	return ok
+	_, isMarshaller := f.Marshallers[MarshallingFormat(formatName)]
+	_, isUnmarshaller := f.Unmarshallers[MarshallingFormat(formatName)]
+	return isMarshaller || isUnmarshaller
 }
 
 // GuessEndpointDescription guesses the transport type and format of the given endpoint target.
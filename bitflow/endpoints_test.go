@@ -25,6 +25,9 @@ func (suite *PipelineTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*PipelineTestSuite) SetS(suite.TestingSuite) {
+}
+
 func TestPipelineTestSuite(t *testing.T) {
 	suite.Run(t, new(PipelineTestSuite))
 }
@@ -183,6 +186,34 @@ func (suite *PipelineTestSuite) TestUrlEndpointErrors() {
 	err("box+box://-", "Multiple transport")
 }
 
+func (suite *PipelineTestSuite) TestUrlEndpointParams() {
+	parse := func(endpoint string) EndpointDescription {
+		desc, err := DefaultEndpointFactory.ParseEndpointDescription(endpoint, false)
+		suite.NoError(err)
+		return desc
+	}
+	suite.Equal(EndpointDescription{Type: TcpEndpoint, Target: "host:123", Params: map[string]string{"parallel": "8"}},
+		parse("tcp://host:123?parallel=8"))
+	suite.Equal(EndpointDescription{Format: CsvFormat, Type: FileEndpoint, Target: "file.csv", Params: map[string]string{"parallel": "2", "buffer": "0"}},
+		parse("csv://file.csv?buffer=0&parallel=2"))
+	suite.Equal(EndpointDescription{Type: StdEndpoint, Target: "-", Params: map[string]string{"buffer": "5"}},
+		parse("std://-?buffer=5"))
+	suite.Equal(EndpointDescription{Type: HttpEndpoint, Target: "host:123/path?tag=x", Params: map[string]string{"parallel": "3"}},
+		parse("http://host:123/path?tag=x&parallel=3"))
+	suite.Equal(EndpointDescription{Type: FileEndpoint, Target: "file?x=y"}, parse("file://file?x=y"))
+	suite.Equal(EndpointDescription{Type: "custom", Target: "target?parallel=3", IsCustomType: true}, parse("custom://target?parallel=3"))
+
+	handler, err := parse("tcp://host:123?parallel=8").ParallelHandler(ParallelSampleHandler{ParallelParsers: 1, BufferedSamples: 10})
+	suite.NoError(err)
+	suite.Equal(ParallelSampleHandler{ParallelParsers: 8, BufferedSamples: 10}, handler)
+
+	for _, endpoint := range []string{"tcp://host:123?parallel=0", "tcp://host:123?parallel=x", "file://x?buffer=-1"} {
+		_, err := DefaultEndpointFactory.ParseEndpointDescription(endpoint, false)
+		suite.Error(err, endpoint)
+		suite.Contains(err.Error(), "Invalid value", endpoint)
+	}
+}
+
 func init() {
 	console_box_testMode = true
 }
@@ -315,6 +346,25 @@ func (suite *PipelineTestSuite) Test_custom_endpoint_errors() {
 	suite.Nil(sink)
 }
 
+func (suite *PipelineTestSuite) Test_input_params() {
+	factory := suite.make_factory()
+	source, err := factory.CreateInput("file://file1?parallel=7&buffer=3", "file://file2?buffer=3&parallel=7")
+	suite.NoError(err)
+	expected := ParallelSampleHandler{ParallelParsers: 7, BufferedSamples: 3}
+	suite.Equal(expected, source.(*FileSource).Reader.ParallelSampleHandler)
+	suite.Equal([]string{"file1", "file2"}, source.(*FileSource).FileNames)
+
+	_, err = factory.CreateInput("file://file1?parallel=7", "file://file2")
+	suite.Error(err)
+	suite.Contains(err.Error(), "same parallel marshalling parameters")
+
+	sink, err := factory.CreateOutput("tcp://host:123?parallel=2")
+	suite.NoError(err)
+	expected = parallel_handler
+	expected.ParallelParsers = 2
+	suite.Equal(expected, sink.(*TCPSink).Writer.ParallelSampleHandler)
+}
+
 func (suite *PipelineTestSuite) Test_input_multiple_listener() {
 	factory := suite.make_factory()
 	source, err := factory.CreateInput(":123", ":456")
@@ -483,3 +533,30 @@ func (suite *PipelineTestSuite) Test_custom_endpoints() {
 	suite.EqualError(err, "Error creating 'testendpoint' output: TEST-ERROR")
 	suite.Equal(res, nil)
 }
+
+func (suite *PipelineTestSuite) Test_custom_unmarshaller() {
+	factory := suite.make_factory()
+	testFormat := MarshallingFormat("testformat")
+	factory.Unmarshallers[testFormat] = func() Unmarshaller {
+		return new(CsvMarshaller)
+	}
+
+	res, err := factory.CreateInput("testformat+file://a.txt", "testformat+file://b.txt")
+	suite.NoError(err)
+	source, ok := res.(*FileSource)
+	suite.True(ok)
+	suite.Equal([]string{"a.txt", "b.txt"}, source.FileNames)
+	suite.Equal(new(CsvMarshaller), source.Reader.Unmarshaller)
+
+	res, err = factory.CreateInput("testformat+file://a.txt", "csv+file://b.txt")
+	suite.EqualError(err, "All inputs must have the same format (Provided 'testformat' and 'csv')")
+	suite.Nil(res)
+
+	res, err = factory.CreateInput("text+file://a.txt")
+	suite.EqualError(err, "Format 'text' cannot be specified for data input: text+file://a.txt")
+	suite.Nil(res)
+
+	// The format can only be used for input
+	_, err = factory.CreateOutput("testformat+file://a.txt")
+	suite.EqualError(err, "Unknown marshaller format: testformat")
+}
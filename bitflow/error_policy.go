@@ -0,0 +1,348 @@
+package bitflow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrorPolicyType defines how an ErrorPolicyProcessor reacts to errors of the wrapped step.
+type ErrorPolicyType string
+
+const (
+	// ErrorPolicyAbort forwards the error to the previous step, which usually stops the pipeline.
+	ErrorPolicyAbort = ErrorPolicyType("abort")
+
+	// ErrorPolicyDrop logs the error and drops the sample that caused it.
+	ErrorPolicyDrop = ErrorPolicyType("drop")
+
+	// ErrorPolicyRetry processes the sample again, up to a number of times.
+	ErrorPolicyRetry = ErrorPolicyType("retry")
+
+	// ErrorPolicyDeadLetter logs the error and forwards the sample that caused it to a separate data sink.
+	ErrorPolicyDeadLetter = ErrorPolicyType("dead-letter")
+
+	// ErrorPolicyHint is the name of the scheduling hint used to configure an ErrorPolicy for a step in a bitflow script.
+	ErrorPolicyHint = "on_error"
+)
+
+// ErrorPolicy is the parsed form of a policy description like "abort", "drop", "retry:3" or "dead-letter:errors.csv".
+type ErrorPolicy struct {
+	Type ErrorPolicyType
+
+	// Retries is the number of retries for ErrorPolicyRetry.
+	Retries int
+
+	// DeadLetterOutput is the output endpoint description for ErrorPolicyDeadLetter.
+	DeadLetterOutput string
+}
+
+// ParseErrorPolicy parses a textual error policy description.
+func ParseErrorPolicy(policy string) (res ErrorPolicy, err error) {
+	parts := strings.SplitN(policy, ":", 2)
+	res.Type = ErrorPolicyType(parts[0])
+	hasParam := len(parts) == 2
+	switch res.Type {
+	case ErrorPolicyAbort, ErrorPolicyDrop:
+		if hasParam {
+			err = fmt.Errorf("Error policy '%v' does not accept a parameter", res.Type)
+		}
+	case ErrorPolicyRetry:
+		if !hasParam {
+			err = fmt.Errorf("Error policy '%v' requires the number of retries, e.g. %v:3", res.Type, res.Type)
+		} else if res.Retries, err = strconv.Atoi(parts[1]); err == nil && res.Retries < 1 {
+			err = fmt.Errorf("Number of retries must be positive: %v", res.Retries)
+		}
+	case ErrorPolicyDeadLetter:
+		if !hasParam || parts[1] == "" {
+			err = fmt.Errorf("Error policy '%v' requires an output endpoint, e.g. %v:errors.csv", res.Type, res.Type)
+		} else {
+			res.DeadLetterOutput = parts[1]
+		}
+	default:
+		err = fmt.Errorf("Unknown error policy '%v', must be one of %v, %v, %v:<num>, %v:<output>",
+			res.Type, ErrorPolicyAbort, ErrorPolicyDrop, ErrorPolicyRetry, ErrorPolicyDeadLetter)
+	}
+	return
+}
+
+func (p ErrorPolicy) String() string {
+	switch p.Type {
+	case ErrorPolicyRetry:
+		return fmt.Sprintf("%v:%v", p.Type, p.Retries)
+	case ErrorPolicyDeadLetter:
+		return fmt.Sprintf("%v:%v", p.Type, p.DeadLetterOutput)
+	default:
+		return string(p.Type)
+	}
+}
+
+// DeadLetterOutput is a data sink for ErrorPolicyDeadLetter, which can be shared by multiple ErrorPolicyProcessors.
+// The sink is started by the first ErrorPolicyProcessor and closed by the last one.
+type DeadLetterOutput struct {
+	Sink SampleProcessor
+
+	lock    sync.Mutex
+	users   int
+	closed  bool
+	stopped golib.StopChan
+}
+
+func (o *DeadLetterOutput) start(wg *sync.WaitGroup) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.users == 0 {
+		o.Sink.SetSink(new(DroppingSampleProcessor))
+		o.stopped = o.Sink.Start(wg)
+	}
+	o.users++
+}
+
+// close closes the sink after the last user is closed and returns the error of the sink, if any
+func (o *DeadLetterOutput) close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.users--
+	if o.users > 0 {
+		return nil
+	}
+	o.closed = true
+	o.Sink.Close()
+	if o.stopped.IsNil() {
+		return nil
+	}
+	o.stopped.Wait()
+	return o.stopped.Err()
+}
+
+func (o *DeadLetterOutput) isClosed() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.closed
+}
+
+// Sample forwards the sample to the dead letter sink, synchronized with other users of the sink.
+func (o *DeadLetterOutput) Sample(sample *Sample, header *Header) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.Sink.Sample(sample, header)
+}
+
+// DeadLetterOutputs creates DeadLetterOutputs through an EndpointFactory and shares them, so that every output
+// endpoint is opened only once, even if it is used by multiple steps.
+type DeadLetterOutputs struct {
+	Endpoints *EndpointFactory
+
+	lock    sync.Mutex
+	outputs map[string]*DeadLetterOutput
+}
+
+// Get returns the DeadLetterOutput for the given output endpoint description and creates it, if necessary.
+// An output that was already closed by all its users is replaced by a new one.
+func (d *DeadLetterOutputs) Get(output string) (*DeadLetterOutput, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if res, ok := d.outputs[output]; ok && !res.isClosed() {
+		return res, nil
+	}
+	sink, err := d.Endpoints.CreateOutput(output)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create dead letter output: %v", err)
+	}
+	if d.outputs == nil {
+		d.outputs = make(map[string]*DeadLetterOutput)
+	}
+	res := &DeadLetterOutput{Sink: sink}
+	d.outputs[output] = res
+	return res, nil
+}
+
+// ErrorPolicyProcessor wraps a SampleProcessor and applies an ErrorPolicy to errors returned by the wrapped step.
+// Errors that originate from subsequent processing steps are not handled, but forwarded unchanged, so every step
+// only handles its own errors.
+// The StatefulProcessor and QueueingProcessor interfaces are forwarded to the wrapped step. If the wrapped step
+// does not implement them, the ErrorPolicyProcessor behaves like a step without state or queue.
+//
+// The sample is not copied: for ErrorPolicyRetry and ErrorPolicyDeadLetter, the wrapped step must not modify
+// a sample before returning an error for it, so that the retry or the dead letter output receives the original sample.
+type ErrorPolicyProcessor struct {
+	StepLogger
+	Step   SampleProcessor
+	Policy ErrorPolicy
+
+	// DeadLetter must be set for ErrorPolicyDeadLetter. It is started and closed
+	// together with the ErrorPolicyProcessor.
+	DeadLetter *DeadLetterOutput
+
+	out SampleProcessor
+}
+
+// NewErrorPolicyProcessor wraps the given step. For ErrorPolicyDeadLetter, the dead letter output is obtained from
+// the given DeadLetterOutputs. If the step implements StringerContainer, the result implements it as well.
+func NewErrorPolicyProcessor(step SampleProcessor, policy ErrorPolicy, deadLetters *DeadLetterOutputs) (SampleProcessor, error) {
+	res := &ErrorPolicyProcessor{Step: step, Policy: policy}
+	if policy.Type == ErrorPolicyDeadLetter {
+		output, err := deadLetters.Get(policy.DeadLetterOutput)
+		if err != nil {
+			return nil, err
+		}
+		res.DeadLetter = output
+	}
+	if _, ok := step.(StringerContainer); ok {
+		return &containerErrorPolicyProcessor{res}, nil
+	}
+	return res, nil
+}
+
+// containerErrorPolicyProcessor forwards the StringerContainer interface of the wrapped step
+type containerErrorPolicyProcessor struct {
+	*ErrorPolicyProcessor
+}
+
+func (p *containerErrorPolicyProcessor) ContainedStringers() []fmt.Stringer {
+	return p.Step.(StringerContainer).ContainedStringers()
+}
+
+// downstreamError marks errors returned by processing steps following the wrapped step.
+type downstreamError struct {
+	error
+}
+
+type downstreamMarker struct {
+	SampleProcessor
+}
+
+func (m *downstreamMarker) Sample(sample *Sample, header *Header) error {
+	if err := m.SampleProcessor.Sample(sample, header); err != nil {
+		if _, ok := err.(downstreamError); !ok {
+			err = downstreamError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// SetSink implements the SampleSource interface.
+func (p *ErrorPolicyProcessor) SetSink(sink SampleProcessor) {
+	p.out = sink
+	p.Step.SetSink(&downstreamMarker{sink})
+}
+
+// GetSink implements the SampleSource interface.
+func (p *ErrorPolicyProcessor) GetSink() SampleProcessor {
+	return p.out
+}
+
+// Start implements the SampleProcessor interface.
+func (p *ErrorPolicyProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
+	if p.DeadLetter != nil {
+		p.DeadLetter.start(wg)
+	}
+	return p.Step.Start(wg)
+}
+
+// Close implements the SampleProcessor interface.
+func (p *ErrorPolicyProcessor) Close() {
+	p.Step.Close()
+	if p.DeadLetter != nil {
+		if err := p.DeadLetter.close(); err != nil {
+			p.Log().Errorln("Dead letter output failed:", err)
+		}
+	}
+}
+
+// Sample implements the SampleProcessor interface.
+func (p *ErrorPolicyProcessor) Sample(sample *Sample, header *Header) error {
+	err := p.Step.Sample(sample, header)
+	for attempt := 0; err != nil && p.Policy.Type == ErrorPolicyRetry && attempt < p.Policy.Retries; attempt++ {
+		if _, downstream := err.(downstreamError); downstream {
+			break
+		}
+		p.Log().Warnf("Retrying sample (attempt %v of %v) after error: %v", attempt+1, p.Policy.Retries, err)
+		err = p.Step.Sample(sample, header)
+	}
+	if err == nil {
+		return nil
+	}
+	if downstream, ok := err.(downstreamError); ok {
+		return downstream.error
+	}
+
+	switch p.Policy.Type {
+	case ErrorPolicyDrop:
+		p.Log().Errorln("Dropping sample after error:", err)
+		return nil
+	case ErrorPolicyDeadLetter:
+		p.Log().Errorln("Forwarding sample to dead letter output after error:", err)
+		if deadErr := p.DeadLetter.Sample(sample, header); deadErr != nil {
+			return fmt.Errorf("Failed to forward sample to dead letter output (%v) after error: %v", deadErr, err)
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// InitLogger implements the LoggingProcessor interface and initializes the logger of the wrapped step as well.
+func (p *ErrorPolicyProcessor) InitLogger(ctx LogContext) {
+	p.StepLogger.InitLogger(ctx)
+	ctx.Step = p.Step.String()
+	initStepLogger(p.Step, ctx)
+}
+
+// SetLogLevel implements the LoggingProcessor interface and sets the log level of the wrapped step as well.
+func (p *ErrorPolicyProcessor) SetLogLevel(level log.Level) {
+	p.StepLogger.SetLogLevel(level)
+	if logging, ok := p.Step.(LoggingProcessor); ok {
+		logging.SetLogLevel(level)
+	}
+}
+
+// OutputSampleSize implements the ResizingSampleProcessor interface, if the wrapped step implements it.
+func (p *ErrorPolicyProcessor) OutputSampleSize(sampleSize int) int {
+	if resizing, ok := p.Step.(ResizingSampleProcessor); ok {
+		return resizing.OutputSampleSize(sampleSize)
+	}
+	return sampleSize
+}
+
+// StoreState implements the StatefulProcessor interface by storing the state of the wrapped step, if it has any.
+func (p *ErrorPolicyProcessor) StoreState() ([]byte, error) {
+	if stateful, ok := p.Step.(StatefulProcessor); ok {
+		return stateful.StoreState()
+	}
+	return nil, nil
+}
+
+// RestoreState implements the StatefulProcessor interface by restoring the state of the wrapped step.
+func (p *ErrorPolicyProcessor) RestoreState(state []byte) error {
+	if stateful, ok := p.Step.(StatefulProcessor); ok {
+		return stateful.RestoreState(state)
+	}
+	return fmt.Errorf("%v does not support restoring a state", p.Step)
+}
+
+// FlushCheckpoint implements the CheckpointFlusher interface by flushing the wrapped step, if it buffers samples.
+func (p *ErrorPolicyProcessor) FlushCheckpoint() error {
+	if flusher, ok := p.Step.(CheckpointFlusher); ok {
+		return flusher.FlushCheckpoint()
+	}
+	return nil
+}
+
+// QueueLength implements the QueueingProcessor interface. The result is -1, if the wrapped step has no queue.
+func (p *ErrorPolicyProcessor) QueueLength() int {
+	if queue, ok := p.Step.(QueueingProcessor); ok {
+		return queue.QueueLength()
+	}
+	return -1
+}
+
+// String implements the SampleProcessor interface.
+func (p *ErrorPolicyProcessor) String() string {
+	return fmt.Sprintf("%v [%v=%v]", p.Step, ErrorPolicyHint, p.Policy)
+}
@@ -0,0 +1,205 @@
+package bitflow
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/antongulenko/golib"
+	"github.com/stretchr/testify/suite"
+)
+
+type ErrorPolicyTestSuite struct {
+	testSuiteBase
+}
+
+func TestErrorPolicy(t *testing.T) {
+	suite.Run(t, new(ErrorPolicyTestSuite))
+}
+
+// failingTestStep fails for the first samples and modifies the successfully processed samples in place
+type failingTestStep struct {
+	NoopProcessor
+	failures int
+	received []*Sample
+}
+
+func (s *failingTestStep) Sample(sample *Sample, header *Header) error {
+	s.received = append(s.received, sample)
+	if len(s.received) <= s.failures {
+		return fmt.Errorf("failure %v", len(s.received))
+	}
+	sample.Values[0]++
+	return s.NoopProcessor.Sample(sample, header)
+}
+
+// deadLetterTestSink records received samples and the calls to Start and Close
+type deadLetterTestSink struct {
+	NoopProcessor
+	starts  int
+	closes  int
+	samples []*Sample
+}
+
+func (s *deadLetterTestSink) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.starts++
+	return s.NoopProcessor.Start(wg)
+}
+
+func (s *deadLetterTestSink) Sample(sample *Sample, _ *Header) error {
+	s.samples = append(s.samples, sample)
+	return nil
+}
+
+func (s *deadLetterTestSink) Close() {
+	s.closes++
+	s.NoopProcessor.Close()
+}
+
+// containerTestStep contains other steps, like a BatchProcessor
+type containerTestStep struct {
+	NoopProcessor
+}
+
+func (s *containerTestStep) ContainedStringers() []fmt.Stringer {
+	return []fmt.Stringer{String("inner")}
+}
+
+var errorPolicyTestHeader = &Header{Fields: []string{"a"}}
+
+func (suite *ErrorPolicyTestSuite) wrap(step SampleProcessor, policy string, deadLetters *DeadLetterOutputs) *ErrorPolicyProcessor {
+	parsed, err := ParseErrorPolicy(policy)
+	suite.NoError(err)
+	wrapped, err := NewErrorPolicyProcessor(step, parsed, deadLetters)
+	suite.NoError(err)
+	wrapped.SetSink(new(DroppingSampleProcessor))
+	res, ok := wrapped.(*ErrorPolicyProcessor)
+	suite.True(ok)
+	return res
+}
+
+func (suite *ErrorPolicyTestSuite) TestParse() {
+	for _, policy := range []string{"abort", "drop", "retry:3", "dead-letter:errors.csv"} {
+		parsed, err := ParseErrorPolicy(policy)
+		suite.NoError(err)
+		suite.Equal(policy, parsed.String())
+	}
+	for _, policy := range []string{"abort:1", "retry", "retry:0", "retry:x", "dead-letter", "dead-letter:", "ignore"} {
+		_, err := ParseErrorPolicy(policy)
+		suite.Error(err, policy)
+	}
+}
+
+func (suite *ErrorPolicyTestSuite) TestAbortAndDrop() {
+	step := &failingTestStep{failures: 1}
+	sample := &Sample{Values: []Value{1}}
+	suite.EqualError(suite.wrap(step, "abort", nil).Sample(sample, errorPolicyTestHeader), "failure 1")
+	suite.True(step.received[0] == sample, "The sample must not be copied for the abort policy")
+
+	step = &failingTestStep{failures: 1}
+	suite.NoError(suite.wrap(step, "drop", nil).Sample(sample, errorPolicyTestHeader))
+	suite.True(step.received[0] == sample, "The sample must not be copied for the drop policy")
+}
+
+func (suite *ErrorPolicyTestSuite) TestRetry() {
+	step := &failingTestStep{failures: 2}
+	policy := suite.wrap(step, "retry:2", nil)
+	sample := &Sample{Values: []Value{1}}
+	suite.NoError(policy.Sample(sample, errorPolicyTestHeader))
+	suite.Len(step.received, 3)
+	for _, received := range step.received {
+		suite.True(received == sample, "The sample must not be copied for retries")
+	}
+	suite.Equal([]Value{2}, sample.Values)
+
+	step = &failingTestStep{failures: 3}
+	suite.EqualError(suite.wrap(step, "retry:2", nil).Sample(&Sample{Values: []Value{1}}, errorPolicyTestHeader), "failure 3")
+}
+
+func (suite *ErrorPolicyTestSuite) TestDownstreamErrorsNotHandled() {
+	step := new(failingTestStep)
+	policy := suite.wrap(step, "retry:3", nil)
+	policy.SetSink(&failingTestStep{failures: 1})
+	suite.EqualError(policy.Sample(&Sample{Values: []Value{1}}, errorPolicyTestHeader), "failure 1")
+	suite.Len(step.received, 1, "Errors of subsequent steps must not be retried")
+}
+
+func (suite *ErrorPolicyTestSuite) TestSharedDeadLetterOutput() {
+	var sinks []*deadLetterTestSink
+	endpoints := NewEndpointFactory()
+	endpoints.CustomDataSinks = map[EndpointType]func(string) (SampleProcessor, error){
+		"test": func(string) (SampleProcessor, error) {
+			sink := new(deadLetterTestSink)
+			sinks = append(sinks, sink)
+			return sink, nil
+		},
+	}
+	deadLetters := &DeadLetterOutputs{Endpoints: endpoints}
+	first := suite.wrap(&failingTestStep{failures: 1}, "dead-letter:test://errors", deadLetters)
+	second := suite.wrap(&failingTestStep{failures: 1}, "dead-letter:test://errors", deadLetters)
+	other := suite.wrap(&failingTestStep{failures: 1}, "dead-letter:test://other", deadLetters)
+	suite.Len(sinks, 2, "Every dead letter output must be created once")
+	suite.True(first.DeadLetter == second.DeadLetter)
+
+	var wg sync.WaitGroup
+	for _, policy := range []*ErrorPolicyProcessor{first, second, other} {
+		policy.Start(&wg)
+	}
+	suite.Equal(1, sinks[0].starts)
+	sample := &Sample{Values: []Value{1}}
+	suite.NoError(first.Sample(sample, errorPolicyTestHeader))
+	suite.NoError(second.Sample(&Sample{Values: []Value{5}}, errorPolicyTestHeader))
+	suite.Len(sinks[0].samples, 2)
+	suite.True(sinks[0].samples[0] == sample, "The dead letter output must receive the failed sample")
+	suite.Equal([]Value{1}, sample.Values)
+
+	first.Close()
+	suite.Equal(0, sinks[0].closes, "The shared output must stay open while it is used")
+	second.Close()
+	other.Close()
+	suite.Equal(1, sinks[0].closes)
+	suite.Equal(1, sinks[1].closes)
+
+	output, err := deadLetters.Get("test://errors")
+	suite.NoError(err)
+	suite.Len(sinks, 3, "A closed output must be replaced by a new one")
+	suite.True(output.Sink == sinks[2])
+}
+
+func (suite *ErrorPolicyTestSuite) TestForwardInterfaces() {
+	stateful := &countingStatefulProcessor{count: 4}
+	policy := suite.wrap(stateful, "drop", nil)
+	state, err := policy.StoreState()
+	suite.NoError(err)
+	suite.Equal("4", string(state))
+	suite.NoError(policy.RestoreState([]byte("10")))
+	suite.Equal(int64(10), stateful.count)
+
+	plain := suite.wrap(new(NoopProcessor), "drop", nil)
+	state, err = plain.StoreState()
+	suite.NoError(err)
+	suite.Nil(state, "A step without state must not store anything")
+	suite.Error(plain.RestoreState([]byte("10")))
+	suite.Equal(-1, plain.QueueLength())
+
+	queue := &queueingTestProcessor{queue: 3}
+	suite.Equal(3, suite.wrap(queue, "drop", nil).QueueLength())
+
+	parsed, err := ParseErrorPolicy("drop")
+	suite.NoError(err)
+	container, err := NewErrorPolicyProcessor(new(containerTestStep), parsed, nil)
+	suite.NoError(err)
+	suite.Equal([]fmt.Stringer{String("inner")}, container.(StringerContainer).ContainedStringers())
+	_, isContainer := interface{}(plain).(StringerContainer)
+	suite.False(isContainer, "Only wrapped containers must implement StringerContainer")
+	_, isStateful := container.(StatefulProcessor)
+	suite.True(isStateful)
+}
+
+func (suite *ErrorPolicyTestSuite) TestInvalidDeadLetterOutput() {
+	deadLetters := &DeadLetterOutputs{Endpoints: NewEndpointFactory()}
+	parsed, err := ParseErrorPolicy("dead-letter:invalid://x")
+	suite.NoError(err)
+	_, err = NewErrorPolicyProcessor(new(NoopProcessor), parsed, deadLetters)
+	suite.Error(err)
+}
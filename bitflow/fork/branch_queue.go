@@ -0,0 +1,368 @@
+package fork
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
+)
+
+// SaturationPolicy defines what happens with samples for a subpipeline, whose queue is full.
+type SaturationPolicy string
+
+const (
+	// SaturationBlock blocks the fork until the queue has room, which slows down all other subpipelines as well.
+	SaturationBlock = SaturationPolicy("block")
+
+	// SaturationDrop drops the samples and logs the number of dropped samples.
+	SaturationDrop = SaturationPolicy("drop")
+
+	// SaturationSpill writes the samples to a temporary file, from which they are read after the queue was drained.
+	// The order of the samples is preserved.
+	SaturationSpill = SaturationPolicy("spill")
+)
+
+// ParseSaturationPolicy returns the SaturationPolicy with the given name.
+func ParseSaturationPolicy(name string) (SaturationPolicy, error) {
+	switch policy := SaturationPolicy(name); policy {
+	case SaturationBlock, SaturationDrop, SaturationSpill:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("Unknown saturation policy '%v', expected '%v', '%v' or '%v'", name, SaturationBlock, SaturationDrop, SaturationSpill)
+	}
+}
+
+var _ bitflow.QueueingProcessor = new(BranchQueue)
+
+// BranchQueue is inserted by the SampleFork at the start of every subpipeline, if the SampleFork.BranchQueue field
+// is set. It hands the samples over to a separate goroutine through a bounded queue, so that the subpipelines run
+// concurrently. The Saturated policy defines what happens when the queue is full.
+type BranchQueue struct {
+	bitflow.NoopProcessor
+	Size      int
+	Saturated SaturationPolicy
+	SpillDir  string // Directory for the spill file, default: os.TempDir()
+
+	queue    chan bitflow.SampleAndHeader
+	loopTask *golib.LoopTask
+	dropped  uint64
+
+	// Access to the spill file is synchronized, because it is written and read by different goroutines
+	spillLock sync.Mutex
+	spill     *spillFile
+	spilled   chan struct{}
+
+	// pending counts the queued and spilled samples that were not forwarded yet. It is protected by pendingCond.L
+	pending     int
+	finished    bool
+	pendingCond *sync.Cond
+}
+
+func (q *BranchQueue) String() string {
+	policy := q.Saturated
+	if policy == "" {
+		policy = SaturationBlock
+	}
+	return fmt.Sprintf("Branch queue (size %v, %v when full)", q.Size, policy)
+}
+
+func (q *BranchQueue) Start(wg *sync.WaitGroup) golib.StopChan {
+	q.queue = make(chan bitflow.SampleAndHeader, q.Size)
+	q.spilled = make(chan struct{}, 1)
+	q.pendingCond = sync.NewCond(new(sync.Mutex))
+	q.loopTask = &golib.LoopTask{
+		Description: q.String(),
+		StopHook:    q.stopped,
+		Loop:        q.loop,
+	}
+	return q.loopTask.Start(wg)
+}
+
+func (q *BranchQueue) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	item := bitflow.SampleAndHeader{Sample: sample, Header: header}
+	// The sample is counted before it is queued, because it can be forwarded before the channel send returns
+	q.addPending(1)
+	switch q.Saturated {
+	case SaturationDrop:
+		select {
+		case q.queue <- item:
+		default:
+			q.addPending(-1)
+			if atomic.AddUint64(&q.dropped, 1) == 1 {
+				q.Log().Warnln("Queue is full, dropping samples")
+			}
+		}
+	case SaturationSpill:
+		q.spillLock.Lock()
+		defer q.spillLock.Unlock()
+		if q.spill == nil || q.spill.pending == 0 {
+			// As long as spilled samples are pending, new samples must be spilled as well to preserve the order
+			select {
+			case q.queue <- item:
+				return nil
+			default:
+			}
+		}
+		err := q.spillSample(item)
+		if err != nil {
+			q.addPending(-1)
+		}
+		return err
+	default:
+		q.queue <- item
+	}
+	return nil
+}
+
+func (q *BranchQueue) addPending(delta int) {
+	q.pendingCond.L.Lock()
+	defer q.pendingCond.L.Unlock()
+	q.pending += delta
+	if q.pending == 0 {
+		q.pendingCond.Broadcast()
+	}
+}
+
+// FlushCheckpoint implements the bitflow.CheckpointFlusher interface by waiting until all queued and spilled samples
+// were forwarded to the subsequent step. An error is returned, if the queue stopped before forwarding all samples.
+func (q *BranchQueue) FlushCheckpoint() error {
+	q.pendingCond.L.Lock()
+	defer q.pendingCond.L.Unlock()
+	for q.pending > 0 && !q.finished {
+		q.pendingCond.Wait()
+	}
+	if q.pending > 0 {
+		return fmt.Errorf("%v stopped with %v pending sample(s)", q, q.pending)
+	}
+	return nil
+}
+
+// IsReadOnly implements the bitflow.ReadOnlyProcessor interface. Spilled samples are new instances, but the original
+// samples are not modified.
+func (q *BranchQueue) IsReadOnly() bool {
+	return true
+}
+
+// QueueLength implements the bitflow.QueueingProcessor interface. Spilled samples are included.
+func (q *BranchQueue) QueueLength() int {
+	res := len(q.queue)
+	q.spillLock.Lock()
+	if q.spill != nil {
+		res += q.spill.pending
+	}
+	q.spillLock.Unlock()
+	return res
+}
+
+func (q *BranchQueue) Close() {
+	close(q.queue)
+}
+
+// loop does not wait for the StopChan, because the loop task is only stopped after the queue was closed and drained
+func (q *BranchQueue) loop(_ golib.StopChan) error {
+	select {
+	case item, open := <-q.queue:
+		if !open {
+			// The queue is drained, forward the remaining spilled samples
+			for {
+				item, ok, err := q.unspillSample()
+				if err != nil || !ok {
+					q.loopTask.Stop()
+					return err
+				}
+				if err := q.forward(item); err != nil {
+					return err
+				}
+			}
+		}
+		return q.forward(item)
+	default:
+	}
+
+	// The queue is empty, continue with spilled samples or wait for new samples
+	item, ok, err := q.unspillSample()
+	if err != nil {
+		return err
+	} else if ok {
+		return q.forward(item)
+	}
+	select {
+	case item, open := <-q.queue:
+		if !open {
+			return nil // Handled in the next iteration
+		}
+		return q.forward(item)
+	case <-q.spilled:
+	}
+	return nil
+}
+
+func (q *BranchQueue) forward(item bitflow.SampleAndHeader) error {
+	if err := q.NoopProcessor.Sample(item.Sample, item.Header); err != nil {
+		// The sample stays pending, so that no checkpoint includes it
+		return fmt.Errorf("Error forwarding sample from %v to %v: %v", q, q.GetSink(), err)
+	}
+	q.addPending(-1)
+	return nil
+}
+
+func (q *BranchQueue) stopped() {
+	q.pendingCond.L.Lock()
+	q.finished = true
+	q.pendingCond.Broadcast()
+	q.pendingCond.L.Unlock()
+	if dropped := atomic.LoadUint64(&q.dropped); dropped > 0 {
+		q.Log().Warnf("Dropped %v sample(s)", dropped)
+	}
+	q.spillLock.Lock()
+	if q.spill != nil {
+		q.spill.remove()
+		q.spill = nil
+	}
+	q.spillLock.Unlock()
+	q.CloseSink()
+}
+
+// spillSample must be called while holding the spillLock
+func (q *BranchQueue) spillSample(item bitflow.SampleAndHeader) error {
+	if q.spill == nil {
+		spill, err := newSpillFile(q.SpillDir)
+		if err != nil {
+			return fmt.Errorf("%v: Failed to create spill file: %v", q, err)
+		}
+		q.Log().Debugln("Spilling samples to", spill.file.Name())
+		q.spill = spill
+	}
+	if err := q.spill.write(item); err != nil {
+		return fmt.Errorf("%v: Failed to spill sample: %v", q, err)
+	}
+	select {
+	case q.spilled <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *BranchQueue) unspillSample() (bitflow.SampleAndHeader, bool, error) {
+	q.spillLock.Lock()
+	defer q.spillLock.Unlock()
+	if q.spill == nil || q.spill.pending == 0 {
+		return bitflow.SampleAndHeader{}, false, nil
+	}
+	item, err := q.spill.read()
+	if err != nil {
+		err = fmt.Errorf("%v: Failed to read spilled sample: %v", q, err)
+	}
+	return item, err == nil, err
+}
+
+// spillFile stores samples in a temporary file in the binary format. The original Header instances are kept in
+// memory, so that the unspilled samples refer to the same headers as before.
+type spillFile struct {
+	file       *os.File
+	writer     *bufio.Writer
+	reader     *bufio.Reader
+	marshaller bitflow.BinaryMarshaller
+
+	pending     int
+	writeHeader *bitflow.Header
+	headers     []*bitflow.Header // Headers that were written, but not yet read
+	readHeader  *bitflow.UnmarshalledHeader
+	original    *bitflow.Header // The original instance of readHeader
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	file, err := ioutil.TempFile(dir, "bitflow-spill-")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		reader: bufio.NewReader(io.NewSectionReader(file, 0, 1<<62)),
+	}, nil
+}
+
+func (s *spillFile) write(item bitflow.SampleAndHeader) error {
+	if len(item.Sample.Values) != len(item.Header.Fields) {
+		return fmt.Errorf("Sample has %v values, but header has %v fields", len(item.Sample.Values), len(item.Header.Fields))
+	}
+	if item.Header != s.writeHeader {
+		if err := s.marshaller.WriteHeader(item.Header, true, s.writer); err != nil {
+			return err
+		}
+		s.writeHeader = item.Header
+		s.headers = append(s.headers, item.Header)
+	}
+	if err := s.marshaller.WriteSample(item.Sample, item.Header, true, s.writer); err != nil {
+		return err
+	}
+	// Make the sample visible for the reader
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+func (s *spillFile) read() (bitflow.SampleAndHeader, error) {
+	for {
+		header, data, err := s.marshaller.Read(s.reader, s.readHeader)
+		if header != nil {
+			// Headers are read in the same order as they were written
+			s.readHeader = header
+			s.original, s.headers = s.headers[0], s.headers[1:]
+			continue
+		}
+		if data == nil {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return bitflow.SampleAndHeader{}, err
+		}
+		sample, err := s.marshaller.ParseSample(s.readHeader, 0, data)
+		s.marshaller.ReleaseData(data)
+		if err != nil {
+			return bitflow.SampleAndHeader{}, err
+		}
+		item := bitflow.SampleAndHeader{Sample: sample, Header: s.original}
+		s.pending--
+		if s.pending == 0 {
+			err = s.reset()
+		}
+		return item, err
+	}
+}
+
+// reset truncates the file after all spilled samples were read, to free the disk space
+func (s *spillFile) reset() error {
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.writer.Reset(s.file)
+	s.reader.Reset(io.NewSectionReader(s.file, 0, 1<<62))
+	s.writeHeader = nil
+	s.headers = nil
+	s.readHeader = nil
+	s.original = nil
+	return nil
+}
+
+func (s *spillFile) remove() {
+	if err := s.file.Close(); err != nil {
+		log.Warnf("Failed to close spill file %v: %v", s.file.Name(), err)
+	}
+	if err := os.Remove(s.file.Name()); err != nil {
+		log.Warnf("Failed to remove spill file %v: %v", s.file.Name(), err)
+	}
+}
@@ -0,0 +1,147 @@
+package fork
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type branchQueueTestSuite struct {
+	suite.Suite
+}
+
+func TestBranchQueue(t *testing.T) {
+	suite.Run(t, new(branchQueueTestSuite))
+}
+
+// gatedSink blocks every sample until the gate is opened, and signals every received sample on the entered channel
+type gatedSink struct {
+	bitflow.DroppingSampleProcessor
+	gate    chan struct{}
+	entered chan struct{}
+	values  []bitflow.Value
+	headers []*bitflow.Header
+	tags    []string
+}
+
+func newGatedSink() *gatedSink {
+	return &gatedSink{gate: make(chan struct{}), entered: make(chan struct{}, 100)}
+}
+
+func (s *gatedSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.entered <- struct{}{}
+	<-s.gate
+	s.values = append(s.values, sample.Values[0])
+	s.headers = append(s.headers, header)
+	s.tags = append(s.tags, sample.Tag("x"))
+	return nil
+}
+
+func (s *branchQueueTestSuite) run(queue *BranchQueue, headers ...*bitflow.Header) *gatedSink {
+	sink := newGatedSink()
+	queue.SetSink(sink)
+	var wg sync.WaitGroup
+	queue.Start(&wg)
+
+	// The first sample blocks the queue goroutine, the following samples fill the queue
+	s.NoError(queue.Sample(&bitflow.Sample{Values: []bitflow.Value{0}}, headers[0]))
+	<-sink.entered
+	for i := 1; i < 10; i++ {
+		sample := &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i)}}
+		sample.SetTag("x", "y")
+		s.NoError(queue.Sample(sample, headers[i*len(headers)/10]))
+	}
+	close(sink.gate)
+	queue.Close()
+	wg.Wait()
+	return sink
+}
+
+func (s *branchQueueTestSuite) TestDrop() {
+	queue := &BranchQueue{Size: 3, Saturated: SaturationDrop}
+	sink := s.run(queue, &bitflow.Header{Fields: []string{"a"}})
+	s.Equal([]bitflow.Value{0, 1, 2, 3}, sink.values)
+	s.Equal(uint64(6), queue.dropped)
+	s.Equal("Branch queue (size 3, drop when full)", queue.String())
+}
+
+func (s *branchQueueTestSuite) TestSpill() {
+	dir, err := ioutil.TempDir("", "bitflow-spill-test")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	header1 := &bitflow.Header{Fields: []string{"a"}}
+	header2 := &bitflow.Header{Fields: []string{"b"}}
+	queue := &BranchQueue{Size: 2, Saturated: SaturationSpill, SpillDir: dir}
+	sink := s.run(queue, header1, header2)
+
+	s.Equal([]bitflow.Value{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, sink.values)
+	for i, header := range sink.headers {
+		expected := header1
+		if i >= 5 {
+			expected = header2
+		}
+		s.True(expected == header, "Sample %v has the wrong header instance: %v", i, header)
+	}
+	s.Equal("y", sink.tags[9], "Tags of spilled samples must be restored")
+	files, err := ioutil.ReadDir(dir)
+	s.NoError(err)
+	s.Empty(files, "The spill file must be removed")
+}
+
+func (s *branchQueueTestSuite) TestSpillReset() {
+	dir, err := ioutil.TempDir("", "bitflow-spill-test")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	spill, err := newSpillFile(dir)
+	s.Require().NoError(err)
+	defer spill.remove()
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 3; i++ {
+			s.NoError(spill.write(bitflow.SampleAndHeader{Sample: &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i)}}, Header: header}))
+		}
+		for i := 0; i < 3; i++ {
+			item, err := spill.read()
+			s.NoError(err)
+			s.Equal(bitflow.Value(i), item.Sample.Values[0])
+			s.True(header == item.Header)
+		}
+		info, err := spill.file.Stat()
+		s.NoError(err)
+		s.Equal(int64(0), info.Size(), "The file must be truncated after reading all samples")
+	}
+	s.Error(spill.write(bitflow.SampleAndHeader{Sample: new(bitflow.Sample), Header: header}))
+}
+
+func (s *branchQueueTestSuite) TestBlock() {
+	queue := &BranchQueue{Size: 20}
+	sink := s.run(queue, &bitflow.Header{Fields: []string{"a"}})
+	s.Len(sink.values, 10)
+	s.Equal("Branch queue (size 20, block when full)", queue.String())
+}
+
+func (s *branchQueueTestSuite) TestConcurrentFork() {
+	pipes := []*bitflow.SamplePipeline{new(bitflow.SamplePipeline), new(bitflow.SamplePipeline)}
+	f := &SampleFork{
+		Distributor: &MultiplexDistributor{PipelineArray{Subpipelines: pipes}},
+		BranchQueue: 5,
+	}
+	out := new(collectingSink)
+	f.SetSink(out)
+	var wg sync.WaitGroup
+	f.Start(&wg)
+	for i := 0; i < 100; i++ {
+		s.NoError(f.Sample(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"a"}}))
+	}
+	f.Close()
+	wg.Wait()
+	s.Len(out.tags, 200)
+	s.IsType(new(BranchQueue), pipes[0].Processors[0])
+	s.Equal("Fork multiplex (2) (concurrent, queue 5, block when full)", f.String())
+}
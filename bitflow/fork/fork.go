@@ -1,12 +1,13 @@
 package fork
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/bitflow-stream/go-bitflow/bitflow"
-	log "github.com/sirupsen/logrus"
 )
 
 type Subpipeline struct {
@@ -19,10 +20,23 @@ type Distributor interface {
 	String() string
 }
 
+// ExpiringDistributor is implemented by Distributors that build subpipelines on demand. If PipelineIdleTimeout()
+// returns a positive duration, the SampleFork stops subpipelines that did not receive any samples for that duration
+// (at the latest after 1.5 times the duration). ForgetPipeline() is called for every stopped subpipeline,
+// so that the Distributor builds a new one when a sample for the same key arrives.
+type ExpiringDistributor interface {
+	Distributor
+	PipelineIdleTimeout() time.Duration
+	ForgetPipeline(pipe *bitflow.SamplePipeline)
+}
+
 type subpipelineStart struct {
-	pipe      *bitflow.SamplePipeline
-	firstStep bitflow.SampleProcessor
-	key       string
+	pipe       *bitflow.SamplePipeline
+	firstStep  bitflow.SampleProcessor
+	key        string
+	lastSample time.Time
+	readOnly   bool
+	checkpoint *bitflow.PipelineCheckpoint
 }
 
 type SampleFork struct {
@@ -36,8 +50,22 @@ type SampleFork struct {
 	// Finished pipelines must be reported through LogFinishedPipeline()
 	NonfatalErrors bool
 
-	pipelines map[*bitflow.SamplePipeline]subpipelineStart
-	lock      sync.Mutex
+	// If BranchQueue is > 0, every subpipeline receives the samples through a BranchQueue of this size and processes
+	// them in a separate goroutine. Otherwise, the subpipelines process the samples sequentially.
+	BranchQueue int
+	Saturated   SaturationPolicy // What to do with samples for a subpipeline whose queue is full. Default: SaturationBlock
+	SpillDir    string           // Directory for the files of SaturationSpill, default: os.TempDir()
+
+	// Subpipelines that do not modify samples receive the original sample instead of a copy. Subpipelines consisting
+	// only of bitflow.ReadOnlyProcessor instances are detected automatically, ReadOnlyBranches declares all
+	// subpipelines as read-only.
+	ReadOnlyBranches bool
+
+	pipelines      map[*bitflow.SamplePipeline]*subpipelineStart
+	restored       map[string]map[string]json.RawMessage // States of subpipelines that were not started yet, by key
+	lock           sync.Mutex
+	lastExpiration time.Time
+	now            func() time.Time // Can be replaced for testing
 
 	ForkPath []string
 }
@@ -45,7 +73,7 @@ type SampleFork struct {
 func (f *SampleFork) Start(wg *sync.WaitGroup) golib.StopChan {
 	result := f.NoopProcessor.Start(wg)
 	f.MultiPipeline.Init(f.GetSink(), f.CloseSink, wg)
-	f.pipelines = make(map[*bitflow.SamplePipeline]subpipelineStart)
+	f.pipelines = make(map[*bitflow.SamplePipeline]*subpipelineStart)
 	return result
 }
 
@@ -54,6 +82,7 @@ func (f *SampleFork) Close() {
 }
 
 func (f *SampleFork) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	f.expireIdlePipelines()
 	subpipes, err := f.Distributor.Distribute(sample, header)
 	if err != nil {
 		return err
@@ -63,44 +92,159 @@ func (f *SampleFork) Sample(sample *bitflow.Sample, header *bitflow.Header) erro
 
 func (f *SampleFork) getSubpipelineSink(subpipes []Subpipeline) bitflow.SampleProcessor {
 	sinks := make([]bitflow.SampleProcessor, 0, len(subpipes))
+	readOnly := make([]bool, 0, len(subpipes))
 	for _, subpipe := range subpipes {
 		if subpipe.Pipe != nil {
-			sinks = append(sinks, f.getPipeline(subpipe))
+			pipe := f.getPipeline(subpipe)
+			sinks = append(sinks, pipe.firstStep)
+			readOnly = append(readOnly, pipe.readOnly)
 		}
 	}
-	return &sinkMultiplexer{sinks: sinks, fallbackSink: &f.merger}
+	return &sinkMultiplexer{sinks: sinks, readOnly: readOnly, fallbackSink: &f.merger}
 }
 
-func (f *SampleFork) getPipeline(subpipe Subpipeline) bitflow.SampleProcessor {
+func (f *SampleFork) getPipeline(subpipe Subpipeline) *subpipelineStart {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
 	pipe, ok := f.pipelines[subpipe.Pipe]
 	if !ok {
-		firstStep := f.initializePipeline(subpipe)
-		pipe = subpipelineStart{key: subpipe.Key, pipe: subpipe.Pipe, firstStep: firstStep}
+		pipe = f.initializePipeline(subpipe)
 		f.pipelines[subpipe.Pipe] = pipe
 	} else if subpipe.Key != pipe.key {
-		log.Debugf("[%v]: Subpipeline %v is reusing the pipeline started previously for key %v", f, subpipe.Key, pipe.key)
+		f.Log().Debugf("Subpipeline %v is reusing the pipeline started previously for key %v", subpipe.Key, pipe.key)
+	}
+	pipe.lastSample = f.time()
+	return pipe
+}
+
+func (f *SampleFork) time() time.Time {
+	if f.now != nil {
+		return f.now()
 	}
-	return pipe.firstStep
+	return time.Now()
 }
 
-func (f *SampleFork) initializePipeline(subpipe Subpipeline) bitflow.SampleProcessor {
+func (f *SampleFork) expireIdlePipelines() {
+	distributor, ok := f.Distributor.(ExpiringDistributor)
+	if !ok {
+		return
+	}
+	timeout := distributor.PipelineIdleTimeout()
+	if timeout <= 0 {
+		return
+	}
+	now := f.time()
+	if now.Sub(f.lastExpiration) < timeout/2 {
+		// Throttle the check, it iterates over all subpipelines
+		return
+	}
+	f.lastExpiration = now
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for pipe, start := range f.pipelines {
+		if now.Sub(start.lastSample) >= timeout {
+			f.Log().Debugf("Stopping subpipeline %v, it did not receive samples for %v", start.key, now.Sub(start.lastSample))
+			delete(f.pipelines, pipe)
+			distributor.ForgetPipeline(pipe)
+			f.StopPipeline(pipe)
+		}
+	}
+}
+
+func (f *SampleFork) initializePipeline(subpipe Subpipeline) *subpipelineStart {
 	pipe := subpipe.Pipe
 	path := f.setForkPaths(subpipe.Pipe, subpipe.Key)
-	log.Debugf("[%v]: Starting forked subpipeline %v", f, path)
+	pipe.Name = f.LogContext().Pipeline
+	pipe.ForkPath = path
+	f.Log().Debugf("Starting forked subpipeline %v", path)
 	if pipe.Source != nil {
 		// Forked pipelines should not have an explicit source, as they receive
 		// samples from the steps preceding them
-		log.Warnf("[%v]: The Source field of the %v subpipeline was set and will be ignored: %v", f, path, pipe.Source)
+		f.Log().Warnf("The Source field of the %v subpipeline was set and will be ignored: %v", path, pipe.Source)
 		pipe.Source = nil
 	}
-	pipe.Add(&f.merger)
+	readOnly := f.ReadOnlyBranches || isReadOnlyPipeline(pipe)
+	if f.BranchQueue > 0 {
+		queue := &BranchQueue{Size: f.BranchQueue, Saturated: f.Saturated, SpillDir: f.SpillDir}
+		pipe.Processors = append([]bitflow.SampleProcessor{queue}, pipe.Processors...)
+	}
+	if readOnly {
+		pipe.Add(&sharedSampleMerger{Merger: &f.merger, copySamples: !bitflow.IsReadOnlyChain(f.GetSink())})
+	} else {
+		pipe.Add(&f.merger)
+	}
+	checkpoint := bitflow.NewPipelineCheckpoint(pipe)
+	if state, ok := f.restored[subpipe.Key]; ok {
+		delete(f.restored, subpipe.Key)
+		if err := checkpoint.Restore(state); err != nil {
+			f.Log().Errorf("Failed to restore the state of subpipeline %v: %v", path, err)
+		}
+	}
 	f.StartPipeline(pipe, func(isPassive bool, err error) {
 		f.LogFinishedPipeline(isPassive, err, fmt.Sprintf("[%v]: Subpipeline %v", f, path))
 	})
-	return pipe.Processors[0]
+	if readOnly {
+		f.Log().Debugf("Subpipeline %v is read-only and receives samples without copying them", path)
+	}
+	return &subpipelineStart{key: subpipe.Key, pipe: pipe, firstStep: pipe.Processors[0], readOnly: readOnly, checkpoint: checkpoint}
+}
+
+// StoreState implements the bitflow.StatefulProcessor interface by storing the states of all subpipelines,
+// identified by their keys. States of subpipelines that were restored, but not started yet, are kept.
+func (f *SampleFork) StoreState() ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	states := make(map[string]map[string]json.RawMessage, len(f.restored)+len(f.pipelines))
+	for key, state := range f.restored {
+		states[key] = state
+	}
+	for _, pipe := range f.pipelines {
+		state, err := pipe.checkpoint.Store()
+		if err != nil {
+			return nil, fmt.Errorf("Subpipeline %v: %v", pipe.key, err)
+		}
+		if len(state) > 0 {
+			states[pipe.key] = state
+		}
+	}
+	if len(states) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(states)
+}
+
+// RestoreState implements the bitflow.StatefulProcessor interface. Subpipelines are restored when they are started.
+func (f *SampleFork) RestoreState(state []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return json.Unmarshal(state, &f.restored)
+}
+
+// FlushCheckpoint implements the bitflow.CheckpointFlusher interface by flushing all subpipelines.
+func (f *SampleFork) FlushCheckpoint() error {
+	f.lock.Lock()
+	pipes := make([]*subpipelineStart, 0, len(f.pipelines))
+	for _, pipe := range f.pipelines {
+		pipes = append(pipes, pipe)
+	}
+	f.lock.Unlock()
+	for _, pipe := range pipes {
+		if err := pipe.checkpoint.Flush(); err != nil {
+			return fmt.Errorf("Subpipeline %v: %v", pipe.key, err)
+		}
+	}
+	return nil
+}
+
+func isReadOnlyPipeline(pipe *bitflow.SamplePipeline) bool {
+	for _, proc := range pipe.Processors {
+		if !bitflow.IsReadOnly(proc) {
+			return false
+		}
+	}
+	return true
 }
 
 func (f *SampleFork) setForkPaths(pipeline *bitflow.SamplePipeline, key string) []string {
@@ -128,6 +272,13 @@ func (f *SampleFork) String() string {
 	if _, complexDistributor := f.Distributor.(bitflow.StringerContainer); complexDistributor {
 		res += f.Distributor.String()
 	}
+	if f.BranchQueue > 0 {
+		saturated := f.Saturated
+		if saturated == "" {
+			saturated = SaturationBlock
+		}
+		res += fmt.Sprintf(" (concurrent, queue %v, %v when full)", f.BranchQueue, saturated)
+	}
 	return res
 }
 
@@ -142,6 +293,7 @@ func (f *SampleFork) getAbstractFork() *SampleFork {
 type sinkMultiplexer struct {
 	bitflow.DroppingSampleProcessor
 	sinks        []bitflow.SampleProcessor
+	readOnly     []bool
 	fallbackSink bitflow.SampleProcessor
 }
 
@@ -154,11 +306,14 @@ func (s *sinkMultiplexer) Sample(sample *bitflow.Sample, header *bitflow.Header)
 	default:
 		// The samples are not forwarded in parallel. Parallelism between pipelines can be achieved by decoupling steps on each subpipeline.
 		var errors golib.MultiError
-		for _, sink := range s.sinks {
+		for i, sink := range s.sinks {
 			// The DeepClone() is necessary since the forks might change the sample
-			// values independently. In some cases it might not be necessary, but that
-			// would be a rather complex optimization.
-			errors.Add(sink.Sample(sample.DeepClone(), header))
+			// values independently. Read-only subpipelines can share the original sample.
+			forwarded := sample
+			if !s.readOnly[i] {
+				forwarded = sample.DeepClone()
+			}
+			errors.Add(sink.Sample(forwarded, header))
 		}
 		return errors.NilOrError()
 	}
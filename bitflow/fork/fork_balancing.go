@@ -0,0 +1,139 @@
+package fork
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+)
+
+const DefaultVirtualNodes = 100
+
+var (
+	_ Distributor = new(LeastLoadedDistributor)
+	_ Distributor = new(HashDistributor)
+)
+
+// PipelineLoad returns the number of samples that are currently queued inside the given pipeline. This is the sum
+// of the queue lengths of all steps that implement bitflow.QueueingProcessor, e.g. decoupling steps.
+func PipelineLoad(pipe *bitflow.SamplePipeline) int {
+	load := 0
+	for _, proc := range pipe.Processors {
+		if queue, ok := proc.(bitflow.QueueingProcessor); ok {
+			load += queue.QueueLength()
+		}
+	}
+	return load
+}
+
+// LeastLoadedDistributor forwards every sample to the subpipeline with the lowest PipelineLoad(). Ties are broken in
+// round-robin order, so subpipelines without any queueing steps simply receive the samples in turns.
+type LeastLoadedDistributor struct {
+	PipelineArray
+	next int
+}
+
+func (d *LeastLoadedDistributor) Distribute(_ *bitflow.Sample, _ *bitflow.Header) ([]Subpipeline, error) {
+	num := len(d.Subpipelines)
+	if num == 0 {
+		return nil, nil
+	}
+	best, bestLoad := -1, 0
+	for i := 0; i < num; i++ {
+		index := (d.next + i) % num
+		load := PipelineLoad(d.Subpipelines[index])
+		if best < 0 || load < bestLoad {
+			best, bestLoad = index, load
+			if load == 0 {
+				break
+			}
+		}
+	}
+	d.next = best + 1
+	return d.build()[best : best+1], nil
+}
+
+func (d *LeastLoadedDistributor) String() string {
+	return fmt.Sprintf("least loaded (%v pipelines)", len(d.Subpipelines))
+}
+
+func (d *LeastLoadedDistributor) ContainedStringers() []fmt.Stringer {
+	return d.pipelineStringers()
+}
+
+type ringNode struct {
+	hash uint64
+	pipe int
+}
+
+// HashDistributor resolves the TagTemplate for every sample and uses consistent hashing to map the result to one of
+// the subpipelines. Samples with the same tag values therefore always land in the same subpipeline. Every subpipeline
+// is placed on the hash ring VirtualNodes times, which balances the keys between the subpipelines and keeps most
+// assignments unchanged when subpipelines are added or removed.
+type HashDistributor struct {
+	PipelineArray
+	bitflow.TagTemplate
+	VirtualNodes int // Default: DefaultVirtualNodes
+
+	ring []ringNode
+}
+
+func (d *HashDistributor) Distribute(sample *bitflow.Sample, _ *bitflow.Header) ([]Subpipeline, error) {
+	if len(d.Subpipelines) == 0 {
+		return nil, nil
+	}
+	index := d.Pipeline(d.Resolve(sample))
+	return d.build()[index : index+1], nil
+}
+
+// Pipeline returns the index of the subpipeline that the given key is mapped to.
+func (d *HashDistributor) Pipeline(key string) int {
+	if len(d.ring) == 0 {
+		d.buildRing()
+	}
+	hash := hashKey(key)
+	index := sort.Search(len(d.ring), func(i int) bool {
+		return d.ring[i].hash >= hash
+	})
+	if index == len(d.ring) {
+		index = 0 // Wrap around the ring
+	}
+	return d.ring[index].pipe
+}
+
+func (d *HashDistributor) buildRing() {
+	nodes := d.VirtualNodes
+	if nodes <= 0 {
+		nodes = DefaultVirtualNodes
+	}
+	d.ring = make([]ringNode, 0, nodes*len(d.Subpipelines))
+	for pipe := range d.Subpipelines {
+		for i := 0; i < nodes; i++ {
+			d.ring = append(d.ring, ringNode{hash: hashKey(strconv.Itoa(pipe) + "-" + strconv.Itoa(i)), pipe: pipe})
+		}
+	}
+	sort.Slice(d.ring, func(i, j int) bool {
+		return d.ring[i].hash < d.ring[j].hash
+	})
+}
+
+func (d *HashDistributor) String() string {
+	return fmt.Sprintf("consistent hash (%v pipelines): %v", len(d.Subpipelines), d.Template)
+}
+
+func (d *HashDistributor) ContainedStringers() []fmt.Stringer {
+	return d.pipelineStringers()
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	// FNV does not mix the last bytes into the high bits, which are decisive for the position on the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return x
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/ryanuber/go-glob"
@@ -31,6 +32,17 @@ func (p *PipelineArray) build() []Subpipeline {
 	return p.out
 }
 
+func (p *PipelineArray) pipelineStringers() []fmt.Stringer {
+	res := make([]fmt.Stringer, len(p.Subpipelines))
+	for i, pipe := range p.Subpipelines {
+		res[i] = &bitflow.TitledSamplePipeline{
+			SamplePipeline: pipe,
+			Title:          fmt.Sprintf("Pipeline %v", i),
+		}
+	}
+	return res
+}
+
 type RoundRobinDistributor struct {
 	PipelineArray
 	Weights []int // Optionally define weights for the pipelines (same order as pipelines). Only values >= 1 will be counted. Default weight is 1.
@@ -95,14 +107,7 @@ func (d *MultiplexDistributor) String() string {
 }
 
 func (d *MultiplexDistributor) ContainedStringers() []fmt.Stringer {
-	res := make([]fmt.Stringer, len(d.Subpipelines))
-	for i, pipe := range d.Subpipelines {
-		res[i] = &bitflow.TitledSamplePipeline{
-			SamplePipeline: pipe,
-			Title:          fmt.Sprintf("Pipeline %v", i),
-		}
-	}
-	return res
+	return d.pipelineStringers()
 }
 
 type PipelineBuildFunc func(key string) ([]*bitflow.SamplePipeline, error)
@@ -154,6 +159,14 @@ func (d *PipelineCache) getPipelines(key string, build PipelineBuildFunc) ([]Sub
 	return result, nil
 }
 
+// forget removes all keys that lead to the given pipeline, so that the pipelines for these keys are built again.
+func (d *PipelineCache) forget(pipe *bitflow.SamplePipeline) {
+	for _, key := range d.keys[pipe] {
+		delete(d.pipelines, key)
+	}
+	delete(d.keys, pipe)
+}
+
 func (d *PipelineCache) ContainedStringers() []fmt.Stringer {
 	res := make([]fmt.Stringer, 0, len(d.keys))
 	for pipe, keys := range d.keys {
@@ -177,6 +190,8 @@ type RegexDistributor struct {
 	ExactMatch bool // Key patterns must match exactly, no glob (*) processing
 	RegexMatch bool // Overrides ExactMatch -> treat key patterns as regexes
 
+	IdleTimeout time.Duration // Stop subpipelines that did not receive samples for this duration, see ExpiringDistributor
+
 	regexCache        map[string]*regexp.Regexp
 	cache             PipelineCache
 	wildcardPipelines PipelineCache // This extra cache is only for implementing ContainedStringers()
@@ -239,6 +254,14 @@ func (d *RegexDistributor) matches(key, pattern string, allowRegex bool, allowGl
 	}
 }
 
+func (d *RegexDistributor) PipelineIdleTimeout() time.Duration {
+	return d.IdleTimeout
+}
+
+func (d *RegexDistributor) ForgetPipeline(pipe *bitflow.SamplePipeline) {
+	d.cache.forget(pipe)
+}
+
 func (d *RegexDistributor) ContainedStringers() []fmt.Stringer {
 	return d.wildcardPipelines.ContainedStringers()
 }
@@ -287,13 +310,20 @@ func (d *TagDistributor) String() string {
 	return fmt.Sprintf("tag template (%v matching): %v", matchMode, d.Template)
 }
 
-var _ Distributor = new(MultiFileDistributor)
+var (
+	_ ExpiringDistributor = new(TagDistributor)
+	_ ExpiringDistributor = new(GenericDistributor)
+	_ ExpiringDistributor = new(MultiFileDistributor)
+)
 
 type MultiFileDistributor struct {
 	bitflow.TagTemplate
 	PipelineCache
 	Config             bitflow.FileSink // Configuration parameters in this field will be used for file outputs
 	ExtendSubpipelines func(fileName string, pipe *bitflow.SamplePipeline)
+	IdleTimeout        time.Duration // Close files that did not receive samples for this duration, see ExpiringDistributor
+
+	opened map[string]bool
 }
 
 func (b *MultiFileDistributor) Distribute(sample *bitflow.Sample, _ *bitflow.Header) ([]Subpipeline, error) {
@@ -304,9 +334,26 @@ func (b *MultiFileDistributor) String() string {
 	return "Output to files: " + b.Template
 }
 
+func (b *MultiFileDistributor) PipelineIdleTimeout() time.Duration {
+	return b.IdleTimeout
+}
+
+func (b *MultiFileDistributor) ForgetPipeline(pipe *bitflow.SamplePipeline) {
+	b.forget(pipe)
+}
+
 func (b *MultiFileDistributor) build(fileName string) ([]*bitflow.SamplePipeline, error) {
 	fileOut := b.Config
 	fileOut.Filename = fileName
+	if b.opened[fileName] {
+		// The file was closed after the idle timeout, do not delete the previously written data
+		fileOut.CleanFiles = false
+	} else {
+		if b.opened == nil {
+			b.opened = make(map[string]bool)
+		}
+		b.opened[fileName] = true
+	}
 	format := bitflow.EndpointDescription{Target: fileName, Type: bitflow.FileEndpoint}.DefaultOutputFormat()
 	var err error
 	fileOut.Marshaller, err = bitflow.DefaultEndpointFactory.CreateMarshaller(format)
@@ -1,6 +1,7 @@
 package fork
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
@@ -26,6 +27,9 @@ func (suite *distributorsTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*distributorsTestSuite) SetS(suite.TestingSuite) {
+}
+
 func (suite *distributorsTestSuite) TestTagTemplateDistributor() {
 	s := &bitflow.Sample{Values: []bitflow.Value{1, 2, 3}}
 	s.SetTag("tag1", "val1")
@@ -70,3 +74,87 @@ func (suite *distributorsTestSuite) TestTagTemplateDistributor() {
 	test("c", "c", pipeA, pipeB)
 	test("cxx", "")
 }
+
+type queueingProcessor struct {
+	bitflow.NoopProcessor
+	queued int
+}
+
+func (p *queueingProcessor) QueueLength() int {
+	return p.queued
+}
+
+func (suite *distributorsTestSuite) TestLeastLoadedDistributor() {
+	queues := []*queueingProcessor{new(queueingProcessor), new(queueingProcessor), new(queueingProcessor)}
+	var dist LeastLoadedDistributor
+	for _, queue := range queues {
+		dist.Subpipelines = append(dist.Subpipelines, new(bitflow.SamplePipeline).Add(queue))
+	}
+	next := func() string {
+		res, err := dist.Distribute(new(bitflow.Sample), new(bitflow.Header))
+		suite.NoError(err)
+		suite.Len(res, 1)
+		return res[0].Key
+	}
+
+	// Equal load: round robin
+	suite.Equal([]string{"0", "1", "2", "0"}, []string{next(), next(), next(), next()})
+
+	queues[0].queued = 5
+	queues[1].queued = 2
+	queues[2].queued = 3
+	suite.Equal("1", next())
+	queues[1].queued = 4
+	suite.Equal("2", next())
+	queues[0].queued = 3
+	queues[2].queued = 3
+	suite.Equal("0", next(), "Ties must be broken in round-robin order")
+	suite.Equal("least loaded (3 pipelines)", dist.String())
+}
+
+func (suite *distributorsTestSuite) TestHashDistributor() {
+	pipes := make([]*bitflow.SamplePipeline, 4)
+	for i := range pipes {
+		pipes[i] = new(bitflow.SamplePipeline)
+	}
+	dist := &HashDistributor{TagTemplate: bitflow.TagTemplate{Template: "${host}"}}
+	dist.Subpipelines = pipes
+
+	counts := make([]int, len(pipes))
+	assignments := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		host := "host-" + strconv.Itoa(i)
+		s := new(bitflow.Sample)
+		s.SetTag("host", host)
+		res, err := dist.Distribute(s, nil)
+		suite.NoError(err)
+		suite.Len(res, 1)
+		index, err := strconv.Atoi(res[0].Key)
+		suite.NoError(err)
+		suite.Equal(pipes[index], res[0].Pipe)
+		assignments[host] = index
+		counts[index]++
+
+		// Same tag value, same pipeline
+		res, err = dist.Distribute(s, nil)
+		suite.NoError(err)
+		suite.Equal(strconv.Itoa(index), res[0].Key)
+	}
+	for i, count := range counts {
+		suite.True(count > 300, "Pipeline %v received only %v of 2000 keys", i, count)
+	}
+
+	// Adding a pipeline must only move keys to the new pipeline
+	bigger := &HashDistributor{TagTemplate: dist.TagTemplate}
+	bigger.Subpipelines = append(pipes, new(bitflow.SamplePipeline))
+	moved := 0
+	for host, index := range assignments {
+		newIndex := bigger.Pipeline(host)
+		if newIndex != index {
+			suite.Equal(4, newIndex)
+			moved++
+		}
+	}
+	suite.True(moved > 200 && moved < 700, "Unexpected number of moved keys: %v", moved)
+	suite.Equal("consistent hash (4 pipelines): ${host}", dist.String())
+}
@@ -0,0 +1,222 @@
+package fork
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type forkTestSuite struct {
+	suite.Suite
+}
+
+func TestFork(t *testing.T) {
+	suite.Run(t, new(forkTestSuite))
+}
+
+type closeCounter struct {
+	bitflow.NoopProcessor
+	closed *int32
+	lock   *sync.Mutex
+}
+
+func (c *closeCounter) Close() {
+	c.lock.Lock()
+	*c.closed++
+	c.lock.Unlock()
+	c.NoopProcessor.Close()
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	tags []string
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, _ *bitflow.Header) error {
+	s.tags = append(s.tags, sample.Tag("host"))
+	return nil
+}
+
+func (s *forkTestSuite) TestIdlePipelineExpiration() {
+	var lock sync.Mutex
+	var built, closed int32
+	dist := &TagDistributor{
+		TagTemplate: bitflow.TagTemplate{Template: "${host}"},
+		RegexDistributor: RegexDistributor{
+			IdleTimeout: time.Minute,
+			Pipelines: map[string]func() ([]*bitflow.SamplePipeline, error){
+				"*": func() ([]*bitflow.SamplePipeline, error) {
+					lock.Lock()
+					defer lock.Unlock()
+					built++
+					return []*bitflow.SamplePipeline{new(bitflow.SamplePipeline).Add(&closeCounter{closed: &closed, lock: &lock})}, nil
+				},
+			},
+		},
+	}
+	s.Require().NoError(dist.Init())
+	built = 0 // Ignore the pipeline built by Init()
+
+	now := time.Unix(0, 0)
+	f := &SampleFork{Distributor: dist, now: func() time.Time { return now }}
+	out := new(collectingSink)
+	f.SetSink(out)
+	var wg sync.WaitGroup
+	f.Start(&wg)
+	header := &bitflow.Header{Fields: []string{"a"}}
+	send := func(host string, advance time.Duration) {
+		now = now.Add(advance)
+		sample := &bitflow.Sample{Values: []bitflow.Value{1}}
+		sample.SetTag("host", host)
+		s.NoError(f.Sample(sample, header))
+	}
+	countClosed := func() int32 {
+		lock.Lock()
+		defer lock.Unlock()
+		return closed
+	}
+
+	send("a", 0)
+	send("b", 40*time.Second)
+	send("b", 30*time.Second) // a is idle for 70 seconds and expires
+	for i := 0; i < 1000 && countClosed() < 1; i++ {
+		time.Sleep(time.Millisecond) // The pipeline is closed in the background
+	}
+	s.Equal(int32(1), countClosed())
+	s.Len(f.pipelines, 1)
+	send("a", 10*time.Second) // a is created again
+	s.Len(f.pipelines, 2)
+
+	f.Close()
+	wg.Wait()
+	s.Equal(int32(3), built)
+	s.Equal(int32(3), countClosed())
+	s.Equal([]string{"a", "b", "b", "a"}, out.tags)
+}
+
+func (s *forkTestSuite) TestNoExpirationWithoutTimeout() {
+	pipe := new(bitflow.SamplePipeline)
+	f := &SampleFork{Distributor: &MultiplexDistributor{PipelineArray{Subpipelines: []*bitflow.SamplePipeline{pipe}}}}
+	now := time.Unix(0, 0)
+	f.now = func() time.Time { return now }
+	f.SetSink(new(collectingSink))
+	var wg sync.WaitGroup
+	f.Start(&wg)
+	s.NoError(f.Sample(new(bitflow.Sample), new(bitflow.Header)))
+	now = now.Add(24 * time.Hour)
+	s.NoError(f.Sample(new(bitflow.Sample), new(bitflow.Header)))
+	s.Len(f.pipelines, 1)
+	f.Close()
+	wg.Wait()
+}
+
+// sampleRecorder records the received sample instances and optionally modifies them
+type sampleRecorder struct {
+	bitflow.NoopProcessor
+	readOnly bool
+	samples  []*bitflow.Sample
+}
+
+func (r *sampleRecorder) IsReadOnly() bool {
+	return r.readOnly
+}
+
+func (r *sampleRecorder) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	r.samples = append(r.samples, sample)
+	if !r.readOnly {
+		sample.SetTag("host", "modified")
+	}
+	return r.NoopProcessor.Sample(sample, header)
+}
+
+func (s *forkTestSuite) runMultiplex(f *SampleFork, sink bitflow.SampleProcessor, branches ...*sampleRecorder) *bitflow.Sample {
+	pipes := make([]*bitflow.SamplePipeline, len(branches))
+	for i, branch := range branches {
+		pipes[i] = new(bitflow.SamplePipeline).Add(branch)
+	}
+	f.Distributor = &MultiplexDistributor{PipelineArray{Subpipelines: pipes}}
+	f.SetSink(sink)
+	var wg sync.WaitGroup
+	f.Start(&wg)
+	sample := &bitflow.Sample{Values: []bitflow.Value{1}}
+	sample.SetTag("host", "a")
+	s.NoError(f.Sample(sample, &bitflow.Header{Fields: []string{"a"}}))
+	f.Close()
+	wg.Wait()
+	return sample
+}
+
+func (s *forkTestSuite) TestReadOnlyBranchesShareSamples() {
+	readOnly1, readOnly2, writing := &sampleRecorder{readOnly: true}, &sampleRecorder{readOnly: true}, new(sampleRecorder)
+	out := new(collectingSink)
+	sample := s.runMultiplex(new(SampleFork), out, readOnly1, writing, readOnly2)
+	s.True(sample == readOnly1.samples[0])
+	s.True(sample == readOnly2.samples[0])
+	s.False(sample == writing.samples[0], "Modifying subpipelines must receive a copy")
+	s.Equal("a", sample.Tag("host"))
+	s.ElementsMatch([]string{"a", "modified", "a"}, out.tags)
+}
+
+func (s *forkTestSuite) TestDeclaredReadOnlyBranches() {
+	branch1, branch2 := new(sampleRecorder), new(sampleRecorder)
+	branch1.readOnly, branch2.readOnly = false, false
+	sample := s.runMultiplex(&SampleFork{ReadOnlyBranches: true}, new(collectingSink), branch1, branch2)
+	s.True(sample == branch1.samples[0])
+	s.True(sample == branch2.samples[0])
+}
+
+func (s *forkTestSuite) TestReadOnlyBranchesCopyForModifyingSuccessor() {
+	branch1, branch2 := &sampleRecorder{readOnly: true}, &sampleRecorder{readOnly: true}
+	f := new(SampleFork)
+	successor := new(sampleRecorder)
+	successor.SetSink(new(collectingSink))
+	sample := s.runMultiplex(f, successor, branch1, branch2)
+	s.True(sample == branch1.samples[0])
+	s.Len(successor.samples, 2)
+	s.False(successor.samples[0] == sample, "The steps after the fork modify the samples, so they must receive copies")
+	s.False(successor.samples[0] == successor.samples[1])
+	s.Equal("a", sample.Tag("host"))
+}
+
+func (s *forkTestSuite) TestSubpipelineCheckpoint() {
+	newFork := func() *SampleFork {
+		pipes := []*bitflow.SamplePipeline{
+			new(bitflow.SamplePipeline).Add(new(bitflow.BatchProcessor)),
+			new(bitflow.SamplePipeline).Add(new(bitflow.BatchProcessor)),
+		}
+		return &SampleFork{Distributor: &MultiplexDistributor{PipelineArray{Subpipelines: pipes}}, BranchQueue: 5}
+	}
+	run := func(f *SampleFork, out *collectingSink, numSamples int) *sync.WaitGroup {
+		f.SetSink(out)
+		var wg sync.WaitGroup
+		f.Start(&wg)
+		for i := 0; i < numSamples; i++ {
+			sample := &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i)}}
+			sample.SetTag("host", "a")
+			s.NoError(f.Sample(sample, &bitflow.Header{Fields: []string{"a"}}))
+		}
+		return &wg
+	}
+
+	f := newFork()
+	wg := run(f, new(collectingSink), 3)
+	s.NoError(f.FlushCheckpoint())
+	state, err := f.StoreState()
+	s.NoError(err)
+	s.Contains(string(state), `"0":`)
+	s.Contains(string(state), `"1":`)
+	f.Close()
+	wg.Wait()
+
+	// The restored subpipelines continue with the batched samples
+	restored := newFork()
+	s.NoError(restored.RestoreState(state))
+	out := new(collectingSink)
+	wg = run(restored, out, 1)
+	restored.Close()
+	wg.Wait()
+	s.Len(out.tags, 8)
+}
@@ -37,7 +37,9 @@ func (m *MultiPipeline) StartPipeline(pipeline *bitflow.SamplePipeline, finished
 		// Use an empty source to make stopPipeline() work
 		pipeline.Source = new(bitflow.EmptySampleSource)
 	}
+	m.stoppedCond.L.Lock()
 	m.runningPipelines++
+	m.stoppedCond.L.Unlock()
 
 	running := runningSubPipeline{
 		pipeline: pipeline,
@@ -76,6 +78,18 @@ func (m *MultiPipeline) StopPipelines() {
 	m.stoppedCond.Broadcast()
 }
 
+// StopPipeline stops a single subpipeline that was started through StartPipeline(), while the other subpipelines
+// keep running. The subpipeline is closed in the background, flushing any samples it still holds.
+func (m *MultiPipeline) StopPipeline(pipeline *bitflow.SamplePipeline) {
+	for i, running := range m.pipelines {
+		if running != nil && running.pipeline == pipeline {
+			m.pipelines = append(m.pipelines[:i], m.pipelines[i+1:]...)
+			go running.stop()
+			return
+		}
+	}
+}
+
 func (m *MultiPipeline) stopPipelines() {
 	var wg sync.WaitGroup
 	for i, pipeline := range m.pipelines {
@@ -152,3 +166,18 @@ func (sink *Merger) Sample(sample *bitflow.Sample, header *bitflow.Header) error
 func (sink *Merger) Close() {
 	// The actual outgoing sink must be closed in the closeHook function passed to Init()
 }
+
+// sharedSampleMerger ends read-only subpipelines, which receive the original samples instead of copies. Since every
+// read-only subpipeline forwards the same instance, the samples are copied before forwarding them, unless the steps
+// following the fork are read-only as well.
+type sharedSampleMerger struct {
+	*Merger
+	copySamples bool
+}
+
+func (sink *sharedSampleMerger) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if sink.copySamples {
+		sample = sample.DeepClone()
+	}
+	return sink.Merger.Sample(sample, header)
+}
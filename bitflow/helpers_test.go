@@ -11,6 +11,7 @@ import (
 
 	"github.com/antongulenko/golib"
 	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
 )
 
 var parallel_handler = ParallelSampleHandler{
@@ -46,6 +47,9 @@ func (suite *testSuiteBase) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*testSuiteBase) SetS(suite.TestingSuite) {
+}
+
 type testSuiteWithSamples struct {
 	testSuiteBase
 
@@ -0,0 +1,104 @@
+package bitflow
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// LogLevelHint is the name of the scheduling hint used to override the log level of a step in a bitflow script,
+	// e.g. step()[log_level=debug].
+	LogLevelHint = "log_level"
+
+	// Names of the fields added to the log entries of pipeline steps, see StepLogger.
+	LogFieldPipeline = "pipeline"
+	LogFieldFork     = "fork"
+	LogFieldStep     = "step"
+)
+
+// LogContext describes the position of a SampleSource or SampleProcessor inside a running pipeline.
+type LogContext struct {
+	Pipeline string
+	ForkPath []string
+	Step     string
+}
+
+// Fields returns the non-empty parts of the LogContext as log fields.
+func (c LogContext) Fields() log.Fields {
+	fields := log.Fields{LogFieldStep: c.Step}
+	if c.Pipeline != "" {
+		fields[LogFieldPipeline] = c.Pipeline
+	}
+	if len(c.ForkPath) > 0 {
+		fields[LogFieldFork] = strings.Join(c.ForkPath, "/")
+	}
+	return fields
+}
+
+// LoggingProcessor is implemented by SampleSources and SampleProcessors that embed StepLogger.
+// SamplePipeline.Construct() initializes the logger of all implementing steps.
+type LoggingProcessor interface {
+	InitLogger(ctx LogContext)
+	SetLogLevel(level log.Level)
+}
+
+// StepLogger provides a logger, which adds the LogContext of a pipeline step to all log entries.
+// It is embedded in AbstractSampleSource, so all sources and processors can log through Log().
+type StepLogger struct {
+	logger *log.Entry
+	ctx    LogContext
+	level  *log.Level
+}
+
+// Log returns the logger of the step. Before the pipeline is constructed, it logs without context.
+func (l *StepLogger) Log() *log.Entry {
+	if l.logger == nil {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return l.logger
+}
+
+// LogContext returns the context that was used to initialize the logger.
+func (l *StepLogger) LogContext() LogContext {
+	return l.ctx
+}
+
+// InitLogger implements the LoggingProcessor interface.
+func (l *StepLogger) InitLogger(ctx LogContext) {
+	l.ctx = ctx
+	logger := log.StandardLogger()
+	if l.level != nil {
+		logger = newLevelLogger(logger, *l.level)
+	}
+	l.logger = log.NewEntry(logger).WithFields(ctx.Fields())
+}
+
+// SetLogLevel implements the LoggingProcessor interface. It overrides the level of the standard logger for this step,
+// and must be called before InitLogger.
+func (l *StepLogger) SetLogLevel(level log.Level) {
+	l.level = &level
+}
+
+// newLevelLogger returns a logger that behaves like the given one, but logs on a different level.
+func newLevelLogger(logger *log.Logger, level log.Level) *log.Logger {
+	return &log.Logger{
+		Out:          logger.Out,
+		Hooks:        logger.Hooks,
+		Formatter:    logger.Formatter,
+		ReportCaller: logger.ReportCaller,
+		Level:        level,
+		ExitFunc:     logger.ExitFunc,
+	}
+}
+
+// ParseLogLevel parses a log level like "debug" or "warn" for the LogLevelHint.
+func ParseLogLevel(level string) (log.Level, error) {
+	return log.ParseLevel(level)
+}
+
+func initStepLogger(step interface{}, ctx LogContext) {
+	if logging, ok := step.(LoggingProcessor); ok {
+		logging.InitLogger(ctx)
+	}
+}
@@ -0,0 +1,81 @@
+package bitflow
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggingTestSuite struct {
+	testSuiteBase
+}
+
+func TestLogging(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
+
+func (suite *LoggingTestSuite) TestLogContextFields() {
+	suite.Equal(log.Fields{LogFieldStep: "step"}, LogContext{Step: "step"}.Fields())
+	suite.Equal(log.Fields{LogFieldStep: "step", LogFieldPipeline: "p", LogFieldFork: "a/b"},
+		LogContext{Pipeline: "p", ForkPath: []string{"a", "b"}, Step: "step"}.Fields())
+}
+
+func (suite *LoggingTestSuite) TestConstructInitializesLoggers() {
+	source := new(EmptySampleSource)
+	step := new(NoopProcessor)
+	wrapped := new(NoopProcessor)
+	policy := &ErrorPolicyProcessor{Step: wrapped, Policy: ErrorPolicy{Type: ErrorPolicyDrop}}
+	pipe := &SamplePipeline{Source: source, Name: "test", ForkPath: []string{"x"}}
+	pipe.Add(step).Add(policy)
+	pipe.Construct(new(golib.TaskGroup))
+
+	suite.Equal(LogContext{Pipeline: "test", ForkPath: []string{"x"}, Step: "empty sample source"}, source.LogContext())
+	suite.Equal(log.Fields{LogFieldStep: "NoopProcessor", LogFieldPipeline: "test", LogFieldFork: "x"}, step.Log().Data)
+	suite.Equal(policy.String(), policy.Log().Data[LogFieldStep])
+	suite.Equal("NoopProcessor", wrapped.Log().Data[LogFieldStep])
+	suite.Equal(log.StandardLogger(), step.Log().Logger)
+}
+
+func (suite *LoggingTestSuite) TestLogLevelOverride() {
+	var buf bytes.Buffer
+	std := log.StandardLogger()
+	oldOut, oldLevel := std.Out, std.Level
+	defer func() {
+		std.SetOutput(oldOut)
+		std.SetLevel(oldLevel)
+	}()
+	std.SetOutput(&buf)
+	std.SetLevel(log.InfoLevel)
+
+	var quiet, verbose, unset StepLogger
+	quiet.SetLogLevel(log.ErrorLevel)
+	verbose.SetLogLevel(log.DebugLevel)
+	quiet.InitLogger(LogContext{Step: "quiet"})
+	verbose.InitLogger(LogContext{Step: "verbose"})
+
+	quiet.Log().Warnln("quiet warning")
+	verbose.Log().Debugln("verbose debug")
+	unset.Log().Debugln("unset debug")
+	unset.Log().Println("unset info")
+
+	out := buf.String()
+	suite.NotContains(out, "quiet warning")
+	suite.Contains(out, "verbose debug")
+	suite.NotContains(out, "unset debug")
+	suite.Contains(out, "unset info")
+}
+
+func (suite *LoggingTestSuite) TestErrorPolicyForwardsLogLevel() {
+	step := new(NoopProcessor)
+	policy := &ErrorPolicyProcessor{Step: step}
+	policy.SetLogLevel(log.DebugLevel)
+	policy.InitLogger(LogContext{Step: "policy"})
+	suite.Equal(log.DebugLevel, step.Log().Logger.Level)
+	suite.Equal(log.DebugLevel, policy.Log().Logger.Level)
+
+	_, err := ParseLogLevel("verbose")
+	suite.Error(err)
+}
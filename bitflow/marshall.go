@@ -69,6 +69,25 @@ type Unmarshaller interface {
 	ParseSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (*Sample, error)
 }
 
+// DataReleasingUnmarshaller can be implemented by Unmarshallers that allocate the sample data returned by Read()
+// from a pool. ReleaseData is called with the sample data after ParseSample() returned, so the buffer can be reused.
+type DataReleasingUnmarshaller interface {
+	Unmarshaller
+	ReleaseData(sampleData []byte)
+}
+
+// DirectUnmarshaller can be implemented by Unmarshallers that decode samples directly from the input stream,
+// without copying the sample data to an intermediate buffer. This is worth it for formats that are cheap to
+// decode, because the decoding is not parallelized: the SampleInputStream uses ReadSample() instead of
+// Read() and ParseSample(), and ignores the ParallelParsers setting.
+type DirectUnmarshaller interface {
+	Unmarshaller
+
+	// ReadSample behaves like Read(), but returns the parsed Sample instead of the sample data.
+	// The resulting Sample must have a Value slice with at least the capacity of minValueCapacity.
+	ReadSample(input *bufio.Reader, previousHeader *UnmarshalledHeader, minValueCapacity int) (newHeader *UnmarshalledHeader, sample *Sample, err error)
+}
+
 // BidiMarshaller is a bidirectional marshaller that combines the
 // Marshaller and Unmarshaller interfaces.
 type BidiMarshaller interface {
@@ -76,6 +95,7 @@ type BidiMarshaller interface {
 	ParseSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (*Sample, error)
 	WriteHeader(header *Header, withTags bool, output io.Writer) error
 	WriteSample(sample *Sample, header *Header, withTags bool, output io.Writer) error
+	ShouldCloseAfterFirstSample() bool
 	String() string
 }
 
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -22,12 +23,16 @@ const (
 	// BinarySeparator is the character separating fields in the marshalled output
 	// of BinaryMarshaller. Every field is marshalled on a separate line.
 	BinarySeparator = '\n'
+
+	// binary_trace_prefix precedes the Header.TraceParent, which is marshalled as an additional header line.
+	binary_trace_prefix = "traceparent="
 )
 
 // BinaryMarshaller marshalled every sample to a dense binary format.
 //
 // The header is marshalled to a newline-separated list of strings. The first
 // field is 'timB', the second field is 'tags' if the following samples include tags.
+// If the header has a TraceParent, it follows as 'traceparent=<value>'.
 // The following fields are the names of the metrics in the header.
 // An empty line denotes the end of the header.
 //
@@ -66,6 +71,13 @@ func (BinaryMarshaller) WriteHeader(header *Header, withTags bool, writer io.Wri
 		w.WriteStr(tags_col)
 		w.WriteByte(BinarySeparator)
 	}
+	if header.TraceParent != "" {
+		if err := checkHeaderField(header.TraceParent); err != nil {
+			return err
+		}
+		w.WriteStr(binary_trace_prefix + header.TraceParent)
+		w.WriteByte(BinarySeparator)
+	}
 	for _, name := range header.Fields {
 		if err := checkHeaderField(name); err != nil {
 			return err
@@ -86,9 +98,10 @@ func (m BinaryMarshaller) WriteSample(sample *Sample, header *Header, withTags b
 	}
 
 	// Time as big-endian uint64 nanoseconds since Unix epoch
-	tim := make([]byte, timeBytes)
-	binary.BigEndian.PutUint64(tim, uint64(sample.Time.UnixNano()))
-	if _, err := writer.Write(tim); err != nil {
+	buf := allocateBuffer(timeBytes)
+	defer releaseBuffer(buf)
+	binary.BigEndian.PutUint64(buf, uint64(sample.Time.UnixNano()))
+	if _, err := writer.Write(buf); err != nil {
 		return err
 	}
 
@@ -106,9 +119,8 @@ func (m BinaryMarshaller) WriteSample(sample *Sample, header *Header, withTags b
 	// Values as big-endian double precision
 	for _, value := range sample.Values {
 		valBits := math.Float64bits(float64(value))
-		val := make([]byte, valBytes)
-		binary.BigEndian.PutUint64(val, valBits)
-		if _, err := writer.Write(val); err != nil {
+		binary.BigEndian.PutUint64(buf, valBits)
+		if _, err := writer.Write(buf); err != nil {
 			return err
 		}
 	}
@@ -123,26 +135,54 @@ func (m BinaryMarshaller) Read(reader *bufio.Reader, previousHeader *Unmarshalle
 	if previousHeader == nil {
 		return m.readHeader(reader)
 	}
+	if isSample, err := m.readSampleStart(reader); err != nil {
+		return nil, nil, err
+	} else if !isSample {
+		return m.readHeader(reader)
+	}
+	data, err := m.readSampleData(previousHeader, reader)
+	return nil, data, err
+}
 
+// ReadSample implements the DirectUnmarshaller interface. It behaves like Read(), but samples are decoded
+// directly from the buffer of the bufio.Reader into a Sample allocated through AllocateSample(),
+// without copying the sample data to an intermediate buffer.
+func (m BinaryMarshaller) ReadSample(reader *bufio.Reader, previousHeader *UnmarshalledHeader, minValueCapacity int) (*UnmarshalledHeader, *Sample, error) {
+	if previousHeader == nil {
+		header, _, err := m.readHeader(reader)
+		return header, nil, err
+	}
+	if isSample, err := m.readSampleStart(reader); err != nil {
+		return nil, nil, err
+	} else if !isSample {
+		header, _, err := m.readHeader(reader)
+		return header, nil, err
+	}
+	sample, err := m.decodeSample(previousHeader, minValueCapacity, reader)
+	return nil, sample, err
+}
+
+// readSampleStart peeks a few bytes from the input stream and returns true, if they start a sample,
+// and false, if they start a header. In case of a sample, the special start bytes are discarded.
+func (BinaryMarshaller) readSampleStart(reader *bufio.Reader) (bool, error) {
 	start, err := reader.Peek(len(binary_sample_start))
 	if err == bufio.ErrBufferFull {
-		return nil, nil, errors.New("Buffer too small to distinguish between binary sample and header")
+		return false, errors.New("Buffer too small to distinguish between binary sample and header")
 	} else if err != nil {
 		if len(start) > 0 {
 			err = unexpectedEOF(err)
 		}
-		return nil, nil, err
+		return false, err
 	}
 
 	switch {
 	case bytes.HasPrefix([]byte(binary_time_col), start):
-		return m.readHeader(reader)
+		return false, nil
 	case bytes.Equal(start, []byte(binary_sample_start)):
 		_, _ = reader.Discard(len(start)) // No error
-		data, err := m.readSampleData(previousHeader, reader)
-		return nil, data, err
+		return true, nil
 	default:
-		return nil, nil, fmt.Errorf("Bitflow binary protocol error, unexpected: %s. Expected %s or %s.",
+		return false, fmt.Errorf("Bitflow binary protocol error, unexpected: %s. Expected %s or %s.",
 			start, binary_sample_start, binary_time_col[:len(binary_sample_start)])
 	}
 }
@@ -177,6 +217,8 @@ func (BinaryMarshaller) readHeader(reader *bufio.Reader) (*UnmarshalledHeader, [
 		name := string(nameBytes[:len(nameBytes)-1])
 		if first && name == tags_col {
 			header.HasTags = true
+		} else if len(header.Fields) == 0 && header.TraceParent == "" && strings.HasPrefix(name, binary_trace_prefix) {
+			header.TraceParent = name[len(binary_trace_prefix):]
 		} else {
 			header.Fields = append(header.Fields, name)
 		}
@@ -187,17 +229,19 @@ func (BinaryMarshaller) readHeader(reader *bufio.Reader) (*UnmarshalledHeader, [
 func (BinaryMarshaller) readSampleData(header *UnmarshalledHeader, input *bufio.Reader) ([]byte, error) {
 	valueLen := valBytes * len(header.Fields)
 	minLen := timeBytes + valueLen
-	data := make([]byte, minLen)
+	data := allocateBuffer(minLen)
 	_, err := io.ReadFull(input, data) // Can be io.EOF
 	if err != nil {
+		releaseBuffer(data)
 		return nil, err
 	}
 	if !header.HasTags {
 		return data, nil
 	} else {
+		defer releaseBuffer(data)
 		index := bytes.IndexByte(data[timeBytes:], BinarySeparator)
 		if index >= 0 {
-			result := make([]byte, minLen+index+1)
+			result := allocateBuffer(minLen + index + 1)
 			copy(result, data)
 			_, err := io.ReadFull(input, result[minLen:])
 			return result, unexpectedEOF(err)
@@ -206,9 +250,10 @@ func (BinaryMarshaller) readSampleData(header *UnmarshalledHeader, input *bufio.
 			if err != nil {
 				return nil, unexpectedEOF(err)
 			}
-			result := make([]byte, minLen+len(tagRest)+valueLen)
+			result := allocateBuffer(minLen + len(tagRest) + valueLen)
 			_, err = io.ReadFull(input, result[minLen+len(tagRest):])
 			if err != nil {
+				releaseBuffer(result)
 				return nil, unexpectedEOF(err)
 			}
 			copy(result, data)
@@ -218,8 +263,77 @@ func (BinaryMarshaller) readSampleData(header *UnmarshalledHeader, input *bufio.
 	}
 }
 
+func (BinaryMarshaller) decodeSample(header *UnmarshalledHeader, minValueCapacity int, input *bufio.Reader) (*Sample, error) {
+	// Time
+	data, err := input.Peek(timeBytes)
+	if err != nil {
+		if len(data) > 0 {
+			err = unexpectedEOF(err)
+		}
+		return nil, err
+	}
+	timeVal := binary.BigEndian.Uint64(data)
+	_, _ = input.Discard(timeBytes) // No error
+
+	if minValueCapacity < len(header.Fields) {
+		minValueCapacity = len(header.Fields)
+	}
+	sample := AllocateSample(minValueCapacity)
+	sample.Time = time.Unix(0, int64(timeVal))
+
+	// Tags
+	if header.HasTags {
+		tags, err := input.ReadSlice(BinarySeparator)
+		if err == bufio.ErrBufferFull {
+			// The tags do not fit into the buffer of the reader, fall back to copying them
+			tags = append([]byte(nil), tags...)
+			var rest []byte
+			rest, err = readUntil(input, BinarySeparator)
+			tags = append(tags, rest...)
+		}
+		if err == nil {
+			// The tags must be parsed before reading on, because ReadSlice returns a slice of the read buffer
+			err = sample.ParseTagString(string(tags[:len(tags)-1]))
+		} else {
+			err = unexpectedEOF(err)
+		}
+		if err != nil {
+			sample.Release()
+			return nil, err
+		}
+	}
+
+	// Values, decoded in chunks that fit into the buffer of the reader
+	chunkSize := input.Size() / valBytes * valBytes
+	for remaining := len(header.Fields) * valBytes; remaining > 0; {
+		size := remaining
+		if size > chunkSize {
+			size = chunkSize
+		}
+		data, err := input.Peek(size)
+		if err != nil {
+			sample.Release()
+			return nil, unexpectedEOF(err)
+		}
+		for i := 0; i < size; i += valBytes {
+			valBits := binary.BigEndian.Uint64(data[i : i+valBytes])
+			sample.Values = append(sample.Values, Value(math.Float64frombits(valBits)))
+		}
+		_, _ = input.Discard(size) // No error
+		remaining -= size
+	}
+	return sample, nil
+}
+
+// ReleaseData implements the DataReleasingUnmarshaller interface. The data returned by Read() is allocated from
+// a pool of reusable buffers.
+func (BinaryMarshaller) ReleaseData(data []byte) {
+	releaseBuffer(data)
+}
+
 // ParseSample implements the Unmarshaller interface by parsing the byte buffer
-// to a new Sample instance. See the godoc for BinaryMarshaller for details on the format.
+// to a new Sample instance, which is allocated through AllocateSample().
+// See the godoc for BinaryMarshaller for details on the format.
 func (BinaryMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (sample *Sample, err error) {
 	// Required size
 	size := timeBytes + len(header.Fields)*valBytes
@@ -231,14 +345,11 @@ func (BinaryMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity
 	// Time
 	timeVal := binary.BigEndian.Uint64(data[:timeBytes])
 	data = data[timeBytes:]
-	var values []Value
-	if minValueCapacity > 0 {
-		values = make([]Value, 0, minValueCapacity)
-	}
-	sample = &Sample{
-		Values: values,
-		Time:   time.Unix(0, int64(timeVal)),
+	if minValueCapacity < len(header.Fields) {
+		minValueCapacity = len(header.Fields)
 	}
+	sample = AllocateSample(minValueCapacity)
+	sample.Time = time.Unix(0, int64(timeVal))
 
 	// Tags
 	if header.HasTags {
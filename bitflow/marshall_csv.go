@@ -163,7 +163,8 @@ func (CsvMarshaller) parseHeader(line []byte) *UnmarshalledHeader {
 	return header
 }
 
-// ParseSample implements the Unmarshaller interface by parsing a CSV line.
+// ParseSample implements the Unmarshaller interface by parsing a CSV line to a new Sample instance,
+// which is allocated through AllocateSample().
 func (CsvMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (sample *Sample, err error) {
 	fields := splitCsvLine(data)
 	var t time.Time
@@ -171,14 +172,11 @@ func (CsvMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity in
 	if err != nil {
 		return
 	}
-	var values []Value
-	if minValueCapacity > 0 {
-		values = make([]Value, 0, minValueCapacity)
-	}
-	sample = &Sample{
-		Values: values,
-		Time:   t,
+	if minValueCapacity < len(header.Fields) {
+		minValueCapacity = len(header.Fields)
 	}
+	sample = AllocateSample(minValueCapacity)
+	sample.Time = t
 
 	start := 1
 	if header.HasTags {
@@ -191,6 +189,10 @@ func (CsvMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity in
 		}
 		start++
 	}
+	if numValues := len(fields) - start; numValues != len(header.Fields) {
+		err = fmt.Errorf("Sample has %v values, but the header has %v fields", numValues, len(header.Fields))
+		return
+	}
 
 	for _, field := range fields[start:] {
 		var val float64
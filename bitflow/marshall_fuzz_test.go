@@ -0,0 +1,86 @@
+package bitflow
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+// fuzzMaxReads limits the number of headers and samples read from a single fuzz input
+const fuzzMaxReads = 100
+
+func fuzzSeeds(f *testing.F, m BidiMarshaller) {
+	header := &Header{Fields: []string{"a", "b", "c"}}
+	sample := &Sample{Values: []Value{1, 2.5, -3}, Time: time.Unix(1000, 0)}
+	sample.SetTag("host", "x")
+	sample.SetTag("key", "a b=c")
+	for _, withTags := range []bool{true, false} {
+		var buf bytes.Buffer
+		_ = m.WriteHeader(header, withTags, &buf)
+		_ = m.WriteSample(sample, header, withTags, &buf)
+		_ = m.WriteSample(sample, header, withTags, &buf)
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{})
+	f.Add([]byte("time\n"))
+}
+
+// checkFuzzSample reports samples that do not match their header, because processing steps rely on that.
+func checkFuzzSample(t *testing.T, header *UnmarshalledHeader, sample *Sample) {
+	if len(sample.Values) != len(header.Fields) {
+		t.Fatalf("Sample has %v values, but header has %v fields", len(sample.Values), len(header.Fields))
+	}
+}
+
+// fuzzUnmarshaller reads headers and samples until an error occurs. Errors are expected, only panics and invalid samples are reported.
+func fuzzUnmarshaller(t *testing.T, m Unmarshaller, data []byte) {
+	reader := bufio.NewReaderSize(bytes.NewReader(data), 64)
+	var header *UnmarshalledHeader
+	for i := 0; i < fuzzMaxReads; i++ {
+		newHeader, sampleData, err := m.Read(reader, header)
+		if newHeader != nil {
+			header = newHeader
+		} else if sampleData != nil && header != nil {
+			sample, parseErr := m.ParseSample(header, 0, sampleData)
+			if parseErr != nil {
+				return
+			}
+			checkFuzzSample(t, header, sample)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func fuzzDirectUnmarshaller(t *testing.T, m DirectUnmarshaller, data []byte) {
+	reader := bufio.NewReaderSize(bytes.NewReader(data), 64)
+	var header *UnmarshalledHeader
+	for i := 0; i < fuzzMaxReads; i++ {
+		newHeader, sample, err := m.ReadSample(reader, header, 0)
+		if newHeader != nil {
+			header = newHeader
+		} else if sample != nil && header != nil {
+			checkFuzzSample(t, header, sample)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func FuzzCsvUnmarshaller(f *testing.F) {
+	fuzzSeeds(f, new(CsvMarshaller))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzUnmarshaller(t, new(CsvMarshaller), data)
+	})
+}
+
+func FuzzBinaryUnmarshaller(f *testing.F) {
+	fuzzSeeds(f, new(BinaryMarshaller))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzUnmarshaller(t, new(BinaryMarshaller), data)
+		fuzzDirectUnmarshaller(t, new(BinaryMarshaller), data)
+	})
+}
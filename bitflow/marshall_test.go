@@ -3,8 +3,10 @@ package bitflow
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -116,3 +118,125 @@ func (suite *MarshallerTestSuite) TestCsvEOF() {
 func (suite *MarshallerTestSuite) TestBinaryEOF() {
 	suite.testEOF(new(BinaryMarshaller))
 }
+
+func (suite *MarshallerTestSuite) testReadDirect(bufSize int) {
+	var m BinaryMarshaller
+	var buf bytes.Buffer
+	for i, header := range suite.headers {
+		suite.write(m, &buf, header, suite.samples[i])
+	}
+
+	rdr := bufio.NewReaderSize(&countingBuf{data: buf.Bytes()}, bufSize)
+	for i, expectedHeader := range suite.headers {
+		header, sample, err := m.ReadSample(rdr, nil, 0)
+		suite.NoError(err)
+		suite.Nil(sample)
+		suite.compareUnmarshalledHeaders(expectedHeader, header)
+
+		for _, expectedSample := range suite.samples[i] {
+			nilHeader, sample, err := m.ReadSample(rdr, header, len(expectedSample.Values)+3)
+			suite.NoError(err)
+			suite.Nil(nilHeader)
+			suite.Equal(expectedSample.TagString(), sample.TagString())
+			suite.Len(sample.Values, len(expectedSample.Values))
+			for j, value := range expectedSample.Values {
+				suite.Equal(value, sample.Values[j])
+			}
+			suite.True(cap(sample.Values) >= len(expectedSample.Values)+3, "Sample.Values capacity")
+			suite.True(expectedSample.Time.Equal(sample.Time))
+		}
+	}
+	header, sample, err := m.ReadSample(rdr, suite.headers[len(suite.headers)-1], 0)
+	suite.Nil(header)
+	suite.Nil(sample)
+	suite.Equal(io.EOF, err)
+}
+
+func (suite *MarshallerTestSuite) TestBinaryMarshallerDirect() {
+	suite.testReadDirect(4096)
+}
+
+func (suite *MarshallerTestSuite) TestBinaryMarshallerDirectSmallBuffer() {
+	// The tags and values do not fit into the buffer of the reader
+	suite.testReadDirect(MinimumInputIoBuffer)
+}
+
+func (suite *MarshallerTestSuite) TestBinaryMarshallerDirectTruncated() {
+	var m BinaryMarshaller
+	header := &UnmarshalledHeader{Header: Header{Fields: []string{"a", "b"}}}
+	var buf bytes.Buffer
+	suite.write(m, &buf, header, []*Sample{{Values: []Value{1, 2}}})
+	data := buf.Bytes()[:buf.Len()-3]
+
+	rdr := bufio.NewReader(bytes.NewReader(data))
+	readHeader, _, err := m.ReadSample(rdr, nil, 0)
+	suite.NoError(err)
+	_, sample, err := m.ReadSample(rdr, readHeader, 0)
+	suite.Nil(sample)
+	suite.Equal(io.ErrUnexpectedEOF, err)
+}
+
+func makeBenchmarkData(b *testing.B, numFields int) []byte {
+	header := &UnmarshalledHeader{HasTags: true}
+	for i := 0; i < numFields; i++ {
+		header.Fields = append(header.Fields, fmt.Sprintf("field-%v", i))
+	}
+	var m BinaryMarshaller
+	var buf bytes.Buffer
+	if err := m.WriteHeader(&header.Header, true, &buf); err != nil {
+		b.Fatal(err)
+	}
+	sample := &Sample{Values: make([]Value, numFields), Time: time.Now()}
+	sample.SetTag("host", "benchmark")
+	for i := 0; i < b.N; i++ {
+		if err := m.WriteSample(sample, &header.Header, true, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func benchmarkBinaryRead(b *testing.B, numFields int, direct bool) {
+	data := makeBenchmarkData(b, numFields)
+	var m BinaryMarshaller
+	rdr := bufio.NewReaderSize(bytes.NewReader(data), 4096)
+	header, _, err := m.Read(rdr, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data) / b.N))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var sample *Sample
+		if direct {
+			_, sample, err = m.ReadSample(rdr, header, 0)
+		} else {
+			var sampleData []byte
+			if _, sampleData, err = m.Read(rdr, header); err == nil {
+				sample, err = m.ParseSample(header, 0, sampleData)
+				m.ReleaseData(sampleData)
+			}
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		sample.Release()
+	}
+}
+
+func BenchmarkBinaryReadAndParse10(b *testing.B) {
+	benchmarkBinaryRead(b, 10, false)
+}
+
+func BenchmarkBinaryReadDirect10(b *testing.B) {
+	benchmarkBinaryRead(b, 10, true)
+}
+
+func BenchmarkBinaryReadAndParse1000(b *testing.B) {
+	benchmarkBinaryRead(b, 1000, false)
+}
+
+func BenchmarkBinaryReadDirect1000(b *testing.B) {
+	benchmarkBinaryRead(b, 1000, true)
+}
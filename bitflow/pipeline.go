@@ -16,6 +16,11 @@ type SamplePipeline struct {
 	Source     SampleSource
 	Processors []SampleProcessor
 
+	// Name and ForkPath are added to the log entries of all steps, see LogContext.
+	// ForkPath is set for subpipelines of forks.
+	Name     string
+	ForkPath []string
+
 	lastProcessor SampleProcessor
 }
 
@@ -29,21 +34,32 @@ type SamplePipeline struct {
 //
 // Additionally, all SampleProcessor instances will be wrapped in small wrapper objects
 // that ensure that the samples and headers forwarded between the processors are consistent.
+// The wrappers also count the forwarded samples in DefaultPipelineStatistics, unregister the processors
+// from DefaultPipelineStatistics when they are closed.
+//
+// If none of the SampleProcessors retains samples (see RetainingProcessor), the samples reaching the
+// end of the pipeline are released, so that pooled samples can be reused (see AllocateSample).
+//
+// The loggers of all steps implementing LoggingProcessor are initialized with the Name and ForkPath of the pipeline.
 func (p *SamplePipeline) Construct(tasks *golib.TaskGroup) {
 	firstSource := p.Source
 	if firstSource == nil {
 		firstSource = new(EmptySampleSource)
 	}
+	initStepLogger(firstSource, p.logContext(firstSource))
 
 	// First connect all sources with their sinks
 	source := firstSource
+	releaseSamples := true
 	for _, processor := range p.Processors {
 		if processor != nil {
+			initStepLogger(processor, p.logContext(processor))
+			releaseSamples = releaseSamples && !RetainsSamples(processor)
 			if resizingProcessor, ok := processor.(ResizingSampleProcessor); ok {
-				wrapper := &resizingProcessorWrapper{sinkWrapper{false}, resizingProcessor}
+				wrapper := &resizingProcessorWrapper{sinkWrapper{stats: DefaultPipelineStatistics.Register(processor)}, resizingProcessor}
 				processor = wrapper
 			} else {
-				wrapper := &processorWrapper{sinkWrapper{false}, processor}
+				wrapper := &processorWrapper{sinkWrapper{stats: DefaultPipelineStatistics.Register(processor)}, processor}
 				processor = wrapper
 			}
 			source.SetSink(processor)
@@ -53,7 +69,7 @@ func (p *SamplePipeline) Construct(tasks *golib.TaskGroup) {
 
 	// Make sure every SampleProcessor has a non-nil sink
 	lastSink := new(DroppingSampleProcessor)
-	source.SetSink(&processorWrapper{sinkWrapper{true}, lastSink})
+	source.SetSink(&processorWrapper{sinkWrapper{dropSamples: true, releaseSamples: releaseSamples}, lastSink})
 
 	// Then add all tasks in reverse: start the final processor first.
 	// Each processor must be started before the source can push data into it.
@@ -67,6 +83,10 @@ func (p *SamplePipeline) Construct(tasks *golib.TaskGroup) {
 	tasks.Add(&SourceTaskWrapper{firstSource})
 }
 
+func (p *SamplePipeline) logContext(step SampleSource) LogContext {
+	return LogContext{Pipeline: p.Name, ForkPath: p.ForkPath, Step: step.String()}
+}
+
 // Add adds the SampleProcessor parameter to the list of SampleProcessors in the
 // receiving SamplePipeline. The Source field must be accessed directly.
 // The Processors field can also be accessed directly, but the Add method allows
@@ -137,21 +157,18 @@ func (p *SamplePipeline) FormatLines() []string {
 //   // ... Set p.Source using f.CreateSource()
 //   os.Exit(p.StartAndWait()) // os.Exit() should be called in an outer method if 'defer' is used here
 //
-// An additional golib.Task is started along with the pipeline, which listens
-// for the Ctrl-C user external interrupt and makes the pipeline stoppable cleanly
-// by the user.
+// The pipeline is executed through WaitAndDrain(), which listens for the ShutdownSignals
+// (Ctrl-C and SIGTERM) and makes the pipeline stoppable cleanly by the user.
 //
 // StartAndWait returns the number of errors that occurred in the pipeline.
 func (p *SamplePipeline) StartAndWait(extraTasks ...golib.Task) int {
 	var tasks golib.TaskGroup
 	p.Construct(&tasks)
 	log.Debugln("Press Ctrl-C to interrupt")
-	tasks.Add(&golib.NoopTask{
-		Chan:        golib.ExternalInterrupt(),
-		Description: "external interrupt",
-	})
 	tasks.Add(extraTasks...)
-	return tasks.PrintWaitAndStop()
+	reason, numErrors := WaitAndDrain(tasks)
+	log.Debugln("Stopped because of", reason)
+	return numErrors
 }
 
 // ProcessorTaskWrapper can be used to convert an instance of SampleProcessor to a golib.Task.
@@ -176,6 +193,30 @@ func (t *SourceTaskWrapper) Stop() {
 	t.Close()
 }
 
+// IsReadOnlyChain returns true, if the given SampleProcessor and all SampleProcessors following it in the
+// constructed SamplePipeline are read-only, see IsReadOnly(). This means that the samples forwarded to
+// the given SampleProcessor are not modified anymore.
+func IsReadOnlyChain(processor SampleProcessor) bool {
+	for processor != nil {
+		if wrapper, ok := processor.(wrappedProcessor); ok {
+			var drops bool
+			processor, drops = wrapper.unwrap()
+			if drops {
+				return true
+			}
+		}
+		if !IsReadOnly(processor) {
+			return false
+		}
+		processor = processor.GetSink()
+	}
+	return true
+}
+
+type wrappedProcessor interface {
+	unwrap() (processor SampleProcessor, dropSamples bool)
+}
+
 type processorWrapper struct {
 	sinkWrapper
 	SampleProcessor
@@ -185,6 +226,15 @@ func (p *processorWrapper) Sample(sample *Sample, header *Header) error {
 	return p.forwardSample(p.SampleProcessor, sample, header)
 }
 
+func (p *processorWrapper) Close() {
+	p.SampleProcessor.Close()
+	DefaultPipelineStatistics.Unregister(p.stats)
+}
+
+func (p *processorWrapper) unwrap() (SampleProcessor, bool) {
+	return p.SampleProcessor, p.dropSamples
+}
+
 type resizingProcessorWrapper struct {
 	sinkWrapper
 	ResizingSampleProcessor
@@ -194,12 +244,26 @@ func (p *resizingProcessorWrapper) Sample(sample *Sample, header *Header) error
 	return p.forwardSample(p.ResizingSampleProcessor, sample, header)
 }
 
+func (p *resizingProcessorWrapper) Close() {
+	p.ResizingSampleProcessor.Close()
+	DefaultPipelineStatistics.Unregister(p.stats)
+}
+
+func (p *resizingProcessorWrapper) unwrap() (SampleProcessor, bool) {
+	return p.ResizingSampleProcessor, p.dropSamples
+}
+
 type sinkWrapper struct {
-	dropSamples bool
+	dropSamples    bool
+	releaseSamples bool
+	stats          *ProcessorStatistics
 }
 
 func (w *sinkWrapper) forwardSample(p SampleProcessor, sample *Sample, header *Header) error {
 	if w.dropSamples {
+		if w.releaseSamples && sample != nil {
+			sample.Release()
+		}
 		return nil
 	}
 	if p.GetSink() == nil {
@@ -215,5 +279,6 @@ func (w *sinkWrapper) forwardSample(p SampleProcessor, sample *Sample, header *H
 		return fmt.Errorf("Unexpected number of values in sample: %v, expected %v",
 			len(sample.Values), len(header.Fields))
 	}
+	w.stats.countSample()
 	return p.Sample(sample, header)
 }
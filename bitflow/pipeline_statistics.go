@@ -0,0 +1,129 @@
+package bitflow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPipelineStatistics collects the ProcessorStatistics of all SampleProcessors
+// of all SamplePipelines that were constructed in this process. It can be used to monitor
+// the operation of the running pipeline(s).
+var DefaultPipelineStatistics = new(PipelineStatistics)
+
+// QueueingProcessor can be implemented by SampleProcessors that buffer samples internally,
+// e.g. in a channel. The result of QueueLength() is reported as part of the ProcessorStatistics.
+type QueueingProcessor interface {
+	SampleProcessor
+	QueueLength() int
+}
+
+// ProcessorStatistics counts the samples that were forwarded into one SampleProcessor.
+type ProcessorStatistics struct {
+	Processor SampleProcessor
+
+	samples  uint64
+	finished uint32
+}
+
+// Samples returns the number of samples that were forwarded into the processor so far.
+func (s *ProcessorStatistics) Samples() uint64 {
+	return atomic.LoadUint64(&s.samples)
+}
+
+// QueueLength returns the number of samples queued inside the processor, if it implements
+// the QueueingProcessor interface. Otherwise, the result is -1.
+func (s *ProcessorStatistics) QueueLength() int {
+	if queue, ok := s.Processor.(QueueingProcessor); ok {
+		return queue.QueueLength()
+	}
+	return -1
+}
+
+func (s *ProcessorStatistics) isFinished() bool {
+	return atomic.LoadUint32(&s.finished) != 0 && s.QueueLength() <= 0
+}
+
+func (s *ProcessorStatistics) countSample() {
+	if s != nil {
+		atomic.AddUint64(&s.samples, 1)
+	}
+}
+
+// PipelineStatistics stores a list of ProcessorStatistics instances in the order
+// they were registered.
+type PipelineStatistics struct {
+	lock       sync.Mutex
+	processors []*ProcessorStatistics
+	started    time.Time
+}
+
+// Register creates and stores a new ProcessorStatistics instance for the given SampleProcessor.
+func (p *PipelineStatistics) Register(processor SampleProcessor) *ProcessorStatistics {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.started.IsZero() {
+		p.started = time.Now()
+	}
+	stats := &ProcessorStatistics{Processor: processor}
+	p.processors = append(p.processors, stats)
+	return stats
+}
+
+// Unregister marks the given ProcessorStatistics as finished, e.g. after the processor was closed.
+// It is removed from the list of processors as soon as the processor has no more queued samples,
+// so that processors that are still draining their queue continue to be reported.
+func (p *PipelineStatistics) Unregister(stats *ProcessorStatistics) {
+	if stats != nil {
+		atomic.StoreUint32(&stats.finished, 1)
+	}
+}
+
+// UnregisterPipeline unregisters the ProcessorStatistics of all SampleProcessors of the given pipeline,
+// e.g. after the pipeline was stopped and removed. See Unregister.
+func (p *PipelineStatistics) UnregisterPipeline(pipe *SamplePipeline) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, stats := range p.processors {
+		for _, processor := range pipe.Processors {
+			if stats.Processor == processor {
+				p.Unregister(stats)
+			}
+		}
+	}
+}
+
+// Processors returns a copy of the list of all registered ProcessorStatistics.
+// Finished processors are removed from the list.
+func (p *PipelineStatistics) Processors() []*ProcessorStatistics {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	running := p.processors[:0]
+	for _, stats := range p.processors {
+		if !stats.isFinished() {
+			running = append(running, stats)
+		}
+	}
+	for i := len(running); i < len(p.processors); i++ {
+		p.processors[i] = nil
+	}
+	p.processors = running
+	res := make([]*ProcessorStatistics, len(p.processors))
+	copy(res, p.processors)
+	return res
+}
+
+// Started returns the time when the first processor was registered.
+func (p *PipelineStatistics) Started() time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.started
+}
+
+// Clear removes all registered ProcessorStatistics, e.g. after the pipeline was stopped.
+func (p *PipelineStatistics) Clear() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.processors = nil
+	p.started = time.Time{}
+}
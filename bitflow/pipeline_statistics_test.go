@@ -0,0 +1,107 @@
+package bitflow
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/antongulenko/golib"
+	"github.com/stretchr/testify/suite"
+)
+
+type PipelineStatisticsTestSuite struct {
+	testSuiteBase
+}
+
+func TestPipelineStatistics(t *testing.T) {
+	suite.Run(t, new(PipelineStatisticsTestSuite))
+}
+
+func (suite *PipelineStatisticsTestSuite) SetupTest() {
+	DefaultPipelineStatistics.Clear()
+}
+
+func (suite *PipelineStatisticsTestSuite) TearDownTest() {
+	DefaultPipelineStatistics.Clear()
+}
+
+// queueingTestProcessor reports a configurable queue length
+type queueingTestProcessor struct {
+	NoopProcessor
+	queue int32
+}
+
+func (p *queueingTestProcessor) QueueLength() int {
+	return int(atomic.LoadInt32(&p.queue))
+}
+
+// pipelineRun is a constructed and started pipeline with an EmptySampleSource, where samples are sent
+// directly into the first processor.
+type pipelineRun struct {
+	source *EmptySampleSource
+	tasks  golib.TaskGroup
+	wg     sync.WaitGroup
+}
+
+func (suite *PipelineStatisticsTestSuite) start(processors ...SampleProcessor) *pipelineRun {
+	run := &pipelineRun{source: new(EmptySampleSource)}
+	pipe := &SamplePipeline{Source: run.source, Processors: processors}
+	pipe.Construct(&run.tasks)
+	run.tasks.StartTasks(&run.wg)
+	return run
+}
+
+func (suite *PipelineStatisticsTestSuite) send(run *pipelineRun, num int) {
+	header := &Header{Fields: []string{"a"}}
+	for i := 0; i < num; i++ {
+		suite.NoError(run.source.GetSink().Sample(&Sample{Values: []Value{Value(i)}}, header))
+	}
+}
+
+func (suite *PipelineStatisticsTestSuite) stop(run *pipelineRun) {
+	run.tasks.Stop()
+	run.wg.Wait()
+}
+
+func (suite *PipelineStatisticsTestSuite) TestCountSamples() {
+	first, second := new(NoopProcessor), new(NoopProcessor)
+	run := suite.start(first, second)
+	suite.send(run, 3)
+
+	processors := DefaultPipelineStatistics.Processors()
+	suite.Len(processors, 2)
+	suite.True(processors[0].Processor == first)
+	suite.True(processors[1].Processor == second)
+	suite.Equal(uint64(3), processors[0].Samples())
+	suite.Equal(uint64(3), processors[1].Samples())
+	suite.Equal(-1, processors[0].QueueLength())
+	suite.False(DefaultPipelineStatistics.Started().IsZero())
+
+	suite.stop(run)
+	suite.Empty(DefaultPipelineStatistics.Processors(), "Closed processors must be unregistered")
+}
+
+func (suite *PipelineStatisticsTestSuite) TestNoLeakAfterRestart() {
+	for i := 0; i < 5; i++ {
+		run := suite.start(new(NoopProcessor), new(NoopProcessor))
+		suite.send(run, 1)
+		suite.Len(DefaultPipelineStatistics.Processors(), 2, "Only the processors of the running pipeline must be registered")
+		suite.stop(run)
+	}
+	suite.Empty(DefaultPipelineStatistics.Processors())
+	suite.Equal(0, QueuedSamples(DefaultPipelineStatistics))
+}
+
+func (suite *PipelineStatisticsTestSuite) TestKeepQueueingProcessor() {
+	queue := &queueingTestProcessor{queue: 5}
+	run := suite.start(new(NoopProcessor), queue)
+	suite.stop(run)
+
+	processors := DefaultPipelineStatistics.Processors()
+	suite.Len(processors, 1, "A closed processor with queued samples must still be reported")
+	suite.True(processors[0].Processor == queue)
+	suite.Equal(5, QueuedSamples(DefaultPipelineStatistics))
+
+	atomic.StoreInt32(&queue.queue, 0)
+	suite.Empty(DefaultPipelineStatistics.Processors())
+}
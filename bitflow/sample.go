@@ -45,13 +45,19 @@ func (v Value) String() string {
 type Header struct {
 	// Fields defines the names of the metrics of samples belonging to this header.
 	Fields []string
+
+	// TraceParent is the W3C trace context of the batch or window that produced the samples belonging to this header,
+	// or empty if they are not traced (see tracing.go). It is transported over TCP by the binary marshaller and
+	// is not considered by Equals().
+	TraceParent string
 }
 
 // Clone creates a copy of the Header receiver, using a new string-array as
 // the header fields.
 func (h *Header) Clone(newFields []string) *Header {
 	return &Header{
-		Fields: newFields,
+		Fields:      newFields,
+		TraceParent: h.TraceParent,
 	}
 }
 
@@ -86,6 +92,10 @@ type Sample struct {
 	tagsLock    sync.RWMutex
 	tags        map[string]string
 	orderedTags []string // All keys from tags, with consistent ordering
+
+	// Reference counting for samples allocated through AllocateSample()
+	pooled bool
+	refs   int32
 }
 
 func (sample *Sample) lockRead(do func()) {
@@ -306,11 +316,10 @@ func (sample *Sample) Clone() *Sample {
 }
 
 // DeepClone returns a deep copy of the receiving sample, including the timestamp,
-// tags and actual metric values.
+// tags and actual metric values. The copy is allocated through AllocateSample().
 func (sample *Sample) DeepClone() *Sample {
-	result := &Sample{
-		Values: make([]Value, len(sample.Values), cap(sample.Values)),
-	}
+	result := AllocateSample(cap(sample.Values))
+	result.Values = result.Values[:len(sample.Values)]
 	result.CopyMetadataFrom(sample)
 	copy(result.Values, sample.Values)
 	return result
@@ -420,14 +429,17 @@ type SampleAndHeader struct {
 	*Header
 }
 
-const TAG_TEMPLATE_ENV_PREFIX = "ENV_"
+const (
+	TAG_TEMPLATE_ENV_PREFIX  = "ENV_"
+	TAG_TEMPLATE_TIME_PREFIX = "time:"
+)
 
 func ResolveTagTemplate(template string, missingValues string, sample *Sample) string {
 	return TagTemplate{Template: template, MissingValue: missingValues}.Resolve(sample)
 }
 
 type TagTemplate struct {
-	Template      string // Placeholders like ${xxx} will be replaced by tag values. Values matching ENV_* will be replaced by the environment variable.
+	Template      string // Placeholders like ${xxx} will be replaced by tag values. Values matching ENV_* will be replaced by the environment variable, values like time:<layout> by the sample timestamp formatted with the Go time layout.
 	MissingValue  string // Replacement for missing values
 	IgnoreEnvVars bool   // Set to true to not treat ENV_ replacement templates specially
 }
@@ -443,6 +455,8 @@ func (t TagTemplate) Resolve(sample *Sample) string {
 			if env, isSet := os.LookupEnv(placeholder[len(TAG_TEMPLATE_ENV_PREFIX):]); isSet {
 				return env
 			}
+		} else if strings.HasPrefix(placeholder, TAG_TEMPLATE_TIME_PREFIX) {
+			return sample.Time.Format(placeholder[len(TAG_TEMPLATE_TIME_PREFIX):])
 		}
 		return t.MissingValue
 	})
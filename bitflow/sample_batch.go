@@ -1,12 +1,14 @@
 package bitflow
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/antongulenko/golib"
-	log "github.com/sirupsen/logrus"
 )
 
 type BatchProcessor struct {
@@ -23,6 +25,11 @@ type BatchProcessor struct {
 	lastSample                  time.Time // Wall time when receiving last sample
 	lastSampleTimestamp         time.Time // Timestamp of last sample
 
+	MemoryBudget int64  // If > 0, buffered samples are spilled to disk when their estimated size exceeds this number of bytes. Default: DefaultBatchMemoryBudget
+	SpillDir     string // Directory for spilled samples. Default: BatchSpillDir
+	memoryUsage  int64
+	spill        *batchSpill
+
 	FlushTags     []string // If set, flush every time any of these tags change
 	lastFlushTags []string
 	flushHeader   *Header
@@ -30,6 +37,8 @@ type BatchProcessor struct {
 	flushError    error
 }
 
+// BatchProcessingStep is executed on every batch of samples. Steps that implement io.Closer are closed
+// when the BatchProcessor is closed, after the final batch was processed.
 type BatchProcessingStep interface {
 	ProcessBatch(header *Header, samples []*Sample) (*Header, []*Sample, error)
 	String() string
@@ -99,6 +108,9 @@ func (p *BatchProcessor) Sample(sample *Sample, header *Header) (err error) {
 		p.lastAutoFlushError = nil
 	}
 	p.samples = append(p.samples, sample)
+	if spillErr := p.accountSample(sample, header); err == nil {
+		err = spillErr
+	}
 	return
 }
 
@@ -106,11 +118,74 @@ func (p *BatchProcessor) Close() {
 	defer p.NoopProcessor.Close()
 	header := p.checker.LastHeader
 	if header == nil {
-		log.Warnln(p.String(), "received no samples")
+		p.Log().Warnln("Received no samples")
 	}
 	if err := p.triggerFlush(header, true); err != nil {
 		p.Error(err)
 	}
+	p.closeSteps()
+}
+
+// closeSteps releases the resources of all steps that implement io.Closer, after the final flush.
+func (p *BatchProcessor) closeSteps() {
+	for _, step := range p.Steps {
+		if closer, ok := step.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				p.Log().Warnf("Failed to close batch step %v: %v", step, err)
+			}
+		}
+	}
+}
+
+// StoreState implements the StatefulProcessor interface by storing the buffered samples that were not flushed yet.
+// A batch that was partially spilled to disk cannot be stored.
+func (p *BatchProcessor) StoreState() ([]byte, error) {
+	if p.flushTrigger != nil {
+		// Synchronize with automatic flushes
+		p.flushTrigger.L.Lock()
+		defer p.flushTrigger.L.Unlock()
+	}
+	if p.spill != nil {
+		return nil, fmt.Errorf("%v: Cannot store a batch that was spilled to disk", p)
+	}
+	header := p.checker.LastHeader
+	if header == nil || len(p.samples) == 0 {
+		return nil, nil
+	}
+	samples, err := encodeSamples(header, p.samples)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(samples)
+}
+
+// RestoreState implements the StatefulProcessor interface. The restored samples are flushed with the next batch.
+func (p *BatchProcessor) RestoreState(state []byte) error {
+	var data []byte
+	if err := json.Unmarshal(state, &data); err != nil {
+		return err
+	}
+	header, samples, err := decodeSamples(data)
+	if err != nil {
+		return err
+	}
+	p.checker.LastHeader = header
+	p.samples = samples
+	p.memoryUsage = 0
+	for _, sample := range samples {
+		p.memoryUsage += EstimateSampleSize(sample)
+	}
+	if len(samples) > 0 {
+		last := samples[len(samples)-1]
+		p.lastSampleTimestamp = last.Time
+		if len(p.FlushTags) > 0 {
+			p.lastFlushTags = make([]string, len(p.FlushTags))
+			for i, tag := range p.FlushTags {
+				p.lastFlushTags[i] = last.Tag(tag)
+			}
+		}
+	}
+	return nil
 }
 
 func (p *BatchProcessor) triggerFlush(header *Header, shutdown bool) error {
@@ -147,7 +222,7 @@ func (p *BatchProcessor) waitAndExecuteFlush() bool {
 		// Automatic flush after timeout
 		err := p.executeFlush(p.checker.LastHeader)
 		if err != nil {
-			log.Errorf("%v: Error during automatic flush (will be returned when next sample arrives): %v", p, err)
+			p.Log().Errorln("Error during automatic flush (will be returned when next sample arrives):", err)
 			p.lastAutoFlushError = fmt.Errorf("Error during previous auto-flush: %v", err)
 		}
 		p.lastSample = time.Now()
@@ -167,45 +242,70 @@ func (p *BatchProcessor) flushTimedOut() bool {
 }
 
 func (p *BatchProcessor) executeFlush(header *Header) error {
-	samples := p.samples
-	if len(samples) == 0 || header == nil {
+	samples, spill := p.samples, p.spill
+	if (len(samples) == 0 && spill == nil) || header == nil {
 		return nil
 	}
-	p.samples = nil // Allow garbage collection
-	if samples, header, err := p.executeSteps(samples, header); err != nil {
-		return err
-	} else {
-		if header == nil {
-			return fmt.Errorf("Cannot flush %v samples because nil-header was returned by last batch processing step", len(samples))
+	numSamples := len(samples)
+	if spill != nil {
+		for _, run := range spill.runs {
+			numSamples += run.samples
 		}
-		if len(samples) > 0 {
-			log.Println("Flushing", len(samples), "batched samples with", len(header.Fields), "metrics")
-			for _, sample := range samples {
-				if err := p.NoopProcessor.Sample(sample, header); err != nil {
-					return fmt.Errorf("Error flushing batch: %v", err)
-				}
+	}
+	p.samples = nil // Allow garbage collection
+	p.spill = nil
+	p.memoryUsage = 0
+	ctx, span := startBatchSpan(p.String(), header, numSamples)
+	var err error
+	if spill != nil {
+		err = p.flushSpilled(ctx, spill, header, samples)
+	} else if samples, header, err = p.runSteps(ctx, p.Steps, samples, header); err == nil {
+		err = p.forwardBatch(ctx, header, samples)
+	}
+	finishSpan(span, err)
+	return err
+}
+
+func (p *BatchProcessor) forwardBatch(ctx context.Context, header *Header, samples []*Sample) error {
+	if header == nil {
+		return fmt.Errorf("Cannot flush %v samples because nil-header was returned by last batch processing step", len(samples))
+	}
+	header = TracedHeader(ctx, header)
+	if len(samples) > 0 {
+		p.Log().Println("Flushing", len(samples), "batched samples with", len(header.Fields), "metrics")
+		for _, sample := range samples {
+			if err := p.NoopProcessor.Sample(sample, header); err != nil {
+				return fmt.Errorf("Error flushing batch: %v", err)
 			}
 		}
-		return nil
 	}
+	return nil
 }
 
 func (p *BatchProcessor) executeSteps(samples []*Sample, header *Header) ([]*Sample, *Header, error) {
-	if len(p.Steps) > 0 {
-		log.Debugln("Executing", len(p.Steps), "batch processing step(s)")
-		for i, step := range p.Steps {
-			if len(samples) == 0 {
-				log.Warnln("Cannot execute remaining", len(p.Steps)-i, "batch step(s) because the batch with", len(header.Fields), "has no samples")
+	return p.runSteps(context.Background(), p.Steps, samples, header)
+}
+
+// runSteps executes the given steps on the batch. Every step is traced as a child span of the given context.
+func (p *BatchProcessor) runSteps(ctx context.Context, steps []BatchProcessingStep, samples []*Sample, header *Header) ([]*Sample, *Header, error) {
+	if len(steps) > 0 {
+		p.Log().Debugln("Executing", len(steps), "batch processing step(s)")
+		execution := batchExecution{header: header, samples: samples}
+		for i, step := range steps {
+			if execution.numSamples() == 0 {
+				p.Log().Warnln("Cannot execute remaining", len(steps)-i, "batch step(s) because the batch with", execution.numFields(), "has no samples")
 				break
 			} else {
-				log.Println("Executing", step, "on", len(samples), "samples with", len(header.Fields), "metrics")
-				var err error
-				header, samples, err = step.ProcessBatch(header, samples)
-				if err != nil {
+				p.Log().Println("Executing", step, "on", execution.numSamples(), "samples with", execution.numFields(), "metrics")
+				if err := execution.executeTraced(ctx, step); err != nil {
 					return nil, nil, err
 				}
 			}
 		}
+		var err error
+		if header, samples, err = execution.result(); err != nil {
+			return nil, nil, err
+		}
 	}
 	return samples, header, nil
 }
@@ -225,6 +325,9 @@ func (p *BatchProcessor) String() string {
 	if p.SampleTimestampFlushTimeout > 0 {
 		flushed += fmt.Sprintf(", flushed when sample timestamp difference over %v", p.SampleTimestampFlushTimeout)
 	}
+	if p.MemoryBudget > 0 {
+		flushed += fmt.Sprintf(", spilled to disk over %v bytes", p.MemoryBudget)
+	}
 	return fmt.Sprintf("BatchProcessor (%v step%s%s)", len(p.Steps), extra, flushed)
 }
 
@@ -239,7 +342,9 @@ func (p *BatchProcessor) MergeProcessor(other SampleProcessor) bool {
 
 func (p *BatchProcessor) compatibleParameters(other *BatchProcessor) bool {
 	if (other.FlushTimeout != 0 && other.FlushTimeout != p.FlushTimeout) ||
-		(other.SampleTimestampFlushTimeout != 0 && other.SampleTimestampFlushTimeout != p.SampleTimestampFlushTimeout) {
+		(other.SampleTimestampFlushTimeout != 0 && other.SampleTimestampFlushTimeout != p.SampleTimestampFlushTimeout) ||
+		(other.MemoryBudget != 0 && other.MemoryBudget != p.MemoryBudget) ||
+		(other.SpillDir != "" && other.SpillDir != p.SpillDir) {
 		return false
 	}
 	if len(other.FlushTags) == 0 {
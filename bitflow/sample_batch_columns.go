@@ -0,0 +1,198 @@
+package bitflow
+
+import (
+	"fmt"
+)
+
+// ColumnBatch is a columnar representation of a batch of samples. The values of all samples are stored in one
+// contiguous slice, column after column: the values of the metric Header.Fields[i] are stored in
+// Values[i*Len() : (i+1)*Len()]. This allows numeric batch processing steps to iterate over the values of one metric
+// in cache-friendly loops, and to hand the values over to gonum without copying them.
+// The timestamps and tags of the samples are kept in the Samples slice. The Values of these samples are outdated
+// while the batch is in the columnar representation and are overwritten by ToSamples().
+type ColumnBatch struct {
+	Header  *Header
+	Samples []*Sample
+	Values  []float64
+}
+
+// NewColumnBatch converts the given samples to the columnar representation. The samples are not copied, but their
+// Values are replaced when converting the batch back through ToSamples().
+func NewColumnBatch(header *Header, samples []*Sample) (*ColumnBatch, error) {
+	numSamples := len(samples)
+	values := make([]float64, len(header.Fields)*numSamples)
+	for row, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return nil, fmt.Errorf("Sample %v has %v values, but the header has %v fields", row, len(sample.Values), len(header.Fields))
+		}
+		for col, value := range sample.Values {
+			values[col*numSamples+row] = float64(value)
+		}
+	}
+	return &ColumnBatch{
+		Header:  header,
+		Samples: samples,
+		Values:  values,
+	}, nil
+}
+
+// Len returns the number of samples in the batch.
+func (b *ColumnBatch) Len() int {
+	return len(b.Samples)
+}
+
+// Column returns the values of the metric with the given index. The returned slice refers to the Values of the batch.
+func (b *ColumnBatch) Column(index int) []float64 {
+	num := b.Len()
+	return b.Values[index*num : (index+1)*num : (index+1)*num]
+}
+
+// Columns returns the values of all metrics, see Column().
+func (b *ColumnBatch) Columns() [][]float64 {
+	res := make([][]float64, len(b.Header.Fields))
+	for i := range res {
+		res[i] = b.Column(i)
+	}
+	return res
+}
+
+// ToSamples writes the values of the batch back into the Samples and returns them. The Values slices of the
+// samples are reused, if they are large enough.
+func (b *ColumnBatch) ToSamples() (*Header, []*Sample, error) {
+	if b.Header == nil {
+		return nil, nil, fmt.Errorf("Cannot convert %v columnar samples without a header", b.Len())
+	}
+	numFields, numSamples := len(b.Header.Fields), b.Len()
+	if len(b.Values) != numFields*numSamples {
+		return nil, nil, fmt.Errorf("Columnar batch has %v values, but expected %v (%v samples with %v fields)", len(b.Values), numFields*numSamples, numSamples, numFields)
+	}
+	for row, sample := range b.Samples {
+		if cap(sample.Values) >= numFields {
+			sample.Values = sample.Values[:numFields]
+		} else {
+			sample.Values = make([]Value, numFields)
+		}
+		for col := range sample.Values {
+			sample.Values[col] = Value(b.Values[col*numSamples+row])
+		}
+	}
+	return b.Header, b.Samples, nil
+}
+
+// ColumnBatchProcessingStep is a BatchProcessingStep that can also process the columnar representation of a batch.
+// The BatchProcessor and the WindowProcessor prefer ProcessColumns() and only convert between the two
+// representations when a step of the other kind follows. The returned batch can be the modified input batch.
+// ProcessBatch() is still required for code that does not support the columnar representation, but
+// it can be implemented through ProcessColumnsAsBatch().
+type ColumnBatchProcessingStep interface {
+	BatchProcessingStep
+	ProcessColumns(batch *ColumnBatch) (*ColumnBatch, error)
+}
+
+// ProcessColumnsAsBatch converts the given samples to the columnar representation, executes the given step and
+// converts the result back.
+func ProcessColumnsAsBatch(step ColumnBatchProcessingStep, header *Header, samples []*Sample) (*Header, []*Sample, error) {
+	batch, err := NewColumnBatch(header, samples)
+	if err != nil {
+		return nil, nil, err
+	}
+	batch, err = step.ProcessColumns(batch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return batch.ToSamples()
+}
+
+// batchExecution executes a sequence of BatchProcessingSteps on a batch and keeps the batch in the columnar
+// representation between consecutive ColumnBatchProcessingSteps.
+type batchExecution struct {
+	header  *Header
+	samples []*Sample
+	columns *ColumnBatch
+}
+
+func (e *batchExecution) numSamples() int {
+	if e.columns != nil {
+		return e.columns.Len()
+	}
+	return len(e.samples)
+}
+
+func (e *batchExecution) numFields() int {
+	header := e.header
+	if e.columns != nil {
+		header = e.columns.Header
+	}
+	if header == nil {
+		return 0
+	}
+	return len(header.Fields)
+}
+
+func (e *batchExecution) execute(step BatchProcessingStep) (err error) {
+	if columnStep, ok := step.(ColumnBatchProcessingStep); ok {
+		if e.columns == nil {
+			if e.columns, err = NewColumnBatch(e.header, e.samples); err != nil {
+				return err
+			}
+		}
+		e.columns, err = columnStep.ProcessColumns(e.columns)
+		if err == nil && e.columns == nil {
+			err = fmt.Errorf("%v returned no batch", step)
+		}
+		return err
+	}
+	if err = e.convertToSamples(); err != nil {
+		return err
+	}
+	e.header, e.samples, err = step.ProcessBatch(e.header, e.samples)
+	return err
+}
+
+func (e *batchExecution) convertToSamples() (err error) {
+	if e.columns != nil {
+		e.header, e.samples, err = e.columns.ToSamples()
+		e.columns = nil
+	}
+	return
+}
+
+func (e *batchExecution) result() (*Header, []*Sample, error) {
+	err := e.convertToSamples()
+	return e.header, e.samples, err
+}
+
+// ==================== Simple implementation ====================
+
+type SimpleColumnBatchProcessingStep struct {
+	Description          string
+	Process              func(batch *ColumnBatch) (*ColumnBatch, error)
+	OutputSampleSizeFunc func(sampleSize int) int
+}
+
+func (s *SimpleColumnBatchProcessingStep) ProcessColumns(batch *ColumnBatch) (*ColumnBatch, error) {
+	if process := s.Process; process == nil {
+		return nil, fmt.Errorf("%v: Process function is not set", s)
+	} else {
+		return process(batch)
+	}
+}
+
+func (s *SimpleColumnBatchProcessingStep) ProcessBatch(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+	return ProcessColumnsAsBatch(s, header, samples)
+}
+
+func (s *SimpleColumnBatchProcessingStep) String() string {
+	if s.Description == "" {
+		return "SimpleColumnBatchProcessingStep"
+	} else {
+		return s.Description
+	}
+}
+
+func (s *SimpleColumnBatchProcessingStep) OutputSampleSize(sampleSize int) int {
+	if f := s.OutputSampleSizeFunc; f != nil {
+		return f(sampleSize)
+	}
+	return sampleSize
+}
@@ -0,0 +1,157 @@
+package bitflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ColumnBatchTestSuite struct {
+	testSuiteBase
+}
+
+func TestColumnBatch(t *testing.T) {
+	suite.Run(t, new(ColumnBatchTestSuite))
+}
+
+func (suite *ColumnBatchTestSuite) makeSamples() (*Header, []*Sample) {
+	header := &Header{Fields: []string{"a", "b"}}
+	samples := make([]*Sample, 3)
+	for i := range samples {
+		samples[i] = &Sample{Values: []Value{Value(i), Value(10 * i)}, Time: time.Unix(int64(i), 0)}
+		samples[i].SetTag("num", string(rune('x'+i)))
+	}
+	return header, samples
+}
+
+func (suite *ColumnBatchTestSuite) TestConversion() {
+	header, samples := suite.makeSamples()
+	batch, err := NewColumnBatch(header, samples)
+	suite.NoError(err)
+	suite.Equal(3, batch.Len())
+	suite.Equal([]float64{0, 1, 2, 0, 10, 20}, batch.Values)
+	suite.Equal([]float64{0, 10, 20}, batch.Column(1))
+	suite.Equal([][]float64{{0, 1, 2}, {0, 10, 20}}, batch.Columns())
+
+	for i := range batch.Values {
+		batch.Values[i]++
+	}
+	outHeader, outSamples, err := batch.ToSamples()
+	suite.NoError(err)
+	suite.True(header == outHeader)
+	suite.Equal([]Value{3, 21}, outSamples[2].Values)
+	suite.Equal("z", outSamples[2].Tag("num"))
+	suite.Equal(time.Unix(2, 0), outSamples[2].Time)
+
+	batch.Header = &Header{Fields: []string{"a"}}
+	_, _, err = batch.ToSamples()
+	suite.Error(err, "The number of values does not match the header")
+	batch.Values = batch.Column(0)
+	_, outSamples, err = batch.ToSamples()
+	suite.NoError(err)
+	suite.Equal([]Value{3}, outSamples[2].Values)
+}
+
+func (suite *ColumnBatchTestSuite) TestInconsistentSamples() {
+	header, samples := suite.makeSamples()
+	samples[1].Values = samples[1].Values[:1]
+	_, err := NewColumnBatch(header, samples)
+	suite.Error(err)
+}
+
+// countingColumnStep adds a constant to all values and records the batch instances it receives
+type countingColumnStep struct {
+	add     float64
+	batches []*ColumnBatch
+}
+
+func (s *countingColumnStep) ProcessBatch(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+	return ProcessColumnsAsBatch(s, header, samples)
+}
+
+func (s *countingColumnStep) ProcessColumns(batch *ColumnBatch) (*ColumnBatch, error) {
+	s.batches = append(s.batches, batch)
+	for i := range batch.Values {
+		batch.Values[i] += s.add
+	}
+	return batch, nil
+}
+
+func (s *countingColumnStep) String() string {
+	return "counting column step"
+}
+
+func (suite *ColumnBatchTestSuite) TestMixedSteps() {
+	columns1, columns2, columns3 := &countingColumnStep{add: 1}, &countingColumnStep{add: 2}, &countingColumnStep{add: 3}
+	var sampleValues [][]Value
+	rowStep := &SimpleBatchProcessingStep{
+		Process: func(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+			for _, sample := range samples {
+				sampleValues = append(sampleValues, append([]Value(nil), sample.Values...))
+			}
+			return header, samples, nil
+		},
+	}
+	proc := &BatchProcessor{Steps: []BatchProcessingStep{columns1, columns2, rowStep, columns3}}
+	header, samples := suite.makeSamples()
+	outSamples, outHeader, err := proc.executeSteps(samples, header)
+	suite.NoError(err)
+	suite.True(header == outHeader)
+	suite.True(columns1.batches[0] == columns2.batches[0], "Consecutive columnar steps must share the batch")
+	suite.False(columns2.batches[0] == columns3.batches[0])
+	suite.Equal([]Value{5, 23}, sampleValues[2], "The batch must be converted before executing a sample-based step")
+	suite.Equal([]Value{8, 26}, outSamples[2].Values)
+}
+
+func (suite *ColumnBatchTestSuite) TestSimpleColumnStep() {
+	step := &SimpleColumnBatchProcessingStep{
+		Process: func(batch *ColumnBatch) (*ColumnBatch, error) {
+			// Reduce the batch to one sample containing the sum of every column
+			sums := make([]float64, len(batch.Header.Fields))
+			for i, col := range batch.Columns() {
+				for _, val := range col {
+					sums[i] += val
+				}
+			}
+			batch.Samples = batch.Samples[:1]
+			batch.Values = sums
+			return batch, nil
+		},
+		OutputSampleSizeFunc: func(size int) int { return size },
+	}
+	header, samples := suite.makeSamples()
+	_, outSamples, err := step.ProcessBatch(header, samples)
+	suite.NoError(err)
+	suite.Len(outSamples, 1)
+	suite.Equal([]Value{3, 30}, outSamples[0].Values)
+	suite.Equal("SimpleColumnBatchProcessingStep", step.String())
+
+	_, _, err = new(SimpleColumnBatchProcessingStep).ProcessBatch(header, samples)
+	suite.Error(err)
+}
+
+func (suite *ColumnBatchTestSuite) TestWindowProcessor() {
+	step := &countingColumnStep{add: 1}
+	out := new(batchCollector)
+	proc := &WindowProcessor{Mode: GlobalWindow, Steps: []BatchProcessingStep{step}}
+	proc.SetSink(out)
+	header, samples := suite.makeSamples()
+	for _, sample := range samples {
+		suite.NoError(proc.Sample(sample, header))
+	}
+	proc.Close()
+	suite.Len(step.batches, 1)
+	suite.Len(out.samples, 3)
+	suite.Equal([]Value{3, 21}, out.samples[2].Values)
+}
+
+type batchCollector struct {
+	DroppingSampleProcessor
+	samples []*Sample
+}
+
+func (c *batchCollector) Sample(sample *Sample, _ *Header) error {
+	c.samples = append(c.samples, sample)
+	return nil
+}
@@ -0,0 +1,319 @@
+package bitflow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// DefaultBatchMemoryBudget is used by BatchProcessors that do not define a MemoryBudget. It is not limited by default.
+	DefaultBatchMemoryBudget int64
+
+	// BatchSpillDir is the directory for temporary files of BatchProcessors without a SpillDir. Default: os.TempDir()
+	BatchSpillDir string
+)
+
+// SpillingBatchProcessingStep is a BatchProcessingStep that can process batches that exceed the memory budget of
+// the BatchProcessor (see BatchProcessor.MemoryBudget), if it is the first step of the BatchProcessor.
+// ProcessBatch() is executed separately on every chunk of samples before it is spilled to disk, and on the
+// remaining samples in memory. ProcessBatch() must not change the header in this case. The processed chunks (runs)
+// are merged while reading them back from disk, like in an external merge sort: NextRun() returns the index of the
+// run that provides the next output sample. heads contains the next sample of every run, or nil if the run is
+// exhausted. remaining contains the number of samples left in every run, including the head.
+type SpillingBatchProcessingStep interface {
+	BatchProcessingStep
+	NextRun(heads []*Sample, remaining []int) int
+}
+
+// EstimateSampleSize returns the approximate number of bytes occupied by the given sample, including the tags.
+func EstimateSampleSize(sample *Sample) int64 {
+	const stringSize = int64(unsafe.Sizeof(""))
+	size := int64(unsafe.Sizeof(*sample)) + int64(cap(sample.Values))*int64(unsafe.Sizeof(Value(0)))
+	sample.lockRead(func() {
+		for key, value := range sample.tags {
+			// The key is stored both in the map and in orderedTags
+			size += 3*stringSize + int64(len(key)+len(value))
+		}
+	})
+	return size
+}
+
+func (p *BatchProcessor) memoryBudget() int64 {
+	if p.MemoryBudget > 0 {
+		return p.MemoryBudget
+	}
+	return DefaultBatchMemoryBudget
+}
+
+func (p *BatchProcessor) spillingStep() (SpillingBatchProcessingStep, bool) {
+	if len(p.Steps) == 0 {
+		return nil, false
+	}
+	step, ok := p.Steps[0].(SpillingBatchProcessingStep)
+	return step, ok
+}
+
+// accountSample adds the size of the sample to the memory usage and spills all buffered samples, if the memory budget
+// is exceeded. The sample must already be added to p.samples.
+func (p *BatchProcessor) accountSample(sample *Sample, header *Header) error {
+	budget := p.memoryBudget()
+	if budget <= 0 {
+		return nil
+	}
+	p.memoryUsage += EstimateSampleSize(sample)
+	if p.memoryUsage <= budget {
+		return nil
+	}
+	samples := p.samples
+	p.samples = nil
+	p.memoryUsage = 0
+	if p.spill == nil {
+		dir := p.SpillDir
+		if dir == "" {
+			dir = BatchSpillDir
+		}
+		spill, err := newBatchSpill(dir)
+		if err != nil {
+			return fmt.Errorf("%v: Failed to create spill file: %v", p, err)
+		}
+		p.Log().Debugf("Memory budget of %v byte(s) exceeded, spilling samples to %v", budget, spill.file.Name())
+		p.spill = spill
+	}
+	if step, ok := p.spillingStep(); ok {
+		var err error
+		if samples, err = processSpillRun(step, header, samples); err != nil {
+			return err
+		}
+	}
+	if err := p.spill.writeRun(header, samples); err != nil {
+		return fmt.Errorf("%v: Failed to spill %v sample(s): %v", p, len(samples), err)
+	}
+	return nil
+}
+
+func processSpillRun(step SpillingBatchProcessingStep, header *Header, samples []*Sample) ([]*Sample, error) {
+	outHeader, samples, err := step.ProcessBatch(header, samples)
+	if err == nil && !outHeader.Equals(header) {
+		err = fmt.Errorf("%v changed the header of a spilled batch", step)
+	}
+	return samples, err
+}
+
+// flushSpilled executes the steps on a batch that was partially spilled to disk. If the first step is a
+// SpillingBatchProcessingStep, the spilled runs are merged and streamed to the subsequent processor. If there are
+// further steps, or the first step cannot process spilled batches, all samples are loaded back into memory.
+func (p *BatchProcessor) flushSpilled(ctx context.Context, spill *batchSpill, header *Header, samples []*Sample) error {
+	defer spill.remove()
+	steps := p.Steps
+	nextRun := concatenateRuns
+	if step, ok := p.spillingStep(); ok {
+		var err error
+		if samples, err = processSpillRun(step, header, samples); err != nil {
+			return err
+		}
+		steps = steps[1:]
+		nextRun = step.NextRun
+	}
+	merger, err := spill.merge(samples, nextRun)
+	if err != nil {
+		return fmt.Errorf("%v: Failed to read spilled samples: %v", p, err)
+	}
+	if len(steps) == 0 {
+		p.Log().Println("Flushing", merger.total, "batched samples with", len(header.Fields), "metrics from", len(merger.runs), "spilled runs")
+		header = TracedHeader(ctx, header)
+		for {
+			sample, err := merger.next()
+			if err != nil {
+				return fmt.Errorf("%v: Failed to read spilled samples: %v", p, err)
+			} else if sample == nil {
+				return nil
+			}
+			if err := p.NoopProcessor.Sample(sample, header); err != nil {
+				return fmt.Errorf("Error flushing batch: %v", err)
+			}
+		}
+	}
+
+	p.Log().Warnf("Loading %v spilled samples back into memory, because %v cannot process spilled batches", merger.total, steps[0])
+	samples = make([]*Sample, 0, merger.total)
+	for {
+		sample, err := merger.next()
+		if err != nil {
+			return fmt.Errorf("%v: Failed to read spilled samples: %v", p, err)
+		} else if sample == nil {
+			break
+		}
+		samples = append(samples, sample)
+	}
+	samples, header, err = p.runSteps(ctx, steps, samples, header)
+	if err != nil {
+		return err
+	}
+	return p.forwardBatch(ctx, header, samples)
+}
+
+// concatenateRuns returns the runs in the order they were spilled, which preserves the order of the samples
+func concatenateRuns(heads []*Sample, _ []int) int {
+	for i, head := range heads {
+		if head != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// batchSpill stores runs of samples in a temporary file in the binary format. Every run starts with a header, so
+// that the runs can be read independently.
+type batchSpill struct {
+	file       *os.File
+	writer     *bufio.Writer
+	marshaller BinaryMarshaller
+	size       int64
+	runs       []spilledRun
+}
+
+type spilledRun struct {
+	offset, length int64
+	samples        int
+}
+
+func newBatchSpill(dir string) (*batchSpill, error) {
+	file, err := ioutil.TempFile(dir, "bitflow-batch-")
+	if err != nil {
+		return nil, err
+	}
+	return &batchSpill{
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+func (s *batchSpill) writeRun(header *Header, samples []*Sample) error {
+	if err := s.marshaller.WriteHeader(header, true, s.writer); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return fmt.Errorf("Sample has %v values, but header has %v fields", len(sample.Values), len(header.Fields))
+		}
+		if err := s.marshaller.WriteSample(sample, header, true, s.writer); err != nil {
+			return err
+		}
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	end, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, spilledRun{offset: s.size, length: end - s.size, samples: len(samples)})
+	s.size = end
+	return nil
+}
+
+// merge returns a spillMerger that reads all spilled runs, followed by a run of the given samples in memory
+func (s *batchSpill) merge(samples []*Sample, nextRun func(heads []*Sample, remaining []int) int) (*spillMerger, error) {
+	merger := &spillMerger{
+		marshaller: s.marshaller,
+		nextRun:    nextRun,
+		runs:       make([]*runReader, 0, len(s.runs)+1),
+	}
+	for _, run := range s.runs {
+		reader := &runReader{
+			reader:    bufio.NewReader(io.NewSectionReader(s.file, run.offset, run.length)),
+			remaining: run.samples,
+		}
+		if run.samples > 0 {
+			var err error
+			if reader.header, _, err = s.marshaller.Read(reader.reader, nil); err != nil {
+				return nil, err
+			}
+		}
+		merger.runs = append(merger.runs, reader)
+	}
+	merger.runs = append(merger.runs, &runReader{samples: samples, remaining: len(samples)})
+	merger.heads = make([]*Sample, len(merger.runs))
+	merger.remaining = make([]int, len(merger.runs))
+	for i, run := range merger.runs {
+		head, err := run.next(s.marshaller)
+		if err != nil {
+			return nil, err
+		}
+		merger.heads[i] = head
+		merger.remaining[i] = run.remaining
+		if head != nil {
+			merger.remaining[i]++
+		}
+		merger.total += merger.remaining[i]
+	}
+	return merger, nil
+}
+
+func (s *batchSpill) remove() {
+	if err := s.file.Close(); err != nil {
+		log.Warnf("Failed to close spill file %v: %v", s.file.Name(), err)
+	}
+	if err := os.Remove(s.file.Name()); err != nil {
+		log.Warnf("Failed to remove spill file %v: %v", s.file.Name(), err)
+	}
+}
+
+// runReader reads the samples of one spilled run, or returns samples from memory, if the reader is nil
+type runReader struct {
+	reader    *bufio.Reader
+	header    *UnmarshalledHeader
+	samples   []*Sample
+	remaining int
+}
+
+func (r *runReader) next(marshaller BinaryMarshaller) (*Sample, error) {
+	if r.remaining == 0 {
+		return nil, nil
+	}
+	r.remaining--
+	if r.reader == nil {
+		sample := r.samples[0]
+		r.samples = r.samples[1:]
+		return sample, nil
+	}
+	header, sample, err := marshaller.ReadSample(r.reader, r.header, 0)
+	if err == nil && (header != nil || sample == nil) {
+		err = io.ErrUnexpectedEOF
+	}
+	return sample, err
+}
+
+type spillMerger struct {
+	marshaller BinaryMarshaller
+	nextRun    func(heads []*Sample, remaining []int) int
+	runs       []*runReader
+	heads      []*Sample
+	remaining  []int
+	total      int
+}
+
+// next returns nil after all samples were returned
+func (m *spillMerger) next() (*Sample, error) {
+	index := m.nextRun(m.heads, m.remaining)
+	if index < 0 || index >= len(m.heads) || m.heads[index] == nil {
+		for _, head := range m.heads {
+			if head != nil {
+				return nil, fmt.Errorf("Invalid run index %v selected for merging spilled samples", index)
+			}
+		}
+		return nil, nil
+	}
+	sample := m.heads[index]
+	head, err := m.runs[index].next(m.marshaller)
+	m.heads[index] = head
+	m.remaining[index]--
+	return sample, err
+}
@@ -0,0 +1,139 @@
+package bitflow
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BatchSpillTestSuite struct {
+	testSuiteBase
+	dir string
+}
+
+func TestBatchSpill(t *testing.T) {
+	suite.Run(t, new(BatchSpillTestSuite))
+}
+
+func (suite *BatchSpillTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "bitflow-batch-spill-test")
+	suite.NoError(err)
+	suite.dir = dir
+}
+
+func (suite *BatchSpillTestSuite) TearDownTest() {
+	files, err := ioutil.ReadDir(suite.dir)
+	suite.NoError(err)
+	suite.Empty(files, "Spill files must be removed")
+	suite.NoError(os.RemoveAll(suite.dir))
+}
+
+// valueSorter sorts samples by their first value, and optionally counts the merged runs
+type valueSorter struct {
+	runs int
+}
+
+func (s *valueSorter) ProcessBatch(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+	s.runs++
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Values[0] < samples[j].Values[0]
+	})
+	return header, samples, nil
+}
+
+func (s *valueSorter) NextRun(heads []*Sample, _ []int) int {
+	next := -1
+	for i, head := range heads {
+		if head != nil && (next < 0 || head.Values[0] < heads[next].Values[0]) {
+			next = i
+		}
+	}
+	return next
+}
+
+func (s *valueSorter) String() string {
+	return "value sorter"
+}
+
+func (suite *BatchSpillTestSuite) run(proc *BatchProcessor, values ...Value) *batchCollector {
+	out := new(batchCollector)
+	proc.SetSink(out)
+	proc.SpillDir = suite.dir
+	var wg sync.WaitGroup
+	proc.Start(&wg)
+	header := &Header{Fields: []string{"a", "b"}}
+	for i, value := range values {
+		sample := &Sample{Values: []Value{value, Value(i)}}
+		sample.SetTag("index", string(rune('a'+i)))
+		suite.NoError(proc.Sample(sample, header))
+	}
+	proc.Close()
+	wg.Wait()
+	for _, sample := range out.samples {
+		suite.Equal(string(rune('a'+int(sample.Values[1]))), sample.Tag("index"), "Tags must be restored")
+	}
+	return out
+}
+
+func (suite *BatchSpillTestSuite) values(samples []*Sample) []Value {
+	res := make([]Value, len(samples))
+	for i, sample := range samples {
+		res[i] = sample.Values[0]
+	}
+	return res
+}
+
+func (suite *BatchSpillTestSuite) TestEstimateSampleSize() {
+	sample := &Sample{Values: make([]Value, 10)}
+	size := EstimateSampleSize(sample)
+	suite.True(size > 80)
+	sample.SetTag("key", "value")
+	suite.True(EstimateSampleSize(sample) > size+8)
+}
+
+func (suite *BatchSpillTestSuite) TestSpillPreservesOrder() {
+	values := []Value{5, 3, 9, 1, 7, 2, 8, 4, 6, 0}
+	proc := &BatchProcessor{MemoryBudget: 3 * EstimateSampleSize(&Sample{Values: make([]Value, 2)})}
+	out := suite.run(proc, values...)
+	suite.Equal(values, suite.values(out.samples))
+}
+
+func (suite *BatchSpillTestSuite) TestExternalSort() {
+	sorter := new(valueSorter)
+	proc := &BatchProcessor{MemoryBudget: 300, Steps: []BatchProcessingStep{sorter}}
+	out := suite.run(proc, 5, 3, 9, 1, 7, 2, 8, 4, 6, 0)
+	suite.Equal([]Value{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, suite.values(out.samples))
+	suite.True(sorter.runs > 2, "Every spilled run must be sorted separately")
+}
+
+func (suite *BatchSpillTestSuite) TestNonSpillingSteps() {
+	var received int
+	step := &SimpleBatchProcessingStep{
+		Process: func(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+			received = len(samples)
+			return header, samples[:3], nil
+		},
+	}
+	sorter := new(valueSorter)
+	proc := &BatchProcessor{MemoryBudget: 300, Steps: []BatchProcessingStep{sorter, step}}
+	out := suite.run(proc, 5, 3, 9, 1, 7, 2, 8, 4, 6, 0)
+	suite.Equal(10, received, "All spilled samples must be loaded for steps that cannot process spilled batches")
+	suite.Equal([]Value{0, 1, 2}, suite.values(out.samples))
+
+	received = 0
+	proc = &BatchProcessor{MemoryBudget: 300, Steps: []BatchProcessingStep{step, sorter}}
+	out = suite.run(proc, 5, 3, 9, 1, 7, 2, 8, 4, 6, 0)
+	suite.Equal(10, received)
+	suite.Equal([]Value{3, 5, 9}, suite.values(out.samples))
+}
+
+func (suite *BatchSpillTestSuite) TestNoBudget() {
+	proc := new(BatchProcessor)
+	out := suite.run(proc, 1, 2, 3)
+	suite.Nil(proc.spill)
+	suite.Len(out.samples, 3)
+}
@@ -0,0 +1,107 @@
+package bitflow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Samples, their Values slices and marshalling buffers can be reused to reduce the load on the garbage collector
+// in pipelines with high sample rates. The unmarshallers and Sample.DeepClone() allocate Samples through
+// AllocateSample(). Such pooled samples are reference counted: the allocating code owns the first reference,
+// Retain() adds a reference and Release() removes one. When the last reference is released, the Sample is
+// reset and put back into the pool. Calling Retain() and Release() on samples that were not allocated from the
+// pool has no effect, and pooled samples that are never released are simply garbage collected.
+//
+// A SamplePipeline releases the samples that reach its end, but only if all its SampleProcessors declare
+// through the RetainingProcessor interface that they do not keep references to the samples.
+// Processors that hand samples over to other goroutines must call Retain() before and Release() after
+// using them there, like the SampleOutputStream does.
+
+var (
+	samplePool = sync.Pool{
+		New: func() interface{} {
+			return new(Sample)
+		},
+	}
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			return new([]byte)
+		},
+	}
+)
+
+// AllocateSample returns a Sample from the pool of reusable samples. The Values slice is empty and has at least
+// the given capacity. The timestamp is zero and the sample has no tags. The caller owns the only reference to the
+// returned sample.
+func AllocateSample(valueCapacity int) *Sample {
+	sample := samplePool.Get().(*Sample)
+	if cap(sample.Values) < valueCapacity {
+		sample.Values = make([]Value, 0, valueCapacity)
+	} else {
+		sample.Values = sample.Values[:0]
+	}
+	sample.refs = 1
+	sample.pooled = true
+	return sample
+}
+
+// Retain adds a reference to the receiving Sample, if it was allocated through AllocateSample().
+// Every call must be followed by a call to Release(), when the reference is not used anymore.
+func (sample *Sample) Retain() {
+	if sample.pooled {
+		atomic.AddInt32(&sample.refs, 1)
+	}
+}
+
+// Release removes a reference to the receiving Sample, if it was allocated through AllocateSample().
+// After the last reference was released, the sample is put back into the pool and must not be used anymore.
+func (sample *Sample) Release() {
+	if !sample.pooled {
+		return
+	}
+	refs := atomic.AddInt32(&sample.refs, -1)
+	if refs > 0 {
+		return
+	} else if refs < 0 {
+		panic("bitflow: pooled Sample released too often")
+	}
+	sample.pooled = false
+	sample.Values = sample.Values[:0]
+	sample.Time = time.Time{}
+	// The tags are not reused, because SampleMetadata instances might still refer to them
+	sample.tags = nil
+	sample.orderedTags = nil
+	samplePool.Put(sample)
+}
+
+// RetainingProcessor can be implemented by SampleProcessors to declare whether they keep references to
+// received samples, or to their tags or Values slices, after forwarding them or returning from Sample().
+// SampleProcessors that do not implement this interface are expected to retain samples.
+type RetainingProcessor interface {
+	SampleProcessor
+	RetainsSamples() bool
+}
+
+// RetainsSamples returns false, if the given SampleProcessor implements RetainingProcessor and reports
+// that it does not retain samples.
+func RetainsSamples(processor SampleProcessor) bool {
+	retaining, ok := processor.(RetainingProcessor)
+	return !ok || retaining.RetainsSamples()
+}
+
+// allocateBuffer returns a byte slice of the given length. The contents are undefined.
+func allocateBuffer(size int) []byte {
+	buf := bufferPool.Get().(*[]byte)
+	if cap(*buf) < size {
+		return make([]byte, size)
+	}
+	return (*buf)[:size]
+}
+
+// releaseBuffer puts a byte slice back into the pool. It must not be used anymore afterwards.
+func releaseBuffer(buf []byte) {
+	if cap(buf) > 0 {
+		bufferPool.Put(&buf)
+	}
+}
@@ -0,0 +1,123 @@
+package bitflow
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/stretchr/testify/suite"
+)
+
+type SamplePoolTestSuite struct {
+	testSuiteBase
+}
+
+func TestSamplePool(t *testing.T) {
+	suite.Run(t, new(SamplePoolTestSuite))
+}
+
+func (suite *SamplePoolTestSuite) TestAllocateAndRelease() {
+	sample := AllocateSample(3)
+	suite.Len(sample.Values, 0)
+	suite.True(cap(sample.Values) >= 3)
+	sample.Values = append(sample.Values, 1, 2, 3)
+	sample.Time = time.Unix(100, 0)
+	sample.SetTag("a", "b")
+
+	sample.Retain()
+	sample.Release()
+	suite.Equal("b", sample.Tag("a"), "The sample must not be reset while it is still referenced")
+	suite.Len(sample.Values, 3)
+
+	sample.Release()
+	suite.Len(sample.Values, 0)
+	suite.True(sample.Time.IsZero())
+	suite.Equal(0, sample.NumTags())
+	suite.Empty(sample.TagString())
+	sample.Release() // Not pooled anymore, no effect
+}
+
+func (suite *SamplePoolTestSuite) TestUnpooledSample() {
+	sample := &Sample{Values: []Value{1}}
+	sample.SetTag("a", "b")
+	sample.Retain()
+	sample.Release()
+	sample.Release()
+	suite.Equal([]Value{1}, sample.Values)
+	suite.Equal("b", sample.Tag("a"))
+}
+
+func (suite *SamplePoolTestSuite) TestDeepCloneIsIndependent() {
+	sample := AllocateSample(2)
+	sample.Values = append(sample.Values, 1, 2)
+	sample.SetTag("a", "b")
+	clone := sample.DeepClone()
+	sample.Release()
+	suite.Equal([]Value{1, 2}, clone.Values)
+	suite.Equal("b", clone.Tag("a"))
+}
+
+// retainingSink records the samples it receives. It can declare that it does not retain them, although it does.
+type retainingSink struct {
+	DroppingSampleProcessor
+	retains bool
+	samples []*Sample
+}
+
+func (s *retainingSink) RetainsSamples() bool {
+	return s.retains
+}
+
+func (s *retainingSink) Sample(sample *Sample, header *Header) error {
+	s.samples = append(s.samples, sample)
+	return s.GetSink().Sample(sample, header)
+}
+
+func (suite *SamplePoolTestSuite) runPipeline(sink *retainingSink) {
+	pipe := new(SamplePipeline).Add(sink)
+	var tasks golib.TaskGroup
+	pipe.Construct(&tasks)
+	sample := AllocateSample(1)
+	sample.Values = append(sample.Values, 1)
+	suite.NoError(pipe.Processors[0].Sample(sample, &Header{Fields: []string{"a"}}))
+}
+
+func (suite *SamplePoolTestSuite) TestPipelineReleasesSamples() {
+	sink := &retainingSink{retains: false}
+	suite.runPipeline(sink)
+	suite.Len(sink.samples[0].Values, 0, "The sample must be released at the end of the pipeline")
+
+	sink = &retainingSink{retains: true}
+	suite.runPipeline(sink)
+	suite.Equal([]Value{1}, sink.samples[0].Values, "The sample must not be released, because the sink retains it")
+}
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error {
+	return nil
+}
+
+func (suite *SamplePoolTestSuite) TestOutputStreamRetainsSamples() {
+	var buf bufferCloser
+	var expected bytes.Buffer
+	var marshaller CsvMarshaller
+	header := &Header{Fields: []string{"a"}}
+	suite.NoError(marshaller.WriteHeader(header, true, &expected))
+
+	writer := SampleWriter{ParallelSampleHandler{BufferedSamples: 5, ParallelParsers: 3}}
+	stream := writer.Open(&buf, marshaller)
+	for i := 0; i < 100; i++ {
+		sample := AllocateSample(1)
+		sample.Values = append(sample.Values, Value(i))
+		sample.Time = time.Unix(int64(i), 0)
+		suite.NoError(marshaller.WriteSample(sample, header, true, &expected))
+		suite.NoError(stream.Sample(sample, header))
+		sample.Release() // The stream must keep its own reference until the sample is marshalled
+	}
+	suite.NoError(stream.Close())
+	suite.Equal(expected.String(), buf.String())
+}
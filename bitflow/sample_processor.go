@@ -5,7 +5,6 @@ import (
 	"sync"
 
 	"github.com/antongulenko/golib"
-	log "github.com/sirupsen/logrus"
 )
 
 // SampleProcessor is the basic interface to receive and process samples.
@@ -59,7 +58,7 @@ type AbstractSampleOutput struct {
 func (out *AbstractSampleOutput) Sample(err error, sample *Sample, header *Header) error {
 	if err != nil {
 		if out.DropOutputErrors {
-			log.Errorln(err)
+			out.Log().Errorln(err)
 		} else {
 			return err
 		}
@@ -70,6 +69,16 @@ func (out *AbstractSampleOutput) Sample(err error, sample *Sample, header *Heade
 	return out.GetSink().Sample(sample, header)
 }
 
+// IsReadOnly implements the ReadOnlyProcessor interface. Outputs only serialize the samples they receive.
+func (out *AbstractSampleOutput) IsReadOnly() bool {
+	return true
+}
+
+// ForwardsSamples returns false, if the DontForwardSamples flag is set.
+func (out *AbstractSampleOutput) ForwardsSamples() bool {
+	return !out.DontForwardSamples
+}
+
 // MarshallingSampleOutput is a SampleProcessor that outputs the received samples to a
 // byte stream that is generated by a Marshaller instance.
 type MarshallingSampleOutput interface {
@@ -99,6 +108,20 @@ func (out *AbstractMarshallingSampleOutput) SetMarshaller(marshaller Marshaller)
 	out.Marshaller = marshaller
 }
 
+// ReadOnlyProcessor can be implemented by SampleProcessors that do not modify the samples and headers they receive,
+// and forward them unmodified, if at all. Forks do not copy the samples for subpipelines that consist only of
+// read-only processors.
+type ReadOnlyProcessor interface {
+	SampleProcessor
+	IsReadOnly() bool
+}
+
+// IsReadOnly returns true, if the given SampleProcessor implements ReadOnlyProcessor and reports to be read-only.
+func IsReadOnly(processor SampleProcessor) bool {
+	readOnly, ok := processor.(ReadOnlyProcessor)
+	return ok && readOnly.IsReadOnly()
+}
+
 // DroppingSampleProcessor implements the SampleProcessor interface by dropping any incoming
 // samples.
 type DroppingSampleProcessor struct {
@@ -110,6 +133,11 @@ func (s *DroppingSampleProcessor) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
 	return
 }
 
+// IsReadOnly implements the ReadOnlyProcessor interface.
+func (s *DroppingSampleProcessor) IsReadOnly() bool {
+	return true
+}
+
 // Close implements the SampleProcessor interface.
 func (s *DroppingSampleProcessor) Close() {
 	s.CloseSink()
@@ -232,6 +260,8 @@ type SimpleProcessor struct {
 	Process              func(sample *Sample, header *Header) (*Sample, *Header, error)
 	OnClose              func()
 	OutputSampleSizeFunc func(sampleSize int) int
+	StoreStateFunc       func() ([]byte, error)
+	RestoreStateFunc     func(state []byte) error
 }
 
 func (p *SimpleProcessor) Sample(sample *Sample, header *Header) error {
@@ -260,6 +290,22 @@ func (p *SimpleProcessor) OutputSampleSize(sampleSize int) int {
 	return sampleSize
 }
 
+// StoreState implements the StatefulProcessor interface. The result is nil, if StoreStateFunc is not set.
+func (p *SimpleProcessor) StoreState() ([]byte, error) {
+	if f := p.StoreStateFunc; f != nil {
+		return f()
+	}
+	return nil, nil
+}
+
+// RestoreState implements the StatefulProcessor interface.
+func (p *SimpleProcessor) RestoreState(state []byte) error {
+	if f := p.RestoreStateFunc; f != nil {
+		return f(state)
+	}
+	return nil
+}
+
 func (p *SimpleProcessor) String() string {
 	if p.Description == "" {
 		return "SimpleProcessor"
@@ -26,8 +26,9 @@ type SampleSource interface {
 
 // AbstractSampleSource is a partial implementation of SampleSource that stores
 // the SampleProcessor and closes the outgoing SampleProcessor after all samples
-// have been generated.
+// have been generated. It also provides a logger with the context of the step in the pipeline, see StepLogger.
 type AbstractSampleSource struct {
+	StepLogger
 	out SampleProcessor
 }
 
@@ -0,0 +1,155 @@
+package bitflow
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ShutdownSignals are the signals that make WaitAndDrain() shut down the running tasks gracefully.
+	// A second signal aborts the graceful shutdown.
+	ShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+	// DrainTimeout limits the time that WaitAndDrain() waits for the tasks to flush all in-flight samples
+	// after the shutdown started. A value <= 0 means no limit.
+	DrainTimeout time.Duration
+)
+
+// ShutdownTask is a golib.Task that finishes when one of the ShutdownSignals is received.
+// Further signals received afterwards stop the Forced() channel. The signals are captured until Close() is called,
+// so that a second signal does not kill the process with the default signal handling.
+type ShutdownTask struct {
+	signals  chan os.Signal
+	received golib.StopChan
+	forced   golib.StopChan
+	closed   golib.StopChan
+	signal   os.Signal
+	lock     sync.Mutex
+}
+
+// NewShutdownTask creates a ShutdownTask. The signals are captured starting with the call to Start().
+func NewShutdownTask() *ShutdownTask {
+	return &ShutdownTask{
+		signals:  make(chan os.Signal, 2),
+		received: golib.NewStopChan(),
+		forced:   golib.NewStopChan(),
+		closed:   golib.NewStopChan(),
+	}
+}
+
+// Start implements the golib.Task interface.
+func (t *ShutdownTask) Start(wg *sync.WaitGroup) golib.StopChan {
+	signal.Notify(t.signals, ShutdownSignals...)
+	go t.handleSignals()
+	return t.received
+}
+
+func (t *ShutdownTask) handleSignals() {
+	for {
+		select {
+		case sig := <-t.signals:
+			if t.received.Stopped() {
+				log.Warnf("Received %v, aborting graceful shutdown", sig)
+				t.forced.Stop()
+			} else {
+				log.Printf("Received %v, shutting down (send again to abort flushing the pipeline)", sig)
+				t.lock.Lock()
+				t.signal = sig
+				t.lock.Unlock()
+				t.received.Stop()
+			}
+		case <-t.closed.WaitChan():
+			return
+		}
+	}
+}
+
+// Stop implements the golib.Task interface. It does not stop capturing the signals, see Close().
+func (t *ShutdownTask) Stop() {
+	t.received.Stop()
+}
+
+// Forced returns a channel that is stopped when a shutdown signal is received after the first one.
+func (t *ShutdownTask) Forced() golib.StopChan {
+	return t.forced
+}
+
+// Close restores the default handling of the ShutdownSignals.
+func (t *ShutdownTask) Close() {
+	signal.Stop(t.signals)
+	t.closed.Stop()
+}
+
+// String implements the golib.Task interface.
+func (t *ShutdownTask) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.signal != nil {
+		return fmt.Sprintf("shutdown signal (%v)", t.signal)
+	}
+	return "shutdown signal"
+}
+
+// WaitAndDrain executes the same lifecycle sequence as golib.TaskGroup.WaitAndStop(), with an additional ShutdownTask
+// added to the tasks. When stopping the tasks, the sources stop producing samples and the samples that are still
+// queued in the pipeline are flushed through all steps and sinks. If the tasks do not finish within the DrainTimeout,
+// or a second shutdown signal is received, WaitAndDrain returns without waiting for them. In that case, samples
+// might be lost: the number of samples that were still queued is logged and the returned number of errors
+// is increased by one.
+func WaitAndDrain(tasks golib.TaskGroup) (golib.Task, int) {
+	shutdown := NewShutdownTask()
+	defer shutdown.Close()
+	// Start capturing the signals before starting any other task
+	tasks = append(golib.TaskGroup{shutdown}, tasks...)
+
+	var wg sync.WaitGroup
+	channels := tasks.StartTasks(&wg)
+	reason := golib.WaitForAny(channels)
+	if reason == -1 {
+		return nil, -1
+	}
+
+	drained := make(chan int, 1)
+	go func() {
+		tasks.Stop()
+		wg.Wait()
+		drained <- tasks.CollectErrors(channels, func(err error) {
+			log.Errorln(err)
+		})
+	}()
+	var timeout <-chan time.Time
+	if DrainTimeout > 0 {
+		timer := time.NewTimer(DrainTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case numErrors := <-drained:
+		return tasks[reason], numErrors
+	case <-timeout:
+		log.Errorf("Pipeline did not shut down within the drain timeout of %v, %v sample(s) still queued",
+			DrainTimeout, QueuedSamples(DefaultPipelineStatistics))
+	case <-shutdown.Forced().WaitChan():
+		log.Errorf("Pipeline shutdown aborted, %v sample(s) still queued", QueuedSamples(DefaultPipelineStatistics))
+	}
+	return tasks[reason], 1
+}
+
+// QueuedSamples returns the number of samples queued in all processors registered in the given statistics.
+func QueuedSamples(stats *PipelineStatistics) int {
+	queued := 0
+	for _, proc := range stats.Processors() {
+		if queue := proc.QueueLength(); queue > 0 {
+			queued += queue
+		}
+	}
+	return queued
+}
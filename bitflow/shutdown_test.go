@@ -0,0 +1,94 @@
+package bitflow
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/stretchr/testify/suite"
+)
+
+type ShutdownTestSuite struct {
+	testSuiteBase
+}
+
+func TestShutdown(t *testing.T) {
+	suite.Run(t, new(ShutdownTestSuite))
+}
+
+// drainingTask finishes after the given delay, or blocks in Stop() for the given drain time.
+// If signals is >0, it sends SIGTERM to the own process the given number of times after starting.
+type drainingTask struct {
+	finish  time.Duration
+	drain   time.Duration
+	signals int
+	err     error
+	stopped golib.StopChan
+}
+
+func (t *drainingTask) Start(wg *sync.WaitGroup) golib.StopChan {
+	t.stopped = golib.NewStopChan()
+	if t.finish > 0 {
+		time.AfterFunc(t.finish, func() {
+			t.stopped.StopErr(t.err)
+		})
+	}
+	go func() {
+		for i := 0; i < t.signals; i++ {
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	return t.stopped
+}
+
+func (t *drainingTask) Stop() {
+	time.Sleep(t.drain)
+	t.stopped.StopErr(t.err)
+}
+
+func (t *drainingTask) String() string {
+	return "draining task"
+}
+
+func (suite *ShutdownTestSuite) wait(task *drainingTask, timeout time.Duration) (golib.Task, int, time.Duration) {
+	DrainTimeout = timeout
+	defer func() {
+		DrainTimeout = 0
+	}()
+	start := time.Now()
+	reason, numErrors := WaitAndDrain(golib.TaskGroup{task})
+	return reason, numErrors, time.Since(start)
+}
+
+func (suite *ShutdownTestSuite) TestTaskFinished() {
+	task := &drainingTask{finish: 10 * time.Millisecond, err: errors.New("failed")}
+	reason, numErrors, _ := suite.wait(task, 0)
+	suite.Equal(task, reason)
+	suite.Equal(1, numErrors)
+}
+
+func (suite *ShutdownTestSuite) TestDrainOnSignal() {
+	task := &drainingTask{drain: 50 * time.Millisecond, signals: 1}
+	reason, numErrors, _ := suite.wait(task, time.Second)
+	suite.Equal("shutdown signal (terminated)", reason.String())
+	suite.Equal(0, numErrors)
+}
+
+func (suite *ShutdownTestSuite) TestDrainTimeout() {
+	task := &drainingTask{drain: time.Second, signals: 1}
+	_, numErrors, duration := suite.wait(task, 50*time.Millisecond)
+	suite.Equal(1, numErrors)
+	suite.True(duration < time.Second, "Waited %v", duration)
+}
+
+func (suite *ShutdownTestSuite) TestSecondSignal() {
+	task := &drainingTask{drain: time.Second, signals: 2}
+	_, numErrors, duration := suite.wait(task, 0)
+	suite.Equal(1, numErrors)
+	suite.True(duration < time.Second, "Waited %v", duration)
+}
@@ -0,0 +1,68 @@
+package testsupport
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/assert"
+)
+
+// UpdateGolden makes AssertGolden overwrite the golden files with the actual output, instead of comparing them.
+// It is set through the -update-golden flag of the test binary, e.g. go test ./... -args -update-golden
+var UpdateGolden = flag.Bool("update-golden", false, "Overwrite golden files with the actual test output")
+
+// Marshall writes the header and the samples with the given marshaller and returns the resulting bytes.
+// Like in a SampleWriter, tags are always included, and a new header is written whenever the header changes.
+func Marshall(marshaller bitflow.Marshaller, headers []*bitflow.Header, samples []*bitflow.Sample) ([]byte, error) {
+	var buf bytes.Buffer
+	var checker bitflow.HeaderChecker
+	for i, sample := range samples {
+		header := headers[i]
+		if checker.HeaderChanged(header) {
+			if err := marshaller.WriteHeader(header, true, &buf); err != nil {
+				return nil, err
+			}
+		}
+		if err := marshaller.WriteSample(sample, header, true, &buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// AssertGolden marshalls the samples received by the sink with the given marshaller and compares the result
+// with the contents of the golden file. Relative file names are resolved in the testdata directory of the current package.
+// If the -update-golden flag is set, the golden file is (re-)created instead, and the comparison always succeeds.
+func AssertGolden(t testing.TB, file string, marshaller bitflow.Marshaller, sink *CapturingSink) bool {
+	t.Helper()
+	data, err := Marshall(marshaller, sink.Headers(), sink.Samples())
+	if !assert.NoError(t, err, "Failed to marshall samples with %v", marshaller) {
+		return false
+	}
+	return AssertGoldenBytes(t, file, data)
+}
+
+// AssertGoldenBytes compares the data with the contents of the golden file, like AssertGolden.
+func AssertGoldenBytes(t testing.TB, file string, data []byte) bool {
+	t.Helper()
+	if !filepath.IsAbs(file) {
+		file = filepath.Join("testdata", file)
+	}
+	if *UpdateGolden {
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(file, data, 0644)
+		}
+		return assert.NoError(t, err, "Failed to update golden file %v", file)
+	}
+	expected, err := ioutil.ReadFile(file)
+	if !assert.NoError(t, err, "Failed to read golden file %v (use -update-golden to create it)", file) {
+		return false
+	}
+	return assert.Equal(t, string(expected), string(data), "Output differs from golden file %v (use -update-golden to update it)", file)
+}
@@ -0,0 +1,211 @@
+package testsupport
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/assert"
+)
+
+// CapturingSink is a SampleProcessor that stores all received samples and headers, instead of forwarding them.
+// It is safe for concurrent use. The Assert* methods report mismatches through the given testing.TB.
+type CapturingSink struct {
+	bitflow.DroppingSampleProcessor
+
+	// Error is returned from every call to Sample(), after the sample is stored. It can be used to test error handling.
+	Error error
+
+	cond    *sync.Cond
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+	closed  bool
+}
+
+// NewCapturingSink creates an empty CapturingSink.
+func NewCapturingSink() *CapturingSink {
+	return &CapturingSink{cond: sync.NewCond(new(sync.Mutex))}
+}
+
+func (s *CapturingSink) String() string {
+	return "Capturing sink"
+}
+
+// Sample implements the SampleSink interface.
+func (s *CapturingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	s.cond.Broadcast()
+	return s.Error
+}
+
+// Close implements the SampleSink interface.
+func (s *CapturingSink) Close() {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// Samples returns a copy of the list of received samples.
+func (s *CapturingSink) Samples() []*bitflow.Sample {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return append([]*bitflow.Sample(nil), s.samples...)
+}
+
+// Headers returns a copy of the list of received headers. The header at every index belongs to the sample at the same index.
+func (s *CapturingSink) Headers() []*bitflow.Header {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return append([]*bitflow.Header(nil), s.headers...)
+}
+
+// Values returns the values of all received samples.
+func (s *CapturingSink) Values() [][]float64 {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	res := make([][]float64, len(s.samples))
+	for i, sample := range s.samples {
+		res[i] = make([]float64, len(sample.Values))
+		for j, val := range sample.Values {
+			res[i][j] = float64(val)
+		}
+	}
+	return res
+}
+
+// Tags returns the value of the given tag in all received samples.
+func (s *CapturingSink) Tags(key string) []string {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	res := make([]string, len(s.samples))
+	for i, sample := range s.samples {
+		res[i] = sample.Tag(key)
+	}
+	return res
+}
+
+// Offsets returns the timestamps of all received samples as offsets from StartTime.
+func (s *CapturingSink) Offsets() []time.Duration {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	res := make([]time.Duration, len(s.samples))
+	for i, sample := range s.samples {
+		res[i] = sample.Time.Sub(StartTime)
+	}
+	return res
+}
+
+// Closed returns whether Close() has been called.
+func (s *CapturingSink) Closed() bool {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return s.closed
+}
+
+// WaitFor waits until at least num samples have been received, or the sink has been closed, or the timeout expired.
+// The result indicates whether enough samples have been received.
+func (s *CapturingSink) WaitFor(num int, timeout time.Duration) bool {
+	return s.waitUntil(timeout, func() bool {
+		return len(s.samples) >= num || s.closed
+	}) && len(s.Samples()) >= num
+}
+
+// WaitClosed waits until the sink has been closed, or the timeout expired. The result indicates whether the sink has been closed.
+func (s *CapturingSink) WaitClosed(timeout time.Duration) bool {
+	return s.waitUntil(timeout, func() bool {
+		return s.closed
+	})
+}
+
+// waitUntil waits until the condition is true or the timeout expires. The condition is evaluated while holding the lock.
+func (s *CapturingSink) waitUntil(timeout time.Duration, condition func() bool) bool {
+	timedOut := false
+	timer := time.AfterFunc(timeout, func() {
+		s.cond.L.Lock()
+		defer s.cond.L.Unlock()
+		timedOut = true
+		s.cond.Broadcast()
+	})
+	defer timer.Stop()
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	for !condition() && !timedOut {
+		s.cond.Wait()
+	}
+	return condition()
+}
+
+// AssertCount checks the number of received samples.
+func (s *CapturingSink) AssertCount(t testing.TB, num int) bool {
+	t.Helper()
+	return assert.Len(t, s.Samples(), num, "Number of received samples")
+}
+
+// AssertClosed checks that Close() has been called.
+func (s *CapturingSink) AssertClosed(t testing.TB) bool {
+	t.Helper()
+	return assert.True(t, s.Closed(), "%v was not closed", s)
+}
+
+// AssertValues checks the values of all received samples.
+func (s *CapturingSink) AssertValues(t testing.TB, expected [][]float64) bool {
+	t.Helper()
+	return assert.Equal(t, expected, s.Values(), "Values of the received samples")
+}
+
+// AssertFields checks that all received samples had a header with the given fields.
+func (s *CapturingSink) AssertFields(t testing.TB, fields ...string) bool {
+	t.Helper()
+	ok := true
+	for i, header := range s.Headers() {
+		ok = assert.Equal(t, fields, header.Fields, "Header of sample %v", i) && ok
+	}
+	return ok
+}
+
+// AssertSamples compares the timestamps, tags and values of the received samples with the expected samples.
+func (s *CapturingSink) AssertSamples(t testing.TB, expected []*bitflow.Sample) bool {
+	t.Helper()
+	samples := s.Samples()
+	if !assert.Len(t, samples, len(expected), "Number of received samples") {
+		return false
+	}
+	ok := true
+	for i, sample := range samples {
+		msg := fmt.Sprintf("Sample %v", i)
+		ok = assert.True(t, expected[i].Time.Equal(sample.Time), "%v: expected time %v, but got %v", msg, expected[i].Time, sample.Time) && ok
+		ok = assert.Equal(t, expected[i].TagMap(), sample.TagMap(), "%v: tags", msg) && ok
+		ok = assert.Equal(t, expected[i].Values, sample.Values, "%v: values", msg) && ok
+	}
+	return ok
+}
+
+// RunProcessor sends the samples with the given header through the processor and closes it. The output of the processor
+// is captured by the returned CapturingSink. The first error returned by the processor is reported through the testing.TB.
+// The processor is started before sending the samples, and the function waits for all goroutines started by the processor.
+func RunProcessor(t testing.TB, processor bitflow.SampleProcessor, header *bitflow.Header, samples []*bitflow.Sample) *CapturingSink {
+	t.Helper()
+	sink := NewCapturingSink()
+	processor.SetSink(sink)
+	var wg sync.WaitGroup
+	stopped := processor.Start(&wg)
+	for i, sample := range samples {
+		if err := processor.Sample(sample, header); err != nil {
+			t.Errorf("%v returned an error for sample %v: %v", processor, i, err)
+			break
+		}
+	}
+	processor.Close()
+	if !stopped.IsNil() {
+		stopped.Wait()
+		assert.NoError(t, stopped.Err(), "Error of %v", processor)
+	}
+	wg.Wait()
+	return sink
+}
@@ -0,0 +1,109 @@
+// Package testsupport contains helpers for unit tests of data sources, processing steps and data sinks:
+// a MockSource emitting deterministic samples, a CapturingSink that stores and checks received samples,
+// and golden-file comparisons of marshalled samples.
+package testsupport
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+)
+
+// StartTime is the timestamp of the first sample created by MakeSamples. It is fixed to make marshalled samples reproducible.
+var StartTime = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SampleInterval is the difference between the timestamps of subsequent samples created by MakeSamples.
+const SampleInterval = time.Second
+
+// MakeHeader creates a header with the given number of fields, named m0, m1, ...
+func MakeHeader(numFields int) *bitflow.Header {
+	fields := make([]string, numFields)
+	for i := range fields {
+		fields[i] = "m" + strconv.Itoa(i)
+	}
+	return &bitflow.Header{Fields: fields}
+}
+
+// MakeSamples creates num samples for the given header. The values are random numbers in [0..1[ generated with the
+// given seed, the timestamps start at StartTime and increase by SampleInterval. Every sample has the tag 'index'
+// with the index of the sample. The same parameters always produce the same samples.
+func MakeSamples(header *bitflow.Header, num int, seed int64) []*bitflow.Sample {
+	random := rand.New(rand.NewSource(seed))
+	samples := make([]*bitflow.Sample, num)
+	for i := range samples {
+		values := make([]bitflow.Value, len(header.Fields))
+		for j := range values {
+			values[j] = bitflow.Value(random.Float64())
+		}
+		samples[i] = &bitflow.Sample{Values: values, Time: StartTime.Add(time.Duration(i) * SampleInterval)}
+		samples[i].SetTag("index", strconv.Itoa(i))
+	}
+	return samples
+}
+
+// NewSample creates a sample with the given values and a timestamp at the given offset from StartTime. The tags are given
+// in the format of Sample.TagString(), e.g. "host=a role=web", and can be empty. An invalid tag string causes a panic.
+func NewSample(offset time.Duration, tags string, values ...bitflow.Value) *bitflow.Sample {
+	sample := &bitflow.Sample{Values: values, Time: StartTime.Add(offset)}
+	if err := sample.ParseTagString(tags); err != nil {
+		panic(err)
+	}
+	return sample
+}
+
+// MockSource is a SampleSource that sends a fixed list of samples with the same header to its sink and closes it afterwards.
+// If Error is set, it is returned after sending all samples, and the sink is closed as well.
+// Copies of the samples are sent, so that the Samples can be compared to the output of the tested steps.
+type MockSource struct {
+	bitflow.AbstractSampleSource
+	Header  *bitflow.Header
+	Samples []*bitflow.Sample
+	Error   error
+
+	stopped golib.StopChan
+}
+
+// NewMockSource creates a MockSource sending num samples with numFields fields, created by MakeSamples with the given seed.
+func NewMockSource(numFields int, num int, seed int64) *MockSource {
+	header := MakeHeader(numFields)
+	return &MockSource{
+		Header:  header,
+		Samples: MakeSamples(header, num, seed),
+	}
+}
+
+func (s *MockSource) String() string {
+	return fmt.Sprintf("Mock source (%v samples, %v fields)", len(s.Samples), len(s.Header.Fields))
+}
+
+// Start implements the golib.Startable interface. The samples are sent in a separate goroutine.
+// Sending stops early when Close() is called or the sink returns an error.
+func (s *MockSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.stopped = golib.NewStopChan()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer s.CloseSinkParallel(wg)
+		for _, sample := range s.Samples {
+			if s.stopped.Stopped() {
+				return
+			}
+			if err := s.GetSink().Sample(sample.DeepClone(), s.Header); err != nil {
+				s.stopped.StopErr(err)
+				return
+			}
+		}
+		s.stopped.StopErr(s.Error)
+	}()
+	return s.stopped
+}
+
+// Close implements the SampleSource interface.
+func (s *MockSource) Close() {
+	s.stopped.Stop()
+}
@@ -0,0 +1,45 @@
+package testsupport
+
+import (
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// Suite is the base type for testify test suites. It makes the require assertions available as methods of the suite:
+//
+//	type myTestSuite struct {
+//	    testsupport.Suite
+//	}
+//
+//	func TestMy(t *testing.T) {
+//	    suite.Run(t, new(myTestSuite))
+//	}
+type Suite struct {
+	t *testing.T
+	*require.Assertions
+}
+
+// T implements the suite.TestingSuite interface.
+func (s *Suite) T() *testing.T {
+	return s.t
+}
+
+// SetT implements the suite.TestingSuite interface.
+func (s *Suite) SetT(t *testing.T) {
+	s.t = t
+	s.Assertions = require.New(t)
+}
+
+// SetS implements the suite.TestingSuite interface. The parent suite is not needed.
+func (*Suite) SetS(suite.TestingSuite) {
+}
+
+// Process sends the samples with the given header through the processor using RunProcessor and returns the CapturingSink
+// with the output of the processor.
+func (s *Suite) Process(processor bitflow.SampleProcessor, header *bitflow.Header, samples ...*bitflow.Sample) *CapturingSink {
+	s.t.Helper()
+	return RunProcessor(s.t, processor, header, samples)
+}
@@ -0,0 +1,81 @@
+package testsupport
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMakeSamples(t *testing.T) {
+	assert := testAssert.New(t)
+	header := MakeHeader(3)
+	assert.Equal([]string{"m0", "m1", "m2"}, header.Fields)
+	samples := MakeSamples(header, 5, 42)
+	assert.Len(samples, 5)
+	for i, sample := range samples {
+		assert.Len(sample.Values, 3)
+		assert.Equal(StartTime.Add(time.Duration(i)*SampleInterval), sample.Time)
+	}
+	assert.Equal("4", samples[4].Tag("index"))
+	assert.Equal(samples[2].Values, MakeSamples(header, 5, 42)[2].Values)
+	assert.NotEqual(samples[2].Values, MakeSamples(header, 5, 43)[2].Values)
+}
+
+func TestMockSource(t *testing.T) {
+	assert := testAssert.New(t)
+	source := NewMockSource(2, 10, 1)
+	source.Error = errors.New("mock error")
+	sink := NewCapturingSink()
+	source.SetSink(sink)
+
+	var wg sync.WaitGroup
+	stopped := source.Start(&wg)
+	assert.True(sink.WaitFor(10, time.Second))
+	stopped.Wait()
+	wg.Wait()
+	assert.EqualError(stopped.Err(), "mock error")
+	sink.AssertClosed(t)
+	sink.AssertFields(t, "m0", "m1")
+	sink.AssertSamples(t, source.Samples)
+	assert.False(sink.WaitFor(11, 10*time.Millisecond))
+}
+
+func TestRunProcessor(t *testing.T) {
+	header := MakeHeader(2)
+	samples := MakeSamples(header, 3, 1)
+	sink := RunProcessor(t, new(bitflow.NoopProcessor), header, samples)
+	sink.AssertCount(t, 3)
+	sink.AssertClosed(t)
+	sink.AssertValues(t, [][]float64{
+		{float64(samples[0].Values[0]), float64(samples[0].Values[1])},
+		{float64(samples[1].Values[0]), float64(samples[1].Values[1])},
+		{float64(samples[2].Values[0]), float64(samples[2].Values[1])},
+	})
+	AssertGolden(t, "noop.csv", new(bitflow.CsvMarshaller), sink)
+}
+
+type processTestSuite struct {
+	Suite
+}
+
+func TestProcess(t *testing.T) {
+	suite.Run(t, new(processTestSuite))
+}
+
+func (s *processTestSuite) TestProcess() {
+	header := &bitflow.Header{Fields: []string{"a"}}
+	out := s.Process(new(bitflow.NoopProcessor), header, NewSample(0, "host=a", 1), NewSample(time.Minute, "host=b role=x", 2))
+	out.AssertClosed(s.T())
+	s.Equal([][]float64{{1}, {2}}, out.Values())
+	s.Equal([]string{"a", "b"}, out.Tags("host"))
+	s.Equal([]string{"", "x"}, out.Tags("role"))
+	s.Equal([]time.Duration{0, time.Minute}, out.Offsets())
+	s.Panics(func() {
+		NewSample(0, "invalid")
+	})
+}
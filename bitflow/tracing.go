@@ -0,0 +1,74 @@
+package bitflow
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer recording the spans of batches and windows.
+//
+// Spans are recorded per batch (see BatchProcessor) and per window (see WindowProcessor), with one child span for every
+// BatchProcessingStep executed on the batch or window. The trace context of the batch is stored in the TraceParent of the
+// header of the resulting samples. The binary marshaller transports it over TCP, so the span of the next batch or window in
+// another process becomes a child of it. Individual samples are not traced and their tags are not modified.
+//
+// Spans are recorded through the global TracerProvider of the otel package, so tracing is disabled unless the
+// application installs an SDK TracerProvider (see otel.SetTracerProvider).
+const TracerName = "github.com/bitflow-stream/go-bitflow"
+
+var traceContextFormat = propagation.TraceContext{}
+
+// traceParentKey is the key of the traceparent in the carrier of propagation.TraceContext
+const traceParentKey = "traceparent"
+
+// HeaderTraceContext returns a context containing the remote span context stored in the TraceParent of the header.
+func HeaderTraceContext(ctx context.Context, header *Header) context.Context {
+	if header == nil || header.TraceParent == "" {
+		return ctx
+	}
+	return traceContextFormat.Extract(ctx, propagation.MapCarrier{traceParentKey: header.TraceParent})
+}
+
+// TracedHeader returns a header with the TraceParent of the span stored in the given context. The header is copied,
+// if the trace context changes. If the context has no valid span, the header is returned unchanged.
+func TracedHeader(ctx context.Context, header *Header) *Header {
+	if header == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return header
+	}
+	carrier := make(propagation.MapCarrier, 1)
+	traceContextFormat.Inject(ctx, carrier)
+	traceParent := carrier[traceParentKey]
+	if traceParent == header.TraceParent {
+		return header
+	}
+	return &Header{Fields: header.Fields, TraceParent: traceParent}
+}
+
+// startBatchSpan starts the span of a batch or window, as a child of the trace context of the header.
+func startBatchSpan(name string, header *Header, numSamples int) (context.Context, trace.Span) {
+	ctx := HeaderTraceContext(context.Background(), header)
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attribute.Int("bitflow.samples", numSamples)))
+}
+
+// finishSpan ends the given span and records the error, if any.
+func finishSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// executeTraced executes the given step on the batch inside a child span of the given context.
+func (e *batchExecution) executeTraced(ctx context.Context, step BatchProcessingStep) error {
+	_, span := otel.Tracer(TracerName).Start(ctx, fmt.Sprint(step), trace.WithAttributes(attribute.Int("bitflow.samples", e.numSamples())))
+	err := e.execute(step)
+	finishSpan(span, err)
+	return err
+}
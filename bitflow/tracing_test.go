@@ -0,0 +1,201 @@
+package bitflow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+type TracingTestSuite struct {
+	testSuiteBase
+	spans *tracetest.SpanRecorder
+}
+
+func TestTracing(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
+
+func (suite *TracingTestSuite) SetupTest() {
+	suite.spans = tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(suite.spans)))
+}
+
+func (suite *TracingTestSuite) TearDownTest() {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+}
+
+func passBatch(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+	return header, samples, nil
+}
+
+type failingBatchStep struct {
+	SimpleBatchProcessingStep
+}
+
+func (s *failingBatchStep) ProcessBatch(*Header, []*Sample) (*Header, []*Sample, error) {
+	return nil, nil, errors.New("failed")
+}
+
+func (suite *TracingTestSuite) runBatch(header *Header, steps ...BatchProcessingStep) (*tracingTestSink, error) {
+	batch := &BatchProcessor{Steps: steps}
+	sink := new(tracingTestSink)
+	batch.SetSink(sink)
+	var wg sync.WaitGroup
+	stopper := batch.Start(&wg)
+	for i := 0; i < 3; i++ {
+		sample := &Sample{Values: []Value{Value(i)}, Time: time.Unix(int64(i), 0)}
+		sample.SetTag("index", "x")
+		suite.NoError(batch.Sample(sample, header))
+	}
+	batch.Close()
+	wg.Wait()
+	return sink, stopper.Err()
+}
+
+func (suite *TracingTestSuite) TestBatchSpans() {
+	header := &Header{Fields: []string{"a"}, TraceParent: testTraceParent}
+	step := &SimpleBatchProcessingStep{Description: "step", Process: passBatch}
+	sink, err := suite.runBatch(header, step, step)
+	suite.NoError(err)
+
+	spans := suite.spans.Ended()
+	suite.Len(spans, 3)
+	batchSpan := spans[2]
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", batchSpan.SpanContext().TraceID().String())
+	suite.Equal("00f067aa0ba902b7", batchSpan.Parent().SpanID().String())
+	suite.True(batchSpan.Parent().IsRemote())
+	for _, stepSpan := range spans[:2] {
+		suite.Equal("step", stepSpan.Name())
+		suite.Equal(batchSpan.SpanContext().SpanID(), stepSpan.Parent().SpanID())
+	}
+
+	// The trace context is forwarded in the header, the samples are not modified
+	suite.Len(sink.samples, 3)
+	outHeader := sink.headers[0]
+	suite.Equal([]string{"a"}, outHeader.Fields)
+	suite.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-"+batchSpan.SpanContext().SpanID().String()+"-01", outHeader.TraceParent)
+	suite.Equal(testTraceParent, header.TraceParent)
+	suite.Equal(map[string]string{"index": "x"}, sink.samples[0].TagMap())
+}
+
+func (suite *TracingTestSuite) TestNewTrace() {
+	_, err := suite.runBatch(&Header{Fields: []string{"a"}}, &failingBatchStep{SimpleBatchProcessingStep{Description: "failing"}})
+	suite.EqualError(err, "failed")
+	spans := suite.spans.Ended()
+	suite.Len(spans, 2)
+	suite.False(spans[1].Parent().IsValid())
+	suite.Equal(codes.Error, spans[0].Status().Code)
+	suite.Equal(codes.Error, spans[1].Status().Code)
+}
+
+func (suite *TracingTestSuite) TestWindowSpans() {
+	window := &WindowProcessor{Mode: TumblingWindow, Size: 2 * time.Second}
+	sink := new(tracingTestSink)
+	window.SetSink(sink)
+	header := &Header{Fields: []string{"a"}, TraceParent: testTraceParent}
+	for i := 0; i < 5; i++ {
+		suite.NoError(window.Sample(&Sample{Values: []Value{1}, Time: time.Unix(int64(i), 0)}, header))
+	}
+	window.Close()
+
+	spans := suite.spans.Ended()
+	suite.Len(spans, 3)
+	suite.Len(sink.samples, 5)
+	for i, span := range spans {
+		suite.Equal("Tumbling window (size 2s)", span.Name())
+		suite.Equal("00f067aa0ba902b7", span.Parent().SpanID().String())
+		suite.Contains(sink.headers[i*2].TraceParent, span.SpanContext().SpanID().String())
+	}
+}
+
+func (suite *TracingTestSuite) TestDisabled() {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	header := &Header{Fields: []string{"a"}}
+	sink, err := suite.runBatch(header, &SimpleBatchProcessingStep{Process: passBatch})
+	suite.NoError(err)
+	suite.Equal(header, sink.headers[0])
+
+	// Without a TracerProvider, the trace context is passed through unchanged
+	header = &Header{Fields: []string{"a"}, TraceParent: testTraceParent}
+	sink, err = suite.runBatch(header, &SimpleBatchProcessingStep{Process: passBatch})
+	suite.NoError(err)
+	suite.Equal(testTraceParent, sink.headers[0].TraceParent)
+}
+
+func (suite *TracingTestSuite) TestHeaderTraceContext() {
+	ctx := HeaderTraceContext(context.Background(), &Header{TraceParent: testTraceParent})
+	spanContext := trace.SpanContextFromContext(ctx)
+	suite.True(spanContext.IsSampled())
+	suite.Equal("00f067aa0ba902b7", spanContext.SpanID().String())
+
+	header := &Header{Fields: []string{"a"}}
+	traced := TracedHeader(ctx, header)
+	suite.Equal(testTraceParent, traced.TraceParent)
+	suite.Empty(header.TraceParent)
+	suite.True(traced == TracedHeader(ctx, traced))
+	suite.True(header == TracedHeader(context.Background(), header))
+	suite.Equal(context.Background(), HeaderTraceContext(context.Background(), header))
+}
+
+func (suite *TracingTestSuite) TestBinaryTraceParent() {
+	var buf bytes.Buffer
+	var m BinaryMarshaller
+	suite.NoError(m.WriteHeader(&Header{Fields: []string{"a", "b"}, TraceParent: testTraceParent}, true, &buf))
+	suite.Equal("timB\ntags\ntraceparent="+testTraceParent+"\na\nb\n\n", buf.String())
+	header, _, err := m.Read(bufio.NewReader(&buf), nil)
+	suite.NoError(err)
+	suite.Equal([]string{"a", "b"}, header.Fields)
+	suite.Equal(testTraceParent, header.TraceParent)
+	suite.True(header.HasTags)
+
+	// Headers without trace context are not changed
+	buf.Reset()
+	suite.NoError(m.WriteHeader(&Header{Fields: []string{"a"}}, false, &buf))
+	suite.Equal("timB\na\n\n", buf.String())
+	header, _, err = m.Read(bufio.NewReader(&buf), nil)
+	suite.NoError(err)
+	suite.Equal([]string{"a"}, header.Fields)
+	suite.Empty(header.TraceParent)
+}
+
+func (suite *TracingTestSuite) TestRepeatHeader() {
+	write := func(m Marshaller) string {
+		var buf closingBuffer
+		stream := new(SampleWriter).Open(&buf, m)
+		sample := &Sample{Values: []Value{1}}
+		for _, traceParent := range []string{"", testTraceParent, testTraceParent} {
+			suite.NoError(stream.Sample(sample, &Header{Fields: []string{"a"}, TraceParent: traceParent}))
+		}
+		suite.NoError(stream.Close())
+		return buf.String()
+	}
+	// Only the binary format transports the trace context
+	suite.Equal(2, bytes.Count([]byte(write(BinaryMarshaller{})), []byte("timB")))
+	suite.Equal(1, bytes.Count([]byte(write(CsvMarshaller{})), []byte("time")))
+}
+
+type tracingTestSink struct {
+	DroppingSampleProcessor
+	samples []*Sample
+	headers []*Header
+}
+
+func (s *tracingTestSink) Sample(sample *Sample, header *Header) error {
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	return nil
+}
@@ -34,6 +34,8 @@ func (s *SynchronizingSampleSink) Sample(sample *Sample, header *Header) error {
 // SampleReader and SampleWriter. Both the reader and writer can marshall
 // and unmarshall Samples in parallel, and these routines are controlled
 // through the two parameters in ParallelSampleHandler.
+// Regardless of the parallelism, Samples are always delivered in the order in which
+// they were read or written.
 type ParallelSampleHandler struct {
 	// BufferedSamples is the number of Samples that are buffered between the
 	// marshall/unmarshall routines and the routine that writes/reads the input
@@ -115,6 +117,9 @@ type bufferedSample struct {
 }
 
 func (sample *bufferedSample) waitDone() {
+	if sample.doneCond == nil {
+		return // The sample was complete from the start
+	}
 	sample.doneCond.L.Lock()
 	defer sample.doneCond.L.Unlock()
 	for !sample.done {
@@ -6,7 +6,6 @@ import (
 	"sync"
 
 	"github.com/antongulenko/golib"
-	log "github.com/sirupsen/logrus"
 )
 
 // WriterSink implements SampleSink by writing all Headers and Samples to a single
@@ -35,7 +34,7 @@ func (sink *WriterSink) String() string {
 // Start implements the SampleSink interface. No additional goroutines are
 // spawned, only a log message is printed.
 func (sink *WriterSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
-	log.WithField("format", sink.Marshaller).Println("Printing samples to " + sink.Description)
+	sink.Log().WithField("format", sink.Marshaller).Println("Printing samples to " + sink.Description)
 	sink.stream = sink.Writer.Open(sink.Output, sink.Marshaller)
 	return
 }
@@ -44,11 +43,17 @@ func (sink *WriterSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
 // to the underlying io.WriteCloser and closes it.
 func (sink *WriterSink) Close() {
 	if err := sink.stream.Close(); err != nil {
-		log.Errorf("%v: Error closing output: %v", sink, err)
+		sink.Log().Errorln("Error closing output:", err)
 	}
 	sink.CloseSink()
 }
 
+// RetainsSamples implements the RetainingProcessor interface. The SampleOutputStream retains the samples
+// only until they are marshalled.
+func (sink *WriterSink) RetainsSamples() bool {
+	return false
+}
+
 // Header implements the SampleSink interface by using a SampleOutputStream to
 // write the given Sample to the configured io.WriteCloser.
 func (sink *WriterSink) Sample(sample *Sample, header *Header) error {
@@ -102,6 +107,6 @@ func (source *ReaderSource) Close() {
 	// from the outside, or the program is stopped forcefully.
 	err := source.stream.Close()
 	if err != nil && !IsFileClosedError(err) {
-		log.Errorf("%v: error closing output: %v", source, err)
+		source.Log().Errorln("Error closing output:", err)
 	}
 }
@@ -1,6 +1,7 @@
 package bitflow
 
 import (
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -184,10 +186,20 @@ func (group *FileGroup) DeleteFiles() error {
 	return err
 }
 
+// withSuffix returns a FileGroup for the files of the receiving group with the additional extension, e.g.
+// the compressed files 'data(-[0-9]+)?.csv.gz' for the group 'data(-[0-9]+)?.csv'.
+func (group *FileGroup) withSuffix(suffix string) FileGroup {
+	res := *group
+	res.filename += suffix
+	res.suffix += suffix
+	return res
+}
+
 // OpenFile attempts to open a new file that will belong to the file group.
 // An integer suffix is counted up to find a non-existing file. A small number
-// of errors is tolerated before giving up.
-func (group *FileGroup) OpenNewFile(counter *int) (file *os.File, err error) {
+// of errors is tolerated before giving up. A file name is also skipped, if the name
+// with one of the given reservedSuffixes appended exists, for example a compressed copy of the file.
+func (group *FileGroup) OpenNewFile(counter *int, reservedSuffixes ...string) (file *os.File, err error) {
 	num_errors := 0
 	file_num := *counter
 	for {
@@ -199,7 +211,10 @@ func (group *FileGroup) OpenNewFile(counter *int) (file *os.File, err error) {
 		}
 		file_num++
 
-		if _, err = os.Stat(name); os.IsNotExist(err) {
+		if _, err = os.Stat(name); os.IsNotExist(err) && isReservedFile(name, reservedSuffixes) {
+			// A file with a reserved suffix exists, try next one
+			continue
+		} else if os.IsNotExist(err) {
 			// File does not exist, try to open and create it
 
 			dir := path.Dir(name)
@@ -227,6 +242,15 @@ func (group *FileGroup) OpenNewFile(counter *int) (file *os.File, err error) {
 	}
 }
 
+func isReservedFile(name string, reservedSuffixes []string) bool {
+	for _, suffix := range reservedSuffixes {
+		if _, err := os.Stat(name + suffix); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // ==================== File data source ====================
 
 // FileSource is an implementation of UnmarshallingSampleSource that reads samples
@@ -311,7 +335,7 @@ func (source *FileSource) Start(wg *sync.WaitGroup) golib.StopChan {
 		source.CloseSinkParallel(wg)
 		return golib.NewStoppedChan(errors.New("No files specified for FileSource"))
 	} else if len(files) > 1 {
-		log.Println("Reading", len(files), "files")
+		source.Log().Println("Reading", len(files), "files")
 	}
 	source.readFilesKeepAlive(wg, files)
 	return source.closed
@@ -400,7 +424,7 @@ func IsFileClosedError(err error) bool {
 }
 
 // SynchronizedReadCloser is a helper type to wrap *os.File and synchronize calls
-// to Read() and Close(). This prevents race condition warnings from the Go race detector
+// to Read(), Seek() and Close(). This prevents race condition warnings from the Go race detector
 // due to parallel access to the fd field of the internal os.file type. The performance
 // overhead is not measurable, but this can be deactivated by setting the UnsynchronizedFileAccess
 // flag in FileSource.
@@ -415,6 +439,17 @@ func (s *SynchronizedReadCloser) Read(b []byte) (int, error) {
 	return s.ReadCloser.Read(b)
 }
 
+// Seek forwards to the wrapped io.ReadCloser, if it implements io.Seeker.
+func (s *SynchronizedReadCloser) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := s.ReadCloser.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("%T does not support seeking", s.ReadCloser)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return seeker.Seek(offset, whence)
+}
+
 func (s *SynchronizedReadCloser) Close() error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -423,6 +458,9 @@ func (s *SynchronizedReadCloser) Close() error {
 
 // ==================== File data sink ====================
 
+// CompressedFileSuffix is appended to the names of rotated output files that are compressed by FileSink.
+const CompressedFileSuffix = ".gz"
+
 // FileSink is an implementation of SampleSink that writes output Headers and Samples
 // to a given file. Every time a new Header is received by the FileSink, a new file is opened
 // using an automatically incremented number as suffix (see FileGroup). Other parameters
@@ -440,6 +478,12 @@ type FileSink struct {
 	// If errors occur while opening output files, a number of retries is attempted while incrementing
 	// the suffix, until the number of error exceeds MaxOutputFileErrors. After this, the FileSink stops
 	// and reports the last error. All intermediate errors are logged as warnings.
+	//
+	// Filename can contain placeholders, which are resolved for every sample through a TagTemplate:
+	// ${xxx} is replaced by the value of the tag xxx, ${time:<layout>} by the timestamp of the sample, formatted with
+	// the given Go time layout, e.g. ${time:2006-01-02}. Whenever the resolved file name changes, the current file
+	// is rotated and the new file is opened. Only one file is open at a time, so samples with interleaving tag values
+	// should be written through the MultiFileDistributor (step output_files) instead, which creates one FileSink per file.
 	Filename string
 
 	// IoBuffer defines the output buffer when writing samples to a file. It should be large
@@ -449,10 +493,12 @@ type FileSink struct {
 	// CleanFiles can be set to true to delete all files that would potentially collide with output files.
 	// In particular, this causes the following when starting the FileSink:
 	//   NewFileGroup(sink.Filename).DeleteFiles()
-	// When deleting these files fails, the FileSink stops and reports an error.
+	// When deleting these files fails, the FileSink stops and reports an error. If Filename contains placeholders,
+	// the files are deleted when a resolved file name is opened for the first time.
 	CleanFiles bool
 
 	// Append can be set to true to make the FileSink append data to a file, if it exists.
+	// Rotated files are never appended to.
 	Append bool
 
 	// VanishedFileCheck can be set to > 0 to enable a periodic check, if the currently opened
@@ -463,14 +509,48 @@ type FileSink struct {
 	// the VanishedFileCheck leads to the file be recreated, which could be the more expected behavior.
 	VanishedFileCheck time.Duration
 
+	// RotateSize can be set to > 0 to rotate the output file after the given number of bytes was written to it.
+	// Rotating opens the next file of the FileGroup, like receiving a new Header. The size is checked before writing
+	// a sample and only includes data that was already marshalled and written to the file. Since marshalling happens
+	// asynchronously and the data is buffered (IoBuffer), the files can become slightly larger than RotateSize.
+	RotateSize int64
+
+	// RotateInterval can be set to > 0 to rotate the output file in regular intervals. The intervals are aligned
+	// to the wall clock, e.g. an interval of one hour rotates the file with the first sample after every full hour.
+	RotateInterval time.Duration
+
+	// CompressRotated can be set to true to compress output files with gzip after they were rotated. The compressed
+	// file is named like the output file with the CompressedFileSuffix, and the uncompressed file is deleted.
+	// Files are compressed in the background. The file that is open when closing the FileSink is not compressed.
+	CompressRotated bool
+
+	// RetainFiles can be set to > 0 to keep only the given number of rotated files of the FileGroup (in addition to
+	// the file that is currently written), including compressed files. After every rotation, the files with the
+	// oldest modification time are deleted. If Filename contains placeholders, only the files of the same
+	// resolved file name are taken into account.
+	RetainFiles int
+
+	// RetainAge can be set to > 0 to delete rotated files of the FileGroup that were not modified for the given
+	// duration. Like RetainFiles, this is checked after every rotation.
+	RetainAge time.Duration
+
 	checker               HeaderChecker
 	group                 FileGroup
 	file_num              int
 	stream                *SampleOutputStream
 	closed                golib.StopChan
 	currentFile           string
+	currentGroup          FileGroup
 	currentIno            uint64
 	lastVanishedFileCheck time.Time
+
+	template         bool
+	resolvedFilename string
+	cleanedFiles     map[string]bool
+	output           *countingWriteCloser
+	rotateTime       time.Time
+	wg               *sync.WaitGroup
+	rotationLock     *sync.Mutex
 }
 
 // String implements the SampleSink interface.
@@ -481,19 +561,43 @@ func (sink *FileSink) String() string {
 // Start implements the SampleSink interface. It does not start any goroutines.
 // It initialized the FileSink, prints some log messages, and depending on the
 // CleanFiles flag tries to delete existing files that would conflict with the output file.
+// The WaitGroup is used for compressing and deleting rotated files in the background.
 func (sink *FileSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
-	log.WithFields(log.Fields{"file": sink.Filename, "format": sink.Marshaller}).Println("Writing samples")
+	sink.Log().WithFields(log.Fields{"file": sink.Filename, "format": sink.Marshaller}).Println("Writing samples")
 	sink.closed = golib.NewStopChan()
-	sink.group = NewFileGroup(sink.Filename)
-	if sink.CleanFiles {
-		if err := sink.group.DeleteFiles(); err != nil {
-			return golib.NewStoppedChan(fmt.Errorf("Failed to clean result files: %v", err))
+	sink.wg = wg
+	sink.rotationLock = new(sync.Mutex)
+	sink.cleanedFiles = nil
+	sink.template = templateRegex.MatchString(sink.Filename)
+	if !sink.template {
+		if err := sink.setFilename(sink.Filename); err != nil {
+			return golib.NewStoppedChan(err)
 		}
 	}
-	sink.file_num = 0
 	return
 }
 
+func (sink *FileSink) setFilename(filename string) error {
+	sink.resolvedFilename = filename
+	sink.group = NewFileGroup(filename)
+	sink.file_num = 0
+	if sink.CleanFiles && !sink.cleanedFiles[filename] {
+		if sink.cleanedFiles == nil {
+			sink.cleanedFiles = make(map[string]bool)
+		}
+		sink.cleanedFiles[filename] = true
+		err := sink.group.DeleteFiles()
+		if err == nil && sink.CompressRotated {
+			compressed := sink.group.withSuffix(CompressedFileSuffix)
+			err = compressed.DeleteFiles()
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to clean result files: %v", err)
+		}
+	}
+	return nil
+}
+
 func (sink *FileSink) flush() error {
 	if sink.stream != nil {
 		return sink.stream.Close()
@@ -501,6 +605,14 @@ func (sink *FileSink) flush() error {
 	return nil
 }
 
+// FlushCheckpoint implements the CheckpointFlusher interface by writing all received samples to the current file.
+func (sink *FileSink) FlushCheckpoint() error {
+	if sink.stream != nil {
+		return sink.stream.Flush()
+	}
+	return nil
+}
+
 // Close implements the SampleSink interface. It flushes and closes the currently open file.
 // No more data should be written to Sample/Header after calling Close.
 func (sink *FileSink) Close() {
@@ -512,53 +624,85 @@ func (sink *FileSink) Close() {
 	})
 }
 
-func (sink *FileSink) openNextFile() (err error) {
+// openNextFile closes the current file and opens the next one. If finishPrevious is true, the closed file is
+// compressed and the retention policy is applied, if configured.
+func (sink *FileSink) openNextFile(rotate bool, finishPrevious bool) (err error) {
 	sink.closed.IfElseStopped(func() {
 		err = errors.New(sink.String() + " is closed")
 	}, func() {
 		if err = sink.flush(); err != nil {
 			return
 		}
+		previousFile, previousGroup := sink.currentFile, sink.currentGroup
 		var file *os.File
-		file, err = sink.openNextNewFile()
+		file, err = sink.openNextNewFile(rotate)
 		if err == nil {
 			sink.currentFile = file.Name()
-			if sink.VanishedFileCheck > 0 {
-				stat, statErr := file.Stat()
-				if statErr != nil {
-					err = statErr
-				}
+			sink.currentGroup = sink.group
+			stat, statErr := file.Stat()
+			if statErr != nil {
+				err = statErr
+			} else {
 				sink.currentIno = stat.Sys().(*syscall.Stat_t).Ino
-			}
-			if err == nil {
-				sink.stream = sink.Writer.OpenBuffered(file, sink.Marshaller, sink.IoBuffer)
+				sink.output = &countingWriteCloser{WriteCloser: file, written: stat.Size()}
+				sink.stream = sink.Writer.OpenBuffered(sink.output, sink.Marshaller, sink.IoBuffer)
+				if sink.RotateInterval > 0 {
+					sink.rotateTime = time.Now().Truncate(sink.RotateInterval).Add(sink.RotateInterval)
+				}
 				log.WithField("file", file.Name()).Println("Opened file")
 			}
+			if finishPrevious && previousFile != "" && previousFile != sink.currentFile {
+				sink.finishFile(previousFile, previousGroup, sink.currentFile)
+			}
 		}
 	})
 	return
 }
 
-func (sink *FileSink) openNextNewFile() (*os.File, error) {
-	if sink.Append {
-		file, err := os.OpenFile(sink.Filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+func (sink *FileSink) openNextNewFile(rotate bool) (*os.File, error) {
+	if sink.Append && !rotate && sink.file_num == 0 {
+		file, err := os.OpenFile(sink.group.filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
 		if err == nil {
 			return file, nil
 		} else if !os.IsNotExist(err) {
-			log.WithField("file", sink.Filename).Warnln("Failed to append to file:", err)
+			log.WithField("file", sink.group.filename).Warnln("Failed to append to file:", err)
 		}
 	}
-	return sink.group.OpenNewFile(&sink.file_num)
+	var reserved []string
+	if sink.CompressRotated {
+		reserved = append(reserved, CompressedFileSuffix)
+	}
+	return sink.group.OpenNewFile(&sink.file_num, reserved...)
+}
+
+// RetainsSamples implements the RetainingProcessor interface. The SampleOutputStream retains the samples
+// only until they are marshalled.
+func (sink *FileSink) RetainsSamples() bool {
+	return false
 }
 
 // Sample writes a Sample to the current open file.
 func (sink *FileSink) Sample(sample *Sample, header *Header) error {
 	openNewFile := sink.checker.HeaderChanged(header) || sink.stream == nil
+	if sink.template {
+		if filename := (TagTemplate{Template: sink.Filename}).Resolve(sample); filename != sink.resolvedFilename {
+			if err := sink.setFilename(filename); err != nil {
+				return err
+			}
+			openNewFile = true
+		}
+	}
+	rotate, vanished := false, false
+	if !openNewFile {
+		rotate = sink.rotationDue()
+		openNewFile = rotate
+	}
 	if !openNewFile && sink.VanishedFileCheck > 0 {
-		openNewFile = sink.checkOutputFile()
+		vanished = sink.checkOutputFile()
+		openNewFile = vanished
 	}
 	if openNewFile {
-		if err := sink.openNextFile(); err != nil {
+		if err := sink.openNextFile(rotate, !vanished); err != nil {
 			return err
 		}
 	}
@@ -566,6 +710,13 @@ func (sink *FileSink) Sample(sample *Sample, header *Header) error {
 	return sink.AbstractMarshallingSampleOutput.Sample(err, sample, header)
 }
 
+func (sink *FileSink) rotationDue() bool {
+	if sink.RotateSize > 0 && sink.output.Written() >= sink.RotateSize {
+		return true
+	}
+	return sink.RotateInterval > 0 && !time.Now().Before(sink.rotateTime)
+}
+
 func (sink *FileSink) checkOutputFile() (openNewFile bool) {
 	now := time.Now()
 	if now.Sub(sink.lastVanishedFileCheck) > sink.VanishedFileCheck {
@@ -587,3 +738,111 @@ func (sink *FileSink) checkOutputFile() (openNewFile bool) {
 	}
 	return
 }
+
+// finishFile compresses a rotated file and applies the retention policy to its FileGroup in the background.
+// The background tasks are serialized, so that the retention policy sees the compressed files.
+func (sink *FileSink) finishFile(filename string, group FileGroup, currentFile string) {
+	compress, retain := sink.CompressRotated, sink.RetainFiles > 0 || sink.RetainAge > 0
+	if !compress && !retain {
+		return
+	}
+	if sink.wg != nil {
+		sink.wg.Add(1)
+	}
+	go func() {
+		if sink.wg != nil {
+			defer sink.wg.Done()
+		}
+		sink.rotationLock.Lock()
+		defer sink.rotationLock.Unlock()
+		if compress {
+			if err := compressFile(filename); err != nil {
+				log.WithField("file", filename).Errorln("Failed to compress rotated file:", err)
+			}
+		}
+		if retain {
+			sink.deleteOldFiles(group, currentFile)
+		}
+	}()
+}
+
+func (sink *FileSink) deleteOldFiles(group FileGroup, currentFile string) {
+	type groupFile struct {
+		name     string
+		modified time.Time
+	}
+	var files []groupFile
+	currentFile = filepath.Clean(currentFile)
+	walk := func(path string, info os.FileInfo) error {
+		if filepath.Clean(path) != currentFile {
+			files = append(files, groupFile{path, info.ModTime()})
+		}
+		return nil
+	}
+	_, err := group.WalkFiles(walk)
+	if err == nil {
+		compressed := group.withSuffix(CompressedFileSuffix)
+		_, err = compressed.WalkFiles(walk)
+	}
+	if err != nil {
+		log.WithField("file", group.filename).Warnln("Failed to list rotated files:", err)
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modified.After(files[j].modified)
+	})
+	deadline := time.Now().Add(-sink.RetainAge)
+	for i, file := range files {
+		if (sink.RetainFiles > 0 && i >= sink.RetainFiles) || (sink.RetainAge > 0 && file.modified.Before(deadline)) {
+			if err := os.Remove(file.name); err != nil && !os.IsNotExist(err) {
+				log.WithField("file", file.name).Warnln("Failed to delete rotated file:", err)
+			} else {
+				log.WithField("file", file.name).Println("Deleted rotated file")
+			}
+		}
+	}
+}
+
+// compressFile replaces the given file with a gzip compressed copy named with the CompressedFileSuffix.
+func compressFile(filename string) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close() // Drop error
+	out, err := os.OpenFile(filename+CompressedFileSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// countingWriteCloser counts the bytes written to the wrapped io.WriteCloser. The counter is accessed atomically,
+// because the SampleOutputStream can write from a background goroutine.
+type countingWriteCloser struct {
+	io.WriteCloser
+	written int64
+}
+
+func (c *countingWriteCloser) Write(b []byte) (int, error) {
+	n, err := c.WriteCloser.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+// Written returns the number of bytes written so far.
+func (c *countingWriteCloser) Written() int64 {
+	return atomic.LoadInt64(&c.written)
+}
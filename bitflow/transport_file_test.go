@@ -1,13 +1,16 @@
 package bitflow
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/suite"
@@ -159,3 +162,123 @@ func (suite *FileTestSuite) TestFilesAllCsv() {
 func (suite *FileTestSuite) TestFilesAllBinary() {
 	suite.testAllHeaders(new(BinaryMarshaller))
 }
+
+func (suite *FileTestSuite) writeRotated(out *FileSink, samples ...*Sample) {
+	out.SetMarshaller(new(CsvMarshaller))
+	out.SetSink(new(DroppingSampleProcessor))
+	out.Writer.ParallelSampleHandler = parallel_handler
+	var wg sync.WaitGroup
+	ch := out.Start(&wg)
+	header := &Header{Fields: []string{"a"}}
+	for _, sample := range samples {
+		suite.NoError(out.Sample(sample, header))
+		if out.RotateSize > 0 {
+			// Samples are written asynchronously, wait for the data to reach the file to make the rotation deterministic
+			for start := time.Now(); out.output.Written() == 0 && time.Since(start) < time.Second; {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	out.Close()
+	wg.Wait()
+	suite.NoError(ch.Err())
+}
+
+func (suite *FileTestSuite) rotationSamples(num int) []*Sample {
+	samples := make([]*Sample, num)
+	for i := range samples {
+		samples[i] = &Sample{Values: []Value{Value(i)}, Time: time.Date(2020, 1, 1, i, 0, 0, 0, time.UTC)}
+		samples[i].SetTag("host", fmt.Sprintf("host%v", i%2))
+	}
+	return samples
+}
+
+func (suite *FileTestSuite) listFiles(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	suite.NoError(err)
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.Name()
+	}
+	return names
+}
+
+func (suite *FileTestSuite) rotationDir() string {
+	suite.fileIndex++
+	dir := filepath.Join(suite.dir, fmt.Sprintf("rotation-%v", suite.fileIndex))
+	suite.NoError(os.Mkdir(dir, MkdirsPermissions))
+	return dir
+}
+
+func (suite *FileTestSuite) TestRotateSize() {
+	dir := suite.rotationDir()
+	out := &FileSink{Filename: filepath.Join(dir, "data.csv"), RotateSize: 1}
+	suite.writeRotated(out, suite.rotationSamples(3)...)
+	suite.Equal([]string{"data-1.csv", "data-2.csv", "data.csv"}, suite.listFiles(dir))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "data-2.csv"))
+	suite.NoError(err)
+	suite.Contains(string(content), "time,tags,a\n", "Every rotated file must contain the header")
+}
+
+func (suite *FileTestSuite) TestRotateInterval() {
+	dir := suite.rotationDir()
+	out := &FileSink{Filename: filepath.Join(dir, "data.csv"), RotateInterval: 50 * time.Millisecond}
+	out.SetMarshaller(new(CsvMarshaller))
+	out.SetSink(new(DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	out.Start(&wg)
+	header := &Header{Fields: []string{"a"}}
+	samples := suite.rotationSamples(3)
+	suite.NoError(out.Sample(samples[0], header))
+	suite.NoError(out.Sample(samples[1], header))
+	time.Sleep(60 * time.Millisecond)
+	suite.NoError(out.Sample(samples[2], header))
+	out.Close()
+	wg.Wait()
+	suite.Equal([]string{"data-1.csv", "data.csv"}, suite.listFiles(dir))
+}
+
+func (suite *FileTestSuite) TestCompressAndRetain() {
+	dir := suite.rotationDir()
+	out := &FileSink{Filename: filepath.Join(dir, "data.csv"), RotateSize: 1, CompressRotated: true, RetainFiles: 2}
+	suite.writeRotated(out, suite.rotationSamples(5)...)
+	suite.Equal([]string{"data-2.csv.gz", "data-3.csv.gz", "data-4.csv"}, suite.listFiles(dir))
+
+	file, err := os.Open(filepath.Join(dir, "data-3.csv.gz"))
+	suite.NoError(err)
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	suite.NoError(err)
+	content, err := ioutil.ReadAll(reader)
+	suite.NoError(err)
+	suite.True(strings.HasPrefix(string(content), "time,tags,a\n2020-01-01 03:00:00"), string(content))
+
+	// Names of compressed files must not be reused
+	out = &FileSink{Filename: filepath.Join(dir, "data.csv"), RotateSize: 1, CompressRotated: true}
+	suite.writeRotated(out, suite.rotationSamples(3)...)
+	suite.Equal([]string{"data-1.csv.gz", "data-2.csv.gz", "data-3.csv.gz", "data-4.csv", "data-5.csv", "data.csv.gz"}, suite.listFiles(dir))
+
+	out = &FileSink{Filename: filepath.Join(dir, "data.csv"), CleanFiles: true, CompressRotated: true}
+	suite.writeRotated(out, suite.rotationSamples(1)...)
+	suite.Equal([]string{"data.csv"}, suite.listFiles(dir), "Compressed files must be cleaned")
+}
+
+func (suite *FileTestSuite) TestRetainAge() {
+	dir := suite.rotationDir()
+	old := filepath.Join(dir, "data-7.csv")
+	suite.NoError(ioutil.WriteFile(old, []byte("old"), 0666))
+	suite.NoError(os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+	out := &FileSink{Filename: filepath.Join(dir, "data.csv"), RotateSize: 1, RetainAge: time.Minute}
+	suite.writeRotated(out, suite.rotationSamples(2)...)
+	suite.Equal([]string{"data-1.csv", "data.csv"}, suite.listFiles(dir))
+}
+
+func (suite *FileTestSuite) TestFilenameTemplate() {
+	dir := suite.rotationDir()
+	out := &FileSink{Filename: filepath.Join(dir, "${host}-${time:2006-01-02T15}.csv")}
+	samples := suite.rotationSamples(3)
+	samples[1].SetTag("host", "host0")
+	suite.writeRotated(out, samples...)
+	suite.Equal([]string{"host0-2020-01-01T00.csv", "host0-2020-01-01T01.csv", "host0-2020-01-01T02.csv"}, suite.listFiles(dir))
+}
@@ -2,6 +2,7 @@ package bitflow
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"sync"
 
@@ -23,6 +24,10 @@ type SampleReader struct {
 	// the data source and can use it to modify tags in the Samples.
 	Handler ReadSampleHandler
 
+	// Tracker is an optional hook for observing the samples forwarded by input streams and
+	// skipping samples that were already processed, e.g. when resuming from a checkpoint.
+	Tracker ReadProgressTracker
+
 	// Unmarshaller will be used when reading and parsing Headers and Samples.
 	// If this field is nil when creating an input stream, the SampleInputStream will try
 	// to automatically determine the format of the incoming data and create
@@ -56,10 +61,14 @@ type SampleInputStream struct {
 	um               Unmarshaller
 	sampleReader     *SampleReader
 	reader           *bufio.Reader
+	counter          *countingReader
 	underlyingReader io.ReadCloser
 	num_samples      int
 	header           *UnmarshalledHeader // Header received from the input stream
+	headerOffset     int64               // Position of the current header in the input stream
+	resume           *ReadPosition       // Set while resuming from a ReadProgressTracker position
 	outHeader        *Header             // Header after modified by the ReadSampleHandler
+	numValues        int                 // Capacity of the Values slices required by the sink
 	sink             SampleSink
 }
 
@@ -76,9 +85,11 @@ func (r *SampleReader) Open(input io.ReadCloser, sink SampleSink) *SampleInputSt
 // MinimumInputIoBuffer bytes to support automatically discovering the input stream format. See Open() for
 // more details.
 func (r *SampleReader) OpenBuffered(input io.ReadCloser, sink SampleSink, bufSize int) *SampleInputStream {
+	counter := &countingReader{reader: input}
 	return &SampleInputStream{
 		um:               r.Unmarshaller,
-		reader:           bufio.NewReaderSize(input, bufSize),
+		reader:           bufio.NewReaderSize(counter, bufSize),
+		counter:          counter,
 		sampleReader:     r,
 		underlyingReader: input,
 		sink:             sink,
@@ -103,18 +114,30 @@ func (stream *SampleInputStream) ReadSamples(source string) (int, error) {
 			stream.um = um
 		}
 	}
-
-	// Parse samples
-	for i := 0; i < stream.sampleReader.ParallelParsers || i < 1; i++ {
-		stream.wg.Add(1)
-		go stream.parseSamples(source)
+	if tracker := stream.sampleReader.Tracker; tracker != nil {
+		if pos, ok := tracker.ResumePosition(source); ok {
+			log.WithField("source", source).Debugln("Resuming at byte offset", pos.Offset)
+			if err := stream.seek(pos.Header); err != nil {
+				return 0, err
+			}
+			stream.resume = &pos
+		}
 	}
 
 	// Forward parsed samples
 	stream.wg.Add(1)
-	go stream.sinkSamples()
+	go stream.sinkSamples(source)
 
-	stream.readData(source)
+	if direct, ok := stream.um.(DirectUnmarshaller); ok {
+		stream.readDirect(source, direct)
+	} else {
+		// Parse samples
+		for i := 0; i < stream.sampleReader.ParallelParsers || i < 1; i++ {
+			stream.wg.Add(1)
+			go stream.parseSamples(source)
+		}
+		stream.readData(source)
+	}
 	stream.wg.Wait()
 	return stream.num_samples, stream.getErrorNoEOF()
 }
@@ -207,6 +230,7 @@ func (stream *SampleInputStream) readData(source string) {
 			return
 		}
 
+		start := stream.position()
 		header, data, err := stream.um.Read(stream.reader, stream.header)
 		if err != nil {
 			stream.addError(err)
@@ -215,11 +239,15 @@ func (stream *SampleInputStream) readData(source string) {
 			}
 		}
 		if header != nil {
-			stream.updateHeader(header, source)
+			stream.updateHeader(header, start, source)
+			if !stream.resumeAfterHeader() {
+				return
+			}
 		} else {
 			s := &bufferedIncomingSample{
 				inHeader:  stream.header,
 				outHeader: stream.outHeader,
+				position:  ReadPosition{Header: stream.headerOffset, Offset: stream.position()},
 				bufferedSample: bufferedSample{
 					stream:   &stream.parallelSampleStream,
 					data:     data,
@@ -239,21 +267,127 @@ func (stream *SampleInputStream) readData(source string) {
 	}
 }
 
-func (stream *SampleInputStream) updateHeader(header *UnmarshalledHeader, source string) {
+// readDirect replaces readData and the parsing routines for DirectUnmarshallers, which return parsed samples
+func (stream *SampleInputStream) readDirect(source string, um DirectUnmarshaller) {
+	defer func() {
+		stream.closeUnderlyingReader()
+		close(stream.incoming)
+		close(stream.outgoing)
+	}()
+	closedChan := stream.closed.WaitChan()
+	handler := stream.sampleReader.Handler
+	for {
+		if stream.hasError() {
+			return
+		}
+
+		start := stream.position()
+		header, sample, err := um.ReadSample(stream.reader, stream.header, stream.numValues)
+		if err != nil {
+			stream.addError(err)
+			if err != io.EOF || (sample == nil && header == nil) {
+				return
+			}
+		}
+		if header != nil {
+			stream.updateHeader(header, start, source)
+			if !stream.resumeAfterHeader() {
+				return
+			}
+		} else {
+			if handler != nil {
+				handler.HandleSample(sample, source)
+			}
+			s := &bufferedIncomingSample{
+				inHeader:  stream.header,
+				outHeader: stream.outHeader,
+				position:  ReadPosition{Header: stream.headerOffset, Offset: stream.position()},
+				bufferedSample: bufferedSample{
+					stream: &stream.parallelSampleStream,
+					sample: sample,
+					done:   true,
+				},
+			}
+			select {
+			case stream.outgoing <- s:
+			case <-closedChan:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (stream *SampleInputStream) updateHeader(header *UnmarshalledHeader, offset int64, source string) {
 	logger := log.WithFields(log.Fields{"format": stream.um, "source": source})
 	if stream.header == nil {
 		logger.Println("Reading", len(header.Fields), "metrics")
-	} else {
+	} else if !header.Equals(&stream.header.Header) {
+		// Headers that only update the TraceParent are not logged
 		logger.Println("Updated header to", len(header.Fields), "metrics")
 	}
 	stream.header = header
-	stream.outHeader = new(Header)
+	stream.headerOffset = offset
+	stream.numValues = RequiredValues(len(header.Fields), stream.sink)
+	stream.outHeader = &Header{TraceParent: header.TraceParent}
 	if numFields := len(header.Fields); numFields > 0 {
 		stream.outHeader.Fields = make([]string, numFields)
 		copy(stream.outHeader.Fields, header.Fields)
 	}
 }
 
+// position returns the number of bytes consumed from the underlying reader, excluding buffered data
+func (stream *SampleInputStream) position() int64 {
+	return stream.counter.read - int64(stream.reader.Buffered())
+}
+
+// resumeAfterHeader continues reading after the resume position, once the header of the resumed position was read.
+func (stream *SampleInputStream) resumeAfterHeader() bool {
+	if stream.resume == nil {
+		return true
+	}
+	offset := stream.resume.Offset
+	stream.resume = nil
+	if err := stream.seek(offset); err != nil {
+		stream.addError(err)
+		return false
+	}
+	return true
+}
+
+// seek positions the input stream at the given byte offset. If the underlying reader does not support seeking,
+// the data up to the offset is skipped, which is only possible in the forward direction.
+func (stream *SampleInputStream) seek(offset int64) error {
+	if seeker, ok := stream.underlyingReader.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		if err == nil {
+			stream.reader.Reset(stream.counter)
+			stream.counter.read = offset
+			return nil
+		}
+		log.Debugln("Seeking failed, skipping data instead:", err)
+	}
+	current := stream.position()
+	if offset < current {
+		return fmt.Errorf("Cannot seek back to byte offset %v, the input stream is already at offset %v", offset, current)
+	}
+	for offset > current {
+		// Discard in chunks, since the int parameter of Discard can overflow on 32 bit platforms
+		chunk := offset - current
+		if chunk > 1<<20 {
+			chunk = 1 << 20
+		}
+		discarded, err := stream.reader.Discard(int(chunk))
+		current += int64(discarded)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (stream *SampleInputStream) parseSamples(source string) {
 	defer stream.wg.Done()
 	for sample := range stream.incoming {
@@ -264,7 +398,12 @@ func (stream *SampleInputStream) parseSamples(source string) {
 func (stream *SampleInputStream) parseOne(source string, sample *bufferedIncomingSample) {
 	defer sample.notifyDone()
 	numValues := RequiredValues(len(sample.inHeader.Fields), stream.sink)
-	if parsedSample, err := stream.um.ParseSample(sample.inHeader, numValues, sample.data); err != nil {
+	parsedSample, err := stream.um.ParseSample(sample.inHeader, numValues, sample.data)
+	if releaser, ok := stream.um.(DataReleasingUnmarshaller); ok {
+		releaser.ReleaseData(sample.data)
+	}
+	sample.data = nil
+	if err != nil {
 		stream.addError(err)
 		sample.ParserError = true
 		return
@@ -276,15 +415,27 @@ func (stream *SampleInputStream) parseOne(source string, sample *bufferedIncomin
 	}
 }
 
-func (stream *SampleInputStream) sinkSamples() {
+func (stream *SampleInputStream) sinkSamples(source string) {
 	defer stream.wg.Done()
+	tracker := stream.sampleReader.Tracker
 	for sample := range stream.outgoing {
 		sample.waitDone()
 		if sample.ParserError {
 			// The first parser error makes the input stream stop.
 			return
 		}
-		if err := stream.sink.Sample(sample.sample, sample.outHeader); err != nil {
+		if tracker != nil && tracker.SkipSample(source, sample.sample) {
+			continue
+		}
+		var err error
+		if tracker != nil {
+			err = tracker.ForwardSample(source, sample.sample, sample.position, func() error {
+				return stream.sink.Sample(sample.sample, sample.outHeader)
+			})
+		} else {
+			err = stream.sink.Sample(sample.sample, sample.outHeader)
+		}
+		if err != nil {
 			stream.addError(err)
 			return
 		}
@@ -299,4 +450,17 @@ type bufferedIncomingSample struct {
 	ParserError bool
 	inHeader    *UnmarshalledHeader
 	outHeader   *Header
+	position    ReadPosition
+}
+
+// countingReader counts the bytes read from the wrapped reader
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.read += int64(n)
+	return n, err
 }
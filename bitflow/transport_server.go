@@ -5,7 +5,6 @@ import (
 	"sync"
 
 	"github.com/antongulenko/golib"
-	log "github.com/sirupsen/logrus"
 )
 
 // TCPListenerSource implements the SampleSource interface as a TCP server.
@@ -65,13 +64,13 @@ func (source *TCPListenerSource) Start(wg *sync.WaitGroup) golib.StopChan {
 		source.synchronizedSink = &SynchronizingSampleSink{Out: source.GetSink()}
 	}
 	return source.task.ExtendedStart(func(addr net.Addr) {
-		log.WithField("format", source.Reader.Format()).Println("Listening for incoming data on", addr)
+		source.Log().WithField("format", source.Reader.Format()).Println("Listening for incoming data on", addr)
 	}, wg)
 }
 
 func (source *TCPListenerSource) handleConnection(wg *sync.WaitGroup, conn *net.TCPConn) {
 	if source.SimultaneousConnections > 0 && len(source.connections) >= int(source.SimultaneousConnections) {
-		log.WithField("remote", conn.RemoteAddr()).Warnln("Rejecting connection, already have", len(source.connections), "connections")
+		source.Log().WithField("remote", conn.RemoteAddr()).Warnln("Rejecting connection, already have", len(source.connections), "connections")
 		_ = conn.Close() // Drop error
 		return
 	}
@@ -79,7 +78,7 @@ func (source *TCPListenerSource) handleConnection(wg *sync.WaitGroup, conn *net.
 		_ = conn.Close() // Drop error
 		return
 	}
-	log.WithField("remote", conn.RemoteAddr()).Debugln("Accepted connection")
+	source.Log().WithField("remote", conn.RemoteAddr()).Debugln("Accepted connection")
 	listenerConn := &tcpListenerConnection{
 		source:   source,
 		stream:   source.Reader.Open(conn, source.synchronizedSink),
@@ -203,7 +202,7 @@ func (sink *TCPListenerSink) Start(wg *sync.WaitGroup) golib.StopChan {
 		Handler: sink.handleConnection,
 	}
 	return sink.task.ExtendedStart(func(addr net.Addr) {
-		log.WithField("format", sink.Marshaller).Println("Listening for output connections on", addr)
+		sink.Log().WithField("format", sink.Marshaller).Println("Listening for output connections on", addr)
 	}, wg)
 }
 
@@ -0,0 +1,417 @@
+package bitflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultTailPollInterval is used by TailSource instances that do not define a PollInterval.
+	DefaultTailPollInterval = time.Second
+
+	// TailFingerprintSize is the maximum number of bytes at the start of a followed file that are used to
+	// recognize the file when resuming from a TailSource.OffsetsFile.
+	TailFingerprintSize = 1024
+)
+
+// TailSource is an implementation of UnmarshallingSampleSource that follows all files matching a list of
+// glob patterns, similar to 'tail -F': new files are read as soon as they appear, and data appended to the files
+// is read continuously. Every file is read in a separate goroutine, so the samples of multiple files are
+// forwarded in an interleaved fashion, synchronized through a SynchronizingSampleSink.
+//
+// Files are identified by their inode, so renaming a file does not lead to reading it again. When a followed file
+// is removed or replaced (e.g. by a log rotation), the remaining data of the old file is read, and the new file is
+// picked up by the next check of the patterns. When a file is truncated, it is read again from the start.
+// The TailSource does not stop on its own and must be closed explicitly.
+type TailSource struct {
+	AbstractUnmarshallingSampleSource
+
+	// Patterns contains glob patterns (see filepath.Match) defining the files to follow, e.g. /var/log/data/*.csv
+	Patterns []string
+
+	// PollInterval defines how often the Patterns are checked for new files, and how often the followed files are
+	// checked for new data after reaching their end. Default: DefaultTailPollInterval
+	PollInterval time.Duration
+
+	// OffsetsFile can be set to store the number of samples read from every followed file. When the TailSource is
+	// started and the file exists, the samples that were already read are skipped. Files are identified by their inode
+	// and a fingerprint of their first TailFingerprintSize bytes, because inodes can be reused for new files.
+	// The file is updated after every check of the Patterns and when the TailSource is closed.
+	OffsetsFile string
+
+	// IoBuffer configures the buffer size for read files.
+	IoBuffer int
+
+	sink      SampleSink
+	loop      *golib.LoopTask
+	closed    golib.StopChan
+	followers sync.WaitGroup
+	lock      sync.Mutex
+	files     map[uint64]*tailedFile
+	restored  map[uint64]TailOffset
+}
+
+// TailOffset is the reading progress of one file followed by a TailSource, as stored in the OffsetsFile.
+// The offsets are stored in a JSON object using the inode numbers of the files as keys.
+type TailOffset struct {
+	File        string `json:"file"`
+	Samples     int    `json:"samples"`
+	Bytes       int64  `json:"bytes"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// String implements the SampleSource interface.
+func (source *TailSource) String() string {
+	if len(source.Patterns) == 1 {
+		return fmt.Sprintf("TailSource(%v)", source.Patterns[0])
+	} else {
+		return fmt.Sprintf("TailSource(%v patterns)", len(source.Patterns))
+	}
+}
+
+func (source *TailSource) pollInterval() time.Duration {
+	if source.PollInterval > 0 {
+		return source.PollInterval
+	}
+	return DefaultTailPollInterval
+}
+
+// Start implements the SampleSource interface. It starts a background goroutine that checks the Patterns for
+// new files in the PollInterval, and one additional goroutine for every followed file.
+func (source *TailSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	err := source.init()
+	if err != nil {
+		source.CloseSinkParallel(wg)
+		return golib.NewStoppedChan(err)
+	}
+	source.Log().WithField("format", source.Reader.Format()).Println("Following files matching", source.Patterns)
+	source.loop = &golib.LoopTask{
+		Description: source.String(),
+		Loop: func(stop golib.StopChan) error {
+			source.scan()
+			if err := source.saveOffsets(); err != nil {
+				log.Errorln("Error storing offsets of followed files:", err)
+			}
+			stop.WaitTimeout(source.pollInterval())
+			return nil
+		},
+	}
+	loopStopped := source.loop.Start(wg)
+	return golib.WaitErrFunc(wg, func() error {
+		defer source.CloseSinkParallel(wg)
+		loopStopped.Wait()
+		source.closed.Stop()
+		source.followers.Wait()
+		if err := source.saveOffsets(); err != nil {
+			return fmt.Errorf("Error storing offsets of followed files: %v", err)
+		}
+		return loopStopped.Err()
+	})
+}
+
+func (source *TailSource) init() error {
+	if len(source.Patterns) == 0 {
+		return fmt.Errorf("No file patterns specified for %v", source)
+	}
+	for _, pattern := range source.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid file pattern '%v': %v", pattern, err)
+		}
+	}
+	restored, err := source.loadOffsets()
+	if err != nil {
+		return err
+	}
+	source.restored = restored
+	source.files = make(map[uint64]*tailedFile)
+	source.closed = golib.NewStopChan()
+	source.sink = &SynchronizingSampleSink{Out: source.GetSink()}
+	return nil
+}
+
+// Close implements the SampleSource interface. It stops following all files. The files are closed after the
+// data that was already read is forwarded.
+func (source *TailSource) Close() {
+	if loop := source.loop; loop != nil {
+		loop.Stop()
+	}
+}
+
+// scan starts following all files that match the patterns and are not followed yet.
+func (source *TailSource) scan() {
+	var paths []string
+	for _, pattern := range source.Patterns {
+		matches, _ := filepath.Glob(pattern) // The pattern was validated in Start()
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	present := make(map[uint64]bool, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		inode := info.Sys().(*syscall.Stat_t).Ino
+		present[inode] = true
+		if _, ok := source.files[inode]; !ok && !source.closed.Stopped() {
+			source.follow(path, inode)
+		}
+	}
+	for inode, file := range source.files {
+		// Forget removed files, so that their inodes can be reused by new files
+		if file.finished && !present[inode] {
+			delete(source.files, inode)
+		}
+	}
+}
+
+func (source *TailSource) follow(path string, inode uint64) {
+	file := &tailedFile{
+		source: source,
+		path:   path,
+		inode:  inode,
+	}
+	if offset, ok := source.restored[inode]; ok {
+		file.restored = offset
+		delete(source.restored, inode)
+	}
+	source.files[inode] = file
+	source.followers.Add(1)
+	go func() {
+		defer source.followers.Done()
+		file.run()
+	}()
+}
+
+func (source *TailSource) loadOffsets() (map[uint64]TailOffset, error) {
+	res := make(map[uint64]TailOffset)
+	if source.OffsetsFile == "" {
+		return res, nil
+	}
+	data, err := ioutil.ReadFile(source.OffsetsFile)
+	if os.IsNotExist(err) {
+		return res, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stored map[string]TailOffset
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("Failed to parse offsets file %v: %v", source.OffsetsFile, err)
+	}
+	for key, offset := range stored {
+		inode, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse offsets file %v: Invalid inode '%v'", source.OffsetsFile, key)
+		}
+		res[inode] = offset
+	}
+	log.Printf("Resuming %v followed file(s) from offsets file %v", len(res), source.OffsetsFile)
+	return res, nil
+}
+
+// saveOffsets replaces the OffsetsFile atomically
+func (source *TailSource) saveOffsets() error {
+	if source.OffsetsFile == "" {
+		return nil
+	}
+	source.lock.Lock()
+	stored := make(map[string]TailOffset, len(source.files))
+	for inode, file := range source.files {
+		stored[strconv.FormatUint(inode, 10)] = file.offset()
+	}
+	source.lock.Unlock()
+
+	encoded, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := source.OffsetsFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, encoded, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, source.OffsetsFile)
+}
+
+// tailedFile follows one file and counts the samples read from it. It is the SampleSink of the input stream.
+type tailedFile struct {
+	source   *TailSource
+	path     string
+	inode    uint64
+	restored TailOffset
+	skip     int
+
+	// Protected by source.lock
+	samples  int
+	bytes    int64
+	prefix   []byte
+	finished bool
+}
+
+func (f *tailedFile) offset() TailOffset {
+	return TailOffset{File: f.path, Samples: f.samples, Bytes: f.bytes, Fingerprint: tailFingerprint(f.prefix)}
+}
+
+func tailFingerprint(prefix []byte) string {
+	hash := fnv.New64a()
+	_, _ = hash.Write(prefix)
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// resumeOffset checks if the restored offset belongs to the given file and returns the number of samples to skip
+func (f *tailedFile) resumeOffset(file *os.File, info os.FileInfo) (int, error) {
+	if f.restored.Samples == 0 {
+		return 0, nil
+	}
+	l := log.WithField("file", f.path)
+	if info.Size() < f.restored.Bytes {
+		l.Warnln("Followed file is smaller than the stored offset, reading it from the start")
+		return 0, nil
+	}
+	prefix := f.restored.Bytes
+	if prefix > TailFingerprintSize {
+		prefix = TailFingerprintSize
+	}
+	data := make([]byte, prefix)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if tailFingerprint(data) != f.restored.Fingerprint {
+		l.Warnln("Followed file does not match the stored offset, reading it from the start")
+		return 0, nil
+	}
+	l.Printf("Skipping %v sample(s) that were already read", f.restored.Samples)
+	return f.restored.Samples, nil
+}
+
+func (f *tailedFile) run() {
+	l := log.WithField("file", f.path)
+	for {
+		truncated, err := f.read()
+		if err != nil {
+			l.Errorln("Error reading followed file:", err)
+		}
+		if !truncated || f.source.closed.Stopped() {
+			break
+		}
+		l.Warnln("Followed file was truncated, reading it from the start")
+		f.source.lock.Lock()
+		f.samples, f.bytes, f.prefix = 0, 0, nil
+		f.source.lock.Unlock()
+	}
+	f.source.lock.Lock()
+	f.finished = true
+	f.source.lock.Unlock()
+}
+
+func (f *tailedFile) read() (truncated bool, err error) {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.Sys().(*syscall.Stat_t).Ino != f.inode {
+		// The file was replaced before it could be opened, the new file will be followed after the next scan
+		_ = file.Close()
+		return false, err
+	}
+	if f.skip, err = f.resumeOffset(file, info); err != nil {
+		_ = file.Close()
+		return false, err
+	}
+	f.restored = TailOffset{}
+	reader := &tailReader{File: file, tailed: f}
+	var stream *SampleInputStream
+	f.source.closed.IfNotStopped(func() {
+		stream = f.source.Reader.OpenBuffered(reader, f, f.source.IoBuffer)
+	})
+	if stream == nil {
+		_ = file.Close()
+		return false, nil
+	}
+	defer stream.Close() // Drop error
+	log.WithField("file", f.path).Println("Following file")
+	err = stream.ReadNamedSamples(f.path)
+	return reader.truncated, err
+}
+
+// Sample implements the SampleSink interface. Samples are forwarded to the TailSource, unless they are skipped
+// because of a restored offset.
+func (f *tailedFile) Sample(sample *Sample, header *Header) error {
+	if f.skip > 0 {
+		f.skip--
+	} else if err := f.source.sink.Sample(sample, header); err != nil {
+		return err
+	}
+	f.source.lock.Lock()
+	f.samples++
+	f.source.lock.Unlock()
+	return nil
+}
+
+// tailReader reads a file and waits for new data when reaching the end of the file. It returns io.EOF when the
+// TailSource is closed, or the file was removed, replaced or truncated.
+type tailReader struct {
+	*os.File
+	tailed    *tailedFile
+	read      int64
+	rotated   bool
+	truncated bool
+}
+
+func (r *tailReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.File.Read(b)
+		if n > 0 {
+			r.read += int64(n)
+			r.tailed.source.lock.Lock()
+			r.tailed.bytes = r.read
+			if missing := TailFingerprintSize - len(r.tailed.prefix); missing > 0 {
+				if missing > n {
+					missing = n
+				}
+				r.tailed.prefix = append(r.tailed.prefix, b[:missing]...)
+			}
+			r.tailed.source.lock.Unlock()
+		}
+		if n > 0 || err != io.EOF || r.rotated || r.truncated {
+			return n, err
+		}
+		if r.checkRotated() {
+			// Read the data that was appended until the file was rotated
+			continue
+		}
+		if !r.tailed.source.closed.WaitTimeout(r.tailed.source.pollInterval()) {
+			return 0, io.EOF
+		}
+	}
+}
+
+func (r *tailReader) checkRotated() bool {
+	if info, err := r.File.Stat(); err == nil && info.Size() < r.read {
+		r.truncated = true
+	} else if info, err := os.Stat(r.tailed.path); err != nil || info.Sys().(*syscall.Stat_t).Ino != r.tailed.inode {
+		r.rotated = true
+	}
+	return r.rotated || r.truncated
+}
@@ -0,0 +1,158 @@
+package bitflow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TailTestSuite struct {
+	testSuiteBase
+	dir string
+}
+
+func TestTailSource(t *testing.T) {
+	suite.Run(t, new(TailTestSuite))
+}
+
+func (suite *TailTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "bitflow-tail-test")
+	suite.NoError(err)
+	suite.dir = dir
+}
+
+func (suite *TailTestSuite) TearDownTest() {
+	suite.NoError(os.RemoveAll(suite.dir))
+}
+
+// tailCollector stores the first value of all received samples
+type tailCollector struct {
+	DroppingSampleProcessor
+	lock   sync.Mutex
+	values []int
+}
+
+func (c *tailCollector) Sample(sample *Sample, _ *Header) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values = append(c.values, int(sample.Values[0]))
+	return nil
+}
+
+func (c *tailCollector) received() []int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	res := append([]int(nil), c.values...)
+	sort.Ints(res)
+	return res
+}
+
+func (suite *TailTestSuite) write(file string, header bool, values ...int) {
+	out, err := os.OpenFile(filepath.Join(suite.dir, file), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	suite.NoError(err)
+	if header {
+		_, err = fmt.Fprintln(out, "time,a")
+		suite.NoError(err)
+	}
+	for _, val := range values {
+		_, err = fmt.Fprintf(out, "2020-01-01 00:00:00,%v\n", val)
+		suite.NoError(err)
+	}
+	suite.NoError(out.Close())
+}
+
+func (suite *TailTestSuite) start(offsets string) (*TailSource, *tailCollector, *sync.WaitGroup) {
+	out := new(tailCollector)
+	source := &TailSource{
+		Patterns:     []string{filepath.Join(suite.dir, "*.csv")},
+		PollInterval: 10 * time.Millisecond,
+		OffsetsFile:  offsets,
+	}
+	source.Reader.ParallelSampleHandler = parallel_handler
+	source.SetSink(out)
+	var wg sync.WaitGroup
+	source.Start(&wg)
+	return source, out, &wg
+}
+
+func (suite *TailTestSuite) waitFor(out *tailCollector, expected ...int) {
+	for start := time.Now(); len(out.received()) < len(expected) && time.Since(start) < 5*time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	suite.Equal(expected, out.received())
+}
+
+func (suite *TailTestSuite) TestFollowFiles() {
+	suite.write("a.csv", true, 1, 2)
+	source, out, wg := suite.start("")
+	suite.waitFor(out, 1, 2)
+
+	suite.write("a.csv", false, 3)
+	suite.write("b.csv", true, 4)
+	suite.write("ignored.txt", true, 100)
+	suite.waitFor(out, 1, 2, 3, 4)
+
+	source.Close()
+	wg.Wait()
+}
+
+func (suite *TailTestSuite) TestRotationAndTruncation() {
+	suite.write("a.csv", true, 1)
+	source, out, wg := suite.start("")
+	suite.waitFor(out, 1)
+
+	// Rotate: data appended before the rotation is read, and the renamed file is not read again
+	suite.write("a.csv", false, 2)
+	suite.NoError(os.Rename(filepath.Join(suite.dir, "a.csv"), filepath.Join(suite.dir, "a-1.csv")))
+	suite.write("a.csv", true, 3)
+	suite.waitFor(out, 1, 2, 3)
+
+	// Truncate: the file is read again from the start
+	suite.NoError(os.Truncate(filepath.Join(suite.dir, "a.csv"), 0))
+	time.Sleep(50 * time.Millisecond)
+	suite.write("a.csv", true, 4)
+	suite.waitFor(out, 1, 2, 3, 4)
+
+	source.Close()
+	wg.Wait()
+}
+
+func (suite *TailTestSuite) TestResumeOffsets() {
+	offsets := filepath.Join(suite.dir, "offsets.json")
+	suite.write("a.csv", true, 1, 2)
+	source, out, wg := suite.start(offsets)
+	suite.waitFor(out, 1, 2)
+	source.Close()
+	wg.Wait()
+
+	suite.write("a.csv", false, 3)
+	suite.write("b.csv", true, 4)
+	source, out, wg = suite.start(offsets)
+	suite.waitFor(out, 3, 4)
+	source.Close()
+	wg.Wait()
+
+	// A replaced file is read completely
+	suite.NoError(os.Remove(filepath.Join(suite.dir, "b.csv")))
+	suite.write("c.csv", true, 5)
+	source, out, wg = suite.start(offsets)
+	suite.waitFor(out, 5)
+	source.Close()
+	wg.Wait()
+}
+
+func (suite *TailTestSuite) TestInvalidPattern() {
+	source := &TailSource{Patterns: []string{"[invalid"}}
+	source.SetSink(new(DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	ch := source.Start(&wg)
+	wg.Wait()
+	suite.Error(ch.Err())
+}
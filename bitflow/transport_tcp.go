@@ -93,7 +93,7 @@ type TcpWriteConn struct {
 func (sink *AbstractTcpSink) OpenWriteConn(wg *sync.WaitGroup, remoteAddr string, conn io.WriteCloser) *TcpWriteConn {
 	res := &TcpWriteConn{
 		stream: sink.Writer.Open(conn, sink.Marshaller),
-		log:    log.WithField("remote", remoteAddr).WithField("protocol", sink.Protocol).WithField("format", sink.Marshaller),
+		log:    sink.Log().WithField("remote", remoteAddr).WithField("protocol", sink.Protocol).WithField("format", sink.Marshaller),
 		proto:  sink.Protocol,
 	}
 	switch sink.LogReceivedTraffic {
@@ -220,7 +220,7 @@ func (sink *TCPSink) String() string {
 func (sink *TCPSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
 	sink.connCounterDescription = sink
 	sink.Protocol = "TCP"
-	log.WithField("format", sink.Marshaller).Println("Sending data to", sink.Endpoint)
+	sink.Log().WithField("format", sink.Marshaller).Println("Sending data to", sink.Endpoint)
 	sink.stopped = golib.NewStopChan()
 	sink.wg = wg
 	return
@@ -355,7 +355,7 @@ func (source *TCPSource) SourceString() string {
 // endpoints and download Headers and Samples as soon as a connection is established.
 func (source *TCPSource) Start(wg *sync.WaitGroup) golib.StopChan {
 	source.connCounterDescription = source
-	log.WithField("format", source.Reader.Format()).Println("Downloading from", source.SourceString())
+	source.Log().WithField("format", source.Reader.Format()).Println("Downloading from", source.SourceString())
 	if len(source.RemoteAddrs) > 1 {
 		source.downloadSink = &SynchronizingSampleSink{Out: source.GetSink()}
 	} else {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/stretchr/testify/suite"
@@ -123,6 +124,20 @@ func (suite *TransportStreamTestSuite) TestTransport_BinaryMarshallerMulti() {
 	suite.testAllHeaders(new(BinaryMarshaller))
 }
 
+// parsingBinaryMarshaller hides the DirectUnmarshaller implementation of BinaryMarshaller,
+// so that the samples are read and parsed separately
+type parsingBinaryMarshaller struct {
+	BidiMarshaller
+}
+
+func (suite *TransportStreamTestSuite) TestTransport_ParsingBinaryMarshallerSingle() {
+	suite.testIndividualHeaders(parsingBinaryMarshaller{new(BinaryMarshaller)})
+}
+
+func (suite *TransportStreamTestSuite) TestTransport_ParsingBinaryMarshallerMulti() {
+	suite.testAllHeaders(parsingBinaryMarshaller{new(BinaryMarshaller)})
+}
+
 func (suite *TransportStreamTestSuite) TestAllocateSample() {
 	var pipe SamplePipeline
 	pipe.
@@ -164,3 +179,53 @@ func (r *resizingTestSink) OutputSampleSize(size int) int {
 func (r *resizingTestSink) String() string {
 	return fmt.Sprintf("ResizingTestSink(* %v + %v)", r.mul, r.plus)
 }
+
+type releasingSink struct {
+	DroppingSampleProcessor
+}
+
+func (*releasingSink) Sample(sample *Sample, _ *Header) error {
+	sample.Release()
+	return nil
+}
+
+func benchmarkBinaryTransport(b *testing.B, um Unmarshaller) {
+	header := &Header{Fields: make([]string, 100)}
+	for i := range header.Fields {
+		header.Fields[i] = fmt.Sprintf("field-%v", i)
+	}
+	sample := &Sample{Values: make([]Value, len(header.Fields)), Time: time.Now()}
+	sample.SetTag("host", "benchmark")
+	var buf bytes.Buffer
+	var m BinaryMarshaller
+	if err := m.WriteHeader(header, true, &buf); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if err := m.WriteSample(sample, header, true, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.SetBytes(int64(buf.Len() / b.N))
+	b.ReportAllocs()
+	b.ResetTimer()
+	reader := SampleReader{
+		ParallelSampleHandler: ParallelSampleHandler{BufferedSamples: 1000, ParallelParsers: 4},
+		Unmarshaller:          um,
+	}
+	num, err := reader.OpenBuffered(&countingBuf{data: buf.Bytes()}, new(releasingSink), 4096).ReadSamples("benchmark")
+	if err != nil {
+		b.Fatal(err)
+	} else if num != b.N {
+		b.Fatalf("Read %v samples instead of %v", num, b.N)
+	}
+}
+
+func BenchmarkBinaryTransportDirect(b *testing.B) {
+	benchmarkBinaryTransport(b, new(BinaryMarshaller))
+}
+
+func BenchmarkBinaryTransportParsing(b *testing.B) {
+	benchmarkBinaryTransport(b, parsingBinaryMarshaller{new(BinaryMarshaller)})
+}
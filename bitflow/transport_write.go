@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
 
 	"github.com/antongulenko/golib"
 )
@@ -32,7 +33,7 @@ type SampleOutputStream struct {
 	outgoing       chan *bufferedOutputSample
 	writer         io.WriteCloser
 	marshaller     Marshaller
-	marshallBuffer int
+	marshallBuffer int64 // Accessed atomically by the parallel marshalling routines
 }
 
 // BufferedWriteCloser is a helper type that wraps a bufio.Writer around a
@@ -113,6 +114,8 @@ func (stream *SampleOutputStream) Sample(sample *Sample, header *Header) error {
 	if stream.hasError() {
 		return stream.getErrorNoEOF()
 	}
+	// The sample is marshalled in another goroutine, so the Sample is retained until then
+	sample.Retain()
 	bufferedSample := &bufferedOutputSample{
 		header: header,
 		bufferedSample: bufferedSample{
@@ -128,6 +131,7 @@ func (stream *SampleOutputStream) Sample(sample *Sample, header *Header) error {
 			if err == nil {
 				err = errors.New("Sample written to closed output stream")
 			}
+			sample.Release()
 		}, func() {
 			stream.incoming <- bufferedSample
 			stream.outgoing <- bufferedSample
@@ -135,6 +139,21 @@ func (stream *SampleOutputStream) Sample(sample *Sample, header *Header) error {
 	return err
 }
 
+// Flush blocks until all samples passed to Sample() were written, and flushes the buffered writer, if any.
+// The returned error is the first error that occurred on this stream.
+func (stream *SampleOutputStream) Flush() error {
+	flushed := make(chan struct{})
+	marker := &bufferedOutputSample{flushed: flushed}
+	stream.closed.IfElseStopped(
+		func() {
+			close(flushed)
+		}, func() {
+			stream.outgoing <- marker
+		})
+	<-flushed
+	return stream.getErrorNoEOF()
+}
+
 // Close closes the receiving SampleOutputStream. After calling this, neither
 // Sample nor Header can be called anymore! The returned error is the first error
 // that ever occurred in any of the Sample/Header/Close calls on this stream.
@@ -161,16 +180,19 @@ func (stream *SampleOutputStream) marshall() {
 
 func (stream *SampleOutputStream) marshallOne(sample *bufferedOutputSample) {
 	defer sample.notifyDone()
+	defer sample.sample.Release()
 	if stream.hasError() {
 		return
 	}
-	buf := bytes.NewBuffer(make([]byte, 0, stream.marshallBuffer))
+	buf := bytes.NewBuffer(allocateBuffer(int(atomic.LoadInt64(&stream.marshallBuffer)))[:0])
 	err := stream.marshaller.WriteSample(sample.sample, sample.header, true, buf)
 	stream.addError(err)
-	if l := buf.Len(); l > stream.marshallBuffer {
+	for l := int64(buf.Len()); ; {
 		// Avoid buffer copies for future samples
-		// TODO should reuse allocated buffers
-		stream.marshallBuffer = l
+		current := atomic.LoadInt64(&stream.marshallBuffer)
+		if l <= current || atomic.CompareAndSwapInt64(&stream.marshallBuffer, current, l) {
+			break
+		}
 	}
 	sample.data = buf.Bytes()
 }
@@ -187,11 +209,23 @@ func (stream *SampleOutputStream) flush() {
 	// to the stream without disturbing the communication. For CSV format it could be an empty line, for binary
 	// format an arbitrary byte that does not collide with 'timB' and 'X'.
 	for sample := range stream.outgoing {
+		if sample.flushed != nil {
+			if !stream.hasError() {
+				stream.addError(stream.flushBuffered())
+			}
+			close(sample.flushed)
+			continue
+		}
 		sample.waitDone()
 		if stream.hasError() {
 			break
 		}
-		if checker.HeaderChanged(sample.header) {
+		traceChanged := false
+		if _, isBinary := stream.marshaller.(BinaryMarshaller); isBinary && checker.LastHeader != nil {
+			// Only the binary format transports the TraceParent, so the header is repeated when it changes
+			traceChanged = sample.header.TraceParent != checker.LastHeader.TraceParent
+		}
+		if checker.HeaderChanged(sample.header) || traceChanged {
 			if err := stream.marshaller.WriteHeader(sample.header, true, stream.writer); stream.addError(err) {
 				break
 			}
@@ -199,16 +233,23 @@ func (stream *SampleOutputStream) flush() {
 				break
 			}
 		}
-		if _, err := stream.writer.Write(sample.data); stream.addError(err) {
+		_, err := stream.writer.Write(sample.data)
+		releaseBuffer(sample.data)
+		sample.data = nil
+		if stream.addError(err) {
 			break
 		}
 	}
-	for range stream.outgoing {
-		// Flush the outgoing channel to avoid blocking Sample() calls in case of errors
+	for sample := range stream.outgoing {
+		// Flush the outgoing channel to avoid blocking Sample() and Flush() calls in case of errors
+		if sample.flushed != nil {
+			close(sample.flushed)
+		}
 	}
 }
 
 type bufferedOutputSample struct {
 	bufferedSample
-	header *Header
+	header  *Header
+	flushed chan struct{} // If set, the sample is a marker for Flush() and is not written
 }
@@ -0,0 +1,448 @@
+package bitflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WindowMode defines how a WindowProcessor assigns samples to windows.
+type WindowMode string
+
+const (
+	// GlobalWindow collects all samples in one window, which is only emitted when the header changes or the stream ends.
+	GlobalWindow = WindowMode("global")
+
+	// TumblingWindow assigns every sample to exactly one window of fixed size. Windows do not overlap.
+	TumblingWindow = WindowMode("tumbling")
+
+	// SlidingWindow assigns samples to overlapping windows of fixed size, which start every Slide interval.
+	SlidingWindow = WindowMode("sliding")
+
+	// SessionWindow groups samples that are less than Gap apart. A session ends when no sample arrives for Gap.
+	SessionWindow = WindowMode("session")
+
+	WindowStartTag = "window_start"
+	WindowEndTag   = "window_end"
+)
+
+// WindowProcessor collects incoming samples into windows based on their timestamps (event time) and
+// executes a list of BatchProcessingSteps on every window. The results are forwarded when the window closes.
+// If KeyTags is set, separate windows are maintained for every combination of the values of these tags.
+//
+// A window closes when the watermark passes its end. The watermark is the newest timestamp seen so far, minus
+// AllowedLateness. Samples that belong only to already closed windows are dropped as late samples.
+// If the Slide of sliding windows is larger than their Size, samples in the gaps between windows are skipped.
+// All open windows are closed when the header changes and when the input stream ends.
+type WindowProcessor struct {
+	NoopProcessor
+
+	Mode            WindowMode
+	Size            time.Duration // Window size for TumblingWindow and SlidingWindow
+	Slide           time.Duration // Interval between the start of two consecutive sliding windows
+	Gap             time.Duration // Session timeout for SessionWindow
+	AllowedLateness time.Duration
+	KeyTags         []string
+	TagWindows      bool // If true, the samples of a window are tagged with WindowStartTag and WindowEndTag
+
+	Steps []BatchProcessingStep
+
+	checker     HeaderChecker
+	windows     map[string][]*sampleWindow
+	maxTime     time.Time
+	lateSamples int
+	gapSamples  int
+}
+
+type sampleWindow struct {
+	key        string
+	start, end time.Time // end is exclusive
+	samples    []*Sample
+}
+
+// closeTime returns the time when the watermark closes the window.
+func (w *sampleWindow) closeTime(p *WindowProcessor) time.Time {
+	if p.Mode == SessionWindow {
+		return w.end.Add(p.Gap)
+	}
+	return w.end
+}
+
+// Validate checks that the parameters are consistent with the configured Mode.
+func (p *WindowProcessor) Validate() error {
+	switch p.Mode {
+	case GlobalWindow:
+	case TumblingWindow:
+		if p.Size <= 0 {
+			return fmt.Errorf("Tumbling windows require a positive size")
+		}
+	case SlidingWindow:
+		if p.Size <= 0 || p.Slide <= 0 {
+			return fmt.Errorf("Sliding windows require a positive size and slide interval")
+		}
+	case SessionWindow:
+		if p.Gap <= 0 {
+			return fmt.Errorf("Session windows require a positive gap")
+		}
+	default:
+		return fmt.Errorf("Unknown window mode '%v', must be one of %v, %v, %v, %v", p.Mode, GlobalWindow, TumblingWindow, SlidingWindow, SessionWindow)
+	}
+	if p.AllowedLateness < 0 {
+		return fmt.Errorf("The allowed lateness must not be negative: %v", p.AllowedLateness)
+	}
+	return nil
+}
+
+func (p *WindowProcessor) Add(step BatchProcessingStep) *WindowProcessor {
+	p.Steps = append(p.Steps, step)
+	return p
+}
+
+func (p *WindowProcessor) ContainedStringers() []fmt.Stringer {
+	res := make([]fmt.Stringer, len(p.Steps))
+	for i, step := range p.Steps {
+		res[i] = step
+	}
+	return res
+}
+
+func (p *WindowProcessor) OutputSampleSize(sampleSize int) int {
+	for _, step := range p.Steps {
+		if step, ok := step.(ResizingBatchProcessingStep); ok {
+			sampleSize = step.OutputSampleSize(sampleSize)
+		}
+	}
+	return sampleSize
+}
+
+// LateSamples returns the number of samples that were dropped, because all their windows were already closed.
+func (p *WindowProcessor) LateSamples() int {
+	return p.lateSamples
+}
+
+// GapSamples returns the number of samples that were skipped, because they did not belong to any sliding window.
+func (p *WindowProcessor) GapSamples() int {
+	return p.gapSamples
+}
+
+func (p *WindowProcessor) Sample(sample *Sample, header *Header) error {
+	if p.windows == nil {
+		p.windows = make(map[string][]*sampleWindow)
+	}
+	if oldHeader := p.checker.LastHeader; p.checker.InitializedHeaderChanged(header) {
+		if err := p.closeWindows(oldHeader, true); err != nil {
+			return err
+		}
+	}
+
+	if sample.Time.After(p.maxTime) {
+		p.maxTime = sample.Time
+	}
+	switch p.assign(sample) {
+	case windowLate:
+		p.lateSamples++
+		p.Log().Debugf("Dropping late sample with timestamp %v (watermark %v)", sample.Time, p.watermark())
+	case windowGap:
+		p.gapSamples++
+	}
+	return p.closeWindows(header, false)
+}
+
+func (p *WindowProcessor) Close() {
+	defer p.NoopProcessor.Close()
+	if header := p.checker.LastHeader; header == nil {
+		p.Log().Warnln("Received no samples")
+	} else if err := p.closeWindows(header, true); err != nil {
+		p.Error(err)
+	}
+	if p.lateSamples > 0 {
+		p.Log().Warnf("Dropped %v late sample(s)", p.lateSamples)
+	}
+	if p.gapSamples > 0 {
+		p.Log().Debugf("Skipped %v sample(s) between sliding windows", p.gapSamples)
+	}
+}
+
+// windowState is the state of a WindowProcessor stored in checkpoints.
+type windowState struct {
+	Fields      []string       `json:"fields"`
+	MaxTime     time.Time      `json:"max_time"`
+	LateSamples int            `json:"late_samples"`
+	GapSamples  int            `json:"gap_samples"`
+	Windows     []storedWindow `json:"windows,omitempty"`
+}
+
+type storedWindow struct {
+	Key     string    `json:"key"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Samples []byte    `json:"samples"`
+}
+
+// StoreState implements the StatefulProcessor interface by storing the open windows and the watermark.
+func (p *WindowProcessor) StoreState() ([]byte, error) {
+	header := p.checker.LastHeader
+	if header == nil {
+		return nil, nil
+	}
+	state := windowState{Fields: header.Fields, MaxTime: p.maxTime, LateSamples: p.lateSamples, GapSamples: p.gapSamples}
+	for _, windows := range p.windows {
+		for _, w := range windows {
+			samples, err := encodeSamples(header, w.samples)
+			if err != nil {
+				return nil, err
+			}
+			state.Windows = append(state.Windows, storedWindow{Key: w.key, Start: w.start, End: w.end, Samples: samples})
+		}
+	}
+	return json.Marshal(&state)
+}
+
+// RestoreState implements the StatefulProcessor interface.
+func (p *WindowProcessor) RestoreState(data []byte) error {
+	var state windowState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	p.checker.LastHeader = &Header{Fields: state.Fields}
+	p.maxTime = state.MaxTime
+	p.lateSamples = state.LateSamples
+	p.gapSamples = state.GapSamples
+	p.windows = make(map[string][]*sampleWindow)
+	for _, stored := range state.Windows {
+		_, samples, err := decodeSamples(stored.Samples)
+		if err != nil {
+			return fmt.Errorf("Failed to restore window %q (%v - %v): %v", stored.Key, stored.Start, stored.End, err)
+		}
+		w := &sampleWindow{key: stored.Key, start: stored.Start, end: stored.End, samples: samples}
+		p.windows[stored.Key] = append(p.windows[stored.Key], w)
+	}
+	return nil
+}
+
+func (p *WindowProcessor) watermark() time.Time {
+	return p.maxTime.Add(-p.AllowedLateness)
+}
+
+// windowKey joins the values of the KeyTags with a null byte, which does not occur in tag values, so that different
+// combinations of tag values always result in different keys. With one key tag, the key is the tag value.
+func (p *WindowProcessor) windowKey(sample *Sample) string {
+	if len(p.KeyTags) == 0 {
+		return ""
+	}
+	values := make([]string, len(p.KeyTags))
+	for i, tag := range p.KeyTags {
+		values[i] = sample.Tag(tag)
+	}
+	return strings.Join(values, "\x00")
+}
+
+type windowAssignment int
+
+const (
+	windowAdded = windowAssignment(iota)
+	windowLate  // All windows of the sample were already closed
+	windowGap   // The sample does not belong to any window
+)
+
+// assign adds the sample to all windows it belongs to, creating new windows if necessary.
+func (p *WindowProcessor) assign(sample *Sample) windowAssignment {
+	key := p.windowKey(sample)
+	watermark := p.watermark()
+	t := sample.Time
+	added, inWindow := false, true
+	addTo := func(w *sampleWindow) {
+		if added {
+			// Batch processing steps are allowed to modify samples in-place
+			w.samples = append(w.samples, sample.DeepClone())
+		} else {
+			w.samples = append(w.samples, sample)
+		}
+		added = true
+	}
+
+	switch p.Mode {
+	case GlobalWindow:
+		addTo(p.getWindow(key, time.Time{}, time.Time{}))
+	case TumblingWindow:
+		start := t.Truncate(p.Size)
+		if end := start.Add(p.Size); end.After(watermark) {
+			addTo(p.getWindow(key, start, end))
+		}
+	case SlidingWindow:
+		inWindow = false
+		for start := t.Truncate(p.Slide); start.Add(p.Size).After(t); start = start.Add(-p.Slide) {
+			inWindow = true
+			if end := start.Add(p.Size); end.After(watermark) {
+				addTo(p.getWindow(key, start, end))
+			}
+		}
+	case SessionWindow:
+		if t.Add(p.Gap).After(watermark) {
+			addTo(p.getSession(key, t))
+		}
+	}
+	switch {
+	case added:
+		return windowAdded
+	case inWindow:
+		return windowLate
+	default:
+		return windowGap
+	}
+}
+
+func (p *WindowProcessor) getWindow(key string, start, end time.Time) *sampleWindow {
+	for _, w := range p.windows[key] {
+		if w.start.Equal(start) {
+			return w
+		}
+	}
+	w := &sampleWindow{key: key, start: start, end: end}
+	p.windows[key] = append(p.windows[key], w)
+	return w
+}
+
+// getSession returns the session window that contains the given timestamp, extending or merging sessions as required.
+func (p *WindowProcessor) getSession(key string, t time.Time) *sampleWindow {
+	var res *sampleWindow
+	sessions := p.windows[key]
+	remaining := sessions[:0]
+	for _, w := range sessions {
+		if t.Before(w.start.Add(-p.Gap)) || !t.Before(w.end.Add(p.Gap)) {
+			remaining = append(remaining, w)
+			continue
+		}
+		if res == nil {
+			res = w
+			remaining = append(remaining, w)
+		} else {
+			// The sample connects two sessions
+			res.samples = append(res.samples, w.samples...)
+			if w.start.Before(res.start) {
+				res.start = w.start
+			}
+			if w.end.After(res.end) {
+				res.end = w.end
+			}
+		}
+	}
+	if res == nil {
+		res = &sampleWindow{key: key, start: t, end: t}
+		remaining = append(remaining, res)
+	} else if t.Before(res.start) {
+		res.start = t
+	} else if t.After(res.end) {
+		res.end = t
+	}
+	p.windows[key] = remaining
+	return res
+}
+
+// closeWindows processes and forwards all windows that were passed by the watermark, or all windows if all is true.
+func (p *WindowProcessor) closeWindows(header *Header, all bool) error {
+	watermark := p.watermark()
+	var closed []*sampleWindow
+	for key, windows := range p.windows {
+		remaining := windows[:0]
+		for _, w := range windows {
+			if all || (p.Mode != GlobalWindow && !w.closeTime(p).After(watermark)) {
+				closed = append(closed, w)
+			} else {
+				remaining = append(remaining, w)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(p.windows, key)
+		} else {
+			p.windows[key] = remaining
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		a, b := closed[i], closed[j]
+		if !a.end.Equal(b.end) {
+			return a.end.Before(b.end)
+		}
+		if !a.start.Equal(b.start) {
+			return a.start.Before(b.start)
+		}
+		return a.key < b.key
+	})
+	for _, w := range closed {
+		if err := p.flushWindow(header, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *WindowProcessor) flushWindow(header *Header, w *sampleWindow) error {
+	samples := w.samples
+	if len(samples) == 0 {
+		return nil
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	ctx, span := startBatchSpan(p.String(), header, len(samples))
+	err := p.processWindow(ctx, header, w)
+	finishSpan(span, err)
+	return err
+}
+
+func (p *WindowProcessor) processWindow(ctx context.Context, header *Header, w *sampleWindow) error {
+	execution := batchExecution{header: header, samples: w.samples}
+	for i, step := range p.Steps {
+		if execution.numSamples() == 0 {
+			p.Log().Debugf("Cannot execute remaining %v step(s) because the window has no samples", len(p.Steps)-i)
+			break
+		}
+		if err := execution.executeTraced(ctx, step); err != nil {
+			return fmt.Errorf("Error processing window [%v, %v): %v", w.start, w.end, err)
+		}
+	}
+	header, samples, err := execution.result()
+	if err != nil {
+		return fmt.Errorf("Error processing window [%v, %v): %v", w.start, w.end, err)
+	}
+	if header == nil {
+		return fmt.Errorf("Cannot flush %v samples because nil-header was returned by last window processing step", len(samples))
+	}
+	header = TracedHeader(ctx, header)
+	for _, sample := range samples {
+		if p.TagWindows {
+			sample.SetTag(WindowStartTag, w.start.Format(time.RFC3339Nano))
+			sample.SetTag(WindowEndTag, w.end.Format(time.RFC3339Nano))
+		}
+		if err := p.NoopProcessor.Sample(sample, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *WindowProcessor) String() string {
+	var params []string
+	switch p.Mode {
+	case TumblingWindow:
+		params = append(params, fmt.Sprintf("size %v", p.Size))
+	case SlidingWindow:
+		params = append(params, fmt.Sprintf("size %v", p.Size), fmt.Sprintf("slide %v", p.Slide))
+	case SessionWindow:
+		params = append(params, fmt.Sprintf("gap %v", p.Gap))
+	}
+	if p.AllowedLateness > 0 {
+		params = append(params, fmt.Sprintf("lateness %v", p.AllowedLateness))
+	}
+	if len(p.KeyTags) > 0 {
+		params = append(params, fmt.Sprintf("keyed by %v", p.KeyTags))
+	}
+	res := fmt.Sprintf("%v window", strings.Title(string(p.Mode)))
+	if len(params) > 0 {
+		res += " (" + strings.Join(params, ", ") + ")"
+	}
+	return res
+}
@@ -0,0 +1,139 @@
+package bitflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WindowTestSuite struct {
+	testSuiteBase
+}
+
+func TestWindow(t *testing.T) {
+	suite.Run(t, new(WindowTestSuite))
+}
+
+var (
+	windowTestStart  = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	windowTestHeader = &Header{Fields: []string{"a"}}
+)
+
+// windowRecorder records the values of every processed window and forwards the samples unchanged
+type windowRecorder struct {
+	windows [][]Value
+}
+
+func (r *windowRecorder) ProcessBatch(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+	values := make([]Value, len(samples))
+	for i, sample := range samples {
+		values[i] = sample.Values[0]
+	}
+	r.windows = append(r.windows, values)
+	return header, samples, nil
+}
+
+func (r *windowRecorder) String() string {
+	return "window recorder"
+}
+
+// run sends one sample per second offset, with the offset as value, and returns the values of the processed windows
+func (suite *WindowTestSuite) run(proc *WindowProcessor, seconds ...int) [][]Value {
+	suite.NoError(proc.Validate())
+	recorder := new(windowRecorder)
+	proc.Add(recorder)
+	proc.SetSink(new(batchCollector))
+	for _, sec := range seconds {
+		sample := &Sample{Values: []Value{Value(sec)}, Time: windowTestStart.Add(time.Duration(sec) * time.Second)}
+		suite.NoError(proc.Sample(sample, windowTestHeader))
+	}
+	proc.Close()
+	return recorder.windows
+}
+
+func (suite *WindowTestSuite) TestTumbling() {
+	proc := &WindowProcessor{Mode: TumblingWindow, Size: 10 * time.Second, TagWindows: true}
+	suite.Equal([][]Value{{0, 5}, {10, 15}, {25}}, suite.run(proc, 0, 5, 10, 15, 25))
+	suite.Equal(0, proc.LateSamples())
+
+	out := proc.GetSink().(*batchCollector)
+	suite.Len(out.samples, 5)
+	suite.Equal(windowTestStart.Format(time.RFC3339Nano), out.samples[0].Tag(WindowStartTag))
+	suite.Equal(windowTestStart.Add(10*time.Second).Format(time.RFC3339Nano), out.samples[0].Tag(WindowEndTag))
+	suite.Equal(windowTestStart.Add(30*time.Second).Format(time.RFC3339Nano), out.samples[4].Tag(WindowEndTag))
+}
+
+func (suite *WindowTestSuite) TestSliding() {
+	proc := &WindowProcessor{Mode: SlidingWindow, Size: 10 * time.Second, Slide: 5 * time.Second}
+	suite.Equal([][]Value{{0}, {0, 7}, {7, 12}, {12}}, suite.run(proc, 0, 7, 12))
+	suite.Equal(0, proc.LateSamples())
+	suite.Equal(0, proc.GapSamples())
+
+	out := proc.GetSink().(*batchCollector)
+	suite.Len(out.samples, 6, "Samples in overlapping windows must be forwarded once per window")
+	suite.False(out.samples[0] == out.samples[1], "Samples in multiple windows must be copied")
+}
+
+func (suite *WindowTestSuite) TestGappedSliding() {
+	proc := &WindowProcessor{Mode: SlidingWindow, Size: 5 * time.Second, Slide: 10 * time.Second}
+	suite.Equal([][]Value{{0, 3}, {12}}, suite.run(proc, 0, 3, 7, 12, 16))
+	suite.Equal(0, proc.LateSamples(), "Samples between the windows are not late")
+	suite.Equal(2, proc.GapSamples())
+}
+
+func (suite *WindowTestSuite) TestLateData() {
+	proc := &WindowProcessor{Mode: TumblingWindow, Size: 10 * time.Second}
+	suite.Equal([][]Value{{0}, {15}}, suite.run(proc, 0, 15, 5))
+	suite.Equal(1, proc.LateSamples())
+
+	proc = &WindowProcessor{Mode: TumblingWindow, Size: 10 * time.Second, AllowedLateness: 10 * time.Second}
+	suite.Equal([][]Value{{0, 5}, {15}}, suite.run(proc, 0, 15, 5))
+	suite.Equal(0, proc.LateSamples())
+
+	proc = &WindowProcessor{Mode: SlidingWindow, Size: 10 * time.Second, Slide: 5 * time.Second}
+	suite.Equal([][]Value{{0}, {0}, {20}, {20}}, suite.run(proc, 0, 20, 3))
+	suite.Equal(1, proc.LateSamples())
+}
+
+func (suite *WindowTestSuite) TestSession() {
+	proc := &WindowProcessor{Mode: SessionWindow, Gap: 5 * time.Second}
+	suite.Equal([][]Value{{0, 3, 7}, {20}}, suite.run(proc, 0, 3, 7, 20))
+
+	// A sample can connect two sessions
+	proc = &WindowProcessor{Mode: SessionWindow, Gap: 5 * time.Second, AllowedLateness: time.Minute}
+	suite.Equal([][]Value{{0, 4, 8}}, suite.run(proc, 0, 8, 4))
+}
+
+func (suite *WindowTestSuite) TestKeyTags() {
+	proc := &WindowProcessor{Mode: GlobalWindow, KeyTags: []string{"a", "b"}}
+	recorder := new(windowRecorder)
+	proc.Add(recorder).SetSink(new(batchCollector))
+	for i, tags := range [][2]string{{"x,y", "z"}, {"x", "y,z"}, {"x,y", "z"}} {
+		sample := &Sample{Values: []Value{Value(i)}, Time: windowTestStart}
+		sample.SetTag("a", tags[0])
+		sample.SetTag("b", tags[1])
+		suite.NoError(proc.Sample(sample, windowTestHeader))
+	}
+	proc.Close()
+	suite.ElementsMatch([][]Value{{0, 2}, {1}}, recorder.windows, "Different tag values must not share a window")
+}
+
+func (suite *WindowTestSuite) TestHeaderChangeClosesWindows() {
+	proc := &WindowProcessor{Mode: GlobalWindow}
+	recorder := new(windowRecorder)
+	proc.Add(recorder).SetSink(new(batchCollector))
+	suite.NoError(proc.Sample(&Sample{Values: []Value{1}, Time: windowTestStart}, windowTestHeader))
+	suite.NoError(proc.Sample(&Sample{Values: []Value{2, 3}, Time: windowTestStart}, &Header{Fields: []string{"a", "b"}}))
+	suite.Equal([][]Value{{1}}, recorder.windows)
+	proc.Close()
+	suite.Equal([][]Value{{1}, {2}}, recorder.windows)
+}
+
+func (suite *WindowTestSuite) TestValidate() {
+	suite.Error((&WindowProcessor{Mode: TumblingWindow}).Validate())
+	suite.Error((&WindowProcessor{Mode: SlidingWindow, Size: time.Second}).Validate())
+	suite.Error((&WindowProcessor{Mode: SessionWindow}).Validate())
+	suite.Error((&WindowProcessor{Mode: "other"}).Validate())
+	suite.Error((&WindowProcessor{Mode: GlobalWindow, AllowedLateness: -1}).Validate())
+}
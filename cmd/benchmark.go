@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultBenchmarkAllocInterval = 100
+
+// Benchmark runs a pipeline and measures the throughput, latency and memory allocations of every step.
+// Like in a DryRun, the outputs of the pipeline are not executed. The source is replaced with synthetic samples,
+// unless Recorded is set.
+//
+// The time spent in a step is measured by probes inserted before every step. Since steps forward samples synchronously,
+// the time of a step is the time measured by its probe, minus the time measured by the probe of the following step.
+// Work done asynchronously or when closing a step (e.g. in batch steps) is therefore attributed to the step that triggers it.
+// Allocations are measured only for every AllocInterval-th sample, because reading the memory statistics stops the program.
+// The latency of these samples is not measured.
+type Benchmark struct {
+	DryRun
+	Recorded      bool
+	Format        string // json or csv
+	AllocInterval int
+
+	probes    []*benchmarkProbe
+	measuring bool // Set while an allocation measurement sample passes through the pipeline
+	numInput  int
+}
+
+// BenchmarkReport is the result of a Benchmark.
+type BenchmarkReport struct {
+	Samples    uint64
+	Duration   time.Duration
+	Throughput float64 // Samples per second
+	AllocBytes uint64
+	Allocs     uint64
+	NumGC      uint32
+	Steps      []BenchmarkStepReport
+}
+
+// BenchmarkStepReport contains the measurements of one step. Latencies and allocations refer to a single sample received by the step.
+type BenchmarkStepReport struct {
+	Step            string
+	SamplesIn       uint64
+	SamplesOut      uint64
+	Time            time.Duration
+	Throughput      float64 // Samples per second, based on the time spent in the step
+	MeanLatency     time.Duration
+	MaxLatency      time.Duration
+	AllocsPerSample float64
+	BytesPerSample  float64
+}
+
+// Run instruments and runs the pipeline, and writes the report in the configured Format.
+func (b *Benchmark) Run(pipe *bitflow.SamplePipeline, out io.Writer) error {
+	if b.AllocInterval <= 0 {
+		b.AllocInterval = DefaultBenchmarkAllocInterval
+	}
+	steps := b.instrument(pipe)
+	if b.Recorded {
+		log.Printf("Benchmark: measuring %v step(s) with the samples from %v", len(steps), pipe.Source)
+	} else {
+		log.Printf("Benchmark: measuring %v step(s) with %v synthetic samples with %v metric(s)", len(steps), b.Samples, len(b.Fields))
+	}
+
+	var memBefore, memAfter runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&memBefore)
+	start := time.Now()
+	numErrors := pipe.StartAndWait()
+	duration := time.Since(start)
+	runtime.ReadMemStats(&memAfter)
+	if numErrors > 0 {
+		return fmt.Errorf("The benchmark failed with %v error(s)", numErrors)
+	}
+
+	report := &BenchmarkReport{
+		Samples:    b.probes[0].samples,
+		Duration:   duration,
+		AllocBytes: memAfter.TotalAlloc - memBefore.TotalAlloc,
+		Allocs:     memAfter.Mallocs - memBefore.Mallocs,
+		NumGC:      memAfter.NumGC - memBefore.NumGC,
+	}
+	if duration > 0 {
+		report.Throughput = float64(report.Samples) / duration.Seconds()
+	}
+	for i, step := range steps {
+		report.Steps = append(report.Steps, b.probes[i].report(step, b.probes[i+1]))
+	}
+	return report.Write(out, b.Format)
+}
+
+// instrument replaces the outputs and the source (unless Recorded is set) and inserts a probe before every step and
+// after the last step. It returns the measured steps.
+func (b *Benchmark) instrument(pipe *bitflow.SamplePipeline) []bitflow.SampleProcessor {
+	if !b.Recorded {
+		pipe.Source = &syntheticSource{run: &b.DryRun}
+	}
+	steps := pipe.Processors
+	processors := make([]bitflow.SampleProcessor, 0, 2*len(steps)+1)
+	b.probes = make([]*benchmarkProbe, len(steps)+1)
+	for i, proc := range steps {
+		b.probes[i] = &benchmarkProbe{bench: b, first: i == 0}
+		processors = append(processors, b.probes[i])
+		if isOutput(proc) {
+			// Outputs are not executed
+			counter := &dryRunCounter{step: proc, forward: true}
+			if output, ok := proc.(forwardingOutput); ok {
+				counter.forward = output.ForwardsSamples()
+			}
+			proc = counter
+		}
+		processors = append(processors, proc)
+	}
+	last := &benchmarkProbe{bench: b, first: len(steps) == 0}
+	b.probes[len(steps)] = last
+	pipe.Processors = append(processors, last)
+	for i := range b.probes[:len(steps)] {
+		b.probes[i].next = b.probes[i+1]
+	}
+	return steps
+}
+
+// benchmarkProbe measures the time and allocations of all following steps (inclusive), and derives the values
+// for the directly following step (exclusive) by subtracting the inclusive values of the next probe.
+type benchmarkProbe struct {
+	bitflow.NoopProcessor
+	bench *Benchmark
+	next  *benchmarkProbe
+	first bool
+
+	samples                         uint64
+	inclusiveTime                   time.Duration
+	exclusiveTime                   time.Duration
+	timedSamples                    uint64
+	maxLatency                      time.Duration
+	allocSamples                    uint64
+	inclusiveAllocs, inclusiveBytes uint64
+	exclusiveAllocs, exclusiveBytes uint64
+}
+
+func (p *benchmarkProbe) String() string {
+	return "Benchmark probe"
+}
+
+func (p *benchmarkProbe) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	p.samples++
+	if p.first {
+		p.bench.numInput++
+		p.bench.measuring = p.bench.numInput%p.bench.AllocInterval == 0
+		defer func() { p.bench.measuring = false }()
+	}
+	if p.bench.measuring {
+		return p.measureAllocations(sample, header)
+	}
+
+	var nextBefore time.Duration
+	if p.next != nil {
+		nextBefore = p.next.inclusiveTime
+	}
+	start := time.Now()
+	err := p.NoopProcessor.Sample(sample, header)
+	elapsed := time.Since(start)
+	p.inclusiveTime += elapsed
+	if p.next != nil {
+		elapsed -= p.next.inclusiveTime - nextBefore
+	}
+	p.exclusiveTime += elapsed
+	p.timedSamples++
+	if elapsed > p.maxLatency {
+		p.maxLatency = elapsed
+	}
+	return err
+}
+
+func (p *benchmarkProbe) measureAllocations(sample *bitflow.Sample, header *bitflow.Header) error {
+	var nextAllocs, nextBytes uint64
+	if p.next != nil {
+		nextAllocs, nextBytes = p.next.inclusiveAllocs, p.next.inclusiveBytes
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := p.NoopProcessor.Sample(sample, header)
+	runtime.ReadMemStats(&after)
+	allocs := after.Mallocs - before.Mallocs
+	bytes := after.TotalAlloc - before.TotalAlloc
+	p.inclusiveAllocs += allocs
+	p.inclusiveBytes += bytes
+	if p.next != nil {
+		allocs -= p.next.inclusiveAllocs - nextAllocs
+		bytes -= p.next.inclusiveBytes - nextBytes
+	}
+	p.exclusiveAllocs += allocs
+	p.exclusiveBytes += bytes
+	p.allocSamples++
+	return err
+}
+
+func (p *benchmarkProbe) report(step bitflow.SampleProcessor, next *benchmarkProbe) BenchmarkStepReport {
+	res := BenchmarkStepReport{
+		Step:       step.String(),
+		SamplesIn:  p.samples,
+		SamplesOut: next.samples,
+		Time:       p.exclusiveTime,
+		MaxLatency: p.maxLatency,
+	}
+	if p.timedSamples > 0 {
+		res.MeanLatency = p.exclusiveTime / time.Duration(p.timedSamples)
+		if p.exclusiveTime > 0 {
+			res.Throughput = float64(p.timedSamples) / p.exclusiveTime.Seconds()
+		}
+	}
+	if p.allocSamples > 0 {
+		res.AllocsPerSample = float64(p.exclusiveAllocs) / float64(p.allocSamples)
+		res.BytesPerSample = float64(p.exclusiveBytes) / float64(p.allocSamples)
+	}
+	return res
+}
+
+// Write writes the report in the given format: json, or csv with one line per step.
+func (r *BenchmarkReport) Write(out io.Writer, format string) error {
+	switch format {
+	case "json":
+		data, err := JSONMarshal(r)
+		if err == nil {
+			_, err = out.Write(data)
+		}
+		return err
+	case "csv":
+		writer := csv.NewWriter(out)
+		_ = writer.Write([]string{"step", "samples_in", "samples_out", "time_ns", "throughput", "mean_latency_ns", "max_latency_ns", "allocs_per_sample", "bytes_per_sample"})
+		for _, step := range r.Steps {
+			_ = writer.Write([]string{
+				step.Step,
+				strconv.FormatUint(step.SamplesIn, 10),
+				strconv.FormatUint(step.SamplesOut, 10),
+				strconv.FormatInt(int64(step.Time), 10),
+				strconv.FormatFloat(step.Throughput, 'f', 2, 64),
+				strconv.FormatInt(int64(step.MeanLatency), 10),
+				strconv.FormatInt(int64(step.MaxLatency), 10),
+				strconv.FormatFloat(step.AllocsPerSample, 'f', 2, 64),
+				strconv.FormatFloat(step.BytesPerSample, 'f', 2, 64),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("Unknown benchmark report format '%v', must be json or csv", format)
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type benchmarkTestSuite struct {
+	testsupport.Suite
+}
+
+func TestBenchmark(t *testing.T) {
+	suite.Run(t, new(benchmarkTestSuite))
+}
+
+func (s *benchmarkTestSuite) slowStep(delay time.Duration) bitflow.SampleProcessor {
+	return &bitflow.SimpleProcessor{
+		Description: "slow step",
+		Process: func(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Sample, *bitflow.Header, error) {
+			time.Sleep(delay)
+			return sample, header, nil
+		},
+	}
+}
+
+func (s *benchmarkTestSuite) allocatingStep() bitflow.SampleProcessor {
+	var garbage [][]byte
+	return &bitflow.SimpleProcessor{
+		Description: "allocating step",
+		Process: func(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Sample, *bitflow.Header, error) {
+			for i := 0; i < 10; i++ {
+				garbage = append(garbage, make([]byte, 1024))
+			}
+			return sample, header, nil
+		},
+	}
+}
+
+func (s *benchmarkTestSuite) run(bench *Benchmark, steps ...bitflow.SampleProcessor) *BenchmarkReport {
+	pipe := &bitflow.SamplePipeline{Source: &bitflow.EmptySampleSource{}}
+	for _, step := range steps {
+		pipe.Add(step)
+	}
+	var out bytes.Buffer
+	s.NoError(bench.Run(pipe, &out))
+	var report BenchmarkReport
+	s.NoError(json.Unmarshal(out.Bytes(), &report))
+	return &report
+}
+
+func (s *benchmarkTestSuite) TestReport() {
+	bench := &Benchmark{DryRun: DryRun{Samples: 20, Fields: []string{"x"}}, Format: "json", AllocInterval: 3} // Odd interval, so that measured samples pass the first step
+	report := s.run(bench, dropEverySecond(), s.slowStep(2*time.Millisecond), s.allocatingStep(),
+		&bitflow.FileSink{Filename: "benchmark-test-output.csv"})
+
+	s.Equal(uint64(20), report.Samples)
+	s.Len(report.Steps, 4)
+	var in, out []uint64
+	for _, step := range report.Steps {
+		in = append(in, step.SamplesIn)
+		out = append(out, step.SamplesOut)
+	}
+	s.Equal([]uint64{20, 10, 10, 10}, in)
+	s.Equal([]uint64{10, 10, 10, 10}, out)
+	s.Equal("drop every second sample", report.Steps[0].Step)
+
+	slow := report.Steps[1]
+	// Samples 3, 9 and 15 measure allocations, so 7 of the 10 samples passing the slow step are timed
+	s.True(slow.Time >= 7*2*time.Millisecond, "Time of the slow step: %v", slow.Time)
+	s.True(report.Steps[0].Time < slow.Time, "The time of the following steps is not attributed to the first step")
+	s.True(slow.MeanLatency >= 2*time.Millisecond)
+	s.True(slow.MaxLatency >= slow.MeanLatency)
+	s.InDelta(1/slow.MeanLatency.Seconds(), slow.Throughput, 1)
+
+	alloc := report.Steps[2]
+	s.True(alloc.AllocsPerSample >= 10, "Allocations: %v", alloc.AllocsPerSample)
+	s.True(alloc.BytesPerSample >= 10*1024, "Bytes: %v", alloc.BytesPerSample)
+	s.True(alloc.AllocsPerSample > report.Steps[0].AllocsPerSample)
+}
+
+func (s *benchmarkTestSuite) TestRecorded() {
+	var recorder dryRunRecorder
+	source := &syntheticSource{run: &DryRun{Samples: 7, Fields: []string{"x"}}}
+	bench := &Benchmark{Recorded: true, Format: "json"}
+	pipe := &bitflow.SamplePipeline{Source: source}
+	pipe.Add(recorder.step())
+	var out bytes.Buffer
+	s.NoError(bench.Run(pipe, &out))
+	s.True(pipe.Source == source, "The recorded source must be kept")
+	s.Len(recorder.samples, 7)
+	s.Equal(DefaultBenchmarkAllocInterval, bench.AllocInterval)
+}
+
+func (s *benchmarkTestSuite) TestCsv() {
+	report := &BenchmarkReport{Steps: []BenchmarkStepReport{{
+		Step: "a, b", SamplesIn: 4, SamplesOut: 2, Time: time.Second, Throughput: 4,
+		MeanLatency: 250 * time.Millisecond, MaxLatency: 300 * time.Millisecond, AllocsPerSample: 1.5, BytesPerSample: 12,
+	}}}
+	var out bytes.Buffer
+	s.NoError(report.Write(&out, "csv"))
+	lines, err := csv.NewReader(&out).ReadAll()
+	s.NoError(err)
+	s.Equal([][]string{
+		{"step", "samples_in", "samples_out", "time_ns", "throughput", "mean_latency_ns", "max_latency_ns", "allocs_per_sample", "bytes_per_sample"},
+		{"a, b", "4", "2", "1000000000", "4.00", "250000000", "300000000", "1.50", "12.00"},
+	}, lines)
+	s.Error(report.Write(&out, "xml"))
+}
+
+func (s *benchmarkTestSuite) TestError() {
+	pipe := new(bitflow.SamplePipeline)
+	pipe.Add(&bitflow.SimpleProcessor{})
+	bench := &Benchmark{DryRun: DryRun{Samples: 1, Fields: []string{"x"}}, Format: "json"}
+	s.Error(bench.Run(pipe, new(bytes.Buffer)), "The Process function is not set")
+}
@@ -9,7 +9,9 @@ import (
 	"strings"
 
 	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/cmd"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -19,7 +21,8 @@ const (
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s <flags> <bitflow script>\nAll flags must be defined before the first non-flag parameter.\nFlags:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s <flags> <bitflow script>\nAll flags must be defined before the first non-flag parameter.\n"+
+			"Placeholders like ${key} or ${key:default} in the script are resolved through -param key=value or environment variables.\nFlags:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	fix_arguments(&os.Args)
@@ -41,10 +44,37 @@ func fix_arguments(argsPtr *[]string) {
 
 func do_main() int {
 	var builder cmd.CmdPipelineBuilder
+	var reloader cmd.ReloadingPipeline
+	var pauser cmd.PipelinePauser
 	scriptFile := ""
+	daemonEndpoint := ""
+	languageServer := false
+	flag.StringVar(&daemonEndpoint, "daemon", "", "Run in daemon mode: serve a REST API on the given endpoint for submitting and managing multiple named pipelines. No script is expected in this mode. "+
+		"Endpoints without host (e.g. ':8080') are served on localhost, other hosts require -daemon-token-file and -daemon-tls-cert/-daemon-tls-key.")
+	flag.BoolVar(&languageServer, "lsp", false, "Run a language server for Bitflow scripts on the standard input and output, for integration in editors. No script is expected in this mode.")
 	flag.StringVar(&scriptFile, fileFlag, "", "File to read a Bitflow script from (alternative to providing the script on the command line)")
 	builder.RegisterFlags()
+	reloader.RegisterFlags()
+	pauser.RegisterFlags()
+	daemon := cmd.NewPipelineDaemon(&builder)
+	daemon.RegisterFlags()
 	_, args := cmd.ParseFlags()
+	if daemonEndpoint != "" {
+		if scriptFile != "" || len(args) > 0 {
+			golib.Fatalln("No bitflow script can be provided in daemon mode")
+		}
+		builder.StartMonitoring()
+		builder.StartTracing()
+		defer builder.StopTracing()
+		return daemon.Serve(daemonEndpoint)
+	}
+	if languageServer {
+		if scriptFile != "" || len(args) > 0 {
+			golib.Fatalln("No bitflow script can be provided in language server mode")
+		}
+		golib.Checkerr(builder.ServeLanguageServer())
+		return 0
+	}
 	rawScript, err := get_script(args, scriptFile)
 	golib.Checkerr(err)
 
@@ -54,8 +84,65 @@ func do_main() int {
 	if pipe == nil {
 		return 0
 	}
+	builder.StartMonitoring()
+	builder.StartTracing()
+	defer builder.StopTracing()
+	checkpoints := builder.Endpoints.Checkpoints
+	if checkpoints != nil {
+		golib.Checkerr(checkpoints.RegisterPipeline(pipe))
+	}
+	if pauser.Enabled() {
+		pauser.Insert(pipe)
+		golib.Checkerr(pauser.Start())
+		defer pauser.Stop()
+	}
+	extraTasks := func() []golib.Task {
+		var tasks []golib.Task
+		if checkpoints != nil {
+			tasks = append(tasks, checkpoints.Task())
+		}
+		if pauser.Enabled() {
+			tasks = append(tasks, pauser.Task())
+		}
+		return tasks
+	}
 	defer golib.ProfileCpu()()
-	return pipe.StartAndWait()
+	var numErrors int
+	if reloader.Enabled() {
+		reloader.Build = func(newScript string) (*bitflow.SamplePipeline, error) {
+			if newScript == "" {
+				var err error
+				newScript, err = get_script(args, scriptFile)
+				if err != nil {
+					return nil, err
+				}
+			}
+			newPipe, err := builder.BuildPipeline(newScript)
+			if err != nil || newPipe == nil {
+				return newPipe, err
+			}
+			if checkpoints != nil {
+				if err := checkpoints.RegisterPipeline(newPipe); err != nil {
+					return nil, err
+				}
+			}
+			if pauser.Enabled() {
+				pauser.Insert(newPipe)
+			}
+			return newPipe, nil
+		}
+		reloader.Tasks = extraTasks
+		numErrors = reloader.Run(pipe)
+	} else {
+		numErrors = pipe.StartAndWait(extraTasks()...)
+	}
+	if checkpoints != nil {
+		if err := checkpoints.Save(); err != nil {
+			log.Errorln("Error saving final checkpoint:", err)
+			numErrors++
+		}
+	}
+	return numErrors
 }
 
 func get_script(parsedArgs []string, scriptFile string) (string, error) {
@@ -58,6 +58,9 @@ func (suite *scriptIntegrationTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*scriptIntegrationTestSuite) SetS(suite.TestingSuite) {
+}
+
 func (suite *scriptIntegrationTestSuite) SetupSuite() {
 	var err error
 	suite.sampleDataFile, err = ioutil.TempFile(os.TempDir(), "sample-data-")
@@ -36,6 +36,9 @@ func (suite *argsTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*argsTestSuite) SetS(suite.TestingSuite) {
+}
+
 func (suite *argsTestSuite) SetupSuite() {
 	name, err := ioutil.TempDir("", "bitflow-pipeline-test-main-script")
 	suite.tempDir = name
@@ -86,6 +86,10 @@ func (c *CmdDataCollector) BuildPipeline() (*bitflow.SamplePipeline, error) {
 		return nil, err
 	}
 	p = c.CmdPipelineBuilder.PrintPipeline(p)
+	if p != nil {
+		c.StartMonitoring()
+		c.StartTracing()
+	}
 	return p, nil
 }
 
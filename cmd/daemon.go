@@ -0,0 +1,494 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PipelineStatusRunning  = "running"
+	PipelineStatusPaused   = "paused"
+	PipelineStatusFinished = "finished"
+	PipelineStatusFailed   = "failed"
+	PipelineStatusStopped  = "stopped"
+
+	// DaemonTokenEnv is the environment variable that can hold the access token of the daemon API,
+	// as an alternative to the -daemon-token-file flag.
+	DaemonTokenEnv = "BITFLOW_DAEMON_TOKEN"
+)
+
+// PipelineDaemon manages multiple named pipelines inside one process. The pipelines are submitted, inspected,
+// paused and stopped through a REST API. Every pipeline is built from a bitflow script using the
+// ProcessorRegistry of the CmdPipelineBuilder.
+//
+// Submitted scripts can run arbitrary steps, including subprocesses, so the API is served on the loopback interface,
+// unless the endpoint explicitly contains another host. Serving on other interfaces requires an access token and TLS.
+type PipelineDaemon struct {
+	Builder *CmdPipelineBuilder
+
+	// Token must be sent in the Authorization header of every API request ("Bearer <token>"), if it is not empty.
+	Token     string
+	TokenFile string // File to read the Token from, see LoadToken()
+	TLSCert   string // Certificate file for serving the API over HTTPS, requires TLSKey
+	TLSKey    string
+
+	lock      sync.Mutex
+	buildLock sync.Mutex
+	pipelines map[string]*ManagedPipeline
+}
+
+// ManagedPipeline is a pipeline running inside a PipelineDaemon.
+type ManagedPipeline struct {
+	Name    string
+	Script  string
+	Started time.Time
+
+	lock     sync.Mutex
+	pipeline *bitflow.SamplePipeline
+	gate     *steps.PausingProcessor
+	stopper  golib.StopChan
+	finished golib.StopChan
+	status   string
+	stopped  time.Time
+	errors   []string
+}
+
+// PipelineInfo is the JSON representation of a ManagedPipeline.
+type PipelineInfo struct {
+	Name     string     `json:"name"`
+	Script   string     `json:"script"`
+	Status   string     `json:"status"`
+	Started  time.Time  `json:"started"`
+	Stopped  *time.Time `json:"stopped,omitempty"`
+	Errors   []string   `json:"errors,omitempty"`
+	Pipeline []string   `json:"pipeline,omitempty"`
+}
+
+func NewPipelineDaemon(builder *CmdPipelineBuilder) *PipelineDaemon {
+	return &PipelineDaemon{
+		Builder:   builder,
+		pipelines: make(map[string]*ManagedPipeline),
+	}
+}
+
+func (d *PipelineDaemon) RegisterFlags() {
+	flag.StringVar(&d.TokenFile, "daemon-token-file", "", "File containing the access token required for all requests to the daemon API (header 'Authorization: Bearer <token>'). "+
+		"Alternatively, the token can be set in the "+DaemonTokenEnv+" environment variable.")
+	flag.StringVar(&d.TLSCert, "daemon-tls-cert", "", "Certificate file for serving the daemon API over HTTPS")
+	flag.StringVar(&d.TLSKey, "daemon-tls-key", "", "Private key file for serving the daemon API over HTTPS")
+}
+
+// LoadToken sets the Token from the TokenFile, or from the DaemonTokenEnv environment variable,
+// if the Token is not set yet.
+func (d *PipelineDaemon) LoadToken() error {
+	if d.Token != "" {
+		return nil
+	}
+	if d.TokenFile != "" {
+		data, err := ioutil.ReadFile(d.TokenFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read the token of the daemon API: %v", err)
+		}
+		d.Token = strings.TrimSpace(string(data))
+		if d.Token == "" {
+			return fmt.Errorf("The token file %v is empty", d.TokenFile)
+		}
+	} else {
+		d.Token = os.Getenv(DaemonTokenEnv)
+	}
+	return nil
+}
+
+// ListenAddress returns the address the API is served on for the given endpoint. Endpoints without a host are bound
+// to the loopback interface. An error is returned, if the address is not a loopback address and the API is not
+// protected by a Token and TLS.
+func (d *PipelineDaemon) ListenAddress(endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if (d.TLSCert == "") != (d.TLSKey == "") {
+		return "", errors.New("Both the TLS certificate and the private key must be set for the daemon API")
+	}
+	if !isLoopbackHost(host) && (d.Token == "" || d.TLSCert == "") {
+		return "", fmt.Errorf("The daemon API executes arbitrary scripts, serving it on %v requires an access token and TLS "+
+			"(-daemon-token-file and -daemon-tls-cert/-daemon-tls-key), or a loopback address", endpoint)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve starts the REST API on the given endpoint and blocks until the process is interrupted.
+// Afterwards, all running pipelines are stopped. See ListenAddress() for the interpretation of the endpoint.
+func (d *PipelineDaemon) Serve(endpoint string) int {
+	if err := d.LoadToken(); err != nil {
+		log.Errorln(err)
+		return 1
+	}
+	addr, err := d.ListenAddress(endpoint)
+	if err != nil {
+		log.Errorln(err)
+		return 1
+	}
+	router := mux.NewRouter()
+	d.Register(RestApiPathPrefix, router)
+	server := http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+	serverStopped := golib.NewStopChan()
+	go func() {
+		if d.TLSCert != "" {
+			serverStopped.StopErr(server.ListenAndServeTLS(d.TLSCert, d.TLSKey))
+		} else {
+			serverStopped.StopErr(server.ListenAndServe())
+		}
+	}()
+	log.WithFields(log.Fields{"tls": d.TLSCert != "", "token": d.Token != ""}).Println("Serving pipeline management API on", addr)
+
+	var tasks golib.TaskGroup
+	tasks.Add(&golib.NoopTask{Chan: serverStopped, Description: "pipeline management API"})
+	tasks.Add(&daemonPipelinesTask{d})
+	reason, numErrors := bitflow.WaitAndDrain(tasks)
+	log.Debugln("Stopped because of", reason)
+	server.Close()
+	return numErrors
+}
+
+// daemonPipelinesTask stops all pipelines of the daemon as part of the shutdown sequence,
+// so that stopping them is limited by the bitflow.DrainTimeout.
+type daemonPipelinesTask struct {
+	daemon *PipelineDaemon
+}
+
+func (t *daemonPipelinesTask) Start(wg *sync.WaitGroup) golib.StopChan {
+	// The pipelines are started and stopped independently, they never cause the daemon to shut down
+	return golib.StopChan{}
+}
+
+func (t *daemonPipelinesTask) Stop() {
+	t.daemon.StopAll()
+}
+
+func (t *daemonPipelinesTask) String() string {
+	return "running pipelines"
+}
+
+// Register adds the API routes to the given router. If the Token is set, all requests must contain it.
+func (d *PipelineDaemon) Register(pathPrefix string, router *mux.Router) {
+	router.HandleFunc(pathPrefix+"/pipelines", d.authorized(d.handleList)).Methods("GET")
+	router.HandleFunc(pathPrefix+"/pipelines/{name}", d.authorized(d.handleInspect)).Methods("GET")
+	router.HandleFunc(pathPrefix+"/pipelines/{name}", d.authorized(d.handleSubmit)).Methods("POST", "PUT")
+	router.HandleFunc(pathPrefix+"/pipelines/{name}", d.authorized(d.handleDelete)).Methods("DELETE")
+	router.HandleFunc(pathPrefix+"/pipelines/{name}/pause", d.authorized(d.handlePause)).Methods("POST")
+	router.HandleFunc(pathPrefix+"/pipelines/{name}/resume", d.authorized(d.handleResume)).Methods("POST")
+	router.HandleFunc(pathPrefix+"/pipelines/{name}/stop", d.authorized(d.handleStop)).Methods("POST")
+}
+
+func (d *PipelineDaemon) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.Token != "" {
+			const prefix = "Bearer "
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, prefix) || subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(d.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJsonError(w, http.StatusUnauthorized, errors.New("Missing or invalid access token"))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// Submit builds the given script and starts the resulting pipeline under the given name.
+// A finished pipeline with the same name is replaced, a running one results in an error.
+func (d *PipelineDaemon) Submit(name string, script string) (*ManagedPipeline, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if existing, ok := d.pipelines[name]; ok && !existing.isDone() {
+		return nil, fmt.Errorf("Pipeline '%v' is already running", name)
+	}
+
+	d.buildLock.Lock()
+	pipe, err := d.Builder.BuildPipeline(script)
+	d.buildLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if pipe == nil {
+		return nil, fmt.Errorf("The script did not produce a pipeline")
+	}
+	pipe.Name = name
+
+	managed := &ManagedPipeline{
+		Name:     name,
+		Script:   script,
+		pipeline: pipe,
+		gate:     steps.NewPausingProcessor(),
+	}
+	d.pipelines[name] = managed
+	managed.start()
+	return managed, nil
+}
+
+func (d *PipelineDaemon) Get(name string) (*ManagedPipeline, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p, ok := d.pipelines[name]
+	return p, ok
+}
+
+// Remove stops the given pipeline, if necessary, and removes it from the daemon.
+func (d *PipelineDaemon) Remove(name string) bool {
+	d.lock.Lock()
+	p, ok := d.pipelines[name]
+	delete(d.pipelines, name)
+	d.lock.Unlock()
+	if ok {
+		p.Stop()
+	}
+	return ok
+}
+
+func (d *PipelineDaemon) List() []PipelineInfo {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	res := make([]PipelineInfo, 0, len(d.pipelines))
+	for _, p := range d.pipelines {
+		res = append(res, p.Info(false))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+func (d *PipelineDaemon) StopAll() {
+	d.lock.Lock()
+	pipes := make([]*ManagedPipeline, 0, len(d.pipelines))
+	for _, p := range d.pipelines {
+		pipes = append(pipes, p)
+	}
+	d.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range pipes {
+		wg.Add(1)
+		go func(p *ManagedPipeline) {
+			defer wg.Done()
+			p.Stop()
+		}(p)
+	}
+	wg.Wait()
+}
+
+func (p *ManagedPipeline) start() {
+	p.pipeline.Processors = append([]bitflow.SampleProcessor{p.gate}, p.pipeline.Processors...)
+	p.stopper = golib.NewStopChan()
+	p.finished = golib.NewStopChan()
+	p.Started = time.Now()
+	p.status = PipelineStatusRunning
+
+	var tasks golib.TaskGroup
+	p.pipeline.Construct(&tasks)
+	stopTask := &golib.NoopTask{Chan: p.stopper, Description: "stop pipeline " + p.Name}
+	tasks.Add(stopTask)
+	go func() {
+		log.Printf("Starting pipeline '%v'", p.Name)
+		var wg sync.WaitGroup
+		channels := tasks.StartTasks(&wg)
+		reason := golib.WaitForAny(channels)
+		tasks.Stop()
+		wg.Wait()
+		// Processors that were not closed regularly, e.g. after an error, must not be reported anymore
+		bitflow.DefaultPipelineStatistics.UnregisterPipeline(p.pipeline)
+		var errors []string
+		tasks.CollectErrors(channels, func(err error) {
+			log.Errorf("Pipeline '%v': %v", p.Name, err)
+			errors = append(errors, err.Error())
+		})
+
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		p.stopped = time.Now()
+		p.errors = errors
+		switch {
+		case len(errors) > 0:
+			p.status = PipelineStatusFailed
+		case reason >= 0 && tasks[reason] == stopTask:
+			p.status = PipelineStatusStopped
+		default:
+			p.status = PipelineStatusFinished
+		}
+		log.Printf("Pipeline '%v' %v", p.Name, p.status)
+		p.finished.Stop()
+	}()
+}
+
+// Pause halts the data source of the pipeline by blocking all samples at the beginning of the pipeline.
+func (p *ManagedPipeline) Pause() error {
+	return p.setPaused(true)
+}
+
+// Resume continues a paused pipeline.
+func (p *ManagedPipeline) Resume() error {
+	return p.setPaused(false)
+}
+
+func (p *ManagedPipeline) setPaused(paused bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.status != PipelineStatusRunning && p.status != PipelineStatusPaused {
+		return fmt.Errorf("Pipeline '%v' is %v", p.Name, p.status)
+	}
+	if paused {
+		p.gate.Pause()
+		p.status = PipelineStatusPaused
+	} else {
+		p.gate.Resume()
+		p.status = PipelineStatusRunning
+	}
+	return nil
+}
+
+// Stop stops the pipeline and waits for it to shut down.
+func (p *ManagedPipeline) Stop() {
+	// Samples blocked in the pause gate would prevent the data source from shutting down
+	p.gate.Resume()
+	p.stopper.Stop()
+	p.finished.Wait()
+}
+
+func (p *ManagedPipeline) isDone() bool {
+	return p.finished.Stopped()
+}
+
+func (p *ManagedPipeline) Info(details bool) PipelineInfo {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	info := PipelineInfo{
+		Name:    p.Name,
+		Script:  p.Script,
+		Status:  p.status,
+		Started: p.Started,
+		Errors:  p.errors,
+	}
+	if !p.stopped.IsZero() {
+		stopped := p.stopped
+		info.Stopped = &stopped
+	}
+	if details {
+		info.Pipeline = p.pipeline.FormatLines()
+	}
+	return info
+}
+
+func (d *PipelineDaemon) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJsonReply(w, http.StatusOK, d.List())
+}
+
+func (d *PipelineDaemon) handleInspect(w http.ResponseWriter, r *http.Request) {
+	if p := d.getPipeline(w, r); p != nil {
+		writeJsonReply(w, http.StatusOK, p.Info(true))
+	}
+}
+
+func (d *PipelineDaemon) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	script, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, err := d.Submit(mux.Vars(r)["name"], string(script))
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+	} else {
+		writeJsonReply(w, http.StatusCreated, p.Info(true))
+	}
+}
+
+func (d *PipelineDaemon) handleDelete(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !d.Remove(name) {
+		writeJsonError(w, http.StatusNotFound, fmt.Errorf("No such pipeline: %v", name))
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (d *PipelineDaemon) handlePause(w http.ResponseWriter, r *http.Request) {
+	d.handleControl(w, r, (*ManagedPipeline).Pause)
+}
+
+func (d *PipelineDaemon) handleResume(w http.ResponseWriter, r *http.Request) {
+	d.handleControl(w, r, (*ManagedPipeline).Resume)
+}
+
+func (d *PipelineDaemon) handleStop(w http.ResponseWriter, r *http.Request) {
+	d.handleControl(w, r, func(p *ManagedPipeline) error {
+		p.Stop()
+		return nil
+	})
+}
+
+func (d *PipelineDaemon) handleControl(w http.ResponseWriter, r *http.Request, control func(p *ManagedPipeline) error) {
+	if p := d.getPipeline(w, r); p != nil {
+		if err := control(p); err != nil {
+			writeJsonError(w, http.StatusConflict, err)
+		} else {
+			writeJsonReply(w, http.StatusOK, p.Info(false))
+		}
+	}
+}
+
+func (d *PipelineDaemon) getPipeline(w http.ResponseWriter, r *http.Request) *ManagedPipeline {
+	name := mux.Vars(r)["name"]
+	p, ok := d.Get(name)
+	if !ok {
+		writeJsonError(w, http.StatusNotFound, fmt.Errorf("No such pipeline: %v", name))
+		return nil
+	}
+	return p
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	writeJsonReply(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJsonReply(w http.ResponseWriter, status int, obj interface{}) {
+	data, err := JSONMarshal(obj)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+const daemonTestScript = `"generate://rate=100" -> noop()`
+
+type daemonTestSuite struct {
+	testsupport.Suite
+	daemon *PipelineDaemon
+	server *httptest.Server
+	token  string // Sent with every request, if set
+}
+
+func TestDaemon(t *testing.T) {
+	suite.Run(t, new(daemonTestSuite))
+}
+
+func (s *daemonTestSuite) SetupTest() {
+	builder := &CmdPipelineBuilder{ProcessorRegistry: reg.NewProcessorRegistry()}
+	s.daemon = NewPipelineDaemon(builder)
+	router := mux.NewRouter()
+	s.daemon.Register(RestApiPathPrefix, router)
+	s.server = httptest.NewServer(router)
+}
+
+func (s *daemonTestSuite) TearDownTest() {
+	s.daemon.StopAll()
+	s.server.Close()
+	s.token = ""
+}
+
+// request sends a request to the daemon API and decodes the JSON reply into the result parameter, if it is not nil.
+func (s *daemonTestSuite) request(method string, path string, body string, result interface{}) int {
+	req, err := http.NewRequest(method, s.server.URL+RestApiPathPrefix+path, strings.NewReader(body))
+	s.NoError(err)
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	s.NoError(err)
+	defer resp.Body.Close()
+	if result != nil {
+		s.NoError(json.NewDecoder(resp.Body).Decode(result))
+	}
+	return resp.StatusCode
+}
+
+func (s *daemonTestSuite) list() []PipelineInfo {
+	var infos []PipelineInfo
+	s.Equal(http.StatusOK, s.request("GET", "/pipelines", "", &infos))
+	return infos
+}
+
+func (s *daemonTestSuite) TestAddListRemove() {
+	s.Empty(s.list())
+
+	var info PipelineInfo
+	s.Equal(http.StatusCreated, s.request("PUT", "/pipelines/first", daemonTestScript, &info))
+	s.Equal("first", info.Name)
+	s.Equal(PipelineStatusRunning, info.Status)
+	s.NotEmpty(info.Pipeline)
+	s.Equal(http.StatusCreated, s.request("POST", "/pipelines/second", daemonTestScript, nil))
+
+	infos := s.list()
+	s.Len(infos, 2)
+	s.Equal("first", infos[0].Name)
+	s.Equal("second", infos[1].Name)
+
+	s.Equal(http.StatusNoContent, s.request("DELETE", "/pipelines/first", "", nil))
+	infos = s.list()
+	s.Len(infos, 1)
+	s.Equal("second", infos[0].Name)
+	s.Equal(http.StatusNotFound, s.request("GET", "/pipelines/first", "", nil))
+	s.Equal(http.StatusNotFound, s.request("DELETE", "/pipelines/first", "", nil))
+}
+
+func (s *daemonTestSuite) TestSubmitErrors() {
+	s.Equal(http.StatusBadRequest, s.request("PUT", "/pipelines/invalid", "invalid_step()", nil))
+	s.Equal(http.StatusCreated, s.request("PUT", "/pipelines/running", daemonTestScript, nil))
+	s.Equal(http.StatusBadRequest, s.request("PUT", "/pipelines/running", daemonTestScript, nil), "A running pipeline must not be replaced")
+	s.Len(s.list(), 1)
+}
+
+func (s *daemonTestSuite) TestPauseAndStop() {
+	s.Equal(http.StatusCreated, s.request("PUT", "/pipelines/p", daemonTestScript, nil))
+	var info PipelineInfo
+	s.Equal(http.StatusOK, s.request("POST", "/pipelines/p/pause", "", &info))
+	s.Equal(PipelineStatusPaused, info.Status)
+	s.Equal(http.StatusOK, s.request("POST", "/pipelines/p/resume", "", &info))
+	s.Equal(PipelineStatusRunning, info.Status)
+	s.Equal(http.StatusOK, s.request("POST", "/pipelines/p/stop", "", &info))
+	s.Equal(PipelineStatusStopped, info.Status)
+	s.NotNil(info.Stopped)
+	s.Equal(http.StatusConflict, s.request("POST", "/pipelines/p/pause", "", nil))
+}
+
+func (s *daemonTestSuite) TestRemoveUnregistersStatistics() {
+	s.Equal(http.StatusCreated, s.request("PUT", "/pipelines/p", daemonTestScript, nil))
+	p, _ := s.daemon.Get("p")
+	s.True(s.hasStatistics(p), "The processors of a running pipeline must be registered")
+	s.Equal(http.StatusNoContent, s.request("DELETE", "/pipelines/p", "", nil))
+	s.False(s.hasStatistics(p), "The processors of a removed pipeline must be unregistered")
+}
+
+func (s *daemonTestSuite) hasStatistics(p *ManagedPipeline) bool {
+	for _, stats := range bitflow.DefaultPipelineStatistics.Processors() {
+		for _, processor := range p.pipeline.Processors {
+			if stats.Processor == processor {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *daemonTestSuite) TestToken() {
+	s.daemon.Token = "secret"
+	s.Equal(http.StatusUnauthorized, s.request("GET", "/pipelines", "", nil))
+	s.Equal(http.StatusUnauthorized, s.request("PUT", "/pipelines/p", daemonTestScript, nil))
+	s.token = "wrong"
+	s.Equal(http.StatusUnauthorized, s.request("GET", "/pipelines", "", nil))
+	s.Empty(s.daemon.List())
+
+	s.token = "secret"
+	s.Equal(http.StatusCreated, s.request("PUT", "/pipelines/p", daemonTestScript, nil))
+	s.Len(s.list(), 1)
+}
+
+func (s *daemonTestSuite) TestListenAddress() {
+	d := new(PipelineDaemon)
+	addr, err := d.ListenAddress(":7777")
+	s.NoError(err)
+	s.Equal("localhost:7777", addr, "Endpoints without host must be served on the loopback interface")
+	addr, err = d.ListenAddress("127.0.0.1:7777")
+	s.NoError(err)
+	s.Equal("127.0.0.1:7777", addr)
+
+	_, err = d.ListenAddress("0.0.0.0:7777")
+	s.Error(err, "Other interfaces require a token and TLS")
+	d.Token = "secret"
+	_, err = d.ListenAddress("0.0.0.0:7777")
+	s.Error(err)
+	d.TLSCert = "cert.pem"
+	_, err = d.ListenAddress("0.0.0.0:7777")
+	s.Error(err, "The TLS key is missing")
+	d.TLSKey = "key.pem"
+	addr, err = d.ListenAddress("0.0.0.0:7777")
+	s.NoError(err)
+	s.Equal("0.0.0.0:7777", addr)
+}
+
+func (s *daemonTestSuite) TestLoadToken() {
+	file, err := ioutil.TempFile("", "bitflow-daemon-token")
+	s.NoError(err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("secret\n")
+	s.NoError(err)
+	s.NoError(file.Close())
+
+	d := &PipelineDaemon{TokenFile: file.Name()}
+	s.NoError(d.LoadToken())
+	s.Equal("secret", d.Token)
+
+	s.NoError(os.Setenv(DaemonTokenEnv, "from-env"))
+	defer os.Unsetenv(DaemonTokenEnv)
+	d = new(PipelineDaemon)
+	s.NoError(d.LoadToken())
+	s.Equal("from-env", d.Token)
+}
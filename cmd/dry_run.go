@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
+)
+
+// DryRun replaces the data source of a pipeline with synthetic samples and the outputs with placeholders,
+// so that a script can be verified end-to-end without reading or writing real data.
+type DryRun struct {
+	Samples int
+	Fields  []string
+
+	// Tags are added to every synthetic sample. Multiple values can be separated by '|' and are used in turns.
+	Tags map[string]string
+
+	counters []*dryRunCounter
+}
+
+// Run replaces the source and outputs of the pipeline, runs it and logs the number of samples emitted by each step.
+// Forks are counted as a whole, the steps inside sub-pipelines are not counted separately.
+func (d *DryRun) Run(pipe *bitflow.SamplePipeline) error {
+	d.instrument(pipe)
+	log.Printf("Dry run: pushing %v synthetic samples with %v metric(s) through the pipeline", d.Samples, len(d.Fields))
+	numErrors := pipe.StartAndWait()
+	for i, counter := range d.counters {
+		log.Printf("Dry run: %v. %v: %v sample(s)", i+1, counter.step, counter.Samples())
+	}
+	if numErrors > 0 {
+		return fmt.Errorf("The dry run failed with %v error(s)", numErrors)
+	}
+	return nil
+}
+
+func (d *DryRun) instrument(pipe *bitflow.SamplePipeline) {
+	pipe.Source = &syntheticSource{run: d}
+	processors := make([]bitflow.SampleProcessor, 0, 2*len(pipe.Processors))
+	for _, proc := range pipe.Processors {
+		counter := &dryRunCounter{step: proc, forward: true}
+		if isOutput(proc) {
+			// Outputs are not executed, only counted
+			if output, ok := proc.(forwardingOutput); ok {
+				counter.forward = output.ForwardsSamples()
+			}
+		} else {
+			processors = append(processors, proc)
+		}
+		processors = append(processors, counter)
+		d.counters = append(d.counters, counter)
+	}
+	pipe.Processors = processors
+}
+
+type forwardingOutput interface {
+	ForwardsSamples() bool
+}
+
+func isOutput(proc bitflow.SampleProcessor) bool {
+	switch proc.(type) {
+	case bitflow.MarshallingSampleOutput, *bitflow.ConsoleBoxSink:
+		return true
+	}
+	return false
+}
+
+// dryRunCounter counts the samples emitted by the preceding step, or replaces an output step.
+type dryRunCounter struct {
+	bitflow.NoopProcessor
+	step    bitflow.SampleProcessor
+	forward bool
+	samples uint64
+}
+
+func (c *dryRunCounter) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	atomic.AddUint64(&c.samples, 1)
+	if !c.forward {
+		return nil
+	}
+	return c.NoopProcessor.Sample(sample, header)
+}
+
+func (c *dryRunCounter) Samples() uint64 {
+	return atomic.LoadUint64(&c.samples)
+}
+
+func (c *dryRunCounter) String() string {
+	return fmt.Sprintf("Dry run counter (%v)", c.step)
+}
+
+// syntheticSource emits the configured number of samples with pseudo-random values and then closes the pipeline.
+type syntheticSource struct {
+	bitflow.AbstractSampleSource
+	run  *DryRun
+	task *golib.LoopTask
+}
+
+func (s *syntheticSource) String() string {
+	return fmt.Sprintf("Synthetic samples (%v samples, fields: %v)", s.run.Samples, strings.Join(s.run.Fields, ", "))
+}
+
+func (s *syntheticSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	header := &bitflow.Header{Fields: s.run.Fields}
+	random := rand.New(rand.NewSource(1))
+	tags := make(map[string][]string, len(s.run.Tags))
+	for key, values := range s.run.Tags {
+		tags[key] = strings.Split(values, "|")
+	}
+	timestamp := time.Now().Add(-time.Duration(s.run.Samples) * time.Second)
+	sent := 0
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			if sent >= s.run.Samples {
+				return golib.StopLoopTask
+			}
+			sample := &bitflow.Sample{Time: timestamp, Values: make([]bitflow.Value, len(header.Fields))}
+			for i := range sample.Values {
+				sample.Values[i] = bitflow.Value(random.Float64() * 100)
+			}
+			for key, values := range tags {
+				sample.SetTag(key, values[sent%len(values)])
+			}
+			sent++
+			timestamp = timestamp.Add(time.Second)
+			return s.GetSink().Sample(sample, header)
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *syntheticSource) Close() {
+	s.task.Stop()
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type dryRunTestSuite struct {
+	testsupport.Suite
+	dir string
+}
+
+func TestDryRun(t *testing.T) {
+	suite.Run(t, new(dryRunTestSuite))
+}
+
+func (s *dryRunTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "bitflow-dry-run-test")
+	s.NoError(err)
+	s.dir = dir
+}
+
+func (s *dryRunTestSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+// dropEverySecond forwards only every second sample
+func dropEverySecond() bitflow.SampleProcessor {
+	num := 0
+	return &bitflow.SimpleProcessor{
+		Description: "drop every second sample",
+		Process: func(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Sample, *bitflow.Header, error) {
+			num++
+			if num%2 == 0 {
+				return nil, nil, nil
+			}
+			return sample, header, nil
+		},
+	}
+}
+
+type dryRunRecorder struct {
+	lock    sync.Mutex
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (r *dryRunRecorder) step() bitflow.SampleProcessor {
+	return &bitflow.SimpleProcessor{
+		Description: "recorder",
+		Process: func(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Sample, *bitflow.Header, error) {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			r.samples = append(r.samples, sample)
+			r.headers = append(r.headers, header)
+			return sample, header, nil
+		},
+	}
+}
+
+func (s *dryRunTestSuite) counts(run *DryRun) []uint64 {
+	res := make([]uint64, len(run.counters))
+	for i, counter := range run.counters {
+		res[i] = counter.Samples()
+	}
+	return res
+}
+
+func (s *dryRunTestSuite) TestRun() {
+	var recorder dryRunRecorder
+	file := filepath.Join(s.dir, "out.csv")
+	pipe := &bitflow.SamplePipeline{Source: &bitflow.EmptySampleSource{}}
+	pipe.Add(dropEverySecond()).Add(&bitflow.FileSink{Filename: file}).Add(recorder.step())
+
+	run := &DryRun{Samples: 10, Fields: []string{"x", "y"}, Tags: map[string]string{"host": "a|b|c", "zone": "z"}}
+	s.NoError(run.Run(pipe))
+	s.IsType(new(syntheticSource), pipe.Source)
+	s.Equal([]uint64{5, 5, 5}, s.counts(run))
+	_, err := os.Stat(file)
+	s.True(os.IsNotExist(err), "Outputs must not be executed")
+
+	s.Len(recorder.samples, 5)
+	s.Equal([]string{"x", "y"}, recorder.headers[0].Fields)
+	var hosts []string
+	for i, sample := range recorder.samples {
+		s.Len(sample.Values, 2)
+		hosts = append(hosts, sample.Tag("host"))
+		s.Equal("z", sample.Tag("zone"))
+		if i > 0 {
+			s.Equal(2*time.Second, sample.Time.Sub(recorder.samples[i-1].Time), "Synthetic samples are one second apart")
+		}
+	}
+	s.Equal([]string{"a", "c", "b", "a", "c"}, hosts, "Tag values are used in turns")
+}
+
+func (s *dryRunTestSuite) TestNonForwardingOutput() {
+	var recorder dryRunRecorder
+	out := &bitflow.FileSink{Filename: filepath.Join(s.dir, "out.csv")}
+	out.DontForwardSamples = true
+	pipe := &bitflow.SamplePipeline{Source: &bitflow.EmptySampleSource{}}
+	pipe.Add(out).Add(recorder.step())
+
+	run := &DryRun{Samples: 3, Fields: []string{"x"}}
+	s.NoError(run.Run(pipe))
+	s.Equal([]uint64{3, 0}, s.counts(run))
+	s.Empty(recorder.samples)
+}
+
+func (s *dryRunTestSuite) TestError() {
+	pipe := new(bitflow.SamplePipeline)
+	pipe.Add(&bitflow.SimpleProcessor{
+		Process: func(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Sample, *bitflow.Header, error) {
+			return nil, nil, errors.New("broken step")
+		},
+	})
+	run := &DryRun{Samples: 3, Fields: []string{"x"}}
+	s.Error(run.Run(pipe))
+}
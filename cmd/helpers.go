@@ -2,13 +2,43 @@ package cmd
 
 import (
 	"flag"
+	"fmt"
+	"time"
 
 	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
 )
 
 func ParseFlags() (*flag.FlagSet, []string) {
 	golib.RegisterFlags(golib.FlagsAll)
+	logJson := flag.Bool("log-json", false, "Output log entries as JSON objects, including the pipeline, fork and step fields of pipeline steps.")
 	previousFlags, args := golib.ParseFlags()
 	golib.ConfigureLogging()
+	if *logJson {
+		formatter := &jsonLogFormatter{log.JSONFormatter{TimestampFormat: time.RFC3339Nano}}
+		log.SetFormatter(formatter)
+		golib.Log.SetFormatter(formatter)
+	}
 	return previousFlags, args
 }
+
+// jsonLogFormatter formats field values that are no basic types as strings, since many fields
+// like marshallers or addresses would otherwise be encoded as (empty) JSON objects.
+type jsonLogFormatter struct {
+	log.JSONFormatter
+}
+
+func (f *jsonLogFormatter) Format(entry *log.Entry) ([]byte, error) {
+	fields := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch value.(type) {
+		case nil, string, bool, int, int64, uint64, float64, error:
+			fields[key] = value
+		default:
+			fields[key] = fmt.Sprint(value)
+		}
+	}
+	formatted := *entry
+	formatted.Data = fields
+	return f.JSONFormatter.Format(&formatted)
+}
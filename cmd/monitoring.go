@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// MonitoringServer serves health and metrics endpoints for the pipeline process over HTTP:
+//   - /healthz returns 200, as long as the process is able to answer requests (liveness probe)
+//   - /readyz returns 200, when a pipeline is running, and 503 otherwise (readiness probe)
+//   - /metrics returns runtime metrics of the process and the sample counters of all pipeline steps in the Prometheus text format
+//
+// A pipeline is considered running, when it registered its steps in the Statistics. While a pipeline is reloaded, it is not ready.
+type MonitoringServer struct {
+	Endpoint string
+
+	// Statistics defaults to bitflow.DefaultPipelineStatistics
+	Statistics *bitflow.PipelineStatistics
+
+	started time.Time
+}
+
+func (s *MonitoringServer) statistics() *bitflow.PipelineStatistics {
+	if s.Statistics == nil {
+		return bitflow.DefaultPipelineStatistics
+	}
+	return s.Statistics
+}
+
+func (s *MonitoringServer) Register(router *mux.Router) {
+	router.HandleFunc("/healthz", s.handleHealth).Methods("GET", "HEAD")
+	router.HandleFunc("/readyz", s.handleReady).Methods("GET", "HEAD")
+	router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+}
+
+// Start serves the monitoring endpoints in the background for the rest of the process lifetime.
+func (s *MonitoringServer) Start() {
+	s.started = time.Now()
+	router := mux.NewRouter()
+	s.Register(router)
+	server := http.Server{
+		Addr:    s.Endpoint,
+		Handler: router,
+	}
+	// Do not add this routine to any wait group: the endpoints must stay available while pipelines are stopped and reloaded
+	go func() {
+		log.Errorln("Monitoring server stopped:", server.ListenAndServe())
+	}()
+	log.Println("Serving health and metrics endpoints on", s.Endpoint)
+}
+
+// Ready returns true, if a pipeline is currently running.
+func (s *MonitoringServer) Ready() bool {
+	return len(s.statistics().Processors()) > 0
+}
+
+func (s *MonitoringServer) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+func (s *MonitoringServer) handleReady(w http.ResponseWriter, _ *http.Request) {
+	if s.Ready() {
+		w.Write([]byte("ok\n"))
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("no pipeline running\n"))
+	}
+}
+
+func (s *MonitoringServer) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(s.Metrics())
+}
+
+// Metrics returns the current metrics in the Prometheus text exposition format.
+func (s *MonitoringServer) Metrics() []byte {
+	var buf bytes.Buffer
+	metric := func(name, metricType, help string, value float64) {
+		fmt.Fprintf(&buf, "# HELP %v %v\n# TYPE %v %v\n%v %v\n", name, help, name, metricType, name, formatMetricValue(value))
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	ready := 0.0
+	if s.Ready() {
+		ready = 1
+	}
+	metric("bitflow_pipeline_ready", "gauge", "Whether a pipeline is running (1) or not (0).", ready)
+	metric("bitflow_uptime_seconds", "gauge", "Time since the monitoring server was started.", time.Since(s.started).Seconds())
+	metric("bitflow_goroutines", "gauge", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	metric("bitflow_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(memStats.HeapAlloc))
+	metric("bitflow_heap_objects", "gauge", "Number of allocated heap objects.", float64(memStats.HeapObjects))
+	metric("bitflow_heap_sys_bytes", "gauge", "Bytes of heap memory obtained from the OS.", float64(memStats.HeapSys))
+	metric("bitflow_gc_total", "counter", "Number of completed GC cycles.", float64(memStats.NumGC))
+	metric("bitflow_gc_pause_seconds_total", "counter", "Total time spent in GC pauses.", time.Duration(memStats.PauseTotalNs).Seconds())
+
+	processors := s.statistics().Processors()
+	buf.WriteString("# HELP bitflow_step_samples_total Number of samples forwarded into the pipeline step.\n# TYPE bitflow_step_samples_total counter\n")
+	for i, proc := range processors {
+		fmt.Fprintf(&buf, "bitflow_step_samples_total{%v} %v\n", stepLabels(i, proc), proc.Samples())
+	}
+	buf.WriteString("# HELP bitflow_step_queue_length Number of samples queued inside the pipeline step.\n# TYPE bitflow_step_queue_length gauge\n")
+	for i, proc := range processors {
+		if queue := proc.QueueLength(); queue >= 0 {
+			fmt.Fprintf(&buf, "bitflow_step_queue_length{%v} %v\n", stepLabels(i, proc), queue)
+		}
+	}
+	return buf.Bytes()
+}
+
+func stepLabels(index int, proc *bitflow.ProcessorStatistics) string {
+	return fmt.Sprintf("step=\"%v\",name=\"%v\"", index, escapeLabelValue(proc.Processor.String()))
+}
+
+var labelValueEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+type monitoringTestSuite struct {
+	testsupport.Suite
+}
+
+func TestMonitoring(t *testing.T) {
+	suite.Run(t, new(monitoringTestSuite))
+}
+
+// monitoringTestStep reports a fixed queue length and has a name that must be escaped in metric labels
+type monitoringTestStep struct {
+	bitflow.NoopProcessor
+}
+
+func (s *monitoringTestStep) String() string {
+	return "step \"quoted\"\nwith \\ newline"
+}
+
+func (s *monitoringTestStep) QueueLength() int {
+	return 3
+}
+
+func (s *monitoringTestSuite) serve(server *MonitoringServer) *httptest.Server {
+	router := mux.NewRouter()
+	server.Register(router)
+	return httptest.NewServer(router)
+}
+
+func (s *monitoringTestSuite) get(server *httptest.Server, path string) (int, string) {
+	resp, err := http.Get(server.URL + path)
+	s.NoError(err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	s.NoError(err)
+	return resp.StatusCode, string(body)
+}
+
+func (s *monitoringTestSuite) TestReadiness() {
+	stats := new(bitflow.PipelineStatistics)
+	server := s.serve(&MonitoringServer{Statistics: stats})
+	defer server.Close()
+
+	code, body := s.get(server, "/healthz")
+	s.Equal(http.StatusOK, code)
+	s.Equal("ok\n", body)
+	code, body = s.get(server, "/readyz")
+	s.Equal(http.StatusServiceUnavailable, code)
+	s.Equal("no pipeline running\n", body)
+
+	step := stats.Register(new(bitflow.NoopProcessor))
+	code, _ = s.get(server, "/readyz")
+	s.Equal(http.StatusOK, code)
+	code, _ = s.get(server, "/healthz")
+	s.Equal(http.StatusOK, code)
+
+	stats.Unregister(step)
+	code, _ = s.get(server, "/readyz")
+	s.Equal(http.StatusServiceUnavailable, code, "Not ready after the pipeline is stopped")
+
+	resp, err := http.Post(server.URL+"/readyz", "text/plain", nil)
+	s.NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func (s *monitoringTestSuite) TestMetricsFormat() {
+	stats := new(bitflow.PipelineStatistics)
+	stats.Register(new(bitflow.NoopProcessor))
+	stats.Register(new(monitoringTestStep))
+	server := s.serve(&MonitoringServer{Statistics: stats, started: time.Now().Add(-time.Minute)})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	s.NoError(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	s.NoError(err)
+	text := string(body)
+
+	// Every sample line must belong to a metric that was declared with HELP and TYPE lines before
+	declared := make(map[string]string)
+	sampleLine := regexp.MustCompile(`^([a-z_]+)(\{step="\d+",name="(?:[^"\\]|\\.)*"\})? (\S+)$`)
+	values := make(map[string]string)
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "# HELP ") {
+			s.True(strings.HasPrefix(lines[i+1], "# TYPE "+strings.Fields(line)[2]+" "), "HELP must be followed by TYPE: %v", line)
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			s.Len(fields, 4)
+			s.Contains([]string{"gauge", "counter"}, fields[3])
+			declared[fields[2]] = fields[3]
+			continue
+		}
+		match := sampleLine.FindStringSubmatch(line)
+		s.NotNil(match, "Invalid sample line: %q", line)
+		s.Contains(declared, match[1], "Undeclared metric: %v", line)
+		values[match[1]+match[2]] = match[3]
+	}
+
+	s.Equal("1", values["bitflow_pipeline_ready"])
+	s.Equal("counter", declared["bitflow_gc_total"])
+	s.Equal("gauge", declared["bitflow_goroutines"])
+	uptime := values["bitflow_uptime_seconds"]
+	s.True(strings.HasPrefix(uptime, "60"), "Uptime: %v", uptime)
+	s.Equal("0", values[`bitflow_step_samples_total{step="0",name="NoopProcessor"}`])
+	s.Equal("0", values[`bitflow_step_samples_total{step="1",name="step \"quoted\"\nwith \\ newline"}`])
+	s.Equal("3", values[`bitflow_step_queue_length{step="1",name="step \"quoted\"\nwith \\ newline"}`])
+	s.NotContains(values, `bitflow_step_queue_length{step="0",name="NoopProcessor"}`, "Steps without a queue report no queue length")
+}
+
+func (s *monitoringTestSuite) TestRunningPipeline() {
+	bitflow.DefaultPipelineStatistics.Clear()
+	defer bitflow.DefaultPipelineStatistics.Clear()
+	server := s.serve(&MonitoringServer{})
+	defer server.Close()
+	code, _ := s.get(server, "/readyz")
+	s.Equal(http.StatusServiceUnavailable, code)
+
+	events := new(reloadTestSuite)
+	pipe := events.pipeline("monitored", false)
+	finished := golib.NewStopChan()
+	go func() {
+		pipe.StartAndWait()
+		finished.Stop()
+	}()
+	for start := time.Now(); time.Since(start) < 5*time.Second && len(events.recorded()) < 2; time.Sleep(time.Millisecond) {
+	}
+	s.Equal([]string{"monitored started", "monitored sample"}, events.recorded())
+
+	code, _ = s.get(server, "/readyz")
+	s.Equal(http.StatusOK, code)
+	_, metrics := s.get(server, "/metrics")
+	s.Contains(metrics, "bitflow_pipeline_ready 1\n")
+	s.Contains(metrics, `bitflow_step_samples_total{step="0",name="`+pipe.Processors[0].String()+`"} 1`+"\n")
+
+	pipe.Source.Close()
+	s.False(finished.WaitTimeout(5*time.Second), "The pipeline did not stop")
+	code, _ = s.get(server, "/readyz")
+	s.Equal(http.StatusServiceUnavailable, code)
+	_, metrics = s.get(server, "/metrics")
+	s.Contains(metrics, "bitflow_pipeline_ready 0\n")
+	s.NotContains(metrics, "bitflow_step_samples_total{")
+}
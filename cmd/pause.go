@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// PipelinePauser pauses and resumes the sample intake of a running pipeline. A pause gate is inserted directly after
+// the data source. While paused, the gate blocks the data source, so no new samples enter the pipeline, but the
+// connections of the data source and the data sinks stay open. Samples that already passed the gate are still processed.
+// Pausing and resuming can be triggered through the SIGUSR1 and SIGUSR2 signals, or through a REST API.
+// The paused state is kept when the pipeline is replaced by a ReloadingPipeline.
+type PipelinePauser struct {
+	PauseOnSignal bool
+	PauseApi      string
+
+	lock     sync.Mutex
+	gate     *steps.PausingProcessor
+	paused   bool
+	signals  chan os.Signal
+	server   *http.Server
+	listener net.Listener
+}
+
+func (p *PipelinePauser) RegisterFlags() {
+	flag.BoolVar(&p.PauseOnSignal, "pause-signal", false, "Pause the sample intake of the pipeline when receiving SIGUSR1, resume when receiving SIGUSR2.")
+	flag.StringVar(&p.PauseApi, "pause-api", "", "Serve a REST API on the given endpoint for pausing and resuming the sample intake of the pipeline "+
+		"(POST "+RestApiPathPrefix+"/pause, POST "+RestApiPathPrefix+"/resume, GET "+RestApiPathPrefix+"/paused).")
+}
+
+// Enabled returns true, if at least one way of pausing the pipeline is configured.
+func (p *PipelinePauser) Enabled() bool {
+	return p.PauseOnSignal || p.PauseApi != ""
+}
+
+// Insert adds a new pause gate to the given pipeline, which replaces the gate of the previous pipeline.
+// The gate is initially paused, if the previous pipeline was paused.
+func (p *PipelinePauser) Insert(pipe *bitflow.SamplePipeline) {
+	gate := steps.NewPausingProcessor()
+	pipe.Processors = append([]bitflow.SampleProcessor{gate}, pipe.Processors...)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.paused {
+		gate.Pause()
+	}
+	p.gate = gate
+}
+
+// Start starts listening for the configured signals and serving the REST API in the background, until Stop() is called.
+func (p *PipelinePauser) Start() error {
+	if p.PauseApi != "" {
+		if err := p.serveApi(); err != nil {
+			return err
+		}
+	}
+	if p.PauseOnSignal {
+		p.handleSignals()
+	}
+	return nil
+}
+
+// Stop stops listening for signals and shuts down the REST API. It should be called after the pipeline
+// and all reloaded pipelines are finished.
+func (p *PipelinePauser) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.signals != nil {
+		signal.Stop(p.signals)
+		close(p.signals)
+		p.signals = nil
+	}
+	if p.server != nil {
+		if err := p.server.Close(); err != nil {
+			log.Errorln("Error stopping pause API:", err)
+		}
+		p.server = nil
+	}
+}
+
+// Pause blocks the data source of the pipeline until Resume() is called.
+func (p *PipelinePauser) Pause() {
+	p.setPaused(true)
+}
+
+// Resume continues the sample intake of a paused pipeline.
+func (p *PipelinePauser) Resume() {
+	p.setPaused(false)
+}
+
+func (p *PipelinePauser) IsPaused() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.paused
+}
+
+func (p *PipelinePauser) setPaused(paused bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.paused == paused {
+		return
+	}
+	p.paused = paused
+	if p.gate != nil {
+		if paused {
+			p.gate.Pause()
+		} else {
+			p.gate.Resume()
+		}
+	}
+	if paused {
+		log.Println("Pipeline paused")
+	} else {
+		log.Println("Pipeline resumed")
+	}
+}
+
+// Task returns a golib.Task that must be added to the tasks of the pipeline, after Insert() was called for it.
+// When the pipeline is stopped, the task releases the samples blocked in the pause gate, because they would prevent
+// the data source from shutting down. The paused state is not changed, so a reloaded pipeline is paused again.
+func (p *PipelinePauser) Task() golib.Task {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return &pauseGateTask{gate: p.gate}
+}
+
+type pauseGateTask struct {
+	gate *steps.PausingProcessor
+}
+
+func (t *pauseGateTask) Start(wg *sync.WaitGroup) golib.StopChan {
+	// The pause gate never causes the pipeline to stop
+	return golib.StopChan{}
+}
+
+func (t *pauseGateTask) Stop() {
+	if t.gate != nil {
+		t.gate.Resume()
+	}
+}
+
+func (t *pauseGateTask) String() string {
+	return "pause gate"
+}
+
+func (p *PipelinePauser) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	p.lock.Lock()
+	p.signals = signals
+	p.lock.Unlock()
+	go func() {
+		for sig := range signals {
+			log.Println("Received", sig)
+			p.setPaused(sig == syscall.SIGUSR1)
+		}
+	}()
+}
+
+func (p *PipelinePauser) router() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc(RestApiPathPrefix+"/pause", p.handleControl(p.Pause)).Methods("POST", "PUT")
+	router.HandleFunc(RestApiPathPrefix+"/resume", p.handleControl(p.Resume)).Methods("POST", "PUT")
+	router.HandleFunc(RestApiPathPrefix+"/paused", p.handleControl(nil)).Methods("GET")
+	return router
+}
+
+// serveApi opens the listening socket synchronously, so that an invalid endpoint is reported immediately.
+// The server runs until Stop() is called.
+func (p *PipelinePauser) serveApi() error {
+	listener, err := net.Listen("tcp", p.PauseApi)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler: p.router(),
+	}
+	p.lock.Lock()
+	p.server = server
+	p.listener = listener
+	p.lock.Unlock()
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Errorln("Pause API stopped:", err)
+		}
+	}()
+	log.Println("Serving pause API on", listener.Addr())
+	return nil
+}
+
+func (p *PipelinePauser) handleControl(control func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if control != nil {
+			control()
+		}
+		writeJsonReply(w, http.StatusOK, map[string]bool{"paused": p.IsPaused()})
+	}
+}
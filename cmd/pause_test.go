@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type pauseTestSuite struct {
+	testsupport.Suite
+}
+
+func TestPause(t *testing.T) {
+	suite.Run(t, new(pauseTestSuite))
+}
+
+func (s *pauseTestSuite) received(recorder *dryRunRecorder) int {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	return len(recorder.samples)
+}
+
+func (s *pauseTestSuite) waitForEvent(events *reloadTestSuite, event string) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		for _, recorded := range events.recorded() {
+			if recorded == event {
+				return
+			}
+		}
+	}
+	s.Fail("Missing event", "Event '%v' did not occur: %v", event, events.recorded())
+}
+
+func (s *pauseTestSuite) TestPauseBlocksSource() {
+	pauser := new(PipelinePauser)
+	var recorder dryRunRecorder
+	pipe := &bitflow.SamplePipeline{Source: &syntheticSource{run: &DryRun{Samples: 5, Fields: []string{"x"}}}}
+	pipe.Add(recorder.step())
+	pauser.Insert(pipe)
+	pauser.Pause()
+	s.True(pauser.IsPaused())
+
+	finished := golib.NewStopChan()
+	go func() {
+		pipe.StartAndWait(pauser.Task())
+		finished.Stop()
+	}()
+	s.True(finished.WaitTimeout(50*time.Millisecond), "The paused pipeline must not finish")
+	s.Equal(0, s.received(&recorder), "The source must be blocked")
+
+	pauser.Resume()
+	s.False(pauser.IsPaused())
+	s.False(finished.WaitTimeout(5*time.Second), "The resumed pipeline did not finish")
+	s.Equal(5, s.received(&recorder))
+}
+
+func (s *pauseTestSuite) TestPausedStateSurvivesReload() {
+	pauser := new(PipelinePauser)
+	events := new(reloadTestSuite)
+	var current *bitflow.SamplePipeline
+	reloader := &ReloadingPipeline{
+		Build: func(string) (*bitflow.SamplePipeline, error) {
+			current = events.pipeline("new", false)
+			pauser.Insert(current)
+			return current, nil
+		},
+		Tasks: func() []golib.Task {
+			return []golib.Task{pauser.Task()}
+		},
+	}
+	old := events.pipeline("old", false)
+	pauser.Insert(old)
+	finished := golib.NewStopChan()
+	go func() {
+		reloader.Run(old)
+		finished.Stop()
+	}()
+	s.waitForEvent(events, "old sample")
+
+	pauser.Pause()
+	s.NoError(reloader.Reload(""))
+	s.waitForEvent(events, "new started")
+	s.True(pauser.IsPaused())
+	s.Equal("Pause gate (paused)", current.Processors[0].String(), "The gate of the reloaded pipeline must be paused")
+	time.Sleep(50 * time.Millisecond)
+	s.NotContains(events.recorded(), "new sample")
+
+	pauser.Resume()
+	s.waitForEvent(events, "new sample")
+	current.Source.Close()
+	s.False(finished.WaitTimeout(5*time.Second), "The reloaded pipeline did not stop")
+}
+
+func (s *pauseTestSuite) request(router http.Handler, method, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, RestApiPathPrefix+path, nil))
+	return recorder.Code, strings.TrimSpace(recorder.Body.String())
+}
+
+func (s *pauseTestSuite) TestApi() {
+	pauser := new(PipelinePauser)
+	pauser.Insert(new(bitflow.SamplePipeline))
+	router := pauser.router()
+
+	code, body := s.request(router, http.MethodGet, "/paused")
+	s.Equal(http.StatusOK, code)
+	s.Equal(`{"paused":false}`, body)
+	code, body = s.request(router, http.MethodPost, "/pause")
+	s.Equal(http.StatusOK, code)
+	s.Equal(`{"paused":true}`, body)
+	s.True(pauser.gate.IsPaused())
+	code, body = s.request(router, http.MethodPut, "/resume")
+	s.Equal(http.StatusOK, code)
+	s.Equal(`{"paused":false}`, body)
+	s.False(pauser.gate.IsPaused())
+
+	code, _ = s.request(router, http.MethodGet, "/pause")
+	s.Equal(http.StatusMethodNotAllowed, code)
+}
+
+func (s *pauseTestSuite) TestStop() {
+	pauser := &PipelinePauser{PauseApi: "127.0.0.1:0"}
+	s.NoError(pauser.Start())
+	url := "http://" + pauser.listener.Addr().String() + RestApiPathPrefix + "/paused"
+	resp, err := http.Get(url)
+	s.NoError(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.NoError(err)
+	s.Equal(`{"paused":false}`, strings.TrimSpace(string(body)))
+
+	pauser.Stop()
+	_, err = http.Get(url)
+	s.Error(err, "The API must be stopped")
+	pauser.Stop() // Stopping twice is allowed
+
+	s.Error((&PipelinePauser{PauseApi: "256.0.0.1:x"}).Start(), "Invalid endpoints must be reported")
+}
@@ -2,21 +2,41 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/graph"
+	"github.com/bitflow-stream/go-bitflow/script/lint"
+	"github.com/bitflow-stream/go-bitflow/script/lsp"
 	"github.com/bitflow-stream/go-bitflow/script/plugin"
+	"github.com/bitflow-stream/go-bitflow/script/plugin/remote"
+	"github.com/bitflow-stream/go-bitflow/script/preprocess"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/script/script"
 	"github.com/bitflow-stream/go-bitflow/script/script_go"
 	defaultPlugin "github.com/bitflow-stream/go-bitflow/steps/bitflow-plugin-default-steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
+const tracingShutdownTimeout = 10 * time.Second
+
+// PluginDirEnv names an environment variable with a directory, from which plugins are loaded in addition to the -plugin-dir flags.
+const PluginDirEnv = "BITFLOW_PLUGIN_DIR"
+
 type CmdPipelineBuilder struct {
 	reg.ProcessorRegistry
 	SkipInputFlags bool
@@ -24,16 +44,73 @@ type CmdPipelineBuilder struct {
 	printAnalyses     bool
 	printPipeline     bool
 	printCapabilities bool
+	printSchema       bool
+	formatScript      bool
+	checkScript       bool
+	checkFields       int
+	printGraph        string
+	dryRun            bool
+	dryRunSamples     int
+	dryRunFields      golib.StringSlice
+	dryRunTags        golib.KeyValueStringSlice
+	benchmark         bool
+	benchmarkSamples  int
+	benchmarkRecorded bool
+	benchmarkFormat   string
+	benchmarkOutput   string
 	useOldScript      bool
 	pluginPaths       golib.StringSlice
+	pluginDirs        golib.StringSlice
+	scriptParams      golib.KeyValueStringSlice
+	monitoringPort    int
+	tracingEndpoint   string
+	tracingService    string
+	tracingRatio      float64
+	tracerProvider    *sdktrace.TracerProvider
+	pipelineName      string
+	pluginsLoaded     bool
 }
 
 func (c *CmdPipelineBuilder) RegisterFlags() {
 	flag.BoolVar(&c.printAnalyses, "print-analyses", false, "Print a list of available analyses and exit.")
 	flag.BoolVar(&c.printPipeline, "print-pipeline", false, "Print the parsed pipeline and exit. Can be used to verify the input script.")
 	flag.BoolVar(&c.printCapabilities, "capabilities", false, "Print the capabilities of this pipeline in JSON form and exit.")
+	flag.BoolVar(&c.printSchema, "capabilities-schema", false, "Print a JSON Schema of all processing steps, forks and endpoints and exit.")
+	flag.BoolVar(&c.formatScript, "format", false, "Verify the input script, print it in canonical formatting and exit.")
+	flag.BoolVar(&c.checkScript, "check", false, "Verify the input script, check the resulting pipeline for common mistakes and exit.")
+	flag.IntVar(&c.checkFields, "check-fields", lint.DefaultInputFields, "Number of metrics in the input samples, assumed by -check.")
+	flag.StringVar(&c.printGraph, "print-graph", "", "Print the parsed pipeline as a graph in the given format ('dot' for Graphviz or 'mermaid') and exit.")
+	flag.BoolVar(&c.dryRun, "dry-run", false, "Run the pipeline with synthetic samples instead of the data source, skip all outputs, report the number of samples emitted by every step and exit.")
+	flag.IntVar(&c.dryRunSamples, "dry-run-samples", 100, "Number of synthetic samples generated by -dry-run.")
+	flag.Var(&c.dryRunFields, "dry-run-field", "Metric in the synthetic samples generated by -dry-run. Can be defined multiple times, default: a, b, c")
+	flag.Var(&c.dryRunTags, "dry-run-tag", "Tag in the form key=value, added to the synthetic samples generated by -dry-run. Values separated by '|' are used in turns.")
+	flag.BoolVar(&c.benchmark, "benchmark", false, "Run the pipeline with synthetic samples, skip all outputs, print a report of the throughput, latency and allocations of every step and exit. "+
+		"The synthetic samples are configured with -dry-run-field and -dry-run-tag.")
+	flag.IntVar(&c.benchmarkSamples, "benchmark-samples", 100000, "Number of synthetic samples generated by -benchmark.")
+	flag.BoolVar(&c.benchmarkRecorded, "benchmark-recorded", false, "Use the data source of the script for -benchmark instead of synthetic samples, e.g. to benchmark with a recorded file.")
+	flag.StringVar(&c.benchmarkFormat, "benchmark-format", "json", "Format of the report printed by -benchmark: json or csv.")
+	flag.StringVar(&c.benchmarkOutput, "benchmark-output", "", "File to write the report of -benchmark to. Default: standard output.")
 	flag.BoolVar(&c.useOldScript, "old", false, "Use the old script parser for processing the input script.")
-	flag.Var(&c.pluginPaths, "p", "Plugins to load for additional functionality")
+	flag.Var(&c.pluginPaths, "p", "Plugins to load for additional functionality. Plugin processes offering steps over the network are given as "+remote.UrlScheme+"host:port or "+remote.TlsUrlScheme+"host:port")
+	flag.Var(&c.pluginDirs, "plugin-dir", "Directory that is scanned for plugins (files ending with "+plugin.PluginFileSuffix+"), which are loaded like plugins given with -p. "+
+		"Can be defined multiple times. The directory in the environment variable "+PluginDirEnv+" is scanned as well.")
+	flag.Var(&c.scriptParams, "param", "Parameter in the form key=value, used to resolve ${key} or ${key:default} placeholders in data sources, data sinks and step parameters of the script. "+
+		"Can be defined multiple times. Environment variables are used for placeholders without a parameter. Unresolved placeholders without default are an error, $${key} is kept as ${key}.")
+	flag.IntVar(&c.monitoringPort, "monitoring-port", 0, "Serve health probes (/healthz, /readyz) and Prometheus metrics of the process and all pipeline steps (/metrics) over HTTP on the given port. Default: disabled.")
+	flag.StringVar(&c.pipelineName, "pipeline-name", "", "Name of the pipeline, added to the log entries of all pipeline steps.")
+	flag.StringVar(&c.tracingEndpoint, "tracing-endpoint", "", "Record a span for every batch and window, with a child span for every batch step, and send the spans to the given OTLP/HTTP URL, "+
+		"e.g. http://localhost:4318/v1/traces for Jaeger or Tempo. The trace context is forwarded to other processes in the headers of the binary format, which is used for TCP by default. Default: disabled.")
+	flag.StringVar(&c.tracingService, "tracing-service", "bitflow-pipeline", "Service name of the spans sent to -tracing-endpoint.")
+	flag.Float64Var(&c.tracingRatio, "tracing-ratio", 1, "Probability of starting a trace for a batch or window without a trace context, see -tracing-endpoint.")
+	flag.DurationVar(&bitflow.DrainTimeout, "drain-timeout", 0, "When shutting down on Ctrl-C or SIGTERM, wait at most this long for the pipeline to flush all queued samples. "+
+		"If the timeout expires, or the signal is received again, the process exits without flushing and with a non-zero exit code. Default: unlimited.")
+	flag.StringVar(&models.Repository, "model-repository", models.Repository, "Directory or HTTP base URL of the model repository, used by steps that store or load models through model://<name> locations.")
+	flag.Func("batch-memory", "Memory budget (MB) for the samples buffered by every batch step. Samples exceeding the budget are spilled to disk. Default: unlimited.", func(value string) error {
+		mb, err := strconv.ParseInt(value, 10, 64)
+		bitflow.DefaultBatchMemoryBudget = mb << 20
+		return err
+	})
+	flag.StringVar(&bitflow.BatchSpillDir, "batch-spill-dir", bitflow.BatchSpillDir, "Directory for samples spilled by batch steps, see -batch-memory. Default: the system temporary directory.")
 
 	c.ProcessorRegistry = reg.NewProcessorRegistry()
 	c.Endpoints.RegisterGeneralFlagsTo(flag.CommandLine)
@@ -43,14 +120,82 @@ func (c *CmdPipelineBuilder) RegisterFlags() {
 	}
 }
 
-func (c *CmdPipelineBuilder) BuildPipeline(script string) (*bitflow.SamplePipeline, error) {
-	err := load_plugins(c.ProcessorRegistry, c.pluginPaths)
+func (c *CmdPipelineBuilder) loadPlugins() error {
+	if !c.pluginsLoaded {
+		// Plugins must be loaded only once, since BuildPipeline() can be called again when reloading the script
+		pluginDirs := c.pluginDirs
+		if dir := os.Getenv(PluginDirEnv); dir != "" {
+			pluginDirs = append(pluginDirs, dir)
+		}
+		pluginPaths, err := discover_plugins(c.pluginPaths, pluginDirs)
+		if err != nil {
+			return err
+		}
+		if err := load_plugins(c.ProcessorRegistry, pluginPaths); err != nil {
+			return err
+		}
+		c.pluginsLoaded = true
+	}
+	return nil
+}
+
+// StartMonitoring starts a MonitoringServer in the background, if enabled through the -monitoring-port flag.
+func (c *CmdPipelineBuilder) StartMonitoring() {
+	if c.monitoringPort > 0 {
+		server := &MonitoringServer{Endpoint: ":" + strconv.Itoa(c.monitoringPort)}
+		server.Start()
+	}
+}
+
+// StartTracing installs an OpenTelemetry TracerProvider exporting spans through OTLP/HTTP,
+// if enabled through the -tracing-endpoint flag. See bitflow.TracerName for the recorded spans.
+func (c *CmdPipelineBuilder) StartTracing() {
+	if c.tracingEndpoint == "" || c.tracerProvider != nil {
+		return
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(c.tracingEndpoint))
 	if err != nil {
+		log.Errorln("Failed to start tracing:", err)
+		return
+	}
+	c.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.tracingRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", c.tracingService))))
+	otel.SetTracerProvider(c.tracerProvider)
+	log.Println("Sending traces to", c.tracingEndpoint)
+}
+
+// StopTracing sends all remaining spans, if tracing was started with StartTracing.
+func (c *CmdPipelineBuilder) StopTracing() {
+	if c.tracerProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := c.tracerProvider.Shutdown(ctx); err != nil {
+			log.Errorln("Failed to send remaining spans:", err)
+		}
+	}
+}
+
+// ServeLanguageServer runs a language server for Bitflow scripts on the standard input and output, see lsp.Server.
+func (c *CmdPipelineBuilder) ServeLanguageServer() error {
+	if err := c.loadPlugins(); err != nil {
+		return err
+	}
+	server := &lsp.Server{Registry: c.ProcessorRegistry}
+	return server.Serve(os.Stdin, os.Stdout)
+}
+
+func (c *CmdPipelineBuilder) BuildPipeline(script string) (*bitflow.SamplePipeline, error) {
+	if err := c.loadPlugins(); err != nil {
 		return nil, err
 	}
 	if c.printCapabilities {
 		return nil, c.PrintJsonCapabilities(os.Stdout)
 	}
+	if c.printSchema {
+		return nil, c.PrintJsonSchema(os.Stdout)
+	}
 	if c.printAnalyses {
 		fmt.Printf("Available analysis steps:\n%v\n", c.PrintAllAnalyses())
 		return nil, nil
@@ -61,10 +206,68 @@ func (c *CmdPipelineBuilder) BuildPipeline(script string) (*bitflow.SamplePipeli
 		log.Println("Running using Go-only script implementation")
 		make_pipeline = make_pipeline_old
 	}
-	return make_pipeline(c.ProcessorRegistry, script)
+	pipe, err := make_pipeline(c.ProcessorRegistry, script, c.scriptParams.Map())
+	if err != nil {
+		return nil, err
+	}
+	pipe.Name = c.pipelineName
+	if c.checkScript {
+		return nil, checkPipeline(pipe, c.checkFields)
+	}
+	if c.printGraph != "" {
+		return nil, graph.New(pipe).Write(os.Stdout, c.printGraph)
+	}
+	fields := []string(c.dryRunFields)
+	if len(fields) == 0 {
+		fields = []string{"a", "b", "c"}
+	}
+	if c.dryRun {
+		run := &DryRun{Samples: c.dryRunSamples, Fields: fields, Tags: c.dryRunTags.Map()}
+		return nil, run.Run(pipe)
+	}
+	if c.benchmark {
+		return nil, c.runBenchmark(pipe, fields)
+	}
+	if !c.formatScript {
+		return pipe, nil
+	}
+	formatted, err := preprocess.Format(script)
+	if err == nil {
+		fmt.Print(formatted)
+	}
+	return nil, err
+}
+
+func (c *CmdPipelineBuilder) runBenchmark(pipe *bitflow.SamplePipeline, fields []string) error {
+	bench := &Benchmark{
+		DryRun:   DryRun{Samples: c.benchmarkSamples, Fields: fields, Tags: c.dryRunTags.Map()},
+		Recorded: c.benchmarkRecorded,
+		Format:   c.benchmarkFormat,
+	}
+	if bench.Format != "json" && bench.Format != "csv" {
+		return fmt.Errorf("Unknown -benchmark-format '%v', must be json or csv", bench.Format)
+	}
+	if c.benchmarkOutput == "" {
+		return bench.Run(pipe, os.Stdout)
+	}
+	out, err := os.Create(c.benchmarkOutput)
+	if err != nil {
+		return err
+	}
+	err = bench.Run(pipe, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		log.Println("Benchmark report written to", c.benchmarkOutput)
+	}
+	return err
 }
 
 func (c *CmdPipelineBuilder) PrintPipeline(pipe *bitflow.SamplePipeline) *bitflow.SamplePipeline {
+	if pipe == nil {
+		return nil
+	}
 	for _, str := range pipe.FormatLines() {
 		log.Println(str)
 	}
@@ -74,6 +277,22 @@ func (c *CmdPipelineBuilder) PrintPipeline(pipe *bitflow.SamplePipeline) *bitflo
 	return pipe
 }
 
+func checkPipeline(pipe *bitflow.SamplePipeline, inputFields int) error {
+	issues := lint.Check(pipe, inputFields)
+	for _, issue := range issues {
+		if issue.Severity == lint.Error {
+			log.Errorln(issue)
+		} else {
+			log.Warnln(issue)
+		}
+	}
+	if issues.HasErrors() {
+		return fmt.Errorf("The pipeline check failed")
+	}
+	log.Printf("The pipeline check found %v warning(s)", len(issues))
+	return nil
+}
+
 func JSONMarshal(t interface{}) ([]byte, error) {
 	buffer := &bytes.Buffer{}
 	encoder := json.NewEncoder(buffer)
@@ -82,7 +301,11 @@ func JSONMarshal(t interface{}) ([]byte, error) {
 	return buffer.Bytes(), err
 }
 
-func make_pipeline_old(registry reg.ProcessorRegistry, scriptStr string) (*bitflow.SamplePipeline, error) {
+func make_pipeline_old(registry reg.ProcessorRegistry, scriptStr string, params map[string]string) (*bitflow.SamplePipeline, error) {
+	scriptStr, err := preprocess.Process(scriptStr, params)
+	if err != nil {
+		return nil, err
+	}
 	queryBuilder := script_go.PipelineBuilder{registry}
 	parser := script_go.NewParser(bytes.NewReader([]byte(scriptStr)))
 	pipe, err := parser.Parse()
@@ -92,15 +315,41 @@ func make_pipeline_old(registry reg.ProcessorRegistry, scriptStr string) (*bitfl
 	return queryBuilder.MakePipeline(pipe)
 }
 
-func make_pipeline_new(registry reg.ProcessorRegistry, scriptStr string) (*bitflow.SamplePipeline, error) {
-	s, err := (&script.BitflowScriptParser{Registry: registry}).ParseScript(scriptStr)
+func make_pipeline_new(registry reg.ProcessorRegistry, scriptStr string, params map[string]string) (*bitflow.SamplePipeline, error) {
+	s, err := (&script.BitflowScriptParser{Registry: registry, Parameters: params}).ParseScript(scriptStr)
 	return s, err.NilOrError()
 }
 
+// discover_plugins appends the plugins found in the given directories to the explicitly given plugins, skipping duplicates.
+func discover_plugins(pluginPaths []string, pluginDirs []string) ([]string, error) {
+	result := append([]string(nil), pluginPaths...)
+	known := make(map[string]bool)
+	for _, path := range pluginPaths {
+		known[filepath.Clean(path)] = true
+	}
+	for _, dir := range pluginDirs {
+		discovered, err := plugin.DiscoverPlugins(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan plugin directory: %v", err)
+		}
+		for _, path := range discovered {
+			if !known[filepath.Clean(path)] {
+				known[filepath.Clean(path)] = true
+				result = append(result, path)
+			}
+		}
+	}
+	return result, nil
+}
+
 func load_plugins(registry reg.ProcessorRegistry, pluginPaths []string) error {
 	loadedNames := make(map[string]bool)
 	for _, path := range pluginPaths {
-		if name, err := plugin.LoadPlugin(registry, path); err != nil {
+		load := plugin.LoadPlugin
+		if remote.IsPluginUrl(path) {
+			load = remote.RegisterPlugin
+		}
+		if name, err := load(registry, path); err != nil {
 			return fmt.Errorf("Failed to load plugin %v: %v", path, err)
 		} else {
 			loadedNames[name] = true
@@ -108,6 +357,5 @@ func load_plugins(registry reg.ProcessorRegistry, pluginPaths []string) error {
 	}
 
 	// Load the default pipeline steps
-	// TODO add a plugin discovery mechanism
 	return defaultPlugin.Plugin.Init(registry)
 }
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const reloadApiShutdownTimeout = 5 * time.Second
+
+// ReloadingPipeline runs a SamplePipeline and replaces it with a newly built pipeline when a reload is requested.
+// A reload can be triggered through the SIGHUP signal or through a REST API. The new pipeline is built and
+// validated before the running pipeline is stopped, without blocking the running pipeline. If building the new pipeline
+// fails, the old pipeline keeps running.
+// The old pipeline is drained by stopping its data source, which closes all subsequent processing steps in order.
+type ReloadingPipeline struct {
+	// Build is called for every reload and should return the new pipeline. The script parameter is non-empty,
+	// if the new script was provided through the REST API. Otherwise, the script should be re-read from its original location.
+	Build func(script string) (*bitflow.SamplePipeline, error)
+
+	// Tasks is optional and called before starting every pipeline. The returned tasks are started and stopped
+	// together with the pipeline.
+	Tasks func() []golib.Task
+
+	ReloadOnSignal bool
+	ReloadApi      string
+
+	lock    sync.Mutex
+	next    *bitflow.SamplePipeline
+	trigger golib.StopChan
+	signals chan os.Signal
+	server  *http.Server
+
+	// buildLock serializes concurrent reloads, without holding lock while the new pipeline is built
+	buildLock sync.Mutex
+}
+
+func (r *ReloadingPipeline) RegisterFlags() {
+	flag.BoolVar(&r.ReloadOnSignal, "reload-signal", false, "Reload the bitflow script when receiving the SIGHUP signal.")
+	flag.StringVar(&r.ReloadApi, "reload-api", "", "Serve a REST API on the given endpoint for reloading the bitflow script (POST "+RestApiPathPrefix+"/reload, optionally with a new script in the request body).")
+}
+
+// Enabled returns true, if at least one way of triggering a reload is configured.
+func (r *ReloadingPipeline) Enabled() bool {
+	return r.ReloadOnSignal || r.ReloadApi != ""
+}
+
+// Run starts the given pipeline and blocks until it finishes without a reload being triggered. The result is the
+// number of errors that occurred in the last executed pipeline. Afterwards, the signal handler and the REST API are stopped.
+func (r *ReloadingPipeline) Run(pipe *bitflow.SamplePipeline) int {
+	if r.ReloadOnSignal {
+		r.handleSignals()
+	}
+	if r.ReloadApi != "" {
+		r.serveApi()
+	}
+	defer r.stop()
+	for {
+		r.lock.Lock()
+		r.trigger = golib.NewStopChan()
+		trigger := r.trigger
+		r.lock.Unlock()
+
+		var tasks golib.TaskGroup
+		pipe.Construct(&tasks)
+		reloadTask := &golib.NoopTask{
+			Chan:        trigger,
+			Description: "pipeline reload",
+		}
+		tasks.Add(reloadTask)
+		if r.Tasks != nil {
+			tasks.Add(r.Tasks()...)
+		}
+		reason, numErrors := bitflow.WaitAndDrain(tasks)
+
+		r.lock.Lock()
+		next := r.next
+		r.next = nil
+		r.lock.Unlock()
+		if reason != reloadTask || next == nil {
+			log.Debugln("Stopped because of", reason)
+			return numErrors
+		}
+		log.Println("Old pipeline stopped with", numErrors, "error(s), starting reloaded pipeline")
+		bitflow.DefaultPipelineStatistics.Clear()
+		pipe = next
+	}
+}
+
+// Reload builds a new pipeline and, if successful, stops the running pipeline and starts the new one.
+func (r *ReloadingPipeline) Reload(script string) error {
+	r.buildLock.Lock()
+	defer r.buildLock.Unlock()
+	if !r.isRunning() {
+		return fmt.Errorf("No pipeline is running")
+	}
+	pipe, err := r.Build(script)
+	if err != nil {
+		return fmt.Errorf("Failed to build reloaded pipeline: %v", err)
+	}
+	if pipe == nil {
+		return fmt.Errorf("The reloaded script did not produce a pipeline")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// The pipeline might have finished while the new pipeline was built
+	if r.trigger.IsNil() || r.trigger.Stopped() {
+		return fmt.Errorf("No pipeline is running")
+	}
+	log.Println("Reloading pipeline")
+	for _, str := range pipe.FormatLines() {
+		log.Println(str)
+	}
+	r.next = pipe
+	r.trigger.Stop()
+	return nil
+}
+
+func (r *ReloadingPipeline) isRunning() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return !r.trigger.IsNil() && !r.trigger.Stopped()
+}
+
+// stop stops listening for signals and shuts down the REST API. Running reload requests are given
+// reloadApiShutdownTimeout to finish.
+func (r *ReloadingPipeline) stop() {
+	r.lock.Lock()
+	signals, server := r.signals, r.server
+	r.signals, r.server = nil, nil
+	r.lock.Unlock()
+	if signals != nil {
+		signal.Stop(signals)
+		close(signals)
+	}
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), reloadApiShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorln("Error stopping reload API:", err)
+		}
+	}
+}
+
+func (r *ReloadingPipeline) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	r.lock.Lock()
+	r.signals = signals
+	r.lock.Unlock()
+	go func() {
+		for range signals {
+			log.Println("Received SIGHUP, reloading bitflow script")
+			if err := r.Reload(""); err != nil {
+				log.Errorln(err)
+			}
+		}
+	}()
+}
+
+func (r *ReloadingPipeline) serveApi() {
+	router := mux.NewRouter()
+	router.HandleFunc(RestApiPathPrefix+"/reload", r.handleReloadRequest).Methods("POST", "PUT")
+	server := &http.Server{
+		Addr:    r.ReloadApi,
+		Handler: router,
+	}
+	r.lock.Lock()
+	r.server = server
+	r.lock.Unlock()
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Errorln("Reload API stopped:", err)
+		}
+	}()
+}
+
+func (r *ReloadingPipeline) handleReloadRequest(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err == nil {
+		err = r.Reload(string(body))
+	}
+	if err != nil {
+		log.Errorln(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+	} else {
+		w.Write([]byte("Pipeline reloaded\n"))
+	}
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type reloadTestSuite struct {
+	testsupport.Suite
+	lock   sync.Mutex
+	events []string
+}
+
+func TestReload(t *testing.T) {
+	suite.Run(t, new(reloadTestSuite))
+}
+
+func (s *reloadTestSuite) SetupTest() {
+	s.events = nil
+}
+
+func (s *reloadTestSuite) event(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, fmt.Sprintf(format, args...))
+}
+
+func (s *reloadTestSuite) recorded() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+func (s *reloadTestSuite) waitForEvent(event string) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		for _, recorded := range s.recorded() {
+			if recorded == event {
+				return
+			}
+		}
+	}
+	s.Fail("Missing event", "Event '%v' did not occur: %v", event, s.recorded())
+}
+
+// reloadTestSource sends one sample. Afterwards, it either finishes, or waits until it is closed.
+type reloadTestSource struct {
+	bitflow.AbstractSampleSource
+	name   string
+	finish bool
+	suite  *reloadTestSuite
+	stop   golib.StopChan
+}
+
+func (s *reloadTestSource) String() string {
+	return s.name + " source"
+}
+
+func (s *reloadTestSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.stop = golib.NewStopChan()
+	s.suite.event("%v started", s.name)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.GetSink().Sample(testsupport.NewSample(0, "", 1), &bitflow.Header{Fields: []string{"a"}})
+		if !s.finish {
+			s.stop.Wait()
+		}
+		s.CloseSinkParallel(wg)
+		s.stop.StopErr(err)
+	}()
+	return s.stop
+}
+
+func (s *reloadTestSource) Close() {
+	s.suite.event("%v closing", s.name)
+	s.stop.Stop()
+}
+
+// reloadTestSink records the received samples and the moment when it is closed
+type reloadTestSink struct {
+	bitflow.NoopProcessor
+	name  string
+	suite *reloadTestSuite
+}
+
+func (s *reloadTestSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.suite.event("%v sample", s.name)
+	return s.NoopProcessor.Sample(sample, header)
+}
+
+func (s *reloadTestSink) Close() {
+	// Delay closing to make sure the reloaded pipeline waits for the old one
+	time.Sleep(20 * time.Millisecond)
+	s.suite.event("%v drained", s.name)
+	s.NoopProcessor.Close()
+}
+
+func (s *reloadTestSuite) pipeline(name string, finish bool) *bitflow.SamplePipeline {
+	pipe := &bitflow.SamplePipeline{Source: &reloadTestSource{name: name, finish: finish, suite: s}}
+	pipe.Add(&reloadTestSink{name: name, suite: s})
+	return pipe
+}
+
+func (s *reloadTestSuite) run(reloader *ReloadingPipeline, pipe *bitflow.SamplePipeline) golib.StopChan {
+	finished := golib.NewStopChan()
+	go func() {
+		numErrors := reloader.Run(pipe)
+		if numErrors > 0 {
+			finished.StopErr(fmt.Errorf("%v error(s)", numErrors))
+		} else {
+			finished.Stop()
+		}
+	}()
+	return finished
+}
+
+func (s *reloadTestSuite) TestBuildFailureKeepsPipeline() {
+	reloader := &ReloadingPipeline{
+		Build: func(script string) (*bitflow.SamplePipeline, error) {
+			return nil, errors.New("invalid script")
+		},
+	}
+	finished := s.run(reloader, s.pipeline("old", false))
+	s.waitForEvent("old sample")
+
+	s.EqualError(reloader.Reload("x"), "Failed to build reloaded pipeline: invalid script")
+	s.True(finished.WaitTimeout(50*time.Millisecond), "The old pipeline must keep running")
+	s.Equal([]string{"old started", "old sample"}, s.recorded())
+
+	reloader.Build = func(script string) (*bitflow.SamplePipeline, error) {
+		return nil, nil
+	}
+	s.Error(reloader.Reload("x"), "An empty pipeline must not replace the running pipeline")
+	s.False(finished.Stopped())
+
+	// Stop the pipeline without a reload
+	reloader.lock.Lock()
+	reloader.trigger.Stop()
+	reloader.lock.Unlock()
+	s.False(finished.WaitTimeout(5 * time.Second))
+	s.NoError(finished.Err())
+}
+
+func (s *reloadTestSuite) TestDrainBeforeSwap() {
+	var scripts []string
+	reloader := &ReloadingPipeline{
+		Build: func(script string) (*bitflow.SamplePipeline, error) {
+			scripts = append(scripts, script)
+			return s.pipeline("new", true), nil
+		},
+	}
+	finished := s.run(reloader, s.pipeline("old", false))
+	s.waitForEvent("old sample")
+
+	s.NoError(reloader.Reload("new script"))
+	s.False(finished.WaitTimeout(5*time.Second), "The reloaded pipeline must finish")
+	s.NoError(finished.Err())
+	s.Equal([]string{"new script"}, scripts)
+	events := s.recorded()
+	s.Equal([]string{"old started", "old sample", "old closing", "old drained", "new started", "new sample"}, events[:6],
+		"The old pipeline must be drained before the new pipeline starts")
+	s.Contains(events[6:], "new drained")
+	s.Error(reloader.Reload(""), "No pipeline is running anymore")
+}
+
+func (s *reloadTestSuite) TestBuildDoesNotBlockPipeline() {
+	building := golib.NewStopChan()
+	release := golib.NewStopChan()
+	reloader := &ReloadingPipeline{
+		Build: func(script string) (*bitflow.SamplePipeline, error) {
+			building.Stop()
+			release.Wait()
+			return s.pipeline("new", true), nil
+		},
+	}
+	finished := s.run(reloader, s.pipeline("old", false))
+	s.waitForEvent("old sample")
+
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- reloader.Reload("")
+	}()
+	building.Wait()
+	// The running pipeline can finish while the new pipeline is being built
+	reloader.lock.Lock()
+	reloader.trigger.Stop()
+	reloader.lock.Unlock()
+	s.False(finished.WaitTimeout(5*time.Second), "The pipeline must not wait for the build")
+	release.Stop()
+	s.EqualError(<-reloaded, "No pipeline is running")
+}
+
+func (s *reloadTestSuite) TestApiShutdown() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.NoError(err)
+	endpoint := listener.Addr().String()
+	s.NoError(listener.Close())
+
+	reloader := &ReloadingPipeline{
+		ReloadApi: endpoint,
+		Build: func(script string) (*bitflow.SamplePipeline, error) {
+			return s.pipeline("new", true), nil
+		},
+	}
+	finished := s.run(reloader, s.pipeline("old", false))
+	s.waitForEvent("old sample")
+	url := "http://" + endpoint + RestApiPathPrefix + "/reload"
+	var resp *http.Response
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		if resp, err = http.Post(url, "text/plain", strings.NewReader("")); err == nil {
+			break
+		}
+	}
+	s.NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.NoError(resp.Body.Close())
+
+	s.False(finished.WaitTimeout(5*time.Second), "The reloaded pipeline must finish")
+	_, err = http.Post(url, "text/plain", strings.NewReader(""))
+	s.Error(err, "The reload API must be shut down after the pipeline finished")
+}
@@ -0,0 +1,239 @@
+// Package graph converts pipelines to graphs of their data sources, processing steps and sub-pipelines,
+// and renders them in the Graphviz DOT or Mermaid format.
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+)
+
+type NodeKind string
+
+const (
+	SourceNode = NodeKind("source")
+	StepNode   = NodeKind("step")
+	ForkNode   = NodeKind("fork")
+	OutputNode = NodeKind("output")
+	MergeNode  = NodeKind("merge")
+)
+
+type Node struct {
+	ID    string
+	Label string
+	Kind  NodeKind
+}
+
+// Edge connects two nodes. The Label is set for edges leading into sub-pipelines.
+type Edge struct {
+	From, To string
+	Label    string
+}
+
+// Graph is the data flow of a pipeline. Forks are nodes with one outgoing edge per sub-pipeline. The last steps of all
+// sub-pipelines are connected to a merge node, because the fork forwards the outputs of all sub-pipelines to the next step.
+// Multiple data inputs are connected to a merge node in the same way.
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+}
+
+// New creates the graph of the given pipeline.
+func New(pipe *bitflow.SamplePipeline) *Graph {
+	g := new(Graph)
+	g.addPipeline(pipe, nil, "")
+	return g
+}
+
+func (g *Graph) addNode(label string, kind NodeKind) string {
+	id := fmt.Sprintf("n%v", len(g.Nodes))
+	g.Nodes = append(g.Nodes, Node{ID: id, Label: label, Kind: kind})
+	return id
+}
+
+func (g *Graph) connect(from []string, to string, label string) {
+	for _, id := range from {
+		g.Edges = append(g.Edges, Edge{From: id, To: to, Label: label})
+	}
+}
+
+// addPipeline adds the nodes of the pipeline and connects them to the given predecessors. The edge to the first node
+// receives the given label. The result contains the IDs of the last nodes of the pipeline.
+func (g *Graph) addPipeline(pipe *bitflow.SamplePipeline, predecessors []string, label string) []string {
+	if source := pipe.Source; source != nil {
+		predecessors = g.addSource(source, predecessors, label)
+		label = ""
+	}
+	for _, proc := range pipe.Processors {
+		predecessors = g.addProcessor(proc, predecessors, label)
+		label = ""
+	}
+	return predecessors
+}
+
+func (g *Graph) addSource(source bitflow.SampleSource, predecessors []string, label string) []string {
+	if subpipes := subpipelines(source); len(subpipes) > 0 {
+		var ends []string
+		for _, sub := range subpipes {
+			ends = append(ends, g.addPipeline(sub.pipe, nil, "")...)
+		}
+		merge := g.addNode(source.String(), MergeNode)
+		g.connect(ends, merge, "")
+		return []string{merge}
+	}
+	id := g.addNode(source.String(), SourceNode)
+	g.connect(predecessors, id, label)
+	return []string{id}
+}
+
+func (g *Graph) addProcessor(proc bitflow.SampleProcessor, predecessors []string, label string) []string {
+	subpipes := subpipelines(proc)
+	if len(subpipes) == 0 {
+		kind := StepNode
+		if isOutput(proc) {
+			kind = OutputNode
+		}
+		id := g.addNode(describe(proc), kind)
+		g.connect(predecessors, id, label)
+		return []string{id}
+	}
+
+	forkID := g.addNode(proc.String(), ForkNode)
+	g.connect(predecessors, forkID, label)
+	var ends []string
+	for _, sub := range subpipes {
+		subEnds := g.addPipeline(sub.pipe, []string{forkID}, sub.title)
+		if len(subEnds) == 1 && subEnds[0] == forkID {
+			// Empty sub-pipeline: the samples are forwarded directly
+			subEnds = nil
+		}
+		ends = append(ends, subEnds...)
+	}
+	if len(ends) == 0 {
+		return []string{forkID}
+	}
+	merge := g.addNode("merge", MergeNode)
+	g.connect(ends, merge, "")
+	return []string{merge}
+}
+
+type titledPipeline struct {
+	title string
+	pipe  *bitflow.SamplePipeline
+}
+
+// subpipelines returns the pipelines contained in forks and multi-input sources.
+func subpipelines(obj interface{}) []titledPipeline {
+	container, ok := obj.(bitflow.StringerContainer)
+	if !ok {
+		return nil
+	}
+	var res []titledPipeline
+	for _, part := range container.ContainedStringers() {
+		switch part := part.(type) {
+		case *bitflow.TitledSamplePipeline:
+			res = append(res, titledPipeline{title: part.Title, pipe: part.SamplePipeline})
+		case *bitflow.SamplePipeline:
+			res = append(res, titledPipeline{pipe: part})
+		}
+	}
+	return res
+}
+
+// describe returns the string representation of the processor. The contained steps of windows and
+// batch processors are listed on separate lines.
+func describe(proc bitflow.SampleProcessor) string {
+	label := proc.String()
+	if container, ok := proc.(bitflow.StringerContainer); ok {
+		for _, part := range container.ContainedStringers() {
+			label += "\n" + part.String()
+		}
+	}
+	return label
+}
+
+func isOutput(proc bitflow.SampleProcessor) bool {
+	switch proc.(type) {
+	case bitflow.MarshallingSampleOutput, *bitflow.ConsoleBoxSink:
+		return true
+	}
+	return false
+}
+
+var dotShapes = map[NodeKind]string{
+	SourceNode: "cylinder",
+	StepNode:   "box",
+	ForkNode:   "diamond",
+	OutputNode: "cylinder",
+	MergeNode:  "circle",
+}
+
+// WriteDot renders the graph in the Graphviz DOT format.
+func (g *Graph) WriteDot(out io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n\trankdir=LR;\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&b, "\t%v [label=%v, shape=%v];\n", node.ID, dotString(node.Label), dotShapes[node.Kind])
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "\t%v -> %v", edge.From, edge.To)
+		if edge.Label != "" {
+			fmt.Fprintf(&b, " [label=%v]", dotString(edge.Label))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func dotString(str string) string {
+	str = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(str)
+	return `"` + str + `"`
+}
+
+var mermaidShapes = map[NodeKind][2]string{
+	SourceNode: {"[(", ")]"},
+	StepNode:   {"[", "]"},
+	ForkNode:   {"{", "}"},
+	OutputNode: {"[(", ")]"},
+	MergeNode:  {"((", "))"},
+}
+
+// WriteMermaid renders the graph as a Mermaid flowchart.
+func (g *Graph) WriteMermaid(out io.Writer) error {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, node := range g.Nodes {
+		shape := mermaidShapes[node.Kind]
+		fmt.Fprintf(&b, "    %v%v%v%v\n", node.ID, shape[0], mermaidString(node.Label), shape[1])
+	}
+	for _, edge := range g.Edges {
+		if edge.Label != "" {
+			fmt.Fprintf(&b, "    %v -->|%v| %v\n", edge.From, mermaidString(edge.Label), edge.To)
+		} else {
+			fmt.Fprintf(&b, "    %v --> %v\n", edge.From, edge.To)
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func mermaidString(str string) string {
+	str = strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace(str)
+	return `"` + str + `"`
+}
+
+// Write renders the graph in the given format, which can be "dot" or "mermaid".
+func (g *Graph) Write(out io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDot(out)
+	case "mermaid":
+		return g.WriteMermaid(out)
+	default:
+		return fmt.Errorf("Unknown graph format '%v', must be 'dot' or 'mermaid'", format)
+	}
+}
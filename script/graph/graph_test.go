@@ -0,0 +1,85 @@
+package graph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+	"github.com/stretchr/testify/assert"
+)
+
+func step(name string) *bitflow.SimpleProcessor {
+	return &bitflow.SimpleProcessor{Description: name}
+}
+
+func testPipeline() *bitflow.SamplePipeline {
+	pipe := &bitflow.SamplePipeline{Source: &bitflow.FileSource{FileNames: []string{"in"}}}
+	return pipe.
+		Add(&fork.SampleFork{Distributor: &fork.MultiplexDistributor{
+			PipelineArray: fork.PipelineArray{Subpipelines: []*bitflow.SamplePipeline{
+				new(bitflow.SamplePipeline).Add(step("a")),
+				new(bitflow.SamplePipeline).Add(step("b")).Add(step("c")),
+			}},
+		}}).
+		Add(&bitflow.FileSink{Filename: "out"})
+}
+
+func TestNew(t *testing.T) {
+	g := New(testPipeline())
+	assert.Equal(t, []Node{
+		{"n0", "FileSource(in)", SourceNode},
+		{"n1", "Fork multiplex (2)", ForkNode},
+		{"n2", "a", StepNode},
+		{"n3", "b", StepNode},
+		{"n4", "c", StepNode},
+		{"n5", "merge", MergeNode},
+		{"n6", "FileSink(out)", OutputNode},
+	}, g.Nodes)
+	assert.Equal(t, []Edge{
+		{"n0", "n1", ""},
+		{"n1", "n2", "Pipeline 0"},
+		{"n1", "n3", "Pipeline 1"},
+		{"n3", "n4", ""},
+		{"n2", "n5", ""},
+		{"n4", "n5", ""},
+		{"n5", "n6", ""},
+	}, g.Edges)
+}
+
+func TestNew_emptySubpipeline(t *testing.T) {
+	pipe := new(bitflow.SamplePipeline).
+		Add(&fork.SampleFork{Distributor: &fork.MultiplexDistributor{
+			PipelineArray: fork.PipelineArray{Subpipelines: []*bitflow.SamplePipeline{new(bitflow.SamplePipeline)}},
+		}}).
+		Add(step("a"))
+	g := New(pipe)
+	assert.Len(t, g.Nodes, 2)
+	assert.Equal(t, []Edge{{"n0", "n1", ""}}, g.Edges)
+}
+
+func TestWrite(t *testing.T) {
+	g := &Graph{
+		Nodes: []Node{{"n0", `say "hi"` + "\nnow", StepNode}, {"n1", "out", OutputNode}},
+		Edges: []Edge{{"n0", "n1", "x"}},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, g.Write(&buf, "dot"))
+	assert.Equal(t, `digraph pipeline {
+	rankdir=LR;
+	n0 [label="say \"hi\"\nnow", shape=box];
+	n1 [label="out", shape=cylinder];
+	n0 -> n1 [label="x"];
+}
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, g.Write(&buf, "mermaid"))
+	assert.Equal(t, `flowchart LR
+    n0["say #quot;hi#quot;<br/>now"]
+    n1[("out")]
+    n0 -->|"x"| n1
+`, buf.String())
+
+	assert.Error(t, g.Write(&buf, "svg"))
+}
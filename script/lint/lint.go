@@ -0,0 +1,145 @@
+// Package lint performs static checks on parsed pipelines, to find mistakes in scripts without running them.
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+)
+
+// DefaultInputFields is the assumed number of metrics in the input samples, used to derive the sample sizes in the pipeline.
+const DefaultInputFields = 10
+
+type Severity int
+
+const (
+	Warning = Severity(iota)
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Issue is a problem found in a pipeline. Path describes the location of the affected step, including the surrounding forks.
+type Issue struct {
+	Severity Severity
+	Path     string
+	Message  string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%v: %v: %v", i.Severity, i.Path, i.Message)
+}
+
+// Issues is the result of Check().
+type Issues []Issue
+
+// HasErrors returns true, if at least one of the issues has the Error severity.
+func (issues Issues) HasErrors() bool {
+	for _, issue := range issues {
+		if issue.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Check walks through the pipeline and all contained sub-pipelines and reports the following problems:
+//   - Forks without sub-pipelines
+//   - Outputs in the middle of a pipeline. Outputs forward samples, but this is often unintended. Steps after an output that
+//     does not forward samples are unreachable.
+//   - Multiple console box outputs, that would overwrite each other's screen
+//   - Steps that receive samples without metrics, based on the OutputSampleSize() method of the previous steps.
+//     The input samples are assumed to have inputFields metrics.
+//
+// Unknown steps and wrong parameters are reported when the pipeline is parsed, before Check() can be called.
+func Check(pipe *bitflow.SamplePipeline, inputFields int) Issues {
+	var c checker
+	c.checkPipeline(pipe, "Pipeline", inputFields)
+	if c.consoleBoxes > 1 {
+		c.add(Error, "Pipeline", "The console box output is used %v times, but only one can be displayed", c.consoleBoxes)
+	}
+	return c.issues
+}
+
+type checker struct {
+	consoleBoxes int
+	issues       Issues
+}
+
+func (c *checker) add(severity Severity, path string, format string, args ...interface{}) {
+	c.issues = append(c.issues, Issue{Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+type forwardingOutput interface {
+	ForwardsSamples() bool
+}
+
+func (c *checker) checkPipeline(pipe *bitflow.SamplePipeline, path string, numFields int) {
+	unreachable := ""
+	for i, proc := range pipe.Processors {
+		stepPath := fmt.Sprintf("%v > %v. %v", path, i+1, proc)
+		if unreachable != "" {
+			c.add(Error, stepPath, "Step is unreachable, because the previous output (%v) does not forward samples", unreachable)
+			break
+		}
+		if numFields <= 0 && !isOutput(proc) {
+			c.add(Error, stepPath, "Step receives samples without metrics")
+		}
+
+		if _, isConsoleBox := proc.(*bitflow.ConsoleBoxSink); isConsoleBox {
+			c.consoleBoxes++
+		}
+		if output, ok := proc.(forwardingOutput); ok && !output.ForwardsSamples() && i < len(pipe.Processors)-1 {
+			unreachable = proc.String()
+		} else if isOutput(proc) && i < len(pipe.Processors)-1 {
+			c.add(Warning, stepPath, "Output in the middle of the pipeline, samples are forwarded to %v more step(s)", len(pipe.Processors)-1-i)
+		}
+
+		if resizing, ok := proc.(bitflow.ResizingSampleProcessor); ok {
+			numFields = resizing.OutputSampleSize(numFields)
+		}
+		if f, ok := proc.(*fork.SampleFork); ok {
+			c.checkFork(f, stepPath, numFields)
+		}
+	}
+}
+
+func (c *checker) checkFork(f *fork.SampleFork, path string, numFields int) {
+	container, ok := f.Distributor.(bitflow.StringerContainer)
+	if !ok {
+		// The sub-pipelines are created dynamically and cannot be checked
+		return
+	}
+	subpipelines := 0
+	for _, part := range container.ContainedStringers() {
+		var pipe *bitflow.SamplePipeline
+		switch part := part.(type) {
+		case *bitflow.TitledSamplePipeline:
+			pipe = part.SamplePipeline
+		case *bitflow.SamplePipeline:
+			pipe = part
+		default:
+			continue
+		}
+		subpipelines++
+		c.checkPipeline(pipe, path+" > "+strings.TrimSpace(part.String()), numFields)
+	}
+	if subpipelines == 0 {
+		c.add(Error, path, "Fork has no sub-pipelines")
+	}
+}
+
+func isOutput(proc bitflow.SampleProcessor) bool {
+	switch proc.(type) {
+	case bitflow.MarshallingSampleOutput, *bitflow.ConsoleBoxSink:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+	"github.com/stretchr/testify/assert"
+)
+
+func step(name string) *bitflow.SimpleProcessor {
+	return &bitflow.SimpleProcessor{Description: name}
+}
+
+func TestCheck_valid(t *testing.T) {
+	pipe := new(bitflow.SamplePipeline).Add(step("a")).Add(&bitflow.FileSink{Filename: "out"})
+	assert.Empty(t, Check(pipe, DefaultInputFields))
+}
+
+func TestCheck_outputs(t *testing.T) {
+	silent := &bitflow.FileSink{Filename: "silent"}
+	silent.DontForwardSamples = true
+	pipe := new(bitflow.SamplePipeline).
+		Add(&bitflow.FileSink{Filename: "out"}).
+		Add(new(bitflow.ConsoleBoxSink)).
+		Add(silent).
+		Add(step("a")).
+		Add(new(bitflow.ConsoleBoxSink))
+	issues := Check(pipe, DefaultInputFields)
+	assert.Equal(t, Issues{
+		{Warning, "Pipeline > 1. FileSink(out)", "Output in the middle of the pipeline, samples are forwarded to 4 more step(s)"},
+		{Warning, "Pipeline > 2. ConsoleBoxSink", "Output in the middle of the pipeline, samples are forwarded to 3 more step(s)"},
+		{Error, "Pipeline > 4. a", "Step is unreachable, because the previous output (FileSink(silent)) does not forward samples"},
+	}, issues)
+	assert.True(t, issues.HasErrors())
+}
+
+func TestCheck_forksAndSampleSizes(t *testing.T) {
+	removeAll := step("remove all")
+	removeAll.OutputSampleSizeFunc = func(int) int { return 0 }
+	pipe := new(bitflow.SamplePipeline).Add(&fork.SampleFork{Distributor: &fork.MultiplexDistributor{
+		PipelineArray: fork.PipelineArray{Subpipelines: []*bitflow.SamplePipeline{
+			new(bitflow.SamplePipeline).Add(step("a")),
+			new(bitflow.SamplePipeline).Add(removeAll).Add(step("b")).Add(new(bitflow.ConsoleBoxSink)),
+			new(bitflow.SamplePipeline).Add(&fork.SampleFork{Distributor: new(fork.MultiplexDistributor)}),
+		}},
+	}})
+	issues := Check(pipe, DefaultInputFields)
+	assert.Equal(t, Issues{
+		{Error, "Pipeline > 1. Fork multiplex (3) > Pipeline 1 > 2. b", "Step receives samples without metrics"},
+		{Error, "Pipeline > 1. Fork multiplex (3) > Pipeline 2 > 1. Fork multiplex (0)", "Fork has no sub-pipelines"},
+	}, issues)
+}
@@ -0,0 +1,275 @@
+// Package lsp implements a language server for Bitflow scripts. It communicates through the Language Server Protocol
+// and offers diagnostics from the script parser, completion of step names and parameters, and documentation on hover.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/script/script"
+	log "github.com/sirupsen/logrus"
+)
+
+const diagnosticSource = "bitflow"
+
+// Server is a language server for Bitflow scripts. The steps and forks of the Registry are used for completion and
+// documentation, and every opened or changed document is parsed to report errors.
+type Server struct {
+	Registry reg.ProcessorRegistry
+
+	documents map[string]string
+	out       io.Writer
+}
+
+// Serve reads requests from in and writes responses and notifications to out, until the client sends the exit
+// notification or in is closed. Typically, in and out are the standard input and output of the process.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.documents = make(map[string]string)
+	s.out = out
+	reader := bufio.NewReader(in)
+	for {
+		msg, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) error {
+	result, rpcErr := s.dispatch(msg)
+	if msg.ID == nil {
+		// Notifications are not answered
+		if rpcErr != nil {
+			log.Warnf("Language server: failed to handle %v notification: %v", msg.Method, rpcErr.Message)
+		}
+		return nil
+	}
+	response := &message{ID: msg.ID, Error: rpcErr}
+	if rpcErr == nil {
+		if result == nil {
+			// The result must be present in successful responses
+			result = json.RawMessage("null")
+		}
+		response.Result = result
+	}
+	return writeMessage(s.out, response)
+}
+
+func (s *Server) dispatch(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": 1, // Full document content on every change
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"(", ",", ">"},
+				},
+				"hoverProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "bitflow-language-server"},
+		}, nil
+	case "initialized", "shutdown", "$/cancelRequest", "workspace/didChangeConfiguration", "textDocument/didSave":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return nil, s.updateDocument(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.updateDocument(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		if err := s.publishDiagnostics(params.TextDocument.URI, nil); err != nil {
+			return nil, &responseError{Message: err.Error()}
+		}
+		return nil, nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.complete(s.documents[params.TextDocument.URI], params.Position), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if result := s.hover(s.documents[params.TextDocument.URI], params.Position); result != nil {
+			return result, nil
+		}
+		return nil, nil
+	default:
+		return nil, &responseError{Code: errorMethodNotFound, Message: "Method not supported: " + msg.Method}
+	}
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: errorInvalidParams, Message: err.Error()}
+}
+
+func (s *Server) updateDocument(uri, text string) *responseError {
+	s.documents[uri] = text
+	if err := s.publishDiagnostics(uri, s.diagnose(text)); err != nil {
+		return &responseError{Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) publishDiagnostics(uri string, diagnostics []diagnostic) error {
+	if diagnostics == nil {
+		diagnostics = []diagnostic{}
+	}
+	params, err := json.Marshal(publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// diagnose parses the script and returns the errors as diagnostics. Errors without a position in the script
+// are reported at the beginning of the script.
+func (s *Server) diagnose(text string) []diagnostic {
+	parser := script.BitflowScriptParser{Registry: s.Registry, RecoverPanics: true}
+	_, errs := parser.ParseScript(text)
+	result := make([]diagnostic, 0, len(errs))
+	for _, err := range errs {
+		pos := textRange{End: position{Character: 1}}
+		if positioned, ok := err.(script.PositionedError); ok {
+			errPos := positioned.Position()
+			pos.Start = position{Line: errPos.Line - 1, Character: errPos.Column}
+			pos.End = position{Line: errPos.EndLine - 1, Character: errPos.EndColumn}
+		}
+		result = append(result, diagnostic{Range: pos, Severity: severityError, Source: diagnosticSource, Message: err.Error()})
+	}
+	return result
+}
+
+// complete offers the parameters of the surrounding step, if the position is inside a parameter list, and the names
+// of all steps and forks otherwise.
+func (s *Server) complete(text string, pos position) []completionItem {
+	before := text[:offset(text, pos)]
+	items := []completionItem{}
+	if stepName, ok := enclosingStep(before); ok {
+		for _, proc := range s.Registry.GetAvailableProcessors() {
+			if proc.Name != stepName {
+				continue
+			}
+			for _, param := range proc.RequiredParams {
+				items = append(items, completionItem{Label: param, Kind: completionKindProperty, Detail: "required parameter", InsertText: param + "="})
+			}
+			for _, param := range proc.OptionalParams {
+				items = append(items, completionItem{Label: param, Kind: completionKindProperty, Detail: "optional parameter", InsertText: param + "="})
+			}
+		}
+		return items
+	}
+	for _, proc := range s.Registry.GetAvailableProcessors() {
+		kind, detail := completionKindFunction, "processing step"
+		if proc.IsFork {
+			kind, detail = completionKindModule, "fork"
+		}
+		items = append(items, completionItem{Label: proc.Name, Kind: kind, Detail: detail, Documentation: proc.Description})
+	}
+	return items
+}
+
+// enclosingStep returns the name of the step, if the end of the text is inside the parameter list of that step.
+func enclosingStep(text string) (string, bool) {
+	depth := 0
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case ')':
+			depth++
+		case '(':
+			if depth == 0 {
+				name := strings.TrimRightFunc(text[:i], unicode.IsSpace)
+				start := strings.LastIndexFunc(name, func(r rune) bool { return !isNameChar(r) })
+				return name[start+1:], true
+			}
+			depth--
+		case '{', '}', ';':
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (s *Server) hover(text string, pos position) *hover {
+	word := wordAt(text, offset(text, pos))
+	if word == "" {
+		return nil
+	}
+	for _, proc := range s.Registry.GetAvailableProcessors() {
+		if proc.Name == word {
+			kind := "Processing step"
+			if proc.IsFork {
+				kind = "Fork"
+			}
+			return &hover{Contents: markupContent{
+				Kind:  "markdown",
+				Value: fmt.Sprintf("**%v** (%v)\n\n%v", proc.Name, kind, proc.Description),
+			}}
+		}
+	}
+	return nil
+}
+
+func wordAt(text string, offset int) string {
+	start := strings.LastIndexFunc(text[:offset], func(r rune) bool { return !isNameChar(r) }) + 1
+	end := strings.IndexFunc(text[offset:], func(r rune) bool { return !isNameChar(r) })
+	if end < 0 {
+		end = len(text)
+	} else {
+		end += offset
+	}
+	return text[start:end]
+}
+
+func isNameChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+// offset converts a position in the text to a byte offset. Characters are counted as runes, which is equal to the
+// UTF-16 code units used by the protocol, except for characters outside the Basic Multilingual Plane.
+func offset(text string, pos position) int {
+	lineStart := 0
+	for line := 0; line < pos.Line; line++ {
+		next := strings.IndexByte(text[lineStart:], '\n')
+		if next < 0 {
+			return len(text)
+		}
+		lineStart += next + 1
+	}
+	i := lineStart
+	for char := 0; char < pos.Character && i < len(text) && text[i] != '\n'; char++ {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return i
+}
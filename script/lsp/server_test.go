@@ -0,0 +1,90 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer() *Server {
+	registry := reg.NewProcessorRegistry()
+	registry.RegisterAnalysisParamsErr("avg",
+		func(pipeline *bitflow.SamplePipeline, params map[string]string) error {
+			return nil
+		}, "Average of the metrics", reg.RequiredParams("window"), reg.OptionalParams("tag"))
+	return &Server{Registry: registry}
+}
+
+func request(t *testing.T, buf *bytes.Buffer, id int, method string, params interface{}) {
+	paramBytes, err := json.Marshal(params)
+	assert.NoError(t, err)
+	msg := &message{Method: method, Params: paramBytes}
+	if id > 0 {
+		rawID := json.RawMessage(string(rune('0' + id)))
+		msg.ID = &rawID
+	}
+	assert.NoError(t, writeMessage(buf, msg))
+}
+
+func responses(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var result []map[string]interface{}
+	reader := bufio.NewReader(out)
+	for reader.Buffered() > 0 || out.Len() > 0 {
+		msg, err := readMessage(reader)
+		if !assert.NoError(t, err) {
+			break
+		}
+		var parsed map[string]interface{}
+		data, _ := json.Marshal(msg)
+		assert.NoError(t, json.Unmarshal(data, &parsed))
+		result = append(result, parsed)
+	}
+	return result
+}
+
+func TestServer(t *testing.T) {
+	var in, out bytes.Buffer
+	doc := map[string]interface{}{"uri": "file:///test.bf", "text": "in -> avg(tag=x) ->\n  unknown()"}
+	request(t, &in, 1, "initialize", map[string]interface{}{})
+	request(t, &in, 0, "textDocument/didOpen", map[string]interface{}{"textDocument": doc})
+	request(t, &in, 2, "textDocument/completion", map[string]interface{}{"textDocument": doc, "position": position{Line: 0, Character: 10}})
+	request(t, &in, 3, "textDocument/completion", map[string]interface{}{"textDocument": doc, "position": position{Line: 0, Character: 19}})
+	request(t, &in, 4, "textDocument/hover", map[string]interface{}{"textDocument": doc, "position": position{Line: 0, Character: 7}})
+	request(t, &in, 5, "unknown/method", nil)
+	request(t, &in, 0, "exit", nil)
+	assert.NoError(t, testServer().Serve(&in, &out))
+
+	res := responses(t, &out)
+	assert.Len(t, res, 6)
+	assert.Contains(t, res[0]["result"], "capabilities")
+
+	assert.Equal(t, "textDocument/publishDiagnostics", res[1]["method"])
+	diagnostics := res[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	assert.Len(t, diagnostics, 2)
+	assert.Contains(t, diagnostics[0].(map[string]interface{})["message"], "Missing required parameter 'window'")
+	diag := diagnostics[1].(map[string]interface{})
+	assert.Contains(t, diag["message"], "unknown: Unknown Processor")
+	assert.Equal(t, map[string]interface{}{
+		"start": map[string]interface{}{"line": 1.0, "character": 2.0},
+		"end":   map[string]interface{}{"line": 1.0, "character": 9.0},
+	}, diag["range"])
+
+	params := res[2]["result"].([]interface{})
+	assert.Len(t, params, 2)
+	assert.Equal(t, "window", params[0].(map[string]interface{})["label"])
+	assert.Equal(t, "tag=", params[1].(map[string]interface{})["insertText"])
+
+	steps := res[3]["result"].([]interface{})
+	assert.Len(t, steps, 2) // avg and the multiplex fork
+	assert.Equal(t, "avg", steps[0].(map[string]interface{})["label"])
+
+	hover := res[4]["result"].(map[string]interface{})["contents"].(map[string]interface{})
+	assert.Contains(t, hover["value"], "Average of the metrics")
+
+	assert.Equal(t, float64(errorMethodNotFound), res[5]["error"].(map[string]interface{})["code"])
+}
@@ -7,6 +7,14 @@
 #     mock://interval=200ms&offset=1h&error=10 -> ...
 #  2. A data processor, used like this. It will print incoming samples in the given frequency and produce an error after a fixed number of samples.
 #     ... -> mock(print=10, error=10) -> ...
+#  3. A data sink that drops all samples, but logs them in the given frequency.
+#     ... -> mock://print=10
+#  4. A marshalling format (identical to CSV), usable with all builtin transports.
+#     ... -> mockcsv+file://out.txt
+#  5. A fork that forwards every sample to a random subpipeline.
+#     ... -> mock(seed=1) { a -> ...; b -> ... }
+# Instead of using the -p switch, the plugin can also be copied into a directory that is scanned with -plugin-dir
+# or the BITFLOW_PLUGIN_DIR environment variable. Only files ending with .so are loaded from such directories.
 
 # Install the pipeline to make sure the plugin is built against an up-to-date binary
 echo "Building go-bitflow-pipeline..."
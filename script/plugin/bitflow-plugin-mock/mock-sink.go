@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
+)
+
+// MockSampleSink is a data sink that drops all samples, but logs every PrintModulo'th sample.
+type MockSampleSink struct {
+	bitflow.DroppingSampleProcessor
+	PrintModulo int
+
+	samplesReceived int
+}
+
+func (s *MockSampleSink) String() string {
+	return fmt.Sprintf("Mock sink (log every %v sample(s))", s.PrintModulo)
+}
+
+func (s *MockSampleSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if s.PrintModulo > 0 && s.samplesReceived%s.PrintModulo == 0 {
+		log.Printf("Mock sink received sample nr %v: %v", s.samplesReceived, sample.Values)
+	}
+	s.samplesReceived++
+	return nil
+}
+
+func (s *MockSampleSink) ParseParams(params map[string]string) error {
+	var err error
+	for key, value := range params {
+		if key != "print" {
+			return fmt.Errorf("Unexpected parameter: %v", key)
+		}
+		s.PrintModulo, err = strconv.Atoi(value)
+	}
+	return err
+}
@@ -11,7 +11,12 @@ import (
 
 const (
 	DataSourceType    = "mock"
+	DataSinkType      = "mock"
 	DataProcessorName = "mock"
+	ForkName          = "mock"
+
+	// MarshallingFormat must not collide with the endpoint types, because both are used in the schema part of endpoint URLs
+	MarshallingFormat = "mockcsv"
 )
 
 func main() {
@@ -42,8 +47,7 @@ func (*pluginImpl) Name() string {
 }
 
 func (p *pluginImpl) Init(registry reg.ProcessorRegistry) error {
-	plugin.LogPluginDataSource(p, DataSourceType)
-	registry.Endpoints.CustomDataSources[bitflow.EndpointType(DataSourceType)] = func(query string) (bitflow.SampleSource, error) {
+	err := plugin.RegisterDataSource(p, registry, DataSourceType, func(query string) (bitflow.SampleSource, error) {
 		params, err := plugin.ParseQueryParameters(query)
 		if err != nil {
 			return nil, err
@@ -51,6 +55,35 @@ func (p *pluginImpl) Init(registry reg.ProcessorRegistry) error {
 		generator := defaultDataSource
 		err = generator.ParseParams(params)
 		return &generator, err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = plugin.RegisterDataSink(p, registry, DataSinkType, func(query string) (bitflow.SampleProcessor, error) {
+		params, err := plugin.ParseQueryParameters(query)
+		if err != nil {
+			return nil, err
+		}
+		sink := new(MockSampleSink)
+		err = sink.ParseParams(params)
+		return sink, err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = plugin.RegisterMarshallingFormat(p, registry, MarshallingFormat,
+		func() bitflow.Marshaller { return bitflow.CsvMarshaller{} },
+		func() bitflow.Unmarshaller { return new(bitflow.CsvMarshaller) })
+	if err != nil {
+		return err
+	}
+
+	err = plugin.RegisterFork(p, registry, ForkName, NewRandomDistributor,
+		"Forward every sample to a random subpipeline", reg.OptionalParams("seed"))
+	if err != nil {
+		return err
 	}
 
 	plugin.LogPluginProcessor(p, DataProcessorName)
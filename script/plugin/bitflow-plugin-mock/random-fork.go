@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// RandomDistributor forwards every sample to one randomly selected subpipeline.
+type RandomDistributor struct {
+	Subpipelines []fork.Subpipeline
+	rnd          *rand.Rand
+}
+
+func NewRandomDistributor(subpipelines []reg.Subpipeline, params map[string]string) (fork.Distributor, error) {
+	var err error
+	seed := reg.IntParam(params, "seed", 1, true, &err)
+	if err != nil {
+		return nil, err
+	}
+	res := &RandomDistributor{rnd: rand.New(rand.NewSource(int64(seed)))}
+	for _, subpipeline := range subpipelines {
+		for _, key := range subpipeline.Keys() {
+			pipe, err := subpipeline.Build()
+			if err != nil {
+				return nil, err
+			}
+			res.Subpipelines = append(res.Subpipelines, fork.Subpipeline{Pipe: pipe, Key: key})
+		}
+	}
+	if len(res.Subpipelines) == 0 {
+		return nil, fmt.Errorf("The %v fork requires at least one subpipeline", DataProcessorName)
+	}
+	return res, nil
+}
+
+func (d *RandomDistributor) Distribute(_ *bitflow.Sample, _ *bitflow.Header) ([]fork.Subpipeline, error) {
+	index := d.rnd.Intn(len(d.Subpipelines))
+	return d.Subpipelines[index : index+1], nil
+}
+
+func (d *RandomDistributor) String() string {
+	return fmt.Sprintf("Mock fork (random distribution to %v subpipeline(s))", len(d.Subpipelines))
+}
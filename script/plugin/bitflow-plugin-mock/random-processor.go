@@ -2,11 +2,11 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"strconv"
 
 	"github.com/antongulenko/golib"
 	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
 )
 
 type MockSampleProcessor struct {
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -50,3 +51,66 @@ func LogPluginDataSource(p BitflowPlugin, sourceName bitflow.EndpointType) {
 func LogPluginProcessor(p BitflowPlugin, stepName string) {
 	log.Debugf("Plugin %v: Registering processing step '%v'", p.Name(), stepName)
 }
+
+func LogPluginDataSink(p BitflowPlugin, sinkName bitflow.EndpointType) {
+	log.Debugf("Plugin %v: Registering data sink '%v'", p.Name(), sinkName)
+}
+
+func LogPluginMarshallingFormat(p BitflowPlugin, format bitflow.MarshallingFormat) {
+	log.Debugf("Plugin %v: Registering marshalling format '%v'", p.Name(), format)
+}
+
+func LogPluginFork(p BitflowPlugin, forkName string) {
+	log.Debugf("Plugin %v: Registering fork '%v'", p.Name(), forkName)
+}
+
+// RegisterDataSource registers a custom data source, which is used for input endpoints like sourceType://target.
+// The factory receives the target part of the endpoint. An error is returned if the type is already registered.
+func RegisterDataSource(p BitflowPlugin, registry reg.ProcessorRegistry, sourceType bitflow.EndpointType, factory func(string) (bitflow.SampleSource, error)) error {
+	if _, ok := registry.Endpoints.CustomDataSources[sourceType]; ok {
+		return fmt.Errorf("Plugin %v: Data source '%v' is already registered", p.Name(), sourceType)
+	}
+	LogPluginDataSource(p, sourceType)
+	registry.Endpoints.CustomDataSources[sourceType] = factory
+	return nil
+}
+
+// RegisterDataSink registers a custom data sink, which is used for output endpoints like sinkType://target.
+// The factory receives the target part of the endpoint. An error is returned if the type is already registered.
+func RegisterDataSink(p BitflowPlugin, registry reg.ProcessorRegistry, sinkType bitflow.EndpointType, factory func(string) (bitflow.SampleProcessor, error)) error {
+	if _, ok := registry.Endpoints.CustomDataSinks[sinkType]; ok {
+		return fmt.Errorf("Plugin %v: Data sink '%v' is already registered", p.Name(), sinkType)
+	}
+	LogPluginDataSink(p, sinkType)
+	registry.Endpoints.CustomDataSinks[sinkType] = factory
+	return nil
+}
+
+// RegisterMarshallingFormat registers a custom marshalling format, which can be used with all builtin transports,
+// e.g. format+file://data.txt. One of the marshaller and unmarshaller factories can be nil, if the format
+// is only supported for output or input, respectively. An error is returned if the format is already registered.
+func RegisterMarshallingFormat(p BitflowPlugin, registry reg.ProcessorRegistry, format bitflow.MarshallingFormat, marshaller func() bitflow.Marshaller, unmarshaller func() bitflow.Unmarshaller) error {
+	_, hasMarshaller := registry.Endpoints.Marshallers[format]
+	_, hasUnmarshaller := registry.Endpoints.Unmarshallers[format]
+	if hasMarshaller || hasUnmarshaller {
+		return fmt.Errorf("Plugin %v: Marshalling format '%v' is already registered", p.Name(), format)
+	}
+	LogPluginMarshallingFormat(p, format)
+	if marshaller != nil {
+		registry.Endpoints.Marshallers[format] = marshaller
+	}
+	if unmarshaller != nil {
+		registry.Endpoints.Unmarshallers[format] = unmarshaller
+	}
+	return nil
+}
+
+// RegisterFork registers a fork with a custom fork.Distributor. An error is returned if the name is already registered.
+func RegisterFork(p BitflowPlugin, registry reg.ProcessorRegistry, name string, createFork reg.ForkFunc, description string, options ...reg.Option) error {
+	if _, ok := registry.GetFork(name); ok {
+		return fmt.Errorf("Plugin %v: Fork '%v' is already registered", p.Name(), name)
+	}
+	LogPluginFork(p, name)
+	registry.RegisterFork(name, createFork, description, options...)
+	return nil
+}
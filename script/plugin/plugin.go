@@ -2,7 +2,10 @@ package plugin
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"plugin"
+	"strings"
 
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	log "github.com/sirupsen/logrus"
@@ -38,3 +41,23 @@ func LoadPluginSymbol(registry reg.ProcessorRegistry, path string, symbol string
 	log.Debugf("Initializing plugin '%v' loaded from symbol '%v' in %v...", p.Name(), symbol, path)
 	return p.Name(), p.Init(registry)
 }
+
+// PluginFileSuffix is the file name suffix of the plugins found by DiscoverPlugins.
+const PluginFileSuffix = ".so"
+
+// DiscoverPlugins returns the paths of all plugin files in the given directory, sorted by name. Subdirectories
+// and files without the PluginFileSuffix are ignored.
+func DiscoverPlugins(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), PluginFileSuffix) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	log.Debugf("Discovered %v plugin(s) in %v", len(paths), dir)
+	return paths, nil
+}
@@ -0,0 +1,205 @@
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// ProtocolVersion is sent to the plugin in the Describe call.
+const ProtocolVersion = "1"
+
+const (
+	// UrlScheme is the prefix of plugin paths that refer to a plugin process, e.g. grpc://localhost:7777.
+	UrlScheme = "grpc://"
+
+	// TlsUrlScheme is the prefix of plugin paths that refer to a plugin process serving gRPC over TLS.
+	// The certificate of the plugin is verified with the root certificates of the host.
+	TlsUrlScheme = "grpc+tls://"
+
+	DefaultDescribeTimeout = 10 * time.Second
+)
+
+// IsPluginUrl returns true, if the given plugin path refers to a plugin process instead of a Go plugin file.
+func IsPluginUrl(path string) bool {
+	return strings.HasPrefix(path, UrlScheme) || strings.HasPrefix(path, TlsUrlScheme)
+}
+
+// Client calls the StepPlugin gRPC service defined in plugin.proto. The connection is established on first use
+// and secured with TLS if the TLS field is set.
+type Client struct {
+	Address string
+	TLS     *tls.Config // Optional
+
+	lock sync.Mutex
+	conn *grpc.ClientConn
+	stub StepPluginClient
+}
+
+// NewClient creates a Client for the given plugin URL, see UrlScheme and TlsUrlScheme.
+func NewClient(pluginUrl string) (*Client, error) {
+	client := new(Client)
+	if strings.HasPrefix(pluginUrl, TlsUrlScheme) {
+		client.Address = strings.TrimPrefix(pluginUrl, TlsUrlScheme)
+		client.TLS = new(tls.Config)
+	} else if strings.HasPrefix(pluginUrl, UrlScheme) {
+		client.Address = strings.TrimPrefix(pluginUrl, UrlScheme)
+	} else {
+		return nil, fmt.Errorf("Plugin URL must start with %v or %v: %v", UrlScheme, TlsUrlScheme, pluginUrl)
+	}
+	if _, _, err := net.SplitHostPort(client.Address); err != nil {
+		return nil, fmt.Errorf("Invalid plugin address '%v': %v", client.Address, err)
+	}
+	return client, nil
+}
+
+func (c *Client) String() string {
+	if c.TLS != nil {
+		return TlsUrlScheme + c.Address
+	}
+	return UrlScheme + c.Address
+}
+
+func (c *Client) client() (StepPluginClient, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stub == nil {
+		creds := insecure.NewCredentials()
+		if c.TLS != nil {
+			creds = credentials.NewTLS(c.TLS)
+		}
+		conn, err := grpc.NewClient(c.Address, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.stub = conn, NewStepPluginClient(conn)
+	}
+	return c.stub, nil
+}
+
+// Close closes the connection to the plugin. The Client can be used again afterwards.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.stub = nil, nil
+	return err
+}
+
+// Describe queries the processing steps offered by the plugin.
+func (c *Client) Describe() (*DescribeResponse, error) {
+	stub, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDescribeTimeout)
+	defer cancel()
+	response, err := stub.Describe(ctx, &DescribeRequest{ProtocolVersion: ProtocolVersion})
+	return response, statusError(err)
+}
+
+// statusError returns the message of a gRPC status error without the status code prefix.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok {
+		return fmt.Errorf("Plugin error (%v): %v", s.Code(), s.Message())
+	}
+	return err
+}
+
+// ProcessStream is an open Process call. Samples are sent through Send() and the samples returned by the
+// plugin are passed to the handler function given to Client.Process().
+type ProcessStream struct {
+	stream grpc.BidiStreamingClient[ProcessRequest, Sample]
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Process opens a stream and sends the step configuration. The handler is called in a separate goroutine
+// for every sample received from the plugin. The stream is aborted when the handler returns an error.
+// If the stream cannot be opened, the error is returned and also reported by Wait().
+func (c *Client) Process(config *StepConfig, handler func(*Sample) error) (*ProcessStream, error) {
+	s := &ProcessStream{done: make(chan struct{})}
+	stub, err := c.client()
+	if err == nil {
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(context.Background())
+		s.stream, err = stub.Process(ctx)
+		if err == nil {
+			err = s.stream.Send(&ProcessRequest{Config: config})
+		}
+	}
+	if err != nil {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		s.stream = nil
+		s.err = statusError(err)
+		close(s.done)
+		return s, s.err
+	}
+	go func() {
+		defer close(s.done)
+		defer s.cancel()
+		s.err = s.receive(handler)
+	}()
+	return s, nil
+}
+
+func (s *ProcessStream) receive(handler func(*Sample) error) error {
+	for {
+		sample, err := s.stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return statusError(err)
+		}
+		if err := handler(sample); err != nil {
+			return err
+		}
+	}
+}
+
+// Send sends one sample to the plugin. If the stream was ended by the plugin, the error is reported by Wait().
+func (s *ProcessStream) Send(sample *Sample) error {
+	if s.stream == nil {
+		return s.err
+	}
+	err := s.stream.Send(&ProcessRequest{Sample: sample})
+	if err == io.EOF {
+		// The stream was ended by the plugin, the status is received in the receive goroutine
+		err = nil
+	}
+	return err
+}
+
+// CloseSend signals the plugin that no more samples will be sent. The plugin should then emit
+// the remaining samples and end the stream.
+func (s *ProcessStream) CloseSend() error {
+	if s.stream == nil {
+		return s.err
+	}
+	return s.stream.CloseSend()
+}
+
+// Wait blocks until the plugin ended the stream and returns the error that occurred during the call, if any.
+func (s *ProcessStream) Wait() error {
+	<-s.done
+	return s.err
+}
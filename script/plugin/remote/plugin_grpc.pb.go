@@ -0,0 +1,165 @@
+// Protocol between bitflow and external processing steps, see the documentation of package remote.
+// Regenerate the Go code with `go generate` after changing this file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: plugin.proto
+
+package remote
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	StepPlugin_Describe_FullMethodName = "/bitflow.plugin.StepPlugin/Describe"
+	StepPlugin_Process_FullMethodName  = "/bitflow.plugin.StepPlugin/Process"
+)
+
+// StepPluginClient is the client API for StepPlugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type StepPluginClient interface {
+	// Describe returns the processing steps offered by the plugin.
+	Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error)
+	// Process transforms a stream of samples. The first request contains only the step configuration,
+	// all following requests contain only samples. The plugin can emit any number of samples
+	// and ends the response stream after the request stream was closed. Errors are reported through the gRPC status.
+	Process(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ProcessRequest, Sample], error)
+}
+
+type stepPluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStepPluginClient(cc grpc.ClientConnInterface) StepPluginClient {
+	return &stepPluginClient{cc}
+}
+
+func (c *stepPluginClient) Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeResponse)
+	err := c.cc.Invoke(ctx, StepPlugin_Describe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stepPluginClient) Process(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ProcessRequest, Sample], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StepPlugin_ServiceDesc.Streams[0], StepPlugin_Process_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ProcessRequest, Sample]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StepPlugin_ProcessClient = grpc.BidiStreamingClient[ProcessRequest, Sample]
+
+// StepPluginServer is the server API for StepPlugin service.
+// All implementations must embed UnimplementedStepPluginServer
+// for forward compatibility.
+type StepPluginServer interface {
+	// Describe returns the processing steps offered by the plugin.
+	Describe(context.Context, *DescribeRequest) (*DescribeResponse, error)
+	// Process transforms a stream of samples. The first request contains only the step configuration,
+	// all following requests contain only samples. The plugin can emit any number of samples
+	// and ends the response stream after the request stream was closed. Errors are reported through the gRPC status.
+	Process(grpc.BidiStreamingServer[ProcessRequest, Sample]) error
+	mustEmbedUnimplementedStepPluginServer()
+}
+
+// UnimplementedStepPluginServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedStepPluginServer struct{}
+
+func (UnimplementedStepPluginServer) Describe(context.Context, *DescribeRequest) (*DescribeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Describe not implemented")
+}
+func (UnimplementedStepPluginServer) Process(grpc.BidiStreamingServer[ProcessRequest, Sample]) error {
+	return status.Error(codes.Unimplemented, "method Process not implemented")
+}
+func (UnimplementedStepPluginServer) mustEmbedUnimplementedStepPluginServer() {}
+func (UnimplementedStepPluginServer) testEmbeddedByValue()                    {}
+
+// UnsafeStepPluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StepPluginServer will
+// result in compilation errors.
+type UnsafeStepPluginServer interface {
+	mustEmbedUnimplementedStepPluginServer()
+}
+
+func RegisterStepPluginServer(s grpc.ServiceRegistrar, srv StepPluginServer) {
+	// If the following call panics, it indicates UnimplementedStepPluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&StepPlugin_ServiceDesc, srv)
+}
+
+func _StepPlugin_Describe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StepPluginServer).Describe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StepPlugin_Describe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StepPluginServer).Describe(ctx, req.(*DescribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StepPlugin_Process_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StepPluginServer).Process(&grpc.GenericServerStream[ProcessRequest, Sample]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StepPlugin_ProcessServer = grpc.BidiStreamingServer[ProcessRequest, Sample]
+
+// StepPlugin_ServiceDesc is the grpc.ServiceDesc for StepPlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StepPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bitflow.plugin.StepPlugin",
+	HandlerType: (*StepPluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Describe",
+			Handler:    _StepPlugin_Describe_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Process",
+			Handler:       _StepPlugin_Process_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "plugin.proto",
+}
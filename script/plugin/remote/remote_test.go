@@ -0,0 +1,254 @@
+package remote
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+type remoteTestSuite struct {
+	suite.Suite
+	server  *grpc.Server
+	address string
+}
+
+func TestRemoteSteps(t *testing.T) {
+	suite.Run(t, new(remoteTestSuite))
+}
+
+func (s *remoteTestSuite) SetupSuite() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.address = listener.Addr().String()
+	s.server = servePlugin(listener)
+}
+
+func (s *remoteTestSuite) TearDownSuite() {
+	s.server.Stop()
+}
+
+func (s *remoteTestSuite) TestRegisterPlugin() {
+	registry := reg.NewProcessorRegistry()
+	name, err := RegisterPlugin(registry, UrlScheme+s.address)
+	s.NoError(err)
+	s.Equal("test", name)
+	step, ok := registry.GetAnalysis("scale")
+	s.True(ok)
+	s.Error(step.Params.Verify(map[string]string{}))
+	s.NoError(step.Params.Verify(map[string]string{"factor": "2"}))
+
+	var pipe bitflow.SamplePipeline
+	s.NoError(step.Func(&pipe, map[string]string{"factor": "2"}))
+	s.Len(pipe.Processors, 1)
+	s.IsType(new(RemoteProcessor), pipe.Processors[0])
+}
+
+func (s *remoteTestSuite) TestProcess() {
+	out := s.process("3", [][]bitflow.Value{{1, 2}, {3, 4}, {5, 6}})
+	s.Len(out.samples, 3)
+	s.Equal([]bitflow.Value{3, 6}, out.samples[0].Values)
+	s.Equal([]bitflow.Value{15, 18}, out.samples[2].Values)
+	s.Equal("yes", out.samples[1].Tag("scaled"))
+	s.Equal("1", out.samples[1].Tag("index"))
+	s.Equal(time.Unix(1, 0), out.samples[1].Time)
+	s.Equal([]string{"a", "b"}, out.headers[2].Fields)
+	s.NoError(out.err)
+}
+
+func (s *remoteTestSuite) TestProcessError() {
+	out := s.process("invalid", nil)
+	s.Empty(out.samples)
+	s.Error(out.err)
+}
+
+func (s *remoteTestSuite) TestProcessConnectionError() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.NoError(listener.Close())
+	client := &Client{Address: listener.Addr().String()}
+	defer client.Close()
+	_, err = client.Describe()
+	s.Error(err)
+
+	out := s.processWith(client, "3", [][]bitflow.Value{{1, 2}})
+	s.Empty(out.samples)
+	s.Error(out.err)
+}
+
+func (s *remoteTestSuite) TestTls() {
+	cert, roots := s.certificate()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	server := servePlugin(listener, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	defer server.Stop()
+
+	client, err := NewClient(TlsUrlScheme + listener.Addr().String())
+	s.NoError(err)
+	client.TLS.RootCAs = roots
+	defer client.Close()
+	description, err := client.Describe()
+	s.NoError(err)
+	s.Equal("test", description.PluginName)
+
+	out := s.processWith(client, "2", [][]bitflow.Value{{1, 2}})
+	s.NoError(out.err)
+	s.Len(out.samples, 1)
+	s.Equal([]bitflow.Value{2, 4}, out.samples[0].Values)
+
+	// Without the root certificate, the certificate of the plugin is rejected
+	client, err = NewClient(TlsUrlScheme + listener.Addr().String())
+	s.NoError(err)
+	defer client.Close()
+	_, err = client.Describe()
+	s.Error(err)
+}
+
+func (s *remoteTestSuite) TestNewClient() {
+	client, err := NewClient("grpc://localhost:7777")
+	s.NoError(err)
+	s.Equal("localhost:7777", client.Address)
+	s.Nil(client.TLS)
+	s.Equal("grpc://localhost:7777", client.String())
+
+	client, err = NewClient("grpc+tls://localhost:7777")
+	s.NoError(err)
+	s.Equal("localhost:7777", client.Address)
+	s.NotNil(client.TLS)
+
+	_, err = NewClient("localhost:7777")
+	s.Error(err)
+	_, err = NewClient("grpc://localhost")
+	s.Error(err)
+	s.True(IsPluginUrl("grpc+tls://host:1"))
+	s.False(IsPluginUrl("/path/to/plugin.so"))
+}
+
+// certificate creates a self-signed certificate for 127.0.0.1 and a pool containing it.
+func (s *remoteTestSuite) certificate() (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test plugin"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	s.Require().NoError(err)
+	parsed, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+func (s *remoteTestSuite) process(factor string, values [][]bitflow.Value) *collectingSink {
+	client, err := NewClient(UrlScheme + s.address)
+	s.Require().NoError(err)
+	defer client.Close()
+	return s.processWith(client, factor, values)
+}
+
+func (s *remoteTestSuite) processWith(client *Client, factor string, values [][]bitflow.Value) *collectingSink {
+	proc := &RemoteProcessor{Client: client, Step: "scale", Params: map[string]string{"factor": factor}}
+	out := new(collectingSink)
+	proc.SetSink(out)
+	var wg sync.WaitGroup
+	stopper := proc.Start(&wg)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	for i, sampleValues := range values {
+		sample := &bitflow.Sample{Values: sampleValues, Time: time.Unix(int64(i), 0)}
+		sample.SetTag("index", strconv.Itoa(i))
+		_ = proc.Sample(sample, header) // Errors are also reported through the stopper
+	}
+	proc.Close()
+	wg.Wait()
+	s.True(out.closed)
+	out.err = stopper.Err()
+	return out
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+	closed  bool
+	err     error
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+func (s *collectingSink) Close() {
+	s.closed = true
+}
+
+// servePlugin implements a plugin with a step that multiplies all values with the 'factor' parameter.
+func servePlugin(listener net.Listener, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	RegisterStepPluginServer(server, new(testPlugin))
+	go server.Serve(listener)
+	return server
+}
+
+type testPlugin struct {
+	UnimplementedStepPluginServer
+}
+
+func (*testPlugin) Describe(context.Context, *DescribeRequest) (*DescribeResponse, error) {
+	return &DescribeResponse{PluginName: "test", Steps: []*StepDescription{
+		{Name: "scale", Description: "Multiply all values", RequiredParams: []string{"factor"}},
+	}}, nil
+}
+
+func (*testPlugin) Process(stream StepPlugin_ProcessServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	factor, err := strconv.ParseFloat(req.Config.GetParams()["factor"], 64)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sample := req.Sample
+		for i := range sample.Values {
+			sample.Values[i] *= factor
+		}
+		sample.Tags["scaled"] = "yes"
+		if err := stream.Send(sample); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package remote implements processing steps in external processes, which can be written in any language.
+// The plugin process serves the StepPlugin gRPC service defined in plugin.proto, optionally secured with TLS.
+//
+// Describe call: returns the names and parameters of the steps offered by the plugin.
+// This call is made once, when the plugin is registered.
+//
+// Process call: a bidirectional stream opened for every instance of a step in a pipeline. Bitflow sends a ProcessRequest
+// with the step Config, followed by one ProcessRequest per incoming Sample, and closes its side of the stream at the end of
+// the input. The plugin answers with any number of Samples, which are forwarded to the next processing step, and ends the call
+// after the input was closed. Errors are reported through the gRPC status of the call.
+package remote
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative plugin.proto
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegisterPlugin queries the processing steps offered by the plugin process at the given URL and
+// registers them in the registry. See UrlScheme and TlsUrlScheme for the URL format. The result is the name of the plugin.
+func RegisterPlugin(registry reg.ProcessorRegistry, pluginUrl string) (string, error) {
+	client, err := NewClient(pluginUrl)
+	if err != nil {
+		return "", err
+	}
+	description, err := client.Describe()
+	if err != nil {
+		return "", fmt.Errorf("Failed to query the steps of plugin %v: %v", client, err)
+	}
+	for _, step := range description.Steps {
+		step := step
+		log.Debugf("Plugin %v: Registering remote processing step '%v'", description.PluginName, step.Name)
+		registry.RegisterAnalysisParamsErr(step.Name, func(p *bitflow.SamplePipeline, params map[string]string) error {
+			p.Add(&RemoteProcessor{Client: client, Step: step.Name, Params: params})
+			return nil
+		}, step.Description, reg.RequiredParams(step.RequiredParams...), reg.OptionalParams(step.OptionalParams...))
+	}
+	return description.PluginName, nil
+}
+
+// RemoteProcessor sends all incoming samples to a step running in a plugin process and forwards the samples returned by
+// the plugin. The plugin can modify, drop or generate samples.
+type RemoteProcessor struct {
+	bitflow.NoopProcessor
+	Client *Client
+	Step   string
+	Params map[string]string
+
+	stream     *ProcessStream
+	sentHeader *bitflow.Header
+	outHeader  *bitflow.Header
+}
+
+func (p *RemoteProcessor) String() string {
+	return fmt.Sprintf("Remote step %v (plugin %v, params %v)", p.Step, p.Client, p.Params)
+}
+
+func (p *RemoteProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
+	stopper := p.NoopProcessor.Start(wg)
+	// A failed connection is also reported by Wait()
+	p.stream, _ = p.Client.Process(&StepConfig{Step: p.Step, Params: p.Params}, p.emit)
+	stream := p.stream
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := stream.Wait(); err != nil {
+			p.Error(fmt.Errorf("%v: %v", p, err))
+		}
+		p.CloseSink()
+	}()
+	return stopper
+}
+
+func (p *RemoteProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	msg := &Sample{
+		TimestampNanos: sample.Time.UnixNano(),
+		Values:         make([]float64, len(sample.Values)),
+		Tags:           sample.TagMap(),
+	}
+	for i, value := range sample.Values {
+		msg.Values[i] = float64(value)
+	}
+	if p.sentHeader == nil || (header != p.sentHeader && !header.Equals(p.sentHeader)) {
+		msg.Fields = header.Fields
+	}
+	p.sentHeader = header
+	return p.stream.Send(msg)
+}
+
+// emit is called for every sample returned by the plugin.
+func (p *RemoteProcessor) emit(msg *Sample) error {
+	if len(msg.Fields) > 0 {
+		p.outHeader = &bitflow.Header{Fields: msg.Fields}
+	} else if p.outHeader == nil {
+		return errors.New("The first sample returned by the plugin has no header fields")
+	}
+	if len(msg.Values) != len(p.outHeader.Fields) {
+		return fmt.Errorf("The plugin returned a sample with %v values, but the header has %v fields", len(msg.Values), len(p.outHeader.Fields))
+	}
+	sample := &bitflow.Sample{
+		Time:   time.Unix(0, msg.TimestampNanos),
+		Values: make([]bitflow.Value, len(msg.Values)),
+	}
+	for i, value := range msg.Values {
+		sample.Values[i] = bitflow.Value(value)
+	}
+	for key, value := range msg.Tags {
+		sample.SetTag(key, value)
+	}
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+// Close ends the stream of samples sent to the plugin. The subsequent step is closed after the plugin has
+// emitted the remaining samples and closed the stream.
+func (p *RemoteProcessor) Close() {
+	if err := p.stream.CloseSend(); err != nil {
+		p.Log().Warnf("Failed to close stream: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package preprocess
+
+import (
+	"strings"
+)
+
+// FormatIndent is used by Format() for every level of nested braces.
+const FormatIndent = "    "
+
+// Format prints a script in canonical form. Every sub-pipeline inside braces is on a separate line and indented by its depth.
+// Spaces around '->', '=' and ',' are normalized and parameter lists are printed on one line. Comments are preserved and
+// line breaks outside of parameter lists are kept, but at most one empty line in a row. A line break before or after '->'
+// results in an indented continuation line starting with '->'. Format only works on the tokens of the script,
+// it does not verify that the script is syntactically correct.
+func Format(script string) (string, error) {
+	tokens, err := scan(script)
+	if err != nil {
+		return "", err
+	}
+	f := formatter{previous: token{typ: tokSpace}}
+	for _, tok := range moveLineBreaksBeforeArrows(tokens) {
+		f.format(tok)
+	}
+	f.b.WriteString("\n")
+	return f.b.String(), nil
+}
+
+// moveLineBreaksBeforeArrows turns "a ->\n b" into "a\n -> b", so that continuation lines always start with '->'.
+func moveLineBreaksBeforeArrows(tokens []token) []token {
+	tokens = append([]token(nil), tokens...)
+	for i := 1; i < len(tokens)-1; i++ {
+		before, after := &tokens[i-1], &tokens[i+1]
+		if tokens[i].text == "->" && tokens[i].typ == tokSpecial && after.typ == tokSpace && strings.Contains(after.text, "\n") &&
+			!(before.typ == tokSpace && strings.Contains(before.text, "\n")) && before.typ != tokComment {
+			if before.typ == tokSpace {
+				before.text = after.text
+			} else {
+				tokens = append(tokens[:i], append([]token{{typ: tokSpace, text: after.text}}, tokens[i:]...)...)
+				i++
+				after = &tokens[i+1]
+			}
+			after.text = " "
+		}
+	}
+	return tokens
+}
+
+type formatter struct {
+	b          strings.Builder
+	indent     int      // Indentation of new lines
+	lineIndent int      // Indentation of the current line
+	braces     [][2]int // Indentation of new lines and of the current line at each open brace
+	parens     int      // Depth of () and [] brackets
+	newlines   int      // Pending line breaks before the next token, at most 2
+	space      bool
+	previous   token
+}
+
+func (f *formatter) format(tok token) {
+	switch tok.typ {
+	case tokSpace:
+		if n := strings.Count(tok.text, "\n"); n > 0 && f.parens == 0 {
+			f.newline(n)
+		} else {
+			f.space = true
+		}
+		return
+	case tokComment:
+		f.write(tok, true)
+		if !strings.HasPrefix(tok.text, "/*") {
+			f.newline(1)
+		}
+	case tokSpecial:
+		switch tok.text {
+		case "{":
+			f.write(tok, true)
+			f.braces = append(f.braces, [2]int{f.indent, f.lineIndent})
+			f.indent = f.lineIndent + 1
+			f.newline(1)
+		case "}":
+			var open [2]int
+			if len(f.braces) > 0 {
+				open = f.braces[len(f.braces)-1]
+				f.braces = f.braces[:len(f.braces)-1]
+			}
+			// The closing brace is aligned with the line of the opening brace
+			f.indent = open[1]
+			f.newline(1)
+			f.write(tok, false)
+			f.indent = open[0]
+		case "(", "[":
+			f.write(tok, false)
+			f.parens++
+		case ")", "]":
+			f.write(tok, false)
+			if f.parens > 0 {
+				f.parens--
+			}
+		case ";":
+			f.write(tok, false)
+			if f.parens == 0 {
+				f.newline(1)
+			}
+		case ",":
+			f.write(tok, false)
+		default: // "=" and "->"
+			f.write(tok, tok.text == "->" || f.parens == 0)
+		}
+	default:
+		f.write(tok, true)
+	}
+	f.space = false
+	f.previous = tok
+}
+
+func (f *formatter) newline(n int) {
+	if n > 2 {
+		n = 2
+	}
+	if n > f.newlines {
+		f.newlines = n
+	}
+}
+
+// write outputs the token, preceded by pending line breaks or a space.
+func (f *formatter) write(tok token, spaceBefore bool) {
+	if f.newlines > 0 && f.b.Len() > 0 {
+		f.b.WriteString(strings.Repeat("\n", f.newlines))
+		f.lineIndent = f.indent
+		if tok.text == "->" && tok.typ == tokSpecial {
+			f.lineIndent++
+		}
+		f.b.WriteString(strings.Repeat(FormatIndent, f.lineIndent))
+	} else if f.b.Len() > 0 && spaceBefore && f.spaceBetween(tok) {
+		f.b.WriteString(" ")
+	}
+	f.newlines = 0
+	f.b.WriteString(tok.text)
+}
+
+func (f *formatter) spaceBetween(tok token) bool {
+	prev := f.previous
+	switch prev.typ {
+	case tokText, tokQuoted:
+		if tok.typ == tokText || tok.typ == tokQuoted {
+			// Adjacent strings without whitespace in between might form a single string
+			return f.space
+		}
+	case tokSpecial:
+		switch prev.text {
+		case "(", "[":
+			return false
+		case "=":
+			return f.parens == 0
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package preprocess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFormat(t *testing.T, script, expected string) {
+	res, err := Format(script)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, res)
+	again, err := Format(res)
+	assert.NoError(t, err)
+	assert.Equal(t, res, again, "Formatting is not idempotent")
+}
+
+func TestFormat_spacing(t *testing.T) {
+	testFormat(t, "in->avg( a = 1,b='x y' )[hint=2]   ->  'out file'",
+		"in -> avg(a=1, b='x y')[hint=2] -> 'out file'\n")
+}
+
+func TestFormat_placeholders(t *testing.T) {
+	testFormat(t, "${in}->x( a=${a:1} )->out_${b}.csv", "${in} -> x(a=${a:1}) -> out_${b}.csv\n")
+}
+
+func TestFormat_forks(t *testing.T) {
+	testFormat(t, "in->fork_tag(tag=host){a->x();b->window(size=10s){y()}->z}->out",
+		`in -> fork_tag(tag=host) {
+    a -> x();
+    b -> window(size=10s) {
+        y()
+    } -> z
+} -> out
+`)
+	testFormat(t, "{ a -> b; c -> d } -> e", "{\n    a -> b;\n    c -> d\n} -> e\n")
+}
+
+func TestFormat_commentsAndLines(t *testing.T) {
+	testFormat(t, "  # header\ndef pre = avg() ->\n  standardize() # c\n\n\n\n/* block\n comment */ in   a\n->pre()->out",
+		`# header
+def pre = avg()
+    -> standardize() # c
+
+/* block
+ comment */ in a
+    -> pre() -> out
+`)
+}
@@ -0,0 +1,66 @@
+package preprocess
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var placeholderRegex = regexp.MustCompile(`\$?\$\{([a-zA-Z_][a-zA-Z0-9_.\-]*)(?::([^}]*))?\}`)
+
+// Interpolate replaces placeholders in the form ${name} or ${name:default} in the data sources, data sinks and parameter values
+// of the script. Step names, parameter names and comments are not changed. The value of a placeholder is taken from the given
+// parameters, from the environment variable of the same name, or from the default value, in that order. Placeholders that
+// cannot be resolved and have no default value are reported as error. Other parts of scripts use the same syntax, for example
+// the template parameter of the fork_tag_template step or the file names of data sinks: such placeholders must be escaped
+// as $${name}, which is replaced by ${name}.
+func Interpolate(script string, params map[string]string) (string, error) {
+	tokens, err := scan(script)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	var unresolved []string
+	for i, tok := range tokens {
+		if (tok.typ == tokText || tok.typ == tokQuoted) && !isName(tokens, i) {
+			tok.text = placeholderRegex.ReplaceAllStringFunc(tok.text, func(placeholder string) string {
+				value, ok := resolvePlaceholder(placeholder, params)
+				if !ok {
+					unresolved = append(unresolved, fmt.Sprintf("%v (line %v)", placeholder, tok.line))
+				}
+				return value
+			})
+		}
+		b.WriteString(tok.text)
+	}
+	if len(unresolved) > 0 {
+		return "", fmt.Errorf("Unresolved placeholders without default value: %v (use $${name} to keep a placeholder unchanged)",
+			strings.Join(unresolved, ", "))
+	}
+	return b.String(), nil
+}
+
+// isName returns true, if the token at index i is the name of a processing step or of a parameter
+func isName(tokens []token, i int) bool {
+	next := nextSignificant(tokens, i+1)
+	return next < len(tokens) && tokens[next].typ == tokSpecial && (tokens[next].text == "(" || tokens[next].text == "=")
+}
+
+func resolvePlaceholder(placeholder string, params map[string]string) (string, bool) {
+	if placeholder[1] == '$' {
+		return placeholder[1:], true
+	}
+	match := placeholderRegex.FindStringSubmatchIndex(placeholder)
+	name := placeholder[match[2]:match[3]]
+	if value, ok := params[name]; ok {
+		return value, true
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if match[4] >= 0 {
+		return placeholder[match[4]:match[5]], true
+	}
+	return placeholder, false
+}
@@ -0,0 +1,31 @@
+package preprocess
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolate(t *testing.T) {
+	assert.NoError(t, os.Setenv("BITFLOW_TEST_HOST", "env-host"))
+	defer os.Unsetenv("BITFLOW_TEST_HOST")
+	params := map[string]string{"port": "7777", "empty": ""}
+
+	testInterpolate(t, params, ":${port} -> x(a=${BITFLOW_TEST_HOST})", ":7777 -> x(a=env-host)")
+	testInterpolate(t, params, "x(a=${window:1s}, b=${empty:default}, c=${port:1})", "x(a=1s, b=, c=7777)")
+	testInterpolate(t, params, "in -> 'out ${port}.csv'[hint=${port}]", "in -> 'out 7777.csv'[hint=7777]")
+	testInterpolate(t, params, "fork_tag_template(template='$${host}') { * -> $${host}.csv }", "fork_tag_template(template='${host}') { * -> ${host}.csv }")
+	testInterpolate(t, params, "${port} -> ${port}(${port}=x) # ${port}", "7777 -> ${port}(${port}=x) # ${port}")
+
+	_, err := Interpolate("in ->\n x(a=${host}, b=${port}) -> ${out}", params)
+	assert.EqualError(t, err, "Unresolved placeholders without default value: ${host} (line 2), ${out} (line 2) (use $${name} to keep a placeholder unchanged)")
+	_, err = Interpolate("x(a='${port})", params)
+	assert.Error(t, err)
+}
+
+func testInterpolate(t *testing.T, params map[string]string, script, expected string) {
+	res, err := Interpolate(script, params)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, res)
+}
@@ -0,0 +1,153 @@
+package preprocess
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MacroKeyword starts the definition of a named sub-pipeline.
+const MacroKeyword = "def"
+
+type macro struct {
+	name string
+	line int
+	body []token
+}
+
+// ExpandMacros handles definitions of named sub-pipelines (macros) in the form
+//
+//	def preprocess = avg() -> standardize()
+//
+// A definition must be at the beginning of the script, or follow a semicolon or another definition.
+// It ends with a semicolon or at the end of the line, unless the line ends with '->' or the next line starts with '->'.
+// Afterwards, the macro can be used like a processing step without parameters: 'input -> preprocess() -> output'.
+// Macros can use other macros. The definitions are replaced by whitespace, so that the line numbers of the script are preserved.
+func ExpandMacros(script string) (string, error) {
+	tokens, err := scan(script)
+	if err != nil {
+		return "", err
+	}
+	macros := make(map[string]*macro)
+	var remaining []token
+	statementStart := true
+	for i := 0; i < len(tokens); {
+		tok := tokens[i]
+		if statementStart && tok.typ == tokText && tok.text == MacroKeyword {
+			if m, end, ok := parseMacro(tokens, i); ok {
+				if len(m.body) == 0 {
+					return "", fmt.Errorf("Line %v: Macro '%v' is empty", m.line, m.name)
+				}
+				if previous, exists := macros[m.name]; exists {
+					return "", fmt.Errorf("Line %v: Macro '%v' is already defined in line %v", m.line, m.name, previous.line)
+				}
+				macros[m.name] = m
+				remaining = append(remaining, token{typ: tokSpace, text: blank(tokens[i:end]), line: tok.line})
+				i = end
+				continue
+			}
+		}
+		switch tok.typ {
+		case tokSpace, tokComment:
+		case tokSpecial:
+			statementStart = tok.text == ";"
+		default:
+			statementStart = false
+		}
+		remaining = append(remaining, tok)
+		i++
+	}
+	if len(macros) == 0 {
+		return script, nil
+	}
+	return expandReferences(remaining, macros, nil)
+}
+
+// parseMacro parses a macro definition starting at the keyword at index start.
+// It returns the index of the first token after the definition.
+func parseMacro(tokens []token, start int) (*macro, int, bool) {
+	nameIndex := nextSignificant(tokens, start+1)
+	if nameIndex >= len(tokens) || tokens[nameIndex].typ != tokText {
+		return nil, 0, false
+	}
+	eqIndex := nextSignificant(tokens, nameIndex+1)
+	if eqIndex >= len(tokens) || tokens[eqIndex].text != "=" {
+		return nil, 0, false
+	}
+	m := &macro{name: tokens[nameIndex].text, line: tokens[nameIndex].line}
+
+	depth := 0
+	lastSignificant := ""
+	i := eqIndex + 1
+	for ; i < len(tokens); i++ {
+		tok := tokens[i]
+		if depth == 0 && tok.typ == tokSpecial && tok.text == ";" {
+			i++ // The semicolon is part of the definition
+			break
+		}
+		if depth == 0 && tok.typ == tokSpace && strings.Contains(tok.text, "\n") && lastSignificant != "->" {
+			next := nextSignificant(tokens, i)
+			if next >= len(tokens) || tokens[next].text != "->" {
+				break
+			}
+		}
+		switch tok.typ {
+		case tokComment, tokSpace:
+			// The body might be inserted in the middle of a line, so it must not contain comments or newlines
+			tok = token{typ: tokSpace, text: " ", line: tok.line}
+		case tokSpecial:
+			switch tok.text {
+			case "(", "{", "[":
+				depth++
+			case ")", "}", "]":
+				depth--
+			}
+		}
+		if tok.typ != tokSpace {
+			lastSignificant = tok.text
+		}
+		m.body = append(m.body, tok)
+	}
+	m.body = trimSpace(m.body)
+	return m, i, true
+}
+
+func trimSpace(tokens []token) []token {
+	for len(tokens) > 0 && tokens[0].typ == tokSpace {
+		tokens = tokens[1:]
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1].typ == tokSpace {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
+
+// expandReferences replaces all references to macros ('name()') with the macro bodies. The stack contains the macros
+// that are currently being expanded, to detect recursive definitions.
+func expandReferences(tokens []token, macros map[string]*macro, stack []string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if m, ok := macros[tok.text]; ok && tok.typ == tokText {
+			open := nextSignificant(tokens, i+1)
+			if open < len(tokens) && tokens[open].text == "(" {
+				closing := nextSignificant(tokens, open+1)
+				if closing < len(tokens) && tokens[closing].text == ")" {
+					for _, name := range stack {
+						if name == m.name {
+							return "", fmt.Errorf("Line %v: Macro '%v' is used recursively (%v -> %v)", tok.line, m.name, strings.Join(stack, " -> "), m.name)
+						}
+					}
+					body, err := expandReferences(m.body, macros, append(stack, m.name))
+					if err != nil {
+						return "", err
+					}
+					b.WriteString(body)
+					i = closing
+					continue
+				}
+			}
+		}
+		b.WriteString(tok.text)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,37 @@
+package preprocess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandMacros_noMacros(t *testing.T) {
+	script := "in -> avg() -> out # def x = y"
+	res, err := ExpandMacros(script)
+	assert.NoError(t, err)
+	assert.Equal(t, script, res)
+}
+
+func TestExpandMacros_simple(t *testing.T) {
+	res, err := ExpandMacros("def pre = avg() -> standardize()\nin -> pre() -> out")
+	assert.NoError(t, err)
+	assert.Equal(t, "                                \nin -> avg() -> standardize() -> out", res)
+}
+
+func TestExpandMacros_forkAndNested(t *testing.T) {
+	res, err := ExpandMacros("def a = x(p='a()') # comment\n -> y(); def b = a() -> z()\nin -> fork(){ 1: b() -> out1; 2: a() -> out2 }")
+	assert.NoError(t, err)
+	assert.Contains(t, res, "in -> fork(){ 1: x(p='a()')   -> y() -> z() -> out1; 2: x(p='a()')   -> y() -> out2 }")
+}
+
+func TestExpandMacros_errors(t *testing.T) {
+	_, err := ExpandMacros("def a = b()\ndef b = a()\nin -> a()")
+	assert.EqualError(t, err, "Line 2: Macro 'a' is used recursively (a -> b -> a)")
+	_, err = ExpandMacros("def a = b()\ndef a = c()")
+	assert.EqualError(t, err, "Line 2: Macro 'a' is already defined in line 1")
+	_, err = ExpandMacros("def a = \nin -> out")
+	assert.EqualError(t, err, "Line 1: Macro 'a' is empty")
+	_, err = ExpandMacros("in -> x(a='b)")
+	assert.EqualError(t, err, "Line 1: Missing closing ' quote")
+}
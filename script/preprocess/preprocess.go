@@ -0,0 +1,36 @@
+package preprocess
+
+import "strings"
+
+// Process applies all transformations of this package to the script: block comments are removed (see StripBlockComments()),
+// macros are expanded (see ExpandMacros()) and placeholders are replaced (see Interpolate()). Placeholders are replaced last,
+// so that placeholders inside comments are ignored, and values of placeholders like /*.csv are not taken for comments.
+func Process(script string, params map[string]string) (string, error) {
+	script, err := StripBlockComments(script)
+	if err == nil {
+		script, err = ExpandMacros(script)
+	}
+	if err != nil {
+		return "", err
+	}
+	return Interpolate(script, params)
+}
+
+// StripBlockComments replaces /* block comments */ with whitespace, but keeps the newlines inside the comments, so that
+// the line numbers of the script are preserved. A block comment is only recognized at the beginning of a token,
+// so that file names like /data/*/file.csv remain unchanged. Line comments starting with # are handled by the parsers.
+func StripBlockComments(script string) (string, error) {
+	tokens, err := scan(script)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, tok := range tokens {
+		if tok.typ == tokComment && strings.HasPrefix(tok.text, "/*") {
+			b.WriteString(blank([]token{tok}))
+		} else {
+			b.WriteString(tok.text)
+		}
+	}
+	return b.String(), nil
+}
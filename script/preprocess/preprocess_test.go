@@ -0,0 +1,27 @@
+package preprocess
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripBlockComments(t *testing.T) {
+	res, err := StripBlockComments("in /* x -> \n y */ -> /data/*/x.csv # /* not a block comment\n-> 'a/*b*/'")
+	assert.NoError(t, err)
+	assert.Equal(t, "in         \n      -> /data/*/x.csv # /* not a block comment\n-> 'a/*b*/'", res)
+
+	_, err = StripBlockComments("in -> \n/* x -> out")
+	assert.EqualError(t, err, "Line 2: Missing closing */ of block comment")
+}
+
+func TestProcess(t *testing.T) {
+	res, err := Process("def pre = /* ${x} */ avg() -> filter(expr=${expr:true})\nin -> pre() -> ${out}", map[string]string{"out": "out.csv"})
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat(" ", 55)+"\nin -> avg() -> filter(expr=true) -> out.csv", res)
+
+	res, err = Process("in -> ${out}", map[string]string{"out": "/*.csv"})
+	assert.NoError(t, err, "Placeholder values must not be parsed as comments")
+	assert.Equal(t, "in -> /*.csv", res)
+}
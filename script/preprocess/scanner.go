@@ -0,0 +1,132 @@
+// Package preprocess works on the text of Bitflow scripts. It contains transformations that are applied before parsing,
+// so that they are available to both script parsers, and a formatter for scripts.
+package preprocess
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokText    tokenType = iota // Unquoted string
+	tokQuoted                   // Surrounded by one of: " ' `
+	tokSpace                    // Whitespace, including newlines
+	tokComment                  // From # to the end of the line, or from /* to */
+	tokSpecial                  // One of the special characters, or ->
+)
+
+type token struct {
+	typ  tokenType
+	text string
+	line int
+}
+
+const specialChars = ";{}[]()=,"
+
+// scan splits a script into tokens. Concatenating the text of all tokens results in the original script.
+func scan(script string) ([]token, error) {
+	var tokens []token
+	line := 1
+	runes := []rune(script)
+	for i := 0; i < len(runes); {
+		start := i
+		tok := token{line: line}
+		switch ch := runes[i]; {
+		case unicode.IsSpace(ch):
+			tok.typ = tokSpace
+			for i < len(runes) && unicode.IsSpace(runes[i]) {
+				i++
+			}
+		case ch == '#':
+			tok.typ = tokComment
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			tok.typ = tokComment
+			end := strings.Index(string(runes[i+2:]), "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("Line %v: Missing closing */ of block comment", line)
+			}
+			i += 2 + len([]rune(string(runes[i+2:])[:end])) + 2
+		case ch == '"' || ch == '\'' || ch == '`':
+			tok.typ = tokQuoted
+			i++
+			for i < len(runes) && runes[i] != ch {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("Line %v: Missing closing %c quote", line, ch)
+			}
+			i++
+		case ch == '-' && i+1 < len(runes) && runes[i+1] == '>':
+			tok.typ = tokSpecial
+			i += 2
+		case strings.ContainsRune(specialChars, ch):
+			tok.typ = tokSpecial
+			i++
+		default:
+			tok.typ = tokText
+			for i < len(runes) && !isTextEnd(runes, i) {
+				i = placeholderEnd(runes, i)
+			}
+		}
+		tok.text = string(runes[start:i])
+		line += strings.Count(tok.text, "\n")
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+func isTextEnd(runes []rune, i int) bool {
+	ch := runes[i]
+	return unicode.IsSpace(ch) || ch == '#' || ch == '"' || ch == '\'' || ch == '`' ||
+		strings.ContainsRune(specialChars, ch) || (ch == '-' && i+1 < len(runes) && runes[i+1] == '>')
+}
+
+// placeholderEnd returns the index after the ${...} placeholder starting at index i, or i+1 if there is no placeholder.
+// The braces of a placeholder are part of the text token, they do not open a sub-pipeline.
+func placeholderEnd(runes []rune, i int) int {
+	if runes[i] == '$' && i+1 < len(runes) && runes[i+1] == '{' {
+		for end := i + 2; end < len(runes) && runes[end] != '\n'; end++ {
+			if runes[end] == '}' {
+				return end + 1
+			}
+		}
+	}
+	return i + 1
+}
+
+func join(tokens []token) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		b.WriteString(tok.text)
+	}
+	return b.String()
+}
+
+// blank replaces the text of the tokens with spaces, but keeps the newlines to preserve the line numbers of the remaining script.
+func blank(tokens []token) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		for _, ch := range tok.text {
+			if ch == '\n' {
+				b.WriteRune('\n')
+			} else {
+				b.WriteRune(' ')
+			}
+		}
+	}
+	return b.String()
+}
+
+// nextSignificant returns the index of the next token at or after i that is not whitespace or a comment, or len(tokens).
+func nextSignificant(tokens []token, i int) int {
+	for i < len(tokens) && (tokens[i].typ == tokSpace || tokens[i].typ == tokComment) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,51 @@
+package reg
+
+import (
+	"fmt"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+)
+
+// Parameters that are available for all forks, see NewSampleFork()
+const (
+	ForkQueueParam      = "branch-queue"
+	ForkSaturationParam = "saturation"
+	ForkSpillDirParam   = "spill-dir"
+	ForkReadOnlyParam   = "read-only"
+)
+
+// forkOptions describe the parameters that are available for all forks. They are added to every registered fork.
+var forkOptions = []Option{
+	OptionalParams(ForkQueueParam, ForkSaturationParam, ForkSpillDirParam, ForkReadOnlyParam),
+	ParamDetails(ForkQueueParam, TypeInt, "0", "If > 0, the subpipelines run concurrently and receive the samples through queues of this size"),
+	ParamDetails(ForkSaturationParam, TypeString, string(fork.SaturationBlock), fmt.Sprintf("What to do with samples for a subpipeline with a full queue: %v, %v or %v (to a temporary file)",
+		fork.SaturationBlock, fork.SaturationDrop, fork.SaturationSpill)),
+	ParamDetails(ForkSpillDirParam, TypeString, "", "Directory for the temporary files of saturation="+string(fork.SaturationSpill)+" (default: system temporary directory)"),
+	ParamDetails(ForkReadOnlyParam, TypeBool, "false", "Declare that the subpipelines do not modify samples, so they receive the samples without copying them. "+
+		"Subpipelines consisting only of read-only steps are detected automatically"),
+}
+
+// NewSampleFork creates a fork.SampleFork and configures it with the parameters that are available for all forks.
+// These parameters are removed from params, the remaining parameters are meant for the ForkFunc of the fork.
+func NewSampleFork(params map[string]string) (*fork.SampleFork, error) {
+	var err error
+	result := &fork.SampleFork{
+		BranchQueue: IntParam(params, ForkQueueParam, 0, true, &err),
+		SpillDir:    StrParam(params, ForkSpillDirParam, "", true, &err),
+
+		ReadOnlyBranches: BoolParam(params, ForkReadOnlyParam, false, true, &err),
+	}
+	saturation := StrParam(params, ForkSaturationParam, string(fork.SaturationBlock), true, &err)
+	if err == nil {
+		if result.BranchQueue < 0 {
+			err = ParameterError(ForkQueueParam, fmt.Errorf("Must not be negative"))
+		} else if result.Saturated, err = fork.ParseSaturationPolicy(saturation); err != nil {
+			err = ParameterError(ForkSaturationParam, err)
+		}
+	}
+	delete(params, ForkQueueParam)
+	delete(params, ForkSaturationParam)
+	delete(params, ForkSpillDirParam)
+	delete(params, ForkReadOnlyParam)
+	return result, err
+}
@@ -3,6 +3,7 @@ package reg
 type Options struct {
 	RequiredParams []string
 	OptionalParams []string
+	ParamDetails   map[string]ParamInfo
 
 	// SupportBatchProcessing, if true this Processor can be called with batches
 	SupportBatchProcessing bool
@@ -25,6 +26,36 @@ func OptionalParams(params ...string) Option {
 	}
 }
 
+// ParamType is the type of a parameter value, matching the helper function used to parse it, like IntParam().
+type ParamType string
+
+const (
+	TypeString   = ParamType("string")
+	TypeInt      = ParamType("integer")
+	TypeFloat    = ParamType("number")
+	TypeBool     = ParamType("boolean")
+	TypeDuration = ParamType("duration")
+)
+
+// ParamInfo describes a parameter for the exported capabilities of the registry. Default is the default value
+// as it would be written in a script, empty if there is no default value.
+type ParamInfo struct {
+	Type        ParamType `json:",omitempty"`
+	Default     string    `json:",omitempty"`
+	Description string    `json:",omitempty"`
+}
+
+// ParamDetails adds a description of the given parameter. The parameter must be listed with RequiredParams() or OptionalParams().
+// Parameters without details are described as strings.
+func ParamDetails(name string, typ ParamType, defaultVal string, description string) Option {
+	return func(opts *Options) {
+		if opts.ParamDetails == nil {
+			opts.ParamDetails = make(map[string]ParamInfo)
+		}
+		opts.ParamDetails[name] = ParamInfo{Type: typ, Default: defaultVal, Description: description}
+	}
+}
+
 func SupportBatch() Option {
 	return func(opts *Options) {
 		opts.SupportBatchProcessing = true
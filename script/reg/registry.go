@@ -35,6 +35,7 @@ type RegisteredFork struct {
 type registeredParameters struct {
 	required []string
 	optional []string
+	details  map[string]ParamInfo
 }
 
 type Subpipeline interface {
@@ -81,7 +82,7 @@ func (r *ProcessorRegistryImpl) RegisterAnalysisParamsErr(name string, setupPipe
 		panic("Analysis already registered: " + name)
 	}
 	opts := GetOpts(options)
-	params := registeredParameters{opts.RequiredParams, opts.OptionalParams}
+	params := registeredParameters{opts.RequiredParams, opts.OptionalParams, opts.ParamDetails}
 	r.analysisRegistry[name] = RegisteredAnalysis{
 		Name:                     name,
 		Func:                     setupPipeline,
@@ -115,8 +116,8 @@ func (r *ProcessorRegistryImpl) RegisterFork(name string, createFork ForkFunc, d
 	if _, ok := r.forkRegistry[name]; ok {
 		panic("Fork already registered: " + name)
 	}
-	opts := GetOpts(options)
-	params := registeredParameters{opts.RequiredParams, opts.OptionalParams}
+	opts := GetOpts(append(options, forkOptions...))
+	params := registeredParameters{opts.RequiredParams, opts.OptionalParams, opts.ParamDetails}
 	r.forkRegistry[name] = RegisteredFork{name, createFork, params.makeDescription(description), params}
 }
 
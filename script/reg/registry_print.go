@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"io"
 	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
 )
 
 type ProcessingSteps []JsonProcessingStep
@@ -28,6 +30,11 @@ type JsonProcessingStep struct {
 	Description    string
 	RequiredParams []string
 	OptionalParams []string
+
+	VariableParams           bool                 `json:",omitempty"`
+	ParamDetails             map[string]ParamInfo `json:",omitempty"`
+	SupportsBatchProcessing  bool                 `json:",omitempty"`
+	SupportsStreamProcessing bool                 `json:",omitempty"`
 }
 
 func (r ProcessorRegistry) getSortedProcessingSteps() ProcessingSteps {
@@ -37,11 +44,15 @@ func (r ProcessorRegistry) getSortedProcessingSteps() ProcessingSteps {
 			continue
 		}
 		all = append(all, JsonProcessingStep{
-			Name:           step.Name,
-			IsFork:         false,
-			Description:    step.Description,
-			RequiredParams: step.Params.required,
-			OptionalParams: step.Params.optional,
+			Name:                     step.Name,
+			IsFork:                   false,
+			Description:              step.Description,
+			RequiredParams:           step.Params.required,
+			OptionalParams:           step.Params.optional,
+			VariableParams:           step.Params.required == nil,
+			ParamDetails:             step.Params.details,
+			SupportsBatchProcessing:  step.SupportsBatchProcessing,
+			SupportsStreamProcessing: step.SupportsStreamProcessing,
 		})
 	}
 	for _, fork := range r.forkRegistry {
@@ -54,6 +65,8 @@ func (r ProcessorRegistry) getSortedProcessingSteps() ProcessingSteps {
 			Description:    fork.Description,
 			RequiredParams: fork.Params.required,
 			OptionalParams: fork.Params.optional,
+			VariableParams: fork.Params.required == nil,
+			ParamDetails:   fork.Params.details,
 		})
 	}
 	sort.Sort(all)
@@ -84,3 +97,99 @@ func (r ProcessorRegistry) PrintJsonCapabilities(out io.Writer) error {
 	}
 	return err
 }
+
+var builtinEndpoints = []bitflow.EndpointType{bitflow.FileEndpoint, bitflow.TcpEndpoint, bitflow.TcpListenEndpoint, bitflow.StdEndpoint, bitflow.HttpEndpoint}
+
+// JsonSchemaVersion is the JSON Schema dialect of the output of PrintJsonSchema().
+const JsonSchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// PrintJsonSchema prints a JSON Schema describing the parameters of all processing steps and forks. Every step is
+// a definition of an object type with one property per parameter. Durations are strings with the "duration" format,
+// as parsed by time.ParseDuration(). Additional information about the steps and the available endpoint types
+// is stored in properties starting with "x-bitflow-".
+func (r ProcessorRegistry) PrintJsonSchema(out io.Writer) error {
+	definitions := make(map[string]interface{})
+	for _, step := range r.getSortedProcessingSteps() {
+		properties := make(map[string]interface{})
+		for _, param := range append(append([]string(nil), step.RequiredParams...), step.OptionalParams...) {
+			properties[param] = paramSchema(step.ParamDetails[param])
+		}
+		definition := map[string]interface{}{
+			"type":                 "object",
+			"description":          step.Description,
+			"properties":           properties,
+			"additionalProperties": step.VariableParams,
+			"x-bitflow-fork":       step.IsFork,
+		}
+		if len(step.RequiredParams) > 0 {
+			definition["required"] = step.RequiredParams
+		}
+		if step.VariableParams {
+			// Variable parameters are always strings
+			definition["additionalProperties"] = map[string]string{"type": "string"}
+		}
+		if !step.IsFork {
+			definition["x-bitflow-batch"] = step.SupportsBatchProcessing
+			definition["x-bitflow-stream"] = step.SupportsStreamProcessing
+		}
+		definitions[step.Name] = definition
+	}
+
+	var customInputs, customOutputs []bitflow.EndpointType
+	for typ := range r.Endpoints.CustomDataSources {
+		customInputs = append(customInputs, typ)
+	}
+	for typ := range r.Endpoints.CustomDataSinks {
+		customOutputs = append(customOutputs, typ)
+	}
+	schema := map[string]interface{}{
+		"$schema":     JsonSchemaVersion,
+		"title":       "Bitflow processing steps",
+		"definitions": definitions,
+		"x-bitflow-endpoints": map[string]interface{}{
+			"inputs":  endpointTypes(builtinEndpoints, customInputs),
+			"outputs": endpointTypes(builtinEndpoints, customOutputs),
+		},
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(schema)
+}
+
+func paramSchema(info ParamInfo) map[string]interface{} {
+	res := map[string]interface{}{"type": string(TypeString)}
+	switch info.Type {
+	case TypeInt, TypeFloat, TypeBool:
+		res["type"] = string(info.Type)
+	case TypeDuration:
+		res["format"] = string(TypeDuration)
+	}
+	if info.Description != "" {
+		res["description"] = info.Description
+	}
+	if info.Default != "" {
+		res["default"] = info.defaultValue()
+	}
+	return res
+}
+
+// defaultValue converts the default value to the JSON type of the parameter, if possible.
+func (info ParamInfo) defaultValue() interface{} {
+	var value interface{}
+	if info.Type == TypeInt || info.Type == TypeFloat || info.Type == TypeBool {
+		if err := json.Unmarshal([]byte(info.Default), &value); err == nil {
+			return value
+		}
+	}
+	return info.Default
+}
+
+func endpointTypes(builtin []bitflow.EndpointType, custom []bitflow.EndpointType) []string {
+	types := make([]string, 0, len(builtin)+len(custom))
+	for _, typ := range append(append([]bitflow.EndpointType(nil), builtin...), custom...) {
+		types = append(types, string(typ))
+	}
+	sort.Strings(types)
+	return types
+}
@@ -3,8 +3,13 @@ package reg
 // TODO implement tests
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -27,10 +32,71 @@ func (suite *processorRegistryTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*processorRegistryTestSuite) SetS(suite.TestingSuite) {
+}
+
 func (suite *processorRegistryTestSuite) TestGivenRegisteredStep_whenGetStep_returnRegisteredStep() {
 
 }
 
+func (suite *processorRegistryTestSuite) TestGivenParamDetails_whenPrintJsonSchema_returnTypedParameters() {
+	registry := NewProcessorRegistry()
+	registry.RegisterAnalysisParamsErr("step", func(*bitflow.SamplePipeline, map[string]string) error { return nil }, "A step",
+		RequiredParams("num"), OptionalParams("flag", "other"),
+		ParamDetails("num", TypeInt, "", "A number"), ParamDetails("flag", TypeBool, "true", ""), SupportBatch())
+	var buf bytes.Buffer
+	suite.NoError(registry.PrintJsonSchema(&buf))
+
+	var schema map[string]interface{}
+	suite.NoError(json.Unmarshal(buf.Bytes(), &schema))
+	suite.Equal(JsonSchemaVersion, schema["$schema"])
+	definitions := schema["definitions"].(map[string]interface{})
+	suite.Len(definitions, 2) // The step and the multiplex fork
+	suite.Equal(map[string]interface{}{
+		"type":        "object",
+		"description": "A step. Required parameters: [num]. Optional parameters: [flag other]",
+		"properties": map[string]interface{}{
+			"num":   map[string]interface{}{"type": "integer", "description": "A number"},
+			"flag":  map[string]interface{}{"type": "boolean", "default": true},
+			"other": map[string]interface{}{"type": "string"},
+		},
+		"required":             []interface{}{"num"},
+		"additionalProperties": false,
+		"x-bitflow-fork":       false,
+		"x-bitflow-batch":      true,
+		"x-bitflow-stream":     true,
+	}, definitions["step"])
+	suite.Equal(true, definitions[MultiplexForkName].(map[string]interface{})["x-bitflow-fork"])
+	suite.Contains(schema["x-bitflow-endpoints"].(map[string]interface{})["inputs"], "empty")
+}
+
+func (suite *processorRegistryTestSuite) TestGivenForkParams_whenNewSampleFork_returnConfiguredFork() {
+	params := map[string]string{ForkQueueParam: "10", ForkSaturationParam: "spill", ForkSpillDirParam: "/tmp", ForkReadOnlyParam: "true", "tag": "host"}
+	f, err := NewSampleFork(params)
+	suite.NoError(err)
+	suite.Equal(10, f.BranchQueue)
+	suite.Equal(fork.SaturationSpill, f.Saturated)
+	suite.Equal("/tmp", f.SpillDir)
+	suite.True(f.ReadOnlyBranches)
+	suite.Equal(map[string]string{"tag": "host"}, params, "The common fork parameters must be removed")
+
+	f, err = NewSampleFork(map[string]string{})
+	suite.NoError(err)
+	suite.Equal(0, f.BranchQueue)
+	suite.Equal(fork.SaturationBlock, f.Saturated)
+	suite.False(f.ReadOnlyBranches)
+
+	_, err = NewSampleFork(map[string]string{ForkSaturationParam: "x"})
+	suite.EqualError(err, "Failed to parse 'saturation' parameter: Unknown saturation policy 'x', expected 'block', 'drop' or 'spill'")
+	_, err = NewSampleFork(map[string]string{ForkQueueParam: "-1"})
+	suite.EqualError(err, "Failed to parse 'branch-queue' parameter: Must not be negative")
+
+	registry := NewProcessorRegistry()
+	multiplex, _ := registry.GetFork(MultiplexForkName)
+	suite.Contains(multiplex.Params.optional, ForkQueueParam)
+	suite.Equal(TypeInt, multiplex.Params.details[ForkQueueParam].Type)
+}
+
 /*
 
 type pipeTestSuite struct {
@@ -51,6 +117,9 @@ func (suite *pipeTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*pipeTestSuite) SetS(suite.TestingSuite) {
+}
+
 func (suite *pipeTestSuite) test(script string, expected *bitflow.SamplePipeline) {
 	ast, err := NewParser(bytes.NewReader([]byte(script))).Parse()
 	suite.NoError(err)
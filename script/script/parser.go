@@ -1,32 +1,67 @@
 package script
 
 import (
-	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/antlr/antlr4/runtime/Go/antlr"
 	"github.com/antongulenko/golib"
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+	"github.com/bitflow-stream/go-bitflow/script/preprocess"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	internal "github.com/bitflow-stream/go-bitflow/script/script/internal"
+	log "github.com/sirupsen/logrus"
 )
 
 type BitflowScriptParser struct {
 	Registry      reg.ProcessorRegistry
 	RecoverPanics bool
+
+	// Parameters are used to resolve ${name} placeholders in the script, see preprocess.Process()
+	Parameters map[string]string
 }
 
 func (s *BitflowScriptParser) ParseScript(script string) (*bitflow.SamplePipeline, golib.MultiError) {
-	parser := &_bitflowScriptParser{registry: &s.Registry}
+	script, err := preprocess.Process(script, s.Parameters)
+	if err != nil {
+		return nil, golib.MultiError{err}
+	}
+	parser := &_bitflowScriptParser{
+		registry:    &s.Registry,
+		reported:    make(map[string]bool),
+		deadLetters: &bitflow.DeadLetterOutputs{Endpoints: &s.Registry.Endpoints},
+	}
 	res := parser.parseScript(script, s.RecoverPanics)
+	sortErrors(parser.MultiError)
 	return res, parser.MultiError
 }
 
+// sortErrors sorts the errors by their position in the script. Errors without position are placed first.
+func sortErrors(errs golib.MultiError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, aOk := errs[i].(PositionedError)
+		b, bOk := errs[j].(PositionedError)
+		if !aOk || !bOk {
+			return !aOk && bOk
+		}
+		posA, posB := a.Position(), b.Position()
+		return posA.Line < posB.Line || (posA.Line == posB.Line && posA.Column < posB.Column)
+	})
+}
+
 type _bitflowScriptParser struct {
 	antlr.DefaultErrorListener
 	golib.MultiError
 	registry *reg.ProcessorRegistry
+
+	// While the script is parsed, the errors of sub-pipelines are added directly to the list of errors. The map is used
+	// to avoid duplicate errors, since the same sub-pipeline can be built multiple times. After parsing, reported is nil.
+	reported map[string]bool
+
+	// Dead letter outputs are shared by all steps of the script, including the sub-pipelines of forks
+	deadLetters *bitflow.DeadLetterOutputs
 }
 
 func (s *_bitflowScriptParser) parseScript(script string, recoverPanics bool) *bitflow.SamplePipeline {
@@ -37,29 +72,51 @@ func (s *_bitflowScriptParser) parseScript(script string, recoverPanics bool) *b
 			}
 		}()
 	}
+	defer func() {
+		s.reported = nil
+	}()
 	input := antlr.NewInputStream(script)
 	lexer := internal.NewBitflowLexer(input)
+	lexer.RemoveErrorListeners()
+	lexer.AddErrorListener(s)
 	stream := antlr.NewCommonTokenStream(lexer, 0)
 	p := internal.NewBitflowParser(stream)
 	p.RemoveErrorListeners()
 	p.AddErrorListener(s)
 	p.BuildParseTrees = true
 	tree := p.Script()
+	if len(s.MultiError) > 0 {
+		// After syntax errors, the parse tree is incomplete. Build it anyway to report as many errors as possible,
+		// but ignore failures caused by the missing parts of the tree.
+		defer func() {
+			if r := recover(); r != nil {
+				log.Debugf("Ignoring panic while building the incomplete parse tree: %v", r)
+			}
+		}()
+	}
 	return s.buildScript(tree.(*internal.ScriptContext))
 }
 
 type parsedSubpipeline struct {
-	keys     []string
-	pipe     *internal.SubPipelineContext
-	registry *reg.ProcessorRegistry
+	keys   []string
+	pipe   *internal.SubPipelineContext
+	parent *_bitflowScriptParser
 }
 
 func (s *parsedSubpipeline) Build() (*bitflow.SamplePipeline, error) {
 	pipe := new(bitflow.SamplePipeline)
 	parser := &_bitflowScriptParser{
-		registry: s.registry,
+		registry:    s.parent.registry,
+		deadLetters: s.parent.deadLetters,
 	}
 	parser.buildPipelineTail(pipe, s.pipe.AllPipelineTailElement())
+	if len(parser.MultiError) > 0 && s.parent.reported != nil {
+		// Do not return the errors, so that the fork continues building the other sub-pipelines and all errors are reported
+		for _, err := range parser.MultiError {
+			s.parent.pushAnyError(err)
+		}
+		return pipe, nil
+	}
 	return pipe, parser.NilOrError()
 }
 
@@ -71,11 +128,57 @@ func (s *parsedSubpipeline) Keys() []string {
 // Error handling
 // ==============
 
+// Position is the location of an error in the script. Lines start at 1 and columns start at 0. EndColumn is exclusive.
+type Position struct {
+	Line, Column       int
+	EndLine, EndColumn int
+}
+
+// PositionedError is implemented by all errors that refer to a location in the script.
+type PositionedError interface {
+	error
+	Position() Position
+}
+
+// SyntaxError is reported by the lexer and parser for invalid scripts.
+type SyntaxError struct {
+	Pos     Position
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return formatParserError(e.Pos.Line, e.Pos.Column, "", e.Message)
+}
+
+func (e *SyntaxError) Position() Position {
+	return e.Pos
+}
+
+// ParserError is reported for syntactically correct scripts that cannot be turned into a pipeline,
+// for example because of unknown processing steps or invalid parameters.
 type ParserError struct {
 	Pos     antlr.ParserRuleContext
 	Message string
 }
 
+func (e ParserError) Position() Position {
+	start, stop := e.Pos.GetStart(), e.Pos.GetStop()
+	pos := Position{Line: start.GetLine(), Column: start.GetColumn()}
+	if stop == nil || stop.GetTokenIndex() < start.GetTokenIndex() {
+		stop = start
+	}
+	// Tokens can contain line breaks
+	text := stop.GetText()
+	if newline := strings.LastIndex(text, "\n"); newline >= 0 {
+		pos.EndLine = stop.GetLine() + strings.Count(text, "\n")
+		pos.EndColumn = len(text) - newline - 1
+	} else {
+		pos.EndLine = stop.GetLine()
+		pos.EndColumn = stop.GetColumn() + len(text)
+	}
+	return pos
+}
+
 func (e ParserError) Error() string {
 	msg := e.Message
 	if msg == "" {
@@ -93,12 +196,19 @@ func formatParserError(line, col int, text, msg string) string {
 
 func (s *_bitflowScriptParser) pushAnyError(err error) {
 	if err != nil {
+		if s.reported != nil {
+			if msg := err.Error(); s.reported[msg] {
+				return
+			} else {
+				s.reported[msg] = true
+			}
+		}
 		s.Add(err)
 	}
 }
 
 func (s *_bitflowScriptParser) pushError(pos antlr.ParserRuleContext, msgFormat string, params ...interface{}) {
-	s.Add(&ParserError{
+	s.pushAnyError(&ParserError{
 		Pos:     pos,
 		Message: fmt.Sprintf(msgFormat, params...),
 	})
@@ -108,7 +218,11 @@ func (s *_bitflowScriptParser) SyntaxError(recognizer antlr.Recognizer, offendin
 	if msg == "" {
 		msg = e.GetMessage()
 	}
-	s.Add(errors.New(formatParserError(line, column, "", msg)))
+	pos := Position{Line: line, Column: column, EndLine: line, EndColumn: column + 1}
+	if token, ok := offendingSymbol.(antlr.Token); ok && token.GetTokenType() != antlr.TokenEOF && len(token.GetText()) > 0 {
+		pos.EndColumn = column + len(token.GetText())
+	}
+	s.pushAnyError(&SyntaxError{Pos: pos, Message: msg})
 }
 
 // ==============
@@ -216,18 +330,74 @@ func (s *_bitflowScriptParser) buildProcessingStep(pipe *bitflow.SamplePipeline,
 		return
 	}
 
+	var errorPolicy *bitflow.ErrorPolicy
+	var logLevel *log.Level
+	if hintsCtx, ok := ctx.SchedulingHints().(*internal.SchedulingHintsContext); ok && hintsCtx != nil {
+		hints := s.buildParameterList(hintsCtx.ParameterList())
+		if policyStr, ok := hints[bitflow.ErrorPolicyHint]; ok {
+			policy, err := bitflow.ParseErrorPolicy(policyStr)
+			if err != nil {
+				s.pushError(hintsCtx, "%v: %v", name, err)
+				return
+			}
+			errorPolicy = &policy
+		}
+		if levelStr, ok := hints[bitflow.LogLevelHint]; ok {
+			level, err := bitflow.ParseLogLevel(levelStr)
+			if err != nil {
+				s.pushError(hintsCtx, "%v: %v", name, err)
+				return
+			}
+			logLevel = &level
+		}
+	}
+
+	numProcessors := len(pipe.Processors)
 	err := regAnalysis.Params.Verify(params)
 	if err == nil {
 		err = regAnalysis.Func(pipe, params)
 	}
+	if err == nil && logLevel != nil {
+		applyLogLevel(pipe.Processors[numProcessors:], *logLevel)
+	}
+	if err == nil && errorPolicy != nil {
+		err = s.applyErrorPolicy(pipe.Processors[numProcessors:], *errorPolicy)
+	}
 	if err != nil {
 		s.pushError(nameCtx, "%v: %v", name, err)
 	}
 }
 
+// applyErrorPolicy wraps all given processors, which were added by a single processing step, in place.
+func (s *_bitflowScriptParser) applyErrorPolicy(processors []bitflow.SampleProcessor, policy bitflow.ErrorPolicy) error {
+	for i, processor := range processors {
+		wrapped, err := bitflow.NewErrorPolicyProcessor(processor, policy, s.deadLetters)
+		if err != nil {
+			return err
+		}
+		processors[i] = wrapped
+	}
+	return nil
+}
+
+// applyLogLevel overrides the log level of all given processors, which were added by a single processing step.
+func applyLogLevel(processors []bitflow.SampleProcessor, level log.Level) {
+	for _, processor := range processors {
+		if logging, ok := processor.(bitflow.LoggingProcessor); ok {
+			logging.SetLogLevel(level)
+		} else {
+			log.Warnf("%v: Processor does not support the %v hint", processor, bitflow.LogLevelHint)
+		}
+	}
+}
+
 func (s *_bitflowScriptParser) buildParameters(ctx *internal.ParametersContext) map[string]string {
+	return s.buildParameterList(ctx.ParameterList())
+}
+
+func (s *_bitflowScriptParser) buildParameterList(lst internal.IParameterListContext) map[string]string {
 	params := make(map[string]string)
-	if lst := ctx.ParameterList(); lst != nil {
+	if lst != nil {
 		for _, paramCtxI := range lst.(*internal.ParameterListContext).AllParameter() {
 			paramCtx := paramCtxI.(*internal.ParameterContext)
 			key := unwrapString(paramCtx.Name(0).(*internal.NameContext))
@@ -249,7 +419,10 @@ func (s *_bitflowScriptParser) buildFork(pipe *bitflow.SamplePipeline, ctx *inte
 		s.pushError(nameCtx, "Pipeline fork '%v' is unknown", name)
 		return
 	}
-	err := forkStep.Params.Verify(params)
+	sampleFork, err := reg.NewSampleFork(params)
+	if err == nil {
+		err = forkStep.Params.Verify(params)
+	}
 	if err != nil {
 		s.pushError(nameCtx, "%v: %v", name, err)
 		return
@@ -261,14 +434,17 @@ func (s *_bitflowScriptParser) buildFork(pipe *bitflow.SamplePipeline, ctx *inte
 		subpipelines[i] = s.buildNamedSubPipeline(namedSubPipe.(*internal.NamedSubPipelineContext))
 	}
 
+	numErrors := len(s.MultiError)
 	distributor, err := forkStep.Func(subpipelines, params)
-	if err != nil {
+	if len(s.MultiError) > numErrors {
+		// The sub-pipelines contained errors
+		return
+	} else if err != nil {
 		s.pushError(nameCtx, "%v: %v", name, err)
 		return
 	}
-	pipe.Add(&fork.SampleFork{
-		Distributor: distributor,
-	})
+	sampleFork.Distributor = distributor
+	pipe.Add(sampleFork)
 }
 
 func (s *_bitflowScriptParser) buildNamedSubPipeline(ctx *internal.NamedSubPipelineContext) reg.Subpipeline {
@@ -278,9 +454,9 @@ func (s *_bitflowScriptParser) buildNamedSubPipeline(ctx *internal.NamedSubPipel
 		keys[i] = unwrapString(key.(*internal.NameContext))
 	}
 	return &parsedSubpipeline{
-		keys:     keys,
-		pipe:     ctx.SubPipeline().(*internal.SubPipelineContext),
-		registry: s.registry,
+		keys:   keys,
+		pipe:   ctx.SubPipeline().(*internal.SubPipelineContext),
+		parent: s,
 	}
 }
 
@@ -315,9 +491,63 @@ func (s *_bitflowScriptParser) buildMultiplexFork(pipe *bitflow.SamplePipeline,
 }
 
 func (s *_bitflowScriptParser) buildWindow(pipe *bitflow.SamplePipeline, ctx *internal.WindowContext) {
+	params := s.buildParameters(ctx.Parameters().(*internal.ParametersContext))
+	window, err := buildWindowProcessor(params)
+	if err != nil {
+		s.pushError(ctx, "window: %v", err)
+		return
+	}
+
+	// Every step inside the window adds a BatchProcessor with its batch processing steps to the temporary pipeline
+	stepsPipe := new(bitflow.SamplePipeline)
+	for _, stepCtx := range ctx.AllProcessingStep() {
+		s.buildProcessingStep(stepsPipe, true, stepCtx.(*internal.ProcessingStepContext))
+	}
+	for _, proc := range stepsPipe.Processors {
+		if batch, ok := proc.(*bitflow.BatchProcessor); ok {
+			window.Steps = append(window.Steps, batch.Steps...)
+		} else {
+			s.pushError(ctx, "window: Step '%v' did not produce a batch processing step", proc)
+		}
+	}
+	pipe.Add(window)
+}
+
+var windowParams = map[string]bool{"mode": true, "size": true, "slide": true, "gap": true, "lateness": true, "key": true, "tag_windows": true}
 
-	// TODO implement batch mode
-	// Take window parameters into account
+func buildWindowProcessor(params map[string]string) (*bitflow.WindowProcessor, error) {
+	for key := range params {
+		if !windowParams[key] {
+			return nil, fmt.Errorf("Unexpected parameter '%v'", key)
+		}
+	}
+	var err error
+	window := &bitflow.WindowProcessor{
+		Size:            reg.DurationParam(params, "size", 0, true, &err),
+		Slide:           reg.DurationParam(params, "slide", 0, true, &err),
+		Gap:             reg.DurationParam(params, "gap", 0, true, &err),
+		AllowedLateness: reg.DurationParam(params, "lateness", 0, true, &err),
+		TagWindows:      reg.BoolParam(params, "tag_windows", false, true, &err),
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key := params["key"]; key != "" {
+		window.KeyTags = strings.Split(key, ",")
+	}
 
-	s.pushError(ctx, "Window mode not implemented")
+	defaultMode := bitflow.GlobalWindow
+	switch {
+	case window.Slide > 0:
+		defaultMode = bitflow.SlidingWindow
+	case window.Size > 0:
+		defaultMode = bitflow.TumblingWindow
+	case window.Gap > 0:
+		defaultMode = bitflow.SessionWindow
+	}
+	window.Mode = bitflow.WindowMode(reg.StrParam(params, "mode", string(defaultMode), true, &err))
+	if err == nil {
+		err = window.Validate()
+	}
+	return window, err
 }
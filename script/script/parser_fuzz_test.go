@@ -0,0 +1,37 @@
+package script
+
+import (
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+)
+
+func FuzzParseScript(f *testing.F) {
+	for _, script := range []string{
+		"./in -> noop() -> ./out",
+		"./in -> noop -> { a -> noop(); b -> noop() } -> ./out",
+		"./in -> window(size=10s, slide=5s, key=\"host,service\") { batch() } -> ./out",
+		"{ ./a ; :8080 } -> multiplex(num=2) { noop() ; noop() } -> csv://-",
+		"./in -> unknown() -> { noop() ; noop() -> other() }\n -> ) -> noop(x=1",
+		"./in -> fork_tag(tag=host) { x -> noop() } -> ${out:./out}",
+		"// comment\n./in -> noop(a=[1, 2], b={x=y}) -> ./out",
+	} {
+		f.Add(script)
+	}
+	registry := reg.NewProcessorRegistry()
+	registry.Endpoints = *bitflow.NewEndpointFactory()
+	steps.RegisterNoop(registry)
+	steps.RegisterForks(registry)
+	registry.RegisterAnalysisParamsErr("batch",
+		func(pipeline *bitflow.SamplePipeline, params map[string]string) error {
+			return nil
+		}, "a batch step", reg.SupportBatch())
+
+	f.Fuzz(func(t *testing.T, script string) {
+		// Panics are not recovered, so they are reported as failures. Errors are expected for most inputs.
+		parser := BitflowScriptParser{Registry: registry}
+		_, _ = parser.ParseScript(script)
+	})
+}
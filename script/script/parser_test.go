@@ -3,11 +3,13 @@ package script
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/steps"
 	"github.com/bugsnag/bugsnag-go/errors"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,9 +49,8 @@ func TestParseScript_withEnforcedBatchInStream_shouldReturnError(t *testing.T) {
 	assert.True(t, strings.Contains(errs[0].Error(), "Processor used outside window, but does not support stream processing"))
 }
 
-// TODO add test
-func __TestParseScript_withStreamTransformInWindow_shouldReturnError(t *testing.T) {
-	testScript := "./in -> window { normal_transform() -> batch_supporting_transform()} -> ./out"
+func TestParseScript_withStreamTransformInWindow_shouldReturnError(t *testing.T) {
+	testScript := "./in -> window() { normal_transform() -> batch_supporting_transform()} -> ./out"
 	parser, _ := createTestParser()
 
 	_, errs := parser.ParseScript(testScript)
@@ -58,9 +59,8 @@ func __TestParseScript_withStreamTransformInWindow_shouldReturnError(t *testing.
 	assert.True(t, strings.Contains(errs[0].Error(), "Processor used in window, but does not support batch processing."))
 }
 
-// TODO add test
-func __TestParseScript_withWindow_shouldWork(t *testing.T) {
-	testScript := "./in -> window { batch_enforcing_transform()-> batch_supporting_transform()} -> normal_transform() -> ./out"
+func TestParseScript_withWindow_shouldWork(t *testing.T) {
+	testScript := "./in -> window() { batch_enforcing_transform()-> batch_supporting_transform()} -> normal_transform() -> ./out"
 	parser, out := createTestParser()
 
 	_, errs := parser.ParseScript(testScript)
@@ -71,6 +71,100 @@ func __TestParseScript_withWindow_shouldWork(t *testing.T) {
 	assert.Equal(t, "normal_transform", out.calledSteps[2])
 }
 
+func TestParseScript_withWindowParameters(t *testing.T) {
+	testScript := "./in -> window(size=10s, slide=5s, key=\"host,service\") { batch_supporting_transform() } -> ./out"
+	parser, _ := createTestParser()
+
+	pipe, errs := parser.ParseScript(testScript)
+
+	assert.Equal(t, nil, errs.NilOrError())
+	window, ok := pipe.Processors[0].(*bitflow.WindowProcessor)
+	assert.True(t, ok)
+	assert.Equal(t, bitflow.SlidingWindow, window.Mode)
+	assert.Equal(t, 10*time.Second, window.Size)
+	assert.Equal(t, 5*time.Second, window.Slide)
+	assert.Equal(t, []string{"host", "service"}, window.KeyTags)
+}
+
+func TestParseScript_withInvalidWindowParameters_shouldReturnError(t *testing.T) {
+	testScript := "./in -> window(mode=sliding, size=10s) { batch_supporting_transform() } -> ./out"
+	parser, _ := createTestParser()
+
+	_, errs := parser.ParseScript(testScript)
+
+	assert.Len(t, errs, 1)
+	assert.True(t, strings.Contains(errs[0].Error(), "Sliding windows require a positive size and slide interval"))
+}
+
+func TestParseScript_multipleErrors_shouldReportAllWithPositions(t *testing.T) {
+	testScript := "./in -> unknown() -> { required_param_transform() ; normal_transform() -> other() }\n -> ) -> normal_transform(x=1"
+	parser, _ := createTestParser()
+
+	_, errs := parser.ParseScript(testScript)
+
+	assert.Len(t, errs, 5)
+	var positions []Position
+	for _, err := range errs {
+		positioned, ok := err.(PositionedError)
+		assert.True(t, ok, "Error has no position: %v", err)
+		positions = append(positions, positioned.Position())
+	}
+	assert.Equal(t, []Position{
+		{Line: 1, Column: 8, EndLine: 1, EndColumn: 15},
+		{Line: 1, Column: 23, EndLine: 1, EndColumn: 47},
+		{Line: 1, Column: 74, EndLine: 1, EndColumn: 79},
+		{Line: 2, Column: 4, EndLine: 2, EndColumn: 5},
+		{Line: 2, Column: 29, EndLine: 2, EndColumn: 30},
+	}, positions)
+	assert.Contains(t, errs[0].Error(), "unknown: Unknown Processor")
+	assert.Contains(t, errs[1].Error(), "Missing required parameter 'requiredParam'")
+	assert.Contains(t, errs[2].Error(), "other: Unknown Processor")
+}
+
+func TestParseScript_withLogLevelHint(t *testing.T) {
+	testScript := "./in -> noop()[log_level=debug] -> ./out"
+	parser, _ := createTestParser()
+
+	pipe, errs := parser.ParseScript(testScript)
+
+	assert.Equal(t, nil, errs.NilOrError())
+	noop, ok := pipe.Processors[0].(*steps.NoopProcessor)
+	assert.True(t, ok)
+	noop.InitLogger(bitflow.LogContext{Step: noop.String()})
+	assert.Equal(t, log.DebugLevel, noop.Log().Logger.Level)
+	assert.Equal(t, "noop", noop.Log().Data[bitflow.LogFieldStep])
+}
+
+func TestParseScript_withInvalidLogLevelHint_shouldReturnError(t *testing.T) {
+	testScript := "./in -> noop()[log_level=verbose] -> ./out"
+	parser, _ := createTestParser()
+
+	_, errs := parser.ParseScript(testScript)
+
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "noop: not a valid logrus Level")
+}
+
+func TestParseScript_withDeadLetterHint_shouldShareOutput(t *testing.T) {
+	testScript := "./in -> noop()[on_error=dead-letter:./errors] -> noop()[on_error=dead-letter:./errors] -> noop()[on_error=drop]"
+	parser, _ := createTestParser()
+
+	pipe, errs := parser.ParseScript(testScript)
+
+	assert.Equal(t, nil, errs.NilOrError())
+	assert.Len(t, pipe.Processors, 3)
+	first, ok := pipe.Processors[0].(*bitflow.ErrorPolicyProcessor)
+	assert.True(t, ok)
+	second, ok := pipe.Processors[1].(*bitflow.ErrorPolicyProcessor)
+	assert.True(t, ok)
+	assert.True(t, first.DeadLetter == second.DeadLetter, "Steps with the same dead letter output must share the output")
+	_, isFileSink := first.DeadLetter.Sink.(*bitflow.FileSink)
+	assert.True(t, isFileSink)
+	drop, ok := pipe.Processors[2].(*bitflow.ErrorPolicyProcessor)
+	assert.True(t, ok)
+	assert.Nil(t, drop.DeadLetter)
+}
+
 // TODO add test
 func __TestParseScript_withWindowInWindow_shouldReturnError(t *testing.T) {
 	testScript := "./in -> window {batch_supporting_transform() -> window { batch_supporting_transform()}} -> normal_transform() -> ./out"
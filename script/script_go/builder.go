@@ -136,9 +136,13 @@ func (b PipelineBuilder) createMultiInput(pipes MultiInput) (bitflow.SampleSourc
 func (b PipelineBuilder) addFork(pipe *bitflow.SamplePipeline, f Fork) error {
 	forkStep, err := b.getFork(f.Name)
 	var distributor fork.Distributor
+	var sampleFork *fork.SampleFork
 	if err == nil {
 		params := f.ParamsMap()
-		err = forkStep.Params.Verify(params)
+		sampleFork, err = reg.NewSampleFork(params)
+		if err == nil {
+			err = forkStep.Params.Verify(params)
+		}
 		if err == nil {
 			subpipelines := b.prepareSubpipelines(f.Pipelines)
 			regSubpipelines := make([]reg.Subpipeline, len(subpipelines))
@@ -154,9 +158,8 @@ func (b PipelineBuilder) addFork(pipe *bitflow.SamplePipeline, f Fork) error {
 			Message: fmt.Sprintf("%v: %v", f.Name.Content(), err),
 		}
 	}
-	pipe.Add(&fork.SampleFork{
-		Distributor: distributor,
-	})
+	sampleFork.Distributor = distributor
+	pipe.Add(sampleFork)
 	return nil
 }
 
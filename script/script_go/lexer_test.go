@@ -27,6 +27,9 @@ func (suite *lexerTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*lexerTestSuite) SetS(suite.TestingSuite) {
+}
+
 func (suite *lexerTestSuite) testErr(input string, tokens []Token, errIndex int, expectedErr error) {
 	s := NewScanner(bytes.NewBufferString(input))
 	var tok Token
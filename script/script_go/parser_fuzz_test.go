@@ -0,0 +1,33 @@
+package script_go
+
+import (
+	"strings"
+	"testing"
+)
+
+type acceptingVerification struct{}
+
+func (acceptingVerification) VerifyInput(inputs []string) error                     { return nil }
+func (acceptingVerification) VerifyOutput(output string) error                      { return nil }
+func (acceptingVerification) VerifyStep(name Token, params map[string]string) error { return nil }
+func (acceptingVerification) VerifyFork(name Token, params map[string]string) error { return nil }
+
+func FuzzParser(f *testing.F) {
+	for _, script := range []string{
+		"in -> out",
+		"a b c -> avg() -> out",
+		"in -> { a -> b ; c } -> fork(x=y) { 1 -> step ; 2 -> step2(a=b, c='d') } -> out",
+		"{ a ; b c } -> \"quoted\" -> `raw` -> out",
+		"# comment\nin -> step(a=1) ; -> x",
+		"'unterminated",
+	} {
+		f.Add(script)
+	}
+	f.Fuzz(func(t *testing.T, script string) {
+		// Errors are expected for most inputs, only panics are reported as failures
+		pipe, err := NewParser(strings.NewReader(script)).Parse()
+		if err == nil {
+			_, _ = pipe.Transform(acceptingVerification{})
+		}
+	})
+}
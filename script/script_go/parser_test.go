@@ -26,6 +26,9 @@ func (suite *parserTestSuite) SetT(t *testing.T) {
 	suite.Assertions = require.New(t)
 }
 
+func (*parserTestSuite) SetS(suite.TestingSuite) {
+}
+
 func (suite *parserTestSuite) testErr(code string, expectedRes interface{}, expectedErr error) {
 	p := NewParser(bytes.NewBufferString(code))
 	res, err := p.Parse()
@@ -0,0 +1,351 @@
+// Package alert provides a processing step that notifies humans about samples matching a condition, e.g. samples
+// that were tagged as anomalous. Notifications are sent via email, Slack, PagerDuty or generic HTTP webhooks.
+package alert
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultDedupWindow   = 10 * time.Minute
+	DefaultLimit         = 10
+	DefaultLimitInterval = time.Hour
+	DefaultQueueSize     = 100
+)
+
+// Alert is the information passed to a Notifier.
+type Alert struct {
+	Time    time.Time          `json:"time"`
+	Message string             `json:"message"`
+	Key     string             `json:"key"`
+	Tags    map[string]string  `json:"tags"`
+	Values  map[string]float64 `json:"values"`
+
+	// Suppressed is the number of alerts with the same key that were suppressed since the last notification
+	Suppressed int `json:"suppressed"`
+}
+
+// Text returns the message, including a note about suppressed alerts.
+func (a *Alert) Text() string {
+	if a.Suppressed > 0 {
+		return fmt.Sprintf("%v (%v similar alert(s) suppressed)", a.Message, a.Suppressed)
+	}
+	return a.Message
+}
+
+// details lists the tags and values of the alert, sorted by name.
+func (a *Alert) details() []string {
+	var lines []string
+	for _, key := range sortedKeys(a.Tags) {
+		lines = append(lines, fmt.Sprintf("%v: %v", key, a.Tags[key]))
+	}
+	fields := make([]string, 0, len(a.Values))
+	for field := range a.Values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		lines = append(lines, fmt.Sprintf("%v = %v", field, a.Values[field]))
+	}
+	return lines
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type dedupEntry struct {
+	sent       time.Time
+	suppressed int
+}
+
+// Processor fires alerts for samples that match the Condition expression and/or carry a non-empty Tag. All
+// samples are forwarded unchanged. Alerts with the same key are only sent once per DedupWindow, and at most Limit
+// alerts are sent per LimitInterval. The notifiers are invoked asynchronously, so that slow notification
+// channels do not block the pipeline.
+type Processor struct {
+	bitflow.NoopProcessor
+
+	Condition *steps.Expression
+	Tag       string
+
+	// Message and Key are templates that can contain tag values, e.g. ${host}. Key defaults to Message.
+	Message bitflow.TagTemplate
+	Key     bitflow.TagTemplate
+
+	DedupWindow   time.Duration // Zero disables the deduplication
+	Limit         int           // Zero disables the rate limit
+	LimitInterval time.Duration
+	QueueSize     int
+
+	Notifiers []Notifier
+
+	description string
+	now         func() time.Time
+	queue       chan *Alert
+	dedup       map[string]*dedupEntry
+	sent        []time.Time
+	rateLimited int
+	dropped     int
+}
+
+func (p *Processor) String() string {
+	var conditions []string
+	if p.description != "" {
+		conditions = append(conditions, p.description)
+	}
+	if p.Tag != "" {
+		conditions = append(conditions, "tag "+p.Tag)
+	}
+	var notifiers []string
+	for _, notifier := range p.Notifiers {
+		notifiers = append(notifiers, notifier.String())
+	}
+	if len(notifiers) == 0 {
+		notifiers = append(notifiers, "log")
+	}
+	return fmt.Sprintf("Alert on %v via %v", strings.Join(conditions, " and "), strings.Join(notifiers, ", "))
+}
+
+func (p *Processor) Start(wg *sync.WaitGroup) golib.StopChan {
+	queueSize := p.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	p.queue = make(chan *Alert, queueSize)
+	stopped := p.NoopProcessor.Start(wg)
+	wg.Add(1)
+	go p.notify(wg)
+	return stopped
+}
+
+func (p *Processor) notify(wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer p.CloseSink()
+	for alert := range p.queue {
+		for _, notifier := range p.Notifiers {
+			if err := notifier.Notify(alert); err != nil {
+				p.Log().Errorf("Failed to send alert via %v: %v", notifier, err)
+			}
+		}
+	}
+}
+
+func (p *Processor) Close() {
+	if p.rateLimited > 0 || p.dropped > 0 {
+		p.Log().Warnf("%v alert(s) were suppressed by the rate limit, %v were dropped because the queue was full", p.rateLimited, p.dropped)
+	}
+	// The notification routine sends the remaining alerts and closes the sink afterwards
+	close(p.queue)
+}
+
+func (p *Processor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	matches, err := p.matches(sample, header)
+	if err != nil {
+		return err
+	}
+	if matches {
+		p.fire(sample, header)
+	}
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *Processor) matches(sample *bitflow.Sample, header *bitflow.Header) (bool, error) {
+	if p.Tag != "" && sample.Tag(p.Tag) == "" {
+		return false, nil
+	}
+	if p.Condition != nil {
+		return p.Condition.EvaluateBool(sample, header)
+	}
+	return true, nil
+}
+
+func (p *Processor) fire(sample *bitflow.Sample, header *bitflow.Header) {
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+	message := p.Message.Resolve(sample)
+	if p.Message.Template == "" {
+		message = "Alert for " + sample.TagString()
+	}
+	key := message
+	if p.Key.Template != "" {
+		key = p.Key.Resolve(sample)
+	}
+
+	if p.dedup == nil {
+		p.dedup = make(map[string]*dedupEntry)
+	}
+	entry, known := p.dedup[key]
+	if known && now.Sub(entry.sent) < p.DedupWindow {
+		entry.suppressed++
+		return
+	}
+	if !p.takeRateLimit(now) {
+		p.rateLimited++
+		return
+	}
+	alert := &Alert{
+		Time:    now,
+		Message: message,
+		Key:     key,
+		Tags:    sample.TagMap(),
+		Values:  make(map[string]float64, len(header.Fields)),
+	}
+	if known {
+		alert.Suppressed = entry.suppressed
+	}
+	for i, field := range header.Fields {
+		if i < len(sample.Values) {
+			alert.Values[field] = float64(sample.Values[i])
+		}
+	}
+	p.cleanDedup(now)
+	p.dedup[key] = &dedupEntry{sent: now}
+
+	log.Warnln("Alert:", alert.Text())
+	select {
+	case p.queue <- alert:
+	default:
+		p.dropped++
+	}
+}
+
+func (p *Processor) takeRateLimit(now time.Time) bool {
+	if p.Limit <= 0 {
+		return true
+	}
+	i := 0
+	for i < len(p.sent) && now.Sub(p.sent[i]) >= p.LimitInterval {
+		i++
+	}
+	p.sent = p.sent[i:]
+	if len(p.sent) >= p.Limit {
+		return false
+	}
+	p.sent = append(p.sent, now)
+	return true
+}
+
+// cleanDedup forgets keys whose deduplication window has expired
+func (p *Processor) cleanDedup(now time.Time) {
+	for key, entry := range p.dedup {
+		if now.Sub(entry.sent) >= p.DedupWindow {
+			delete(p.dedup, key)
+		}
+	}
+}
+
+func RegisterAlert(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("alert",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			proc, err := NewProcessor(params)
+			if err == nil {
+				p.Add(proc)
+			}
+			return err
+		},
+		"Send notifications for samples that match the given boolean expression and/or carry the given tag. "+
+			"Alerts are always logged, and optionally sent via email, Slack, PagerDuty or a generic JSON webhook.",
+		reg.OptionalParams("expr", "tag", "message", "key", "dedup", "limit", "limit-interval", "queue",
+			"webhook", "slack", "pagerduty", "pagerduty-severity", "email", "smtp", "smtp-from", "smtp-user", "smtp-password"),
+		reg.ParamDetails("expr", reg.TypeString, "", "Boolean expression that samples must match to fire an alert"),
+		reg.ParamDetails("tag", reg.TypeString, "", "Fire alerts for samples carrying this tag with a non-empty value, e.g. an anomaly tag"),
+		reg.ParamDetails("message", reg.TypeString, "", "Alert message, tag values can be inserted like ${host} (default: all tags of the sample)"),
+		reg.ParamDetails("key", reg.TypeString, "", "Deduplication key, tag values can be inserted like ${host} (default: the message)"),
+		reg.ParamDetails("dedup", reg.TypeDuration, DefaultDedupWindow.String(), "Send alerts with the same key only once within this duration, 0 disables the deduplication"),
+		reg.ParamDetails("limit", reg.TypeInt, fmt.Sprint(DefaultLimit), "Maximum number of alerts per limit-interval, 0 disables the limit"),
+		reg.ParamDetails("limit-interval", reg.TypeDuration, DefaultLimitInterval.String(), "Time interval for the limit parameter"),
+		reg.ParamDetails("queue", reg.TypeInt, fmt.Sprint(DefaultQueueSize), "Number of alerts that can wait for being sent, further alerts are dropped"),
+		reg.ParamDetails("webhook", reg.TypeString, "", "URL that receives alerts as JSON objects"),
+		reg.ParamDetails("slack", reg.TypeString, "", "URL of a Slack incoming webhook"),
+		reg.ParamDetails("pagerduty", reg.TypeString, "", "Routing key of a PagerDuty Events API v2 integration"),
+		reg.ParamDetails("pagerduty-severity", reg.TypeString, DefaultSeverity, "Severity of PagerDuty events (critical, error, warning or info)"),
+		reg.ParamDetails("email", reg.TypeString, "", "Comma-separated email recipients, requires the smtp parameter"),
+		reg.ParamDetails("smtp", reg.TypeString, "", "SMTP server for sending emails (host:port)"),
+		reg.ParamDetails("smtp-from", reg.TypeString, "", "Sender of alert emails (default: bitflow@<hostname>)"),
+		reg.ParamDetails("smtp-user", reg.TypeString, "", "User name for authenticating at the SMTP server"),
+		reg.ParamDetails("smtp-password", reg.TypeString, "", "Password for authenticating at the SMTP server"))
+}
+
+// NewProcessor creates an alert Processor from the parameters of the alert step.
+func NewProcessor(params map[string]string) (*Processor, error) {
+	var err error
+	expr := reg.StrParam(params, "expr", "", true, &err)
+	proc := &Processor{
+		Tag:           reg.StrParam(params, "tag", "", true, &err),
+		Message:       bitflow.TagTemplate{Template: reg.StrParam(params, "message", "", true, &err)},
+		Key:           bitflow.TagTemplate{Template: reg.StrParam(params, "key", "", true, &err)},
+		DedupWindow:   reg.DurationParam(params, "dedup", DefaultDedupWindow, true, &err),
+		Limit:         reg.IntParam(params, "limit", DefaultLimit, true, &err),
+		LimitInterval: reg.DurationParam(params, "limit-interval", DefaultLimitInterval, true, &err),
+		QueueSize:     reg.IntParam(params, "queue", DefaultQueueSize, true, &err),
+		description:   expr,
+	}
+	webhook := reg.StrParam(params, "webhook", "", true, &err)
+	slack := reg.StrParam(params, "slack", "", true, &err)
+	pagerDuty := &PagerDutyNotifier{
+		RoutingKey: reg.StrParam(params, "pagerduty", "", true, &err),
+		Severity:   reg.StrParam(params, "pagerduty-severity", DefaultSeverity, true, &err),
+	}
+	recipients := reg.StrParam(params, "email", "", true, &err)
+	email := &EmailNotifier{
+		Server:   reg.StrParam(params, "smtp", "", true, &err),
+		From:     reg.StrParam(params, "smtp-from", "", true, &err),
+		Username: reg.StrParam(params, "smtp-user", "", true, &err),
+		Password: reg.StrParam(params, "smtp-password", "", true, &err),
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if expr == "" && proc.Tag == "" {
+		return nil, fmt.Errorf("At least one of the parameters 'expr' and 'tag' must be defined")
+	}
+	if expr != "" {
+		if proc.Condition, err = steps.NewExpression(expr); err != nil {
+			return nil, reg.ParameterError("expr", err)
+		}
+	}
+	if proc.Limit > 0 && proc.LimitInterval <= 0 {
+		return nil, reg.ParameterError("limit-interval", fmt.Errorf("Must be positive"))
+	}
+	if webhook != "" {
+		proc.Notifiers = append(proc.Notifiers, &WebhookNotifier{Url: webhook})
+	}
+	if slack != "" {
+		proc.Notifiers = append(proc.Notifiers, &SlackNotifier{WebhookUrl: slack})
+	}
+	if pagerDuty.RoutingKey != "" {
+		proc.Notifiers = append(proc.Notifiers, pagerDuty)
+	}
+	if recipients != "" {
+		if email.Server == "" {
+			return nil, reg.ParameterError("smtp", fmt.Errorf("Must be defined for sending emails"))
+		}
+		email.To = strings.Split(recipients, ",")
+		if email.From == "" {
+			hostname, _ := os.Hostname()
+			email.From = "bitflow@" + hostname
+		}
+		proc.Notifiers = append(proc.Notifiers, email)
+	}
+	return proc, nil
+}
@@ -0,0 +1,194 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type alertTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	lock     sync.Mutex
+	received map[string][]map[string]interface{}
+}
+
+func TestAlert(t *testing.T) {
+	suite.Run(t, new(alertTestSuite))
+}
+
+func (s *alertTestSuite) SetupTest() {
+	s.received = make(map[string][]map[string]interface{})
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/broken" {
+			http.Error(w, "Broken", http.StatusInternalServerError)
+			return
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.received[r.URL.Path] = append(s.received[r.URL.Path], body)
+	}))
+}
+
+func (s *alertTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+type nopSink struct {
+	bitflow.DroppingSampleProcessor
+	samples int
+}
+
+func (s *nopSink) Sample(*bitflow.Sample, *bitflow.Header) error {
+	s.samples++
+	return nil
+}
+
+func (s *alertTestSuite) run(proc *Processor, samples ...*bitflow.Sample) *nopSink {
+	header := &bitflow.Header{Fields: []string{"cpu"}}
+	out := new(nopSink)
+	proc.SetSink(out)
+	var wg sync.WaitGroup
+	proc.Start(&wg)
+	for _, sample := range samples {
+		s.NoError(proc.Sample(sample, header))
+	}
+	proc.Close()
+	wg.Wait()
+	return out
+}
+
+func sample(cpu float64, tags ...string) *bitflow.Sample {
+	result := &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(cpu)}}
+	for i := 0; i < len(tags); i += 2 {
+		result.SetTag(tags[i], tags[i+1])
+	}
+	return result
+}
+
+func (s *alertTestSuite) TestConditions() {
+	proc, err := NewProcessor(map[string]string{
+		"expr": "cpu > 90", "tag": "anomaly", "message": "High CPU on ${host}", "dedup": "0",
+		"webhook": s.server.URL + "/hook", "slack": s.server.URL + "/slack",
+	})
+	s.Require().NoError(err)
+	s.Equal("Alert on cpu > 90 and tag anomaly via webhook "+s.server.URL+"/hook, Slack", proc.String())
+
+	out := s.run(proc,
+		sample(95, "host", "a", "anomaly", "true"),
+		sample(95, "host", "b"),
+		sample(50, "host", "c", "anomaly", "true"),
+		sample(99, "host", "d", "anomaly", "true"))
+	s.Equal(4, out.samples, "All samples must be forwarded")
+
+	s.Require().Len(s.received["/hook"], 2)
+	hook := s.received["/hook"][0]
+	s.Equal("High CPU on a", hook["message"])
+	s.Equal(map[string]interface{}{"host": "a", "anomaly": "true"}, hook["tags"])
+	s.Equal(map[string]interface{}{"cpu": 95.0}, hook["values"])
+	s.Equal([]map[string]interface{}{{"text": "High CPU on a"}, {"text": "High CPU on d"}}, s.received["/slack"])
+}
+
+func (s *alertTestSuite) TestDeduplicationAndRateLimit() {
+	now := time.Unix(1000, 0)
+	proc := &Processor{
+		Tag:           "anomaly",
+		Message:       bitflow.TagTemplate{Template: "Anomaly ${anomaly} on ${host}"},
+		Key:           bitflow.TagTemplate{Template: "${host}"},
+		DedupWindow:   time.Minute,
+		Limit:         2,
+		LimitInterval: time.Hour,
+		Notifiers:     []Notifier{&WebhookNotifier{Url: s.server.URL + "/hook"}},
+		now:           func() time.Time { return now },
+	}
+	header := &bitflow.Header{Fields: []string{"cpu"}}
+	var wg sync.WaitGroup
+	proc.SetSink(new(nopSink))
+	proc.Start(&wg)
+	fire := func(host string, advance time.Duration) {
+		now = now.Add(advance)
+		s.NoError(proc.Sample(sample(1, "host", host, "anomaly", "cpu"), header))
+	}
+	fire("a", 0)
+	fire("a", 10*time.Second) // Deduplicated
+	fire("a", 10*time.Second) // Deduplicated
+	fire("a", time.Minute)    // Sent, reporting 2 suppressed alerts
+	fire("b", time.Second)    // Rate limited
+	fire("b", 2*time.Hour)    // Sent
+	fire("c", 0)              // Sent
+	fire("c", 0)              // Deduplicated
+	proc.Close()
+	wg.Wait()
+
+	var messages []string
+	for _, alert := range s.received["/hook"] {
+		messages = append(messages, alert["message"].(string)+" "+alert["key"].(string))
+	}
+	s.Equal([]string{"Anomaly cpu on a a", "Anomaly cpu on a a", "Anomaly cpu on b b", "Anomaly cpu on c c"}, messages)
+	s.Equal(2.0, s.received["/hook"][1]["suppressed"])
+	s.Equal(1, proc.rateLimited)
+}
+
+func (s *alertTestSuite) TestNotifiers() {
+	alert := &Alert{
+		Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Message: "Disk full\nDetails follow", Key: "disk",
+		Tags: map[string]string{"host": "a"}, Values: map[string]float64{"disk": 100}, Suppressed: 3,
+	}
+	pagerDuty := &PagerDutyNotifier{RoutingKey: "secret", Severity: "critical", Url: s.server.URL + "/pd"}
+	s.NoError(pagerDuty.Notify(alert))
+	s.Equal([]map[string]interface{}{{
+		"routing_key": "secret", "event_action": "trigger", "dedup_key": "disk",
+		"payload": map[string]interface{}{
+			"summary": "Disk full\nDetails follow", "source": "bitflow", "severity": "critical", "timestamp": "2020-01-02T03:04:05Z",
+			"custom_details": map[string]interface{}{"host": "a", "disk": 100.0},
+		},
+	}}, s.received["/pd"])
+
+	err := (&WebhookNotifier{Url: s.server.URL + "/broken"}).Notify(alert)
+	s.EqualError(err, "POST "+s.server.URL+"/broken returned status 500 Internal Server Error: Broken")
+
+	email := &EmailNotifier{From: "bitflow@example.com", To: []string{"ops@example.com", "dev@example.com"}}
+	s.Equal(strings.Join([]string{
+		"From: bitflow@example.com",
+		"To: ops@example.com, dev@example.com",
+		"Subject: [bitflow] Disk full",
+		"Date: Thu, 02 Jan 2020 03:04:05 +0000",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"Disk full",
+		"Details follow (3 similar alert(s) suppressed)",
+		"",
+		"host: a",
+		"disk = 100",
+	}, "\r\n"), string(email.message(alert)))
+}
+
+func (s *alertTestSuite) TestParameters() {
+	_, err := NewProcessor(map[string]string{"message": "x"})
+	s.EqualError(err, "At least one of the parameters 'expr' and 'tag' must be defined")
+	_, err = NewProcessor(map[string]string{"tag": "anomaly", "email": "ops@example.com"})
+	s.EqualError(err, "Failed to parse 'smtp' parameter: Must be defined for sending emails")
+	_, err = NewProcessor(map[string]string{"expr": "cpu >"})
+	s.Error(err)
+
+	proc, err := NewProcessor(map[string]string{"tag": "anomaly", "email": "ops@example.com,dev@example.com", "smtp": "mail:25", "pagerduty": "key"})
+	s.Require().NoError(err)
+	s.Equal(DefaultDedupWindow, proc.DedupWindow)
+	s.Equal(DefaultLimit, proc.Limit)
+	s.Require().Len(proc.Notifiers, 2)
+	s.Equal(DefaultSeverity, proc.Notifiers[0].(*PagerDutyNotifier).Severity)
+	s.Equal([]string{"ops@example.com", "dev@example.com"}, proc.Notifiers[1].(*EmailNotifier).To)
+	s.Equal("Alert on tag anomaly via PagerDuty, email to ops@example.com, dev@example.com", proc.String())
+}
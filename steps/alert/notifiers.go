@@ -0,0 +1,151 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout     = 10 * time.Second
+	PagerDutyEventsApi = "https://events.pagerduty.com/v2/enqueue"
+	DefaultSeverity    = "error"
+)
+
+// Notifier delivers alerts to humans.
+type Notifier interface {
+	fmt.Stringer
+	Notify(alert *Alert) error
+}
+
+var httpClient = &http.Client{Timeout: DefaultTimeout}
+
+func postJson(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("POST %v returned status %v: %v", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// WebhookNotifier posts the alert as JSON object to a URL.
+type WebhookNotifier struct {
+	Url string
+}
+
+func (n *WebhookNotifier) String() string {
+	return "webhook " + n.Url
+}
+
+func (n *WebhookNotifier) Notify(alert *Alert) error {
+	return postJson(n.Url, alert)
+}
+
+// SlackNotifier posts the alert message to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookUrl string
+}
+
+func (n *SlackNotifier) String() string {
+	return "Slack"
+}
+
+func (n *SlackNotifier) Notify(alert *Alert) error {
+	return postJson(n.WebhookUrl, map[string]string{"text": alert.Text()})
+}
+
+// PagerDutyNotifier triggers PagerDuty incidents through the Events API v2. The deduplication key of the alert is
+// used as dedup_key, so that PagerDuty groups repeated alerts into one incident.
+type PagerDutyNotifier struct {
+	RoutingKey string
+	Severity   string // critical, error, warning or info
+	Url        string // Defaults to PagerDutyEventsApi
+}
+
+func (n *PagerDutyNotifier) String() string {
+	return "PagerDuty"
+}
+
+func (n *PagerDutyNotifier) Notify(alert *Alert) error {
+	url := n.Url
+	if url == "" {
+		url = PagerDutyEventsApi
+	}
+	details := make(map[string]interface{}, len(alert.Tags)+len(alert.Values))
+	for key, value := range alert.Tags {
+		details[key] = value
+	}
+	for key, value := range alert.Values {
+		details[key] = value
+	}
+	return postJson(url, map[string]interface{}{
+		"routing_key":  n.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    alert.Key,
+		"payload": map[string]interface{}{
+			"summary":        alert.Message,
+			"source":         "bitflow",
+			"severity":       n.Severity,
+			"timestamp":      alert.Time.Format(time.RFC3339),
+			"custom_details": details,
+		},
+	})
+}
+
+// EmailNotifier sends the alert as plain text email through an SMTP server.
+type EmailNotifier struct {
+	Server   string // host:port
+	From     string
+	To       []string
+	Username string // Optional, enables PLAIN authentication
+	Password string
+}
+
+func (n *EmailNotifier) String() string {
+	return fmt.Sprintf("email to %v", strings.Join(n.To, ", "))
+}
+
+func (n *EmailNotifier) Notify(alert *Alert) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		host := n.Server
+		if colon := strings.LastIndex(host, ":"); colon >= 0 {
+			host = host[:colon]
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	return smtp.SendMail(n.Server, auth, n.From, n.To, n.message(alert))
+}
+
+func (n *EmailNotifier) message(alert *Alert) []byte {
+	subject := alert.Message
+	if newline := strings.IndexByte(subject, '\n'); newline >= 0 {
+		subject = subject[:newline]
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [bitflow] %v\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %v\r\n", alert.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(alert.Text(), "\n", "\r\n", -1))
+	msg.WriteString("\r\n")
+	for _, line := range alert.details() {
+		msg.WriteString("\r\n" + line)
+	}
+	return msg.Bytes()
+}
@@ -9,13 +9,19 @@ func RegisterGenericBatch(b reg.ProcessorRegistry) {
 	b.RegisterAnalysisParamsErr("batch",
 		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
 			timeout := reg.DurationParam(params, "timeout", 0, true, &err)
+			memory := reg.IntParam(params, "memory", 0, true, &err)
 			if err == nil {
 				p.Add(&bitflow.BatchProcessor{
 					FlushTags:    []string{params["tag"]},
 					FlushTimeout: timeout,
+					MemoryBudget: int64(memory) << 20,
+					SpillDir:     params["spill-dir"],
 				})
 			}
 			return
 		},
-		"Collect samples and flush them on different events (wall time/sample time/tag change/number of samples). Affects the follow-up analysis step, if it is also a batch analysis", reg.RequiredParams("tag"), reg.OptionalParams("timeout"))
+		"Collect samples and flush them on different events (wall time/sample time/tag change/number of samples). Affects the follow-up analysis step, if it is also a batch analysis. "+
+			"Samples exceeding the memory budget (in MB) are spilled to disk", reg.RequiredParams("tag"), reg.OptionalParams("timeout", "memory", "spill-dir"),
+		reg.ParamDetails("memory", reg.TypeInt, "0", "Memory budget (MB) for the buffered samples, 0 for the global -batch-memory setting"),
+		reg.ParamDetails("spill-dir", reg.TypeString, "", "Directory for spilled samples, default: -batch-spill-dir"))
 }
@@ -1,13 +1,25 @@
 package bitflow_plugin_default_steps
 
 import (
-	"log"
-
 	"github.com/bitflow-stream/go-bitflow/script/plugin"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/alert"
+	"github.com/bitflow-stream/go-bitflow/steps/docker"
+	"github.com/bitflow-stream/go-bitflow/steps/enrich"
+	"github.com/bitflow-stream/go-bitflow/steps/evaluation"
+	"github.com/bitflow-stream/go-bitflow/steps/flow"
+	"github.com/bitflow-stream/go-bitflow/steps/hostmetrics"
+	"github.com/bitflow-stream/go-bitflow/steps/jolokia"
+	"github.com/bitflow-stream/go-bitflow/steps/kubernetes"
+	"github.com/bitflow-stream/go-bitflow/steps/libvirt"
+	"github.com/bitflow-stream/go-bitflow/steps/lua"
 	"github.com/bitflow-stream/go-bitflow/steps/math"
+	"github.com/bitflow-stream/go-bitflow/steps/msgqueue"
+	"github.com/bitflow-stream/go-bitflow/steps/objectstore"
 	"github.com/bitflow-stream/go-bitflow/steps/plot"
+	"github.com/bitflow-stream/go-bitflow/steps/wasm"
+	log "github.com/sirupsen/logrus"
 )
 
 // This plugin is automatically loaded by the bitflow-pipeline tool, there is no need to actually compile
@@ -36,31 +48,55 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 	steps.RegisterForks(b)
 	steps.RegisterExpression(b)
 	steps.RegisterSubprocessRunner(b)
+	steps.RegisterOnnxModel(b)
+	wasm.RegisterWasm(b)
+	lua.RegisterScriptStep(b)
 	steps.RegisterMergeHeaders(b)
 	steps.RegisterGenericBatch(b)
+	steps.RegisterWindowAggregation(b)
+	steps.RegisterTopK(b)
 	steps.RegisterDecouple(b)
 	steps.RegisterDropErrorsStep(b)
 	steps.RegisterResendStep(b)
 	steps.RegisterFillUpStep(b)
 	steps.RegisterPipelineRateSynchronizer(b)
 	steps.RegisterSubpipelineStreamMerger(b)
+	steps.RegisterOrderedStreamMerger(b)
 	blockMgr := steps.NewBlockManager()
 	blockMgr.RegisterBlockingProcessor(b)
 	blockMgr.RegisterReleasingProcessor(b)
 	steps.RegisterTagSynchronizer(b)
+	steps.RegisterStreamJoin(b)
+	steps.RegisterResampling(b)
+	steps.RegisterDownsampling(b)
 
 	// Data output
 	steps.RegisterOutputFiles(b)
 	steps.RegisterGraphiteOutput(b)
 	steps.RegisterOpentsdbOutput(b)
+	alert.RegisterAlert(b)
 
 	// Logging, output metadata
 	steps.RegisterStoreStats(b)
 	steps.RegisterLoggingSteps(b)
+	steps.RegisterPipelineMetrics(b)
+	steps.RegisterGeneratorSource(b)
+	hostmetrics.RegisterCollectSource(b)
+	libvirt.RegisterLibvirtSource(b)
+	docker.RegisterDockerSource(b)
+	kubernetes.RegisterMetricsSource(b)
+	kubernetes.RegisterPodTagger(b)
+	jolokia.RegisterJolokiaSource(b)
+	msgqueue.RegisterMessageQueues(b)
+	objectstore.RegisterObjectStorage(b)
+	flow.RegisterFlowSource(b)
 
 	// Visualization
 	plot.RegisterHttpPlotter(b)
+	plot.RegisterDashboard(b)
+	plot.RegisterGrafanaDatasource(b)
 	plot.RegisterPlot(b)
+	plot.RegisterMetricsPlot(b)
 
 	// Basic Math
 	math.RegisterFFT(b)
@@ -73,16 +109,41 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 	math.RegisterPCALoadStream(b)
 	math.RegisterMinMaxScaling(b)
 	math.RegisterStandardizationScaling(b)
+	math.RegisterStreamingScaling(b)
 	math.RegisterAggregateAvg(b)
 	math.RegisterAggregateSlope(b)
+	math.RegisterWindowFeatures(b)
+	math.RegisterPercentiles(b)
+	math.RegisterSmoothing(b)
+	math.RegisterDerivative(b)
+	math.RegisterSGDRegression(b)
+	math.RegisterARIMA(b)
+	math.RegisterCorrelation(b)
+	math.RegisterLOF(b)
+	math.RegisterOneClassSVM(b)
+	math.RegisterKMeans(b)
+	math.RegisterHierarchicalClustering(b)
+
+	// Evaluation
+	evaluation.RegisterSplitting(b)
+	evaluation.RegisterEvaluation(b)
+	evaluation.RegisterRocCurves(b)
+	evaluation.RegisterConfusionMatrix(b)
+	evaluation.RegisterEventEvaluation(b)
+	evaluation.RegisterLabelInjection(b)
+	evaluation.RegisterAnomalyInjection(b)
 
 	// Filter samples
 	steps.RegisterFilterExpression(b)
 	steps.RegisterPickPercent(b)
+	steps.RegisterSampling(b)
 	steps.RegisterPickHead(b)
 	steps.RegisterSkipHead(b)
 	math.RegisterConvexHull(b)
 	steps.RegisterDuplicateTimestampFilter(b)
+	steps.RegisterDeduplication(b)
+	steps.RegisterInvalidValueHandler(b)
+	steps.RegisterRateLimiter(b)
 
 	// Reorder samples
 	math.RegisterConvexHullSort(b)
@@ -91,15 +152,23 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 
 	// Metadata
 	steps.RegisterSetCurrentTime(b)
+	steps.RegisterClockSkewCorrection(b)
 	steps.RegisterTaggingProcessor(b)
+	steps.RegisterTagRewriter(b)
+	steps.RegisterCardinalityGuard(b)
 	steps.RegisterHttpTagger(b)
 	steps.RegisterPauseTagger(b)
+	enrich.RegisterEnrichment(b)
 
 	// Add/Remove/Rename/Reorder generic metrics
 	steps.RegisterParseTags(b)
+	steps.RegisterTagFeatures(b)
 	steps.RegisterStripMetrics(b)
 	steps.RegisterMetricMapper(b)
+	steps.RegisterHeaderFixer(b)
 	steps.RegisterMetricRenamer(b)
+	steps.RegisterMetricComputation(b)
+	steps.RegisterUnitConversion(b)
 	steps.RegisterIncludeMetricsFilter(b)
 	steps.RegisterExcludeMetricsFilter(b)
 	steps.RegisterVarianceMetricsFilter(b)
@@ -0,0 +1,173 @@
+package steps
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CardinalityDrop   = "drop"
+	CardinalityHash   = "hash"
+	CardinalityRollup = "rollup"
+)
+
+func RegisterCardinalityGuard(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("cardinality_guard",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			guard := &CardinalityGuard{
+				Limit:       reg.IntParam(params, "limit", 1000, true, &err),
+				Action:      reg.StrParam(params, "action", CardinalityRollup, true, &err),
+				Buckets:     reg.IntParam(params, "buckets", 100, true, &err),
+				RollupValue: reg.StrParam(params, "rollup-value", "other", true, &err),
+				Expire:      reg.DurationParam(params, "expire", 0, true, &err),
+			}
+			if tags := reg.StrParam(params, "tags", "", true, &err); tags != "" {
+				guard.Tags = strings.Split(tags, ",")
+			}
+			if err == nil {
+				switch {
+				case guard.Action != CardinalityDrop && guard.Action != CardinalityHash && guard.Action != CardinalityRollup:
+					err = reg.ParameterError("action", fmt.Errorf("Expected '%v', '%v' or '%v', but got '%v'",
+						CardinalityDrop, CardinalityHash, CardinalityRollup, guard.Action))
+				case guard.Limit < 1:
+					err = reg.ParameterError("limit", fmt.Errorf("Must be positive"))
+				case guard.Buckets < 1:
+					err = reg.ParameterError("buckets", fmt.Errorf("Must be positive"))
+				default:
+					p.Add(guard)
+				}
+			}
+			return
+		},
+		"Limit the number of distinct values per tag. Once the limit is exceeded, samples with new values are dropped, "+
+			"or the new values are replaced by a hash bucket or by a fixed roll-up value.",
+		reg.OptionalParams("tags", "limit", "action", "buckets", "rollup-value", "expire"),
+		reg.ParamDetails("tags", reg.TypeString, "", "Comma-separated tags to guard (default: all tags)"),
+		reg.ParamDetails("limit", reg.TypeInt, "1000", "Maximum number of distinct values per tag"),
+		reg.ParamDetails("action", reg.TypeString, CardinalityRollup, "What to do with new values beyond the limit: drop (the sample), hash or rollup"),
+		reg.ParamDetails("buckets", reg.TypeInt, "100", "Number of hash buckets for action=hash"),
+		reg.ParamDetails("rollup-value", reg.TypeString, "other", "Replacement value for action=rollup"),
+		reg.ParamDetails("expire", reg.TypeDuration, "0", "Forget values that were not seen for this duration (sample time), 0 disables the expiration"))
+}
+
+type tagValues struct {
+	lastSeen    map[string]time.Time
+	lastCleanup time.Time
+	exceeded    int
+}
+
+// CardinalityGuard tracks the distinct values of tags and limits them to Limit values per tag. Values that were
+// seen before the limit was reached are forwarded unchanged. New values beyond the limit are handled according to
+// Action: the sample is dropped, or the value is replaced with a hash bucket (Buckets different values) or with
+// RollupValue. If Expire is set, values that did not occur for that duration are forgotten and make room for new values.
+type CardinalityGuard struct {
+	bitflow.NoopProcessor
+	Tags        []string // Empty means all tags
+	Limit       int
+	Action      string
+	Buckets     int
+	RollupValue string
+	Expire      time.Duration
+
+	values map[string]*tagValues
+}
+
+func (g *CardinalityGuard) String() string {
+	tags := "all tags"
+	if len(g.Tags) > 0 {
+		tags = "tags " + strings.Join(g.Tags, ", ")
+	}
+	res := fmt.Sprintf("Limit cardinality of %v to %v values (%v", tags, g.Limit, g.Action)
+	if g.Expire > 0 {
+		res += fmt.Sprintf(", expire after %v", g.Expire)
+	}
+	return res + ")"
+}
+
+func (g *CardinalityGuard) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	tags := g.Tags
+	if len(tags) == 0 {
+		for _, pair := range sample.SortedTags() {
+			tags = append(tags, pair.Key)
+		}
+	}
+	for _, tag := range tags {
+		if !sample.HasTag(tag) {
+			continue
+		}
+		value := sample.Tag(tag)
+		if g.admit(tag, value, sample.Time) {
+			continue
+		}
+		switch g.Action {
+		case CardinalityDrop:
+			return nil
+		case CardinalityHash:
+			sample.SetTag(tag, g.hash(value))
+		default:
+			sample.SetTag(tag, g.RollupValue)
+		}
+	}
+	return g.NoopProcessor.Sample(sample, header)
+}
+
+// admit returns whether the value of the tag is within the limit, and records it if possible.
+func (g *CardinalityGuard) admit(tag, value string, now time.Time) bool {
+	if g.values == nil {
+		g.values = make(map[string]*tagValues)
+	}
+	values, ok := g.values[tag]
+	if !ok {
+		values = &tagValues{lastSeen: make(map[string]time.Time)}
+		g.values[tag] = values
+	}
+	if _, known := values.lastSeen[value]; known {
+		values.lastSeen[value] = now
+		return true
+	}
+	if len(values.lastSeen) >= g.Limit && g.Expire > 0 && now.Sub(values.lastCleanup) >= g.Expire/2 {
+		// Throttle the cleanup, it iterates over all known values
+		values.lastCleanup = now
+		for knownValue, lastSeen := range values.lastSeen {
+			if now.Sub(lastSeen) >= g.Expire {
+				delete(values.lastSeen, knownValue)
+			}
+		}
+	}
+	if len(values.lastSeen) < g.Limit {
+		values.lastSeen[value] = now
+		return true
+	}
+	if values.exceeded == 0 {
+		log.Warnf("%v: Tag '%v' exceeded %v distinct values, new values are handled with action '%v'", g, tag, g.Limit, g.Action)
+	}
+	values.exceeded++
+	return false
+}
+
+func (g *CardinalityGuard) hash(value string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(value))
+	return fmt.Sprintf("hash-%v", h.Sum32()%uint32(g.Buckets))
+}
+
+func (g *CardinalityGuard) Close() {
+	tags := make([]string, 0, len(g.values))
+	for tag, values := range g.values {
+		if values.exceeded > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		log.Warnf("%v: Tag '%v' exceeded the limit in %v sample(s)", g, tag, g.values[tag].exceeded)
+	}
+	g.NoopProcessor.Close()
+}
@@ -0,0 +1,49 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type cardinalityGuardTestSuite struct {
+	testsupport.Suite
+}
+
+func TestCardinalityGuard(t *testing.T) {
+	suite.Run(t, new(cardinalityGuardTestSuite))
+}
+
+// guard sends one sample per minute, with the given value in the pod and host tags, and returns the resulting pod tags.
+func (s *cardinalityGuardTestSuite) guard(guard *CardinalityGuard, values ...string) []string {
+	samples := make([]*bitflow.Sample, len(values))
+	for i, value := range values {
+		samples[i] = testsupport.NewSample(time.Duration(i)*time.Minute, "pod="+value+" host="+value)
+	}
+	return s.Process(guard, &bitflow.Header{}, samples...).Tags("pod")
+}
+
+func (s *cardinalityGuardTestSuite) TestActions() {
+	values := []string{"a", "b", "a", "c", "b", "d"}
+
+	guard := &CardinalityGuard{Tags: []string{"pod"}, Limit: 2, Action: CardinalityRollup, RollupValue: "other"}
+	s.Equal([]string{"a", "b", "a", "other", "b", "other"}, s.guard(guard, values...))
+	s.Equal(2, guard.values["pod"].exceeded)
+	s.Nil(guard.values["host"], "Only the configured tags should be tracked")
+
+	guard = &CardinalityGuard{Tags: []string{"pod"}, Limit: 2, Action: CardinalityDrop}
+	s.Equal([]string{"a", "b", "a", "b"}, s.guard(guard, values...))
+
+	guard = &CardinalityGuard{Limit: 2, Action: CardinalityHash, Buckets: 1}
+	s.Equal([]string{"a", "b", "a", "hash-0", "b", "hash-0"}, s.guard(guard, values...))
+	s.Equal(2, guard.values["host"].exceeded, "All tags should be tracked")
+}
+
+func (s *cardinalityGuardTestSuite) TestExpiration() {
+	guard := &CardinalityGuard{Tags: []string{"pod"}, Limit: 2, Action: CardinalityRollup, RollupValue: "other", Expire: 3 * time.Minute}
+	// Samples are one minute apart: 'a' expires before 'd' arrives, 'c' arrives too early
+	s.Equal([]string{"a", "b", "other", "b", "d", "other"}, s.guard(guard, "a", "b", "c", "b", "d", "a"))
+}
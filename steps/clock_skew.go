@@ -0,0 +1,180 @@
+package steps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultSkewWindow = 100
+
+func RegisterClockSkewCorrection(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("correct_skew",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			corrector := &ClockSkewCorrector{
+				Tag:       reg.StrParam(params, "tag", "", false, &err),
+				Reference: reg.StrParam(params, "reference", "", true, &err),
+				Window:    reg.IntParam(params, "window", DefaultSkewWindow, true, &err),
+				Drift:     reg.BoolParam(params, "drift", false, true, &err),
+			}
+			offsets := reg.StrParam(params, "offsets", "", true, &err)
+			if err == nil && corrector.Window < 1 {
+				err = reg.ParameterError("window", fmt.Errorf("Must be positive"))
+			}
+			if err == nil && offsets != "" {
+				corrector.Offsets, err = ParseClockOffsets(offsets)
+				if err != nil {
+					err = reg.ParameterError("offsets", err)
+				}
+			}
+			if err == nil {
+				p.Add(corrector)
+			}
+			return
+		},
+		"Correct clock offsets between the sources identified by the given tag. The delay between the timestamp and the arrival of the samples of every source "+
+			"is observed over the last window samples (default 100), and timestamps are shifted so that every source has the same delay as the reference source (the value of the tag given by reference). "+
+			"Without a reference, timestamps are corrected to the local clock. With drift=true, a linearly drifting offset is estimated instead of a constant offset. "+
+			"As the estimation is based on arrival times, it only works for live data. For recorded data, fixed offsets can be given as offsets='host1=2s,host2=-1.5s'. "+
+			"Sources with a fixed offset are not estimated.",
+		reg.RequiredParams("tag"), reg.OptionalParams("reference", "window", "drift", "offsets"))
+}
+
+// ParseClockOffsets parses a comma-separated list of source=duration pairs.
+func ParseClockOffsets(offsets string) (map[string]time.Duration, error) {
+	res := make(map[string]time.Duration)
+	for _, part := range strings.Split(offsets, ",") {
+		keyVal := strings.SplitN(part, "=", 2)
+		if len(keyVal) != 2 {
+			return nil, fmt.Errorf("Offsets must have the format source=duration, not '%v'", part)
+		}
+		offset, err := time.ParseDuration(keyVal[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid offset for source %v: %v", keyVal[0], err)
+		}
+		res[keyVal[0]] = offset
+	}
+	return res, nil
+}
+
+// ClockSkewCorrector shifts the timestamps of samples from multiple sources (identified by the value of Tag) to compensate
+// clock offsets between the sources. For every source, the delays between sample timestamps and their arrival are stored
+// for the last Window samples. The median of these delays is the estimated delay of the source. If Drift is set, the delay is
+// instead predicted by a linear regression over the arrival times, which compensates a constantly drifting clock.
+// The difference between the estimated delay of a source and the delay of the Reference source is added to every timestamp.
+// Samples are forwarded unchanged, until the reference source delivered its first sample.
+type ClockSkewCorrector struct {
+	bitflow.NoopProcessor
+	Tag       string
+	Reference string // If empty, timestamps are corrected to the local clock
+	Window    int
+	Drift     bool
+	Offsets   map[string]time.Duration // Fixed offsets that are added to the timestamps of the given sources
+
+	sources map[string]*clockSource
+	now     func() time.Time
+}
+
+type clockSource struct {
+	arrivals []float64 // Unix seconds
+	delays   []float64 // Seconds between the sample timestamp and the arrival
+	next     int
+}
+
+func (s *clockSource) observe(arrival time.Time, sampleTime time.Time, window int) {
+	seconds := float64(arrival.UnixNano()) / float64(time.Second)
+	delay := arrival.Sub(sampleTime).Seconds()
+	if len(s.delays) < window {
+		s.arrivals = append(s.arrivals, seconds)
+		s.delays = append(s.delays, delay)
+	} else {
+		s.arrivals[s.next] = seconds
+		s.delays[s.next] = delay
+		s.next = (s.next + 1) % window
+	}
+}
+
+// delay estimates the delay of the source at the given arrival time
+func (s *clockSource) delay(arrival time.Time, drift bool) float64 {
+	if !drift || len(s.delays) < 2 {
+		return Percentile(s.delays, 0.5)
+	}
+	// Least squares fit: delay = intercept + slope * arrival. Arrival times are centered to avoid precision problems.
+	var meanX, meanY float64
+	for i, x := range s.arrivals {
+		meanX += x
+		meanY += s.delays[i]
+	}
+	meanX /= float64(len(s.arrivals))
+	meanY /= float64(len(s.arrivals))
+	var cov, variance float64
+	for i, x := range s.arrivals {
+		cov += (x - meanX) * (s.delays[i] - meanY)
+		variance += (x - meanX) * (x - meanX)
+	}
+	if variance == 0 {
+		return meanY
+	}
+	x := float64(arrival.UnixNano())/float64(time.Second) - meanX
+	return meanY + cov/variance*x
+}
+
+func (c *ClockSkewCorrector) String() string {
+	res := fmt.Sprintf("Correct clock skew of sources identified by tag %v", c.Tag)
+	if c.Reference != "" {
+		res += fmt.Sprintf(" (reference %v", c.Reference)
+	} else {
+		res += " (reference local clock"
+	}
+	if c.Drift {
+		res += ", with drift"
+	}
+	if len(c.Offsets) > 0 {
+		sources := make([]string, 0, len(c.Offsets))
+		for source := range c.Offsets {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		res += fmt.Sprintf(", fixed offsets for %v", sources)
+	}
+	return res + ")"
+}
+
+func (c *ClockSkewCorrector) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	name := sample.Tag(c.Tag)
+	if offset, ok := c.Offsets[name]; ok {
+		sample.Time = sample.Time.Add(offset)
+		return c.NoopProcessor.Sample(sample, header)
+	}
+	if c.sources == nil {
+		c.sources = make(map[string]*clockSource)
+		if c.now == nil {
+			c.now = time.Now
+		}
+	}
+	now := c.now()
+	source, ok := c.sources[name]
+	if !ok {
+		source = new(clockSource)
+		c.sources[name] = source
+		log.Debugf("%v: New source %v", c, name)
+	}
+	source.observe(now, sample.Time, c.Window)
+
+	var referenceDelay float64
+	if c.Reference != "" {
+		reference, ok := c.sources[c.Reference]
+		if !ok {
+			return c.NoopProcessor.Sample(sample, header)
+		}
+		referenceDelay = reference.delay(now, c.Drift)
+	}
+	correction := source.delay(now, c.Drift) - referenceDelay
+	sample.Time = sample.Time.Add(time.Duration(correction * float64(time.Second)))
+	return c.NoopProcessor.Sample(sample, header)
+}
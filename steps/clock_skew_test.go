@@ -0,0 +1,76 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type clockSkewTestSuite struct {
+	testsupport.Suite
+}
+
+func TestClockSkew(t *testing.T) {
+	suite.Run(t, new(clockSkewTestSuite))
+}
+
+// skewed sends one sample per second and source. The clocks of the sources have the given offsets and drifts (seconds per second).
+// The returned timestamps are relative to the start time, in seconds.
+func (s *clockSkewTestSuite) skewed(corrector *ClockSkewCorrector, num int, offsets map[string]float64, drifts map[string]float64) map[string][]float64 {
+	out := testsupport.NewCapturingSink()
+	corrector.SetSink(out)
+	var now time.Time
+	corrector.now = func() time.Time { return now }
+	for i := 0; i < num; i++ {
+		for _, source := range []string{"ref", "a", "b"} {
+			elapsed := float64(i)
+			now = testsupport.StartTime.Add(time.Duration(elapsed*float64(time.Second)) + 100*time.Millisecond) // Constant network delay
+			clock := elapsed + offsets[source] + drifts[source]*elapsed
+			_ = corrector.Sample(testsupport.NewSample(time.Duration(clock*float64(time.Second)), "host="+source), &bitflow.Header{})
+		}
+	}
+	res := make(map[string][]float64)
+	offsetsOut := out.Offsets()
+	for i, host := range out.Tags("host") {
+		res[host] = append(res[host], offsetsOut[i].Seconds())
+	}
+	return res
+}
+
+func (s *clockSkewTestSuite) TestConstantOffset() {
+	corrector := &ClockSkewCorrector{Tag: "host", Reference: "ref", Window: 10}
+	res := s.skewed(corrector, 20, map[string]float64{"ref": 1, "a": 4, "b": -2}, nil)
+	for _, source := range []string{"ref", "a", "b"} {
+		s.InDelta(20.0, res[source][19], 0.001, source)
+	}
+
+	corrector = &ClockSkewCorrector{Tag: "host", Window: 10}
+	res = s.skewed(corrector, 5, map[string]float64{"a": 4}, nil)
+	s.InDelta(4.1, res["a"][4], 0.001, "without reference, the local clock is used")
+}
+
+func (s *clockSkewTestSuite) TestDrift() {
+	drifts := map[string]float64{"a": 0.01, "b": -0.02}
+	res := s.skewed(&ClockSkewCorrector{Tag: "host", Reference: "ref", Window: 50, Drift: true}, 100, nil, drifts)
+	s.InDelta(99.0, res["a"][99], 0.001)
+	s.InDelta(99.0, res["b"][99], 0.001)
+
+	res = s.skewed(&ClockSkewCorrector{Tag: "host", Reference: "ref", Window: 50}, 100, nil, drifts)
+	s.NotEqual(99.0, res["a"][99], "a constant offset cannot correct the drift")
+}
+
+func (s *clockSkewTestSuite) TestFixedOffsets() {
+	offsets, err := ParseClockOffsets("a=-4s,b=2s")
+	s.NoError(err)
+	res := s.skewed(&ClockSkewCorrector{Tag: "host", Reference: "ref", Window: 10, Offsets: offsets}, 3, map[string]float64{"a": 4, "b": -2}, nil)
+	s.Equal([]float64{0, 1, 2}, res["a"])
+	s.Equal([]float64{0, 1, 2}, res["b"])
+
+	_, err = ParseClockOffsets("a=x")
+	s.Error(err)
+	_, err = ParseClockOffsets("a")
+	s.Error(err)
+}
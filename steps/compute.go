@@ -0,0 +1,198 @@
+package steps
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// Policies for NaN or infinite results of a MetricComputation, e.g. caused by a division by zero.
+// Alternatively, a numeric replacement value can be configured.
+const (
+	ComputeNanKeep  = "keep"
+	ComputeNanDrop  = "drop"
+	ComputeNanError = "error"
+)
+
+func RegisterMetricComputation(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("compute",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			comp := &MetricComputation{
+				RemoveSources: reg.BoolParam(params, "remove", false, true, &err),
+			}
+			nan := reg.StrParam(params, "nan", ComputeNanKeep, true, &err)
+			if err != nil {
+				return
+			}
+			if err = comp.SetNanPolicy(nan); err != nil {
+				return reg.ParameterError("nan", err)
+			}
+			delete(params, "remove")
+			delete(params, "nan")
+			if len(params) == 0 {
+				return fmt.Errorf("At least one metric must be defined, e.g. compute(util='busy / (busy + idle)')")
+			}
+			names := make([]string, 0, len(params))
+			for name := range params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if err = comp.AddMetric(name, params[name]); err != nil {
+					return reg.ParameterError(name, err)
+				}
+			}
+			p.Add(comp)
+			return
+		},
+		"Define new metrics as arithmetic combinations of existing metrics, e.g. compute(util='busy / (busy + idle)'). Every parameter defines one metric, "+
+			"existing metrics with the same name are replaced. All expressions are evaluated on the input sample. "+
+			"The reserved parameter remove=true removes all metrics referenced by the expressions. "+
+			"The reserved parameter nan defines the handling of NaN and infinite results, e.g. caused by a division by zero: "+
+			"keep (default), drop (drop the sample), error (stop with an error), or a numeric replacement value")
+}
+
+// MetricComputation adds metrics computed from arithmetic expressions over the metrics of every sample.
+type MetricComputation struct {
+	bitflow.NoopProcessor
+	Metrics       []ComputedMetric
+	RemoveSources bool
+
+	// NanPolicy is one of ComputeNanKeep, ComputeNanDrop and ComputeNanError. If it is empty, NaN and infinite
+	// results are replaced with NanValue.
+	NanPolicy string
+	NanValue  float64
+
+	checker      bitflow.HeaderChecker
+	outHeader    *bitflow.Header
+	inputIndices []int // For every output field, the index of the input value, or -1 for computed metrics
+	outIndices   []int // For every computed metric, the index in the output header
+}
+
+type ComputedMetric struct {
+	Name string
+	Expr *Expression
+}
+
+// SetNanPolicy parses one of the policies ComputeNanKeep, ComputeNanDrop, ComputeNanError or a numeric replacement value.
+func (c *MetricComputation) SetNanPolicy(policy string) error {
+	switch policy {
+	case ComputeNanKeep, ComputeNanDrop, ComputeNanError:
+		c.NanPolicy = policy
+	default:
+		val, err := strconv.ParseFloat(policy, 64)
+		if err != nil {
+			return fmt.Errorf("Must be %v, %v, %v, or a number", ComputeNanKeep, ComputeNanDrop, ComputeNanError)
+		}
+		c.NanPolicy = ""
+		c.NanValue = val
+	}
+	return nil
+}
+
+func (c *MetricComputation) AddMetric(name string, expression string) error {
+	expr, err := NewExpression(expression)
+	if err != nil {
+		return err
+	}
+	c.Metrics = append(c.Metrics, ComputedMetric{Name: name, Expr: expr})
+	return nil
+}
+
+func (c *MetricComputation) String() string {
+	defs := make([]string, len(c.Metrics))
+	for i, metric := range c.Metrics {
+		defs[i] = fmt.Sprintf("%v = %v", metric.Name, metric.Expr.expr)
+	}
+	res := "Compute metrics: " + strings.Join(defs, ", ")
+	if c.RemoveSources {
+		res += " (remove source metrics)"
+	}
+	return res
+}
+
+func (c *MetricComputation) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if c.checker.HeaderChanged(header) {
+		c.updateHeader(header)
+	}
+	computed := make([]bitflow.Value, len(c.Metrics))
+	for i, metric := range c.Metrics {
+		res, err := metric.Expr.Evaluate(sample, header)
+		if err != nil {
+			return err
+		}
+		val, ok := res.(float64)
+		if !ok {
+			return fmt.Errorf("%v: Non-numeric result for metric %v: %v (%T)", c, metric.Name, res, res)
+		}
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			switch c.NanPolicy {
+			case ComputeNanKeep:
+			case ComputeNanDrop:
+				return nil
+			case ComputeNanError:
+				return fmt.Errorf("%v: Invalid result for metric %v: %v", c, metric.Name, val)
+			default:
+				val = c.NanValue
+			}
+		}
+		computed[i] = bitflow.Value(val)
+	}
+
+	values := make([]bitflow.Value, len(c.outHeader.Fields))
+	for i, inIndex := range c.inputIndices {
+		if inIndex >= 0 {
+			values[i] = sample.Values[inIndex]
+		}
+	}
+	for i, outIndex := range c.outIndices {
+		values[outIndex] = computed[i]
+	}
+	sample.Values = values
+	return c.NoopProcessor.Sample(sample, c.outHeader)
+}
+
+func (c *MetricComputation) updateHeader(header *bitflow.Header) {
+	removed := make(map[string]bool)
+	if c.RemoveSources {
+		for _, metric := range c.Metrics {
+			for variable := range metric.Expr.vars {
+				removed[variable] = true
+			}
+		}
+	}
+	computedIndices := make(map[string]int, len(c.Metrics))
+	for i, metric := range c.Metrics {
+		computedIndices[metric.Name] = i
+	}
+
+	c.outIndices = make([]int, len(c.Metrics))
+	c.inputIndices = c.inputIndices[:0]
+	var fields []string
+	for i, field := range header.Fields {
+		inIndex := i
+		if computed, ok := computedIndices[field]; ok {
+			// Replace the existing metric in-place
+			c.outIndices[computed] = len(fields)
+			delete(computedIndices, field)
+			inIndex = -1
+		} else if removed[field] {
+			continue
+		}
+		fields = append(fields, field)
+		c.inputIndices = append(c.inputIndices, inIndex)
+	}
+	for i, metric := range c.Metrics {
+		if _, ok := computedIndices[metric.Name]; ok {
+			c.outIndices[i] = len(fields)
+			fields = append(fields, metric.Name)
+			c.inputIndices = append(c.inputIndices, -1)
+		}
+	}
+	c.outHeader = header.Clone(fields)
+}
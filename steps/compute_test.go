@@ -0,0 +1,61 @@
+package steps
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type computeTestSuite struct {
+	testsupport.Suite
+}
+
+func TestCompute(t *testing.T) {
+	suite.Run(t, new(computeTestSuite))
+}
+
+var computeTestHeader = &bitflow.Header{Fields: []string{"busy", "idle", "other"}}
+
+func (s *computeTestSuite) compute(comp *MetricComputation, values ...[]bitflow.Value) *testsupport.CapturingSink {
+	samples := make([]*bitflow.Sample, len(values))
+	for i, vals := range values {
+		samples[i] = testsupport.NewSample(0, "", vals...)
+	}
+	return s.Process(comp, computeTestHeader, samples...)
+}
+
+func (s *computeTestSuite) TestComputation() {
+	comp := new(MetricComputation)
+	s.NoError(comp.SetNanPolicy(ComputeNanKeep))
+	s.NoError(comp.AddMetric("util", "busy / (busy + idle)"))
+	s.NoError(comp.AddMetric("other", "other * 2"))
+	out := s.compute(comp, []bitflow.Value{1, 3, 5}, []bitflow.Value{0, 0, 1})
+	out.AssertFields(s.T(), "busy", "idle", "other", "util")
+	values := out.Values()
+	s.Equal([]float64{1, 3, 10, 0.25}, values[0])
+	s.True(math.IsNaN(values[1][3]))
+
+	comp = &MetricComputation{RemoveSources: true}
+	s.NoError(comp.SetNanPolicy("0"))
+	s.NoError(comp.AddMetric("util", "busy / (busy + idle)"))
+	out = s.compute(comp, []bitflow.Value{1, 3, 5}, []bitflow.Value{0, 0, 1})
+	out.AssertFields(s.T(), "other", "util")
+	out.AssertValues(s.T(), [][]float64{{5, 0.25}, {1, 0}})
+	headers := out.Headers()
+	s.True(headers[0] == headers[1], "the output header must be reused")
+}
+
+func (s *computeTestSuite) TestNanPolicy() {
+	comp := new(MetricComputation)
+	s.NoError(comp.SetNanPolicy(ComputeNanDrop))
+	s.NoError(comp.AddMetric("ratio", "busy / idle"))
+	out := s.compute(comp, []bitflow.Value{1, 2, 0}, []bitflow.Value{1, 0, 0}, []bitflow.Value{3, 2, 0})
+	out.AssertCount(s.T(), 2)
+
+	s.NoError(comp.SetNanPolicy(ComputeNanError))
+	s.Error(comp.Sample(testsupport.NewSample(0, "", 1, 0, 0), computeTestHeader))
+	s.Error(comp.SetNanPolicy("invalid"))
+}
@@ -33,6 +33,17 @@ func RegisterDecouple(b reg.ProcessorRegistry) {
 	b.RegisterAnalysisParamsErr("decouple", AddDecoupleStep, "Start a new concurrent routine for handling samples. The parameter is the size of the FIFO-buffer for handing over the samples", reg.RequiredParams("buf"))
 }
 
+// IsReadOnly implements the bitflow.ReadOnlyProcessor interface.
+func (p *DecouplingProcessor) IsReadOnly() bool {
+	return true
+}
+
+// RetainsSamples implements the bitflow.RetainingProcessor interface. The queued samples are only referenced
+// until they are forwarded.
+func (p *DecouplingProcessor) RetainsSamples() bool {
+	return false
+}
+
 func (p *DecouplingProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
 	p.samples <- bitflow.SampleAndHeader{Sample: sample, Header: header}
 	return nil
@@ -69,6 +80,11 @@ func (p *DecouplingProcessor) Close() {
 	close(p.samples)
 }
 
+// QueueLength implements the bitflow.QueueingProcessor interface.
+func (p *DecouplingProcessor) QueueLength() int {
+	return len(p.samples)
+}
+
 func (p *DecouplingProcessor) String() string {
 	return fmt.Sprintf("DecouplingProcessor (buffer %v)", p.ChannelBuffer)
 }
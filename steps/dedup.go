@@ -0,0 +1,90 @@
+package steps
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterDeduplication(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("dedup",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			dedup := &DeduplicationProcessor{
+				Tolerance:   reg.FloatParam(params, "tolerance", 0, true, &err),
+				MaxInterval: reg.DurationParam(params, "interval", 0, true, &err),
+				CompareTags: reg.BoolParam(params, "tags", false, true, &err),
+			}
+			if err == nil {
+				p.Add(dedup)
+			}
+			return
+		},
+		"Drop samples whose values differ from the last forwarded sample by at most the given tolerance. "+
+			"With tags=true, the tags must also be identical. If interval is set, an unchanged sample is forwarded at least once per given duration.",
+		reg.OptionalParams("tolerance", "interval", "tags"))
+}
+
+// DeduplicationProcessor drops samples that repeat the values of the last forwarded sample.
+// Values are considered equal, if their absolute difference is at most Tolerance.
+type DeduplicationProcessor struct {
+	bitflow.NoopProcessor
+	Tolerance   float64
+	MaxInterval time.Duration // If > 0, forward unchanged samples after this duration (based on sample timestamps)
+	CompareTags bool
+
+	checker  bitflow.HeaderChecker
+	last     *bitflow.Sample
+	lastTags string
+	dropped  int
+}
+
+func (d *DeduplicationProcessor) String() string {
+	res := fmt.Sprintf("Drop duplicate samples (tolerance %v", d.Tolerance)
+	if d.MaxInterval > 0 {
+		res += fmt.Sprintf(", forward at least every %v", d.MaxInterval)
+	}
+	if d.CompareTags {
+		res += ", compare tags"
+	}
+	return res + ")"
+}
+
+func (d *DeduplicationProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if !d.checker.HeaderChanged(header) && d.isDuplicate(sample) {
+		d.dropped++
+		return nil
+	}
+	d.last = sample.DeepClone() // Follow-up steps might modify the sample in-place
+	if d.CompareTags {
+		d.lastTags = sample.TagString()
+	}
+	return d.NoopProcessor.Sample(sample, header)
+}
+
+func (d *DeduplicationProcessor) isDuplicate(sample *bitflow.Sample) bool {
+	last := d.last
+	if last == nil || len(last.Values) != len(sample.Values) {
+		return false
+	}
+	if d.MaxInterval > 0 && sample.Time.Sub(last.Time) >= d.MaxInterval {
+		return false
+	}
+	if d.CompareTags && sample.TagString() != d.lastTags {
+		return false
+	}
+	for i, val := range sample.Values {
+		lastVal := last.Values[i]
+		if val != lastVal && !(math.Abs(float64(val-lastVal)) <= d.Tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// DroppedSamples returns the number of samples that were dropped as duplicates.
+func (d *DeduplicationProcessor) DroppedSamples() int {
+	return d.dropped
+}
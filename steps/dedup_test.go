@@ -0,0 +1,83 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type dedupTestSuite struct {
+	testsupport.Suite
+}
+
+func TestDedup(t *testing.T) {
+	suite.Run(t, new(dedupTestSuite))
+}
+
+var dedupTestHeader = &bitflow.Header{Fields: []string{"a", "b"}}
+
+func (s *dedupTestSuite) TestExactDuplicates() {
+	dedup := new(DeduplicationProcessor)
+	out := s.Process(dedup, dedupTestHeader,
+		testsupport.NewSample(0, "", 1, 2),
+		testsupport.NewSample(time.Second, "", 1, 2),
+		testsupport.NewSample(2*time.Second, "", 1, 3),
+		testsupport.NewSample(3*time.Second, "", 1, 2))
+	out.AssertValues(s.T(), [][]float64{{1, 2}, {1, 3}, {1, 2}})
+	s.Equal(1, dedup.DroppedSamples())
+}
+
+func (s *dedupTestSuite) TestTolerance() {
+	out := s.Process(&DeduplicationProcessor{Tolerance: 0.5}, dedupTestHeader,
+		testsupport.NewSample(0, "", 1, 2),
+		testsupport.NewSample(time.Second, "", 1.4, 2.5),
+		testsupport.NewSample(2*time.Second, "", 1.6, 2),
+		testsupport.NewSample(3*time.Second, "", 2, 2))
+	// Samples are compared with the last forwarded sample, not with the last received one
+	out.AssertValues(s.T(), [][]float64{{1, 2}, {1.6, 2}})
+}
+
+func (s *dedupTestSuite) TestMaxInterval() {
+	var samples []*bitflow.Sample
+	for i := 0; i < 6; i++ {
+		samples = append(samples, testsupport.NewSample(time.Duration(i)*time.Second, "", 1, 1))
+	}
+	out := s.Process(&DeduplicationProcessor{MaxInterval: 2 * time.Second}, dedupTestHeader, samples...)
+	s.Equal([]time.Duration{0, 2 * time.Second, 4 * time.Second}, out.Offsets())
+}
+
+func (s *dedupTestSuite) TestTagsAndHeader() {
+	out := s.Process(&DeduplicationProcessor{CompareTags: true}, dedupTestHeader,
+		testsupport.NewSample(0, "host=a", 1, 1),
+		testsupport.NewSample(0, "host=b", 1, 1),
+		testsupport.NewSample(0, "host=b", 1, 1))
+	s.Equal([]string{"a", "b"}, out.Tags("host"))
+
+	out = s.Process(new(DeduplicationProcessor), dedupTestHeader,
+		testsupport.NewSample(0, "host=a", 1, 1),
+		testsupport.NewSample(0, "host=b", 1, 1))
+	out.AssertCount(s.T(), 1)
+
+	// A header change always forwards the next sample
+	dedup := new(DeduplicationProcessor)
+	sink := testsupport.NewCapturingSink()
+	dedup.SetSink(sink)
+	s.NoError(dedup.Sample(testsupport.NewSample(0, "", 1, 1), dedupTestHeader))
+	s.NoError(dedup.Sample(testsupport.NewSample(0, "", 1, 1), &bitflow.Header{Fields: []string{"c", "d"}}))
+	sink.AssertCount(s.T(), 2)
+}
+
+func (s *dedupTestSuite) TestModifiedOutput() {
+	// Follow-up steps modifying the forwarded sample must not influence the comparison
+	dedup := new(DeduplicationProcessor)
+	sink := testsupport.NewCapturingSink()
+	dedup.SetSink(sink)
+	first := testsupport.NewSample(0, "", 1, 1)
+	s.NoError(dedup.Sample(first, dedupTestHeader))
+	first.Values[0] = 5
+	s.NoError(dedup.Sample(testsupport.NewSample(0, "", 1, 1), dedupTestHeader))
+	sink.AssertCount(s.T(), 1)
+}
@@ -0,0 +1,132 @@
+// Package docker provides a data source emitting resource usage metrics of Docker containers. The statistics are
+// queried through the HTTP API of the Docker daemon, either through its unix socket or through TCP.
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultDaemon = "unix:///var/run/docker.sock"
+
+// Client is a minimal client for the Docker Engine API.
+type Client struct {
+	baseUrl string
+	client  *http.Client
+}
+
+// NewClient creates a client for the given daemon address, which is either unix:///path/to/socket,
+// tcp://host:port or http://host:port.
+func NewClient(daemon string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(daemon)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Docker daemon address '%v': %v", daemon, err)
+	}
+	transport := new(http.Transport)
+	c := &Client{client: &http.Client{Transport: transport, Timeout: timeout}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		c.baseUrl = "http://docker"
+	case "tcp", "http":
+		c.baseUrl = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("Unsupported Docker daemon address '%v', expected unix://, tcp:// or http://", daemon)
+	}
+	return c, nil
+}
+
+func (c *Client) get(path string, result interface{}) error {
+	resp, err := c.client.Get(c.baseUrl + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GET %v returned status %v: %v", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Container is an entry of the container list.
+type Container struct {
+	Id    string
+	Names []string
+	Image string
+	State string
+}
+
+// Name returns the primary name of the container, without the leading slash.
+func (c *Container) Name() string {
+	if len(c.Names) == 0 {
+		return ShortId(c.Id)
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// ShortId returns the abbreviated container id, as shown by the docker CLI.
+func ShortId(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// ListContainers returns the running containers.
+func (c *Client) ListContainers() ([]Container, error) {
+	var containers []Container
+	err := c.get("/containers/json", &containers)
+	return containers, err
+}
+
+// Stats contains the subset of the container statistics that is used by the Source.
+type Stats struct {
+	CpuStats struct {
+		CpuUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	PidsStats struct {
+		Current uint64 `json:"current"`
+	} `json:"pids_stats"`
+	Networks map[string]struct {
+		RxBytes   uint64 `json:"rx_bytes"`
+		RxPackets uint64 `json:"rx_packets"`
+		RxErrors  uint64 `json:"rx_errors"`
+		RxDropped uint64 `json:"rx_dropped"`
+		TxBytes   uint64 `json:"tx_bytes"`
+		TxPackets uint64 `json:"tx_packets"`
+		TxErrors  uint64 `json:"tx_errors"`
+		TxDropped uint64 `json:"tx_dropped"`
+	} `json:"networks"`
+	BlkioStats struct {
+		IoServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+// ContainerStats returns a single snapshot of the statistics of a container.
+func (c *Client) ContainerStats(id string) (*Stats, error) {
+	stats := new(Stats)
+	err := c.get("/containers/"+url.PathEscape(id)+"/stats?stream=false&one-shot=true", stats)
+	return stats, err
+}
@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type dockerTestSuite struct {
+	suite.Suite
+}
+
+func TestDocker(t *testing.T) {
+	suite.Run(t, new(dockerTestSuite))
+}
+
+// fakeDaemon serves the container list and statistics of the Docker API
+type fakeDaemon struct {
+	lock       sync.Mutex
+	containers string
+	cpu        int
+	rxBytes    int
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	switch {
+	case r.URL.Path == "/containers/json":
+		fmt.Fprint(w, d.containers)
+	case strings.HasPrefix(r.URL.Path, "/containers/aaaaaaaaaaaaaaaa/stats"):
+		fmt.Fprintf(w, `{
+			"cpu_stats": {"cpu_usage": {"total_usage": %v}},
+			"memory_stats": {"usage": 3000, "limit": 10000, "stats": {"inactive_file": 1000}},
+			"pids_stats": {"current": 7},
+			"networks": {"eth0": {"rx_bytes": %v, "tx_bytes": 10}, "eth1": {"rx_bytes": 500}},
+			"blkio_stats": {"io_service_bytes_recursive": [{"op": "Read", "value": 100}, {"op": "Write", "value": 200}]}
+		}`, d.cpu, d.rxBytes)
+	default:
+		http.Error(w, "No such container", http.StatusNotFound)
+	}
+}
+
+func (s *dockerTestSuite) TestCollect() {
+	daemon := &fakeDaemon{containers: `[
+		{"Id": "aaaaaaaaaaaaaaaa", "Names": ["/web"], "Image": "nginx:latest", "State": "running"},
+		{"Id": "bbbbbbbbbbbbbbbb", "Names": ["/gone"], "Image": "busybox", "State": "running"},
+		{"Id": "cccccccccccccccc", "Names": ["/excluded"], "Image": "busybox", "State": "running"}
+	]`}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	source, err := NewSource("tcp://" + strings.TrimPrefix(server.URL, "http://") + "?exclude=^excl&interval=2s")
+	s.Require().NoError(err)
+	s.Equal(2*time.Second, source.Interval)
+
+	samples, err := source.Collect()
+	s.NoError(err)
+	s.Empty(samples)
+	s.Len(source.containers, 1)
+
+	// Simulate one second between the queries: 0.5 seconds of CPU time and 2000 received bytes
+	source.containers["aaaaaaaaaaaaaaaa"].time = source.containers["aaaaaaaaaaaaaaaa"].time.Add(-time.Second)
+	daemon.lock.Lock()
+	daemon.cpu, daemon.rxBytes = 5e8, 2000
+	daemon.lock.Unlock()
+	samples, err = source.Collect()
+	s.NoError(err)
+	s.Require().Len(samples, 1)
+	sample := samples[0]
+	s.Equal(map[string]string{ContainerIdTag: "aaaaaaaaaaaa", ContainerNameTag: "web", ImageTag: "nginx:latest"}, sample.TagMap())
+
+	values := make(map[string]bitflow.Value)
+	for i, field := range source.header.Fields {
+		values[field] = sample.Values[i]
+	}
+	s.InDelta(50, float64(values["cpu"]), 1)
+	s.InDelta(2000, float64(values["net/rx-bytes"]), 20)
+	s.Equal(bitflow.Value(2000), values["mem"])
+	s.Equal(bitflow.Value(20), values["mem/percent"])
+	s.Equal(bitflow.Value(7), values["pids"])
+	s.Equal(bitflow.Value(0), values["disk/read-bytes"])
+
+	// Stopped containers are forgotten
+	daemon.lock.Lock()
+	daemon.containers = "[]"
+	daemon.lock.Unlock()
+	samples, err = source.Collect()
+	s.NoError(err)
+	s.Empty(samples)
+	s.Empty(source.containers)
+
+	server.Close()
+	_, err = source.Collect()
+	s.Error(err)
+}
+
+func (s *dockerTestSuite) TestParameters() {
+	source, err := NewSource("-")
+	s.Require().NoError(err)
+	s.Equal(DefaultDaemon, source.Daemon)
+	s.Equal(DefaultInterval, source.Interval)
+
+	source, err = NewSource("interval=3s&include=web")
+	s.Require().NoError(err)
+	s.Equal(DefaultDaemon, source.Daemon)
+	s.Equal(3*time.Second, source.Interval)
+	s.True(source.Include.MatchString("web-1"))
+
+	_, err = NewSource("ftp://host")
+	s.EqualError(err, "Unsupported Docker daemon address 'ftp://host', expected unix://, tcp:// or http://")
+	_, err = NewSource("-?other=1")
+	s.Error(err)
+	_, err = NewSource("unix:///var/run/docker.sock?other=1")
+	s.EqualError(err, "Unexpected parameter for docker endpoint: other")
+}
@@ -0,0 +1,277 @@
+package docker
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	DockerEndpoint = bitflow.EndpointType("docker")
+
+	DefaultInterval = time.Second
+
+	ContainerIdTag   = "container-id"
+	ContainerNameTag = "container"
+	ImageTag         = "image"
+)
+
+var containerMetrics = []string{
+	"cpu", "mem", "mem/limit", "mem/percent", "pids",
+	"net/rx-bytes", "net/tx-bytes", "net/rx-packets", "net/tx-packets", "net/errors", "net/dropped",
+	"disk/read-bytes", "disk/write-bytes",
+}
+
+type containerState struct {
+	time     time.Time
+	counters map[string]float64
+}
+
+// Source periodically queries the statistics of all running containers and emits one sample per container,
+// tagged with the container id, name and image. Started and stopped containers are discovered automatically.
+// Counters are converted to rates per second, so the first query of a container does not produce a sample.
+type Source struct {
+	bitflow.AbstractSampleSource
+
+	Daemon   string
+	Interval time.Duration
+
+	// Include and Exclude optionally select the containers by name
+	Include, Exclude *regexp.Regexp
+
+	client     *Client
+	task       *golib.LoopTask
+	header     *bitflow.Header
+	containers map[string]*containerState
+}
+
+func (s *Source) String() string {
+	return fmt.Sprintf("Docker containers of %v (every %v)", s.Daemon, s.Interval)
+}
+
+func (s *Source) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			samples, err := s.Collect()
+			if err != nil {
+				// The daemon might be temporarily unavailable, keep trying
+				s.Log().Errorln(err)
+			}
+			for _, sample := range samples {
+				if err := s.GetSink().Sample(sample, s.header); err != nil {
+					return err
+				}
+			}
+			stop.WaitTimeout(s.Interval)
+			return nil
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *Source) Close() {
+	s.task.Stop()
+}
+
+type containerResult struct {
+	container Container
+	stats     *Stats
+	time      time.Time
+	err       error
+}
+
+// Collect queries the statistics of all running containers in parallel and returns one sample for every container
+// that was already known in the previous query.
+func (s *Source) Collect() ([]*bitflow.Sample, error) {
+	containers, err := s.client.ListContainers()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list containers: %v", err)
+	}
+	if s.header == nil {
+		s.header = &bitflow.Header{Fields: containerMetrics}
+	}
+	if s.containers == nil {
+		s.containers = make(map[string]*containerState)
+	}
+
+	var results []*containerResult
+	var wg sync.WaitGroup
+	for _, container := range containers {
+		name := container.Name()
+		if (container.State != "" && container.State != "running") ||
+			(s.Include != nil && !s.Include.MatchString(name)) || (s.Exclude != nil && s.Exclude.MatchString(name)) {
+			continue
+		}
+		result := &containerResult{container: container}
+		results = append(results, result)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result.stats, result.err = s.client.ContainerStats(result.container.Id)
+			result.time = time.Now()
+		}()
+	}
+	wg.Wait()
+
+	var samples []*bitflow.Sample
+	running := make(map[string]bool, len(results))
+	for _, result := range results {
+		id := result.container.Id
+		if result.err != nil {
+			// The container might have stopped in the meantime
+			s.Log().Debugf("Failed to query stats of container %v: %v", result.container.Name(), result.err)
+			continue
+		}
+		running[id] = true
+		if _, known := s.containers[id]; !known {
+			s.Log().Infof("Discovered container %v (%v)", result.container.Name(), ShortId(id))
+		}
+		if sample := s.containerSample(result); sample != nil {
+			samples = append(samples, sample)
+		}
+	}
+	for id := range s.containers {
+		if !running[id] {
+			s.Log().Infof("Container %v has stopped", ShortId(id))
+			delete(s.containers, id)
+		}
+	}
+	return samples, nil
+}
+
+func (s *Source) containerSample(result *containerResult) *bitflow.Sample {
+	stats := result.stats
+	counters := map[string]float64{
+		"cpu": float64(stats.CpuStats.CpuUsage.TotalUsage) / 1e7, // Nanoseconds to percent of one core
+	}
+	for _, net := range stats.Networks {
+		counters["net/rx-bytes"] += float64(net.RxBytes)
+		counters["net/tx-bytes"] += float64(net.TxBytes)
+		counters["net/rx-packets"] += float64(net.RxPackets)
+		counters["net/tx-packets"] += float64(net.TxPackets)
+		counters["net/errors"] += float64(net.RxErrors + net.TxErrors)
+		counters["net/dropped"] += float64(net.RxDropped + net.TxDropped)
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			counters["disk/read-bytes"] += float64(entry.Value)
+		case "write":
+			counters["disk/write-bytes"] += float64(entry.Value)
+		}
+	}
+
+	id := result.container.Id
+	previous, known := s.containers[id]
+	s.containers[id] = &containerState{time: result.time, counters: counters}
+	if !known {
+		return nil
+	}
+
+	// Like the docker CLI, do not count the page cache as used memory (total_inactive_file in cgroup v1, inactive_file in cgroup v2)
+	mem := float64(stats.MemoryStats.Usage)
+	if cache, ok := stats.MemoryStats.Stats["total_inactive_file"]; ok {
+		mem -= float64(cache)
+	} else if cache, ok := stats.MemoryStats.Stats["inactive_file"]; ok {
+		mem -= float64(cache)
+	}
+	memPercent := 0.0
+	if limit := float64(stats.MemoryStats.Limit); limit > 0 {
+		memPercent = 100 * mem / limit
+	}
+	gauges := map[string]float64{
+		"mem":         mem,
+		"mem/limit":   float64(stats.MemoryStats.Limit),
+		"mem/percent": memPercent,
+		"pids":        float64(stats.PidsStats.Current),
+	}
+
+	elapsed := result.time.Sub(previous.time).Seconds()
+	sample := &bitflow.Sample{Time: result.time, Values: make([]bitflow.Value, len(containerMetrics))}
+	for i, name := range containerMetrics {
+		value, isGauge := gauges[name]
+		if !isGauge {
+			value = 0
+			if last := previous.counters[name]; elapsed > 0 && counters[name] >= last {
+				value = (counters[name] - last) / elapsed
+			}
+		}
+		sample.Values[i] = bitflow.Value(value)
+	}
+	sample.SetTag(ContainerIdTag, ShortId(id))
+	sample.SetTag(ContainerNameTag, result.container.Name())
+	sample.SetTag(ImageTag, result.container.Image)
+	return sample
+}
+
+// RegisterDockerSource registers the docker:// data source. The target is the address of the Docker daemon,
+// optionally extended with the query parameters interval, include and exclude, e.g.:
+//
+//	docker://unix:///var/run/docker.sock?interval=5s&exclude=^k8s_POD
+//	docker://tcp://10.0.0.1:2375
+//
+// The target '-' connects to the default unix socket.
+func RegisterDockerSource(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[DockerEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		return NewSource(target)
+	}
+}
+
+// NewSource parses the docker:// endpoint target, see RegisterDockerSource.
+func NewSource(target string) (*Source, error) {
+	if target == "-" {
+		target = DefaultDaemon
+	} else if !strings.Contains(target, "://") {
+		// Allow docker://?interval=5s or docker://interval=5s for the default daemon
+		target = DefaultDaemon + "?" + strings.TrimPrefix(target, "?")
+	}
+	uri, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Docker daemon address '%v': %v", target, err)
+	}
+	params := make(map[string]string)
+	for key, values := range uri.Query() {
+		params[key] = strings.Join(values, ",")
+	}
+	uri.RawQuery = ""
+
+	source := &Source{Daemon: uri.String()}
+	source.Interval = reg.DurationParam(params, "interval", DefaultInterval, true, &err)
+	include := reg.StrParam(params, "include", "", true, &err)
+	exclude := reg.StrParam(params, "exclude", "", true, &err)
+	if err != nil {
+		return nil, err
+	}
+	for key := range params {
+		if key != "interval" && key != "include" && key != "exclude" {
+			return nil, fmt.Errorf("Unexpected parameter for %v endpoint: %v", DockerEndpoint, key)
+		}
+	}
+	if source.Interval <= 0 {
+		return nil, reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+	}
+	if include != "" {
+		if source.Include, err = regexp.Compile(include); err != nil {
+			return nil, reg.ParameterError("include", err)
+		}
+	}
+	if exclude != "" {
+		if source.Exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, reg.ParameterError("exclude", err)
+		}
+	}
+	source.client, err = NewClient(source.Daemon, source.Interval+5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
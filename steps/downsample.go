@@ -0,0 +1,125 @@
+package steps
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterDownsampling(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("downsample",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			downsampler := &Downsampler{
+				Factor:      reg.IntParam(params, "factor", 0, false, &err),
+				Aggregation: reg.StrParam(params, "agg", "avg", true, &err),
+			}
+			if err == nil {
+				if downsampler.Factor < 1 {
+					err = reg.ParameterError("factor", fmt.Errorf("Must be positive"))
+				} else if downsampler.aggregate, err = GetWindowAggregationFunc(downsampler.Aggregation); err != nil {
+					err = reg.ParameterError("agg", err)
+				} else {
+					p.Add(downsampler)
+				}
+			}
+			return
+		},
+		"Reduce the sample rate by aggregating every given number of samples into one sample (agg: avg, sum, min, max, count, first, last, or percentiles like p95). "+
+			"Samples with different tags are aggregated separately, and the tags are preserved.",
+		reg.RequiredParams("factor"), reg.OptionalParams("agg"))
+}
+
+// Downsampler aggregates every Factor consecutive samples with equal tags into one sample, using one of the
+// WindowAggregationFuncs. The output sample has the timestamp of the last aggregated sample.
+// Incomplete groups are flushed when the header changes and when the stream ends.
+type Downsampler struct {
+	bitflow.NoopProcessor
+	Factor      int
+	Aggregation string
+
+	checker   bitflow.HeaderChecker
+	groups    map[string]*downsampleGroup
+	aggregate WindowAggregationFunc
+}
+
+type downsampleGroup struct {
+	values [][]float64 // Values of the collected samples, indexed by metric
+	last   *bitflow.Sample
+	num    int
+}
+
+func (d *Downsampler) String() string {
+	return fmt.Sprintf("Downsample by factor %v (%v)", d.Factor, d.Aggregation)
+}
+
+func (d *Downsampler) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if oldHeader := d.checker.LastHeader; d.checker.InitializedHeaderChanged(header) {
+		if err := d.flushAll(oldHeader); err != nil {
+			return err
+		}
+	}
+	if d.groups == nil {
+		d.groups = make(map[string]*downsampleGroup)
+	}
+	key := sample.TagString()
+	group, ok := d.groups[key]
+	if !ok {
+		group = &downsampleGroup{values: make([][]float64, len(header.Fields))}
+		d.groups[key] = group
+	}
+	for i, val := range sample.Values {
+		group.values[i] = append(group.values[i], float64(val))
+	}
+	group.last = sample
+	group.num++
+	if group.num >= d.Factor {
+		delete(d.groups, key)
+		return d.flush(group, header)
+	}
+	return nil
+}
+
+func (d *Downsampler) Close() {
+	if err := d.flushAll(d.checker.LastHeader); err != nil {
+		d.Error(err)
+	}
+	d.NoopProcessor.Close()
+}
+
+func (d *Downsampler) flushAll(header *bitflow.Header) error {
+	groups := make([]*downsampleGroup, 0, len(d.groups))
+	for _, group := range d.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].last.Time.Before(groups[j].last.Time)
+	})
+	d.groups = nil
+	for _, group := range groups {
+		if err := d.flush(group, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Downsampler) flush(group *downsampleGroup, header *bitflow.Header) error {
+	aggregate := d.aggregate
+	if aggregate == nil {
+		var err error
+		if aggregate, err = GetWindowAggregationFunc(d.Aggregation); err != nil {
+			return err
+		}
+		d.aggregate = aggregate
+	}
+	out := &bitflow.Sample{Values: make([]bitflow.Value, len(group.values))}
+	for i, values := range group.values {
+		if len(values) > 0 {
+			out.Values[i] = bitflow.Value(aggregate(values))
+		}
+	}
+	out.CopyMetadataFrom(group.last)
+	return d.NoopProcessor.Sample(out, header)
+}
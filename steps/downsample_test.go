@@ -0,0 +1,114 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type downsampleTestSuite struct {
+	testsupport.Suite
+}
+
+func TestDownsample(t *testing.T) {
+	suite.Run(t, new(downsampleTestSuite))
+}
+
+var downsampleTestHeader = &bitflow.Header{Fields: []string{"a", "b"}}
+
+func (s *downsampleTestSuite) downsampler(factor string, agg string) *Downsampler {
+	b := reg.NewProcessorRegistry()
+	RegisterDownsampling(b)
+	analysis, ok := b.GetAnalysis("downsample")
+	s.True(ok)
+	params := map[string]string{"factor": factor}
+	if agg != "" {
+		params["agg"] = agg
+	}
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, params))
+	s.Len(pipe.Processors, 1)
+	return pipe.Processors[0].(*Downsampler)
+}
+
+func (s *downsampleTestSuite) TestAggregations() {
+	samples := []*bitflow.Sample{
+		testsupport.NewSample(0, "", 3, 10),
+		testsupport.NewSample(time.Second, "", 1, 20),
+		testsupport.NewSample(2*time.Second, "", 4, 30),
+		testsupport.NewSample(3*time.Second, "", 2, 40),
+	}
+	for agg, expected := range map[string][]float64{
+		"":      {2.5, 25}, // avg is the default
+		"avg":   {2.5, 25},
+		"sum":   {10, 100},
+		"min":   {1, 10},
+		"max":   {4, 40},
+		"count": {4, 4},
+		"first": {3, 10},
+		"last":  {2, 40},
+		"p0":    {1, 10},
+		"p50":   {2.5, 25},
+		"p75":   {3.25, 32.5},
+		"p100":  {4, 40},
+	} {
+		out := s.Process(s.downsampler("4", agg), downsampleTestHeader, samples...)
+		s.Equal([][]float64{expected}, out.Values(), "Aggregation %v", agg)
+		s.Equal([]time.Duration{3 * time.Second}, out.Offsets(), "The output must have the timestamp of the last sample")
+	}
+}
+
+func (s *downsampleTestSuite) TestFactor() {
+	var samples []*bitflow.Sample
+	for i := 0; i < 7; i++ {
+		samples = append(samples, testsupport.NewSample(time.Duration(i)*time.Second, "", bitflow.Value(i), 1))
+	}
+	out := s.Process(s.downsampler("3", "sum"), downsampleTestHeader, samples...)
+	out.AssertValues(s.T(), [][]float64{{3, 3}, {12, 3}, {6, 1}}) // The incomplete last group is flushed on close
+	s.Equal([]time.Duration{2 * time.Second, 5 * time.Second, 6 * time.Second}, out.Offsets())
+
+	out = s.Process(s.downsampler("1", "max"), downsampleTestHeader, samples[:2]...)
+	out.AssertValues(s.T(), [][]float64{{0, 1}, {1, 1}})
+}
+
+func (s *downsampleTestSuite) TestTags() {
+	out := s.Process(s.downsampler("2", "avg"), downsampleTestHeader,
+		testsupport.NewSample(0, "host=a", 1, 1),
+		testsupport.NewSample(time.Second, "host=b", 10, 10),
+		testsupport.NewSample(2*time.Second, "host=a", 3, 3),
+		testsupport.NewSample(3*time.Second, "host=b", 30, 30),
+		testsupport.NewSample(4*time.Second, "host=b", 5, 5),
+		testsupport.NewSample(5*time.Second, "host=a", 7, 7))
+	out.AssertValues(s.T(), [][]float64{{2, 2}, {20, 20}, {5, 5}, {7, 7}})
+	s.Equal([]string{"a", "b", "b", "a"}, out.Tags("host"))
+}
+
+func (s *downsampleTestSuite) TestHeaderChange() {
+	step := s.downsampler("2", "sum")
+	out := testsupport.NewCapturingSink()
+	step.SetSink(out)
+	s.NoError(step.Sample(testsupport.NewSample(0, "", 1, 2), downsampleTestHeader))
+	newHeader := &bitflow.Header{Fields: []string{"c"}}
+	s.NoError(step.Sample(testsupport.NewSample(time.Second, "", 5), newHeader))
+	out.AssertValues(s.T(), [][]float64{{1, 2}})
+	s.True(out.Headers()[0] == downsampleTestHeader, "The flushed group must keep its previous header")
+	s.NoError(step.Sample(testsupport.NewSample(2*time.Second, "", 6), newHeader))
+	out.AssertValues(s.T(), [][]float64{{1, 2}, {11}})
+}
+
+func (s *downsampleTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterDownsampling(b)
+	analysis, _ := b.GetAnalysis("downsample")
+	for _, params := range []map[string]string{
+		{"factor": "0"},
+		{"factor": "2", "agg": "median"},
+		{"factor": "2", "agg": "p101"},
+	} {
+		s.Error(analysis.Func(new(bitflow.SamplePipeline), params), "%v", params)
+	}
+}
@@ -0,0 +1,179 @@
+// Package enrich provides a processing step that adds tags to samples by looking up the value of an existing tag
+// or field in an external source: a CSV file, an HTTP JSON API or reverse DNS.
+package enrich
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultTTL    = 10 * time.Minute
+	DefaultDnsTag = "hostname"
+)
+
+type cacheEntry struct {
+	tags    map[string]string
+	expires time.Time
+}
+
+// Enricher adds the tags returned by a Lookup to every sample. The lookup key is the value of the tag Tag or,
+// if Field is set, the value of that field. Results (including unknown keys and failed lookups) are cached for
+// TTL, so that the lookup source is queried at most once per key and TTL.
+type Enricher struct {
+	bitflow.NoopProcessor
+
+	Tag    string
+	Field  string
+	Lookup Lookup
+	TTL    time.Duration
+
+	// Prefix is prepended to the names of the added tags
+	Prefix string
+
+	// Overwrite allows replacing tags that are already present in the sample
+	Overwrite bool
+
+	checker    bitflow.HeaderChecker
+	fieldIndex int
+	now        func() time.Time
+	cache      map[string]*cacheEntry
+	failed     int
+}
+
+func (e *Enricher) String() string {
+	key := "tag " + e.Tag
+	if e.Field != "" {
+		key = "field " + e.Field
+	}
+	return fmt.Sprintf("Enrich tags by looking up %v in %v (cache ttl %v)", key, e.Lookup, e.TTL)
+}
+
+func (e *Enricher) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	key, ok := e.key(sample, header)
+	if ok {
+		for name, value := range e.lookup(key) {
+			name = e.Prefix + name
+			if e.Overwrite || !sample.HasTag(name) {
+				sample.SetTag(name, value)
+			}
+		}
+	}
+	return e.NoopProcessor.Sample(sample, header)
+}
+
+func (e *Enricher) Close() {
+	if e.failed > 0 {
+		log.Warnf("%v: %v lookup(s) failed", e, e.failed)
+	}
+	e.NoopProcessor.Close()
+}
+
+func (e *Enricher) key(sample *bitflow.Sample, header *bitflow.Header) (string, bool) {
+	if e.Field == "" {
+		key := sample.Tag(e.Tag)
+		return key, key != ""
+	}
+	if e.checker.HeaderChanged(header) {
+		e.fieldIndex = -1
+		for i, field := range header.Fields {
+			if field == e.Field {
+				e.fieldIndex = i
+			}
+		}
+	}
+	if e.fieldIndex < 0 || e.fieldIndex >= len(sample.Values) {
+		return "", false
+	}
+	return formatKey(sample.Values[e.fieldIndex]), true
+}
+
+func (e *Enricher) lookup(key string) map[string]string {
+	now := time.Now()
+	if e.now != nil {
+		now = e.now()
+	}
+	if entry, ok := e.cache[key]; ok && now.Before(entry.expires) {
+		return entry.tags
+	}
+	tags, err := e.Lookup.Lookup(key)
+	if err != nil {
+		// Cache the failure as well, to avoid querying a failing source for every sample
+		log.Warnf("%v: Failed to look up '%v': %v", e, key, err)
+		e.failed++
+	}
+	if e.cache == nil {
+		e.cache = make(map[string]*cacheEntry)
+	}
+	e.cache[key] = &cacheEntry{tags: tags, expires: now.Add(e.TTL)}
+	return tags
+}
+
+func RegisterEnrichment(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("enrich",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			enricher, err := NewEnricher(params)
+			if err == nil {
+				p.Add(enricher)
+			}
+			return err
+		},
+		"Add tags by looking up the value of a tag or field in a CSV file (first column is the key, the other columns are added as tags), "+
+			"an HTTP JSON API (${key} in the URL is replaced by the key, the fields of the returned object are added as tags), or reverse DNS",
+		reg.OptionalParams("tag", "field", "csv", "http", "dns", "dns-tag", "ttl", "timeout", "prefix", "overwrite"),
+		reg.ParamDetails("tag", reg.TypeString, "", "Tag containing the lookup key"),
+		reg.ParamDetails("field", reg.TypeString, "", "Field containing the lookup key (alternative to tag)"),
+		reg.ParamDetails("csv", reg.TypeString, "", "CSV file to look up the key in"),
+		reg.ParamDetails("http", reg.TypeString, "", "URL of a JSON API, containing the placeholder "+KeyPlaceholder),
+		reg.ParamDetails("dns", reg.TypeBool, "false", "Resolve the key (an IP address) through reverse DNS"),
+		reg.ParamDetails("dns-tag", reg.TypeString, DefaultDnsTag, "Tag receiving the result of the reverse DNS lookup"),
+		reg.ParamDetails("ttl", reg.TypeDuration, DefaultTTL.String(), "Time to cache the result of a lookup"),
+		reg.ParamDetails("timeout", reg.TypeDuration, DefaultTimeout.String(), "Timeout for HTTP and DNS lookups"),
+		reg.ParamDetails("prefix", reg.TypeString, "", "Prefix for the names of the added tags"),
+		reg.ParamDetails("overwrite", reg.TypeBool, "true", "Whether to replace tags that are already present in the sample"))
+}
+
+// NewEnricher creates an Enricher from the parameters of the enrich step.
+func NewEnricher(params map[string]string) (*Enricher, error) {
+	var err error
+	enricher := &Enricher{
+		Tag:       reg.StrParam(params, "tag", "", true, &err),
+		Field:     reg.StrParam(params, "field", "", true, &err),
+		TTL:       reg.DurationParam(params, "ttl", DefaultTTL, true, &err),
+		Prefix:    reg.StrParam(params, "prefix", "", true, &err),
+		Overwrite: reg.BoolParam(params, "overwrite", true, true, &err),
+	}
+	csvFile := reg.StrParam(params, "csv", "", true, &err)
+	httpUrl := reg.StrParam(params, "http", "", true, &err)
+	dns := reg.BoolParam(params, "dns", false, true, &err)
+	dnsTag := reg.StrParam(params, "dns-tag", DefaultDnsTag, true, &err)
+	timeout := reg.DurationParam(params, "timeout", DefaultTimeout, true, &err)
+	if err != nil {
+		return nil, err
+	}
+	if (enricher.Tag == "") == (enricher.Field == "") {
+		return nil, fmt.Errorf("Exactly one of the parameters 'tag' and 'field' must be defined")
+	}
+	sources := 0
+	if csvFile != "" {
+		enricher.Lookup = &CsvLookup{File: csvFile}
+		sources++
+	}
+	if httpUrl != "" {
+		enricher.Lookup = &HttpLookup{Url: httpUrl, Client: &http.Client{Timeout: timeout}}
+		sources++
+	}
+	if dns {
+		enricher.Lookup = &DnsLookup{Tag: dnsTag, Timeout: timeout}
+		sources++
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("Exactly one of the parameters 'csv', 'http' and 'dns' must be defined")
+	}
+	return enricher, nil
+}
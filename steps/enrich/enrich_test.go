@@ -0,0 +1,189 @@
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type enrichTestSuite struct {
+	suite.Suite
+}
+
+func TestEnrich(t *testing.T) {
+	suite.Run(t, new(enrichTestSuite))
+}
+
+type countingLookup struct {
+	queries int
+	result  map[string]map[string]string
+	err     error
+}
+
+func (l *countingLookup) String() string {
+	return "test lookup"
+}
+
+func (l *countingLookup) Lookup(key string) (map[string]string, error) {
+	l.queries++
+	return l.result[key], l.err
+}
+
+func (s *enrichTestSuite) enrich(enricher *Enricher, header *bitflow.Header, sample *bitflow.Sample) map[string]string {
+	enricher.SetSink(new(bitflow.DroppingSampleProcessor))
+	s.NoError(enricher.Sample(sample, header))
+	return sample.TagMap()
+}
+
+func (s *enrichTestSuite) TestEnricher() {
+	now := time.Unix(1000, 0)
+	lookup := &countingLookup{result: map[string]map[string]string{
+		"10.0.0.1": {"rack": "r1", "service": "db"},
+		"42":       {"owner": "alice"},
+	}}
+	enricher := &Enricher{Tag: "ip", Lookup: lookup, TTL: time.Minute, Prefix: "meta/", now: func() time.Time { return now }}
+	header := &bitflow.Header{Fields: []string{"id"}}
+
+	sample := &bitflow.Sample{Values: []bitflow.Value{42}}
+	sample.SetTag("ip", "10.0.0.1")
+	sample.SetTag("meta/rack", "old")
+	s.Equal(map[string]string{"ip": "10.0.0.1", "meta/rack": "old", "meta/service": "db"}, s.enrich(enricher, header, sample))
+
+	enricher.Overwrite = true
+	sample = &bitflow.Sample{}
+	sample.SetTag("ip", "10.0.0.1")
+	sample.SetTag("meta/rack", "old")
+	s.Equal(map[string]string{"ip": "10.0.0.1", "meta/rack": "r1", "meta/service": "db"}, s.enrich(enricher, header, sample))
+	s.Equal(1, lookup.queries, "The result should be cached")
+
+	// Unknown keys are cached as well, until the TTL expires
+	sample = &bitflow.Sample{}
+	sample.SetTag("ip", "10.0.0.2")
+	s.Equal(map[string]string{"ip": "10.0.0.2"}, s.enrich(enricher, header, sample))
+	s.enrich(enricher, header, sample)
+	s.Equal(2, lookup.queries)
+	now = now.Add(time.Minute)
+	s.enrich(enricher, header, sample)
+	s.Equal(3, lookup.queries)
+
+	// Samples without key are forwarded unchanged
+	s.Equal(map[string]string{}, s.enrich(enricher, header, &bitflow.Sample{}))
+	s.Equal(3, lookup.queries)
+
+	// Failed lookups are logged and cached
+	lookup.err = fmt.Errorf("Unavailable")
+	sample = &bitflow.Sample{}
+	sample.SetTag("ip", "10.0.0.3")
+	s.enrich(enricher, header, sample)
+	s.enrich(enricher, header, sample)
+	s.Equal(4, lookup.queries)
+	s.Equal(1, enricher.failed)
+
+	// Look up field values
+	lookup.err = nil
+	enricher = &Enricher{Field: "id", Lookup: lookup, TTL: time.Minute}
+	s.Equal(map[string]string{"owner": "alice"}, s.enrich(enricher, header, &bitflow.Sample{Values: []bitflow.Value{42}}))
+	s.Equal(map[string]string{}, s.enrich(enricher, &bitflow.Header{Fields: []string{"other"}}, &bitflow.Sample{Values: []bitflow.Value{42}}))
+}
+
+func (s *enrichTestSuite) TestCsvLookup() {
+	dir, err := ioutil.TempDir("", "enrich")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hosts.csv")
+	s.Require().NoError(ioutil.WriteFile(file, []byte("ip,rack,owner\n10.0.0.1, r1, alice\n10.0.0.2,r2\n"), 0644))
+
+	lookup := &CsvLookup{File: file}
+	tags, err := lookup.Lookup("10.0.0.1")
+	s.NoError(err)
+	s.Equal(map[string]string{"rack": "r1", "owner": "alice"}, tags)
+	tags, err = lookup.Lookup("10.0.0.2")
+	s.NoError(err)
+	s.Equal(map[string]string{"rack": "r2"}, tags)
+	tags, err = lookup.Lookup("10.0.0.3")
+	s.NoError(err)
+	s.Nil(tags)
+
+	// The file is read again after it was modified
+	s.Require().NoError(ioutil.WriteFile(file, []byte("ip,rack\n10.0.0.3,r3\n"), 0644))
+	s.Require().NoError(os.Chtimes(file, time.Now(), time.Now().Add(time.Hour)))
+	tags, err = lookup.Lookup("10.0.0.3")
+	s.NoError(err)
+	s.Equal(map[string]string{"rack": "r3"}, tags)
+
+	s.Require().NoError(ioutil.WriteFile(file, []byte("ip\n"), 0644))
+	s.Require().NoError(os.Chtimes(file, time.Now(), time.Now().Add(2*time.Hour)))
+	_, err = lookup.Lookup("10.0.0.3")
+	s.EqualError(err, file+" must contain a header row with at least two columns")
+}
+
+func (s *enrichTestSuite) TestHttpLookup() {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Query().Get("ip") {
+		case "10.0.0.1":
+			fmt.Fprint(w, `{"rack": "r1", "slots": 4, "active": true, "nested": {"a": 1}, "none": null}`)
+		case "broken":
+			http.Error(w, "Database down", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	lookup := &HttpLookup{Url: server.URL + "/hosts?ip=" + KeyPlaceholder}
+	tags, err := lookup.Lookup("10.0.0.1")
+	s.NoError(err)
+	s.Equal(map[string]string{"rack": "r1", "slots": "4", "active": "true"}, tags)
+	tags, err = lookup.Lookup("10.0.0.2")
+	s.NoError(err)
+	s.Nil(tags)
+	_, err = lookup.Lookup("broken")
+	s.EqualError(err, "Lookup of 'broken' returned status 503 Service Unavailable: Database down")
+	s.Equal(int32(3), atomic.LoadInt32(&requests))
+}
+
+func (s *enrichTestSuite) TestDnsLookup() {
+	lookup := &DnsLookup{Tag: DefaultDnsTag, lookupAddr: func(_ context.Context, addr string) ([]string, error) {
+		if addr == "10.0.0.1" {
+			return []string{"db1.example.com.", "db.example.com."}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}}
+	tags, err := lookup.Lookup("10.0.0.1")
+	s.NoError(err)
+	s.Equal(map[string]string{DefaultDnsTag: "db1.example.com"}, tags)
+	tags, err = lookup.Lookup("10.0.0.2")
+	s.NoError(err)
+	s.Nil(tags)
+	tags, err = lookup.Lookup("not-an-ip")
+	s.NoError(err)
+	s.Nil(tags)
+}
+
+func (s *enrichTestSuite) TestParameters() {
+	enricher, err := NewEnricher(map[string]string{"tag": "ip", "dns": "true", "ttl": "1m", "prefix": "dns/"})
+	s.Require().NoError(err)
+	s.Equal(&DnsLookup{Tag: DefaultDnsTag, Timeout: DefaultTimeout}, enricher.Lookup)
+	s.Equal(time.Minute, enricher.TTL)
+	s.Equal("Enrich tags by looking up tag ip in reverse DNS (cache ttl 1m0s)", enricher.String())
+
+	_, err = NewEnricher(map[string]string{"tag": "ip", "field": "id", "dns": "true"})
+	s.EqualError(err, "Exactly one of the parameters 'tag' and 'field' must be defined")
+	_, err = NewEnricher(map[string]string{"tag": "ip", "csv": "hosts.csv", "dns": "true"})
+	s.EqualError(err, "Exactly one of the parameters 'csv', 'http' and 'dns' must be defined")
+	_, err = NewEnricher(map[string]string{"tag": "ip"})
+	s.EqualError(err, "Exactly one of the parameters 'csv', 'http' and 'dns' must be defined")
+}
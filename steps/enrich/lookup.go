@@ -0,0 +1,180 @@
+package enrich
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+)
+
+const (
+	KeyPlaceholder = "${key}"
+	DefaultTimeout = 5 * time.Second
+)
+
+// Lookup resolves a key to a set of tags. A nil result without error means that the key is unknown.
+type Lookup interface {
+	fmt.Stringer
+	Lookup(key string) (map[string]string, error)
+}
+
+// CsvLookup reads the tags from a CSV file. The first row contains the column names, the first column contains
+// the keys and every other column is added as tag. The file is read again when it was modified.
+type CsvLookup struct {
+	File string
+
+	lock    sync.Mutex
+	modTime time.Time
+	rows    map[string]map[string]string
+}
+
+func (l *CsvLookup) String() string {
+	return "CSV file " + l.File
+}
+
+func (l *CsvLookup) Lookup(key string) (map[string]string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	info, err := os.Stat(l.File)
+	if err != nil {
+		return nil, err
+	}
+	if l.rows == nil || !info.ModTime().Equal(l.modTime) {
+		if err := l.load(); err != nil {
+			return nil, err
+		}
+		l.modTime = info.ModTime()
+	}
+	return l.rows[key], nil
+}
+
+func (l *CsvLookup) load() error {
+	file, err := os.Open(l.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("Failed to read %v: %v", l.File, err)
+	}
+	if len(records) == 0 || len(records[0]) < 2 {
+		return fmt.Errorf("%v must contain a header row with at least two columns", l.File)
+	}
+	columns := records[0]
+	rows := make(map[string]map[string]string, len(records)-1)
+	for _, record := range records[1:] {
+		tags := make(map[string]string, len(columns)-1)
+		for i := 1; i < len(columns) && i < len(record); i++ {
+			tags[columns[i]] = record[i]
+		}
+		rows[record[0]] = tags
+	}
+	l.rows = rows
+	return nil
+}
+
+// HttpLookup queries a JSON API. The placeholder ${key} in the URL is replaced with the (escaped) key. The response
+// must be a JSON object, whose string, number and boolean values are added as tags. The status 404 means that the
+// key is unknown.
+type HttpLookup struct {
+	Url    string
+	Client *http.Client
+}
+
+func (l *HttpLookup) String() string {
+	return "HTTP " + l.Url
+}
+
+func (l *HttpLookup) Lookup(key string) (map[string]string, error) {
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(strings.Replace(l.Url, KeyPlaceholder, url.QueryEscape(key), -1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Lookup of '%v' returned status %v: %v", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var object map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return nil, fmt.Errorf("Lookup of '%v' returned invalid JSON object: %v", key, err)
+	}
+	tags := make(map[string]string, len(object))
+	for name, value := range object {
+		switch v := value.(type) {
+		case string:
+			tags[name] = v
+		case float64:
+			tags[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			tags[name] = strconv.FormatBool(v)
+		}
+		// Nested objects, arrays and null values are ignored
+	}
+	return tags, nil
+}
+
+// DnsLookup resolves IP addresses to host names through reverse DNS lookups.
+type DnsLookup struct {
+	Tag     string
+	Timeout time.Duration
+
+	// lookupAddr can be replaced for testing
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+}
+
+func (l *DnsLookup) String() string {
+	return "reverse DNS"
+}
+
+func (l *DnsLookup) Lookup(key string) (map[string]string, error) {
+	if net.ParseIP(key) == nil {
+		return nil, nil
+	}
+	lookupAddr := l.lookupAddr
+	if lookupAddr == nil {
+		lookupAddr = net.DefaultResolver.LookupAddr
+	}
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	names, err := lookupAddr(ctx, key)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	return map[string]string{l.Tag: strings.TrimSuffix(names[0], ".")}, nil
+}
+
+// formatKey converts a field value to a lookup key
+func formatKey(value bitflow.Value) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 64)
+}
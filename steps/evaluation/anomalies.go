@@ -0,0 +1,230 @@
+package evaluation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/antongulenko/go-onlinestats"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// Types of anomalies injected by the AnomalyInjector
+const (
+	AnomalySpike   = "spike"   // A single sample is shifted by the magnitude
+	AnomalyShift   = "shift"   // All samples of the anomaly are shifted by the magnitude
+	AnomalyDropout = "dropout" // The metric is zero for all samples of the anomaly
+	AnomalyDrift   = "drift"   // The shift grows linearly until it reaches the magnitude in the last sample of the anomaly
+
+	DefaultAnomalyRate     = 0.01
+	DefaultAnomalyDuration = 10
+	DefaultAnomalySize     = 5
+	DefaultAnomalyTypeTag  = "anomaly_type"
+	DefaultAnomalyMetric   = "anomaly_metric"
+)
+
+var AllAnomalyTypes = []string{AnomalySpike, AnomalyShift, AnomalyDropout, AnomalyDrift}
+
+func RegisterAnomalyInjection(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("inject_anomalies",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &AnomalyInjector{
+				Rate:          reg.FloatParam(params, "rate", DefaultAnomalyRate, true, &err),
+				Duration:      reg.IntParam(params, "duration", DefaultAnomalyDuration, true, &err),
+				Size:          reg.FloatParam(params, "size", DefaultAnomalySize, true, &err),
+				Tag:           reg.StrParam(params, "tag", DefaultExpectedTag, true, &err),
+				PositiveValue: reg.StrParam(params, "positive", DefaultPositiveValue, true, &err),
+				NormalValue:   reg.StrParam(params, "normal", "", true, &err),
+				TypeTag:       reg.StrParam(params, "type_tag", DefaultAnomalyTypeTag, true, &err),
+				MetricTag:     reg.StrParam(params, "metric_tag", DefaultAnomalyMetric, true, &err),
+				Seed:          int64(reg.IntParam(params, "seed", 1, true, &err)),
+			}
+			types := reg.StrParam(params, "types", strings.Join(AllAnomalyTypes, ","), true, &err)
+			metrics := reg.StrParam(params, "metrics", "", true, &err)
+			if err != nil {
+				return
+			}
+			step.Types = strings.Split(types, ",")
+			for _, anomalyType := range step.Types {
+				if !isAnomalyType(anomalyType) {
+					return reg.ParameterError("types", fmt.Errorf("Unknown anomaly type '%v' (allowed: %v)", anomalyType, strings.Join(AllAnomalyTypes, ", ")))
+				}
+			}
+			if metrics != "" {
+				if step.Metrics, err = regexp.Compile(metrics); err != nil {
+					return reg.ParameterError("metrics", err)
+				}
+			}
+			switch {
+			case step.Rate <= 0 || step.Rate > 1:
+				err = reg.ParameterError("rate", fmt.Errorf("Must be in ]0..1]"))
+			case step.Duration < 1:
+				err = reg.ParameterError("duration", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		fmt.Sprintf("Inject synthetic anomalies into the stream. Every sample starts a new anomaly with the probability given by rate (default %v), unless an anomaly is already active. ", DefaultAnomalyRate)+
+			fmt.Sprintf("The types parameter is a comma-separated list of the injected anomaly types (default all): %v (single sample), %v (constant offset), ", AnomalySpike, AnomalyShift)+
+			fmt.Sprintf("%v (metric drops to zero) and %v (linearly growing offset). Except for spikes, anomalies last for the given duration in samples (default %v). ", AnomalyDropout, AnomalyDrift, DefaultAnomalyDuration)+
+			fmt.Sprintf("Every anomaly affects one random metric matching the metrics regex (default all metrics). The offset is size (default %v) times the running standard deviation of that metric. ", DefaultAnomalySize)+
+			fmt.Sprintf("Affected samples receive the positive value (default '%v') in the tag (default '%v'), the anomaly type in type_tag (default '%v') and the metric name in metric_tag (default '%v'). ", DefaultPositiveValue, DefaultExpectedTag, DefaultAnomalyTypeTag, DefaultAnomalyMetric)+
+			"If the normal parameter is given, it is set in the tag of all other samples. The random numbers are generated with the given seed (default 1).",
+		reg.OptionalParams("rate", "types", "duration", "size", "metrics", "tag", "positive", "normal", "type_tag", "metric_tag", "seed"))
+}
+
+func isAnomalyType(anomalyType string) bool {
+	for _, known := range AllAnomalyTypes {
+		if anomalyType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// AnomalyInjector modifies the values of random metrics to simulate anomalies and labels the affected samples with the ground truth.
+// At most one anomaly is active at a time. The magnitude of an anomaly is Size times the standard deviation of the unmodified values
+// of the affected metric (or its mean, if all values were equal, or 1, if all values were zero).
+// A metric is only affected by anomalies after at least two values have been observed. When the header changes, the statistics
+// are reset and an active anomaly is aborted.
+type AnomalyInjector struct {
+	bitflow.NoopProcessor
+	Rate          float64 // Probability of a sample starting an anomaly
+	Types         []string
+	Duration      int // In samples, not applied to spikes
+	Size          float64
+	Metrics       *regexp.Regexp // Optional, matches the metrics that can be affected
+	Tag           string
+	PositiveValue string
+	NormalValue   string // Optional
+	TypeTag       string
+	MetricTag     string
+	Seed          int64
+
+	random   *rand.Rand
+	checker  bitflow.HeaderChecker
+	fields   []int // Indices of the metrics that can be affected
+	stats    []onlinestats.Running
+	active   *injectedAnomaly
+	injected map[string]int
+}
+
+type injectedAnomaly struct {
+	anomalyType string
+	field       int
+	length      int
+	index       int
+	magnitude   float64
+}
+
+func (a *injectedAnomaly) apply(value bitflow.Value) bitflow.Value {
+	switch a.anomalyType {
+	case AnomalyDropout:
+		return 0
+	case AnomalyDrift:
+		return value + bitflow.Value(a.magnitude*float64(a.index+1)/float64(a.length))
+	default:
+		return value + bitflow.Value(a.magnitude)
+	}
+}
+
+func (p *AnomalyInjector) String() string {
+	res := fmt.Sprintf("Inject anomalies (rate %v, types %v, duration %v, size %v", p.Rate, strings.Join(p.Types, ","), p.Duration, p.Size)
+	if p.Metrics != nil {
+		res += fmt.Sprintf(", metrics %v", p.Metrics)
+	}
+	return res + fmt.Sprintf(", tag %v)", p.Tag)
+}
+
+func (p *AnomalyInjector) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.random == nil {
+		p.random = rand.New(rand.NewSource(p.Seed))
+		p.injected = make(map[string]int)
+	}
+	if p.checker.HeaderChanged(header) {
+		p.updateHeader(header)
+	}
+	for _, field := range p.fields {
+		if val := float64(sample.Values[field]); !math.IsNaN(val) && !math.IsInf(val, 0) {
+			p.stats[field].Push(val)
+		}
+	}
+	if p.active == nil && p.random.Float64() < p.Rate {
+		p.active = p.startAnomaly()
+	}
+	if anomaly := p.active; anomaly != nil {
+		sample.Values[anomaly.field] = anomaly.apply(sample.Values[anomaly.field])
+		sample.SetTag(p.Tag, p.PositiveValue)
+		sample.SetTag(p.TypeTag, anomaly.anomalyType)
+		sample.SetTag(p.MetricTag, header.Fields[anomaly.field])
+		anomaly.index++
+		if anomaly.index >= anomaly.length {
+			p.active = nil
+		}
+	} else if p.NormalValue != "" {
+		sample.SetTag(p.Tag, p.NormalValue)
+	}
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *AnomalyInjector) Close() {
+	types := make([]string, 0, len(p.injected))
+	for anomalyType, count := range p.injected {
+		types = append(types, fmt.Sprintf("%v %v", count, anomalyType))
+	}
+	sort.Strings(types)
+	p.Log().Printf("Injected anomalies: %v", strings.Join(types, ", "))
+	p.NoopProcessor.Close()
+}
+
+func (p *AnomalyInjector) updateHeader(header *bitflow.Header) {
+	p.active = nil
+	p.fields = p.fields[:0]
+	p.stats = make([]onlinestats.Running, len(header.Fields))
+	for i, field := range header.Fields {
+		if p.Metrics == nil || p.Metrics.MatchString(field) {
+			p.fields = append(p.fields, i)
+		}
+	}
+}
+
+// startAnomaly returns nil, if no metric has enough values to determine the magnitude of an anomaly.
+func (p *AnomalyInjector) startAnomaly() *injectedAnomaly {
+	var candidates []int
+	for _, field := range p.fields {
+		if p.stats[field].Len() >= 2 {
+			candidates = append(candidates, field)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	anomaly := &injectedAnomaly{
+		anomalyType: p.Types[p.random.Intn(len(p.Types))],
+		field:       candidates[p.random.Intn(len(candidates))],
+		length:      p.Duration,
+	}
+	if anomaly.anomalyType == AnomalySpike {
+		anomaly.length = 1
+	}
+	stats := &p.stats[anomaly.field]
+	scale := stats.Stddev()
+	if scale == 0 {
+		scale = math.Abs(stats.Mean())
+	}
+	if scale == 0 {
+		scale = 1
+	}
+	anomaly.magnitude = p.Size * scale
+	if p.random.Intn(2) == 0 {
+		anomaly.magnitude = -anomaly.magnitude
+	}
+	p.injected[anomaly.anomalyType]++
+	return anomaly
+}
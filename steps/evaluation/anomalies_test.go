@@ -0,0 +1,70 @@
+package evaluation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type anomalyInjectionTestSuite struct {
+	testsupport.Suite
+}
+
+func TestAnomalyInjection(t *testing.T) {
+	suite.Run(t, new(anomalyInjectionTestSuite))
+}
+
+// inject sends num samples with values alternating around 10 and 100 through the injector.
+func (s *anomalyInjectionTestSuite) inject(injector *AnomalyInjector, num int) []*bitflow.Sample {
+	samples := make([]*bitflow.Sample, num)
+	for i := range samples {
+		sign := bitflow.Value(i%2*2 - 1)
+		samples[i] = testsupport.NewSample(0, "", 10+sign, 100+sign)
+	}
+	return s.Process(injector, &bitflow.Header{Fields: []string{"a", "b"}}, samples...).Samples()
+}
+
+func (s *anomalyInjectionTestSuite) TestInjection() {
+	injector := &AnomalyInjector{Rate: 0.05, Types: AllAnomalyTypes, Duration: 5, Size: 10, Tag: DefaultExpectedTag,
+		PositiveValue: DefaultPositiveValue, NormalValue: "normal", TypeTag: DefaultAnomalyTypeTag, MetricTag: DefaultAnomalyMetric, Seed: 1}
+	samples := s.inject(injector, 2000)
+	s.Len(samples, 2000)
+
+	for _, anomalyType := range AllAnomalyTypes {
+		s.True(injector.injected[anomalyType] > 0, "no %v anomalies injected", anomalyType)
+	}
+	for _, sample := range samples {
+		label := sample.Tag(DefaultExpectedTag)
+		if label == "normal" {
+			s.InDelta(10, float64(sample.Values[0]), 1)
+			s.InDelta(100, float64(sample.Values[1]), 1)
+			continue
+		}
+		s.Equal(DefaultPositiveValue, label)
+		index := map[string]int{"a": 0, "b": 1}[sample.Tag(DefaultAnomalyMetric)]
+		normal := 10.0
+		if index == 1 {
+			normal = 100
+		}
+		value := float64(sample.Values[index])
+		switch sample.Tag(DefaultAnomalyTypeTag) {
+		case AnomalyDropout:
+			s.Equal(0.0, value)
+		case AnomalySpike, AnomalyShift:
+			s.InDelta(10, math.Abs(value-normal), 1.5)
+		case AnomalyDrift:
+			s.True(math.Abs(value-normal) <= 11.5)
+		default:
+			s.Fail("unexpected anomaly type", sample.Tag(DefaultAnomalyTypeTag))
+		}
+	}
+
+	again := &AnomalyInjector{Rate: 0.05, Types: AllAnomalyTypes, Duration: 5, Size: 10, Tag: DefaultExpectedTag,
+		PositiveValue: DefaultPositiveValue, NormalValue: "normal", TypeTag: DefaultAnomalyTypeTag, MetricTag: DefaultAnomalyMetric, Seed: 1}
+	for i, sample := range s.inject(again, 2000) {
+		s.Equal(samples[i].Values, sample.Values)
+	}
+}
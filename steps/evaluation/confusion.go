@@ -0,0 +1,294 @@
+package evaluation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+// MissingClass is used as the predicted class of samples without the predicted tag.
+const MissingClass = "(none)"
+
+func RegisterConfusionMatrix(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("confusion_matrix",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &ClassEvaluation{
+				ExpectedTag:  reg.StrParam(params, "expected", DefaultExpectedTag, true, &err),
+				PredictedTag: reg.StrParam(params, "predicted", DefaultPredictedTag, true, &err),
+				GroupTag:     reg.StrParam(params, "group", FoldTag, true, &err),
+				SetTag:       reg.StrParam(params, "set_tag", SetTag, true, &err),
+				File:         reg.StrParam(params, "file", "", true, &err),
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		"Compare the predicted tag of every sample with the expected tag, where every tag value is a separate class. "+
+			"When the input ends, the confusion matrix and the precision, recall and F1 score of every class are logged, along with the accuracy and the macro-averaged F1 score. "+
+			"If the file parameter is given, all results are written to that file as JSON. "+
+			"Like the evaluate step, the results are computed separately for every value of the group tag, and samples from the training set are ignored. "+
+			"Samples without the predicted tag are counted as class '"+MissingClass+"'.",
+		reg.OptionalParams("expected", "predicted", "group", "set_tag", "file"))
+}
+
+// ConfusionMatrix counts the samples for every combination of expected and predicted class.
+type ConfusionMatrix map[string]map[string]int
+
+func (m ConfusionMatrix) Add(expected, predicted string) {
+	row, ok := m[expected]
+	if !ok {
+		row = make(map[string]int)
+		m[expected] = row
+	}
+	row[predicted]++
+}
+
+// Classes returns all expected and predicted classes, sorted.
+func (m ConfusionMatrix) Classes() []string {
+	set := make(map[string]bool)
+	for expected, row := range m {
+		set[expected] = true
+		for predicted := range row {
+			set[predicted] = true
+		}
+	}
+	res := make([]string, 0, len(set))
+	for class := range set {
+		res = append(res, class)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ClassScores contains the evaluation results for one class.
+type ClassScores struct {
+	Support   int // Number of samples expected in this class
+	Predicted int
+	Precision float64
+	Recall    float64
+	F1        float64
+}
+
+// ClassReport contains the evaluation results for one group of samples.
+type ClassReport struct {
+	Samples   int
+	Accuracy  float64
+	MacroF1   float64
+	Classes   map[string]*ClassScores
+	Confusion ConfusionMatrix // Maps expected class -> predicted class -> number of samples
+}
+
+// Report computes the scores of all classes. Classes that are never expected or predicted have NaN scores,
+// which are excluded from the macro-averaged F1 score.
+func (m ConfusionMatrix) Report() *ClassReport {
+	res := &ClassReport{
+		Classes:   make(map[string]*ClassScores),
+		Confusion: m,
+	}
+	var correct int
+	for _, class := range m.Classes() {
+		scores := new(ClassScores)
+		for predicted, num := range m[class] {
+			scores.Support += num
+			res.Samples += num
+			if predicted == class {
+				correct += num
+			}
+		}
+		for _, row := range m {
+			scores.Predicted += row[class]
+		}
+		truePositives := m[class][class]
+		scores.Precision = ratio(truePositives, scores.Predicted)
+		scores.Recall = ratio(truePositives, scores.Support)
+		scores.F1 = f1(scores.Precision, scores.Recall)
+		res.Classes[class] = scores
+	}
+	res.Accuracy = ratio(correct, res.Samples)
+	var f1Sum float64
+	var f1Num int
+	for _, scores := range res.Classes {
+		if !math.IsNaN(scores.F1) && scores.Support > 0 {
+			f1Sum += scores.F1
+			f1Num++
+		}
+	}
+	res.MacroF1 = math.NaN()
+	if f1Num > 0 {
+		res.MacroF1 = f1Sum / float64(f1Num)
+	}
+	return res
+}
+
+// String formats the confusion matrix and the class scores as a table.
+func (r *ClassReport) String() string {
+	var buf bytes.Buffer
+	classes := r.Confusion.Classes()
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "expected \\ predicted\t%v\tprecision\trecall\tf1\tsupport\t\n", strings.Join(classes, "\t"))
+	for _, expected := range classes {
+		fmt.Fprintf(w, "%v\t", expected)
+		for _, predicted := range classes {
+			fmt.Fprintf(w, "%v\t", r.Confusion[expected][predicted])
+		}
+		scores := r.Classes[expected]
+		fmt.Fprintf(w, "%.4f\t%.4f\t%.4f\t%v\t\n", scores.Precision, scores.Recall, scores.F1, scores.Support)
+	}
+	_ = w.Flush()
+	fmt.Fprintf(&buf, "%v samples, accuracy %.4f, macro F1 %.4f", r.Samples, r.Accuracy, r.MacroF1)
+	return buf.String()
+}
+
+// jsonFloat encodes NaN values as null, because they are not supported by JSON.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func (s *ClassScores) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"support":   s.Support,
+		"predicted": s.Predicted,
+		"precision": jsonFloat(s.Precision),
+		"recall":    jsonFloat(s.Recall),
+		"f1":        jsonFloat(s.F1),
+	})
+}
+
+func (r *ClassReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"samples":   r.Samples,
+		"accuracy":  jsonFloat(r.Accuracy),
+		"macro_f1":  jsonFloat(r.MacroF1),
+		"classes":   r.Classes,
+		"confusion": r.Confusion,
+	})
+}
+
+// ClassEvaluation computes a multi-class confusion matrix from an expected and a predicted tag,
+// separately for every value of the group tag.
+type ClassEvaluation struct {
+	bitflow.NoopProcessor
+	ExpectedTag  string
+	PredictedTag string
+	GroupTag     string // Optional
+	SetTag       string // Optional, samples in the training set are ignored
+	File         string // Optional
+
+	groups  map[string]ConfusionMatrix
+	ignored int
+}
+
+func (e *ClassEvaluation) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if e.SetTag == "" || sample.Tag(e.SetTag) != TrainSet {
+		if !sample.HasTag(e.ExpectedTag) {
+			e.ignored++
+		} else {
+			predicted := MissingClass
+			if sample.HasTag(e.PredictedTag) {
+				predicted = sample.Tag(e.PredictedTag)
+			}
+			e.matrix(sample).Add(sample.Tag(e.ExpectedTag), predicted)
+		}
+	}
+	return e.NoopProcessor.Sample(sample, header)
+}
+
+func (e *ClassEvaluation) matrix(sample *bitflow.Sample) ConfusionMatrix {
+	if e.groups == nil {
+		e.groups = make(map[string]ConfusionMatrix)
+	}
+	group := ""
+	if e.GroupTag != "" {
+		group = sample.Tag(e.GroupTag)
+	}
+	matrix, ok := e.groups[group]
+	if !ok {
+		matrix = make(ConfusionMatrix)
+		e.groups[group] = matrix
+	}
+	return matrix
+}
+
+func (e *ClassEvaluation) Close() {
+	defer e.NoopProcessor.Close()
+	if e.ignored > 0 {
+		log.Warnf("%v: Ignored %v samples without tag '%v'", e, e.ignored, e.ExpectedTag)
+	}
+	if len(e.groups) == 0 {
+		log.Warnf("%v: No samples evaluated", e)
+		return
+	}
+	groups := make([]string, 0, len(e.groups))
+	for group := range e.groups {
+		groups = append(groups, group)
+	}
+	sortGroups(groups)
+
+	reports := make(map[string]*ClassReport, len(groups))
+	var accuracies, macroF1s []float64
+	for _, group := range groups {
+		report := e.groups[group].Report()
+		reports[group] = report
+		accuracies = append(accuracies, report.Accuracy)
+		macroF1s = append(macroF1s, report.MacroF1)
+		prefix := ""
+		if len(groups) > 1 {
+			prefix = fmt.Sprintf(" (%v %v)", e.GroupTag, group)
+		}
+		log.Printf("%v%v:\n%v", e, prefix, report)
+	}
+	if len(groups) > 1 {
+		accMean, accStddev := meanStddev(accuracies)
+		f1Mean, f1Stddev := meanStddev(macroF1s)
+		log.Printf("%v: Over %v groups: accuracy %.4f ± %.4f, macro F1 %.4f ± %.4f", e, len(groups), accMean, accStddev, f1Mean, f1Stddev)
+	}
+	if e.File != "" {
+		if err := e.writeReport(reports); err != nil {
+			e.Error(err)
+		}
+	}
+}
+
+func (e *ClassEvaluation) writeReport(reports map[string]*ClassReport) (err error) {
+	fileGroup := bitflow.NewFileGroup(e.File)
+	file, err := fileGroup.OpenNewFile(new(int))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	log.Println("Writing evaluation report to", file.Name())
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"expected_tag":  e.ExpectedTag,
+		"predicted_tag": e.PredictedTag,
+		"group_tag":     e.GroupTag,
+		"groups":        reports,
+	})
+}
+
+func (e *ClassEvaluation) String() string {
+	res := fmt.Sprintf("Confusion matrix (expected %v, predicted %v", e.ExpectedTag, e.PredictedTag)
+	if e.GroupTag != "" {
+		res += ", grouped by " + e.GroupTag
+	}
+	return res + ")"
+}
@@ -0,0 +1,95 @@
+package evaluation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type confusionTestSuite struct {
+	testsupport.Suite
+}
+
+func TestConfusionMatrix(t *testing.T) {
+	suite.Run(t, new(confusionTestSuite))
+}
+
+func (s *confusionTestSuite) matrix() ConfusionMatrix {
+	m := make(ConfusionMatrix)
+	m.Add("cat", "cat")
+	m.Add("cat", "cat")
+	m.Add("cat", "dog")
+	m.Add("dog", "dog")
+	m.Add("dog", "cat")
+	m.Add("bird", MissingClass)
+	return m
+}
+
+func (s *confusionTestSuite) TestReport() {
+	m := s.matrix()
+	s.Equal([]string{MissingClass, "bird", "cat", "dog"}, m.Classes())
+	r := m.Report()
+	s.Equal(6, r.Samples)
+	s.Equal(0.5, r.Accuracy)
+
+	s.Equal(&ClassScores{Support: 3, Predicted: 3, Precision: 2.0 / 3, Recall: 2.0 / 3, F1: 2.0 / 3}, r.Classes["cat"])
+	s.Equal(&ClassScores{Support: 2, Predicted: 2, Precision: 0.5, Recall: 0.5, F1: 0.5}, r.Classes["dog"])
+	bird := r.Classes["bird"]
+	s.Equal(1, bird.Support)
+	s.True(math.IsNaN(bird.Precision), "Never predicted")
+	s.Equal(0.0, bird.F1)
+	none := r.Classes[MissingClass]
+	s.Equal(0, none.Support)
+	s.Equal(1, none.Predicted)
+	s.True(math.IsNaN(none.Recall), "Never expected")
+	// Classes that are never expected are excluded from the macro average
+	s.InDelta((2.0/3+0.5)/3, r.MacroF1, 1e-12)
+
+	table := r.String()
+	s.Contains(table, "expected \\ predicted")
+	s.Contains(table, "6 samples, accuracy 0.5000, macro F1 0.3889")
+}
+
+func (s *confusionTestSuite) TestJson() {
+	data, err := json.Marshal(s.matrix().Report())
+	s.NoError(err)
+	var decoded map[string]interface{}
+	s.NoError(json.Unmarshal(data, &decoded))
+	s.Equal(6.0, decoded["samples"])
+	classes := decoded["classes"].(map[string]interface{})
+	s.Nil(classes["bird"].(map[string]interface{})["precision"], "NaN must be encoded as null")
+	s.Equal(0.5, classes["dog"].(map[string]interface{})["recall"])
+	s.Equal(map[string]interface{}{"cat": 1.0, "dog": 1.0}, decoded["confusion"].(map[string]interface{})["dog"])
+}
+
+func (s *confusionTestSuite) TestClassEvaluation() {
+	dir, err := ioutil.TempDir("", "bitflow-confusion-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	step := &ClassEvaluation{ExpectedTag: "e", PredictedTag: "p", GroupTag: FoldTag, SetTag: SetTag, File: filepath.Join(dir, "report.json")}
+	s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(0, "fold=0 e=x p=x", 1),
+		testsupport.NewSample(0, "fold=0 e=x p=y set=train", 1), // Training samples are ignored
+		testsupport.NewSample(0, "fold=1 e=y", 1),               // Missing prediction
+		testsupport.NewSample(0, "fold=1 p=y", 1))               // Missing expected class
+	s.Equal(1, step.ignored)
+	s.Equal(ConfusionMatrix{"x": {"x": 1}}, step.groups["0"])
+	s.Equal(ConfusionMatrix{"y": {MissingClass: 1}}, step.groups["1"])
+
+	content, err := ioutil.ReadFile(step.File)
+	s.NoError(err)
+	var decoded map[string]interface{}
+	s.NoError(json.Unmarshal(content, &decoded))
+	s.Equal("e", decoded["expected_tag"])
+	groups := decoded["groups"].(map[string]interface{})
+	s.Len(groups, 2)
+	s.Equal(1.0, groups["0"].(map[string]interface{})["accuracy"])
+	s.Equal(0.0, groups["1"].(map[string]interface{})["accuracy"])
+}
@@ -0,0 +1,286 @@
+// Package evaluation contains steps for evaluating the results of classification and anomaly detection steps
+// against expected labels, and for splitting data into training and test sets.
+package evaluation
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultExpectedTag   = "expected"
+	DefaultPredictedTag  = "predicted"
+	DefaultPositiveValue = "anomaly"
+)
+
+func RegisterEvaluation(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("evaluate",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &BinaryEvaluation{
+				ExpectedTag:   reg.StrParam(params, "expected", DefaultExpectedTag, true, &err),
+				PredictedTag:  reg.StrParam(params, "predicted", DefaultPredictedTag, true, &err),
+				PositiveValue: reg.StrParam(params, "positive", DefaultPositiveValue, true, &err),
+				GroupTag:      reg.StrParam(params, "group", FoldTag, true, &err),
+				SetTag:        reg.StrParam(params, "set_tag", SetTag, true, &err),
+				File:          reg.StrParam(params, "file", "", true, &err),
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		fmt.Sprintf("Compare the predicted tag (default '%v') of every sample with the expected tag (default '%v'), where the positive value (default '%v') denotes the positive class. ", DefaultPredictedTag, DefaultExpectedTag, DefaultPositiveValue)+
+			"When the input ends, the accuracy, precision, recall and F1 score are logged and optionally written to the given CSV file. "+
+			fmt.Sprintf("The results are computed separately for every value of the group tag (default '%v'). With multiple groups (e.g. cross-validation folds), the mean and standard deviation over all groups are reported as well. ", FoldTag)+
+			fmt.Sprintf("Samples where the set tag (default '%v') is '%v' are not evaluated. Samples without the expected tag are ignored.", SetTag, TrainSet),
+		reg.OptionalParams("expected", "predicted", "positive", "group", "set_tag", "file"))
+}
+
+// BinaryCounts contains the outcomes of a binary classification.
+type BinaryCounts struct {
+	TruePositives  int
+	FalsePositives int
+	TrueNegatives  int
+	FalseNegatives int
+}
+
+func (c *BinaryCounts) Add(expected, predicted bool) {
+	switch {
+	case expected && predicted:
+		c.TruePositives++
+	case expected:
+		c.FalseNegatives++
+	case predicted:
+		c.FalsePositives++
+	default:
+		c.TrueNegatives++
+	}
+}
+
+func (c *BinaryCounts) Total() int {
+	return c.TruePositives + c.FalsePositives + c.TrueNegatives + c.FalseNegatives
+}
+
+func (c *BinaryCounts) Accuracy() float64 {
+	return ratio(c.TruePositives+c.TrueNegatives, c.Total())
+}
+
+func (c *BinaryCounts) Precision() float64 {
+	return ratio(c.TruePositives, c.TruePositives+c.FalsePositives)
+}
+
+func (c *BinaryCounts) Recall() float64 {
+	return ratio(c.TruePositives, c.TruePositives+c.FalseNegatives)
+}
+
+func (c *BinaryCounts) F1() float64 {
+	return f1(c.Precision(), c.Recall())
+}
+
+// Scores returns the values of all ScoreNames, in that order.
+func (c *BinaryCounts) Scores() []float64 {
+	return []float64{c.Accuracy(), c.Precision(), c.Recall(), c.F1()}
+}
+
+// ScoreNames are the names of the values returned by BinaryCounts.Scores()
+var ScoreNames = []string{"accuracy", "precision", "recall", "f1"}
+
+func (c *BinaryCounts) String() string {
+	return fmt.Sprintf("%v samples (TP %v, FP %v, TN %v, FN %v), accuracy %.4f, precision %.4f, recall %.4f, F1 %.4f",
+		c.Total(), c.TruePositives, c.FalsePositives, c.TrueNegatives, c.FalseNegatives, c.Accuracy(), c.Precision(), c.Recall(), c.F1())
+}
+
+// ratio returns NaN if the denominator is zero
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return math.NaN()
+	}
+	return float64(a) / float64(b)
+}
+
+// f1 treats an undefined precision or recall as zero, unless both are undefined
+func f1(precision, recall float64) float64 {
+	if math.IsNaN(precision) && math.IsNaN(recall) {
+		return math.NaN()
+	}
+	if math.IsNaN(precision) {
+		precision = 0
+	}
+	if math.IsNaN(recall) {
+		recall = 0
+	}
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
+
+// meanStddev ignores NaN values
+func meanStddev(values []float64) (mean float64, stddev float64) {
+	var num int
+	for _, val := range values {
+		if !math.IsNaN(val) {
+			mean += val
+			num++
+		}
+	}
+	if num == 0 {
+		return math.NaN(), math.NaN()
+	}
+	mean /= float64(num)
+	for _, val := range values {
+		if !math.IsNaN(val) {
+			stddev += (val - mean) * (val - mean)
+		}
+	}
+	return mean, math.Sqrt(stddev / float64(num))
+}
+
+// BinaryEvaluation compares a predicted tag with an expected tag and computes binary classification scores,
+// separately for every value of the group tag.
+type BinaryEvaluation struct {
+	bitflow.NoopProcessor
+	ExpectedTag   string
+	PredictedTag  string
+	PositiveValue string
+	GroupTag      string // Optional
+	SetTag        string // Optional, samples in the training set are ignored
+	File          string // Optional
+
+	groups  map[string]*BinaryCounts
+	ignored int
+}
+
+func (e *BinaryEvaluation) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if e.SetTag == "" || sample.Tag(e.SetTag) != TrainSet {
+		if !sample.HasTag(e.ExpectedTag) {
+			e.ignored++
+		} else {
+			e.counts(sample).Add(sample.Tag(e.ExpectedTag) == e.PositiveValue, sample.Tag(e.PredictedTag) == e.PositiveValue)
+		}
+	}
+	return e.NoopProcessor.Sample(sample, header)
+}
+
+func (e *BinaryEvaluation) counts(sample *bitflow.Sample) *BinaryCounts {
+	if e.groups == nil {
+		e.groups = make(map[string]*BinaryCounts)
+	}
+	group := ""
+	if e.GroupTag != "" {
+		group = sample.Tag(e.GroupTag)
+	}
+	counts, ok := e.groups[group]
+	if !ok {
+		counts = new(BinaryCounts)
+		e.groups[group] = counts
+	}
+	return counts
+}
+
+// sortGroups sorts the group names numerically, if possible
+func sortGroups(groups []string) []string {
+	sort.Slice(groups, func(i, j int) bool {
+		a, errA := strconv.Atoi(groups[i])
+		b, errB := strconv.Atoi(groups[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return groups[i] < groups[j]
+	})
+	return groups
+}
+
+func (e *BinaryEvaluation) Close() {
+	if e.ignored > 0 {
+		log.Warnf("%v: Ignored %v samples without tag '%v'", e, e.ignored, e.ExpectedTag)
+	}
+	groups := make([]string, 0, len(e.groups))
+	for group := range e.groups {
+		groups = append(groups, group)
+	}
+	sortGroups(groups)
+	if len(groups) == 0 {
+		log.Warnf("%v: No samples evaluated", e)
+	}
+	allScores := make([][]float64, len(ScoreNames))
+	for _, group := range groups {
+		counts := e.groups[group]
+		if len(groups) > 1 {
+			log.Printf("%v: %v %v: %v", e, e.GroupTag, group, counts)
+		} else {
+			log.Printf("%v: %v", e, counts)
+		}
+		for i, score := range counts.Scores() {
+			allScores[i] = append(allScores[i], score)
+		}
+	}
+	if len(groups) > 1 {
+		var summary []string
+		for i, name := range ScoreNames {
+			mean, stddev := meanStddev(allScores[i])
+			summary = append(summary, fmt.Sprintf("%v %.4f ± %.4f", name, mean, stddev))
+		}
+		log.Printf("%v: Over %v groups: %v", e, len(groups), strings.Join(summary, ", "))
+	}
+	if e.File != "" && len(groups) > 0 {
+		if err := e.writeResults(groups, allScores); err != nil {
+			e.Error(err)
+		}
+	}
+	e.NoopProcessor.Close()
+}
+
+func (e *BinaryEvaluation) writeResults(groups []string, allScores [][]float64) (err error) {
+	group := bitflow.NewFileGroup(e.File)
+	file, err := group.OpenNewFile(new(int))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	log.Println("Writing evaluation results to", file.Name())
+	buf := bufio.NewWriter(file)
+	formatFloat := func(val float64) string {
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	}
+	buf.WriteString("group,samples,tp,fp,tn,fn," + strings.Join(ScoreNames, ",") + "\n")
+	for _, group := range groups {
+		counts := e.groups[group]
+		fmt.Fprintf(buf, "%v,%v,%v,%v,%v,%v", group, counts.Total(), counts.TruePositives, counts.FalsePositives, counts.TrueNegatives, counts.FalseNegatives)
+		for _, score := range counts.Scores() {
+			buf.WriteString("," + formatFloat(score))
+		}
+		buf.WriteString("\n")
+	}
+	if len(groups) > 1 {
+		means := make([]string, len(ScoreNames))
+		stddevs := make([]string, len(ScoreNames))
+		for i := range ScoreNames {
+			mean, stddev := meanStddev(allScores[i])
+			means[i], stddevs[i] = formatFloat(mean), formatFloat(stddev)
+		}
+		buf.WriteString("mean,,,,,," + strings.Join(means, ",") + "\n")
+		buf.WriteString("stddev,,,,,," + strings.Join(stddevs, ",") + "\n")
+	}
+	return buf.Flush()
+}
+
+func (e *BinaryEvaluation) String() string {
+	res := fmt.Sprintf("Binary evaluation (%v = %v, expected tag %v", e.PredictedTag, e.PositiveValue, e.ExpectedTag)
+	if e.GroupTag != "" {
+		res += ", grouped by " + e.GroupTag
+	}
+	return res + ")"
+}
@@ -0,0 +1,97 @@
+package evaluation
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type evaluationTestSuite struct {
+	testsupport.Suite
+}
+
+func TestEvaluation(t *testing.T) {
+	suite.Run(t, new(evaluationTestSuite))
+}
+
+func (s *evaluationTestSuite) TestBinaryCounts() {
+	var c BinaryCounts
+	for _, outcome := range [][2]bool{{true, true}, {true, true}, {true, false}, {false, true}, {false, false}, {false, false}, {false, false}} {
+		c.Add(outcome[0], outcome[1])
+	}
+	s.Equal(BinaryCounts{TruePositives: 2, FalseNegatives: 1, FalsePositives: 1, TrueNegatives: 3}, c)
+	s.Equal(7, c.Total())
+	s.InDeltaSlice([]float64{5.0 / 7, 2.0 / 3, 2.0 / 3, 2.0 / 3}, c.Scores(), 1e-12)
+
+	// Undefined precision is treated as zero for the F1 score
+	c = BinaryCounts{FalseNegatives: 2, TrueNegatives: 1}
+	s.True(math.IsNaN(c.Precision()))
+	s.Equal(0.0, c.Recall())
+	s.Equal(0.0, c.F1())
+	c = BinaryCounts{TrueNegatives: 1}
+	s.True(math.IsNaN(c.F1()), "Without positive predictions and samples, the F1 score is undefined")
+	s.True(math.IsNaN(new(BinaryCounts).Accuracy()))
+}
+
+func (s *evaluationTestSuite) TestMeanStddev() {
+	mean, stddev := meanStddev([]float64{1, math.NaN(), 3})
+	s.Equal(2.0, mean)
+	s.Equal(1.0, stddev)
+	mean, stddev = meanStddev([]float64{math.NaN()})
+	s.True(math.IsNaN(mean) && math.IsNaN(stddev))
+
+	s.Equal([]string{"2", "10", "a"}, sortGroups([]string{"10", "a", "2"}))
+}
+
+func (s *evaluationTestSuite) TestBinaryEvaluation() {
+	dir, err := ioutil.TempDir("", "bitflow-evaluation-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	step := &BinaryEvaluation{
+		ExpectedTag:   DefaultExpectedTag,
+		PredictedTag:  DefaultPredictedTag,
+		PositiveValue: DefaultPositiveValue,
+		GroupTag:      FoldTag,
+		SetTag:        SetTag,
+		File:          filepath.Join(dir, "results.csv"),
+	}
+	out := s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(0, "fold=0 set=test expected=anomaly predicted=anomaly", 1),
+		testsupport.NewSample(0, "fold=0 set=test expected=normal predicted=anomaly", 2),
+		testsupport.NewSample(0, "fold=0 set=train expected=anomaly predicted=normal", 3), // Training samples are not evaluated
+		testsupport.NewSample(0, "fold=1 set=test expected=anomaly predicted=anomaly", 4),
+		testsupport.NewSample(0, "fold=1 set=test expected=normal", 5),
+		testsupport.NewSample(0, "fold=1 set=test", 6)) // No expected tag
+	out.AssertCount(s.T(), 6)
+	s.Equal(1, step.ignored)
+	s.Equal(&BinaryCounts{TruePositives: 1, FalsePositives: 1}, step.groups["0"])
+	s.Equal(&BinaryCounts{TruePositives: 1, TrueNegatives: 1}, step.groups["1"])
+
+	content, err := ioutil.ReadFile(step.File)
+	s.NoError(err)
+	s.Equal("group,samples,tp,fp,tn,fn,accuracy,precision,recall,f1\n"+
+		"0,2,1,1,0,0,0.5,0.5,1,0.6666666666666666\n"+
+		"1,2,1,0,1,0,1,1,1,1\n"+
+		"mean,,,,,,0.75,0.75,1,0.8333333333333333\n"+
+		"stddev,,,,,,0.25,0.25,0,0.16666666666666669\n", string(content))
+}
+
+func (s *evaluationTestSuite) TestSingleGroup() {
+	dir, err := ioutil.TempDir("", "bitflow-evaluation-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	step := &BinaryEvaluation{ExpectedTag: "e", PredictedTag: "p", PositiveValue: "yes", File: filepath.Join(dir, "results.csv")}
+	s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(0, "e=yes p=no set=train", 1),
+		testsupport.NewSample(0, "e=no p=no", 1))
+	content, err := ioutil.ReadFile(step.File)
+	s.NoError(err)
+	s.Equal("group,samples,tp,fp,tn,fn,accuracy,precision,recall,f1\n,2,0,0,1,1,0.5,NaN,0,0\n", string(content),
+		"Without a set tag, all samples are evaluated. No summary for a single group.")
+}
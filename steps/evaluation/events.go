@@ -0,0 +1,228 @@
+package evaluation
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+func RegisterEventEvaluation(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("evaluate_events",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &EventEvaluation{
+				ExpectedTag:   reg.StrParam(params, "expected", DefaultExpectedTag, true, &err),
+				PredictedTag:  reg.StrParam(params, "predicted", DefaultPredictedTag, true, &err),
+				PositiveValue: reg.StrParam(params, "positive", DefaultPositiveValue, true, &err),
+				GroupTag:      reg.StrParam(params, "group", "", true, &err),
+				GraceBefore:   reg.DurationParam(params, "grace_before", 0, true, &err),
+				GraceAfter:    reg.DurationParam(params, "grace_after", 0, true, &err),
+				File:          reg.StrParam(params, "file", "", true, &err),
+			}
+			if err == nil && (step.GraceBefore < 0 || step.GraceAfter < 0) {
+				err = fmt.Errorf("The grace periods must not be negative")
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		"Evaluate the detection of events, where an event is a sequence of consecutive samples that have the positive value in the expected tag. "+
+			"An event is detected by the first sample with the positive value in the predicted tag, and the detection latency is the time from the start of the event to the detection. "+
+			"Detections up to grace_after after the end of an event still count for that event. Detections up to grace_before before the start of an event also count (with a negative latency). "+
+			"All other samples with a positive prediction are counted as false alarms. The samples must be ordered by time. "+
+			"If the group tag is given (e.g. a host name), events are tracked separately for every value of that tag. "+
+			"When the input ends, the number of detected and missed events, the false alarms and the distribution of the detection latency are logged. "+
+			"If the file parameter is given, one line for every event is written to that CSV file.",
+		reg.OptionalParams("expected", "predicted", "positive", "group", "grace_before", "grace_after", "file"))
+}
+
+// Event is a period of consecutive samples that are expected to be detected.
+type Event struct {
+	Group     string
+	Start     time.Time
+	End       time.Time // Timestamp of the last sample in the event
+	Samples   int
+	Detected  bool
+	Detection time.Time
+}
+
+func (e *Event) Latency() time.Duration {
+	return e.Detection.Sub(e.Start)
+}
+
+type eventGroupState struct {
+	current *Event // Event that is currently in progress
+	last    *Event // The previous event, which can still be detected during the grace period
+	pending []time.Time
+}
+
+// EventEvaluation tracks expected events and measures how long it takes until they are detected.
+type EventEvaluation struct {
+	bitflow.NoopProcessor
+	ExpectedTag   string
+	PredictedTag  string
+	PositiveValue string
+	GroupTag      string // Optional
+	GraceBefore   time.Duration
+	GraceAfter    time.Duration
+	File          string // Optional
+
+	groups      map[string]*eventGroupState
+	events      []*Event
+	falseAlarms int
+}
+
+func (e *EventEvaluation) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	group := ""
+	if e.GroupTag != "" {
+		group = sample.Tag(e.GroupTag)
+	}
+	if e.groups == nil {
+		e.groups = make(map[string]*eventGroupState)
+	}
+	state, ok := e.groups[group]
+	if !ok {
+		state = new(eventGroupState)
+		e.groups[group] = state
+	}
+	e.process(state, group, sample.Time, sample.Tag(e.ExpectedTag) == e.PositiveValue, sample.Tag(e.PredictedTag) == e.PositiveValue)
+	return e.NoopProcessor.Sample(sample, header)
+}
+
+func (e *EventEvaluation) process(state *eventGroupState, group string, t time.Time, expected, predicted bool) {
+	if expected {
+		if state.current == nil {
+			state.current = &Event{Group: group, Start: t}
+			e.events = append(e.events, state.current)
+			// Positive predictions shortly before the event are early detections
+			for i, pending := range state.pending {
+				if t.Sub(pending) <= e.GraceBefore {
+					state.current.Detected = true
+					state.current.Detection = pending
+					e.falseAlarms += i
+					state.pending = nil
+					break
+				}
+			}
+		}
+		state.current.End = t
+		state.current.Samples++
+		if predicted && !state.current.Detected {
+			state.current.Detected = true
+			state.current.Detection = t
+		}
+	} else {
+		if state.current != nil {
+			state.last, state.current = state.current, nil
+		}
+		if predicted {
+			if state.last != nil && t.Sub(state.last.End) <= e.GraceAfter {
+				if !state.last.Detected {
+					state.last.Detected = true
+					state.last.Detection = t
+				}
+			} else {
+				state.pending = append(state.pending, t)
+			}
+		}
+	}
+	// Positive predictions that are too old to be early detections are false alarms
+	for len(state.pending) > 0 && t.Sub(state.pending[0]) > e.GraceBefore {
+		state.pending = state.pending[1:]
+		e.falseAlarms++
+	}
+}
+
+func (e *EventEvaluation) Close() {
+	defer e.NoopProcessor.Close()
+	for _, state := range e.groups {
+		e.falseAlarms += len(state.pending)
+		state.pending = nil
+	}
+	var latencies []time.Duration
+	for _, event := range e.events {
+		if event.Detected {
+			latencies = append(latencies, event.Latency())
+		}
+	}
+	detected := len(latencies)
+	log.Printf("%v: %v events, %v detected (recall %.4f), %v missed, %v false alarm samples",
+		e, len(e.events), detected, ratio(detected, len(e.events)), len(e.events)-detected, e.falseAlarms)
+	if detected > 0 {
+		log.Printf("%v: Detection latency: %v", e, LatencyDistribution(latencies))
+	}
+	if e.File != "" && len(e.events) > 0 {
+		if err := e.writeEvents(); err != nil {
+			e.Error(err)
+		}
+	}
+}
+
+// LatencyDistribution summarizes the given latencies with the minimum, mean, percentiles and maximum.
+func LatencyDistribution(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "no values"
+	}
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	var sum time.Duration
+	for _, latency := range sorted {
+		sum += latency
+	}
+	percentile := func(p float64) time.Duration {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return fmt.Sprintf("min %v, mean %v, median %v, p90 %v, p99 %v, max %v", sorted[0], sum/time.Duration(len(sorted)),
+		percentile(0.5), percentile(0.9), percentile(0.99), sorted[len(sorted)-1])
+}
+
+func (e *EventEvaluation) writeEvents() (err error) {
+	fileGroup := bitflow.NewFileGroup(e.File)
+	file, err := fileGroup.OpenNewFile(new(int))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	log.Println("Writing event evaluation to", file.Name())
+	buf := bufio.NewWriter(file)
+	buf.WriteString("group,start,end,samples,detected,detection,latency_seconds\n")
+	for _, event := range e.events {
+		detection, latency := "", ""
+		if event.Detected {
+			detection = event.Detection.Format(bitflow.TextMarshallerDateFormat)
+			latency = fmt.Sprintf("%v", event.Latency().Seconds())
+		}
+		fmt.Fprintf(buf, "%v,%v,%v,%v,%v,%v,%v\n", event.Group, event.Start.Format(bitflow.TextMarshallerDateFormat),
+			event.End.Format(bitflow.TextMarshallerDateFormat), event.Samples, event.Detected, detection, latency)
+	}
+	return buf.Flush()
+}
+
+func (e *EventEvaluation) String() string {
+	var parts []string
+	parts = append(parts, fmt.Sprintf("%v = %v", e.PredictedTag, e.PositiveValue), "expected tag "+e.ExpectedTag)
+	if e.GroupTag != "" {
+		parts = append(parts, "grouped by "+e.GroupTag)
+	}
+	if e.GraceBefore > 0 {
+		parts = append(parts, fmt.Sprintf("grace before %v", e.GraceBefore))
+	}
+	if e.GraceAfter > 0 {
+		parts = append(parts, fmt.Sprintf("grace after %v", e.GraceAfter))
+	}
+	return fmt.Sprintf("Event evaluation (%v)", strings.Join(parts, ", "))
+}
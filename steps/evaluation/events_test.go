@@ -0,0 +1,108 @@
+package evaluation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type eventsTestSuite struct {
+	testsupport.Suite
+}
+
+func TestEventEvaluation(t *testing.T) {
+	suite.Run(t, new(eventsTestSuite))
+}
+
+func (s *eventsTestSuite) sample(seconds int, tags string) *bitflow.Sample {
+	return testsupport.NewSample(time.Duration(seconds)*time.Second, tags, 0)
+}
+
+func (s *eventsTestSuite) TestEvents() {
+	dir, err := ioutil.TempDir("", "bitflow-events-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	step := &EventEvaluation{
+		ExpectedTag:   DefaultExpectedTag,
+		PredictedTag:  DefaultPredictedTag,
+		PositiveValue: DefaultPositiveValue,
+		GroupTag:      "host",
+		GraceBefore:   2 * time.Second,
+		GraceAfter:    2 * time.Second,
+		File:          filepath.Join(dir, "events.csv"),
+	}
+	s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		s.sample(0, "host=a predicted=anomaly"), // False alarm, too early for the first event
+		s.sample(5, "host=a"),
+		s.sample(9, "host=a predicted=anomaly"),                   // Early detection of event 1
+		s.sample(10, "host=a expected=anomaly"),                   // Event 1
+		s.sample(10, "host=b expected=anomaly predicted=anomaly"), // Separate event in group b
+		s.sample(11, "host=a expected=anomaly"),
+		s.sample(12, "host=a"),
+		s.sample(20, "host=a expected=anomaly"), // Event 2
+		s.sample(21, "host=a expected=anomaly predicted=anomaly"),
+		s.sample(22, "host=a predicted=anomaly"), // Within the grace period of the detected event 2, not a false alarm
+		s.sample(30, "host=a expected=anomaly"),  // Event 3
+		s.sample(31, "host=a"),
+		s.sample(32, "host=a predicted=anomaly"), // Late detection within the grace period
+		s.sample(40, "host=a expected=anomaly"),  // Event 4, missed
+		s.sample(41, "host=a"),
+		s.sample(50, "host=a predicted=anomaly")) // False alarm, too late for event 4
+
+	s.Len(step.events, 5)
+	var latencies []time.Duration
+	for _, event := range step.events {
+		if event.Detected {
+			latencies = append(latencies, event.Latency())
+		}
+	}
+	s.Equal([]time.Duration{-time.Second, 0, time.Second, 2 * time.Second}, latencies)
+	s.Equal([]string{"a", "b", "a", "a", "a"}, []string{step.events[0].Group, step.events[1].Group, step.events[2].Group, step.events[3].Group, step.events[4].Group})
+	s.Equal(2, step.events[0].Samples)
+	s.False(step.events[4].Detected)
+	s.Equal(2, step.falseAlarms)
+	s.Equal("min -1s, mean 500ms, median 0s, p90 2s, p99 2s, max 2s", LatencyDistribution(latencies))
+
+	content, err := ioutil.ReadFile(step.File)
+	s.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	s.Len(lines, 6)
+	s.Equal("group,start,end,samples,detected,detection,latency_seconds", lines[0])
+	format := func(seconds int) string {
+		return testsupport.StartTime.Add(time.Duration(seconds) * time.Second).Format(bitflow.TextMarshallerDateFormat)
+	}
+	s.Equal("a,"+format(10)+","+format(11)+",2,true,"+format(9)+",-1", lines[1])
+	s.Equal("a,"+format(40)+","+format(40)+",1,false,,", lines[5])
+}
+
+func (s *eventsTestSuite) TestNoGracePeriods() {
+	step := &EventEvaluation{ExpectedTag: "e", PredictedTag: "p", PositiveValue: "yes"}
+	s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		s.sample(0, "p=yes"),
+		s.sample(1, "e=yes"),
+		s.sample(2, "p=yes"),
+		s.sample(3, "e=yes p=yes"))
+	s.Len(step.events, 2)
+	s.False(step.events[0].Detected)
+	s.Equal(time.Duration(0), step.events[1].Latency())
+	s.Equal(2, step.falseAlarms)
+	s.Equal("no values", LatencyDistribution(nil))
+}
+
+func (s *eventsTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterEventEvaluation(b)
+	analysis, _ := b.GetAnalysis("evaluate_events")
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"grace_before": "-1s"}))
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, map[string]string{"group": "host", "grace_after": "5s"}))
+	s.Equal("Event evaluation (predicted = anomaly, expected tag expected, grouped by host, grace after 5s)", pipe.Processors[0].String())
+}
@@ -0,0 +1,216 @@
+package evaluation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+func RegisterLabelInjection(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("inject_labels",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "file", "", false, &err)
+			step := &LabelInjector{
+				Tag:     reg.StrParam(params, "tag", DefaultExpectedTag, true, &err),
+				Default: reg.StrParam(params, "default", "", true, &err),
+			}
+			if err == nil {
+				step.Labels, err = ReadLabelsFile(file)
+				if err == nil {
+					p.Add(step)
+				}
+			}
+			return
+		},
+		fmt.Sprintf("Read labelled time ranges from the given file and set the tag (default '%v') of all samples inside a time range to the label of that range. ", DefaultExpectedTag)+
+			"Files ending with .json must contain a list of objects with the keys start, end, label and optionally tags (an object of tag values that a sample must have to match). "+
+			"All other files are read as CSV with the columns start, end, label and an optional fourth column with the tags in the format 'key1=value1 key2=value2'. "+
+			"Times are formatted as '"+bitflow.CsvDateFormat+"' or RFC3339. Both the start and the end time are included in the range. "+
+			"If multiple ranges match a sample, the first one in the file is used. If the default parameter is given, it is used as the label for samples that match no range, "+
+			"otherwise the tag of these samples is not modified.",
+		reg.RequiredParams("file"),
+		reg.OptionalParams("tag", "default"))
+}
+
+// Label assigns a label value to all samples within a time range that have the given tag values.
+type Label struct {
+	Start time.Time
+	End   time.Time
+	Value string
+	Tags  map[string]string // Optional
+}
+
+func (l *Label) Matches(sample *bitflow.Sample) bool {
+	if sample.Time.Before(l.Start) || sample.Time.After(l.End) {
+		return false
+	}
+	for key, value := range l.Tags {
+		if sample.Tag(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Label) String() string {
+	res := fmt.Sprintf("%v from %v to %v", l.Value, l.Start.Format(bitflow.TextMarshallerDateFormat), l.End.Format(bitflow.TextMarshallerDateFormat))
+	if len(l.Tags) > 0 {
+		res += fmt.Sprintf(" (tags %v)", l.Tags)
+	}
+	return res
+}
+
+// ReadLabelsFile reads a list of labels from a JSON file (if the file name ends with .json) or from a CSV file.
+func ReadLabelsFile(filename string) ([]*Label, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // Drop error
+	var labels []*Label
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		labels, err = ReadLabelsJson(file)
+	} else {
+		labels, err = ReadLabelsCsv(file)
+	}
+	if err != nil {
+		err = fmt.Errorf("Failed to read labels from %v: %v", filename, err)
+	}
+	return labels, err
+}
+
+func ReadLabelsJson(input io.Reader) ([]*Label, error) {
+	var entries []struct {
+		Start string
+		End   string
+		Label string
+		Tags  map[string]string
+	}
+	if err := json.NewDecoder(input).Decode(&entries); err != nil {
+		return nil, err
+	}
+	labels := make([]*Label, len(entries))
+	for i, entry := range entries {
+		label, err := newLabel(entry.Start, entry.End, entry.Label)
+		if err != nil {
+			return nil, fmt.Errorf("Entry %v: %v", i+1, err)
+		}
+		label.Tags = entry.Tags
+		labels[i] = label
+	}
+	return labels, nil
+}
+
+// ReadLabelsCsv expects a header line, which is skipped.
+func ReadLabelsCsv(input io.Reader) ([]*Label, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var labels []*Label
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		if len(record) < 3 || len(record) > 4 {
+			return nil, fmt.Errorf("Line %v: Expected 3 or 4 columns, but got %v", i+1, len(record))
+		}
+		label, err := newLabel(record[0], record[1], record[2])
+		if err == nil && len(record) == 4 && record[3] != "" {
+			var tags bitflow.Sample
+			err = tags.ParseTagString(record[3])
+			label.Tags = tags.TagMap()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Line %v: %v", i+1, err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+func newLabel(start, end, value string) (*Label, error) {
+	label := &Label{Value: value}
+	var err error
+	if label.Start, err = parseLabelTime(start); err != nil {
+		return nil, err
+	}
+	if label.End, err = parseLabelTime(end); err != nil {
+		return nil, err
+	}
+	if label.End.Before(label.Start) {
+		return nil, fmt.Errorf("End time %v is before start time %v", end, start)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("Empty label")
+	}
+	return label, nil
+}
+
+func parseLabelTime(str string) (time.Time, error) {
+	t, err := time.Parse(bitflow.CsvDateFormat, str)
+	if err != nil {
+		var rfcErr error
+		t, rfcErr = time.Parse(time.RFC3339Nano, str)
+		if rfcErr == nil {
+			err = nil
+		}
+	}
+	return t, err
+}
+
+// LabelInjector sets a tag based on a list of labelled time ranges.
+type LabelInjector struct {
+	bitflow.NoopProcessor
+	Labels  []*Label
+	Tag     string
+	Default string // Optional
+
+	labelled   int
+	unlabelled int
+}
+
+func (l *LabelInjector) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	value := l.Default
+	matched := false
+	for _, label := range l.Labels {
+		if label.Matches(sample) {
+			value, matched = label.Value, true
+			break
+		}
+	}
+	if matched {
+		l.labelled++
+	} else {
+		l.unlabelled++
+	}
+	if value != "" {
+		sample.SetTag(l.Tag, value)
+	}
+	return l.NoopProcessor.Sample(sample, header)
+}
+
+func (l *LabelInjector) Close() {
+	log.Printf("%v: Labelled %v samples, %v samples matched no label", l, l.labelled, l.unlabelled)
+	l.NoopProcessor.Close()
+}
+
+func (l *LabelInjector) String() string {
+	res := fmt.Sprintf("Inject %v labels into tag %v", len(l.Labels), l.Tag)
+	if l.Default != "" {
+		res += " (default " + l.Default + ")"
+	}
+	return res
+}
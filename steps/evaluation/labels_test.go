@@ -0,0 +1,151 @@
+package evaluation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type labelsTestSuite struct {
+	testsupport.Suite
+}
+
+func TestLabelInjection(t *testing.T) {
+	suite.Run(t, new(labelsTestSuite))
+}
+
+func (s *labelsTestSuite) at(seconds int) time.Time {
+	return testsupport.StartTime.Add(time.Duration(seconds) * time.Second)
+}
+
+func (s *labelsTestSuite) csvTime(seconds int) string {
+	return s.at(seconds).Format(bitflow.CsvDateFormat)
+}
+
+func (s *labelsTestSuite) TestReadCsv() {
+	labels, err := ReadLabelsCsv(strings.NewReader("start,end,label,tags\n" +
+		s.csvTime(0) + "," + s.csvTime(10) + ",busy\n" +
+		s.at(20).Format(time.RFC3339Nano) + ", " + s.at(30).Format(time.RFC3339Nano) + ", idle, host=a zone=b\n"))
+	s.NoError(err)
+	s.Equal([]*Label{
+		{Start: s.at(0), End: s.at(10), Value: "busy"},
+		{Start: s.at(20), End: s.at(30), Value: "idle", Tags: map[string]string{"host": "a", "zone": "b"}},
+	}, labels)
+
+	labels, err = ReadLabelsCsv(strings.NewReader(s.csvTime(0) + "," + s.csvTime(1) + ",a\n"))
+	s.NoError(err)
+	s.Empty(labels, "The first line is a header")
+
+	for _, line := range []string{
+		"x,y",
+		s.csvTime(0) + "," + s.csvTime(1) + ",a,host=a,b",
+		s.csvTime(1) + "," + s.csvTime(0) + ",a",
+		s.csvTime(0) + "," + s.csvTime(1) + ",",
+		"yesterday," + s.csvTime(1) + ",a",
+	} {
+		_, err := ReadLabelsCsv(strings.NewReader("header\n" + line + "\n"))
+		s.Error(err, "Line: %v", line)
+	}
+}
+
+func (s *labelsTestSuite) TestReadJson() {
+	labels, err := ReadLabelsJson(strings.NewReader(`[
+		{"start": "` + s.csvTime(0) + `", "end": "` + s.csvTime(5) + `", "label": "a"},
+		{"start": "` + s.at(5).Format(time.RFC3339) + `", "end": "` + s.at(9).Format(time.RFC3339) + `", "label": "b", "tags": {"host": "x"}}
+	]`))
+	s.NoError(err)
+	s.Equal([]*Label{
+		{Start: s.at(0), End: s.at(5), Value: "a"},
+		{Start: s.at(5), End: s.at(9), Value: "b", Tags: map[string]string{"host": "x"}},
+	}, labels)
+	s.Equal("b from "+s.at(5).Format(bitflow.TextMarshallerDateFormat)+" to "+s.at(9).Format(bitflow.TextMarshallerDateFormat)+" (tags map[host:x])", labels[1].String())
+
+	_, err = ReadLabelsJson(strings.NewReader(`[{"start": "` + s.csvTime(5) + `", "end": "` + s.csvTime(0) + `", "label": "a"}]`))
+	s.Error(err)
+	_, err = ReadLabelsJson(strings.NewReader(`{}`))
+	s.Error(err)
+}
+
+func (s *labelsTestSuite) TestReadFile() {
+	dir, err := ioutil.TempDir("", "bitflow-labels-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	jsonFile := filepath.Join(dir, "labels.JSON")
+	csvFile := filepath.Join(dir, "labels.txt")
+	s.NoError(ioutil.WriteFile(jsonFile, []byte(`[{"start": "`+s.csvTime(0)+`", "end": "`+s.csvTime(1)+`", "label": "a"}]`), 0644))
+	s.NoError(ioutil.WriteFile(csvFile, []byte("start,end,label\n"+s.csvTime(0)+","+s.csvTime(1)+",b\n"), 0644))
+
+	labels, err := ReadLabelsFile(jsonFile)
+	s.NoError(err)
+	s.Len(labels, 1)
+	s.Equal("a", labels[0].Value)
+	labels, err = ReadLabelsFile(csvFile)
+	s.NoError(err)
+	s.Len(labels, 1)
+	s.Equal("b", labels[0].Value)
+
+	// A JSON file is not valid CSV content with the expected columns
+	s.NoError(ioutil.WriteFile(csvFile, []byte("header\n"+`[{"start": "x"}]`+"\n"), 0644))
+	_, err = ReadLabelsFile(csvFile)
+	s.Error(err)
+	_, err = ReadLabelsFile(filepath.Join(dir, "missing.csv"))
+	s.Error(err)
+}
+
+func (s *labelsTestSuite) TestInjection() {
+	step := &LabelInjector{
+		Tag:     "expected",
+		Default: "normal",
+		Labels: []*Label{
+			{Start: s.at(10), End: s.at(20), Value: "anomaly", Tags: map[string]string{"host": "a"}},
+			{Start: s.at(15), End: s.at(25), Value: "overload"},
+		},
+	}
+	out := s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(9*time.Second, "host=a", 1),
+		testsupport.NewSample(10*time.Second, "host=a", 2), // Start is inclusive
+		testsupport.NewSample(10*time.Second, "host=b", 3), // Tag does not match
+		testsupport.NewSample(16*time.Second, "host=a", 4), // First matching label wins
+		testsupport.NewSample(16*time.Second, "host=b", 5),
+		testsupport.NewSample(20*time.Second, "host=a", 6), // End is inclusive
+		testsupport.NewSample(25*time.Second, "host=a", 7),
+		testsupport.NewSample(26*time.Second, "host=a expected=old", 8))
+	out.AssertCount(s.T(), 8)
+	s.Equal([]string{"normal", "anomaly", "normal", "anomaly", "overload", "anomaly", "overload", "normal"}, out.Tags("expected"))
+	s.Equal(5, step.labelled)
+	s.Equal(3, step.unlabelled)
+	s.Equal("Inject 2 labels into tag expected (default normal)", step.String())
+
+	// Without a default value, unmatched samples keep their tags
+	step = &LabelInjector{Tag: "l", Labels: []*Label{{Start: s.at(0), End: s.at(0), Value: "x"}}}
+	out = s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(0, "", 1),
+		testsupport.NewSample(time.Second, "l=y", 2),
+		testsupport.NewSample(2*time.Second, "", 3))
+	s.Equal([]string{"x", "y", ""}, out.Tags("l"))
+	s.Equal("Inject 1 labels into tag l", step.String())
+}
+
+func (s *labelsTestSuite) TestParameters() {
+	dir, err := ioutil.TempDir("", "bitflow-labels-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "labels.csv")
+	s.NoError(ioutil.WriteFile(file, []byte("start,end,label\n"+s.csvTime(0)+","+s.csvTime(1)+",a\n"), 0644))
+
+	b := reg.NewProcessorRegistry()
+	RegisterLabelInjection(b)
+	analysis, _ := b.GetAnalysis("inject_labels")
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"file": filepath.Join(dir, "missing.csv")}))
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, map[string]string{"file": file, "default": "none"}))
+	s.Equal("Inject 1 labels into tag expected (default none)", pipe.Processors[0].String())
+}
@@ -0,0 +1,278 @@
+package evaluation
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps/plot"
+	log "github.com/sirupsen/logrus"
+	"gonum.org/v1/plot/plotter"
+)
+
+func RegisterRocCurves(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("roc",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &RocEvaluation{
+				ScoreMetric:   reg.StrParam(params, "score", "", false, &err),
+				Invert:        reg.BoolParam(params, "invert", false, true, &err),
+				ExpectedTag:   reg.StrParam(params, "expected", DefaultExpectedTag, true, &err),
+				PositiveValue: reg.StrParam(params, "positive", DefaultPositiveValue, true, &err),
+				GroupTag:      reg.StrParam(params, "group", FoldTag, true, &err),
+				SetTag:        reg.StrParam(params, "set_tag", SetTag, true, &err),
+				File:          reg.StrParam(params, "file", "", true, &err),
+				RocPlot:       reg.StrParam(params, "roc_plot", "", true, &err),
+				PrPlot:        reg.StrParam(params, "pr_plot", "", true, &err),
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		"Compute the ROC curve and the precision-recall curve of an anomaly score metric, based on the expected tag (samples with the positive value are anomalies). "+
+			"By default, higher scores indicate anomalies. Set invert=true for scores where lower values indicate anomalies (e.g. ocsvm). "+
+			"When the input ends, the area under the ROC curve (AUC) and the average precision are logged. "+
+			"The curves can be written to a CSV file (file parameter) and plotted (roc_plot and pr_plot parameters, the image format is derived from the file extension). "+
+			"Like the evaluate step, the curves are computed separately for every value of the group tag, and samples from the training set are ignored.",
+		reg.RequiredParams("score"),
+		reg.OptionalParams("invert", "expected", "positive", "group", "set_tag", "file", "roc_plot", "pr_plot"))
+}
+
+// CurvePoint is one point on the ROC and precision-recall curves, obtained by classifying
+// all samples with a score of at least Threshold as anomalies.
+type CurvePoint struct {
+	Threshold         float64
+	FalsePositiveRate float64
+	TruePositiveRate  float64 // Equal to the recall
+	Precision         float64
+}
+
+type scoredSample struct {
+	score    float64
+	positive bool
+}
+
+// Curves contains the ROC and precision-recall curves of one set of scored samples.
+// Auc is NaN if the samples contain only one class, AveragePrecision is NaN if there are no positive samples.
+type Curves struct {
+	Points           []CurvePoint
+	Auc              float64
+	AveragePrecision float64
+	Positives        int
+	Negatives        int
+}
+
+// computeCurves computes the curve points for every distinct score, ordered by decreasing threshold. The first point
+// has an infinite threshold and corresponds to classifying no sample as anomaly.
+func computeCurves(samples []scoredSample) *Curves {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].score > samples[j].score
+	})
+	res := new(Curves)
+	for _, sample := range samples {
+		if sample.positive {
+			res.Positives++
+		} else {
+			res.Negatives++
+		}
+	}
+	res.Points = append(res.Points, CurvePoint{Threshold: math.Inf(1), Precision: 1})
+	var tp, fp int
+	for i, sample := range samples {
+		if sample.positive {
+			tp++
+		} else {
+			fp++
+		}
+		if i < len(samples)-1 && samples[i+1].score == sample.score {
+			continue // Only one point per distinct score
+		}
+		point := CurvePoint{
+			Threshold:         sample.score,
+			FalsePositiveRate: ratio(fp, res.Negatives),
+			TruePositiveRate:  ratio(tp, res.Positives),
+			Precision:         ratio(tp, tp+fp),
+		}
+		prev := res.Points[len(res.Points)-1]
+		res.Auc += (point.FalsePositiveRate - prev.FalsePositiveRate) * (point.TruePositiveRate + prev.TruePositiveRate) / 2
+		res.AveragePrecision += (point.TruePositiveRate - prev.TruePositiveRate) * point.Precision
+		res.Points = append(res.Points, point)
+	}
+	if res.Positives == 0 || res.Negatives == 0 {
+		res.Auc = math.NaN()
+	}
+	if res.Positives == 0 {
+		res.AveragePrecision = math.NaN()
+	}
+	return res
+}
+
+// RocEvaluation collects an anomaly score and the expected class of every sample, and computes the
+// ROC and precision-recall curves when the input ends.
+type RocEvaluation struct {
+	bitflow.NoopProcessor
+	ScoreMetric   string
+	Invert        bool // If true, lower scores indicate anomalies
+	ExpectedTag   string
+	PositiveValue string
+	GroupTag      string // Optional
+	SetTag        string // Optional, samples in the training set are ignored
+	File          string // Optional
+	RocPlot       string // Optional
+	PrPlot        string // Optional
+
+	checker    bitflow.HeaderChecker
+	scoreIndex int
+	groups     map[string][]scoredSample
+	ignored    int
+}
+
+func (e *RocEvaluation) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if e.checker.HeaderChanged(header) {
+		index, ok := header.BuildIndex()[e.ScoreMetric]
+		if !ok {
+			return fmt.Errorf("%v: Metric %v is missing", e, e.ScoreMetric)
+		}
+		e.scoreIndex = index
+	}
+	if e.SetTag == "" || sample.Tag(e.SetTag) != TrainSet {
+		score := float64(sample.Values[e.scoreIndex])
+		if !sample.HasTag(e.ExpectedTag) || math.IsNaN(score) {
+			e.ignored++
+		} else {
+			if e.Invert {
+				score = -score
+			}
+			if e.groups == nil {
+				e.groups = make(map[string][]scoredSample)
+			}
+			group := ""
+			if e.GroupTag != "" {
+				group = sample.Tag(e.GroupTag)
+			}
+			e.groups[group] = append(e.groups[group], scoredSample{score: score, positive: sample.Tag(e.ExpectedTag) == e.PositiveValue})
+		}
+	}
+	return e.NoopProcessor.Sample(sample, header)
+}
+
+func (e *RocEvaluation) Close() {
+	defer e.NoopProcessor.Close()
+	if e.ignored > 0 {
+		log.Warnf("%v: Ignored %v samples without tag '%v' or with NaN score", e, e.ignored, e.ExpectedTag)
+	}
+	if len(e.groups) == 0 {
+		log.Warnf("%v: No samples evaluated", e)
+		return
+	}
+	groups := make([]string, 0, len(e.groups))
+	for group := range e.groups {
+		groups = append(groups, group)
+	}
+	sortGroups(groups)
+	curves := make(map[string]*Curves, len(groups))
+	var aucs, aps []float64
+	for _, group := range groups {
+		c := computeCurves(e.groups[group])
+		curves[group] = c
+		aucs = append(aucs, c.Auc)
+		aps = append(aps, c.AveragePrecision)
+		prefix := ""
+		if len(groups) > 1 {
+			prefix = fmt.Sprintf("%v %v: ", e.GroupTag, group)
+		}
+		log.Printf("%v: %v%v positives, %v negatives, ROC AUC %.4f, average precision %.4f", e, prefix, c.Positives, c.Negatives, c.Auc, c.AveragePrecision)
+	}
+	if len(groups) > 1 {
+		aucMean, aucStddev := meanStddev(aucs)
+		apMean, apStddev := meanStddev(aps)
+		log.Printf("%v: Over %v groups: ROC AUC %.4f ± %.4f, average precision %.4f ± %.4f", e, len(groups), aucMean, aucStddev, apMean, apStddev)
+	}
+
+	if e.File != "" {
+		if err := e.writeCurves(groups, curves); err != nil {
+			e.Error(err)
+		}
+	}
+	if e.RocPlot != "" {
+		if err := e.plot(e.RocPlot, "false positive rate", "true positive rate", curves, func(p CurvePoint) (float64, float64) {
+			return p.FalsePositiveRate, p.TruePositiveRate
+		}); err != nil {
+			e.Error(err)
+		}
+	}
+	if e.PrPlot != "" {
+		if err := e.plot(e.PrPlot, "recall", "precision", curves, func(p CurvePoint) (float64, float64) {
+			return p.TruePositiveRate, p.Precision
+		}); err != nil {
+			e.Error(err)
+		}
+	}
+}
+
+func (e *RocEvaluation) writeCurves(groups []string, curves map[string]*Curves) (err error) {
+	fileGroup := bitflow.NewFileGroup(e.File)
+	file, err := fileGroup.OpenNewFile(new(int))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	log.Println("Writing ROC and precision-recall curves to", file.Name())
+	buf := bufio.NewWriter(file)
+	buf.WriteString("group,threshold,fpr,tpr,precision,recall\n")
+	formatFloat := func(val float64) string {
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	}
+	for _, group := range groups {
+		for _, point := range curves[group].Points {
+			threshold := point.Threshold
+			if e.Invert {
+				threshold = -threshold
+			}
+			fmt.Fprintf(buf, "%v,%v,%v,%v,%v,%v\n", group, formatFloat(threshold), formatFloat(point.FalsePositiveRate),
+				formatFloat(point.TruePositiveRate), formatFloat(point.Precision), formatFloat(point.TruePositiveRate))
+		}
+	}
+	return buf.Flush()
+}
+
+func (e *RocEvaluation) plot(file string, labelX, labelY string, curves map[string]*Curves, coordinates func(CurvePoint) (float64, float64)) error {
+	data := make(map[string]plotter.XYs, len(curves))
+	for group, c := range curves {
+		name := group
+		if name != "" {
+			name = e.GroupTag + " " + group
+		}
+		points := make(plotter.XYs, 0, len(c.Points))
+		for _, point := range c.Points {
+			x, y := coordinates(point)
+			if !math.IsNaN(x) && !math.IsNaN(y) {
+				points = append(points, plotter.XY{X: x, Y: y})
+			}
+		}
+		data[name] = points
+	}
+	log.Println("Plotting", labelY, "over", labelX, "to", file)
+	p := plot.Plot{LabelX: labelX, LabelY: labelY, Type: plot.LinePlot, NoLegend: len(curves) == 1}
+	return p.Save(data, file)
+}
+
+func (e *RocEvaluation) String() string {
+	res := fmt.Sprintf("ROC evaluation (score %v", e.ScoreMetric)
+	if e.Invert {
+		res += " inverted"
+	}
+	res += fmt.Sprintf(", %v = %v", e.ExpectedTag, e.PositiveValue)
+	if e.GroupTag != "" {
+		res += ", grouped by " + e.GroupTag
+	}
+	return res + ")"
+}
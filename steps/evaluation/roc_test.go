@@ -0,0 +1,114 @@
+package evaluation
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type rocTestSuite struct {
+	testsupport.Suite
+}
+
+func TestRoc(t *testing.T) {
+	suite.Run(t, new(rocTestSuite))
+}
+
+const rocDelta = 1e-9
+
+// scored creates scored samples from alternating score and class arguments
+func (s *rocTestSuite) scored(scoresAndClasses ...interface{}) []scoredSample {
+	var res []scoredSample
+	for i := 0; i < len(scoresAndClasses); i += 2 {
+		res = append(res, scoredSample{score: scoresAndClasses[i].(float64), positive: scoresAndClasses[i+1].(bool)})
+	}
+	return res
+}
+
+func (s *rocTestSuite) TestKnownCurves() {
+	c := computeCurves(s.scored(0.7, true, 0.6, false, 0.9, true, 0.8, false))
+	s.Equal(2, c.Positives)
+	s.Equal(2, c.Negatives)
+	s.InDelta(0.75, c.Auc, rocDelta)
+	s.InDelta(0.5+0.5*2.0/3, c.AveragePrecision, rocDelta)
+	s.Equal([]CurvePoint{
+		{Threshold: math.Inf(1), Precision: 1},
+		{Threshold: 0.9, FalsePositiveRate: 0, TruePositiveRate: 0.5, Precision: 1},
+		{Threshold: 0.8, FalsePositiveRate: 0.5, TruePositiveRate: 0.5, Precision: 0.5},
+		{Threshold: 0.7, FalsePositiveRate: 0.5, TruePositiveRate: 1, Precision: 2.0 / 3},
+		{Threshold: 0.6, FalsePositiveRate: 1, TruePositiveRate: 1, Precision: 0.5},
+	}, c.Points)
+
+	perfect := computeCurves(s.scored(1.0, true, 2.0, true, 0.5, false, 0.1, false))
+	s.InDelta(1, perfect.Auc, rocDelta)
+	s.InDelta(1, perfect.AveragePrecision, rocDelta)
+
+	inverse := computeCurves(s.scored(0.1, true, 0.2, true, 0.5, false, 1.0, false))
+	s.InDelta(0, inverse.Auc, rocDelta)
+}
+
+func (s *rocTestSuite) TestTiedScores() {
+	c := computeCurves(s.scored(0.5, true, 0.5, false, 0.5, true, 0.5, false))
+	s.Len(c.Points, 2, "All tied scores must result in a single threshold")
+	s.InDelta(0.5, c.Auc, rocDelta)
+	s.InDelta(0.5, c.AveragePrecision, rocDelta)
+
+	// Ties between a positive and a negative sample count as half correctly ranked
+	c = computeCurves(s.scored(0.9, true, 0.5, true, 0.5, false))
+	s.InDelta(0.75, c.Auc, rocDelta)
+}
+
+func (s *rocTestSuite) TestSingleClass() {
+	c := computeCurves(s.scored(0.9, true, 0.1, true))
+	s.Equal(0, c.Negatives)
+	s.True(math.IsNaN(c.Auc), "The AUC is undefined without negative samples")
+	s.InDelta(1, c.AveragePrecision, rocDelta)
+
+	c = computeCurves(s.scored(0.9, false, 0.1, false))
+	s.Equal(0, c.Positives)
+	s.True(math.IsNaN(c.Auc), "The AUC is undefined without positive samples")
+	s.True(math.IsNaN(c.AveragePrecision))
+}
+
+func (s *rocTestSuite) TestEvaluation() {
+	dir, err := ioutil.TempDir("", "bitflow-roc-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "roc.csv")
+
+	step := &RocEvaluation{ScoreMetric: "score", Invert: true, ExpectedTag: DefaultExpectedTag, PositiveValue: DefaultPositiveValue,
+		GroupTag: FoldTag, SetTag: SetTag, File: file}
+	out := s.Process(step, &bitflow.Header{Fields: []string{"x", "score"}},
+		testsupport.NewSample(0, "expected=anomaly fold=1", 0, 1),
+		testsupport.NewSample(0, "expected=normal fold=1", 0, 2),
+		testsupport.NewSample(0, "expected=normal fold=1 set=train", 0, 0),
+		testsupport.NewSample(0, "fold=1", 0, 0),
+		testsupport.NewSample(0, "expected=anomaly fold=2", 0, bitflow.Value(math.NaN())),
+		testsupport.NewSample(0, "expected=anomaly fold=2", 0, 3),
+		testsupport.NewSample(0, "expected=normal fold=2", 0, 3))
+	out.AssertCount(s.T(), 7)
+	s.Equal(2, step.ignored, "Samples without expected tag or with NaN score must be ignored")
+	s.Len(step.groups["1"], 2)
+	s.Len(step.groups["2"], 2)
+
+	content, err := ioutil.ReadFile(file)
+	s.NoError(err)
+	s.Equal("group,threshold,fpr,tpr,precision,recall\n"+
+		"1,-Inf,0,0,1,0\n"+
+		"1,1,0,1,1,1\n"+
+		"1,2,1,1,0.5,1\n"+
+		"2,-Inf,0,0,1,0\n"+
+		"2,3,1,1,0.5,1\n", string(content))
+}
+
+func (s *rocTestSuite) TestMissingMetric() {
+	step := &RocEvaluation{ScoreMetric: "score"}
+	step.SetSink(new(bitflow.DroppingSampleProcessor))
+	s.Error(step.Sample(testsupport.NewSample(0, ""), &bitflow.Header{}))
+}
@@ -0,0 +1,185 @@
+package evaluation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SetTag marks samples as part of the training or test set
+	SetTag   = "set"
+	TrainSet = "train"
+	TestSet  = "test"
+
+	// FoldTag contains the index of the cross-validation fold that a sample belongs to
+	FoldTag = "fold"
+
+	DefaultTestFraction = 0.3
+	DefaultFolds        = 5
+)
+
+func RegisterSplitting(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("train_test_split",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &TrainTestSplit{
+				TestFraction: reg.FloatParam(params, "test", DefaultTestFraction, true, &err),
+				Label:        reg.StrParam(params, "label", "", true, &err),
+				Seed:         int64(reg.IntParam(params, "seed", 1, true, &err)),
+				Tag:          reg.StrParam(params, "tag", SetTag, true, &err),
+			}
+			if err == nil && (step.TestFraction <= 0 || step.TestFraction >= 1) {
+				err = reg.ParameterError("test", fmt.Errorf("Must be in ]0..1["))
+			}
+			if err == nil {
+				p.Batch(step)
+			}
+			return
+		},
+		fmt.Sprintf("Randomly split a batch of samples into a training and a test set by setting the given tag (default '%v') to '%v' or '%v'. ", SetTag, TrainSet, TestSet)+
+			"The test parameter is the fraction of test samples. If the label parameter is given, the split is stratified by the values of that tag, "+
+			"so that every label has the same fraction of test samples.",
+		reg.OptionalParams("test", "label", "seed", "tag"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("cross_validation",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &CrossValidation{
+				Folds:   reg.IntParam(params, "k", DefaultFolds, true, &err),
+				Label:   reg.StrParam(params, "label", "", true, &err),
+				Seed:    int64(reg.IntParam(params, "seed", 1, true, &err)),
+				FoldTag: reg.StrParam(params, "fold_tag", FoldTag, true, &err),
+				SetTag:  reg.StrParam(params, "set_tag", SetTag, true, &err),
+			}
+			if err == nil && step.Folds < 2 {
+				err = reg.ParameterError("k", fmt.Errorf("Must be at least 2"))
+			}
+			if err == nil {
+				p.Batch(step)
+			}
+			return
+		},
+		"Prepare a batch of samples for k-fold cross-validation. The samples are randomly assigned to k folds (stratified by the label tag, if given). "+
+			fmt.Sprintf("The batch is then repeated k times: in repetition i, the fold tag (default '%v') is set to i, and the set tag (default '%v') is set to '%v' for the samples of fold i and to '%v' for all others. ", FoldTag, SetTag, TestSet, TrainSet)+
+			"Within every repetition, the training samples are emitted before the test samples. "+
+			"A fork on the fold tag can be used to train and score a separate model for every fold, and the evaluate step aggregates the results of all folds.",
+		reg.OptionalParams("k", "label", "seed", "fold_tag", "set_tag"), reg.SupportBatch())
+}
+
+// stratify groups the indices of the samples by the value of the label tag, and shuffles every group.
+// If the label is empty, all samples are in one group. The groups are sorted by label value to make the result reproducible.
+func stratify(samples []*bitflow.Sample, label string, rnd *rand.Rand) [][]int {
+	groups := make(map[string][]int)
+	for i, sample := range samples {
+		value := ""
+		if label != "" {
+			value = sample.Tag(label)
+		}
+		groups[value] = append(groups[value], i)
+	}
+	values := make([]string, 0, len(groups))
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	res := make([][]int, len(values))
+	for i, value := range values {
+		group := groups[value]
+		rnd.Shuffle(len(group), func(a, b int) {
+			group[a], group[b] = group[b], group[a]
+		})
+		res[i] = group
+	}
+	return res
+}
+
+// TrainTestSplit tags every sample of a batch as part of the training or test set.
+type TrainTestSplit struct {
+	TestFraction float64
+	Label        string // Optional tag for stratification
+	Seed         int64
+	Tag          string
+}
+
+func (s *TrainTestSplit) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	numTest := 0
+	for _, group := range stratify(samples, s.Label, rand.New(rand.NewSource(s.Seed))) {
+		test := int(math.Round(s.TestFraction * float64(len(group))))
+		for i, index := range group {
+			if i < test {
+				samples[index].SetTag(s.Tag, TestSet)
+			} else {
+				samples[index].SetTag(s.Tag, TrainSet)
+			}
+		}
+		numTest += test
+	}
+	log.Printf("%v: %v training samples, %v test samples", s, len(samples)-numTest, numTest)
+	return header, samples, nil
+}
+
+func (s *TrainTestSplit) String() string {
+	res := fmt.Sprintf("Train/test split (test fraction %v, tag %v", s.TestFraction, s.Tag)
+	if s.Label != "" {
+		res += ", stratified by " + s.Label
+	}
+	return res + ")"
+}
+
+// CrossValidation repeats a batch of samples once for every fold, and marks the samples of that fold as test samples.
+type CrossValidation struct {
+	Folds   int
+	Label   string // Optional tag for stratification
+	Seed    int64
+	FoldTag string
+	SetTag  string
+}
+
+func (c *CrossValidation) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) < c.Folds {
+		return nil, nil, fmt.Errorf("%v: Cannot split %v samples into %v folds", c, len(samples), c.Folds)
+	}
+	// Distribute every group over the folds. Continue with the next fold where the previous group ended, to balance the fold sizes.
+	folds := make([]int, len(samples))
+	next := 0
+	for _, group := range stratify(samples, c.Label, rand.New(rand.NewSource(c.Seed))) {
+		for _, index := range group {
+			folds[index] = next
+			next = (next + 1) % c.Folds
+		}
+	}
+
+	result := make([]*bitflow.Sample, 0, len(samples)*c.Folds)
+	for fold := 0; fold < c.Folds; fold++ {
+		foldStr := strconv.Itoa(fold)
+		for _, test := range []bool{false, true} {
+			for i, sample := range samples {
+				if (folds[i] == fold) != test {
+					continue
+				}
+				clone := sample.DeepClone()
+				clone.SetTag(c.FoldTag, foldStr)
+				if test {
+					clone.SetTag(c.SetTag, TestSet)
+				} else {
+					clone.SetTag(c.SetTag, TrainSet)
+				}
+				result = append(result, clone)
+			}
+		}
+	}
+	return header, result, nil
+}
+
+func (c *CrossValidation) String() string {
+	res := fmt.Sprintf("%v-fold cross-validation (tags %v and %v", c.Folds, c.FoldTag, c.SetTag)
+	if c.Label != "" {
+		res += ", stratified by " + c.Label
+	}
+	return res + ")"
+}
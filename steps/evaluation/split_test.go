@@ -0,0 +1,106 @@
+package evaluation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type splitTestSuite struct {
+	testsupport.Suite
+}
+
+func TestSplit(t *testing.T) {
+	suite.Run(t, new(splitTestSuite))
+}
+
+// labeledSamples returns numA samples with label a, followed by numB samples with label b. Every sample has a unique id tag.
+func (s *splitTestSuite) labeledSamples(numA, numB int) []*bitflow.Sample {
+	var res []*bitflow.Sample
+	for i := 0; i < numA+numB; i++ {
+		label := "a"
+		if i >= numA {
+			label = "b"
+		}
+		res = append(res, testsupport.NewSample(0, fmt.Sprintf("label=%v id=%v", label, i), bitflow.Value(i)))
+	}
+	return res
+}
+
+// count returns the number of samples per label and set
+func (s *splitTestSuite) count(samples []*bitflow.Sample, setTag string) map[string]int {
+	res := make(map[string]int)
+	for _, sample := range samples {
+		res[sample.Tag("label")+"/"+sample.Tag(setTag)]++
+	}
+	return res
+}
+
+func (s *splitTestSuite) TestTrainTestSplit() {
+	step := &TrainTestSplit{TestFraction: 0.3, Label: "label", Seed: 1, Tag: SetTag}
+	_, samples, err := step.ProcessBatch(nil, s.labeledSamples(10, 20))
+	s.NoError(err)
+	s.Len(samples, 30)
+	s.Equal(map[string]int{"a/test": 3, "a/train": 7, "b/test": 6, "b/train": 14}, s.count(samples, SetTag))
+
+	// The split is reproducible with the same seed
+	_, again, err := step.ProcessBatch(nil, s.labeledSamples(10, 20))
+	s.NoError(err)
+	for i := range samples {
+		s.Equal(samples[i].Tag(SetTag), again[i].Tag(SetTag))
+	}
+
+	// Without stratification, the fraction applies to the whole batch
+	_, samples, err = (&TrainTestSplit{TestFraction: 0.5, Seed: 2, Tag: "s"}).ProcessBatch(nil, s.labeledSamples(1, 9))
+	s.NoError(err)
+	counts := s.count(samples, "s")
+	s.Equal(5, counts["a/test"]+counts["b/test"])
+}
+
+func (s *splitTestSuite) TestCrossValidation() {
+	step := &CrossValidation{Folds: 3, Label: "label", Seed: 1, FoldTag: FoldTag, SetTag: SetTag}
+	input := s.labeledSamples(3, 6)
+	_, samples, err := step.ProcessBatch(nil, input)
+	s.NoError(err)
+	s.Len(samples, 3*9)
+
+	testFolds := make(map[string]string) // Every sample must be tested in exactly one fold
+	for fold := 0; fold < 3; fold++ {
+		repetition := samples[fold*9 : (fold+1)*9]
+		foldStr := fmt.Sprint(fold)
+		s.Equal(map[string]int{"a/train": 2, "a/test": 1, "b/train": 4, "b/test": 2}, s.count(repetition, SetTag), "Fold %v", fold)
+		for i, sample := range repetition {
+			s.Equal(foldStr, sample.Tag(FoldTag))
+			if i < 6 {
+				s.Equal(TrainSet, sample.Tag(SetTag), "Training samples must be emitted first")
+			} else {
+				s.Equal(TestSet, sample.Tag(SetTag))
+				_, tested := testFolds[sample.Tag("id")]
+				s.False(tested, "Sample %v tested twice", sample.Tag("id"))
+				testFolds[sample.Tag("id")] = foldStr
+			}
+		}
+	}
+	s.Len(testFolds, 9)
+	s.False(input[0].HasTag(FoldTag), "The input samples must not be modified")
+
+	_, _, err = step.ProcessBatch(nil, s.labeledSamples(1, 1))
+	s.Error(err)
+}
+
+func (s *splitTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterSplitting(b)
+	split, _ := b.GetAnalysis("train_test_split")
+	s.Error(split.Func(new(bitflow.SamplePipeline), map[string]string{"test": "1"}))
+	s.Error(split.Func(new(bitflow.SamplePipeline), map[string]string{"test": "0"}))
+	cv, _ := b.GetAnalysis("cross_validation")
+	s.Error(cv.Func(new(bitflow.SamplePipeline), map[string]string{"k": "1"}))
+	s.NoError(cv.Func(new(bitflow.SamplePipeline), map[string]string{"k": "10", "label": "l"}))
+	s.Equal("10-fold cross-validation (tags fold and set, stratified by l)",
+		(&CrossValidation{Folds: 10, Label: "l", FoldTag: FoldTag, SetTag: SetTag}).String())
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/Knetic/govaluate"
@@ -17,11 +19,17 @@ type Expression struct {
 	sample     *bitflow.Sample
 	header     *bitflow.Header
 	num        int
+
+	checker       bitflow.HeaderChecker
+	headerChanged bool            // Set when set() or remove() modified the header during the current evaluation
+	outHeader     *bitflow.Header // Cached output header to avoid allocating a new header for every sample
+	regexes       map[string]*regexp.Regexp
 }
 
 func NewExpression(expressionString string) (*Expression, error) {
 	expr := &Expression{
-		vars: make(map[string]bool),
+		vars:    make(map[string]bool),
+		regexes: make(map[string]*regexp.Regexp),
 	}
 	compiled, err := govaluate.NewEvaluableExpressionWithFunctions(expressionString, expr.makeFunctions())
 	if err != nil {
@@ -54,16 +62,38 @@ func (p *Expression) UpdateHeader(header *bitflow.Header) error {
 }
 
 func (p *Expression) Evaluate(sample *bitflow.Sample, header *bitflow.Header) (interface{}, error) {
+	res, _, err := p.EvaluateHeader(sample, header)
+	return res, err
+}
+
+// EvaluateHeader evaluates the expression and additionally returns the header of the sample, which differs from
+// the input header if the expression added or removed fields through the set() or remove() functions.
+// The variables of the expression are resolved again whenever the input header changes.
+func (p *Expression) EvaluateHeader(sample *bitflow.Sample, header *bitflow.Header) (interface{}, *bitflow.Header, error) {
+	if p.checker.HeaderChanged(header) {
+		if err := p.UpdateHeader(header); err != nil {
+			return nil, nil, err
+		}
+	}
 	parameters := p.makeParameters(sample)
 	p.sample = sample // Set the sample/header so that the functions in makeFunctions() can access its values
 	p.header = header
+	p.headerChanged = false
 	defer func() {
 		p.sample = nil
 		p.header = nil
 	}()
 	res, err := p.expr.Evaluate(parameters)
 	p.num++
-	return res, err
+	outHeader := p.header
+	if p.headerChanged {
+		if outHeader.Equals(p.outHeader) {
+			outHeader = p.outHeader
+		} else {
+			p.outHeader = outHeader
+		}
+	}
+	return res, outHeader, err
 }
 
 func (p *Expression) EvaluateBool(sample *bitflow.Sample, header *bitflow.Header) (bool, error) {
@@ -130,7 +160,7 @@ func (p *Expression) makeFunctions() map[string]govaluate.ExpressionFunction {
 		"set_timestamp": func(arguments ...interface{}) (interface{}, error) {
 			if len(arguments) == 1 {
 				if numArg, ok := arguments[0].(float64); ok {
-					p.currentSample().Time = time.Unix(int64(numArg), 0)
+					p.currentSample().Time = unixTime(numArg)
 					return arguments[0], nil
 				}
 			}
@@ -144,7 +174,258 @@ func (p *Expression) makeFunctions() map[string]govaluate.ExpressionFunction {
 			}
 			return nil, fmt.Errorf("floor() needs 1 float64 parameter, but received: %v", printParamStrings(arguments))
 		},
+		"ceil":  makeMathFunction("ceil", math.Ceil),
+		"round": makeMathFunction("round", math.Round),
+		"abs":   makeMathFunction("abs", math.Abs),
+		"sqrt":  makeMathFunction("sqrt", math.Sqrt),
+		"log":   makeMathFunction("log", math.Log),
+		"min":   makeMathFunction2("min", math.Min),
+		"max":   makeMathFunction2("max", math.Max),
+		"pow":   makeMathFunction2("pow", math.Pow),
+
+		// Conditionals
+		"if": func(arguments ...interface{}) (interface{}, error) {
+			if len(arguments) == 3 {
+				if cond, ok := arguments[0].(bool); ok {
+					if cond {
+						return arguments[1], nil
+					}
+					return arguments[2], nil
+				}
+			}
+			return nil, fmt.Errorf("if() needs a boolean and 2 further parameters, but received: %v", printParamStrings(arguments))
+		},
+		"is_nan": makeMathPredicate("is_nan", math.IsNaN),
+
+		// String functions
+		"upper":      makeStringTransformation("upper", 1, func(args []string) interface{} { return strings.ToUpper(args[0]) }),
+		"lower":      makeStringTransformation("lower", 1, func(args []string) interface{} { return strings.ToLower(args[0]) }),
+		"trim":       makeStringTransformation("trim", 1, func(args []string) interface{} { return strings.TrimSpace(args[0]) }),
+		"len":        makeStringTransformation("len", 1, func(args []string) interface{} { return float64(len(args[0])) }),
+		"contains":   makeStringTransformation("contains", 2, func(args []string) interface{} { return strings.Contains(args[0], args[1]) }),
+		"has_prefix": makeStringTransformation("has_prefix", 2, func(args []string) interface{} { return strings.HasPrefix(args[0], args[1]) }),
+		"has_suffix": makeStringTransformation("has_suffix", 2, func(args []string) interface{} { return strings.HasSuffix(args[0], args[1]) }),
+		"replace":    makeStringTransformation("replace", 3, func(args []string) interface{} { return strings.Replace(args[0], args[1], args[2], -1) }),
+		"concat": func(arguments ...interface{}) (interface{}, error) {
+			var buf bytes.Buffer
+			for _, arg := range arguments {
+				fmt.Fprintf(&buf, "%v", arg)
+			}
+			return buf.String(), nil
+		},
+		"substr": func(arguments ...interface{}) (interface{}, error) {
+			if len(arguments) == 3 {
+				str, ok1 := arguments[0].(string)
+				start, ok2 := arguments[1].(float64)
+				length, ok3 := arguments[2].(float64)
+				if ok1 && ok2 && ok3 {
+					from := clampIndex(int(start), len(str))
+					to := clampIndex(from+int(length), len(str))
+					return str[from:to], nil
+				}
+			}
+			return nil, fmt.Errorf("substr() needs parameters (string, float64, float64), but received: %v", printParamStrings(arguments))
+		},
+
+		// Regular expressions
+		"matches": p.makeRegexFunction("matches", 0, func(re *regexp.Regexp, args []string) interface{} {
+			return re.MatchString(args[0])
+		}),
+		"regex_replace": p.makeRegexFunction("regex_replace", 1, func(re *regexp.Regexp, args []string) interface{} {
+			return re.ReplaceAllString(args[0], args[1])
+		}),
+		"regex_extract": p.makeRegexFunction("regex_extract", 0, func(re *regexp.Regexp, args []string) interface{} {
+			// Return the first capture group, if the expression defines one, otherwise the entire match
+			match := re.FindStringSubmatch(args[0])
+			if len(match) == 0 {
+				return ""
+			}
+			return match[len(match)-1]
+		}),
+
+		// Tags
+		"delete_tag": p.makeStringFunction("delete_tag", 1, func(sample *bitflow.Sample, args ...string) (interface{}, error) {
+			value := sample.Tag(args[0])
+			sample.DeleteTag(args[0])
+			return value, nil
+		}),
+
+		// Time arithmetic. Timestamps are represented as seconds since the Unix epoch (including fractions).
+		"time": p.makeStringFunction("time", 0, func(sample *bitflow.Sample, args ...string) (interface{}, error) {
+			return float64(sample.Time.UnixNano()) / float64(time.Second), nil
+		}),
+		"duration": makeStringTransformation("duration", 1, func(args []string) interface{} {
+			dur, err := time.ParseDuration(args[0])
+			if err != nil {
+				return err
+			}
+			return dur.Seconds()
+		}),
+		"parse_date": makeStringTransformation("parse_date", 1, func(args []string) interface{} {
+			date, err := time.Parse(bitflow.TextMarshallerDateFormat, args[0])
+			if err != nil {
+				return fmt.Errorf("Cannot parse date (format: %v): %v", bitflow.TextMarshallerDateFormat, err)
+			}
+			return float64(date.UnixNano()) / float64(time.Second)
+		}),
+		"hour":    makeTimeFunction("hour", func(t time.Time) int { return t.Hour() }),
+		"minute":  makeTimeFunction("minute", func(t time.Time) int { return t.Minute() }),
+		"weekday": makeTimeFunction("weekday", func(t time.Time) int { return int(t.Weekday()) }),
+
+		// Creating and removing fields. set() and remove() return true, so that multiple calls can be combined with &&
+		"get": p.makeStringFunction("get", 1, func(sample *bitflow.Sample, args ...string) (interface{}, error) {
+			if index := p.fieldIndex(args[0]); index >= 0 {
+				return float64(sample.Values[index]), nil
+			}
+			return math.NaN(), nil
+		}),
+		"set": func(arguments ...interface{}) (interface{}, error) {
+			if len(arguments) == 2 {
+				name, ok1 := arguments[0].(string)
+				value, ok2 := arguments[1].(float64)
+				if ok1 && ok2 {
+					p.setField(name, value)
+					return true, nil
+				}
+			}
+			return nil, fmt.Errorf("set() needs parameters (string, float64), but received: %v", printParamStrings(arguments))
+		},
+		"remove": p.makeStringFunction("remove", 1, func(sample *bitflow.Sample, args ...string) (interface{}, error) {
+			p.removeField(args[0])
+			return true, nil
+		}),
+	}
+}
+
+func (p *Expression) fieldIndex(name string) int {
+	for i, field := range p.currentHeader().Fields {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Expression) setField(name string, value float64) {
+	sample := p.currentSample()
+	if index := p.fieldIndex(name); index >= 0 {
+		sample.Values[index] = bitflow.Value(value)
+		return
+	}
+	header := p.currentHeader()
+	fields := make([]string, len(header.Fields), len(header.Fields)+1)
+	copy(fields, header.Fields)
+	p.header = &bitflow.Header{Fields: append(fields, name)}
+	p.headerChanged = true
+	AppendToSample(sample, []float64{value})
+}
+
+func (p *Expression) removeField(name string) {
+	index := p.fieldIndex(name)
+	if index < 0 {
+		return
+	}
+	sample := p.currentSample()
+	header := p.currentHeader()
+	fields := make([]string, 0, len(header.Fields)-1)
+	fields = append(append(fields, header.Fields[:index]...), header.Fields[index+1:]...)
+	values := make([]bitflow.Value, 0, len(sample.Values)-1)
+	sample.Values = append(append(values, sample.Values[:index]...), sample.Values[index+1:]...)
+	p.header = &bitflow.Header{Fields: fields}
+	p.headerChanged = true
+}
+
+func (p *Expression) makeRegexFunction(funcName string, extraArgs int, f func(re *regexp.Regexp, args []string) interface{}) govaluate.ExpressionFunction {
+	return makeStringTransformation(funcName, 2+extraArgs, func(args []string) interface{} {
+		re, ok := p.regexes[args[1]]
+		if !ok {
+			var err error
+			if re, err = regexp.Compile(args[1]); err != nil {
+				return fmt.Errorf("%v(): Invalid regular expression: %v", funcName, err)
+			}
+			p.regexes[args[1]] = re
+		}
+		return f(re, append(args[:1:1], args[2:]...))
+	})
+}
+
+// makeStringTransformation creates a function receiving only string parameters. If the result of f is an error, it is returned as such.
+func makeStringTransformation(funcName string, numArgs int, f func(args []string) interface{}) govaluate.ExpressionFunction {
+	return func(arguments ...interface{}) (interface{}, error) {
+		if len(arguments) == numArgs {
+			strArgs := make([]string, 0, numArgs)
+			for _, arg := range arguments {
+				if strArg, ok := arg.(string); ok {
+					strArgs = append(strArgs, strArg)
+				}
+			}
+			if len(strArgs) == numArgs {
+				res := f(strArgs)
+				if err, isErr := res.(error); isErr {
+					return nil, err
+				}
+				return res, nil
+			}
+		}
+		return nil, fmt.Errorf("%v() needs %v string parameter(s), but received: %v", funcName, numArgs, printParamStrings(arguments))
+	}
+}
+
+func makeMathFunction(funcName string, f func(float64) float64) govaluate.ExpressionFunction {
+	return func(arguments ...interface{}) (interface{}, error) {
+		if len(arguments) == 1 {
+			if numArg, ok := arguments[0].(float64); ok {
+				return f(numArg), nil
+			}
+		}
+		return nil, fmt.Errorf("%v() needs 1 float64 parameter, but received: %v", funcName, printParamStrings(arguments))
+	}
+}
+
+func makeMathFunction2(funcName string, f func(float64, float64) float64) govaluate.ExpressionFunction {
+	return func(arguments ...interface{}) (interface{}, error) {
+		if len(arguments) == 2 {
+			a, ok1 := arguments[0].(float64)
+			b, ok2 := arguments[1].(float64)
+			if ok1 && ok2 {
+				return f(a, b), nil
+			}
+		}
+		return nil, fmt.Errorf("%v() needs 2 float64 parameters, but received: %v", funcName, printParamStrings(arguments))
+	}
+}
+
+func makeMathPredicate(funcName string, f func(float64) bool) govaluate.ExpressionFunction {
+	return func(arguments ...interface{}) (interface{}, error) {
+		if len(arguments) == 1 {
+			if numArg, ok := arguments[0].(float64); ok {
+				return f(numArg), nil
+			}
+		}
+		return nil, fmt.Errorf("%v() needs 1 float64 parameter, but received: %v", funcName, printParamStrings(arguments))
+	}
+}
+
+// makeTimeFunction creates a function that extracts a component of a timestamp (seconds since the Unix epoch, UTC)
+func makeTimeFunction(funcName string, f func(time.Time) int) govaluate.ExpressionFunction {
+	return makeMathFunction(funcName, func(seconds float64) float64 {
+		return float64(f(unixTime(seconds).UTC()))
+	})
+}
+
+func unixTime(seconds float64) time.Time {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second)))
+}
+
+func clampIndex(index, length int) int {
+	if index < 0 {
+		return 0
+	}
+	if index > length {
+		return length
 	}
+	return index
 }
 
 func (p *Expression) makeStringFunction(funcName string, numArgs int, f func(sample *bitflow.Sample, args ...string) (interface{}, error)) govaluate.ExpressionFunction {
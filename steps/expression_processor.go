@@ -2,6 +2,7 @@ package steps
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
@@ -11,7 +12,6 @@ type ExpressionProcessor struct {
 	bitflow.NoopProcessor
 	Filter bool
 
-	checker     bitflow.HeaderChecker
 	expressions []*Expression
 }
 
@@ -20,15 +20,20 @@ func RegisterExpression(b reg.ProcessorRegistry) {
 		func(p *bitflow.SamplePipeline, params map[string]string) error {
 			return add_expression(p, params, false)
 		},
-		"Execute the given expression on every sample", reg.RequiredParams("expr"))
+		"Execute the given expression on every sample. Fields can be created, modified and removed through the set(), get() and remove() functions", reg.RequiredParams("expr"))
 }
 
+// RegisterFilterExpression registers the where() step, which forwards only the samples matching a boolean expression,
+// e.g. where(expr="cpu > 0.9 && tag('host') =~ 'web-.*'"). The filter() step is kept as an alias.
 func RegisterFilterExpression(b reg.ProcessorRegistry) {
-	b.RegisterAnalysisParamsErr("filter",
-		func(p *bitflow.SamplePipeline, params map[string]string) error {
-			return add_expression(p, params, true)
-		},
-		"Filter the samples based on a boolean expression", reg.RequiredParams("expr"))
+	create := func(p *bitflow.SamplePipeline, params map[string]string) error {
+		return add_expression(p, params, true)
+	}
+	b.RegisterAnalysisParamsErr("where", create,
+		"Forward only the samples for which the given boolean expression is true. Metrics are accessed by their name, tags through tag('name'). "+
+			"Supports comparisons, &&, ||, ! and regular expression matching with =~ and !~",
+		reg.RequiredParams("expr"))
+	b.RegisterAnalysisParamsErr("filter", create, "Alias for where(): filter the samples based on a boolean expression", reg.RequiredParams("expr"))
 }
 
 func add_expression(p *bitflow.SamplePipeline, params map[string]string, filter bool) error {
@@ -50,10 +55,10 @@ func (p *ExpressionProcessor) AddExpression(expressionString string) error {
 }
 
 func (p *ExpressionProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
-	if res, err := p.evaluate(sample, header); err != nil {
+	if res, outHeader, err := p.evaluate(sample, header); err != nil {
 		return err
 	} else if res {
-		return p.NoopProcessor.Sample(sample, header)
+		return p.NoopProcessor.Sample(sample, outHeader)
 	}
 	return nil
 }
@@ -89,26 +94,21 @@ func (p *ExpressionProcessor) String() string {
 	return res + ": " + str.String()
 }
 
-func (p *ExpressionProcessor) evaluate(sample *bitflow.Sample, header *bitflow.Header) (bool, error) {
-	if p.checker.HeaderChanged(header) {
-		for _, expr := range p.expressions {
-			if err := expr.UpdateHeader(header); err != nil {
-				return false, err
-			}
-		}
-	}
+func (p *ExpressionProcessor) evaluate(sample *bitflow.Sample, header *bitflow.Header) (bool, *bitflow.Header, error) {
+	// Every expression can modify the header through the set() and remove() functions
 	for _, expr := range p.expressions {
+		res, outHeader, err := expr.EvaluateHeader(sample, header)
+		if err != nil {
+			return false, nil, err
+		}
+		header = outHeader
 		if p.Filter {
-			if res, err := expr.EvaluateBool(sample, header); err != nil {
-				return false, err
-			} else if !res {
-				return false, nil
-			}
-		} else {
-			if _, err := expr.Evaluate(sample, header); err != nil {
-				return false, err
+			if boolRes, ok := res.(bool); !ok {
+				return false, nil, fmt.Errorf("%v: Non-boolean result returned: %v (%T)", expr.expr, res, res)
+			} else if !boolRes {
+				return false, nil, nil
 			}
 		}
 	}
-	return true, nil
+	return true, header, nil
 }
@@ -0,0 +1,66 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type expressionTestSuite struct {
+	testsupport.Suite
+}
+
+func TestExpression(t *testing.T) {
+	suite.Run(t, new(expressionTestSuite))
+}
+
+var expressionTestHeader = &bitflow.Header{Fields: []string{"a", "b"}}
+
+// evaluate evaluates the expression on a sample with the values a=1 and b=2, the tag host=web-01 and the time 13:30.
+// It returns the result, the output header and the (possibly modified) sample.
+func (s *expressionTestSuite) evaluate(expression string) (interface{}, *bitflow.Header, *bitflow.Sample) {
+	expr, err := NewExpression(expression)
+	s.NoError(err)
+	sample := testsupport.NewSample(13*time.Hour+30*time.Minute, "host=web-01", 1, 2)
+	res, outHeader, err := expr.EvaluateHeader(sample, expressionTestHeader)
+	s.NoError(err)
+	return res, outHeader, sample
+}
+
+func (s *expressionTestSuite) TestStringFunctions() {
+	res, _, _ := s.evaluate("concat(upper(tag('host')), '/', substr('abcdef', 1, 3), '/', replace('a-b', '-', '+'))")
+	s.Equal("WEB-01/bcd/a+b", res)
+	res, _, _ = s.evaluate("matches(tag('host'), '^web-[0-9]+$') && regex_extract(tag('host'), '-([0-9]+)') == '01'")
+	s.Equal(true, res)
+}
+
+func (s *expressionTestSuite) TestConditionalsAndTime() {
+	res, _, _ := s.evaluate("if(a > b, 'greater', 'smaller')")
+	s.Equal("smaller", res)
+	res, _, _ = s.evaluate("hour(time() + duration('1h'))")
+	s.Equal(14.0, res)
+}
+
+func (s *expressionTestSuite) TestSetAndRemoveFields() {
+	_, outHeader, sample := s.evaluate("set('sum', a + b) && set('a', 10) && remove('b')")
+	s.Equal([]string{"a", "sum"}, outHeader.Fields)
+	s.Equal([]bitflow.Value{10, 3}, sample.Values)
+	s.Equal([]string{"a", "b"}, expressionTestHeader.Fields, "the input header must not be modified")
+}
+
+func (s *expressionTestSuite) TestFilterWhere() {
+	proc := &ExpressionProcessor{Filter: true}
+	s.NoError(proc.AddExpression("cpu > 0.9 && tag('host') =~ 'web-.*' && !(tag('env') == 'test')"))
+	out := s.Process(proc, &bitflow.Header{Fields: []string{"cpu"}},
+		testsupport.NewSample(0, "host=web-01 env=prod", 0.95),
+		testsupport.NewSample(0, "host=web-01 env=prod", 0.5),
+		testsupport.NewSample(0, "host=db-01 env=prod", 0.95),
+		testsupport.NewSample(0, "host=web-01 env=test", 0.95))
+	out.AssertValues(s.T(), [][]float64{{0.95}})
+
+	_, _, err := proc.evaluate(&bitflow.Sample{}, &bitflow.Header{})
+	s.Error(err, "unresolved variables must be reported")
+}
@@ -0,0 +1,90 @@
+package steps
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterHeaderFixer(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("fix_header",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			fixer := &HeaderFixer{
+				Missing: bitflow.Value(reg.FloatParam(params, "missing", 0, true, &err)),
+			}
+			if fields := reg.StrParam(params, "fields", "", true, &err); fields != "" {
+				fixer.Fields = strings.Split(fields, ",")
+			}
+			if err == nil {
+				p.Add(fixer)
+			}
+			return
+		},
+		"Force all samples into the header given by the comma-separated fields parameter. Missing metrics are set to the missing value (default 0), additional metrics are dropped. "+
+			"Without the fields parameter, the header of the first sample is used. All outgoing samples share the same header, so following steps never observe a header change.",
+		reg.OptionalParams("fields", "missing"))
+}
+
+// HeaderFixer converts every sample to a fixed header. Values of missing metrics are set to Missing, metrics that are
+// not part of Fields are dropped. If Fields is empty, it is initialized with the header of the first sample.
+type HeaderFixer struct {
+	bitflow.NoopProcessor
+	Fields  []string
+	Missing bitflow.Value
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	indices   []int // For every output field, the index of the input value, or -1 for missing metrics
+}
+
+func (f *HeaderFixer) String() string {
+	if len(f.Fields) == 0 {
+		return fmt.Sprintf("Fix header to the first header (missing value %v)", f.Missing)
+	}
+	return fmt.Sprintf("Fix header to %v field(s) (missing value %v)", len(f.Fields), f.Missing)
+}
+
+func (f *HeaderFixer) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if f.checker.HeaderChanged(header) {
+		f.updateHeader(header)
+	}
+	values := make([]bitflow.Value, len(f.indices))
+	for i, index := range f.indices {
+		if index < 0 {
+			values[i] = f.Missing
+		} else {
+			values[i] = sample.Values[index]
+		}
+	}
+	sample.Values = values
+	return f.NoopProcessor.Sample(sample, f.outHeader)
+}
+
+func (f *HeaderFixer) updateHeader(header *bitflow.Header) {
+	if f.outHeader == nil {
+		if len(f.Fields) == 0 {
+			f.Fields = append([]string(nil), header.Fields...)
+		}
+		f.outHeader = &bitflow.Header{Fields: f.Fields}
+	}
+	inIndices := header.BuildIndex()
+	f.indices = make([]int, len(f.Fields))
+	var missing []string
+	for i, field := range f.Fields {
+		if index, ok := inIndices[field]; ok {
+			f.indices[i] = index
+			delete(inIndices, field)
+		} else {
+			f.indices[i] = -1
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 || len(inIndices) > 0 {
+		f.Log().Warnf("Header with %v field(s) received, %v field(s) missing, dropping %v field(s)", len(header.Fields), len(missing), len(inIndices))
+		if len(missing) > 0 {
+			f.Log().Debugf("Missing fields: %v", missing)
+		}
+	}
+}
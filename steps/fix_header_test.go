@@ -0,0 +1,46 @@
+package steps
+
+import (
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type headerFixerTestSuite struct {
+	testsupport.Suite
+}
+
+func TestHeaderFixer(t *testing.T) {
+	suite.Run(t, new(headerFixerTestSuite))
+}
+
+// fix sends one sample per header through the fixer. The number of values of each sample matches its header.
+func (s *headerFixerTestSuite) fix(fixer *HeaderFixer, headers ...*bitflow.Header) *testsupport.CapturingSink {
+	sink := testsupport.NewCapturingSink()
+	fixer.SetSink(sink)
+	for i, header := range headers {
+		values := make([]bitflow.Value, len(header.Fields))
+		for j := range values {
+			values[j] = bitflow.Value(i*10 + j)
+		}
+		s.NoError(fixer.Sample(testsupport.NewSample(0, "", values...), header))
+	}
+	return sink
+}
+
+func (s *headerFixerTestSuite) TestFields() {
+	fixer := &HeaderFixer{Fields: []string{"a", "b", "c"}, Missing: -1}
+	out := s.fix(fixer, &bitflow.Header{Fields: []string{"c", "a", "b"}}, &bitflow.Header{Fields: []string{"a", "x"}})
+	s.Equal([][]float64{{1, 2, 0}, {10, -1, -1}}, out.Values())
+	headers := out.Headers()
+	s.Equal([]string{"a", "b", "c"}, headers[0].Fields)
+	s.True(headers[0] == headers[1], "the output header must not change")
+}
+
+func (s *headerFixerTestSuite) TestFirstHeader() {
+	out := s.fix(new(HeaderFixer), &bitflow.Header{Fields: []string{"a", "b"}}, &bitflow.Header{Fields: []string{"c", "b", "a"}})
+	s.Equal([][]float64{{0, 1}, {12, 11}}, out.Values())
+	s.Equal([]string{"a", "b"}, out.Headers()[1].Fields)
+}
@@ -0,0 +1,114 @@
+// Package flow provides a data source decoding network flow telemetry (NetFlow v5, NetFlow v9 and sFlow v5)
+// into one sample per flow.
+package flow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Flow is a unidirectional network flow, as reported by a NetFlow exporter or sFlow agent.
+type Flow struct {
+	Time     time.Time
+	Exporter net.IP
+
+	Src, Dst         net.IP
+	SrcPort, DstPort uint16
+	Protocol         uint8
+
+	Bytes, Packets uint64
+}
+
+var protocolNames = map[uint8]string{1: "icmp", 6: "tcp", 17: "udp", 47: "gre", 50: "esp", 58: "icmpv6", 132: "sctp"}
+
+// ProtocolName returns the name of well-known IP protocols, and the protocol number otherwise.
+func (f *Flow) ProtocolName() string {
+	if name, ok := protocolNames[f.Protocol]; ok {
+		return name
+	}
+	return strconv.Itoa(int(f.Protocol))
+}
+
+// Decoder decodes flow datagrams. The version of the datagram is detected automatically. NetFlow v9 templates are
+// remembered per exporter, so one Decoder should be used for all datagrams received on one socket.
+type Decoder struct {
+	templates map[templateKey][]templateField
+
+	// MissingTemplates counts the NetFlow v9 data records that were dropped, because their template was not
+	// received yet. Exporters send their templates periodically, so this is normal after startup.
+	MissingTemplates int
+}
+
+// Decode parses one datagram. The exporter is the sender of the datagram (the agent address contained in sFlow
+// datagrams takes precedence) and received is used as the time of flows that do not carry a timestamp.
+func (d *Decoder) Decode(data []byte, exporter net.IP, received time.Time) ([]Flow, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("Flow datagram too short (%v bytes)", len(data))
+	}
+	switch binary.BigEndian.Uint16(data) {
+	case 5:
+		return decodeNetflow5(data, exporter)
+	case 9:
+		return d.decodeNetflow9(data, exporter)
+	case 0:
+		if version := binary.BigEndian.Uint32(data); version == 5 {
+			return decodeSflow(data, received)
+		}
+	}
+	return nil, fmt.Errorf("Unsupported flow datagram version %v", binary.BigEndian.Uint16(data))
+}
+
+// reader reads big-endian values from a datagram. Reading beyond the end of the data sets err and returns zero values.
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		if r.err == nil {
+			r.err = fmt.Errorf("Truncated flow datagram (%v bytes, expected at least %v)", len(r.data), r.pos+n)
+		}
+		return make([]byte, n)
+	}
+	result := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return result
+}
+
+func (r *reader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *reader) u8() uint8 {
+	return r.bytes(1)[0]
+}
+
+func (r *reader) u16() uint16 {
+	return binary.BigEndian.Uint16(r.bytes(2))
+}
+
+func (r *reader) u32() uint32 {
+	return binary.BigEndian.Uint32(r.bytes(4))
+}
+
+func (r *reader) ip(n int) net.IP {
+	return net.IP(append([]byte(nil), r.bytes(n)...))
+}
+
+func (r *reader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+// uintValue reads a big-endian unsigned integer of up to 8 bytes.
+func uintValue(data []byte) uint64 {
+	var result uint64
+	for _, b := range data {
+		result = result<<8 | uint64(b)
+	}
+	return result
+}
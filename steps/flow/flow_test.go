@@ -0,0 +1,194 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type flowTestSuite struct {
+	suite.Suite
+}
+
+func TestFlow(t *testing.T) {
+	suite.Run(t, new(flowTestSuite))
+}
+
+// datagram builds big-endian test data from uint8, uint16, uint32, net.IP and []byte values
+func datagram(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, value := range values {
+		switch v := value.(type) {
+		case net.IP:
+			if ip4 := v.To4(); ip4 != nil {
+				v = ip4
+			}
+			buf.Write(v)
+		case []byte:
+			buf.Write(v)
+		default:
+			_ = binary.Write(&buf, binary.BigEndian, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+var (
+	exporter = net.ParseIP("192.168.0.1")
+	srcIp    = net.ParseIP("10.0.0.1")
+	dstIp    = net.ParseIP("10.0.0.2")
+)
+
+func (s *flowTestSuite) TestNetflow5() {
+	exportTime := time.Unix(1600000000, 0)
+	data := datagram(uint16(5), uint16(1), uint32(10000), uint32(exportTime.Unix()), uint32(0), uint32(1), uint8(0), uint8(0), uint16(0),
+		srcIp, dstIp, net.ParseIP("10.0.0.254"), uint16(1), uint16(2), uint32(3), uint32(1500), uint32(8000), uint32(9000),
+		uint16(44321), uint16(443), uint8(0), uint8(0x18), uint8(6), uint8(0), uint16(0), uint16(0), uint8(24), uint8(24), uint16(0))
+
+	var decoder Decoder
+	flows, err := decoder.Decode(data, exporter, time.Now())
+	s.Require().NoError(err)
+	s.Equal([]Flow{{
+		Time: exportTime.Add(-time.Second), Exporter: exporter,
+		Src: srcIp.To4(), Dst: dstIp.To4(), SrcPort: 44321, DstPort: 443, Protocol: 6,
+		Bytes: 1500, Packets: 3,
+	}}, flows)
+
+	_, err = decoder.Decode(data[:len(data)-1], exporter, time.Now())
+	s.EqualError(err, "NetFlow v5 datagram with 1 records is truncated (71 bytes)")
+}
+
+func (s *flowTestSuite) TestNetflow9() {
+	exportTime := time.Unix(1600000000, 0)
+	header := []interface{}{uint16(9), uint16(2), uint32(10000), uint32(exportTime.Unix()), uint32(1), uint32(7)}
+	templateSet := []interface{}{uint16(0), uint16(4 + 4 + 7*4), uint16(300), uint16(7),
+		uint16(fieldIpv4SrcAddr), uint16(4), uint16(fieldIpv4DstAddr), uint16(4), uint16(fieldSrcPort), uint16(2),
+		uint16(fieldDstPort), uint16(2), uint16(fieldProtocol), uint16(1), uint16(fieldInBytes), uint16(8), uint16(fieldInPkts), uint16(4)}
+	// Two records of 25 bytes, padded to a multiple of 4 bytes
+	dataSet := []interface{}{uint16(300), uint16(4 + 2*25 + 2),
+		srcIp, dstIp, uint16(53), uint16(5353), uint8(17), uint64(1000), uint32(10),
+		dstIp, srcIp, uint16(0), uint16(0), uint8(1), uint64(84), uint32(1), uint16(0)}
+
+	var decoder Decoder
+	flows, err := decoder.Decode(datagram(append(header, dataSet...)...), exporter, time.Now())
+	s.NoError(err)
+	s.Empty(flows)
+	s.Equal(1, decoder.MissingTemplates)
+
+	flows, err = decoder.Decode(datagram(append(append(header, templateSet...), dataSet...)...), exporter, time.Now())
+	s.Require().NoError(err)
+	s.Equal([]Flow{
+		{Time: exportTime, Exporter: exporter, Src: srcIp.To4(), Dst: dstIp.To4(), SrcPort: 53, DstPort: 5353, Protocol: 17, Bytes: 1000, Packets: 10},
+		{Time: exportTime, Exporter: exporter, Src: dstIp.To4(), Dst: srcIp.To4(), Protocol: 1, Bytes: 84, Packets: 1},
+	}, flows)
+
+	// Templates are remembered per exporter
+	flows, err = decoder.Decode(datagram(append(header, dataSet...)...), exporter, time.Now())
+	s.NoError(err)
+	s.Len(flows, 2)
+	flows, err = decoder.Decode(datagram(append(header, dataSet...)...), net.ParseIP("192.168.0.2"), time.Now())
+	s.NoError(err)
+	s.Empty(flows)
+	s.Equal(2, decoder.MissingTemplates)
+}
+
+func (s *flowTestSuite) TestSflow() {
+	agent := net.ParseIP("192.168.0.10").To4()
+	// Ethernet with VLAN tag, IPv4 and TCP headers
+	packetHeader := datagram(make([]byte, 12), uint16(0x8100), uint16(100), uint16(0x0800),
+		uint8(0x45), uint8(0), uint16(1500), make([]byte, 5), uint8(6), uint16(0), srcIp, dstIp, uint16(22), uint16(50000), make([]byte, 2))
+	rawRecord := datagram(uint32(sflowRawPacketHeader), uint32(16+len(packetHeader)),
+		uint32(headerProtocolEthernet), uint32(1518), uint32(4), uint32(len(packetHeader)), packetHeader)
+	ipv6Record := datagram(uint32(sflowSampledIpv6), uint32(56), uint32(200), uint32(17),
+		net.ParseIP("fe80::1"), net.ParseIP("fe80::2"), uint32(123), uint32(123), uint32(0), uint32(0))
+	flowSample := datagram(uint32(1), uint32(0), uint32(512), uint32(0), uint32(0), uint32(0), uint32(0), uint32(1), rawRecord)
+	expandedSample := datagram(uint32(1), uint32(0), uint32(0), uint32(100), uint32(0), uint32(0), uint32(0), uint32(0), uint32(0), uint32(0),
+		uint32(1), ipv6Record)
+	counterSample := datagram(uint32(1), uint32(0), uint32(0))
+	data := datagram(uint32(5), uint32(1), agent, uint32(0), uint32(1), uint32(1000), uint32(3),
+		uint32(sflowFlowSample), uint32(len(flowSample)), flowSample,
+		uint32(2), uint32(len(counterSample)), counterSample,
+		uint32(sflowExpandedFlowSample), uint32(len(expandedSample)), expandedSample)
+
+	var decoder Decoder
+	received := time.Now()
+	flows, err := decoder.Decode(data, exporter, received)
+	s.Require().NoError(err)
+	s.Equal([]Flow{
+		{Time: received, Exporter: agent, Src: srcIp.To4(), Dst: dstIp.To4(), SrcPort: 22, DstPort: 50000, Protocol: 6, Bytes: 1518 * 512, Packets: 512},
+		{Time: received, Exporter: agent, Src: net.ParseIP("fe80::1"), Dst: net.ParseIP("fe80::2"), SrcPort: 123, DstPort: 123, Protocol: 17, Bytes: 200 * 100, Packets: 100},
+	}, flows)
+
+	flows, err = decoder.Decode(data[:len(data)-10], exporter, received)
+	s.EqualError(err, "Truncated flow datagram (262 bytes, expected at least 272)")
+	s.Len(flows, 1)
+}
+
+func (s *flowTestSuite) TestInvalidDatagrams() {
+	var decoder Decoder
+	_, err := decoder.Decode([]byte{0, 10, 0, 0}, exporter, time.Now())
+	s.EqualError(err, "Unsupported flow datagram version 10")
+	_, err = decoder.Decode([]byte{0, 5}, exporter, time.Now())
+	s.EqualError(err, "Flow datagram too short (2 bytes)")
+}
+
+func (s *flowTestSuite) TestFlowSample() {
+	sample := FlowSample(&Flow{Exporter: exporter, Src: srcIp, Dst: dstIp, SrcPort: 1, DstPort: 2, Protocol: 6, Bytes: 100, Packets: 2})
+	s.Equal([]bitflow.Value{100, 2}, sample.Values)
+	s.Equal(map[string]string{ExporterTag: "192.168.0.1", SrcTag: "10.0.0.1", DstTag: "10.0.0.2", SrcPortTag: "1", DstPortTag: "2", ProtocolTag: "tcp"}, sample.TagMap())
+
+	sample = FlowSample(&Flow{Src: srcIp, Dst: dstIp, Protocol: 89})
+	s.Equal(map[string]string{SrcTag: "10.0.0.1", DstTag: "10.0.0.2", ProtocolTag: "89"}, sample.TagMap())
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	lock    sync.Mutex
+	samples []*bitflow.Sample
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, _ *bitflow.Header) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.samples = append(s.samples, sample)
+	return nil
+}
+
+func (s *collectingSink) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.samples)
+}
+
+func (s *flowTestSuite) TestSource() {
+	source := &Source{Endpoint: "127.0.0.1:0"}
+	out := new(collectingSink)
+	source.SetSink(out)
+	var wg sync.WaitGroup
+	stopped := source.Start(&wg)
+	s.Require().False(stopped.Stopped(), "%v", stopped.Err())
+
+	conn, err := net.Dial("udp", source.conn.LocalAddr().String())
+	s.Require().NoError(err)
+	defer conn.Close()
+	_, err = conn.Write(datagram(uint16(5), uint16(1), make([]byte, 20),
+		srcIp, dstIp, make([]byte, 8), uint32(1), uint32(60), make([]byte, 8), uint16(1), uint16(2), uint16(0), uint8(17), make([]byte, 9)))
+	s.Require().NoError(err)
+	for i := 0; i < 100 && out.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	source.Close()
+	wg.Wait()
+
+	s.Require().Equal(1, out.count())
+	s.Equal([]bitflow.Value{60, 1}, out.samples[0].Values)
+	s.Equal("127.0.0.1", out.samples[0].Tag(ExporterTag))
+	s.Equal("udp", out.samples[0].Tag(ProtocolTag))
+	s.NoError(stopped.Err())
+}
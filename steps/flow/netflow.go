@@ -0,0 +1,181 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	netflow5HeaderLen = 24
+	netflow5RecordLen = 48
+
+	netflow9TemplateSet        = 0
+	netflow9OptionsTemplateSet = 1
+	netflow9MinDataSet         = 256
+)
+
+// NetFlow v9 field types (RFC 3954) that are used for flows.
+const (
+	fieldInBytes      = 1
+	fieldInPkts       = 2
+	fieldProtocol     = 4
+	fieldSrcPort      = 7
+	fieldIpv4SrcAddr  = 8
+	fieldDstPort      = 11
+	fieldIpv4DstAddr  = 12
+	fieldLastSwitched = 21
+	fieldOutBytes     = 23
+	fieldOutPkts      = 24
+	fieldIpv6SrcAddr  = 27
+	fieldIpv6DstAddr  = 28
+)
+
+func decodeNetflow5(data []byte, exporter net.IP) ([]Flow, error) {
+	r := &reader{data: data}
+	r.skip(2) // Version
+	count := int(r.u16())
+	uptime := r.u32()
+	exportTime := time.Unix(int64(r.u32()), int64(r.u32()))
+	r.skip(6) // Sequence number, engine type and id
+	sampling := uint64(r.u16() & 0x3FFF)
+	if sampling == 0 {
+		sampling = 1
+	}
+	if r.err == nil && r.remaining() < count*netflow5RecordLen {
+		return nil, fmt.Errorf("NetFlow v5 datagram with %v records is truncated (%v bytes)", count, len(data))
+	}
+
+	flows := make([]Flow, count)
+	for i := range flows {
+		flow := &flows[i]
+		flow.Exporter = exporter
+		flow.Src = r.ip(4)
+		flow.Dst = r.ip(4)
+		r.skip(8) // Next hop, input and output interface
+		flow.Packets = uint64(r.u32()) * sampling
+		flow.Bytes = uint64(r.u32()) * sampling
+		r.skip(4) // Start of the flow
+		last := r.u32()
+		flow.SrcPort = r.u16()
+		flow.DstPort = r.u16()
+		r.skip(2) // Padding and TCP flags
+		flow.Protocol = r.u8()
+		r.skip(9) // TOS, AS numbers, masks and padding
+
+		// The end of the flow is given as system uptime in milliseconds
+		flow.Time = exportTime.Add(-time.Duration(uptime-last) * time.Millisecond)
+	}
+	return flows, r.err
+}
+
+type templateKey struct {
+	exporter   string
+	sourceId   uint32
+	templateId uint16
+}
+
+type templateField struct {
+	typ    uint16
+	length int
+}
+
+func (d *Decoder) decodeNetflow9(data []byte, exporter net.IP) ([]Flow, error) {
+	r := &reader{data: data}
+	r.skip(4) // Version and record count
+	uptime := r.u32()
+	exportTime := time.Unix(int64(r.u32()), 0)
+	r.skip(4) // Sequence number
+	sourceId := r.u32()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var flows []Flow
+	for r.remaining() >= 4 {
+		setId := r.u16()
+		setLen := int(r.u16())
+		if setLen < 4 {
+			return flows, fmt.Errorf("Invalid NetFlow v9 flowset length %v", setLen)
+		}
+		set := &reader{data: r.bytes(setLen - 4)}
+		if r.err != nil {
+			return flows, r.err
+		}
+		switch {
+		case setId == netflow9TemplateSet:
+			if err := d.readTemplates(set, templateKey{exporter: exporter.String(), sourceId: sourceId}); err != nil {
+				return flows, err
+			}
+		case setId == netflow9OptionsTemplateSet, setId < netflow9MinDataSet:
+			// Options (e.g. sampling configuration) are not used
+		default:
+			key := templateKey{exporter: exporter.String(), sourceId: sourceId, templateId: setId}
+			template, ok := d.templates[key]
+			if !ok {
+				d.MissingTemplates++
+				continue
+			}
+			recordLen := 0
+			for _, field := range template {
+				recordLen += field.length
+			}
+			// The flowset might be padded to a multiple of 4 bytes
+			for recordLen > 0 && set.remaining() >= recordLen {
+				flow := Flow{Exporter: exporter, Time: exportTime}
+				for _, field := range template {
+					readNetflow9Field(&flow, field, set.bytes(field.length), exportTime, uptime)
+				}
+				flows = append(flows, flow)
+			}
+		}
+	}
+	return flows, nil
+}
+
+func (d *Decoder) readTemplates(set *reader, key templateKey) error {
+	for set.remaining() >= 4 {
+		key.templateId = set.u16()
+		fields := make([]templateField, set.u16())
+		for i := range fields {
+			fields[i] = templateField{typ: set.u16(), length: int(set.u16())}
+		}
+		if set.err != nil {
+			return fmt.Errorf("Invalid NetFlow v9 template %v: %v", key.templateId, set.err)
+		}
+		if d.templates == nil {
+			d.templates = make(map[templateKey][]templateField)
+		}
+		d.templates[key] = fields
+	}
+	return nil
+}
+
+func readNetflow9Field(flow *Flow, field templateField, value []byte, exportTime time.Time, uptime uint32) {
+	switch field.typ {
+	case fieldInBytes:
+		flow.Bytes = uintValue(value)
+	case fieldOutBytes:
+		if flow.Bytes == 0 {
+			flow.Bytes = uintValue(value)
+		}
+	case fieldInPkts:
+		flow.Packets = uintValue(value)
+	case fieldOutPkts:
+		if flow.Packets == 0 {
+			flow.Packets = uintValue(value)
+		}
+	case fieldProtocol:
+		flow.Protocol = uint8(uintValue(value))
+	case fieldSrcPort:
+		flow.SrcPort = uint16(uintValue(value))
+	case fieldDstPort:
+		flow.DstPort = uint16(uintValue(value))
+	case fieldIpv4SrcAddr, fieldIpv6SrcAddr:
+		flow.Src = net.IP(append([]byte(nil), value...))
+	case fieldIpv4DstAddr, fieldIpv6DstAddr:
+		flow.Dst = net.IP(append([]byte(nil), value...))
+	case fieldLastSwitched:
+		flow.Time = exportTime.Add(-time.Duration(uptime-uint32(uintValue(value))) * time.Millisecond)
+	}
+}
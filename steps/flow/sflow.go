@@ -0,0 +1,186 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// sFlow v5 structure formats (enterprise 0), see https://sflow.org/sflow_version_5.txt
+const (
+	sflowFlowSample         = 1
+	sflowExpandedFlowSample = 3
+
+	sflowRawPacketHeader = 1
+	sflowSampledIpv4     = 3
+	sflowSampledIpv6     = 4
+
+	headerProtocolEthernet = 1
+	headerProtocolIpv4     = 11
+	headerProtocolIpv6     = 12
+)
+
+// decodeSflow returns one flow for every flow sample in the datagram. sFlow samples individual packets, so the
+// packet count and size are multiplied with the sampling rate to estimate the actual traffic. Counter samples
+// are ignored.
+func decodeSflow(data []byte, received time.Time) ([]Flow, error) {
+	r := &reader{data: data}
+	r.skip(4) // Version
+	var agent net.IP
+	switch addrType := r.u32(); addrType {
+	case 1:
+		agent = r.ip(4)
+	case 2:
+		agent = r.ip(16)
+	default:
+		return nil, fmt.Errorf("Invalid sFlow agent address type %v", addrType)
+	}
+	r.skip(12) // Sub agent id, sequence number and uptime
+	numSamples := int(r.u32())
+
+	var flows []Flow
+	for i := 0; i < numSamples && r.err == nil; i++ {
+		format := r.u32()
+		sample := &reader{data: r.bytes(int(r.u32()))}
+		if r.err != nil {
+			break
+		}
+		var expanded bool
+		switch format {
+		case sflowFlowSample:
+		case sflowExpandedFlowSample:
+			expanded = true
+		default:
+			// Counter samples and enterprise-specific formats
+			continue
+		}
+		flow, ok, err := decodeSflowSample(sample, expanded)
+		if err != nil {
+			return flows, err
+		}
+		if ok {
+			flow.Time = received
+			flow.Exporter = agent
+			flows = append(flows, flow)
+		}
+	}
+	return flows, r.err
+}
+
+func decodeSflowSample(r *reader, expanded bool) (flow Flow, ok bool, err error) {
+	if expanded {
+		r.skip(12) // Sequence number, source id type and index
+	} else {
+		r.skip(8) // Sequence number and source id
+	}
+	samplingRate := uint64(r.u32())
+	if expanded {
+		r.skip(24) // Sample pool, drops, input and output interface (format and value)
+	} else {
+		r.skip(16) // Sample pool, drops, input and output interface
+	}
+	numRecords := int(r.u32())
+	if samplingRate == 0 {
+		samplingRate = 1
+	}
+
+	for i := 0; i < numRecords && r.err == nil; i++ {
+		format := r.u32()
+		record := &reader{data: r.bytes(int(r.u32()))}
+		switch format {
+		case sflowRawPacketHeader:
+			protocol := record.u32()
+			frameLen := uint64(record.u32())
+			record.skip(4) // Stripped bytes
+			header := record.bytes(int(record.u32()))
+			if record.err == nil && decodePacketHeader(protocol, header, &flow) {
+				flow.Bytes = frameLen * samplingRate
+				ok = true
+			}
+		case sflowSampledIpv4, sflowSampledIpv6:
+			if ok {
+				// Prefer the information from the raw packet header
+				continue
+			}
+			length := uint64(record.u32())
+			flow.Protocol = uint8(record.u32())
+			addrLen := 4
+			if format == sflowSampledIpv6 {
+				addrLen = 16
+			}
+			flow.Src = record.ip(addrLen)
+			flow.Dst = record.ip(addrLen)
+			flow.SrcPort = uint16(record.u32())
+			flow.DstPort = uint16(record.u32())
+			flow.Bytes = length * samplingRate
+			ok = record.err == nil
+		}
+	}
+	flow.Packets = samplingRate
+	return flow, ok, r.err
+}
+
+// decodePacketHeader extracts the addresses, protocol and ports from the sampled header of a packet.
+func decodePacketHeader(protocol uint32, header []byte, flow *Flow) bool {
+	r := &reader{data: header}
+	switch protocol {
+	case headerProtocolEthernet:
+		r.skip(12) // MAC addresses
+		etherType := r.u16()
+		for etherType == 0x8100 || etherType == 0x88A8 {
+			// VLAN tags
+			r.skip(2)
+			etherType = r.u16()
+		}
+		switch etherType {
+		case 0x0800:
+			return decodeIpv4Header(r, flow)
+		case 0x86DD:
+			return decodeIpv6Header(r, flow)
+		}
+	case headerProtocolIpv4:
+		return decodeIpv4Header(r, flow)
+	case headerProtocolIpv6:
+		return decodeIpv6Header(r, flow)
+	}
+	return false
+}
+
+func decodeIpv4Header(r *reader, flow *Flow) bool {
+	headerLen := int(r.u8()&0x0F) * 4
+	r.skip(8)
+	flow.Protocol = r.u8()
+	r.skip(2) // Checksum
+	flow.Src = r.ip(4)
+	flow.Dst = r.ip(4)
+	r.skip(headerLen - 20) // Options
+	if r.err != nil {
+		return false
+	}
+	decodePorts(r, flow)
+	return true
+}
+
+func decodeIpv6Header(r *reader, flow *Flow) bool {
+	r.skip(6)
+	// Extension headers are not parsed, the ports are only available if the next header is TCP or UDP
+	flow.Protocol = r.u8()
+	r.skip(1) // Hop limit
+	flow.Src = r.ip(16)
+	flow.Dst = r.ip(16)
+	if r.err != nil {
+		return false
+	}
+	decodePorts(r, flow)
+	return true
+}
+
+func decodePorts(r *reader, flow *Flow) {
+	switch flow.Protocol {
+	case 6, 17, 132: // TCP, UDP, SCTP
+		if r.remaining() >= 4 {
+			flow.SrcPort = r.u16()
+			flow.DstPort = r.u16()
+		}
+	}
+}
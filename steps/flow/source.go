@@ -0,0 +1,143 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FlowEndpoint = bitflow.EndpointType("flow")
+
+	ExporterTag = "exporter"
+	SrcTag      = "src"
+	DstTag      = "dst"
+	SrcPortTag  = "src-port"
+	DstPortTag  = "dst-port"
+	ProtocolTag = "proto"
+
+	maxDatagramSize = 65535
+	readTimeout     = time.Second
+)
+
+var flowHeader = &bitflow.Header{Fields: []string{"bytes", "packets"}}
+
+// Source listens for NetFlow v5, NetFlow v9 and sFlow v5 datagrams on a UDP socket and emits one sample per flow.
+// The samples contain the number of bytes and packets and are tagged with the exporter, the source and destination
+// addresses and ports, and the IP protocol.
+type Source struct {
+	bitflow.AbstractSampleSource
+
+	// Endpoint is the UDP address to listen on, e.g. :2055 (NetFlow) or :6343 (sFlow)
+	Endpoint string
+
+	decoder Decoder
+	conn    net.PacketConn
+	task    *golib.LoopTask
+}
+
+func (s *Source) String() string {
+	return "Flow datagrams on udp " + s.Endpoint
+}
+
+func (s *Source) Start(wg *sync.WaitGroup) golib.StopChan {
+	conn, err := net.ListenPacket("udp", s.Endpoint)
+	if err != nil {
+		return golib.NewStoppedChan(err)
+	}
+	s.conn = conn
+	log.Println("Listening for flow datagrams on", conn.LocalAddr())
+
+	buf := make([]byte, maxDatagramSize)
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook: func() {
+			_ = s.conn.Close()
+			s.CloseSinkParallel(wg)
+		},
+		Loop: func(stop golib.StopChan) error {
+			// The deadline makes sure that the task notices when it is stopped without closing the connection
+			if err := s.conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+				return err
+			}
+			n, addr, err := s.conn.ReadFrom(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); (ok && netErr.Timeout()) || stop.Stopped() {
+					return nil
+				}
+				return err
+			}
+			return s.handleDatagram(buf[:n], addr)
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *Source) Close() {
+	s.task.Stop()
+	// Interrupt the current read
+	_ = s.conn.Close()
+}
+
+func (s *Source) handleDatagram(data []byte, addr net.Addr) error {
+	var exporter net.IP
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		exporter = udpAddr.IP
+	}
+	missingTemplates := s.decoder.MissingTemplates
+	flows, err := s.decoder.Decode(data, exporter, time.Now())
+	if err != nil {
+		// Continue with the flows that could be decoded
+		s.Log().Warnf("Invalid datagram from %v: %v", addr, err)
+	}
+	if s.decoder.MissingTemplates > missingTemplates {
+		s.Log().Debugf("Dropped NetFlow v9 records from %v, because the template is not known yet", addr)
+	}
+	for i := range flows {
+		if err := s.GetSink().Sample(FlowSample(&flows[i]), flowHeader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlowSample converts a flow to a sample with the fields bytes and packets.
+func FlowSample(flow *Flow) *bitflow.Sample {
+	sample := &bitflow.Sample{
+		Time:   flow.Time,
+		Values: []bitflow.Value{bitflow.Value(flow.Bytes), bitflow.Value(flow.Packets)},
+	}
+	if flow.Exporter != nil {
+		sample.SetTag(ExporterTag, flow.Exporter.String())
+	}
+	if flow.Src != nil {
+		sample.SetTag(SrcTag, flow.Src.String())
+	}
+	if flow.Dst != nil {
+		sample.SetTag(DstTag, flow.Dst.String())
+	}
+	sample.SetTag(ProtocolTag, flow.ProtocolName())
+	if flow.SrcPort != 0 || flow.DstPort != 0 {
+		sample.SetTag(SrcPortTag, strconv.Itoa(int(flow.SrcPort)))
+		sample.SetTag(DstPortTag, strconv.Itoa(int(flow.DstPort)))
+	}
+	return sample
+}
+
+// RegisterFlowSource registers the flow:// data source. The target is the UDP address to listen on, e.g. flow://:2055.
+// The datagram format (NetFlow v5, NetFlow v9 or sFlow v5) is detected automatically.
+func RegisterFlowSource(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[FlowEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return nil, fmt.Errorf("Invalid UDP address for %v endpoint: %v", FlowEndpoint, err)
+		}
+		return &Source{Endpoint: target}, nil
+	}
+}
@@ -2,6 +2,7 @@ package steps
 
 import (
 	"fmt"
+	"runtime"
 	"sort"
 	"strconv"
 
@@ -13,8 +14,127 @@ import (
 // This function is placed in this package to avoid circular dependency between the fork and the query package.
 func RegisterForks(b reg.ProcessorRegistry) {
 	b.RegisterFork("rr", fork_round_robin, "The round-robin fork distributes the samples to the subpipelines based on weights. The pipeline selector keys must be positive integers denoting the weight of the respective pipeline.")
-	b.RegisterFork("fork_tag", fork_tag, "Fork based on the values of the given tag", reg.RequiredParams("tag"), reg.OptionalParams("regex", "exact"))
-	b.RegisterFork("fork_tag_template", fork_tag_template, "Fork based on a template string, placeholders like ${xxx} are replaced by tag values.", reg.RequiredParams("template"), reg.OptionalParams("regex", "exact"))
+	b.RegisterFork("fork_tag", fork_tag, "Fork based on the values of the given tag", reg.RequiredParams("tag"), reg.OptionalParams("regex", "exact", "idle-timeout"),
+		reg.ParamDetails("idle-timeout", reg.TypeDuration, "0", idleTimeoutDescription))
+	b.RegisterFork("fork_tag_template", fork_tag_template, "Fork based on a template string, placeholders like ${xxx} are replaced by tag values.", reg.RequiredParams("template"), reg.OptionalParams("regex", "exact", "idle-timeout"),
+		reg.ParamDetails("idle-timeout", reg.TypeDuration, "0", idleTimeoutDescription))
+	b.RegisterFork("fork_parallel", fork_parallel,
+		"Parallelize a subpipeline by distributing the samples over multiple instances of it, either round-robin or to the instance with the fewest queued samples. "+
+			"If multiple subpipelines are given, they are used as the instances. Subpipeline keys are ignored.",
+		reg.OptionalParams("instances", "mode", "buf"),
+		reg.ParamDetails("instances", reg.TypeInt, "number of CPUs", "Number of instances of the single subpipeline"),
+		reg.ParamDetails("mode", reg.TypeString, ParallelLeastLoaded, "How to select the instance for each sample: "+ParallelRoundRobin+" or "+ParallelLeastLoaded),
+		reg.ParamDetails("buf", reg.TypeInt, strconv.Itoa(DefaultForkBuffer), "Buffer of the decoupling step added to the start of every instance, 0 disables the decoupling"))
+	b.RegisterFork("fork_hash", fork_hash,
+		"Distribute the samples over multiple instances of a subpipeline by consistent hashing of a tag (or tag template), so that samples with the same tag values always land in the same instance. "+
+			"If multiple subpipelines are given, they are used as the instances. Subpipeline keys are ignored.",
+		reg.OptionalParams("tag", "template", "instances", "virtual-nodes", "buf"),
+		reg.ParamDetails("tag", reg.TypeString, "", "Tag to hash"),
+		reg.ParamDetails("template", reg.TypeString, "", "Template to hash, placeholders like ${xxx} are replaced by tag values (alternative to tag)"),
+		reg.ParamDetails("instances", reg.TypeInt, "number of CPUs", "Number of instances of the single subpipeline"),
+		reg.ParamDetails("virtual-nodes", reg.TypeInt, strconv.Itoa(fork.DefaultVirtualNodes), "Number of positions of every instance on the hash ring"),
+		reg.ParamDetails("buf", reg.TypeInt, strconv.Itoa(DefaultForkBuffer), "Buffer of the decoupling step added to the start of every instance, 0 disables the decoupling"))
+}
+
+const idleTimeoutDescription = "Close subpipelines that did not receive samples for this duration, they are created again when their key occurs again. 0 disables the expiration"
+
+const (
+	ParallelRoundRobin  = "round-robin"
+	ParallelLeastLoaded = "least-loaded"
+
+	DefaultForkBuffer = 100
+)
+
+func fork_parallel(subpipelines []reg.Subpipeline, params map[string]string) (fork.Distributor, error) {
+	var err error
+	mode := reg.StrParam(params, "mode", ParallelLeastLoaded, true, &err)
+	if err != nil {
+		return nil, err
+	}
+	pipes, err := build_fork_instances(subpipelines, params)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case ParallelRoundRobin:
+		res := new(fork.RoundRobinDistributor)
+		res.Subpipelines = pipes
+		return res, nil
+	case ParallelLeastLoaded:
+		res := new(fork.LeastLoadedDistributor)
+		res.Subpipelines = pipes
+		return res, nil
+	default:
+		return nil, reg.ParameterError("mode", fmt.Errorf("Expected '%v' or '%v', but got '%v'", ParallelRoundRobin, ParallelLeastLoaded, mode))
+	}
+}
+
+func fork_hash(subpipelines []reg.Subpipeline, params map[string]string) (fork.Distributor, error) {
+	var err error
+	tag := reg.StrParam(params, "tag", "", true, &err)
+	template := reg.StrParam(params, "template", "", true, &err)
+	virtualNodes := reg.IntParam(params, "virtual-nodes", fork.DefaultVirtualNodes, true, &err)
+	if err != nil {
+		return nil, err
+	}
+	if (tag == "") == (template == "") {
+		return nil, fmt.Errorf("Exactly one of the parameters 'tag' and 'template' must be defined")
+	}
+	if virtualNodes < 1 {
+		return nil, reg.ParameterError("virtual-nodes", fmt.Errorf("Must be positive"))
+	}
+	if tag != "" {
+		template = "${" + tag + "}"
+	}
+	res := &fork.HashDistributor{
+		TagTemplate:  bitflow.TagTemplate{Template: template},
+		VirtualNodes: virtualNodes,
+	}
+	res.Subpipelines, err = build_fork_instances(subpipelines, params)
+	return res, err
+}
+
+// build_fork_instances builds the given number of instances of a single subpipeline, or builds every subpipeline
+// once if there are multiple. If configured, every instance starts with a decoupling step, so that the instances
+// run in parallel.
+func build_fork_instances(subpipelines []reg.Subpipeline, params map[string]string) ([]*bitflow.SamplePipeline, error) {
+	var err error
+	instances := reg.IntParam(params, "instances", 0, true, &err)
+	buf := reg.IntParam(params, "buf", DefaultForkBuffer, true, &err)
+	if err != nil {
+		return nil, err
+	}
+	if instances < 0 {
+		return nil, reg.ParameterError("instances", fmt.Errorf("Must not be negative"))
+	}
+	if buf < 0 {
+		return nil, reg.ParameterError("buf", fmt.Errorf("Must not be negative"))
+	}
+	if len(subpipelines) == 0 {
+		return nil, fmt.Errorf("At least one subpipeline must be defined")
+	}
+	if len(subpipelines) > 1 && instances > 0 {
+		return nil, reg.ParameterError("instances", fmt.Errorf("Can only be used with a single subpipeline, but %v subpipelines are defined", len(subpipelines)))
+	}
+	if len(subpipelines) == 1 && instances == 0 {
+		instances = runtime.NumCPU()
+	}
+	pipes := make([]*bitflow.SamplePipeline, 0, instances)
+	for i := 0; i < instances || i < len(subpipelines); i++ {
+		subpipe := subpipelines[0]
+		if len(subpipelines) > 1 {
+			subpipe = subpipelines[i]
+		}
+		pipe, err := subpipe.Build()
+		if err != nil {
+			return nil, err
+		}
+		if buf > 0 {
+			pipe.Processors = append([]bitflow.SampleProcessor{&DecouplingProcessor{ChannelBuffer: buf}}, pipe.Processors...)
+		}
+		pipes = append(pipes, pipe)
+	}
+	return pipes, nil
 }
 
 func fork_round_robin(subpipelines []reg.Subpipeline, _ map[string]string) (fork.Distributor, error) {
@@ -71,9 +191,10 @@ func fork_tag_template(subpipelines []reg.Subpipeline, params map[string]string)
 			Template: params["template"],
 		},
 		RegexDistributor: fork.RegexDistributor{
-			Pipelines:  wildcardPipelines,
-			ExactMatch: reg.BoolParam(params, "exact", false, true, &err),
-			RegexMatch: reg.BoolParam(params, "regex", false, true, &err),
+			Pipelines:   wildcardPipelines,
+			ExactMatch:  reg.BoolParam(params, "exact", false, true, &err),
+			RegexMatch:  reg.BoolParam(params, "regex", false, true, &err),
+			IdleTimeout: reg.DurationParam(params, "idle-timeout", 0, true, &err),
 		},
 	}
 	if err == nil {
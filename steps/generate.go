@@ -0,0 +1,270 @@
+package steps
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const GenerateEndpoint = bitflow.EndpointType("generate")
+
+// Shapes of the series produced by a Generator
+const (
+	GenerateSine = "sine"
+	GenerateWalk = "walk"
+	GenerateStep = "step"
+
+	DefaultGeneratePeriod     = 60
+	DefaultAnomalySize        = 5
+	DefaultGenerateAnomalyTag = "anomaly"
+)
+
+var generatorParams = []string{"shape", "metrics", "rate", "samples", "period", "amplitude", "offset", "noise", "anomalies", "anomaly-size", "tag", "seed"}
+
+// RegisterGeneratorSource registers the generate:// data source. The target contains optional query parameters,
+// e.g. generate://shape=sine,walk&metrics=4&rate=10&noise=0.1. The target '-' uses the default settings.
+func RegisterGeneratorSource(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[GenerateEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		params := make(map[string]string)
+		if target != "-" {
+			query, err := url.ParseQuery(target)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse parameters of %v endpoint: %v", GenerateEndpoint, err)
+			}
+			for key, values := range query {
+				params[key] = strings.Join(values, ",")
+			}
+		}
+		return NewGeneratorSource(params)
+	}
+}
+
+// NewGeneratorSource creates a GeneratorSource from the query parameters of a generate:// endpoint:
+//   - shape: comma-separated list of sine, walk and step. The shapes are assigned to the metrics in turn (default sine)
+//   - metrics: number of generated metrics (default 1)
+//   - rate: samples per second, 0 means as fast as possible (default 1)
+//   - samples: number of samples before the source closes, 0 means unlimited (default 0)
+//   - period: period of sine waves and duration of steps, in samples (default 60)
+//   - amplitude, offset: scale and baseline of all series (default 1 and 0)
+//   - noise: standard deviation of gaussian noise added to all values (default 0)
+//   - anomalies: probability of a sample containing an anomaly (default 0)
+//   - anomaly-size: size of anomalies, relative to the amplitude (default 5)
+//   - tag: tag that receives the name of the anomalous metric (default 'anomaly')
+//   - seed: seed for the random number generator (default 1)
+func NewGeneratorSource(params map[string]string) (*GeneratorSource, error) {
+	var err error
+	gen := &Generator{
+		Metrics:     reg.IntParam(params, "metrics", 1, true, &err),
+		Period:      reg.IntParam(params, "period", DefaultGeneratePeriod, true, &err),
+		Amplitude:   reg.FloatParam(params, "amplitude", 1, true, &err),
+		Offset:      reg.FloatParam(params, "offset", 0, true, &err),
+		Noise:       reg.FloatParam(params, "noise", 0, true, &err),
+		Anomalies:   reg.FloatParam(params, "anomalies", 0, true, &err),
+		AnomalySize: reg.FloatParam(params, "anomaly-size", DefaultAnomalySize, true, &err),
+		AnomalyTag:  reg.StrParam(params, "tag", DefaultGenerateAnomalyTag, true, &err),
+		Seed:        int64(reg.IntParam(params, "seed", 1, true, &err)),
+	}
+	shapes := reg.StrParam(params, "shape", GenerateSine, true, &err)
+	source := &GeneratorSource{
+		Rate:    reg.FloatParam(params, "rate", 1, true, &err),
+		Samples: reg.IntParam(params, "samples", 0, true, &err),
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key := range params {
+		known := false
+		for _, allowed := range generatorParams {
+			known = known || key == allowed
+		}
+		if !known {
+			return nil, fmt.Errorf("Unexpected parameter for %v endpoint: %v (allowed: %v)", GenerateEndpoint, key, strings.Join(generatorParams, ", "))
+		}
+	}
+	gen.Shapes = strings.Split(shapes, ",")
+	for _, shape := range gen.Shapes {
+		if shape != GenerateSine && shape != GenerateWalk && shape != GenerateStep {
+			return nil, reg.ParameterError("shape", fmt.Errorf("Expected %v, %v or %v, but got '%v'", GenerateSine, GenerateWalk, GenerateStep, shape))
+		}
+	}
+	switch {
+	case gen.Metrics < 1:
+		err = reg.ParameterError("metrics", fmt.Errorf("Must be positive"))
+	case gen.Period < 1:
+		err = reg.ParameterError("period", fmt.Errorf("Must be positive"))
+	case source.Rate < 0:
+		err = reg.ParameterError("rate", fmt.Errorf("Must not be negative"))
+	case source.Samples < 0:
+		err = reg.ParameterError("samples", fmt.Errorf("Must not be negative"))
+	case gen.Anomalies < 0 || gen.Anomalies > 1:
+		err = reg.ParameterError("anomalies", fmt.Errorf("Must be in [0..1]"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	source.Generator = gen
+	return source, nil
+}
+
+// Generator produces deterministic synthetic time series. Every metric follows one of the Shapes (assigned in turn),
+// scaled by Amplitude and shifted by Offset. Sine waves have a period of Period samples and are phase-shifted between metrics,
+// random walks move by a gaussian step of Amplitude/10 per sample, and step functions jump to a random level in [-Amplitude..Amplitude]
+// every Period samples. Gaussian noise with a standard deviation of Noise is added to all values.
+// With probability Anomalies, a sample contains a spike of AnomalySize*Amplitude in one random metric. The name of that
+// metric is stored in the AnomalyTag of the sample.
+type Generator struct {
+	Shapes      []string
+	Metrics     int
+	Period      int
+	Amplitude   float64
+	Offset      float64
+	Noise       float64
+	Anomalies   float64
+	AnomalySize float64
+	AnomalyTag  string
+	Seed        int64
+
+	header *bitflow.Header
+	random *rand.Rand
+	levels []float64 // Current values of random walks and step functions
+	index  int
+}
+
+func (g *Generator) String() string {
+	res := fmt.Sprintf("Generate %v metric(s) (shapes %v, period %v, amplitude %v, offset %v", g.Metrics, strings.Join(g.Shapes, ","), g.Period, g.Amplitude, g.Offset)
+	if g.Noise > 0 {
+		res += fmt.Sprintf(", noise %v", g.Noise)
+	}
+	if g.Anomalies > 0 {
+		res += fmt.Sprintf(", anomalies %v", g.Anomalies)
+	}
+	return res + ")"
+}
+
+func (g *Generator) shape(metric int) string {
+	if len(g.Shapes) == 0 {
+		return GenerateSine
+	}
+	return g.Shapes[metric%len(g.Shapes)]
+}
+
+// Header returns the header of the generated samples. The metrics are named after their shape and index, e.g. sine0, walk1.
+func (g *Generator) Header() *bitflow.Header {
+	if g.header == nil {
+		fields := make([]string, g.Metrics)
+		for i := range fields {
+			fields[i] = g.shape(i) + strconv.Itoa(i)
+		}
+		g.header = &bitflow.Header{Fields: fields}
+		g.random = rand.New(rand.NewSource(g.Seed))
+		g.levels = make([]float64, g.Metrics)
+	}
+	return g.header
+}
+
+// Next returns the next generated sample with the given timestamp.
+func (g *Generator) Next(timestamp time.Time) *bitflow.Sample {
+	header := g.Header()
+	values := make([]bitflow.Value, len(header.Fields))
+	for i := range values {
+		var value float64
+		switch g.shape(i) {
+		case GenerateSine:
+			phase := float64(i) / float64(g.Metrics)
+			value = math.Sin(2 * math.Pi * (float64(g.index)/float64(g.Period) + phase))
+		case GenerateWalk:
+			g.levels[i] += g.random.NormFloat64() / 10
+			value = g.levels[i]
+		case GenerateStep:
+			if g.index%g.Period == 0 {
+				g.levels[i] = g.random.Float64()*2 - 1
+			}
+			value = g.levels[i]
+		}
+		value = g.Offset + value*g.Amplitude
+		if g.Noise > 0 {
+			value += g.random.NormFloat64() * g.Noise
+		}
+		values[i] = bitflow.Value(value)
+	}
+	sample := &bitflow.Sample{Time: timestamp, Values: values}
+	if g.Anomalies > 0 && g.random.Float64() < g.Anomalies {
+		metric := g.random.Intn(len(values))
+		spike := g.AnomalySize * g.Amplitude
+		if g.random.Intn(2) == 0 {
+			spike = -spike
+		}
+		values[metric] += bitflow.Value(spike)
+		sample.SetTag(g.AnomalyTag, header.Fields[metric])
+	}
+	g.index++
+	return sample
+}
+
+// GeneratorSource is a data source emitting the samples of a Generator with the given Rate (samples per second).
+// The timestamps of the samples are spaced evenly according to the Rate (one second, if Rate is 0), starting at the current time.
+// If Samples is positive, the source closes after emitting that number of samples.
+type GeneratorSource struct {
+	bitflow.AbstractSampleSource
+	*Generator
+	Rate    float64
+	Samples int
+
+	task *golib.LoopTask
+}
+
+func (s *GeneratorSource) String() string {
+	res := s.Generator.String()
+	if s.Rate > 0 {
+		res += fmt.Sprintf(" at %v samples/s", s.Rate)
+	}
+	if s.Samples > 0 {
+		res += fmt.Sprintf(", %v samples", s.Samples)
+	}
+	return res
+}
+
+func (s *GeneratorSource) interval() time.Duration {
+	if s.Rate <= 0 {
+		return time.Second
+	}
+	return time.Duration(float64(time.Second) / s.Rate)
+}
+
+func (s *GeneratorSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	header := s.Header()
+	interval := s.interval()
+	timestamp := time.Now()
+	var lastSent time.Time
+	sent := 0
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			if s.Samples > 0 && sent >= s.Samples {
+				return golib.StopLoopTask
+			}
+			if s.Rate > 0 && sent > 0 && !stop.WaitTimeoutPrecise(interval, 1, &lastSent) {
+				return nil
+			}
+			sample := s.Next(timestamp)
+			timestamp = timestamp.Add(interval)
+			sent++
+			return s.GetSink().Sample(sample, header)
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *GeneratorSource) Close() {
+	s.task.Stop()
+}
@@ -0,0 +1,112 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type generatorTestSuite struct {
+	testsupport.Suite
+}
+
+func TestGenerator(t *testing.T) {
+	suite.Run(t, new(generatorTestSuite))
+}
+
+func (s *generatorTestSuite) TestShapes() {
+	gen := &Generator{Shapes: []string{GenerateSine, GenerateWalk, GenerateStep}, Metrics: 4, Period: 4, Amplitude: 2, Offset: 10, Seed: 1}
+	s.Equal([]string{"sine0", "walk1", "step2", "sine3"}, gen.Header().Fields)
+
+	now := time.Now()
+	var sines, steps []float64
+	for i := 0; i < 8; i++ {
+		sample := gen.Next(now)
+		s.Len(sample.Values, 4)
+		s.Empty(sample.Tag(DefaultGenerateAnomalyTag))
+		sines = append(sines, float64(sample.Values[0]))
+		steps = append(steps, float64(sample.Values[2]))
+	}
+	expected := []float64{10, 12, 10, 8, 10, 12, 10, 8}
+	for i, val := range sines {
+		s.InDelta(expected[i], val, 1e-9)
+	}
+	for i := 1; i < len(steps); i++ {
+		if i%4 != 0 {
+			s.Equal(steps[i-1], steps[i], "step functions must only change every period")
+		}
+		s.True(steps[i] >= 8 && steps[i] <= 12)
+	}
+}
+
+func (s *generatorTestSuite) TestDeterministic() {
+	run := func(seed int64) (values []float64, anomalies int) {
+		gen := &Generator{Shapes: []string{GenerateWalk}, Metrics: 2, Period: 10, Amplitude: 1, Noise: 0.1,
+			Anomalies: 0.1, AnomalySize: 100, AnomalyTag: "anomaly", Seed: seed}
+		for i := 0; i < 1000; i++ {
+			sample := gen.Next(time.Time{})
+			if metric := sample.Tag("anomaly"); metric != "" {
+				anomalies++
+				index := map[string]int{"walk0": 0, "walk1": 1}[metric]
+				s.True(math.Abs(float64(sample.Values[index])) > 50, "anomaly not visible in %v: %v", metric, sample.Values)
+			}
+			for _, val := range sample.Values {
+				values = append(values, float64(val))
+			}
+		}
+		return
+	}
+	values, anomalies := run(1)
+	s.True(anomalies > 50 && anomalies < 150, "unexpected number of anomalies: %v", anomalies)
+	again, _ := run(1)
+	s.Equal(values, again)
+	other, _ := run(2)
+	s.NotEqual(values, other)
+}
+
+func (s *generatorTestSuite) TestSourceParams() {
+	source, err := NewGeneratorSource(map[string]string{})
+	s.NoError(err)
+	s.Equal([]string{GenerateSine}, source.Shapes)
+	s.Equal(1, source.Metrics)
+	s.Equal(1.0, source.Rate)
+
+	source, err = NewGeneratorSource(map[string]string{"shape": "sine,step", "metrics": "3", "rate": "0", "samples": "10", "noise": "0.5"})
+	s.NoError(err)
+	s.Equal([]string{"sine0", "step1", "sine2"}, source.Header().Fields)
+	s.Equal(10, source.Samples)
+	s.Equal(0.5, source.Noise)
+
+	for _, params := range []map[string]string{
+		{"shape": "square"},
+		{"metrics": "0"},
+		{"rate": "-1"},
+		{"anomalies": "2"},
+		{"period": "x"},
+		{"unknown": "1"},
+	} {
+		_, err = NewGeneratorSource(params)
+		s.Error(err, "params: %v", params)
+	}
+}
+
+func (s *generatorTestSuite) TestSource() {
+	source, err := NewGeneratorSource(map[string]string{"metrics": "2", "rate": "0", "samples": "20"})
+	s.NoError(err)
+	out := testsupport.NewCapturingSink()
+	source.SetSink(out)
+	var wg sync.WaitGroup
+	stopped := source.Start(&wg)
+	stopped.Wait()
+	wg.Wait()
+	s.NoError(stopped.Err())
+	out.AssertCount(s.T(), 20)
+	samples := out.Samples()
+	for i := 1; i < len(samples); i++ {
+		s.Equal(time.Second, samples[i].Time.Sub(samples[i-1].Time))
+	}
+}
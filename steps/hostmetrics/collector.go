@@ -0,0 +1,196 @@
+// Package hostmetrics collects system metrics of the local host (CPU, memory, disk I/O, network and per-process
+// statistics) from the Linux /proc file system, so that bitflow-pipeline can be used as a standalone collector agent.
+package hostmetrics
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+)
+
+// Names of the metric groups that can be enabled in a Collector
+const (
+	GroupCPU     = "cpu"
+	GroupMemory  = "mem"
+	GroupLoad    = "load"
+	GroupDisk    = "disk"
+	GroupNetwork = "net"
+	GroupProcess = "proc"
+)
+
+var AllGroups = []string{GroupCPU, GroupMemory, GroupLoad, GroupDisk, GroupNetwork, GroupProcess}
+
+const DefaultProcRoot = "/proc"
+
+var (
+	DefaultDiskExclude      = regexp.MustCompile(`^(loop|ram|sr)\d+$`)
+	DefaultInterfaceExclude = regexp.MustCompile(`^lo$`)
+)
+
+// Collector reads system metrics from the /proc file system. Counters like the number of transmitted bytes are
+// converted to rates per second, based on the time since the previous invocation of Collect(). Percentages
+// are in the range 0..100.
+type Collector struct {
+	// ProcRoot is the mount point of the proc file system, defaults to DefaultProcRoot
+	ProcRoot string
+
+	// Groups contains the enabled metric groups, see AllGroups. Empty means all groups except GroupProcess.
+	Groups []string
+
+	// Disks and Interfaces optionally restrict the collected block devices and network interfaces.
+	// Devices matching DiskExclude or InterfaceExclude are never collected.
+	Disks, DiskExclude           *regexp.Regexp
+	Interfaces, InterfaceExclude *regexp.Regexp
+
+	// Processes selects the processes for the GroupProcess metrics by matching their command name.
+	// The metrics of all matching processes with the same command name are aggregated.
+	Processes *regexp.Regexp
+
+	// Tags are added to every sample. The 'host' tag is set to the hostname by default.
+	Tags map[string]string
+
+	counters     map[string]float64
+	lastCounters map[string]float64
+	lastTime     time.Time
+	elapsed      float64
+	header       *bitflow.Header
+}
+
+// NewCollector returns a Collector with the default settings, tagging the samples with the local hostname.
+func NewCollector() *Collector {
+	c := &Collector{
+		ProcRoot:         DefaultProcRoot,
+		DiskExclude:      DefaultDiskExclude,
+		InterfaceExclude: DefaultInterfaceExclude,
+		Tags:             make(map[string]string),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		c.Tags["host"] = hostname
+	}
+	return c
+}
+
+func (c *Collector) String() string {
+	groups := c.Groups
+	if len(groups) == 0 {
+		groups = AllGroups[:len(AllGroups)-1]
+	}
+	return fmt.Sprintf("Host metrics (%v)", strings.Join(groups, ", "))
+}
+
+func (c *Collector) enabled(group string) bool {
+	if len(c.Groups) == 0 {
+		return group != GroupProcess
+	}
+	for _, g := range c.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// metrics accumulates the fields and values of one sample
+type metrics struct {
+	fields []string
+	values []bitflow.Value
+}
+
+func (m *metrics) add(field string, value float64) {
+	m.fields = append(m.fields, field)
+	m.values = append(m.values, bitflow.Value(value))
+}
+
+// rate stores the current value of a counter and returns its change per second since the previous collection.
+func (c *Collector) rate(key string, value float64) float64 {
+	c.counters[key] = value
+	last, ok := c.lastCounters[key]
+	if !ok || c.elapsed <= 0 || value < last {
+		// The counter is new or was reset
+		return 0
+	}
+	return (value - last) / c.elapsed
+}
+
+// delta stores the current value of a counter and returns its change since the previous collection.
+func (c *Collector) delta(key string, value float64) float64 {
+	c.counters[key] = value
+	last, ok := c.lastCounters[key]
+	if !ok || value < last {
+		return 0
+	}
+	return value - last
+}
+
+// Collect reads all enabled metrics. The first invocation only initializes the counters and returns a nil sample,
+// because rates cannot be computed yet.
+func (c *Collector) Collect() (*bitflow.Sample, *bitflow.Header, error) {
+	now := time.Now()
+	first := c.lastTime.IsZero()
+	c.elapsed = now.Sub(c.lastTime).Seconds()
+	c.counters = make(map[string]float64, len(c.lastCounters))
+
+	var m metrics
+	collectors := []struct {
+		group   string
+		collect func(*metrics) error
+	}{
+		{GroupCPU, c.collectCPU},
+		{GroupMemory, c.collectMemory},
+		{GroupLoad, c.collectLoad},
+		{GroupDisk, c.collectDisks},
+		{GroupNetwork, c.collectNetwork},
+		{GroupProcess, c.collectProcesses},
+	}
+	for _, collector := range collectors {
+		if c.enabled(collector.group) {
+			if err := collector.collect(&m); err != nil {
+				return nil, nil, fmt.Errorf("%v: Failed to collect %v metrics: %v", c, collector.group, err)
+			}
+		}
+	}
+	c.lastCounters = c.counters
+	c.lastTime = now
+	if first {
+		return nil, nil, nil
+	}
+
+	if c.header == nil || !stringsEqual(c.header.Fields, m.fields) {
+		c.header = &bitflow.Header{Fields: m.fields}
+	}
+	sample := &bitflow.Sample{Values: m.values, Time: now}
+	for key, value := range c.Tags {
+		sample.SetTag(key, value)
+	}
+	return sample, c.header, nil
+}
+
+func (c *Collector) includeDevice(name string, include, exclude *regexp.Regexp) bool {
+	return (include == nil || include.MatchString(name)) && (exclude == nil || !exclude.MatchString(name))
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]*processGroup) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,157 @@
+package hostmetrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type hostMetricsTestSuite struct {
+	suite.Suite
+	root string
+}
+
+func TestHostMetrics(t *testing.T) {
+	suite.Run(t, new(hostMetricsTestSuite))
+}
+
+func (s *hostMetricsTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "bitflow-proc")
+	s.Require().NoError(err)
+	s.root = dir
+}
+
+func (s *hostMetricsTestSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.root))
+}
+
+func (s *hostMetricsTestSuite) write(file string, content string) {
+	path := filepath.Join(s.root, file)
+	s.Require().NoError(os.MkdirAll(filepath.Dir(path), 0755))
+	s.Require().NoError(ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func (s *hostMetricsTestSuite) writeCounters(cpuBusy, diskSectors, netBytes, procTicks int) {
+	s.write("stat", "cpu  "+strconv.Itoa(cpuBusy)+" 0 0 "+strconv.Itoa(3*cpuBusy)+" 0 0 0 0 0 0\n"+
+		"cpu0 "+strconv.Itoa(cpuBusy)+" 0 0 "+strconv.Itoa(3*cpuBusy)+" 0 0 0 0 0 0\nctxt 100\n")
+	s.write("meminfo", "MemTotal: 1000 kB\nMemFree: 100 kB\nMemAvailable: 250 kB\nSwapTotal: 0 kB\nSwapFree: 0 kB\n")
+	s.write("loadavg", "0.50 1.00 1.50 1/100 1234\n")
+	s.write("diskstats", "   8 0 sda 10 0 "+strconv.Itoa(diskSectors)+" 0 5 0 0 0 0 100 0 0 0 0 0\n"+
+		"   7 0 loop0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n")
+	s.write("net/dev", "Inter-|   Receive |  Transmit\n face |bytes packets|bytes packets\n"+
+		"    lo: 100 1 0 0 0 0 0 0 100 1 0 0 0 0 0 0\n"+
+		"  eth0:"+strconv.Itoa(netBytes)+" 10 1 0 0 0 0 0 500 5 0 2 0 0 0 0\n")
+	s.write("42/stat", "42 (my (app)) S 1 42 42 0 -1 0 0 0 0 0 "+strconv.Itoa(procTicks)+" 0 0 0 20 0 3 0 100 1000 10 0 0\n")
+	s.write("43/stat", "43 (other) S 1 43 43 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 100 1000 10 0 0\n")
+}
+
+func (s *hostMetricsTestSuite) TestCollect() {
+	c := NewCollector()
+	c.ProcRoot = s.root
+	c.Groups = AllGroups
+	c.Processes = regexp.MustCompile("app")
+	c.Tags = map[string]string{"host": "test"}
+
+	s.writeCounters(100, 0, 1000, 0)
+	sample, header, err := c.Collect()
+	s.NoError(err)
+	s.Nil(sample)
+	s.Nil(header)
+
+	// Simulate one second between the collections
+	c.lastTime = c.lastTime.Add(-time.Second)
+	s.writeCounters(350, 20, 3000, 50)
+	sample, header, err = c.Collect()
+	s.Require().NoError(err)
+	s.Equal([]string{
+		"cpu", "cpu/user", "cpu/system", "cpu/iowait", "cpu/steal", "cpu/0",
+		"mem/total", "mem/used", "mem/available", "mem/percent", "swap/used", "swap/percent",
+		"load/1", "load/5", "load/15",
+		"disk/sda/read-ops", "disk/sda/read-bytes", "disk/sda/write-ops", "disk/sda/write-bytes", "disk/sda/io-time",
+		"net/eth0/rx-bytes", "net/eth0/rx-packets", "net/eth0/tx-bytes", "net/eth0/tx-packets", "net/eth0/errors", "net/eth0/dropped",
+		"proc/my (app)/count", "proc/my (app)/cpu", "proc/my (app)/rss", "proc/my (app)/threads", "proc/my (app)/fds",
+	}, header.Fields)
+	s.Equal("test", sample.Tag("host"))
+
+	value := func(field string) float64 {
+		for i, f := range header.Fields {
+			if f == field {
+				return float64(sample.Values[i])
+			}
+		}
+		s.Fail("Missing field " + field)
+		return 0
+	}
+	s.Equal(25.0, value("cpu"))
+	s.Equal(25.0, value("cpu/0"))
+	s.Equal(1024000.0, value("mem/total"))
+	s.Equal(75.0, value("mem/percent"))
+	s.Equal(1.0, value("load/5"))
+	s.InDelta(20*512, value("disk/sda/read-bytes"), 100)
+	s.InDelta(2000, value("net/eth0/rx-bytes"), 20)
+	s.Equal(0.0, value("net/eth0/tx-bytes"))
+	s.InDelta(50, value("proc/my (app)/cpu"), 1)
+	s.Equal(3.0, value("proc/my (app)/threads"))
+	s.Equal(1.0, value("proc/my (app)/count"))
+
+	// The header is reused if the fields do not change
+	_, header2, err := c.Collect()
+	s.NoError(err)
+	s.True(header == header2)
+}
+
+func (s *hostMetricsTestSuite) TestSourceParameters() {
+	source, err := NewSource(map[string]string{"interval": "10ms", "groups": "cpu,load", "tags": "a=b", "proc-root": s.root})
+	s.Require().NoError(err)
+	s.Equal(10*time.Millisecond, source.Interval)
+	s.Equal([]string{GroupCPU, GroupLoad}, source.Groups)
+	s.Equal("b", source.Tags["a"])
+
+	source, err = NewSource(map[string]string{"procs": "java"})
+	s.Require().NoError(err)
+	s.Equal(AllGroups, source.Groups)
+
+	_, err = NewSource(map[string]string{"groups": "cpu,gpu"})
+	s.EqualError(err, "Failed to parse 'groups' parameter: Unknown metric group 'gpu', available: cpu, mem, load, disk, net, proc")
+	_, err = NewSource(map[string]string{"something": "x"})
+	s.EqualError(err, "Unexpected parameter for collect endpoint: something")
+	_, err = NewSource(map[string]string{"disks": "("})
+	s.Error(err)
+}
+
+func (s *hostMetricsTestSuite) TestSource() {
+	s.writeCounters(100, 0, 1000, 0)
+	source, err := NewSource(map[string]string{"interval": "5ms", "groups": "cpu,mem", "proc-root": s.root})
+	s.Require().NoError(err)
+	sink := &collectingSink{samples: make(chan *bitflow.Sample, 100)}
+	source.SetSink(sink)
+
+	var wg sync.WaitGroup
+	source.Start(&wg)
+	select {
+	case sample := <-sink.samples:
+		s.Len(sample.Values, 12)
+	case <-time.After(5 * time.Second):
+		s.Fail("No sample received")
+	}
+	source.Close()
+	wg.Wait()
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples chan *bitflow.Sample
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, _ *bitflow.Header) error {
+	s.samples <- sample
+	return nil
+}
@@ -0,0 +1,281 @@
+package hostmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// clockTicks is the unit of the CPU times in /proc (USER_HZ), which is 100 on all common Linux platforms
+	clockTicks = 100
+
+	sectorSize = 512
+)
+
+var pageSize = float64(os.Getpagesize())
+
+func (c *Collector) path(elem ...string) string {
+	root := c.ProcRoot
+	if root == "" {
+		root = DefaultProcRoot
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// readLines calls the given function with the whitespace-separated fields of every line of a file
+func (c *Collector) readLines(file string, handle func(fields []string) error) error {
+	f, err := os.Open(c.path(file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			if err := handle(fields); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func parseFloats(fields []string) ([]float64, error) {
+	result := make([]float64, len(fields))
+	for i, field := range fields {
+		val, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = val
+	}
+	return result, nil
+}
+
+// collectCPU reads /proc/stat. The columns of the cpu lines are:
+// user nice system idle iowait irq softirq steal guest guest_nice
+func (c *Collector) collectCPU(m *metrics) error {
+	return c.readLines("stat", func(fields []string) error {
+		if !strings.HasPrefix(fields[0], "cpu") || len(fields) < 5 {
+			return nil
+		}
+		times, err := parseFloats(fields[1:])
+		if err != nil {
+			return fmt.Errorf("Invalid line for %v: %v", fields[0], err)
+		}
+		for len(times) < 8 {
+			times = append(times, 0)
+		}
+		total := 0.0
+		for _, t := range times[:8] { // guest times are already included in user and nice
+			total += t
+		}
+		key := fields[0] + "/"
+		deltaTotal := c.delta(key+"total", total)
+		percent := func(name string, value float64) float64 {
+			delta := c.delta(key+name, value)
+			if deltaTotal <= 0 {
+				return 0
+			}
+			return 100 * delta / deltaTotal
+		}
+		idle := times[3] + times[4]
+		if fields[0] == "cpu" {
+			m.add("cpu", 100-percent("idle", idle))
+			m.add("cpu/user", percent("user", times[0]+times[1]))
+			m.add("cpu/system", percent("system", times[2]+times[5]+times[6]))
+			m.add("cpu/iowait", percent("iowait", times[4]))
+			m.add("cpu/steal", percent("steal", times[7]))
+		} else {
+			m.add("cpu/"+strings.TrimPrefix(fields[0], "cpu"), 100-percent("idle", idle))
+		}
+		return nil
+	})
+}
+
+// collectMemory reads /proc/meminfo. The values are in kB and are converted to bytes.
+func (c *Collector) collectMemory(m *metrics) error {
+	info := make(map[string]float64)
+	err := c.readLines("meminfo", func(fields []string) error {
+		if len(fields) >= 2 {
+			if val, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				info[strings.TrimSuffix(fields[0], ":")] = val * 1024
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	total := info["MemTotal"]
+	available, ok := info["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14 do not provide MemAvailable
+		available = info["MemFree"] + info["Buffers"] + info["Cached"]
+	}
+	used := total - available
+	m.add("mem/total", total)
+	m.add("mem/used", used)
+	m.add("mem/available", available)
+	m.add("mem/percent", percentOf(used, total))
+	swapUsed := info["SwapTotal"] - info["SwapFree"]
+	m.add("swap/used", swapUsed)
+	m.add("swap/percent", percentOf(swapUsed, info["SwapTotal"]))
+	return nil
+}
+
+func percentOf(value, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return 100 * value / total
+}
+
+// collectLoad reads /proc/loadavg
+func (c *Collector) collectLoad(m *metrics) error {
+	data, err := ioutil.ReadFile(c.path("loadavg"))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return fmt.Errorf("Unexpected content: %q", string(data))
+	}
+	load, err := parseFloats(fields[:3])
+	if err != nil {
+		return err
+	}
+	m.add("load/1", load[0])
+	m.add("load/5", load[1])
+	m.add("load/15", load[2])
+	return nil
+}
+
+// collectDisks reads /proc/diskstats. The relevant columns after the device name are:
+// 0: reads completed, 2: sectors read, 4: writes completed, 6: sectors written, 9: milliseconds spent doing I/O
+func (c *Collector) collectDisks(m *metrics) error {
+	return c.readLines("diskstats", func(fields []string) error {
+		if len(fields) < 14 {
+			return nil
+		}
+		name := fields[2]
+		if !c.includeDevice(name, c.Disks, c.DiskExclude) {
+			return nil
+		}
+		stats, err := parseFloats(fields[3:14])
+		if err != nil {
+			return fmt.Errorf("Invalid line for disk %v: %v", name, err)
+		}
+		key := "disk/" + name + "/"
+		m.add(key+"read-ops", c.rate(key+"read-ops", stats[0]))
+		m.add(key+"read-bytes", c.rate(key+"read-bytes", stats[2]*sectorSize))
+		m.add(key+"write-ops", c.rate(key+"write-ops", stats[4]))
+		m.add(key+"write-bytes", c.rate(key+"write-bytes", stats[6]*sectorSize))
+		m.add(key+"io-time", c.rate(key+"io-time", stats[9])/10) // Milliseconds per second to percent
+		return nil
+	})
+}
+
+// collectNetwork reads /proc/net/dev. The columns after the interface name are 8 receive counters
+// (bytes packets errs drop fifo frame compressed multicast), followed by 8 transmit counters.
+func (c *Collector) collectNetwork(m *metrics) error {
+	return c.readLines("net/dev", func(fields []string) error {
+		if !strings.HasSuffix(fields[0], ":") {
+			// Header lines, or interface names that are directly followed by the first counter
+			parts := strings.SplitN(fields[0], ":", 2)
+			if len(parts) != 2 || parts[1] == "" {
+				return nil
+			}
+			fields = append([]string{parts[0] + ":", parts[1]}, fields[1:]...)
+		}
+		name := strings.TrimSuffix(fields[0], ":")
+		if len(fields) < 17 || !c.includeDevice(name, c.Interfaces, c.InterfaceExclude) {
+			return nil
+		}
+		stats, err := parseFloats(fields[1:17])
+		if err != nil {
+			return fmt.Errorf("Invalid line for interface %v: %v", name, err)
+		}
+		key := "net/" + name + "/"
+		m.add(key+"rx-bytes", c.rate(key+"rx-bytes", stats[0]))
+		m.add(key+"rx-packets", c.rate(key+"rx-packets", stats[1]))
+		m.add(key+"tx-bytes", c.rate(key+"tx-bytes", stats[8]))
+		m.add(key+"tx-packets", c.rate(key+"tx-packets", stats[9]))
+		m.add(key+"errors", c.rate(key+"errors", stats[2]+stats[10]))
+		m.add(key+"dropped", c.rate(key+"dropped", stats[3]+stats[11]))
+		return nil
+	})
+}
+
+type processGroup struct {
+	count, cpu, rss, threads, fds float64
+}
+
+// collectProcesses reads /proc/<pid>/stat for all processes with a command name matching c.Processes.
+func (c *Collector) collectProcesses(m *metrics) error {
+	if c.Processes == nil {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(c.path())
+	if err != nil {
+		return err
+	}
+	groups := make(map[string]*processGroup)
+	for _, entry := range entries {
+		pid := entry.Name()
+		if _, err := strconv.Atoi(pid); err != nil || !entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(c.path(pid, "stat"))
+		if err != nil {
+			continue // The process has terminated in the meantime
+		}
+		// The command name is in parentheses and can contain spaces and parentheses itself
+		stat := string(data)
+		open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		name := stat[open+1 : end]
+		if !c.Processes.MatchString(name) {
+			continue
+		}
+		fields := strings.Fields(stat[end+1:])
+		if len(fields) < 22 {
+			continue
+		}
+		// Indices relative to the field after the command name (state): 11 utime, 12 stime, 17 num_threads, 21 rss
+		values, err := parseFloats([]string{fields[11], fields[12], fields[17], fields[21]})
+		if err != nil {
+			continue
+		}
+		group, ok := groups[name]
+		if !ok {
+			group = new(processGroup)
+			groups[name] = group
+		}
+		group.count++
+		group.cpu += c.rate("pid/"+pid, (values[0]+values[1])/clockTicks) * 100
+		group.threads += values[2]
+		group.rss += values[3] * pageSize
+		if fds, err := ioutil.ReadDir(c.path(pid, "fd")); err == nil {
+			group.fds += float64(len(fds))
+		}
+	}
+	for _, name := range sortedKeys(groups) {
+		group := groups[name]
+		key := "proc/" + name + "/"
+		m.add(key+"count", group.count)
+		m.add(key+"cpu", group.cpu)
+		m.add(key+"rss", group.rss)
+		m.add(key+"threads", group.threads)
+		m.add(key+"fds", group.fds)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package hostmetrics
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	CollectEndpoint = bitflow.EndpointType("collect")
+
+	DefaultInterval = time.Second
+)
+
+// Source emits the samples of a Collector in regular intervals.
+type Source struct {
+	bitflow.AbstractSampleSource
+	*Collector
+	Interval time.Duration
+
+	task *golib.LoopTask
+}
+
+func (s *Source) String() string {
+	return fmt.Sprintf("%v (every %v)", s.Collector, s.Interval)
+}
+
+func (s *Source) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			sample, header, err := s.Collect()
+			if err != nil {
+				return err
+			}
+			if sample != nil {
+				if err := s.GetSink().Sample(sample, header); err != nil {
+					return err
+				}
+			}
+			stop.WaitTimeout(s.Interval)
+			return nil
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *Source) Close() {
+	s.task.Stop()
+}
+
+// RegisterCollectSource registers the collect:// data source. The target of the endpoint contains optional
+// URL query parameters, e.g. collect://interval=500ms&groups=cpu,mem,proc&procs=java|python
+func RegisterCollectSource(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[CollectEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		params := make(map[string]string)
+		if target != "-" {
+			query, err := url.ParseQuery(target)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse parameters of %v endpoint: %v", CollectEndpoint, err)
+			}
+			for key, values := range query {
+				params[key] = strings.Join(values, ",")
+			}
+		}
+		return NewSource(params)
+	}
+}
+
+// NewSource creates a Source from the following optional parameters:
+//
+//	interval: the collection interval (default 1s)
+//	groups: comma-separated metric groups, see AllGroups (default: all groups, proc only if procs is defined)
+//	procs: regex selecting the processes by their command name
+//	disks, interfaces: regexes selecting block devices and network interfaces
+//	tags: additional tags in the form key1=value1,key2=value2
+//	proc-root: mount point of the proc file system (default /proc)
+func NewSource(params map[string]string) (*Source, error) {
+	var err error
+	interval := reg.DurationParam(params, "interval", DefaultInterval, true, &err)
+	groups := reg.StrParam(params, "groups", "", true, &err)
+	procs := reg.StrParam(params, "procs", "", true, &err)
+	disks := reg.StrParam(params, "disks", "", true, &err)
+	interfaces := reg.StrParam(params, "interfaces", "", true, &err)
+	tags := reg.StrParam(params, "tags", "", true, &err)
+	procRoot := reg.StrParam(params, "proc-root", DefaultProcRoot, true, &err)
+	if err != nil {
+		return nil, err
+	}
+	for key := range params {
+		switch key {
+		case "interval", "groups", "procs", "disks", "interfaces", "tags", "proc-root":
+		default:
+			return nil, fmt.Errorf("Unexpected parameter for %v endpoint: %v", CollectEndpoint, key)
+		}
+	}
+	if interval <= 0 {
+		return nil, reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+	}
+
+	c := NewCollector()
+	c.ProcRoot = procRoot
+	if groups != "" {
+		for _, group := range strings.Split(groups, ",") {
+			if !isGroup(group) {
+				return nil, reg.ParameterError("groups", fmt.Errorf("Unknown metric group '%v', available: %v", group, strings.Join(AllGroups, ", ")))
+			}
+			c.Groups = append(c.Groups, group)
+		}
+	} else if procs != "" {
+		c.Groups = AllGroups
+	}
+	for _, regex := range []struct {
+		name  string
+		value string
+		out   **regexp.Regexp
+	}{{"procs", procs, &c.Processes}, {"disks", disks, &c.Disks}, {"interfaces", interfaces, &c.Interfaces}} {
+		if regex.value != "" {
+			if *regex.out, err = regexp.Compile(regex.value); err != nil {
+				return nil, reg.ParameterError(regex.name, err)
+			}
+		}
+	}
+	if tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			parts := strings.SplitN(tag, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, reg.ParameterError("tags", fmt.Errorf("Expected key=value, but got '%v'", tag))
+			}
+			c.Tags[parts[0]] = parts[1]
+		}
+	}
+	return &Source{Collector: c, Interval: interval}, nil
+}
+
+func isGroup(group string) bool {
+	for _, g := range AllGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package steps
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+func RegisterStreamJoin(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("join",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			join := &StreamJoin{
+				StreamTag:     params["stream"],
+				Streams:       reg.IntParam(params, "num", 0, false, &err),
+				Tolerance:     reg.DurationParam(params, "tolerance", 0, true, &err),
+				PrefixMetrics: reg.BoolParam(params, "prefix", false, true, &err),
+				MaxBuffered:   reg.IntParam(params, "buffer", 1000, true, &err),
+				Expire:        reg.DurationParam(params, "expire", time.Minute, true, &err),
+			}
+			if keys := params["key"]; keys != "" {
+				join.KeyTags = strings.Split(keys, ",")
+			}
+			if err == nil && join.Streams < 2 {
+				err = reg.ParameterError("num", fmt.Errorf("At least 2 streams are required for a join"))
+			}
+			if err == nil {
+				p.Add(join)
+			}
+			return
+		},
+		"Join samples from multiple streams (identified by the given tag) into combined samples containing the union of all metrics. "+
+			"Samples are joined when their timestamps differ by at most the given tolerance and the values of the key tags (comma-separated) are equal.",
+		reg.RequiredParams("stream", "num"), reg.OptionalParams("tolerance", "key", "prefix", "buffer", "expire"),
+		reg.ParamDetails("expire", reg.TypeDuration, "1m", "Forget key tag values that did not occur for this duration (sample time), 0 disables the expiration"))
+}
+
+// maxJoinedHeaders is the number of joined headers that are cached by a StreamJoin
+const maxJoinedHeaders = 32
+
+// StreamJoin merges samples from multiple streams into single samples. The streams are identified by the value of
+// StreamTag. One sample of every stream is required for a joined sample. Samples are joined when their timestamps
+// differ by at most Tolerance, and when they have equal values for all KeyTags.
+// The joined sample contains the metrics of all streams, ordered by stream name, and the tags of all joined samples.
+// Its timestamp is the newest timestamp of the joined samples.
+//
+// The streams are expected to be sorted by time. A buffered sample is dropped, when all other streams delivered
+// samples that are too new to be joined with it, or when more than MaxBuffered samples are queued for one stream.
+// If Expire is set, the buffered samples of key tag values that did not occur for that duration are dropped as well.
+type StreamJoin struct {
+	bitflow.NoopProcessor
+
+	StreamTag     string
+	Streams       int
+	Tolerance     time.Duration
+	KeyTags       []string
+	PrefixMetrics bool // If true, the metric names are prefixed with the stream name, e.g. "host/cpu"
+	MaxBuffered   int
+	Expire        time.Duration
+
+	lock            sync.Mutex
+	groups          map[string]*joinGroup
+	headers         []*joinedHeader // Most recently used first
+	newest          time.Time
+	lastCleanup     time.Time
+	dropped         int
+	warnedCollision bool
+}
+
+type joinGroup struct {
+	streams map[string]*joinStream
+}
+
+type joinStream struct {
+	samples list.List // Elements of type bitflow.SampleAndHeader
+	newest  time.Time
+}
+
+type joinedHeader struct {
+	names   []string
+	inputs  []*bitflow.Header
+	header  *bitflow.Header
+	indices [][]int // For every joined stream and input metric, the index in the joined header (or -1)
+}
+
+func (h *joinedHeader) matches(names []string, samples []bitflow.SampleAndHeader) bool {
+	if len(names) != len(h.names) {
+		return false
+	}
+	for i, name := range names {
+		if name != h.names[i] || (h.inputs[i] != samples[i].Header && !h.inputs[i].Equals(samples[i].Header)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (j *StreamJoin) String() string {
+	res := fmt.Sprintf("Join %v streams identified by tag '%v'", j.Streams, j.StreamTag)
+	if j.Tolerance > 0 {
+		res += fmt.Sprintf(" (tolerance %v)", j.Tolerance)
+	}
+	if len(j.KeyTags) > 0 {
+		res += fmt.Sprintf(" on tags %v", j.KeyTags)
+	}
+	return res
+}
+
+func (j *StreamJoin) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if !sample.HasTag(j.StreamTag) {
+		return fmt.Errorf("%v: Sample is missing the stream tag '%v'", j, j.StreamTag)
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.groups == nil {
+		j.groups = make(map[string]*joinGroup)
+	}
+	if sample.Time.After(j.newest) {
+		j.newest = sample.Time
+	}
+
+	key := j.groupKey(sample)
+	group, ok := j.groups[key]
+	if !ok {
+		group = &joinGroup{streams: make(map[string]*joinStream)}
+		j.groups[key] = group
+	}
+	streamName := sample.Tag(j.StreamTag)
+	stream, ok := group.streams[streamName]
+	if !ok {
+		stream = new(joinStream)
+		group.streams[streamName] = stream
+	}
+	stream.samples.PushBack(bitflow.SampleAndHeader{Sample: sample, Header: header})
+	if sample.Time.After(stream.newest) {
+		stream.newest = sample.Time
+	}
+	if j.MaxBuffered > 0 && stream.samples.Len() > j.MaxBuffered {
+		stream.samples.Remove(stream.samples.Front())
+		j.dropped++
+	}
+
+	err := j.join(group, streamName, sample.Time)
+	j.evict(group)
+	j.expire()
+	return err
+}
+
+func (j *StreamJoin) Close() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for _, group := range j.groups {
+		for _, stream := range group.streams {
+			j.dropped += stream.samples.Len()
+		}
+	}
+	if j.dropped > 0 {
+		log.Warnf("%v: Dropped %v sample(s) that could not be joined", j, j.dropped)
+	}
+	j.NoopProcessor.Close()
+}
+
+func (j *StreamJoin) groupKey(sample *bitflow.Sample) string {
+	if len(j.KeyTags) == 0 {
+		return ""
+	}
+	values := make([]string, len(j.KeyTags))
+	for i, tag := range j.KeyTags {
+		values[i] = sample.Tag(tag)
+	}
+	return strings.Join(values, ",")
+}
+
+// join tries to join the sample with the given timestamp, that was just added to the given stream.
+func (j *StreamJoin) join(group *joinGroup, streamName string, t time.Time) error {
+	if len(group.streams) < j.Streams {
+		return nil
+	}
+	names := make([]string, 0, len(group.streams))
+	for name := range group.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	elements := make([]*list.Element, len(names))
+	for i, name := range names {
+		stream := group.streams[name]
+		if name == streamName {
+			elements[i] = stream.samples.Back()
+			continue
+		}
+		// Find the closest sample within the tolerance
+		var bestDiff time.Duration = -1
+		for e := stream.samples.Front(); e != nil; e = e.Next() {
+			diff := e.Value.(bitflow.SampleAndHeader).Time.Sub(t)
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff <= j.Tolerance && (bestDiff < 0 || diff < bestDiff) {
+				elements[i], bestDiff = e, diff
+			}
+		}
+		if elements[i] == nil {
+			return nil
+		}
+	}
+
+	samples := make([]bitflow.SampleAndHeader, len(names))
+	for i, name := range names {
+		samples[i] = elements[i].Value.(bitflow.SampleAndHeader)
+		group.streams[name].samples.Remove(elements[i])
+	}
+	sample, header := j.merge(names, samples)
+	return j.NoopProcessor.Sample(sample, header)
+}
+
+// evict drops buffered samples that cannot be joined anymore, because the other streams already delivered newer samples.
+func (j *StreamJoin) evict(group *joinGroup) {
+	if len(group.streams) < j.Streams {
+		return
+	}
+	for name, stream := range group.streams {
+		// The oldest timestamp that the other streams can still deliver
+		var horizon time.Time
+		for otherName, other := range group.streams {
+			if otherName != name && (horizon.IsZero() || other.newest.Before(horizon)) {
+				horizon = other.newest
+			}
+		}
+		for e := stream.samples.Front(); e != nil; {
+			next := e.Next()
+			if e.Value.(bitflow.SampleAndHeader).Time.Add(j.Tolerance).Before(horizon) {
+				stream.samples.Remove(e)
+				j.dropped++
+			}
+			e = next
+		}
+	}
+}
+
+// expire drops the groups of key tag values that did not receive samples for the Expire duration.
+// The check runs at most once per Expire duration.
+func (j *StreamJoin) expire() {
+	if j.Expire <= 0 || j.newest.Sub(j.lastCleanup) < j.Expire {
+		return
+	}
+	j.lastCleanup = j.newest
+	for key, group := range j.groups {
+		expired := true
+		for _, stream := range group.streams {
+			if j.newest.Sub(stream.newest) <= j.Expire {
+				expired = false
+				break
+			}
+		}
+		if expired {
+			for _, stream := range group.streams {
+				j.dropped += stream.samples.Len()
+			}
+			delete(j.groups, key)
+		}
+	}
+}
+
+func (j *StreamJoin) merge(names []string, samples []bitflow.SampleAndHeader) (*bitflow.Sample, *bitflow.Header) {
+	joined := j.joinedHeader(names, samples)
+	out := &bitflow.Sample{
+		Values: make([]bitflow.Value, len(joined.header.Fields)),
+	}
+	for i, sample := range samples {
+		if sample.Time.After(out.Time) {
+			out.Time = sample.Time
+		}
+		for key, value := range sample.TagMap() {
+			if key != j.StreamTag {
+				out.SetTag(key, value)
+			}
+		}
+		for fieldIndex, outIndex := range joined.indices[i] {
+			if outIndex >= 0 {
+				out.Values[outIndex] = sample.Values[fieldIndex]
+			}
+		}
+	}
+	return out, joined.header
+}
+
+func (j *StreamJoin) joinedHeader(names []string, samples []bitflow.SampleAndHeader) *joinedHeader {
+	for i, cached := range j.headers {
+		if cached.matches(names, samples) {
+			copy(j.headers[1:i+1], j.headers[:i])
+			j.headers[0] = cached
+			return cached
+		}
+	}
+
+	res := &joinedHeader{
+		names:   append([]string(nil), names...),
+		inputs:  make([]*bitflow.Header, len(samples)),
+		header:  new(bitflow.Header),
+		indices: make([][]int, len(samples)),
+	}
+	fieldIndices := make(map[string]int)
+	for i, sample := range samples {
+		res.inputs[i] = sample.Header
+		res.indices[i] = make([]int, len(sample.Fields))
+		for fieldIndex, field := range sample.Fields {
+			if j.PrefixMetrics {
+				field = names[i] + "/" + field
+			}
+			if _, exists := fieldIndices[field]; exists {
+				if !j.warnedCollision {
+					log.Warnf("%v: Metric '%v' exists in multiple streams, using the value of the first stream. Use prefix=true to keep all values.", j, field)
+					j.warnedCollision = true
+				}
+				res.indices[i][fieldIndex] = -1
+				continue
+			}
+			fieldIndices[field] = len(res.header.Fields)
+			res.indices[i][fieldIndex] = len(res.header.Fields)
+			res.header.Fields = append(res.header.Fields, field)
+		}
+	}
+	if len(j.headers) < maxJoinedHeaders {
+		j.headers = append(j.headers, nil)
+	}
+	copy(j.headers[1:], j.headers)
+	j.headers[0] = res
+	return res
+}
@@ -0,0 +1,150 @@
+package steps
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type joinTestSuite struct {
+	testsupport.Suite
+}
+
+func TestJoin(t *testing.T) {
+	suite.Run(t, new(joinTestSuite))
+}
+
+var (
+	joinHeaderA = &bitflow.Header{Fields: []string{"cpu"}}
+	joinHeaderB = &bitflow.Header{Fields: []string{"mem"}}
+)
+
+func (s *joinTestSuite) start(join *StreamJoin) *testsupport.CapturingSink {
+	if join.StreamTag == "" {
+		join.StreamTag = "s"
+	}
+	if join.Streams == 0 {
+		join.Streams = 2
+	}
+	out := testsupport.NewCapturingSink()
+	join.SetSink(out)
+	join.Start(new(sync.WaitGroup))
+	return out
+}
+
+func (s *joinTestSuite) send(join *StreamJoin, header *bitflow.Header, samples ...*bitflow.Sample) {
+	for _, sample := range samples {
+		s.NoError(join.Sample(sample, header))
+	}
+}
+
+func (s *joinTestSuite) TestJoin() {
+	join := &StreamJoin{Tolerance: time.Second}
+	out := s.start(join)
+	s.send(join, joinHeaderB, testsupport.NewSample(500*time.Millisecond, "s=b host=x", 2))
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=a dc=1", 1))
+	out.AssertFields(s.T(), "cpu", "mem")
+	out.AssertValues(s.T(), [][]float64{{1, 2}})
+	s.Equal([]time.Duration{500 * time.Millisecond}, out.Offsets(), "The joined sample must have the newest timestamp")
+	s.Equal(map[string]string{"host": "x", "dc": "1"}, out.Samples()[0].TagMap())
+
+	// Samples outside the tolerance are not joined
+	s.send(join, joinHeaderA, testsupport.NewSample(10*time.Second, "s=a", 3))
+	s.send(join, joinHeaderB, testsupport.NewSample(12*time.Second, "s=b", 4))
+	out.AssertCount(s.T(), 1)
+	s.Error(join.Sample(testsupport.NewSample(0, "", 1), joinHeaderA), "The stream tag is required")
+}
+
+func (s *joinTestSuite) TestPrefixAndCollision() {
+	join := &StreamJoin{PrefixMetrics: true}
+	out := s.start(join)
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=x", 1))
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=y", 2))
+	out.AssertFields(s.T(), "x/cpu", "y/cpu")
+	out.AssertValues(s.T(), [][]float64{{1, 2}})
+
+	join = new(StreamJoin)
+	out = s.start(join)
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=x", 1))
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=y", 2))
+	out.AssertFields(s.T(), "cpu")
+	out.AssertValues(s.T(), [][]float64{{1}}) // Colliding metrics use the value of the first stream
+}
+
+func (s *joinTestSuite) TestKeyTags() {
+	join := &StreamJoin{KeyTags: []string{"host"}}
+	out := s.start(join)
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=a host=x", 1))
+	s.send(join, joinHeaderB, testsupport.NewSample(0, "s=b host=y", 2))
+	out.AssertCount(s.T(), 0)
+	s.send(join, joinHeaderB, testsupport.NewSample(0, "s=b host=x", 3))
+	out.AssertValues(s.T(), [][]float64{{1, 3}})
+	s.Equal([]string{"x"}, out.Tags("host"))
+}
+
+func (s *joinTestSuite) TestHeaderCache() {
+	join := new(StreamJoin)
+	out := s.start(join)
+	for i := 0; i < 3; i++ {
+		// New header instances with equal fields must reuse the joined header
+		s.send(join, &bitflow.Header{Fields: []string{"cpu"}}, testsupport.NewSample(0, "s=a", 1))
+		s.send(join, &bitflow.Header{Fields: []string{"mem"}}, testsupport.NewSample(0, "s=b", 2))
+	}
+	headers := out.Headers()
+	s.Len(headers, 3)
+	s.True(headers[0] == headers[1] && headers[1] == headers[2])
+	s.Len(join.headers, 1)
+
+	// Changed input headers produce a new joined header
+	s.send(join, &bitflow.Header{Fields: []string{"cpu", "load"}}, testsupport.NewSample(0, "s=a", 1, 5))
+	s.send(join, joinHeaderB, testsupport.NewSample(0, "s=b", 2))
+	s.Equal([]string{"cpu", "load", "mem"}, out.Headers()[3].Fields)
+	out.AssertCount(s.T(), 4)
+	s.Equal([]float64{1, 5, 2}, out.Values()[3])
+
+	// The cache is bounded
+	for i := 0; i < 2*maxJoinedHeaders; i++ {
+		s.send(join, &bitflow.Header{Fields: []string{"cpu" + strconv.Itoa(i)}}, testsupport.NewSample(0, "s=a", 1))
+		s.send(join, joinHeaderB, testsupport.NewSample(0, "s=b", 2))
+	}
+	s.Len(join.headers, maxJoinedHeaders)
+	s.Equal([]string{"cpu" + strconv.Itoa(2*maxJoinedHeaders-1), "mem"}, join.headers[0].header.Fields)
+}
+
+func (s *joinTestSuite) TestDropOldSamples() {
+	join := new(StreamJoin)
+	out := s.start(join)
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=a", 1), testsupport.NewSample(time.Second, "s=a", 2), testsupport.NewSample(2*time.Second, "s=a", 3))
+	s.send(join, joinHeaderB, testsupport.NewSample(2*time.Second, "s=b", 4))
+	out.AssertValues(s.T(), [][]float64{{3, 4}})
+	s.Equal(2, join.dropped, "Samples older than the newest sample of the other stream must be dropped")
+
+	join = &StreamJoin{MaxBuffered: 2}
+	s.start(join)
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=a", 1), testsupport.NewSample(time.Second, "s=a", 2), testsupport.NewSample(2*time.Second, "s=a", 3))
+	s.Equal(1, join.dropped)
+	s.Equal(2, join.groups[""].streams["a"].samples.Len())
+}
+
+func (s *joinTestSuite) TestExpireGroups() {
+	join := &StreamJoin{KeyTags: []string{"host"}, Expire: 10 * time.Second}
+	out := s.start(join)
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=a host=old", 1))
+	s.send(join, joinHeaderA, testsupport.NewSample(20*time.Second, "s=a host=new", 2))
+	s.send(join, joinHeaderB, testsupport.NewSample(20*time.Second, "s=b host=new", 3))
+	out.AssertValues(s.T(), [][]float64{{2, 3}})
+	s.Len(join.groups, 1, "The group of the expired key must be removed")
+	s.Contains(join.groups, "new")
+	s.Equal(1, join.dropped)
+
+	join = &StreamJoin{KeyTags: []string{"host"}}
+	s.start(join)
+	s.send(join, joinHeaderA, testsupport.NewSample(0, "s=a host=old", 1))
+	s.send(join, joinHeaderA, testsupport.NewSample(time.Hour, "s=a host=new", 2))
+	s.Len(join.groups, 2, "Groups must not expire without the Expire setting")
+}
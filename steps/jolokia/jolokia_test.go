@@ -0,0 +1,140 @@
+package jolokia
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type jolokiaTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	gcCount  int
+	requests []jolokiaRequest
+}
+
+func TestJolokia(t *testing.T) {
+	suite.Run(t, new(jolokiaTestSuite))
+}
+
+func (s *jolokiaTestSuite) SetupTest() {
+	s.gcCount = 10
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/jolokia/" {
+			http.NotFound(w, r)
+			return
+		}
+		s.requests = nil
+		if err := json.NewDecoder(r.Body).Decode(&s.requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var responses []string
+		for _, req := range s.requests {
+			switch req.MBean {
+			case "java.lang:type=Memory":
+				responses = append(responses, `{"status": 200, "value": {"init": 100, "used": 50, "committed": 80, "max": -1}}`)
+			case "java.lang:type=Threading":
+				responses = append(responses, `{"status": 200, "value": 12}`)
+			case "java.lang:type=GarbageCollector,name=*":
+				responses = append(responses, fmt.Sprintf(`{"status": 200, "value": {
+					"java.lang:name=G1 Young Generation,type=GarbageCollector": {"CollectionCount": %v, "CollectionTime": 5}}}`, s.gcCount))
+			case "java.lang:type=OperatingSystem":
+				responses = append(responses, `{"status": 200, "value": {"ProcessCpuLoad": 0.5, "Name": "Linux", "Valid": true}}`)
+			default:
+				responses = append(responses, `{"status": 404, "error": "javax.management.InstanceNotFoundException"}`)
+			}
+		}
+		fmt.Fprintf(w, "[%v]", strings.Join(responses, ","))
+	}))
+}
+
+func (s *jolokiaTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *jolokiaTestSuite) TestCollect() {
+	source := &Source{
+		Url:      s.server.URL + "/jolokia/",
+		Service:  "orders",
+		Interval: time.Second,
+		MBeans: []MBean{
+			{Field: "heap", Name: "java.lang:type=Memory", Attribute: "HeapMemoryUsage"},
+			{Field: "threads", Name: "java.lang:type=Threading", Attribute: "ThreadCount"},
+			{Field: "missing", Name: "java.lang:type=Missing", Attribute: "Value"},
+			{Field: "gc", Name: "java.lang:type=GarbageCollector,name=*", Attribute: "CollectionCount,CollectionTime", Rate: true},
+			{Field: "os", Name: "java.lang:type=OperatingSystem"},
+		},
+	}
+	sample, header, err := source.Collect()
+	s.Require().NoError(err)
+	s.Equal([]string{
+		"heap/committed", "heap/init", "heap/max", "heap/used",
+		"threads",
+		"gc/G1_Young_Generation/CollectionCount", "gc/G1_Young_Generation/CollectionTime",
+		"os/ProcessCpuLoad", "os/Valid",
+	}, header.Fields)
+	s.Equal([]bitflow.Value{80, 100, -1, 50, 12, 0, 0, 0.5, 1}, sample.Values)
+	s.Equal("orders", sample.Tag(ServiceTag))
+
+	s.Len(s.requests, 5)
+	s.Equal([]interface{}{"CollectionCount", "CollectionTime"}, s.requests[3].Attribute)
+	s.Nil(s.requests[4].Attribute)
+
+	// Counters are converted to rates, the header is reused while the fields do not change
+	source.lastTime = source.lastTime.Add(-2 * time.Second)
+	s.gcCount = 20
+	sample2, header2, err := source.Collect()
+	s.Require().NoError(err)
+	s.True(header == header2)
+	s.InDelta(5, float64(sample2.Values[5]), 0.1)
+	s.Equal(bitflow.Value(0), sample2.Values[6])
+}
+
+func (s *jolokiaTestSuite) TestErrors() {
+	source := &Source{Url: s.server.URL + "/other", Interval: time.Second, MBeans: DefaultMBeans}
+	_, _, err := source.Collect()
+	s.EqualError(err, "Jolokia returned status 404 Not Found: 404 page not found")
+}
+
+func (s *jolokiaTestSuite) TestParseMBeans() {
+	mbeans, err := ParseMBeans("msgs=Count@kafka.server:type=BrokerTopicMetrics,name=MessagesInPerSec|"+
+		"old-gen=Usage/used@java.lang:type=MemoryPool,name=G1 Old Gen|pool=java.lang:type=MemoryPool,name=Metaspace", []string{"msgs"})
+	s.Require().NoError(err)
+	s.Equal([]MBean{
+		{Field: "msgs", Name: "kafka.server:type=BrokerTopicMetrics,name=MessagesInPerSec", Attribute: "Count", Rate: true},
+		{Field: "old-gen", Name: "java.lang:type=MemoryPool,name=G1 Old Gen", Attribute: "Usage", Path: "used"},
+		{Field: "pool", Name: "java.lang:type=MemoryPool,name=Metaspace"},
+	}, mbeans)
+
+	_, err = ParseMBeans("heap=HeapMemoryUsage@Memory", nil)
+	s.EqualError(err, "Invalid MBean name 'Memory'")
+	_, err = ParseMBeans("=Count@java.lang:type=Memory", nil)
+	s.EqualError(err, "Expected field=attribute@mbean, but got '=Count@java.lang:type=Memory'")
+}
+
+func (s *jolokiaTestSuite) TestNewSource() {
+	source, err := NewSource("app:8778/jolokia/?interval=30s&mbeans=msgs%3DCount@kafka.server:type%3DBrokerTopicMetrics&rates=msgs&x=y")
+	s.Require().NoError(err)
+	s.Equal("http://app:8778/jolokia/?x=y", source.Url)
+	s.Equal("app:8778", source.Service)
+	s.Equal(30*time.Second, source.Interval)
+	s.Len(source.MBeans, len(DefaultMBeans)+1)
+	s.Equal(MBean{Field: "msgs", Name: "kafka.server:type=BrokerTopicMetrics", Attribute: "Count", Rate: true}, source.MBeans[len(DefaultMBeans)])
+
+	source, err = NewSource("https://app/jolokia?service=orders")
+	s.Require().NoError(err)
+	s.Equal("https://app/jolokia", source.Url)
+	s.Equal("orders", source.Service)
+	s.Equal(DefaultInterval, source.Interval)
+
+	_, err = NewSource("app?interval=-1s")
+	s.EqualError(err, "Failed to parse 'interval' parameter: Must be positive")
+}
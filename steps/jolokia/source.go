@@ -0,0 +1,346 @@
+// Package jolokia provides a data source emitting JVM metrics, which are queried through the Jolokia HTTP bridge
+// for JMX (https://jolokia.org).
+package jolokia
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	JolokiaEndpoint = bitflow.EndpointType("jolokia")
+
+	DefaultInterval = 10 * time.Second
+	ServiceTag      = "service"
+)
+
+// MBean describes a JMX attribute that is read through Jolokia. Numeric values are emitted as one field,
+// composite values (like the heap memory usage) as one field per numeric entry, e.g. heap/used.
+type MBean struct {
+	Field     string
+	Name      string
+	Attribute string
+	Path      string
+
+	// Rate converts a counter to a rate per second
+	Rate bool
+}
+
+// DefaultMBeans are always collected. Garbage collectors are queried with a wildcard, the resulting fields are
+// named gc/<collector>/CollectionCount (collections per second) and gc/<collector>/CollectionTime (milliseconds
+// of GC time per second).
+var DefaultMBeans = []MBean{
+	{Field: "heap", Name: "java.lang:type=Memory", Attribute: "HeapMemoryUsage"},
+	{Field: "non-heap", Name: "java.lang:type=Memory", Attribute: "NonHeapMemoryUsage"},
+	{Field: "threads", Name: "java.lang:type=Threading", Attribute: "ThreadCount"},
+	{Field: "threads/daemon", Name: "java.lang:type=Threading", Attribute: "DaemonThreadCount"},
+	{Field: "classes", Name: "java.lang:type=ClassLoading", Attribute: "LoadedClassCount"},
+	{Field: "cpu", Name: "java.lang:type=OperatingSystem", Attribute: "ProcessCpuLoad"},
+	{Field: "gc", Name: "java.lang:type=GarbageCollector,name=*", Attribute: "CollectionCount,CollectionTime", Rate: true},
+}
+
+type jolokiaRequest struct {
+	Type      string      `json:"type"`
+	MBean     string      `json:"mbean"`
+	Attribute interface{} `json:"attribute,omitempty"`
+	Path      string      `json:"path,omitempty"`
+}
+
+type jolokiaResponse struct {
+	Status int             `json:"status"`
+	Error  string          `json:"error"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// Source periodically reads a set of MBeans from a Jolokia agent and emits them as one sample.
+type Source struct {
+	bitflow.AbstractSampleSource
+
+	Url      string
+	Service  string
+	Interval time.Duration
+	MBeans   []MBean
+
+	client       *http.Client
+	task         *golib.LoopTask
+	header       *bitflow.Header
+	lastTime     time.Time
+	lastCounters map[string]float64
+}
+
+func (s *Source) String() string {
+	return fmt.Sprintf("Jolokia %v (every %v)", s.Url, s.Interval)
+}
+
+func (s *Source) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			sample, header, err := s.Collect()
+			if err != nil {
+				// The JVM might be restarting, keep trying
+				s.Log().Errorln(err)
+			} else if err := s.GetSink().Sample(sample, header); err != nil {
+				return err
+			}
+			stop.WaitTimeout(s.Interval)
+			return nil
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *Source) Close() {
+	s.task.Stop()
+}
+
+// Collect reads all MBeans with one bulk request.
+func (s *Source) Collect() (*bitflow.Sample, *bitflow.Header, error) {
+	requests := make([]jolokiaRequest, len(s.MBeans))
+	for i, mbean := range s.MBeans {
+		requests[i] = jolokiaRequest{Type: "read", MBean: mbean.Name, Path: mbean.Path}
+		if strings.Contains(mbean.Attribute, ",") {
+			requests[i].Attribute = strings.Split(mbean.Attribute, ",")
+		} else if mbean.Attribute != "" {
+			requests[i].Attribute = mbean.Attribute
+		}
+	}
+	responses, err := s.query(requests)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(responses) != len(requests) {
+		return nil, nil, fmt.Errorf("Received %v responses for %v requests", len(responses), len(requests))
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(s.lastTime).Seconds()
+	counters := make(map[string]float64)
+	var fields []string
+	var values []bitflow.Value
+	for i, resp := range responses {
+		mbean := s.MBeans[i]
+		if resp.Status != http.StatusOK {
+			// Missing MBeans (e.g. a platform without ProcessCpuLoad) are skipped
+			s.Log().Debugf("Failed to read %v %v: %v", mbean.Name, mbean.Attribute, resp.Error)
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(resp.Value, &value); err != nil {
+			return nil, nil, fmt.Errorf("Invalid value of %v: %v", mbean.Name, err)
+		}
+		flat := make(map[string]float64)
+		flatten(mbean.Field, value, flat, strings.Contains(mbean.Name, "*"))
+		for _, field := range sortedKeys(flat) {
+			val := flat[field]
+			if mbean.Rate {
+				counters[field] = val
+				last, ok := s.lastCounters[field]
+				if val >= last && ok && elapsed > 0 {
+					val = (val - last) / elapsed
+				} else {
+					val = 0
+				}
+			}
+			fields = append(fields, field)
+			values = append(values, bitflow.Value(val))
+		}
+	}
+	s.lastTime, s.lastCounters = now, counters
+
+	if s.header == nil || !stringsEqual(s.header.Fields, fields) {
+		s.header = &bitflow.Header{Fields: fields}
+	}
+	sample := &bitflow.Sample{Time: now, Values: values}
+	sample.SetTag(ServiceTag, s.Service)
+	return sample, s.header, nil
+}
+
+func (s *Source) query(requests []jolokiaRequest) ([]jolokiaResponse, error) {
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return nil, err
+	}
+	client := s.client
+	if client == nil {
+		client = &http.Client{Timeout: s.Interval + 5*time.Second}
+		s.client = client
+	}
+	resp, err := client.Post(s.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Jolokia returned status %v: %v", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var responses []jolokiaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+		return nil, fmt.Errorf("Invalid response from Jolokia: %v", err)
+	}
+	return responses, nil
+}
+
+// flatten converts numeric values and nested objects to fields. For wildcard MBean queries, the keys of the
+// first level are MBean names, which are shortened to the value of their 'name' or 'type' property.
+func flatten(prefix string, value interface{}, result map[string]float64, wildcard bool) {
+	switch v := value.(type) {
+	case float64:
+		result[prefix] = v
+	case bool:
+		if v {
+			result[prefix] = 1
+		} else {
+			result[prefix] = 0
+		}
+	case map[string]interface{}:
+		for key, nested := range v {
+			if wildcard {
+				key = shortMBeanName(key)
+			}
+			flatten(prefix+"/"+key, nested, result, false)
+		}
+	}
+	// Other values (strings, arrays, null) are ignored
+}
+
+func shortMBeanName(name string) string {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) != 2 {
+		return name
+	}
+	var fallback string
+	for _, property := range strings.Split(parts[1], ",") {
+		keyValue := strings.SplitN(property, "=", 2)
+		if len(keyValue) == 2 {
+			if keyValue[0] == "name" {
+				return strings.Replace(keyValue[1], " ", "_", -1)
+			} else if keyValue[0] == "type" {
+				fallback = keyValue[1]
+			}
+		}
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return name
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMBeans parses a list of custom MBeans, separated by '|', in the form field=attribute[/path]@mbean, e.g.:
+//
+//	msgs=OneMinuteRate@kafka.server:type=BrokerTopicMetrics,name=MessagesInPerSec|old-gen=Usage/used@java.lang:type=MemoryPool,name=G1 Old Gen
+//
+// The attribute can be omitted to read all attributes of the MBean. The fields listed in rates are counters,
+// which are converted to rates per second.
+func ParseMBeans(spec string, rates []string) ([]MBean, error) {
+	var result []MBean
+	for _, part := range strings.Split(spec, "|") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fieldAndRest := strings.SplitN(part, "=", 2)
+		if len(fieldAndRest) != 2 || fieldAndRest[0] == "" {
+			return nil, fmt.Errorf("Expected field=attribute@mbean, but got '%v'", part)
+		}
+		mbean := MBean{Field: fieldAndRest[0], Name: fieldAndRest[1]}
+		if at := strings.LastIndex(fieldAndRest[1], "@"); at >= 0 {
+			mbean.Attribute, mbean.Name = fieldAndRest[1][:at], fieldAndRest[1][at+1:]
+			if slash := strings.Index(mbean.Attribute, "/"); slash >= 0 {
+				mbean.Attribute, mbean.Path = mbean.Attribute[:slash], mbean.Attribute[slash+1:]
+			}
+		}
+		if !strings.Contains(mbean.Name, ":") {
+			return nil, fmt.Errorf("Invalid MBean name '%v'", mbean.Name)
+		}
+		for _, rate := range rates {
+			mbean.Rate = mbean.Rate || rate == mbean.Field
+		}
+		result = append(result, mbean)
+	}
+	return result, nil
+}
+
+// RegisterJolokiaSource registers the jolokia:// data source. The target is the URL of the Jolokia agent
+// (http:// is assumed if no scheme is given), optionally extended with the query parameters interval, service
+// (the value of the service tag, defaults to the host of the URL), mbeans and rates (see ParseMBeans), e.g.:
+//
+//	jolokia://app-server:8778/jolokia/?service=orders&interval=30s
+func RegisterJolokiaSource(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[JolokiaEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		return NewSource(target)
+	}
+}
+
+// NewSource parses the jolokia:// endpoint target, see RegisterJolokiaSource.
+func NewSource(target string) (*Source, error) {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid Jolokia URL '%v': %v", target, err)
+	}
+	params := make(map[string]string)
+	query := u.Query()
+	for _, key := range []string{"interval", "service", "mbeans", "rates"} {
+		if _, ok := query[key]; ok {
+			params[key] = query.Get(key)
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	source := &Source{Url: u.String()}
+	source.Interval = reg.DurationParam(params, "interval", DefaultInterval, true, &err)
+	source.Service = reg.StrParam(params, "service", u.Host, true, &err)
+	mbeans := reg.StrParam(params, "mbeans", "", true, &err)
+	rates := reg.StrParam(params, "rates", "", true, &err)
+	if err != nil {
+		return nil, err
+	}
+	if source.Interval <= 0 {
+		return nil, reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+	}
+	custom, err := ParseMBeans(mbeans, strings.Split(rates, ","))
+	if err != nil {
+		return nil, reg.ParameterError("mbeans", err)
+	}
+	source.MBeans = append(append([]MBean(nil), DefaultMBeans...), custom...)
+	return source, nil
+}
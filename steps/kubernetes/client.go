@@ -0,0 +1,158 @@
+// Package kubernetes integrates bitflow pipelines with Kubernetes clusters: a data source for the pod and node
+// metrics of the metrics API (metrics.k8s.io), and a processing step adding pod metadata to samples.
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	DefaultTimeout    = 10 * time.Second
+)
+
+// Client is a minimal client for the Kubernetes API server.
+type Client struct {
+	Api   string
+	Token string
+
+	client *http.Client
+}
+
+// ClientConfig configures the connection to the API server. All fields are optional when running inside a pod:
+// the API server address is then taken from the KUBERNETES_SERVICE_HOST/PORT environment variables, and the
+// token and CA certificate from the service account directory.
+type ClientConfig struct {
+	Api       string
+	TokenFile string
+	CaFile    string
+	Insecure  bool
+}
+
+// ClientParams are the parameters parsed by ParseClientConfig
+var ClientParams = []string{"api", "token-file", "ca-file", "insecure"}
+
+// ParseClientConfig reads the ClientParams from the parameters of a processing step or data source.
+func ParseClientConfig(params map[string]string, err *error) ClientConfig {
+	return ClientConfig{
+		Api:       reg.StrParam(params, "api", "", true, err),
+		TokenFile: reg.StrParam(params, "token-file", "", true, err),
+		CaFile:    reg.StrParam(params, "ca-file", "", true, err),
+		Insecure:  reg.BoolParam(params, "insecure", false, true, err),
+	}
+}
+
+func NewClient(config ClientConfig) (*Client, error) {
+	api := config.Api
+	if api == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("Not running inside a Kubernetes cluster, the API server address must be configured")
+		}
+		api = "https://" + net.JoinHostPort(host, port)
+	}
+	tokenFile, caFile := config.TokenFile, config.CaFile
+	if config.Api == "" {
+		if tokenFile == "" {
+			tokenFile = ServiceAccountDir + "/token"
+		}
+		if caFile == "" {
+			caFile = ServiceAccountDir + "/ca.crt"
+		}
+	}
+
+	c := &Client{Api: strings.TrimSuffix(api, "/")}
+	if tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read Kubernetes token: %v", err)
+		}
+		c.Token = strings.TrimSpace(string(token))
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+	if caFile != "" && !config.Insecure {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read Kubernetes CA certificate: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No valid certificates in %v", caFile)
+		}
+	}
+	c.client = &http.Client{
+		Timeout:   DefaultTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return c, nil
+}
+
+// Get requests the given API path and decodes the JSON response.
+func (c *Client) Get(path string, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.Api+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GET %v returned status %v: %v", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// ObjectMeta contains the metadata fields of Kubernetes objects that are used in this package.
+type ObjectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+var quantitySuffixes = map[string]float64{
+	"n": 1e-9, "u": 1e-6, "m": 1e-3, "": 1,
+	"k": 1e3, "M": 1e6, "G": 1e9, "T": 1e12, "P": 1e15, "E": 1e18,
+	"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30, "Ti": 1 << 40, "Pi": 1 << 50, "Ei": 1 << 60,
+}
+
+// ParseQuantity parses a Kubernetes resource quantity like 250m (CPU cores) or 128Mi (bytes).
+func ParseQuantity(quantity string) (float64, error) {
+	quantity = strings.TrimSpace(quantity)
+	i := len(quantity)
+	for i > 0 && (quantity[i-1] < '0' || quantity[i-1] > '9') && quantity[i-1] != '.' {
+		i--
+	}
+	number, suffix := quantity[:i], quantity[i:]
+	multiplier, ok := quantitySuffixes[suffix]
+	if !ok {
+		// Decimal exponent notation, e.g. 1e3
+		if val, err := strconv.ParseFloat(quantity, 64); err == nil {
+			return val, nil
+		}
+		return 0, fmt.Errorf("Invalid quantity '%v'", quantity)
+	}
+	val, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("Invalid quantity '%v'", quantity)
+	}
+	return val * multiplier, nil
+}
@@ -0,0 +1,140 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type kubernetesTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	podLists int32
+}
+
+func TestKubernetes(t *testing.T) {
+	suite.Run(t, new(kubernetesTestSuite))
+}
+
+func (s *kubernetesTestSuite) SetupTest() {
+	atomic.StoreInt32(&s.podLists, 0)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/apis/metrics.k8s.io/v1beta1/nodes":
+			fmt.Fprint(w, `{"items": [{"metadata": {"name": "node-1"}, "timestamp": "2020-01-01T00:00:00Z", "usage": {"cpu": "1500m", "memory": "2Gi"}}]}`)
+		case "/apis/metrics.k8s.io/v1beta1/namespaces/default/pods":
+			fmt.Fprint(w, `{"items": [
+				{"metadata": {"name": "web", "namespace": "default"}, "containers": [
+					{"name": "a", "usage": {"cpu": "250000000n", "memory": "100Mi"}},
+					{"name": "b", "usage": {"cpu": "250m", "memory": "28Mi"}}]},
+				{"metadata": {"name": "broken", "namespace": "default"}, "containers": [{"name": "a", "usage": {"cpu": "x", "memory": "1"}}]}]}`)
+		case "/api/v1/pods":
+			atomic.AddInt32(&s.podLists, 1)
+			fmt.Fprint(w, `{"items": [
+				{"metadata": {"name": "web", "namespace": "default", "labels": {"app": "nginx"}}, "spec": {"nodeName": "node-1"}, "status": {"podIP": "10.0.0.5"}},
+				{"metadata": {"name": "web", "namespace": "other"}, "spec": {"nodeName": "node-2"}, "status": {"podIP": "10.0.0.6"}}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func (s *kubernetesTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *kubernetesTestSuite) client() *Client {
+	return &Client{Api: s.server.URL, Token: "secret", client: http.DefaultClient}
+}
+
+func (s *kubernetesTestSuite) TestParseQuantity() {
+	for quantity, expected := range map[string]float64{
+		"1": 1, "250m": 0.25, "100n": 1e-7, "1.5Gi": 1.5 * (1 << 30), "2k": 2000, "1e3": 1000, "10u": 1e-5,
+	} {
+		val, err := ParseQuantity(quantity)
+		s.NoError(err, quantity)
+		s.InDelta(expected, val, expected*1e-9, quantity)
+	}
+	for _, invalid := range []string{"", "m", "1x", "abc"} {
+		_, err := ParseQuantity(invalid)
+		s.Error(err, invalid)
+	}
+}
+
+func (s *kubernetesTestSuite) TestMetricsSource() {
+	source := &MetricsSource{Client: s.client(), Nodes: true, Pods: true, Namespace: "default"}
+	samples, err := source.Collect()
+	s.Require().NoError(err)
+	s.Require().Len(samples, 2)
+
+	s.Equal([]bitflow.Value{1.5, 2 << 30}, samples[0].Values)
+	s.Equal(map[string]string{KindTag: "node", NodeTag: "node-1"}, samples[0].TagMap())
+	s.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), samples[0].Time.UTC())
+
+	s.Equal([]bitflow.Value{0.5, 128 << 20}, samples[1].Values)
+	s.Equal(map[string]string{KindTag: "pod", PodTag: "web", NamespaceTag: "default"}, samples[1].TagMap())
+
+	source.Client.Token = "wrong"
+	_, err = source.Collect()
+	s.EqualError(err, "Failed to query node metrics: GET /apis/metrics.k8s.io/v1beta1/nodes returned status 401 Unauthorized: Unauthorized")
+}
+
+func (s *kubernetesTestSuite) TestMetricsSourceParameters() {
+	source, err := NewMetricsSource(map[string]string{"api": s.server.URL, "kind": "pods", "interval": "1m"})
+	s.Require().NoError(err)
+	s.False(source.Nodes)
+	s.True(source.Pods)
+	s.Equal(time.Minute, source.Interval)
+
+	_, err = NewMetricsSource(map[string]string{"api": s.server.URL, "kind": "services"})
+	s.EqualError(err, "Failed to parse 'kind' parameter: Expected 'nodes', 'pods' or 'all', but got 'services'")
+	_, err = NewMetricsSource(map[string]string{"api": s.server.URL, "other": "x"})
+	s.EqualError(err, "Unexpected parameter for k8s endpoint: other")
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, _ *bitflow.Header) error {
+	s.samples = append(s.samples, sample)
+	return nil
+}
+
+func (s *kubernetesTestSuite) TestPodTagger() {
+	tagger := &PodTagger{Client: s.client(), Tag: "ip", LabelPrefix: DefaultLabelPrefix, Refresh: time.Hour}
+	out := new(collectingSink)
+	tagger.SetSink(out)
+	header := &bitflow.Header{}
+
+	for _, ip := range []string{"10.0.0.5", "10.0.0.6", "10.0.0.7"} {
+		sample := new(bitflow.Sample)
+		sample.SetTag("ip", ip)
+		s.NoError(tagger.Sample(sample, header))
+	}
+	s.Equal(map[string]string{"ip": "10.0.0.5", NamespaceTag: "default", PodTag: "web", NodeTag: "node-1", "label/app": "nginx"}, out.samples[0].TagMap())
+	s.Equal(map[string]string{"ip": "10.0.0.6", NamespaceTag: "other", PodTag: "web", NodeTag: "node-2"}, out.samples[1].TagMap())
+	s.Equal(map[string]string{"ip": "10.0.0.7"}, out.samples[2].TagMap())
+	s.Equal(int32(1), atomic.LoadInt32(&s.podLists), "The pod list should only be queried once")
+
+	// Match by name, using the namespace tag to distinguish pods with the same name
+	tagger = &PodTagger{Client: s.client(), Tag: "name", ByName: true, Refresh: time.Hour}
+	tagger.SetSink(out)
+	sample := new(bitflow.Sample)
+	sample.SetTag("name", "web")
+	sample.SetTag(NamespaceTag, "other")
+	s.NoError(tagger.Sample(sample, header))
+	s.Equal("node-2", sample.Tag(NodeTag))
+	s.False(sample.HasTag("label/app"))
+}
@@ -0,0 +1,233 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	MetricsEndpoint = bitflow.EndpointType("k8s")
+
+	DefaultInterval = 15 * time.Second
+
+	KindTag      = "kind"
+	NodeTag      = "node"
+	PodTag       = "pod"
+	NamespaceTag = "namespace"
+
+	metricsApi = "/apis/metrics.k8s.io/v1beta1"
+)
+
+var metricsHeader = &bitflow.Header{Fields: []string{"cpu", "mem"}}
+
+type resourceUsage struct {
+	Cpu    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+func (u resourceUsage) parse() (cpu float64, mem float64, err error) {
+	if cpu, err = ParseQuantity(u.Cpu); err == nil {
+		mem, err = ParseQuantity(u.Memory)
+	}
+	return
+}
+
+type nodeMetricsList struct {
+	Items []struct {
+		Metadata  ObjectMeta    `json:"metadata"`
+		Timestamp time.Time     `json:"timestamp"`
+		Usage     resourceUsage `json:"usage"`
+	} `json:"items"`
+}
+
+type podMetricsList struct {
+	Items []struct {
+		Metadata   ObjectMeta `json:"metadata"`
+		Timestamp  time.Time  `json:"timestamp"`
+		Containers []struct {
+			Name  string        `json:"name"`
+			Usage resourceUsage `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// MetricsSource periodically queries the Kubernetes metrics API and emits one sample per node and per pod.
+// Every sample contains the CPU usage (in cores) and memory usage (in bytes). Node samples are tagged with the
+// node name, pod samples with the pod name and namespace, and all samples with the kind (node or pod).
+type MetricsSource struct {
+	bitflow.AbstractSampleSource
+	Client   *Client
+	Interval time.Duration
+
+	Nodes, Pods bool
+
+	// Namespace optionally restricts the collected pods
+	Namespace string
+
+	task *golib.LoopTask
+}
+
+func (s *MetricsSource) String() string {
+	var kinds []string
+	if s.Nodes {
+		kinds = append(kinds, "nodes")
+	}
+	if s.Pods {
+		kinds = append(kinds, "pods")
+	}
+	return fmt.Sprintf("Kubernetes metrics of %v from %v (every %v)", strings.Join(kinds, " and "), s.Client.Api, s.Interval)
+}
+
+func (s *MetricsSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			samples, err := s.Collect()
+			if err != nil {
+				// The API server might be temporarily unavailable, keep trying
+				s.Log().Errorln(err)
+			}
+			for _, sample := range samples {
+				if err := s.GetSink().Sample(sample, metricsHeader); err != nil {
+					return err
+				}
+			}
+			stop.WaitTimeout(s.Interval)
+			return nil
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *MetricsSource) Close() {
+	s.task.Stop()
+}
+
+func usageSample(timestamp time.Time, cpu, mem float64, tags ...string) *bitflow.Sample {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	sample := &bitflow.Sample{Time: timestamp, Values: []bitflow.Value{bitflow.Value(cpu), bitflow.Value(mem)}}
+	for i := 0; i < len(tags); i += 2 {
+		sample.SetTag(tags[i], tags[i+1])
+	}
+	return sample
+}
+
+// Collect queries the current node and pod metrics.
+func (s *MetricsSource) Collect() ([]*bitflow.Sample, error) {
+	var samples []*bitflow.Sample
+	if s.Nodes {
+		var nodes nodeMetricsList
+		if err := s.Client.Get(metricsApi+"/nodes", &nodes); err != nil {
+			return nil, fmt.Errorf("Failed to query node metrics: %v", err)
+		}
+		for _, node := range nodes.Items {
+			cpu, mem, err := node.Usage.parse()
+			if err != nil {
+				s.Log().Warnf("Invalid metrics of node %v: %v", node.Metadata.Name, err)
+				continue
+			}
+			samples = append(samples, usageSample(node.Timestamp, cpu, mem, KindTag, "node", NodeTag, node.Metadata.Name))
+		}
+	}
+	if s.Pods {
+		path := metricsApi + "/pods"
+		if s.Namespace != "" {
+			path = metricsApi + "/namespaces/" + url.PathEscape(s.Namespace) + "/pods"
+		}
+		var pods podMetricsList
+		if err := s.Client.Get(path, &pods); err != nil {
+			return samples, fmt.Errorf("Failed to query pod metrics: %v", err)
+		}
+		for _, pod := range pods.Items {
+			var cpu, mem float64
+			var err error
+			for _, container := range pod.Containers {
+				var containerCpu, containerMem float64
+				if containerCpu, containerMem, err = container.Usage.parse(); err != nil {
+					break
+				}
+				cpu += containerCpu
+				mem += containerMem
+			}
+			if err != nil {
+				s.Log().Warnf("Invalid metrics of pod %v/%v: %v", pod.Metadata.Namespace, pod.Metadata.Name, err)
+				continue
+			}
+			samples = append(samples, usageSample(pod.Timestamp, cpu, mem,
+				KindTag, "pod", PodTag, pod.Metadata.Name, NamespaceTag, pod.Metadata.Namespace))
+		}
+	}
+	return samples, nil
+}
+
+// RegisterMetricsSource registers the k8s:// data source. The target contains optional query parameters:
+// interval, kind (nodes, pods or all), namespace, and the client parameters (api, token-file, ca-file, insecure).
+// Example: k8s://kind=pods&namespace=default&interval=30s. The target '-' uses the default settings.
+func RegisterMetricsSource(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[MetricsEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		params := make(map[string]string)
+		if target != "-" {
+			query, err := url.ParseQuery(target)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse parameters of %v endpoint: %v", MetricsEndpoint, err)
+			}
+			for key, values := range query {
+				params[key] = strings.Join(values, ",")
+			}
+		}
+		return NewMetricsSource(params)
+	}
+}
+
+func NewMetricsSource(params map[string]string) (*MetricsSource, error) {
+	var err error
+	interval := reg.DurationParam(params, "interval", DefaultInterval, true, &err)
+	kind := reg.StrParam(params, "kind", "all", true, &err)
+	namespace := reg.StrParam(params, "namespace", "", true, &err)
+	config := ParseClientConfig(params, &err)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkParams(params, append([]string{"interval", "kind", "namespace"}, ClientParams...)); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+	}
+	source := &MetricsSource{Interval: interval, Namespace: namespace}
+	switch kind {
+	case "all":
+		source.Nodes, source.Pods = true, true
+	case "nodes":
+		source.Nodes = true
+	case "pods":
+		source.Pods = true
+	default:
+		return nil, reg.ParameterError("kind", fmt.Errorf("Expected 'nodes', 'pods' or 'all', but got '%v'", kind))
+	}
+	source.Client, err = NewClient(config)
+	return source, err
+}
+
+func checkParams(params map[string]string, allowed []string) error {
+	for key := range params {
+		known := false
+		for _, allowedKey := range allowed {
+			known = known || key == allowedKey
+		}
+		if !known {
+			return fmt.Errorf("Unexpected parameter for %v endpoint: %v", MetricsEndpoint, key)
+		}
+	}
+	return nil
+}
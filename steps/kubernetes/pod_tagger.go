@@ -0,0 +1,170 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultRefreshInterval = time.Minute
+	DefaultLabelPrefix     = "label/"
+)
+
+type podList struct {
+	Items []podInfo `json:"items"`
+}
+
+type podInfo struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+	Status struct {
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+// PodTagger adds the namespace, pod name, node name and labels of Kubernetes pods to samples. The pod is
+// identified by the value of a tag in the sample, which contains either the IP or the name of the pod.
+// The list of pods is queried from the API server and refreshed periodically.
+type PodTagger struct {
+	bitflow.NoopProcessor
+	Client *Client
+
+	// Tag is the name of the sample tag that identifies the pod
+	Tag string
+
+	// ByName means that Tag contains the pod name. Otherwise, it contains the pod IP. When matching by name,
+	// an existing namespace tag in the sample is used to distinguish pods with the same name.
+	ByName bool
+
+	// Namespace optionally restricts the queried pods
+	Namespace string
+
+	// LabelPrefix is prepended to the names of the label tags. Labels are not added if it is empty.
+	LabelPrefix string
+
+	Refresh time.Duration
+
+	pods        map[string]*podInfo
+	lastRefresh time.Time
+}
+
+func (t *PodTagger) String() string {
+	key := "IP"
+	if t.ByName {
+		key = "name"
+	}
+	return fmt.Sprintf("Add Kubernetes pod metadata (pod %v in tag '%v', refresh every %v)", key, t.Tag, t.Refresh)
+}
+
+func (t *PodTagger) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if time.Since(t.lastRefresh) >= t.Refresh {
+		if err := t.refresh(); err != nil {
+			// Keep working with the previous list of pods
+			log.Warnf("%v: Failed to refresh the list of pods: %v", t, err)
+		}
+		t.lastRefresh = time.Now()
+	}
+	if pod := t.lookup(sample); pod != nil {
+		sample.SetTag(NamespaceTag, pod.Metadata.Namespace)
+		sample.SetTag(PodTag, pod.Metadata.Name)
+		if pod.Spec.NodeName != "" {
+			sample.SetTag(NodeTag, pod.Spec.NodeName)
+		}
+		if t.LabelPrefix != "" {
+			for key, value := range pod.Metadata.Labels {
+				sample.SetTag(t.LabelPrefix+key, value)
+			}
+		}
+	}
+	return t.NoopProcessor.Sample(sample, header)
+}
+
+func (t *PodTagger) lookup(sample *bitflow.Sample) *podInfo {
+	value := sample.Tag(t.Tag)
+	if value == "" {
+		return nil
+	}
+	if t.ByName && sample.HasTag(NamespaceTag) {
+		if pod, ok := t.pods[sample.Tag(NamespaceTag)+"/"+value]; ok {
+			return pod
+		}
+	}
+	return t.pods[value]
+}
+
+func (t *PodTagger) refresh() error {
+	path := "/api/v1/pods"
+	if t.Namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(t.Namespace) + "/pods"
+	}
+	var pods podList
+	if err := t.Client.Get(path, &pods); err != nil {
+		return err
+	}
+	index := make(map[string]*podInfo, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if t.ByName {
+			index[pod.Metadata.Name] = pod
+			index[pod.Metadata.Namespace+"/"+pod.Metadata.Name] = pod
+		} else if pod.Status.PodIP != "" {
+			index[pod.Status.PodIP] = pod
+		}
+	}
+	t.pods = index
+	return nil
+}
+
+func RegisterPodTagger(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("k8s_tags",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			var err error
+			tagger := &PodTagger{
+				Tag:       reg.StrParam(params, "tag", "", false, &err),
+				Namespace: reg.StrParam(params, "namespace", "", true, &err),
+				Refresh:   reg.DurationParam(params, "refresh", DefaultRefreshInterval, true, &err),
+			}
+			match := reg.StrParam(params, "match", "ip", true, &err)
+			labels := reg.BoolParam(params, "labels", true, true, &err)
+			tagger.LabelPrefix = reg.StrParam(params, "label-prefix", DefaultLabelPrefix, true, &err)
+			config := ParseClientConfig(params, &err)
+			if err != nil {
+				return err
+			}
+			switch match {
+			case "ip":
+			case "name":
+				tagger.ByName = true
+			default:
+				return reg.ParameterError("match", fmt.Errorf("Expected 'ip' or 'name', but got '%v'", match))
+			}
+			if !labels {
+				tagger.LabelPrefix = ""
+			}
+			tagger.Client, err = NewClient(config)
+			if err == nil {
+				p.Add(tagger)
+			}
+			return err
+		},
+		"Add the namespace, pod name, node name and labels of Kubernetes pods to the samples. The pod is identified by a tag containing its IP or name.",
+		reg.RequiredParams("tag"),
+		reg.OptionalParams("match", "namespace", "refresh", "labels", "label-prefix", "api", "token-file", "ca-file", "insecure"),
+		reg.ParamDetails("tag", reg.TypeString, "", "Name of the tag identifying the pod"),
+		reg.ParamDetails("match", reg.TypeString, "ip", "Whether the tag contains the pod 'ip' or 'name'"),
+		reg.ParamDetails("namespace", reg.TypeString, "", "Only consider pods in this namespace"),
+		reg.ParamDetails("refresh", reg.TypeDuration, DefaultRefreshInterval.String(), "Interval for refreshing the list of pods"),
+		reg.ParamDetails("labels", reg.TypeBool, "true", "Whether to add the pod labels as tags"),
+		reg.ParamDetails("label-prefix", reg.TypeString, DefaultLabelPrefix, "Prefix for the names of the label tags"),
+		reg.ParamDetails("api", reg.TypeString, "", "Address of the API server (default: in-cluster configuration)"),
+		reg.ParamDetails("token-file", reg.TypeString, "", "File containing the bearer token for the API server"),
+		reg.ParamDetails("ca-file", reg.TypeString, "", "CA certificate of the API server"),
+		reg.ParamDetails("insecure", reg.TypeBool, "false", "Skip the verification of the API server certificate"))
+}
@@ -0,0 +1,67 @@
+// Package libvirt provides a data source emitting metrics of the virtual machines managed by a libvirt hypervisor.
+// The statistics are queried through the virsh command line tool, which supports all libvirt connection URIs,
+// including remote hypervisors accessed via qemu+tcp:// or qemu+ssh://.
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DomainStats contains the raw statistics of one domain, as printed by 'virsh domstats --raw'.
+type DomainStats struct {
+	Name  string
+	Stats map[string]string
+}
+
+// ParseDomainStats parses the output of 'virsh domstats --raw', which has the following format:
+//
+//	Domain: 'vm1'
+//	  state.state=1
+//	  cpu.time=1234567
+//	  block.0.rd.bytes=4096
+func ParseDomainStats(output []byte) ([]DomainStats, error) {
+	var result []DomainStats
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "Domain:") {
+			name := strings.TrimSpace(strings.TrimPrefix(line, "Domain:"))
+			name = strings.TrimSuffix(strings.TrimPrefix(name, "'"), "'")
+			result = append(result, DomainStats{Name: name, Stats: make(map[string]string)})
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || len(result) == 0 {
+			return nil, fmt.Errorf("Unexpected output of virsh domstats in line %v: %q", lineNum, line)
+		}
+		result[len(result)-1].Stats[parts[0]] = parts[1]
+	}
+	return result, scanner.Err()
+}
+
+// Float returns the numeric value of a statistic, or 0 if it is not available.
+func (d *DomainStats) Float(key string) float64 {
+	val, err := strconv.ParseFloat(d.Stats[key], 64)
+	if err != nil {
+		return 0
+	}
+	return val
+}
+
+// Sum returns the sum of a statistic over all devices of a type, e.g. Sum("block", "rd.bytes")
+// sums up block.0.rd.bytes, block.1.rd.bytes, and so on.
+func (d *DomainStats) Sum(device string, stat string) float64 {
+	count := int(d.Float(device + ".count"))
+	sum := 0.0
+	for i := 0; i < count; i++ {
+		sum += d.Float(device + "." + strconv.Itoa(i) + "." + stat)
+	}
+	return sum
+}
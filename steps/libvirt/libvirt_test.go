@@ -0,0 +1,120 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type libvirtTestSuite struct {
+	suite.Suite
+}
+
+func TestLibvirt(t *testing.T) {
+	suite.Run(t, new(libvirtTestSuite))
+}
+
+func domstats(cpuTime, rxBytes int) string {
+	return fmt.Sprintf(`Domain: 'web-1'
+  state.state=1
+  cpu.time=%v
+  balloon.current=1048576
+  balloon.rss=524288
+  vcpu.current=2
+  net.count=2
+  net.0.name=vnet0
+  net.0.rx.bytes=%v
+  net.1.name=vnet1
+  net.1.rx.bytes=1000
+  block.count=1
+  block.0.name=vda
+  block.0.wr.bytes=4096
+
+Domain: 'test-vm'
+  state.state=1
+  cpu.time=0
+`, cpuTime, rxBytes)
+}
+
+func (s *libvirtTestSuite) TestParse() {
+	stats, err := ParseDomainStats([]byte(domstats(100, 200)))
+	s.Require().NoError(err)
+	s.Len(stats, 2)
+	s.Equal("web-1", stats[0].Name)
+	s.Equal("test-vm", stats[1].Name)
+	s.Equal(1200.0, stats[0].Sum("net", "rx.bytes"))
+	s.Equal(0.0, stats[1].Sum("net", "rx.bytes"))
+	s.Equal(2.0, stats[0].Float("vcpu.current"))
+
+	_, err = ParseDomainStats([]byte("cpu.time=1\n"))
+	s.EqualError(err, `Unexpected output of virsh domstats in line 1: "cpu.time=1"`)
+}
+
+func (s *libvirtTestSuite) TestCollect() {
+	source, err := NewLibvirtSource("qemu+ssh://user@host/system?keyfile=/key&interval=5s&exclude=^test-")
+	s.Require().NoError(err)
+	s.Equal("qemu+ssh://user@host/system?keyfile=%2Fkey", source.Uri)
+	s.Equal(5*time.Second, source.Interval)
+
+	output := domstats(0, 0)
+	var args []string
+	source.Virsh = func(a ...string) ([]byte, error) {
+		args = a
+		return []byte(output), nil
+	}
+	samples, err := source.Collect()
+	s.NoError(err)
+	s.Empty(samples)
+	s.Equal([]string{"-c", "qemu+ssh://user@host/system?keyfile=%2Fkey"}, args[:2])
+
+	// Simulate one second between the queries: 0.5 seconds of CPU time and 2000 received bytes
+	source.domains["web-1"].time = source.domains["web-1"].time.Add(-time.Second)
+	output = domstats(5e8, 2000)
+	samples, err = source.Collect()
+	s.NoError(err)
+	s.Require().Len(samples, 1)
+	s.Equal("web-1", samples[0].Tag(DomainTag))
+
+	values := make(map[string]bitflow.Value)
+	for i, field := range source.header.Fields {
+		values[field] = samples[0].Values[i]
+	}
+	s.InDelta(50, float64(values["cpu"]), 1)
+	s.InDelta(2000, float64(values["net/rx-bytes"]), 20)
+	s.Equal(bitflow.Value(2), values["vcpus"])
+	s.Equal(bitflow.Value(1<<30), values["mem"])
+	s.Equal(bitflow.Value(0), values["disk/write-bytes"])
+
+	// Stopped domains are forgotten
+	output = ""
+	samples, err = source.Collect()
+	s.NoError(err)
+	s.Empty(samples)
+	s.Empty(source.domains)
+
+	source.Virsh = func(...string) ([]byte, error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	_, err = source.Collect()
+	s.EqualError(err, "Failed to query domain statistics: connection refused")
+}
+
+func (s *libvirtTestSuite) TestParameters() {
+	source, err := NewLibvirtSource("-")
+	s.Require().NoError(err)
+	s.Equal(DefaultUri, source.Uri)
+	s.Equal(DefaultInterval, source.Interval)
+
+	source, err = NewLibvirtSource("qemu+tcp://10.0.0.1/system?include=web")
+	s.Require().NoError(err)
+	s.Equal("qemu+tcp://10.0.0.1/system", source.Uri)
+	s.True(source.Include.MatchString("web-2"))
+
+	_, err = NewLibvirtSource("qemu:///system?interval=0s")
+	s.EqualError(err, "Failed to parse 'interval' parameter: Must be positive")
+	_, err = NewLibvirtSource("qemu:///system?exclude=(")
+	s.Error(err)
+}
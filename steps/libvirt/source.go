@@ -0,0 +1,243 @@
+package libvirt
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	LibvirtEndpoint = bitflow.EndpointType("libvirt")
+
+	DefaultInterval = time.Second
+	DefaultUri      = "qemu:///system"
+	DomainTag       = "vm"
+)
+
+var domainMetrics = []string{
+	"cpu", "vcpus",
+	"mem", "mem/rss", "mem/unused", "mem/available",
+	"disk/read-bytes", "disk/write-bytes", "disk/read-ops", "disk/write-ops",
+	"net/rx-bytes", "net/tx-bytes", "net/rx-packets", "net/tx-packets", "net/errors", "net/dropped",
+}
+
+// counters are converted to rates per second. The CPU time is in nanoseconds and is converted to percent.
+var domainCounters = map[string]func(*DomainStats) float64{
+	"cpu":              func(d *DomainStats) float64 { return d.Float("cpu.time") / 1e7 },
+	"disk/read-bytes":  func(d *DomainStats) float64 { return d.Sum("block", "rd.bytes") },
+	"disk/write-bytes": func(d *DomainStats) float64 { return d.Sum("block", "wr.bytes") },
+	"disk/read-ops":    func(d *DomainStats) float64 { return d.Sum("block", "rd.reqs") },
+	"disk/write-ops":   func(d *DomainStats) float64 { return d.Sum("block", "wr.reqs") },
+	"net/rx-bytes":     func(d *DomainStats) float64 { return d.Sum("net", "rx.bytes") },
+	"net/tx-bytes":     func(d *DomainStats) float64 { return d.Sum("net", "tx.bytes") },
+	"net/rx-packets":   func(d *DomainStats) float64 { return d.Sum("net", "rx.pkts") },
+	"net/tx-packets":   func(d *DomainStats) float64 { return d.Sum("net", "tx.pkts") },
+	"net/errors":       func(d *DomainStats) float64 { return d.Sum("net", "rx.errs") + d.Sum("net", "tx.errs") },
+	"net/dropped":      func(d *DomainStats) float64 { return d.Sum("net", "rx.drop") + d.Sum("net", "tx.drop") },
+}
+
+// The balloon statistics are in KiB
+var domainGauges = map[string]func(*DomainStats) float64{
+	"vcpus":         func(d *DomainStats) float64 { return d.Float("vcpu.current") },
+	"mem":           func(d *DomainStats) float64 { return d.Float("balloon.current") * 1024 },
+	"mem/rss":       func(d *DomainStats) float64 { return d.Float("balloon.rss") * 1024 },
+	"mem/unused":    func(d *DomainStats) float64 { return d.Float("balloon.unused") * 1024 },
+	"mem/available": func(d *DomainStats) float64 { return d.Float("balloon.available") * 1024 },
+}
+
+type domainState struct {
+	time     time.Time
+	counters map[string]float64
+}
+
+// LibvirtSource periodically queries the statistics of all running domains of a libvirt hypervisor and emits
+// one sample per domain, tagged with the domain name. Counters are converted to rates per second, so the first
+// query of a domain does not produce a sample.
+type LibvirtSource struct {
+	bitflow.AbstractSampleSource
+
+	// Uri is the libvirt connection URI, e.g. qemu:///system, qemu+tcp://host/system or qemu+ssh://user@host/system
+	Uri      string
+	Interval time.Duration
+
+	// Include and Exclude optionally select the domains by name
+	Include, Exclude *regexp.Regexp
+
+	// Virsh executes the virsh tool with the given arguments and returns its standard output.
+	// Defaults to running the 'virsh' executable.
+	Virsh func(args ...string) ([]byte, error)
+
+	task    *golib.LoopTask
+	header  *bitflow.Header
+	domains map[string]*domainState
+}
+
+func (s *LibvirtSource) String() string {
+	return fmt.Sprintf("Libvirt domains of %v (every %v)", s.Uri, s.Interval)
+}
+
+func (s *LibvirtSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			samples, err := s.Collect()
+			if err != nil {
+				// The hypervisor might be temporarily unavailable, keep trying
+				s.Log().Errorln(err)
+			}
+			for _, sample := range samples {
+				if err := s.GetSink().Sample(sample, s.header); err != nil {
+					return err
+				}
+			}
+			stop.WaitTimeout(s.Interval)
+			return nil
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *LibvirtSource) Close() {
+	s.task.Stop()
+}
+
+func runVirsh(args ...string) ([]byte, error) {
+	output, err := exec.Command("virsh", args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		err = fmt.Errorf("%v: %v", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return output, err
+}
+
+// Collect queries the current statistics and returns one sample for every running domain that was already
+// known in the previous query. Domains that are no longer running are forgotten.
+func (s *LibvirtSource) Collect() ([]*bitflow.Sample, error) {
+	virsh := s.Virsh
+	if virsh == nil {
+		virsh = runVirsh
+	}
+	output, err := virsh("-c", s.Uri, "domstats", "--raw", "--list-active",
+		"--state", "--cpu-total", "--balloon", "--vcpu", "--interface", "--block")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query domain statistics: %v", err)
+	}
+	now := time.Now()
+	stats, err := ParseDomainStats(output)
+	if err != nil {
+		return nil, err
+	}
+	if s.header == nil {
+		s.header = &bitflow.Header{Fields: domainMetrics}
+	}
+	if s.domains == nil {
+		s.domains = make(map[string]*domainState)
+	}
+
+	var samples []*bitflow.Sample
+	running := make(map[string]bool, len(stats))
+	for i := range stats {
+		domain := &stats[i]
+		if (s.Include != nil && !s.Include.MatchString(domain.Name)) || (s.Exclude != nil && s.Exclude.MatchString(domain.Name)) {
+			continue
+		}
+		running[domain.Name] = true
+		if sample := s.domainSample(domain, now); sample != nil {
+			samples = append(samples, sample)
+		}
+	}
+	for name := range s.domains {
+		if !running[name] {
+			delete(s.domains, name)
+		}
+	}
+	return samples, nil
+}
+
+func (s *LibvirtSource) domainSample(domain *DomainStats, now time.Time) *bitflow.Sample {
+	counters := make(map[string]float64, len(domainCounters))
+	for name, get := range domainCounters {
+		counters[name] = get(domain)
+	}
+	previous, known := s.domains[domain.Name]
+	s.domains[domain.Name] = &domainState{time: now, counters: counters}
+	if !known {
+		return nil
+	}
+
+	elapsed := now.Sub(previous.time).Seconds()
+	sample := &bitflow.Sample{Time: now, Values: make([]bitflow.Value, len(domainMetrics))}
+	for i, name := range domainMetrics {
+		var value float64
+		if get, isGauge := domainGauges[name]; isGauge {
+			value = get(domain)
+		} else if last := previous.counters[name]; elapsed > 0 && counters[name] >= last {
+			value = (counters[name] - last) / elapsed
+		}
+		sample.Values[i] = bitflow.Value(value)
+	}
+	sample.SetTag(DomainTag, domain.Name)
+	return sample
+}
+
+// RegisterLibvirtSource registers the libvirt:// data source. The target is a libvirt connection URI, optionally
+// extended with the query parameters interval, include and exclude, e.g.:
+//
+//	libvirt://qemu+ssh://user@host/system?interval=5s&exclude=^test-
+//
+// Other query parameters are passed on to libvirt as part of the URI. The target '-' connects to qemu:///system.
+func RegisterLibvirtSource(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[LibvirtEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		return NewLibvirtSource(target)
+	}
+}
+
+// NewLibvirtSource parses the libvirt:// endpoint target, see RegisterLibvirtSource.
+func NewLibvirtSource(target string) (*LibvirtSource, error) {
+	if target == "-" {
+		target = DefaultUri
+	}
+	uri, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid libvirt URI '%v': %v", target, err)
+	}
+	query := uri.Query()
+	params := make(map[string]string)
+	for _, key := range []string{"interval", "include", "exclude"} {
+		if _, ok := query[key]; ok {
+			params[key] = query.Get(key)
+			query.Del(key)
+		}
+	}
+	uri.RawQuery = query.Encode()
+
+	source := &LibvirtSource{Uri: uri.String()}
+	source.Interval = reg.DurationParam(params, "interval", DefaultInterval, true, &err)
+	include := reg.StrParam(params, "include", "", true, &err)
+	exclude := reg.StrParam(params, "exclude", "", true, &err)
+	if err != nil {
+		return nil, err
+	}
+	if source.Interval <= 0 {
+		return nil, reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+	}
+	if include != "" {
+		if source.Include, err = regexp.Compile(include); err != nil {
+			return nil, reg.ParameterError("include", err)
+		}
+	}
+	if exclude != "" {
+		if source.Exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, reg.ParameterError("exclude", err)
+		}
+	}
+	return source, nil
+}
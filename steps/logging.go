@@ -3,7 +3,6 @@ package steps
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"math"
 	"sort"
 	"strconv"
@@ -11,6 +10,7 @@ import (
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
 )
 
 func RegisterLoggingSteps(b reg.ProcessorRegistry) {
@@ -0,0 +1,171 @@
+package lua
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+	lua "github.com/yuin/gopher-lua"
+)
+
+type luaTestSuite struct {
+	suite.Suite
+}
+
+func TestLua(t *testing.T) {
+	suite.Run(t, new(luaTestSuite))
+}
+
+func (s *luaTestSuite) eval(code string) []lua.LValue {
+	state := NewState()
+	defer state.Close()
+	s.Require().NoError(state.Load("test", "function f() "+code+" end"))
+	results, err := state.CallGlobal("f")
+	s.Require().NoError(err)
+	return results
+}
+
+func (s *luaTestSuite) evalError(code string) string {
+	state := NewState()
+	defer state.Close()
+	err := state.Load("test", code)
+	s.Require().Error(err)
+	return err.Error()
+}
+
+func (s *luaTestSuite) TestLibraries() {
+	s.Equal([]lua.LValue{lua.LNumber(3), lua.LString("x=1.50 HELLO"), lua.LString("a,b"), lua.LNumber(4)}, s.eval(`
+		local t = {"a"}
+		table.insert(t, "b")
+		return math.max(1, 3, 2), string.format("x=%.2f %s", 1.5, ("hello"):upper()), table.concat(t, ","), math.sqrt(16)`))
+
+	// Functions that load code or access files are not available
+	s.Equal([]lua.LValue{lua.LString("nil"), lua.LString("nil"), lua.LString("nil"), lua.LString("nil"), lua.LString("nil")},
+		s.eval(`return type(io), type(os), type(dofile), type(require), type(loadstring)`))
+}
+
+func (s *luaTestSuite) TestErrors() {
+	s.Equal("test:3: custom", s.evalError("\n\nerror('custom')"))
+	s.Equal("no position", s.evalError("error('no position', 0)"))
+	s.Contains(s.evalError("if true then"), "test")
+	s.Equal([]lua.LValue{lua.LFalse, lua.LString("test:1: boom")}, s.eval(`return pcall(function() error("boom") end)`))
+}
+
+func (s *luaTestSuite) TestPrint() {
+	state := NewState()
+	defer state.Close()
+	var printed []string
+	state.Print = func(message string) {
+		printed = append(printed, message)
+	}
+	s.NoError(state.Load("test", `print("a", 1, nil, {} ~= nil) print()`))
+	s.Equal([]string{"a\t1\tnil\ttrue", ""}, printed)
+}
+
+func (s *luaTestSuite) TestStepLimit() {
+	state := NewState()
+	defer state.Close()
+	state.MaxSteps = 100
+	s.NoError(state.Load("test", "function loop() while true do end end function short() return 1 end"))
+	_, err := state.CallGlobal("loop")
+	s.Error(err)
+	s.Contains(err.Error(), "execution limit of 100 steps exceeded")
+
+	// The counter is reset for every call, and the state remains usable
+	for i := 0; i < 200; i++ {
+		results, err := state.CallGlobal("short")
+		s.NoError(err)
+		s.Equal([]lua.LValue{lua.LNumber(1)}, results)
+	}
+
+	// The limit also applies to the code executed while loading the script
+	err = state.Load("test", "while true do end")
+	s.Error(err)
+	s.Contains(err.Error(), "execution limit of 100 steps exceeded")
+
+	// The script step has a finite limit by default
+	script, err := NewScript("test", "function process(s) while true do end end", DefaultFunction, DefaultMaxSteps)
+	s.Require().NoError(err)
+	defer script.Close()
+	_, err = script.Process(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"a"}})
+	s.Error(err)
+	s.Contains(err.Error(), fmt.Sprintf("execution limit of %v steps exceeded", DefaultMaxSteps))
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+func (s *luaTestSuite) TestScriptStep() {
+	script, err := NewScript("transform", `
+		count = 0
+		function process(sample)
+			count = count + 1
+			if sample.values.a < 0 then return false end
+			sample.values.sum = sample.values.a + sample.values.b
+			sample.values.b = nil
+			sample.tags.count = count
+			sample.tags.remove = nil
+			sample.time = sample.time + 1
+			return true
+		end`, DefaultFunction, 0)
+	s.Require().NoError(err)
+	proc := &Processor{Script: script}
+	out := new(collectingSink)
+	proc.SetSink(out)
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	start := time.Unix(1000, 0)
+	for i, values := range [][]bitflow.Value{{1, 2}, {-1, 2}, {3, 4}} {
+		sample := &bitflow.Sample{Values: values, Time: start.Add(time.Duration(i) * time.Second)}
+		sample.SetTag("host", "h1")
+		sample.SetTag("remove", "x")
+		s.NoError(proc.Sample(sample, header))
+	}
+
+	s.Len(out.samples, 2)
+	s.Equal([]string{"a", "sum"}, out.headers[0].Fields)
+	s.True(out.headers[0] == out.headers[1], "The output header should be reused")
+	s.Equal([]bitflow.Value{1, 3}, out.samples[0].Values)
+	s.Equal([]bitflow.Value{3, 7}, out.samples[1].Values)
+	s.Equal(map[string]string{"host": "h1", "count": "3"}, out.samples[1].TagMap())
+	s.Equal(start.Add(3*time.Second), out.samples[1].Time)
+	proc.Close()
+}
+
+func (s *luaTestSuite) TestNewFieldsOrder() {
+	script, err := NewScript("test", `function process(s) s.values.z = 1; s.values.y = 2; s.values.x = 3 end`, DefaultFunction, 0)
+	s.Require().NoError(err)
+	defer script.Close()
+	sample := &bitflow.Sample{Values: []bitflow.Value{0}}
+	outHeader, err := script.Process(sample, &bitflow.Header{Fields: []string{"a"}})
+	s.NoError(err)
+	s.Equal([]string{"a", "z", "y", "x"}, outHeader.Fields)
+	s.Equal([]bitflow.Value{0, 1, 2, 3}, sample.Values)
+}
+
+func (s *luaTestSuite) TestScriptErrors() {
+	_, err := NewScript("test", "x = 1", DefaultFunction, 0)
+	s.EqualError(err, "Script test does not define the function 'process'")
+
+	script, err := NewScript("test", "function process(s) s.values.a = 'x' end", DefaultFunction, 0)
+	s.Require().NoError(err)
+	_, err = script.Process(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"a"}})
+	s.EqualError(err, "test: Invalid value for field a (number expected, got string)")
+
+	script, err = NewScript("test", "function process(s) return s.values.b.c end", DefaultFunction, 0)
+	s.Require().NoError(err)
+	_, err = script.Process(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"a"}})
+	s.Error(err)
+	s.Contains(err.Error(), "test: test:1: attempt to index")
+}
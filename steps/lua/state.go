@@ -0,0 +1,145 @@
+// Package lua embeds the gopher-lua interpreter (Lua 5.1) into bitflow to implement custom sample transformations
+// in scripts, without writing and registering processing steps in Go.
+//
+// Scripts run in a sandbox: only the base, string, table and math libraries are available, and the functions
+// that load code or access files are removed. The print function writes to the log instead of the standard output,
+// which might carry samples. The number of instructions executed per call can be limited.
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// DefaultMaxSteps limits the number of Lua instructions executed for one sample.
+const DefaultMaxSteps = 1000000
+
+// Global functions of the base library that load code or access files
+var removedGlobals = []string{"dofile", "loadfile", "load", "loadstring", "require", "module"}
+
+// State is a sandboxed Lua environment. Global variables keep their values between calls. A State must not be used concurrently.
+type State struct {
+	*lua.LState
+
+	// MaxSteps limits the number of instructions executed by one invocation of Load() or Call(). Zero disables the limit.
+	MaxSteps int
+
+	// Print receives the output of the Lua print function. By default, it is logged with level info.
+	Print func(message string)
+
+	steps int
+}
+
+// NewState creates a Lua environment with the base, string, table and math libraries.
+func NewState() *State {
+	s := &State{LState: lua.NewState(lua.Options{SkipOpenLibs: true}), Print: logPrint}
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		s.Push(s.NewFunction(lib.open))
+		s.Push(lua.LString(lib.name))
+		s.LState.Call(1, 0)
+	}
+	for _, name := range removedGlobals {
+		s.SetGlobal(name, lua.LNil)
+	}
+	s.SetGlobal("print", s.NewFunction(s.print))
+	s.SetContext(&stepLimit{Context: context.Background(), state: s})
+	return s
+}
+
+// Load compiles and executes a chunk of Lua code. Global variables and functions defined by the chunk
+// remain available for subsequent calls.
+func (s *State) Load(chunk string, code string) error {
+	fn, err := s.LState.Load(strings.NewReader(code), chunk)
+	if err != nil {
+		return err
+	}
+	_, err = s.Call(fn)
+	return err
+}
+
+// Call invokes a function in protected mode and returns its results. Lua errors are returned without the stack trace.
+func (s *State) Call(fn lua.LValue, args ...lua.LValue) ([]lua.LValue, error) {
+	s.steps = 0
+	top := s.GetTop()
+	if err := s.CallByParam(lua.P{Fn: fn, NRet: lua.MultRet, Protect: true}, args...); err != nil {
+		if apiErr, ok := err.(*lua.ApiError); ok && apiErr.Object != nil {
+			err = errors.New(apiErr.Object.String())
+		}
+		return nil, err
+	}
+	results := make([]lua.LValue, s.GetTop()-top)
+	for i := range results {
+		results[i] = s.Get(top + i + 1)
+	}
+	s.SetTop(top)
+	return results, nil
+}
+
+func logPrint(message string) {
+	log.Infoln(message)
+}
+
+// print replaces the print function of the base library, which writes to the standard output.
+func (s *State) print(l *lua.LState) int {
+	parts := make([]string, l.GetTop())
+	for i := range parts {
+		parts[i] = l.ToStringMeta(l.Get(i + 1)).String()
+	}
+	s.Print(strings.Join(parts, "\t"))
+	return 0
+}
+
+// CallGlobal invokes the global function with the given name.
+func (s *State) CallGlobal(name string, args ...lua.LValue) ([]lua.LValue, error) {
+	fn, ok := s.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		return nil, fmt.Errorf("Global function '%v' is not defined", name)
+	}
+	return s.Call(fn, args...)
+}
+
+// stepLimit is the context of a State. The gopher-lua VM polls the Done() channel of the context before every
+// instruction, so the context counts these calls and is cancelled when the MaxSteps of the State are exceeded.
+type stepLimit struct {
+	context.Context
+	state *State
+}
+
+var closedChannel = make(chan struct{})
+
+func init() {
+	close(closedChannel)
+}
+
+func (l *stepLimit) exceeded() bool {
+	return l.state.MaxSteps > 0 && l.state.steps > l.state.MaxSteps
+}
+
+func (l *stepLimit) Done() <-chan struct{} {
+	l.state.steps++
+	if l.exceeded() {
+		return closedChannel
+	}
+	return nil
+}
+
+func (l *stepLimit) Err() error {
+	if l.exceeded() {
+		return fmt.Errorf("execution limit of %v steps exceeded", l.state.MaxSteps)
+	}
+	return nil
+}
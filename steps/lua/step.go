@@ -0,0 +1,261 @@
+package lua
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const DefaultFunction = "process"
+
+// Script calls a Lua function for every sample. The function receives a table with the following fields, which
+// it can modify in place:
+//
+//	values: maps the header fields to the sample values. Setting a value to nil removes the field, new keys add fields.
+//	tags: maps tag names to tag values.
+//	time: the timestamp of the sample in seconds since the Unix epoch.
+//	fields: the list of header fields, in order (read-only).
+//
+// If the function returns false, the sample is dropped.
+type Script struct {
+	Description string
+	Function    string
+
+	state     *State
+	inHeader  *bitflow.Header
+	outHeader *bitflow.Header
+	fields    *lua.LTable
+}
+
+// NewScript executes the given Lua code, which must define the function that is called for every sample.
+// Global variables defined by the script keep their values between samples. Zero maxSteps disables the execution limit.
+func NewScript(chunk string, code string, function string, maxSteps int) (*Script, error) {
+	state := NewState()
+	state.MaxSteps = maxSteps
+	state.Print = func(message string) {
+		log.Infof("%v: %v", chunk, message)
+	}
+	if err := state.Load(chunk, code); err != nil {
+		state.Close()
+		return nil, err
+	}
+	if _, ok := state.GetGlobal(function).(*lua.LFunction); !ok {
+		state.Close()
+		return nil, fmt.Errorf("Script %v does not define the function '%v'", chunk, function)
+	}
+	return &Script{Description: chunk, Function: function, state: state}, nil
+}
+
+func (s *Script) String() string {
+	return s.Description
+}
+
+// Close releases the Lua state of the script.
+func (s *Script) Close() {
+	s.state.Close()
+}
+
+// Process calls the script function for the given sample and modifies the sample accordingly. The result is the
+// header of the modified sample, or nil if the sample should be dropped.
+func (s *Script) Process(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Header, error) {
+	if s.inHeader != header {
+		s.inHeader = header
+		s.outHeader = header
+		s.fields = s.state.NewTable()
+		for _, field := range header.Fields {
+			s.fields.Append(lua.LString(field))
+		}
+	}
+
+	values := s.state.NewTable()
+	for i, field := range header.Fields {
+		values.RawSetString(field, lua.LNumber(sample.Values[i]))
+	}
+	tags := s.state.NewTable()
+	for key, value := range sample.TagMap() {
+		tags.RawSetString(key, lua.LString(value))
+	}
+	timestamp := lua.LNumber(float64(sample.Time.UnixNano()) / 1e9)
+	table := s.state.NewTable()
+	table.RawSetString("values", values)
+	table.RawSetString("tags", tags)
+	table.RawSetString("time", timestamp)
+	table.RawSetString("fields", s.fields)
+
+	results, err := s.state.CallGlobal(s.Function, table)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", s, err)
+	}
+	if len(results) > 0 && results[0] == lua.LFalse {
+		return nil, nil
+	}
+
+	if err := s.updateTags(sample, table.RawGetString("tags")); err != nil {
+		return nil, err
+	}
+	if newTime := table.RawGetString("time"); newTime != timestamp {
+		t, ok := newTime.(lua.LNumber)
+		if !ok {
+			return nil, fmt.Errorf("%v: Invalid sample time (number expected, got %v)", s, newTime.Type())
+		}
+		sample.Time = time.Unix(0, int64(float64(t)*1e9))
+	}
+	return s.updateValues(sample, header, table.RawGetString("values"))
+}
+
+func (s *Script) updateTags(sample *bitflow.Sample, tagsValue lua.LValue) error {
+	tags, ok := tagsValue.(*lua.LTable)
+	if !ok {
+		return fmt.Errorf("%v: Invalid sample tags (table expected, got %v)", s, tagsValue.Type())
+	}
+	for key := range sample.TagMap() {
+		if tags.RawGetString(key) == lua.LNil {
+			sample.DeleteTag(key)
+		}
+	}
+	for key, value := tags.Next(lua.LNil); key != lua.LNil; key, value = tags.Next(key) {
+		if value.Type() == lua.LTTable {
+			return fmt.Errorf("%v: Invalid value for tag %v: %v", s, key, value.Type())
+		}
+		sample.SetTag(key.String(), value.String())
+	}
+	return nil
+}
+
+func (s *Script) updateValues(sample *bitflow.Sample, header *bitflow.Header, valuesValue lua.LValue) (*bitflow.Header, error) {
+	values, ok := valuesValue.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("%v: Invalid sample values (table expected, got %v)", s, valuesValue.Type())
+	}
+	number := func(key string, v lua.LValue) (bitflow.Value, error) {
+		f, ok := v.(lua.LNumber)
+		if !ok {
+			return 0, fmt.Errorf("%v: Invalid value for field %v (number expected, got %v)", s, key, v.Type())
+		}
+		return bitflow.Value(f), nil
+	}
+
+	outValues := make([]bitflow.Value, 0, len(header.Fields))
+	outFields := make([]string, 0, len(header.Fields))
+	known := make(map[string]bool, len(header.Fields))
+	for _, field := range header.Fields {
+		known[field] = true
+		if v := values.RawGetString(field); v != lua.LNil {
+			f, err := number(field, v)
+			if err != nil {
+				return nil, err
+			}
+			outValues = append(outValues, f)
+			outFields = append(outFields, field)
+		}
+	}
+	// New fields are appended in the order in which they were added to the table
+	for key, value := values.Next(lua.LNil); key != lua.LNil; key, value = values.Next(key) {
+		field, isString := key.(lua.LString)
+		if !isString {
+			return nil, fmt.Errorf("%v: Invalid field name %v (string expected, got %v)", s, key, key.Type())
+		}
+		if known[string(field)] {
+			continue
+		}
+		f, err := number(string(field), value)
+		if err != nil {
+			return nil, err
+		}
+		outValues = append(outValues, f)
+		outFields = append(outFields, string(field))
+	}
+	sample.Values = outValues
+
+	if !stringsEqual(outFields, s.outHeader.Fields) {
+		s.outHeader = header.Clone(outFields)
+	}
+	return s.outHeader, nil
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Processor applies a Script to every incoming sample.
+type Processor struct {
+	bitflow.NoopProcessor
+	*Script
+}
+
+func (p *Processor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	outHeader, err := p.Process(sample, header)
+	if err != nil || outHeader == nil {
+		return err
+	}
+	return p.NoopProcessor.Sample(sample, outHeader)
+}
+
+func (p *Processor) Close() {
+	p.Script.Close()
+	p.NoopProcessor.Close()
+}
+
+func (p *Processor) String() string {
+	return "Lua script " + p.Script.String()
+}
+
+func RegisterScriptStep(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("script_step",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			var err error
+			lang := reg.StrParam(params, "lang", "lua", true, &err)
+			file := reg.StrParam(params, "file", "", true, &err)
+			code := reg.StrParam(params, "script", "", true, &err)
+			function := reg.StrParam(params, "function", DefaultFunction, true, &err)
+			maxSteps := reg.IntParam(params, "max-steps", DefaultMaxSteps, true, &err)
+			if err != nil {
+				return err
+			}
+			if lang != "lua" {
+				return reg.ParameterError("lang", fmt.Errorf("Unsupported scripting language '%v', only 'lua' is available", lang))
+			}
+			if maxSteps < 0 {
+				return reg.ParameterError("max-steps", fmt.Errorf("Must not be negative"))
+			}
+			if (file == "") == (code == "") {
+				return fmt.Errorf("Exactly one of the parameters 'file' and 'script' must be defined")
+			}
+			chunk := "script"
+			if file != "" {
+				content, err := ioutil.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				chunk, code = file, string(content)
+			}
+			script, err := NewScript(chunk, code, function, maxSteps)
+			if err == nil {
+				p.Add(&Processor{Script: script})
+			}
+			return err
+		},
+		"Call a function of an embedded Lua script for every sample. The function receives a table with the fields "+
+			"'values' (field name -> value), 'tags' (tag name -> value), 'time' (seconds since the epoch) and 'fields' (list of header fields), "+
+			"and can modify the values, tags and time in place. Setting a value to nil removes the field, new keys add fields. "+
+			"Returning false drops the sample. Global variables keep their values between samples.",
+		reg.OptionalParams("lang", "file", "script", "function", "max-steps"),
+		reg.ParamDetails("lang", reg.TypeString, "lua", "Scripting language, only 'lua' is supported"),
+		reg.ParamDetails("file", reg.TypeString, "", "Path to the script file"),
+		reg.ParamDetails("script", reg.TypeString, "", "Inline script code, as an alternative to 'file'"),
+		reg.ParamDetails("function", reg.TypeString, DefaultFunction, "Name of the global function that is called for every sample"),
+		reg.ParamDetails("max-steps", reg.TypeInt, fmt.Sprint(DefaultMaxSteps), "Maximum number of executed Lua instructions per sample (0 means unlimited)"))
+}
@@ -3,7 +3,9 @@ package math
 import (
 	"container/list"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/antongulenko/golib"
@@ -222,6 +224,46 @@ func RegisterAggregateSlope(b reg.ProcessorRegistry) {
 		"Add a slope metric for every incoming metric. Optional parameter: duration or number of samples", reg.OptionalParams("window"))
 }
 
+// WindowFeatures contains the operations supported by the window_features step, along with the suffixes of the resulting metrics.
+var WindowFeatures = map[string]FeatureAggregatorOperation{
+	"mean":     FeatureWindowAverage,
+	"stddev":   FeatureWindowStddev,
+	"min":      FeatureWindowMin,
+	"max":      FeatureWindowMax,
+	"slope":    FeatureWindowSlope,
+	"kurtosis": FeatureWindowKurtosis,
+	"energy":   FeatureWindowEnergy,
+}
+
+// DefaultWindowFeatures defines the features and their order, if the features parameter is not given.
+var DefaultWindowFeatures = []string{"mean", "stddev", "min", "max", "slope", "kurtosis", "energy"}
+
+func RegisterWindowFeatures(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("window_features",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			agg, err := create_aggregator(params)
+			if err != nil {
+				return err
+			}
+			features := DefaultWindowFeatures
+			if featuresParam := params["features"]; featuresParam != "" {
+				features = strings.Split(featuresParam, ",")
+			}
+			for _, feature := range features {
+				operation, ok := WindowFeatures[feature]
+				if !ok {
+					return reg.ParameterError("features", fmt.Errorf("Unknown feature '%v', supported are: %v", feature, strings.Join(DefaultWindowFeatures, ", ")))
+				}
+				agg.Add("_"+feature, operation)
+			}
+			p.Add(agg)
+			return nil
+		},
+		"For every incoming metric, add the given features (comma-separated, default: "+strings.Join(DefaultWindowFeatures, ",")+"), computed over a sliding window, as new metrics with the feature name as suffix. "+
+			"The energy is the sum of squared values, the kurtosis is the excess kurtosis. Optional parameter: window duration or number of samples",
+		reg.OptionalParams("window", "features"))
+}
+
 func create_aggregator(params map[string]string) (*FeatureAggregator, error) {
 	window, haveWindow := params["window"]
 	if !haveWindow {
@@ -237,3 +279,66 @@ func create_aggregator(params map[string]string) (*FeatureAggregator, error) {
 	}
 	return nil, reg.ParameterError("window", golib.MultiError{err1, err2})
 }
+
+func FeatureWindowStddev(stats *FeatureWindowStats) bitflow.Value {
+	if stats.num <= 1 {
+		return 0
+	}
+	return bitflow.Value(math.Sqrt(stats.centralMoment(2)))
+}
+
+func FeatureWindowMin(stats *FeatureWindowStats) bitflow.Value {
+	if stats.num == 0 {
+		return 0
+	}
+	min := stats.values.Front().Value.(bitflow.Value)
+	for link := stats.values.Front(); link != nil; link = link.Next() {
+		if val := link.Value.(bitflow.Value); val < min {
+			min = val
+		}
+	}
+	return min
+}
+
+func FeatureWindowMax(stats *FeatureWindowStats) bitflow.Value {
+	if stats.num == 0 {
+		return 0
+	}
+	max := stats.values.Front().Value.(bitflow.Value)
+	for link := stats.values.Front(); link != nil; link = link.Next() {
+		if val := link.Value.(bitflow.Value); val > max {
+			max = val
+		}
+	}
+	return max
+}
+
+// FeatureWindowKurtosis returns the excess kurtosis (0 for normally distributed values), or 0 if all values are equal.
+func FeatureWindowKurtosis(stats *FeatureWindowStats) bitflow.Value {
+	if stats.num <= 1 {
+		return 0
+	}
+	variance := stats.centralMoment(2)
+	if variance == 0 {
+		return 0
+	}
+	return bitflow.Value(stats.centralMoment(4)/(variance*variance) - 3)
+}
+
+func FeatureWindowEnergy(stats *FeatureWindowStats) bitflow.Value {
+	var energy bitflow.Value
+	for link := stats.values.Front(); link != nil; link = link.Next() {
+		val := link.Value.(bitflow.Value)
+		energy += val * val
+	}
+	return energy
+}
+
+func (stats *FeatureWindowStats) centralMoment(order float64) float64 {
+	mean := float64(FeatureWindowAverage(stats))
+	var sum float64
+	for link := stats.values.Front(); link != nil; link = link.Next() {
+		sum += math.Pow(float64(link.Value.(bitflow.Value))-mean, order)
+	}
+	return sum / float64(stats.num)
+}
@@ -0,0 +1,83 @@
+package math
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type aggregateTestSuite struct {
+	testsupport.Suite
+}
+
+func TestAggregate(t *testing.T) {
+	suite.Run(t, new(aggregateTestSuite))
+}
+
+var aggregateTestValues = []bitflow.Value{2, 4, 4, 4, 5, 5, 7, 9}
+
+func (s *aggregateTestSuite) windowFeatures(params map[string]string) bitflow.SampleProcessor {
+	b := reg.NewProcessorRegistry()
+	RegisterWindowFeatures(b)
+	analysis, ok := b.GetAnalysis("window_features")
+	s.True(ok)
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, params))
+	s.Len(pipe.Processors, 1)
+	return pipe.Processors[0]
+}
+
+func (s *aggregateTestSuite) samples() []*bitflow.Sample {
+	res := make([]*bitflow.Sample, len(aggregateTestValues))
+	for i, val := range aggregateTestValues {
+		res[i] = testsupport.NewSample(0, "", val)
+	}
+	return res
+}
+
+func (s *aggregateTestSuite) TestAllFeatures() {
+	out := s.Process(s.windowFeatures(map[string]string{"window": "8"}), &bitflow.Header{Fields: []string{"a"}}, s.samples()...)
+	out.AssertFields(s.T(), "a", "a_mean", "a_stddev", "a_min", "a_max", "a_slope", "a_kurtosis", "a_energy")
+	values := out.Values()
+	s.Equal([]float64{2, 2, 0, 2, 2, 0, 0, 4}, values[0], "A single value has no variance")
+	// Population moments of all values: mean 5, stddev 2, fourth central moment 44.5
+	s.InDeltaSlice([]float64{9, 5, 2, 2, 9, 7, 44.5/16 - 3, 232}, values[7], 1e-12)
+}
+
+func (s *aggregateTestSuite) TestSlidingWindow() {
+	out := s.Process(s.windowFeatures(map[string]string{"window": "4", "features": "stddev,kurtosis,min,max"}), &bitflow.Header{Fields: []string{"a"}}, s.samples()...)
+	out.AssertFields(s.T(), "a", "a_stddev", "a_kurtosis", "a_min", "a_max")
+	values := out.Values()
+	// Window 2, 4, 4, 4: mean 3.5, variance 0.75, fourth central moment 1.3125
+	s.InDeltaSlice([]float64{4, math.Sqrt(0.75), 1.3125/0.5625 - 3, 2, 4}, values[3], 1e-12)
+	// Window 4, 4, 4, 5 after the first value left the window
+	s.Equal([]float64{4, 5}, values[4][3:])
+	// Window 5, 5, 7, 9
+	s.Equal([]float64{5, 9}, values[7][3:])
+}
+
+func (s *aggregateTestSuite) TestEmptyWindow() {
+	for _, feature := range DefaultWindowFeatures {
+		s.Equal(bitflow.Value(0), WindowFeatures[feature](new(FeatureWindowStats)), "Feature %v", feature)
+	}
+}
+
+func (s *aggregateTestSuite) TestConstantValues() {
+	out := s.Process(s.windowFeatures(map[string]string{"features": "stddev,kurtosis"}), &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(0, "", 3),
+		testsupport.NewSample(0, "", 3))
+	out.AssertValues(s.T(), [][]float64{{3, 0, 0}, {3, 0, 0}})
+}
+
+func (s *aggregateTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterWindowFeatures(b)
+	analysis, _ := b.GetAnalysis("window_features")
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"features": "mean,median"}))
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"window": "soon"}))
+	s.Equal("Feature Aggregator [10s] [_min _energy]", s.windowFeatures(map[string]string{"window": "10s", "features": "min,energy"}).String())
+}
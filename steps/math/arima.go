@@ -0,0 +1,380 @@
+package math
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+)
+
+const (
+	ResidualSuffix           = "_residual"
+	DefaultArOrder           = 5
+	DefaultResidualThreshold = 3
+)
+
+func RegisterARIMA(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("arima",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &BatchARIMA{
+				P:      reg.IntParam(params, "p", DefaultArOrder, true, &err),
+				D:      reg.IntParam(params, "d", 0, true, &err),
+				Season: reg.IntParam(params, "season", 0, true, &err),
+			}
+			step.Forecaster = parseARIMAForecasterParams(params, &err)
+			file := reg.StrParam(params, "store", "", true, &err)
+			if err == nil {
+				err = step.validate()
+			}
+			if err == nil {
+				if file != "" {
+					step.Store = models.NewStore(file)
+				}
+				p.Batch(step)
+			}
+			return
+		},
+		"Fit an ARIMA(p,d,0) model (an autoregressive model of order p on the d times differenced values) to every metric in a batch of samples. "+
+			"The season parameter optionally applies an additional seasonal difference with the given lag (in samples) before fitting. "+
+			"For every metric, the one-step prediction (suffix "+PredictedSuffix+") and the residual (suffix "+ResidualSuffix+") are appended. "+
+			"If the tag parameter is given, samples where the absolute residual of any metric exceeds threshold times the residual standard deviation of the training data are tagged as '"+OutlierTagValue+"', all others as '"+InlierTagValue+"'. "+
+			"If the store parameter is given, the models are stored to that model location, to be used by arima_load_stream.",
+		reg.OptionalParams("p", "d", "season", "threshold", "tag", "store"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("arima_load_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := parseARIMAForecasterParams(params, &err)
+			if err == nil {
+				model := new(ARIMAModel)
+				if _, err = models.Load(params["file"], ArimaModelType, model); err == nil {
+					step.Model = model
+					p.Add(step)
+				}
+			}
+			return
+		},
+		"Load ARIMA models stored by the arima step and append the one-step prediction and residual of every modelled metric to every sample. "+
+			"The predictions are based on the previous samples, so the first samples (depending on the model orders) receive NaN predictions. "+
+			"The threshold and tag parameters are the same as for the arima step.",
+		reg.RequiredParams("file"), reg.OptionalParams("threshold", "tag"))
+}
+
+// ARModel is an autoregressive model of one metric, fitted to the differenced values of that metric.
+type ARModel struct {
+	Mean         float64
+	Coefficients []float64
+	Sigma        float64 // Standard deviation of the one-step prediction residuals in the training data
+}
+
+// ARIMAModel contains one ARModel for every metric. All metrics are differenced in the same way:
+// first with the seasonal lag (if Season > 0), then D times with lag 1.
+type ARIMAModel struct {
+	Fields  []string
+	P       int
+	D       int
+	Season  int
+	Metrics []ARModel
+}
+
+// HistorySize returns the number of previous values that are required for a prediction.
+func (m *ARIMAModel) HistorySize() int {
+	return m.P + m.D + m.Season
+}
+
+func (m *ARIMAModel) difference(values []float64) []float64 {
+	if m.Season > 0 {
+		values = differenceLag(values, m.Season)
+	}
+	for i := 0; i < m.D; i++ {
+		values = differenceLag(values, 1)
+	}
+	return values
+}
+
+func differenceLag(values []float64, lag int) []float64 {
+	if len(values) <= lag {
+		return nil
+	}
+	res := make([]float64, len(values)-lag)
+	for i := range res {
+		res[i] = values[i+lag] - values[i]
+	}
+	return res
+}
+
+// Fit estimates the autoregressive coefficients of every metric using the Yule-Walker equations, which are
+// solved with the Levinson-Durbin recursion.
+func (m *ARIMAModel) Fit(header *bitflow.Header, samples []*bitflow.Sample) error {
+	m.Fields = header.Fields
+	m.Metrics = make([]ARModel, len(header.Fields))
+	values := make([]float64, len(samples))
+	for i := range header.Fields {
+		for j, sample := range samples {
+			values[j] = float64(sample.Values[i])
+		}
+		diffs := m.difference(values)
+		if len(diffs) <= m.P {
+			return fmt.Errorf("Not enough samples for fitting %v: %v samples remain after differencing, but p is %v", m, len(diffs), m.P)
+		}
+		metric := &m.Metrics[i]
+		metric.fit(diffs, m.P)
+
+		var sumSquares float64
+		var num int
+		for t := m.P; t < len(diffs); t++ {
+			if residual := diffs[t] - metric.predict(diffs[:t]); !math.IsNaN(residual) {
+				sumSquares += residual * residual
+				num++
+			}
+		}
+		if num > 0 {
+			metric.Sigma = math.Sqrt(sumSquares / float64(num))
+		}
+	}
+	return nil
+}
+
+func (m *ARModel) fit(values []float64, order int) {
+	var sum float64
+	var num int
+	for _, val := range values {
+		if !math.IsNaN(val) {
+			sum += val
+			num++
+		}
+	}
+	if num > 0 {
+		m.Mean = sum / float64(num)
+	}
+	autocov := make([]float64, order+1)
+	for lag := range autocov {
+		for t := lag; t < len(values); t++ {
+			if prod := (values[t] - m.Mean) * (values[t-lag] - m.Mean); !math.IsNaN(prod) {
+				autocov[lag] += prod
+			}
+		}
+		autocov[lag] /= float64(len(values))
+	}
+	m.Coefficients = levinsonDurbin(autocov, order)
+}
+
+// levinsonDurbin solves the Yule-Walker equations for the given autocovariances. Constant series result in zero coefficients.
+func levinsonDurbin(autocov []float64, order int) []float64 {
+	phi := make([]float64, order)
+	variance := autocov[0]
+	prev := make([]float64, order)
+	for k := 0; k < order; k++ {
+		if variance <= 0 {
+			break
+		}
+		acc := autocov[k+1]
+		for j := 0; j < k; j++ {
+			acc -= phi[j] * autocov[k-j]
+		}
+		reflection := acc / variance
+		copy(prev, phi)
+		phi[k] = reflection
+		for j := 0; j < k; j++ {
+			phi[j] = prev[j] - reflection*prev[k-1-j]
+		}
+		variance *= 1 - reflection*reflection
+	}
+	return phi
+}
+
+// predict returns the prediction of the next value, based on the given previous (differenced) values.
+func (m *ARModel) predict(previous []float64) float64 {
+	if len(previous) < len(m.Coefficients) {
+		return math.NaN()
+	}
+	res := m.Mean
+	for i, coeff := range m.Coefficients {
+		res += coeff * (previous[len(previous)-1-i] - m.Mean)
+	}
+	return res
+}
+
+// Predict returns the prediction of the next value of the given metric, based on the previous values (in original,
+// undifferenced form). If the history is shorter than HistorySize(), NaN is returned.
+func (m *ARIMAModel) Predict(metric int, history []float64) float64 {
+	if len(history) < m.HistorySize() {
+		return math.NaN()
+	}
+	// Differencing is linear and the next value enters with a coefficient of 1. Differencing the history with
+	// an appended zero therefore yields the part of the differenced next value that depends only on the history.
+	values := make([]float64, m.HistorySize()+1)
+	copy(values, history[len(history)-m.HistorySize():])
+	diffs := m.difference(values)
+	last := len(diffs) - 1
+	return m.Metrics[metric].predict(diffs[:last]) - diffs[last]
+}
+
+func (m *ARIMAModel) String() string {
+	res := fmt.Sprintf("ARIMA(%v,%v,0)", m.P, m.D)
+	if m.Season > 0 {
+		res += fmt.Sprintf(" with seasonal difference %v", m.Season)
+	}
+	if len(m.Fields) > 0 {
+		res += fmt.Sprintf(" model of %v metrics", len(m.Fields))
+	}
+	return res
+}
+
+// ARIMAForecaster appends the prediction and the residual of every metric of an ARIMAModel to every sample.
+// The history of previous values is reset when the header changes.
+type ARIMAForecaster struct {
+	bitflow.NoopProcessor
+	Model     *ARIMAModel
+	Threshold float64 // In multiples of the residual standard deviation of the training data
+	Tag       string  // Optional
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	indices   []int
+	history   [][]float64
+}
+
+func parseARIMAForecasterParams(params map[string]string, err *error) *ARIMAForecaster {
+	res := &ARIMAForecaster{
+		Threshold: reg.FloatParam(params, "threshold", DefaultResidualThreshold, true, err),
+		Tag:       reg.StrParam(params, "tag", "", true, err),
+	}
+	if *err == nil && res.Threshold <= 0 {
+		*err = reg.ParameterError("threshold", fmt.Errorf("Must be positive"))
+	}
+	return res
+}
+
+func (f *ARIMAForecaster) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if f.checker.HeaderChanged(header) {
+		if err := f.headerChanged(header); err != nil {
+			return err
+		}
+	}
+	f.forecast(sample)
+	return f.NoopProcessor.Sample(sample, f.outHeader)
+}
+
+func (f *ARIMAForecaster) headerChanged(header *bitflow.Header) error {
+	fieldIndices := header.BuildIndex()
+	f.indices = make([]int, len(f.Model.Fields))
+	for i, field := range f.Model.Fields {
+		index, ok := fieldIndices[field]
+		if !ok {
+			return fmt.Errorf("%v: Metric %v is missing", f, field)
+		}
+		f.indices[i] = index
+	}
+	f.history = make([][]float64, len(f.Model.Fields))
+	fields := make([]string, len(header.Fields), len(header.Fields)+2*len(f.Model.Fields))
+	copy(fields, header.Fields)
+	for _, field := range f.Model.Fields {
+		fields = append(fields, field+PredictedSuffix, field+ResidualSuffix)
+	}
+	f.outHeader = header.Clone(fields)
+	return nil
+}
+
+func (f *ARIMAForecaster) forecast(sample *bitflow.Sample) {
+	values := make([]float64, 0, 2*len(f.indices))
+	anomaly := false
+	historySize := f.Model.HistorySize()
+	for i, index := range f.indices {
+		value := float64(sample.Values[index])
+		prediction := f.Model.Predict(i, f.history[i])
+		residual := value - prediction
+		values = append(values, prediction, residual)
+		if sigma := f.Model.Metrics[i].Sigma; sigma > 0 && math.Abs(residual) > f.Threshold*sigma {
+			anomaly = true
+		}
+
+		if historySize > 0 {
+			if len(f.history[i]) >= historySize {
+				f.history[i] = append(f.history[i][:0], f.history[i][1:]...)
+			}
+			f.history[i] = append(f.history[i], value)
+		}
+	}
+	steps.AppendToSample(sample, values)
+	if f.Tag != "" {
+		if anomaly {
+			sample.SetTag(f.Tag, OutlierTagValue)
+		} else {
+			sample.SetTag(f.Tag, InlierTagValue)
+		}
+	}
+}
+
+func (f *ARIMAForecaster) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 2*len(f.Model.Fields)
+}
+
+func (f *ARIMAForecaster) paramString() string {
+	if f.Tag == "" {
+		return ""
+	}
+	return fmt.Sprintf(", tag %v if residual > %v sigma", f.Tag, f.Threshold)
+}
+
+func (f *ARIMAForecaster) String() string {
+	return fmt.Sprintf("ARIMA forecast (%v%v)", f.Model, f.paramString())
+}
+
+// BatchARIMA fits an ARIMAModel to a batch of samples and appends the in-sample one-step predictions and residuals.
+type BatchARIMA struct {
+	P          int
+	D          int
+	Season     int
+	Forecaster *ARIMAForecaster
+	Store      *models.Store // Optional
+}
+
+func (s *BatchARIMA) validate() error {
+	switch {
+	case s.P < 0:
+		return reg.ParameterError("p", fmt.Errorf("Must not be negative"))
+	case s.D < 0:
+		return reg.ParameterError("d", fmt.Errorf("Must not be negative"))
+	case s.Season < 0:
+		return reg.ParameterError("season", fmt.Errorf("Must not be negative"))
+	}
+	return nil
+}
+
+func (s *BatchARIMA) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	model := &ARIMAModel{P: s.P, D: s.D, Season: s.Season}
+	if err := model.Fit(header, samples); err != nil {
+		return nil, nil, err
+	}
+	if s.Store != nil {
+		if err := storeModel(s.Store, ArimaModelType, header.Fields, model); err != nil {
+			return nil, nil, err
+		}
+	}
+	forecaster := &ARIMAForecaster{Model: model, Threshold: s.Forecaster.Threshold, Tag: s.Forecaster.Tag}
+	if err := forecaster.headerChanged(header); err != nil {
+		return nil, nil, err
+	}
+	for _, sample := range samples {
+		forecaster.forecast(sample)
+	}
+	return forecaster.outHeader, samples, nil
+}
+
+func (s *BatchARIMA) String() string {
+	res := fmt.Sprintf("ARIMA(%v,%v,0)", s.P, s.D)
+	if s.Season > 0 {
+		res += fmt.Sprintf(" with seasonal difference %v", s.Season)
+	}
+	res += s.Forecaster.paramString()
+	if s.Store != nil {
+		res += fmt.Sprintf(" (store model to %v)", s.Store)
+	}
+	return res
+}
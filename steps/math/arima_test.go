@@ -0,0 +1,127 @@
+package math
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type arimaTestSuite struct {
+	testsupport.Suite
+}
+
+func TestARIMA(t *testing.T) {
+	suite.Run(t, new(arimaTestSuite))
+}
+
+// The reference values in these tests were computed independently by solving the Yule-Walker equations
+// (biased autocovariances of the demeaned series) with exact rational arithmetic and Gaussian elimination.
+
+const arimaDelta = 1e-9
+
+func (s *arimaTestSuite) fit(model *ARIMAModel, values ...float64) *ARIMAModel {
+	samples := make([]*bitflow.Sample, len(values))
+	for i, val := range values {
+		samples[i] = testsupport.NewSample(time.Duration(i)*time.Second, "", bitflow.Value(val))
+	}
+	s.NoError(model.Fit(&bitflow.Header{Fields: []string{"a"}}, samples))
+	s.Len(model.Metrics, 1)
+	return model
+}
+
+func (s *arimaTestSuite) TestAR1() {
+	values := []float64{2, 4, 3, 5, 4, 6, 5, 7, 6, 8}
+	model := s.fit(&ARIMAModel{P: 1}, values...)
+	metric := model.Metrics[0]
+	s.InDelta(5, metric.Mean, arimaDelta)
+	s.Len(metric.Coefficients, 1)
+	s.InDelta(0.3, metric.Coefficients[0], arimaDelta)
+	s.InDelta(1.3940349110884322, metric.Sigma, arimaDelta)
+	s.InDelta(5.9, model.Predict(0, values), arimaDelta)
+	s.True(math.IsNaN(model.Predict(0, nil)), "Predictions without history must be NaN")
+}
+
+func (s *arimaTestSuite) TestAR2() {
+	values := []float64{1, 2, 3, 2, 1, 2, 3, 2, 1, 2, 3, 2}
+	model := s.fit(&ARIMAModel{P: 2}, values...)
+	metric := model.Metrics[0]
+	s.InDelta(2, metric.Mean, arimaDelta)
+	s.InDelta(0, metric.Coefficients[0], arimaDelta)
+	s.InDelta(-5.0/6, metric.Coefficients[1], arimaDelta)
+	s.InDelta(7.0/6, model.Predict(0, values), arimaDelta)
+}
+
+func (s *arimaTestSuite) TestDifferencing() {
+	values := []float64{2, 4, 3, 5, 4, 6, 5, 7, 6, 8}
+	model := s.fit(&ARIMAModel{P: 1, D: 1}, values...)
+	metric := model.Metrics[0]
+	s.InDelta(2.0/3, metric.Mean, arimaDelta)
+	s.InDelta(-8.0/9, metric.Coefficients[0], arimaDelta)
+	s.InDelta(202.0/27, model.Predict(0, values), arimaDelta)
+	s.Equal(2, model.HistorySize())
+
+	// A linear trend has constant differences and is predicted exactly
+	model = s.fit(&ARIMAModel{P: 2, D: 1}, 1, 3, 5, 7, 9, 11)
+	s.Equal([]float64{0, 0}, model.Metrics[0].Coefficients, "Constant series must result in zero coefficients")
+	s.InDelta(13, model.Predict(0, []float64{5, 7, 9, 11}), arimaDelta)
+	s.Equal(0.0, model.Metrics[0].Sigma)
+}
+
+func (s *arimaTestSuite) TestSeasonalDifference() {
+	season := []float64{1, 5, 2, 8}
+	var values []float64
+	for i := 0; i < 4; i++ {
+		for _, val := range season {
+			values = append(values, val+float64(i)*10)
+		}
+	}
+	model := s.fit(&ARIMAModel{P: 1, Season: 4}, values...)
+	s.Equal(5, model.HistorySize())
+	s.InDelta(10, model.Metrics[0].Mean, arimaDelta)
+	s.InDelta(41, model.Predict(0, values), arimaDelta, "The seasonal pattern must be continued with the seasonal trend")
+}
+
+func (s *arimaTestSuite) TestNotEnoughSamples() {
+	samples := []*bitflow.Sample{testsupport.NewSample(0, "", 1), testsupport.NewSample(0, "", 2), testsupport.NewSample(0, "", 3)}
+	model := &ARIMAModel{P: 2, D: 1}
+	s.Error(model.Fit(&bitflow.Header{Fields: []string{"a"}}, samples))
+}
+
+func (s *arimaTestSuite) TestForecaster() {
+	model := s.fit(&ARIMAModel{P: 1}, 2, 4, 3, 5, 4, 6, 5, 7, 6, 8)
+	forecaster := &ARIMAForecaster{Model: model, Threshold: 3, Tag: "anomaly"}
+	out := s.Process(forecaster, &bitflow.Header{Fields: []string{"x", "a"}},
+		testsupport.NewSample(0, "", 100, 4),
+		testsupport.NewSample(time.Second, "", 100, 6),
+		testsupport.NewSample(2*time.Second, "", 100, 20))
+	out.AssertFields(s.T(), "x", "a", "a"+PredictedSuffix, "a"+ResidualSuffix)
+	values := out.Values()
+	s.True(math.IsNaN(values[0][2]) && math.IsNaN(values[0][3]), "The first sample has no history")
+	s.InDelta(4.7, values[1][2], arimaDelta)
+	s.InDelta(1.3, values[1][3], arimaDelta)
+	s.InDelta(5.3, values[2][2], arimaDelta)
+	s.InDelta(14.7, values[2][3], arimaDelta)
+	s.Equal([]string{InlierTagValue, InlierTagValue, OutlierTagValue}, out.Tags("anomaly"))
+
+	forecaster.SetSink(new(bitflow.DroppingSampleProcessor))
+	s.Error(forecaster.Sample(testsupport.NewSample(0, "", 1), &bitflow.Header{Fields: []string{"b"}}), "Missing metrics must be reported")
+}
+
+func (s *arimaTestSuite) TestBatch() {
+	step := &BatchARIMA{P: 1, Forecaster: &ARIMAForecaster{Threshold: 3}}
+	var samples []*bitflow.Sample
+	for i, val := range []bitflow.Value{2, 4, 3, 5, 4, 6, 5, 7, 6, 8} {
+		samples = append(samples, testsupport.NewSample(time.Duration(i)*time.Second, "", val))
+	}
+	header, out, err := step.ProcessBatch(&bitflow.Header{Fields: []string{"a"}}, samples)
+	s.NoError(err)
+	s.Equal([]string{"a", "a" + PredictedSuffix, "a" + ResidualSuffix}, header.Fields)
+	s.Len(out, 10)
+	// In-sample prediction of the second value: 5 + 0.3 * (2 - 5)
+	s.InDelta(4.1, float64(out[1].Values[1]), 1e-6)
+	s.InDelta(-0.1, float64(out[1].Values[2]), 1e-6)
+}
@@ -0,0 +1,273 @@
+package math
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CorrelationPearson  = "pearson"
+	CorrelationSpearman = "spearman"
+)
+
+func RegisterCorrelation(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("correlation",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &CorrelationFeatureSelection{
+				Method:         reg.StrParam(params, "method", CorrelationPearson, true, &err),
+				File:           reg.StrParam(params, "file", "", true, &err),
+				MaxCorrelation: reg.FloatParam(params, "max_correlation", 0, true, &err),
+				MinStddev:      reg.FloatParam(params, "min_stddev", 0, true, &err),
+			}
+			if err == nil && step.Method != CorrelationPearson && step.Method != CorrelationSpearman {
+				err = reg.ParameterError("method", fmt.Errorf("Must be %v or %v", CorrelationPearson, CorrelationSpearman))
+			}
+			if err == nil && (step.MaxCorrelation < 0 || step.MaxCorrelation > 1) {
+				err = reg.ParameterError("max_correlation", fmt.Errorf("Must be in [0..1]"))
+			}
+			if err == nil && step.MinStddev < 0 {
+				err = reg.ParameterError("min_stddev", fmt.Errorf("Must not be negative"))
+			}
+			if err == nil {
+				p.Batch(step)
+			}
+			return
+		},
+		"Compute the correlation matrix of all metrics in a batch of samples, using the "+CorrelationPearson+" (default) or "+CorrelationSpearman+" (rank) correlation coefficient. "+
+			"If the file parameter is given, the matrix is written to that file as CSV. "+
+			"Metrics with a standard deviation below min_stddev are dropped. If max_correlation is given, metrics are dropped until no pair of remaining metrics "+
+			"has an absolute correlation above that threshold. Metrics that are highly correlated with many other metrics are dropped first.",
+		reg.OptionalParams("method", "file", "max_correlation", "min_stddev"), reg.SupportBatch())
+}
+
+// CorrelationMatrix computes the correlation between all pairs of metrics. Metrics without variance have a correlation of NaN.
+func CorrelationMatrix(header *bitflow.Header, samples []*bitflow.Sample, method string) [][]float64 {
+	columns := make([][]float64, len(header.Fields))
+	for i := range columns {
+		columns[i] = make([]float64, len(samples))
+		for j, sample := range samples {
+			columns[i][j] = float64(sample.Values[i])
+		}
+		if method == CorrelationSpearman {
+			columns[i] = ranks(columns[i])
+		}
+	}
+	matrix := make([][]float64, len(columns))
+	for i := range matrix {
+		matrix[i] = make([]float64, len(columns))
+		for j := 0; j <= i; j++ {
+			matrix[i][j] = pearson(columns[i], columns[j])
+			matrix[j][i] = matrix[i][j]
+		}
+	}
+	return matrix
+}
+
+func pearson(a, b []float64) float64 {
+	n := float64(len(a))
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i]
+		meanB += b[i]
+	}
+	meanA /= n
+	meanB /= n
+	var cov, varA, varB float64
+	for i := range a {
+		diffA, diffB := a[i]-meanA, b[i]-meanB
+		cov += diffA * diffB
+		varA += diffA * diffA
+		varB += diffB * diffB
+	}
+	if varA == 0 || varB == 0 {
+		return math.NaN()
+	}
+	return cov / math.Sqrt(varA*varB)
+}
+
+// ranks returns the rank of every value, starting at 1. Tied values receive the average of their ranks.
+func ranks(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return values[order[i]] < values[order[j]]
+	})
+	res := make([]float64, len(values))
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && values[order[end]] == values[order[start]] {
+			end++
+		}
+		rank := float64(start+end+1) / 2
+		for _, index := range order[start:end] {
+			res[index] = rank
+		}
+		start = end
+	}
+	return res
+}
+
+// WriteCorrelationMatrix writes the matrix as CSV, with the metric names as first row and first column.
+func WriteCorrelationMatrix(writer io.Writer, fields []string, matrix [][]float64) error {
+	buf := bufio.NewWriter(writer)
+	buf.WriteString("metric," + strings.Join(fields, ",") + "\n")
+	for i, row := range matrix {
+		buf.WriteString(fields[i])
+		for _, val := range row {
+			buf.WriteString("," + strconv.FormatFloat(val, 'g', -1, 64))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Flush()
+}
+
+// CorrelationFeatureSelection computes the correlation matrix of a batch and optionally drops metrics with
+// a low standard deviation, or metrics that are highly correlated with other metrics.
+type CorrelationFeatureSelection struct {
+	Method         string
+	File           string  // Optional
+	MaxCorrelation float64 // Disabled if zero
+	MinStddev      float64 // Disabled if zero
+
+	counter int
+}
+
+func (c *CorrelationFeatureSelection) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	matrix := CorrelationMatrix(header, samples, c.Method)
+	if c.File != "" {
+		if err := c.writeMatrix(header.Fields, matrix); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.MaxCorrelation == 0 && c.MinStddev == 0 {
+		return header, samples, nil
+	}
+
+	dropped := make([]bool, len(header.Fields))
+	c.dropLowStddev(header, samples, dropped)
+	c.dropCorrelated(header, matrix, dropped)
+	indices := make([]int, 0, len(header.Fields))
+	fields := make([]string, 0, len(header.Fields))
+	for i, field := range header.Fields {
+		if !dropped[i] {
+			indices = append(indices, i)
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == len(header.Fields) {
+		return header, samples, nil
+	}
+	log.Printf("%v: Keeping %v of %v metrics", c, len(fields), len(header.Fields))
+	for _, sample := range samples {
+		values := make([]bitflow.Value, len(indices))
+		for i, index := range indices {
+			values[i] = sample.Values[index]
+		}
+		sample.Values = values
+	}
+	return header.Clone(fields), samples, nil
+}
+
+func (c *CorrelationFeatureSelection) dropLowStddev(header *bitflow.Header, samples []*bitflow.Sample, dropped []bool) {
+	if c.MinStddev == 0 {
+		return
+	}
+	for i, field := range header.Fields {
+		var sum, sumSquares float64
+		for _, sample := range samples {
+			val := float64(sample.Values[i])
+			sum += val
+			sumSquares += val * val
+		}
+		n := float64(len(samples))
+		variance := sumSquares/n - (sum/n)*(sum/n)
+		if stddev := math.Sqrt(math.Max(0, variance)); stddev < c.MinStddev {
+			dropped[i] = true
+			log.Printf("%v: Dropping metric %v (stddev %v)", c, field, stddev)
+		}
+	}
+}
+
+// dropCorrelated repeatedly finds the remaining pair with the highest absolute correlation above the threshold,
+// and drops the metric of that pair that has the higher mean absolute correlation with all remaining metrics.
+func (c *CorrelationFeatureSelection) dropCorrelated(header *bitflow.Header, matrix [][]float64, dropped []bool) {
+	if c.MaxCorrelation == 0 {
+		return
+	}
+	meanCorrelation := func(i int) float64 {
+		var sum float64
+		var num int
+		for j, row := range matrix {
+			if !dropped[j] && j != i && !math.IsNaN(row[i]) {
+				sum += math.Abs(row[i])
+				num++
+			}
+		}
+		if num == 0 {
+			return 0
+		}
+		return sum / float64(num)
+	}
+	for {
+		maxI, maxJ, maxCorr := -1, -1, c.MaxCorrelation
+		for i, row := range matrix {
+			for j := i + 1; j < len(row); j++ {
+				if !dropped[i] && !dropped[j] && math.Abs(row[j]) > maxCorr {
+					maxI, maxJ, maxCorr = i, j, math.Abs(row[j])
+				}
+			}
+		}
+		if maxI < 0 {
+			return
+		}
+		drop, keep := maxJ, maxI
+		if meanCorrelation(maxI) > meanCorrelation(maxJ) {
+			drop, keep = maxI, maxJ
+		}
+		dropped[drop] = true
+		log.Printf("%v: Dropping metric %v (correlation %.3f with %v)", c, header.Fields[drop], maxCorr, header.Fields[keep])
+	}
+}
+
+func (c *CorrelationFeatureSelection) writeMatrix(fields []string, matrix [][]float64) error {
+	group := bitflow.NewFileGroup(c.File)
+	file, err := group.OpenNewFile(&c.counter)
+	if err != nil {
+		return err
+	}
+	log.Println("Writing correlation matrix to", file.Name())
+	err = WriteCorrelationMatrix(file, fields, matrix)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *CorrelationFeatureSelection) String() string {
+	res := fmt.Sprintf("Correlation matrix (%v", c.Method)
+	if c.MaxCorrelation > 0 {
+		res += fmt.Sprintf(", max correlation %v", c.MaxCorrelation)
+	}
+	if c.MinStddev > 0 {
+		res += fmt.Sprintf(", min stddev %v", c.MinStddev)
+	}
+	res += ")"
+	if c.File != "" {
+		res += " (write to " + c.File + ")"
+	}
+	return res
+}
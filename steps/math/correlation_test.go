@@ -0,0 +1,120 @@
+package math
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type correlationTestSuite struct {
+	testsupport.Suite
+}
+
+func TestCorrelation(t *testing.T) {
+	suite.Run(t, new(correlationTestSuite))
+}
+
+// columnSamples builds one sample for every row of the given metric columns
+func (s *correlationTestSuite) columnSamples(columns ...[]float64) []*bitflow.Sample {
+	samples := make([]*bitflow.Sample, len(columns[0]))
+	for i := range samples {
+		values := make([]bitflow.Value, len(columns))
+		for j, column := range columns {
+			values[j] = bitflow.Value(column[i])
+		}
+		samples[i] = &bitflow.Sample{Values: values}
+	}
+	return samples
+}
+
+var (
+	correlationX = []float64{1, 2, 3, 4}
+	correlationY = []float64{1, 3, 2, 4} // Correlation 0.8 with x
+	correlationZ = []float64{2, 1, 4, 3} // Correlation 0.6 with x, 0 with y
+)
+
+func (s *correlationTestSuite) TestPearson() {
+	header := &bitflow.Header{Fields: []string{"x", "double", "reverse", "y", "const"}}
+	matrix := CorrelationMatrix(header, s.columnSamples(correlationX, []float64{2, 4, 6, 8}, []float64{4, 3, 2, 1}, correlationY, []float64{5, 5, 5, 5}), CorrelationPearson)
+	s.Len(matrix, 5)
+	s.InDeltaSlice([]float64{1, 1, -1, 0.8}, matrix[0][:4], 1e-12)
+	s.InDelta(-0.8, matrix[2][3], 1e-12)
+	s.Equal(matrix[0][3], matrix[3][0], "The matrix must be symmetric")
+	for i := range matrix {
+		s.True(math.IsNaN(matrix[i][4]) && math.IsNaN(matrix[4][i]), "Metrics without variance must have a correlation of NaN")
+	}
+}
+
+func (s *correlationTestSuite) TestSpearman() {
+	header := &bitflow.Header{Fields: []string{"x", "squared"}}
+	samples := s.columnSamples(correlationX, []float64{1, 4, 9, 100})
+	s.True(CorrelationMatrix(header, samples, CorrelationPearson)[0][1] < 0.95)
+	s.InDelta(1, CorrelationMatrix(header, samples, CorrelationSpearman)[0][1], 1e-12, "Monotonic relations have a rank correlation of 1")
+
+	s.Equal([]float64{1, 2.5, 2.5, 4}, ranks([]float64{10, 20, 20, 30}))
+	s.Equal([]float64{3, 1, 2}, ranks([]float64{7, -1, 0}))
+}
+
+func (s *correlationTestSuite) TestDropCorrelated() {
+	// x has the highest correlation (with y) and the highest mean correlation, so it is dropped first.
+	// Afterwards, y and z are not correlated.
+	step := &CorrelationFeatureSelection{Method: CorrelationPearson, MaxCorrelation: 0.5}
+	header, samples, err := step.ProcessBatch(&bitflow.Header{Fields: []string{"x", "y", "z"}}, s.columnSamples(correlationX, correlationY, correlationZ))
+	s.NoError(err)
+	s.Equal([]string{"y", "z"}, header.Fields)
+	s.Equal([]bitflow.Value{3, 1}, samples[1].Values)
+
+	step.MaxCorrelation = 0.9
+	header, _, err = step.ProcessBatch(&bitflow.Header{Fields: []string{"x", "y", "z"}}, s.columnSamples(correlationX, correlationY, correlationZ))
+	s.NoError(err)
+	s.Equal([]string{"x", "y", "z"}, header.Fields)
+}
+
+func (s *correlationTestSuite) TestDropLowStddev() {
+	step := &CorrelationFeatureSelection{Method: CorrelationPearson, MinStddev: 0.5}
+	header, samples, err := step.ProcessBatch(&bitflow.Header{Fields: []string{"x", "const", "small"}},
+		s.columnSamples(correlationX, []float64{5, 5, 5, 5}, []float64{1, 1.1, 1, 1.1}))
+	s.NoError(err)
+	s.Equal([]string{"x"}, header.Fields)
+	s.Equal([]bitflow.Value{4}, samples[3].Values)
+}
+
+func (s *correlationTestSuite) TestWriteMatrix() {
+	var buf bytes.Buffer
+	s.NoError(WriteCorrelationMatrix(&buf, []string{"a", "b"}, [][]float64{{1, 0.5}, {0.5, 1}}))
+	s.Equal("metric,a,b\na,1,0.5\nb,0.5,1\n", buf.String())
+
+	dir, err := ioutil.TempDir("", "bitflow-correlation-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	step := &CorrelationFeatureSelection{Method: CorrelationPearson, File: filepath.Join(dir, "matrix.csv")}
+	header := &bitflow.Header{Fields: []string{"x", "y"}}
+	outHeader, _, err := step.ProcessBatch(header, s.columnSamples(correlationX, correlationY))
+	s.NoError(err)
+	s.True(outHeader == header, "Without thresholds, the header must not change")
+	content, err := ioutil.ReadFile(step.File)
+	s.NoError(err)
+	s.Equal("metric,x,y\nx,1,0.8\ny,0.8,1\n", string(content))
+}
+
+func (s *correlationTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterCorrelation(b)
+	analysis, _ := b.GetAnalysis("correlation")
+	s.NoError(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"method": CorrelationSpearman, "max_correlation": "0.9"}))
+	for _, params := range []map[string]string{
+		{"method": "kendall"},
+		{"max_correlation": "1.5"},
+		{"min_stddev": "-1"},
+	} {
+		s.Error(analysis.Func(new(bitflow.SamplePipeline), params), "Parameters %v", params)
+	}
+}
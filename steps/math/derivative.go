@@ -0,0 +1,227 @@
+package math
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+)
+
+func RegisterDerivative(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("derive",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			proc := &DerivativeProcessor{
+				Mode:    DeriveRate,
+				Unit:    reg.DurationParam(params, "unit", time.Second, true, &err),
+				Counter: reg.BoolParam(params, "counter", false, true, &err),
+				Replace: reg.BoolParam(params, "replace", false, true, &err),
+			}
+			proc.KeyTags = parseKeyTags(params)
+			if err == nil {
+				err = proc.validate()
+			}
+			if err == nil {
+				p.Add(proc)
+			}
+			return
+		},
+		"Compute the rate of change of every metric per time unit, based on the timestamps of consecutive samples (suffix _rate). The first sample yields NaN. "+
+			"With counter=true, metrics are treated as monotonically increasing counters: a decreasing value is interpreted as a counter reset, and the new value is used as increment. "+
+			"With replace=true, the metrics are replaced instead of appending new ones. With the key parameter (comma-separated tags), every combination of tag values is handled separately.",
+		reg.OptionalParams("unit", "counter", "replace", "key"))
+
+	b.RegisterAnalysisParamsErr("integrate",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			proc := &DerivativeProcessor{
+				Unit:    reg.DurationParam(params, "unit", time.Second, true, &err),
+				Replace: reg.BoolParam(params, "replace", false, true, &err),
+			}
+			method := reg.StrParam(params, "method", string(IntegrateSum), true, &err)
+			proc.Mode = DerivativeMode(method)
+			proc.KeyTags = parseKeyTags(params)
+			if err == nil && proc.Mode != IntegrateSum && proc.Mode != IntegrateTrapezoid {
+				err = reg.ParameterError("method", fmt.Errorf("Must be %v or %v", IntegrateSum, IntegrateTrapezoid))
+			}
+			if err == nil {
+				err = proc.validate()
+			}
+			if err == nil {
+				p.Add(proc)
+			}
+			return
+		},
+		fmt.Sprintf("Compute the cumulative sum of every metric (suffix _integral). With method=%v, the integral over time is computed using the trapezoidal rule, scaled to the given time unit. ", IntegrateTrapezoid)+
+			"With replace=true, the metrics are replaced instead of appending new ones. With the key parameter (comma-separated tags), every combination of tag values is handled separately.",
+		reg.OptionalParams("unit", "method", "replace", "key"))
+}
+
+func parseKeyTags(params map[string]string) []string {
+	if keys := params["key"]; keys != "" {
+		return strings.Split(keys, ",")
+	}
+	return nil
+}
+
+type DerivativeMode string
+
+const (
+	DeriveRate         = DerivativeMode("rate")
+	IntegrateSum       = DerivativeMode("sum")
+	IntegrateTrapezoid = DerivativeMode("trapezoid")
+)
+
+// DerivativeProcessor computes either the rate of change or the cumulative sum of every metric, depending on the Mode.
+type DerivativeProcessor struct {
+	bitflow.NoopProcessor
+	Mode    DerivativeMode
+	Unit    time.Duration // The time unit for rates and time-based integrals
+	Counter bool          // Detect counter resets when computing rates
+	Replace bool          // Replace the metrics instead of appending new ones
+	KeyTags []string      // If set, the state is kept separately for every combination of values of these tags
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	states    map[string]*derivativeState
+}
+
+type derivativeState struct {
+	time   time.Time
+	values []float64 // Values of the previous sample
+	sums   []float64 // Cumulative sums for integration
+}
+
+func (p *DerivativeProcessor) validate() error {
+	if p.Unit <= 0 {
+		return reg.ParameterError("unit", fmt.Errorf("Must be positive"))
+	}
+	return nil
+}
+
+func (p *DerivativeProcessor) suffix() string {
+	if p.Mode == DeriveRate {
+		return "_rate"
+	}
+	return "_integral"
+}
+
+func (p *DerivativeProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		p.states = make(map[string]*derivativeState)
+		if p.Replace {
+			p.outHeader = header
+		} else {
+			fields := make([]string, len(header.Fields), len(header.Fields)*2)
+			copy(fields, header.Fields)
+			for _, field := range header.Fields {
+				fields = append(fields, field+p.suffix())
+			}
+			p.outHeader = &bitflow.Header{Fields: fields}
+		}
+	}
+
+	key := p.key(sample)
+	state, ok := p.states[key]
+	if !ok {
+		state = &derivativeState{sums: make([]float64, len(header.Fields))}
+		p.states[key] = state
+	}
+	values := make([]float64, len(header.Fields))
+	for i, val := range sample.Values[:len(header.Fields)] {
+		values[i] = float64(val)
+	}
+	var results []float64
+	if p.Mode == DeriveRate {
+		results = p.rates(state, values, sample.Time)
+	} else {
+		results = p.integrate(state, values, sample.Time)
+	}
+	state.time = sample.Time
+	state.values = values
+
+	if p.Replace {
+		steps.FillSample(sample, results)
+	} else {
+		steps.AppendToSample(sample, results)
+	}
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *DerivativeProcessor) rates(state *derivativeState, values []float64, t time.Time) []float64 {
+	res := make([]float64, len(values))
+	units := float64(t.Sub(state.time)) / float64(p.Unit)
+	for i, val := range values {
+		if state.values == nil || units <= 0 {
+			res[i] = math.NaN()
+			continue
+		}
+		diff := val - state.values[i]
+		if p.Counter && diff < 0 {
+			// Counter reset: assume the counter restarted from zero
+			diff = val
+		}
+		res[i] = diff / units
+	}
+	return res
+}
+
+func (p *DerivativeProcessor) integrate(state *derivativeState, values []float64, t time.Time) []float64 {
+	res := make([]float64, len(values))
+	for i, val := range values {
+		// NaN values (e.g. the first result of a derivation) do not contribute to the integral
+		if p.Mode == IntegrateTrapezoid {
+			if state.values != nil && t.After(state.time) && !math.IsNaN(val) && !math.IsNaN(state.values[i]) {
+				units := float64(t.Sub(state.time)) / float64(p.Unit)
+				state.sums[i] += (state.values[i] + val) / 2 * units
+			}
+		} else if !math.IsNaN(val) {
+			state.sums[i] += val
+		}
+		res[i] = state.sums[i]
+	}
+	return res
+}
+
+func (p *DerivativeProcessor) key(sample *bitflow.Sample) string {
+	if len(p.KeyTags) == 0 {
+		return ""
+	}
+	values := make([]string, len(p.KeyTags))
+	for i, tag := range p.KeyTags {
+		values[i] = sample.Tag(tag)
+	}
+	return strings.Join(values, ",")
+}
+
+func (p *DerivativeProcessor) OutputSampleSize(sampleSize int) int {
+	if p.Replace {
+		return sampleSize
+	}
+	return sampleSize * 2
+}
+
+func (p *DerivativeProcessor) String() string {
+	var res string
+	switch p.Mode {
+	case DeriveRate:
+		res = fmt.Sprintf("Derive (rate per %v", p.Unit)
+		if p.Counter {
+			res += ", counter"
+		}
+	case IntegrateTrapezoid:
+		res = fmt.Sprintf("Integrate (trapezoid, unit %v", p.Unit)
+	default:
+		res = "Integrate (cumulative sum"
+	}
+	if p.Replace {
+		res += ", replace metrics"
+	}
+	res += ")"
+	if len(p.KeyTags) > 0 {
+		res += fmt.Sprintf(" per %v", p.KeyTags)
+	}
+	return res
+}
@@ -0,0 +1,90 @@
+package math
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type derivativeTestSuite struct {
+	testsupport.Suite
+}
+
+func TestDerivative(t *testing.T) {
+	suite.Run(t, new(derivativeTestSuite))
+}
+
+var derivativeTestHeader = &bitflow.Header{Fields: []string{"a"}}
+
+func (s *derivativeTestSuite) TestRate() {
+	out := s.Process(&DerivativeProcessor{Mode: DeriveRate, Unit: time.Second}, derivativeTestHeader,
+		testsupport.NewSample(0, "", 10),
+		testsupport.NewSample(2*time.Second, "", 20),
+		testsupport.NewSample(2*time.Second, "", 30), // No time difference
+		testsupport.NewSample(2500*time.Millisecond, "", 25))
+	out.AssertFields(s.T(), "a", "a_rate")
+	values := out.Values()
+	s.True(math.IsNaN(values[0][1]), "The first sample has no rate")
+	s.Equal(5.0, values[1][1])
+	s.True(math.IsNaN(values[2][1]))
+	s.Equal(-10.0, values[3][1])
+
+	out = s.Process(&DerivativeProcessor{Mode: DeriveRate, Unit: time.Minute, Replace: true}, derivativeTestHeader,
+		testsupport.NewSample(0, "", 10),
+		testsupport.NewSample(30*time.Second, "", 20))
+	out.AssertFields(s.T(), "a")
+	s.Equal(20.0, out.Values()[1][0])
+}
+
+func (s *derivativeTestSuite) TestCounterReset() {
+	out := s.Process(&DerivativeProcessor{Mode: DeriveRate, Unit: time.Second, Counter: true, Replace: true}, derivativeTestHeader,
+		testsupport.NewSample(0, "", 100),
+		testsupport.NewSample(time.Second, "", 150),
+		testsupport.NewSample(2*time.Second, "", 20), // The counter restarted from zero
+		testsupport.NewSample(3*time.Second, "", 30))
+	s.Equal([]float64{50, 20, 10}, []float64{out.Values()[1][0], out.Values()[2][0], out.Values()[3][0]})
+}
+
+func (s *derivativeTestSuite) TestIntegrate() {
+	out := s.Process(&DerivativeProcessor{Mode: IntegrateSum, Unit: time.Second}, derivativeTestHeader,
+		testsupport.NewSample(0, "", 1),
+		testsupport.NewSample(time.Second, "", bitflow.Value(math.NaN())),
+		testsupport.NewSample(5*time.Second, "", 3))
+	out.AssertFields(s.T(), "a", "a_integral")
+	s.Equal([]float64{1, 1, 4}, []float64{out.Values()[0][1], out.Values()[1][1], out.Values()[2][1]})
+
+	out = s.Process(&DerivativeProcessor{Mode: IntegrateTrapezoid, Unit: time.Second, Replace: true}, derivativeTestHeader,
+		testsupport.NewSample(0, "", 0),
+		testsupport.NewSample(2*time.Second, "", 4),
+		testsupport.NewSample(3*time.Second, "", 2))
+	out.AssertValues(s.T(), [][]float64{{0}, {4}, {7}})
+}
+
+func (s *derivativeTestSuite) TestKeys() {
+	out := s.Process(&DerivativeProcessor{Mode: DeriveRate, Unit: time.Second, Replace: true, KeyTags: []string{"host"}}, derivativeTestHeader,
+		testsupport.NewSample(0, "host=a", 0),
+		testsupport.NewSample(0, "host=b", 100),
+		testsupport.NewSample(time.Second, "host=a", 1),
+		testsupport.NewSample(2*time.Second, "host=b", 110))
+	values := out.Values()
+	s.True(math.IsNaN(values[0][0]) && math.IsNaN(values[1][0]))
+	s.Equal(1.0, values[2][0])
+	s.Equal(5.0, values[3][0])
+}
+
+func (s *derivativeTestSuite) TestRoundTrip() {
+	// Summing up the rates per sample interval restores the differences to the first value
+	derive := &DerivativeProcessor{Mode: DeriveRate, Unit: time.Second, Replace: true}
+	integrate := &DerivativeProcessor{Mode: IntegrateSum, Unit: time.Second, Replace: true}
+	var samples []*bitflow.Sample
+	for i, val := range []bitflow.Value{3, 5, 4, 10} {
+		samples = append(samples, testsupport.NewSample(time.Duration(i)*time.Second, "", val))
+	}
+	rates := s.Process(derive, derivativeTestHeader, samples...).Samples()
+	out := s.Process(integrate, derivativeTestHeader, rates...)
+	out.AssertValues(s.T(), [][]float64{{0}, {2}, {1}, {7}})
+}
@@ -3,6 +3,7 @@ package math
 import (
 	"fmt"
 	"math"
+	"math/cmplx"
 	"strconv"
 	"sync"
 
@@ -30,13 +31,34 @@ var (
 	warningLock          sync.Mutex
 )
 
+const (
+	FftWindowNone    = "none"
+	FftWindowHann    = "hann"
+	FftWindowHamming = "hamming"
+)
+
 func RegisterFFT(b reg.ProcessorRegistry) {
-	b.RegisterAnalysis("fft",
-		func(p *bitflow.SamplePipeline) {
-			p.Batch(new(BatchFft))
+	b.RegisterAnalysisParamsErr("fft",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &BatchFft{
+				WindowFunction: reg.StrParam(params, "window_function", FftWindowNone, true, &err),
+				FrameSize:      reg.IntParam(params, "frame", 0, true, &err),
+				Overlap:        reg.FloatParam(params, "overlap", 0, true, &err),
+			}
+			if err == nil {
+				err = step.validate()
+			}
+			if err == nil {
+				p.Batch(step)
+			}
+			return
 		},
-		"Compute a radix-2 FFT on every metric of the batch. Output the real and imaginary parts of the result",
-		reg.SupportBatch())
+		"Compute a radix-2 FFT on every metric of the batch. Output the real and imaginary parts of the result. "+
+			"The window_function parameter selects a function that is applied to the input values before the FFT ("+FftWindowNone+", "+FftWindowHann+" or "+FftWindowHamming+"). "+
+			"If the frame parameter (a power of two) is given, a spectrogram is computed instead: the batch is split into frames of the given number of samples, "+
+			"which overlap by the given fraction (overlap parameter, default 0). For every frame, one sample per frequency bin is emitted, "+
+			"containing the frequency (metric '"+FftFreqMetricName+"') and the magnitude of every input metric. The timestamp and tags are taken from the first sample of the frame.",
+		reg.OptionalParams("window_function", "frame", "overlap"), reg.SupportBatch())
 }
 
 type BatchFft struct {
@@ -66,6 +88,63 @@ type BatchFft struct {
 	// FatalErrors can be set to true to return errors that occur when processing a batch.
 	// Otherwise, the error will be printed as a warning and an empty result batch will be produced.
 	FatalErrors bool
+
+	// WindowFunction is applied to the input values before computing the FFT. Supported are FftWindowNone (the default),
+	// FftWindowHann and FftWindowHamming. The normalization takes the coherent gain of the window function into account.
+	WindowFunction string
+
+	// If FrameSize > 0, a spectrogram is computed: the input batch is split into frames of FrameSize samples (which must be a power of two),
+	// and the FFT is computed for every frame. Consecutive frames overlap by the fraction given in Overlap (in [0, 1[).
+	// For every frame, one output sample is produced for every frequency bin, containing the frequency and the magnitude
+	// of every input metric. The other options, except SamplingFrequency and WindowFunction, are ignored in this mode.
+	FrameSize int
+	Overlap   float64
+}
+
+func (s *BatchFft) validate() error {
+	switch s.WindowFunction {
+	case "", FftWindowNone, FftWindowHann, FftWindowHamming:
+	default:
+		return reg.ParameterError("window_function", fmt.Errorf("Unknown window function '%v'", s.WindowFunction))
+	}
+	if s.FrameSize < 0 || (s.FrameSize > 0 && s.FrameSize&(s.FrameSize-1) != 0) {
+		return reg.ParameterError("frame", fmt.Errorf("Must be a power of two"))
+	}
+	if s.Overlap < 0 || s.Overlap >= 1 {
+		return reg.ParameterError("overlap", fmt.Errorf("Must be in [0..1["))
+	}
+	return nil
+}
+
+// windowWeights returns the weights of the configured window function for the given number of values.
+func (s *BatchFft) windowWeights(num int) []float64 {
+	weights := make([]float64, num)
+	for i := range weights {
+		weights[i] = 1
+		if num <= 1 {
+			continue
+		}
+		phase := 2 * math.Pi * float64(i) / float64(num-1)
+		switch s.WindowFunction {
+		case FftWindowHann:
+			weights[i] = 0.5 - 0.5*math.Cos(phase)
+		case FftWindowHamming:
+			weights[i] = 0.54 - 0.46*math.Cos(phase)
+		}
+	}
+	return weights
+}
+
+// coherentGain returns the mean of the given window weights, which is used to normalize the FFT results.
+func coherentGain(weights []float64) float64 {
+	if len(weights) == 0 {
+		return 1
+	}
+	var sum float64
+	for _, weight := range weights {
+		sum += weight
+	}
+	return sum / float64(len(weights))
 }
 
 func getFft(num int) (*fft.FFT, error) {
@@ -125,6 +204,10 @@ func (s *BatchFft) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sampl
 }
 
 func (s *BatchFft) processBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if s.FrameSize > 0 {
+		return s.processSpectrogram(header, samples)
+	}
+
 	// Get an FFT instance and change the number of samples to a power of 2, if necessary
 	f, err := getFft(len(samples))
 	if err != nil {
@@ -151,10 +234,12 @@ func (s *BatchFft) processBatch(header *bitflow.Header, samples []*bitflow.Sampl
 	for i := range header.Fields {
 		cols[i] = make([]complex128, f.N)
 	}
+	weights := s.windowWeights(len(samples))
+	normalization := float64(f.N) * coherentGain(weights)
 	for j, sample := range samples {
 		for i := range header.Fields {
 			// TODO is the imaginary part always zero?
-			cols[i][j] = complex(float64(sample.Values[i]), 0)
+			cols[i][j] = complex(float64(sample.Values[i])*weights[j], 0)
 		}
 		// Resize the sample to take the real and imaginary part of the result
 		sample.Resize(outputFields)
@@ -167,7 +252,7 @@ func (s *BatchFft) processBatch(header *bitflow.Header, samples []*bitflow.Sampl
 	}
 	outHeader := header
 	if outputFields != len(outHeader.Fields) {
-		outHeader = &bitflow.Header{make([]string, outputFields)}
+		outHeader = header.Clone(make([]string, outputFields))
 		copy(outHeader.Fields, header.Fields[:freqIndex])
 		if freqIndex < len(header.Fields) {
 			copy(outHeader.Fields[freqIndex+1:], header.Fields[freqIndex:])
@@ -193,7 +278,7 @@ func (s *BatchFft) processBatch(header *bitflow.Header, samples []*bitflow.Sampl
 		for j, sample := range samples {
 			val := math.Abs(real(res[j]))
 			if !s.SkipNormalization {
-				val /= float64(f.N)
+				val /= normalization
 			}
 			valIndex := i
 			if valIndex >= freqIndex {
@@ -212,6 +297,55 @@ func (s *BatchFft) processBatch(header *bitflow.Header, samples []*bitflow.Sampl
 	return outHeader, samples, nil
 }
 
+func (s *BatchFft) processSpectrogram(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	f, err := getFft(s.FrameSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(samples) < s.FrameSize {
+		return nil, nil, fmt.Errorf("The number of input samples (%v) is smaller than the frame size (%v)", len(samples), s.FrameSize)
+	}
+	hop := int(float64(s.FrameSize) * (1 - s.Overlap))
+	if hop < 1 {
+		hop = 1
+	}
+	weights := s.windowWeights(s.FrameSize)
+	normalization := float64(s.FrameSize) * coherentGain(weights)
+	numBins := s.FrameSize/2 + 1
+
+	outFields := make([]string, 0, len(header.Fields)+1)
+	outFields = append(outFields, FftFreqMetricName)
+	outFields = append(outFields, header.Fields...)
+	outSamples := make([]*bitflow.Sample, 0, (len(samples)-s.FrameSize)/hop*numBins+numBins)
+	col := make([]complex128, s.FrameSize)
+	for start := 0; start+s.FrameSize <= len(samples); start += hop {
+		frame := samples[start : start+s.FrameSize]
+		samplingFrequency, err := s.samplingFrequency(frame)
+		if err != nil {
+			return nil, nil, err
+		}
+		frameSamples := make([]*bitflow.Sample, numBins)
+		for bin := range frameSamples {
+			sample := new(bitflow.Sample)
+			sample.CopyMetadataFrom(frame[0])
+			sample.Values = make([]bitflow.Value, len(outFields))
+			sample.Values[0] = bitflow.Value(float64(bin) * samplingFrequency / float64(s.FrameSize))
+			frameSamples[bin] = sample
+		}
+		for i := range header.Fields {
+			for j, sample := range frame {
+				col[j] = complex(float64(sample.Values[i])*weights[j], 0)
+			}
+			res := f.Transform(col)
+			for bin, sample := range frameSamples {
+				sample.Values[i+1] = bitflow.Value(cmplx.Abs(res[bin]) / normalization)
+			}
+		}
+		outSamples = append(outSamples, frameSamples...)
+	}
+	return header.Clone(outFields), outSamples, nil
+}
+
 func (s *BatchFft) OutputSampleSize(sampleSize int) int {
 	res := sampleSize
 	if s.FrequencyMetricIndex >= 0 {
@@ -232,6 +366,13 @@ func (s *BatchFft) String() string {
 	} else {
 		freqMetric = "frequency appended at index " + strconv.Itoa(s.FrequencyMetricIndex)
 	}
-	return fmt.Sprintf("FFT (cut symmetric part: %v, normalize: %v, fill zeros: %v, %v, %v)",
-		!s.LeaveSymmetricPart, !s.SkipNormalization, s.FillZeros, sampleFreq, freqMetric)
+	window := ""
+	if s.WindowFunction != "" && s.WindowFunction != FftWindowNone {
+		window = ", " + s.WindowFunction + " window"
+	}
+	if s.FrameSize > 0 {
+		return fmt.Sprintf("FFT spectrogram (frame size %v, overlap %v%v, %v)", s.FrameSize, s.Overlap, window, sampleFreq)
+	}
+	return fmt.Sprintf("FFT (cut symmetric part: %v, normalize: %v, fill zeros: %v, %v, %v%v)",
+		!s.LeaveSymmetricPart, !s.SkipNormalization, s.FillZeros, sampleFreq, freqMetric, window)
 }
@@ -0,0 +1,102 @@
+package math
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type fftTestSuite struct {
+	testsupport.Suite
+}
+
+func TestFft(t *testing.T) {
+	suite.Run(t, new(fftTestSuite))
+}
+
+// signal returns num samples with a sampling frequency of 8 Hz, tagged with their index
+func (s *fftTestSuite) signal(num int, f func(t float64) float64) []*bitflow.Sample {
+	res := make([]*bitflow.Sample, num)
+	for i := range res {
+		t := float64(i) / 8
+		res[i] = testsupport.NewSample(time.Duration(t*float64(time.Second)), "", bitflow.Value(f(t)))
+		res[i].SetTag("index", string(rune('a'+i)))
+	}
+	return res
+}
+
+func (s *fftTestSuite) TestWindowFunctions() {
+	s.InDeltaSlice([]float64{1, 1, 1, 1, 1}, (&BatchFft{}).windowWeights(5), 1e-12)
+	hann := (&BatchFft{WindowFunction: FftWindowHann}).windowWeights(5)
+	s.InDeltaSlice([]float64{0, 0.5, 1, 0.5, 0}, hann, 1e-12)
+	s.InDeltaSlice([]float64{0.08, 0.54, 1, 0.54, 0.08}, (&BatchFft{WindowFunction: FftWindowHamming}).windowWeights(5), 1e-12)
+	s.InDelta(0.4, coherentGain(hann), 1e-12)
+	s.Equal([]float64{1}, (&BatchFft{WindowFunction: FftWindowHann}).windowWeights(1))
+}
+
+func (s *fftTestSuite) TestWindowNormalization() {
+	// The coherent gain of the window function is compensated, so the DC component of a constant signal is not changed
+	for _, window := range []string{FftWindowNone, FftWindowHann, FftWindowHamming} {
+		step := &BatchFft{WindowFunction: window, SamplingFrequency: 8, FatalErrors: true}
+		header, samples, err := step.ProcessBatch(&bitflow.Header{Fields: []string{"a"}}, s.signal(8, func(float64) float64 { return 3 }))
+		s.NoError(err)
+		s.Equal([]string{FftFreqMetricName, "a"}, header.Fields)
+		s.Len(samples, 5)
+		s.InDelta(3, float64(samples[0].Values[1]), 1e-12, "Window function %v", window)
+	}
+}
+
+func (s *fftTestSuite) TestSpectrogram() {
+	step := &BatchFft{FrameSize: 8, Overlap: 0.5, SamplingFrequency: 8, FatalErrors: true}
+	cosine := func(t float64) float64 { return math.Cos(2 * math.Pi * 2 * t) } // 2 Hz
+	header, samples, err := step.ProcessBatch(&bitflow.Header{Fields: []string{"a"}}, s.signal(16, cosine))
+	s.NoError(err)
+	s.Equal([]string{FftFreqMetricName, "a"}, header.Fields)
+	s.Len(samples, 3*5, "Three frames starting at samples 0, 4 and 8, five frequency bins each")
+	for i, sample := range samples {
+		frame, bin := i/5, i%5
+		s.Equal(float64(bin), float64(sample.Values[0]), "Frequency of bin %v", bin)
+		expected := 0.0
+		if bin == 2 {
+			expected = 0.5
+		}
+		s.InDelta(expected, float64(sample.Values[1]), 1e-9, "Magnitude of frame %v, bin %v", frame, bin)
+		s.Equal(string(rune('a'+frame*4)), sample.Tag("index"), "Metadata must be copied from the first sample of the frame")
+		s.Equal(testsupport.StartTime.Add(time.Duration(frame)*500*time.Millisecond), sample.Time)
+	}
+}
+
+func (s *fftTestSuite) TestSpectrogramWindow() {
+	// Without overlap, the frames are consecutive. The sampling frequency is computed from the timestamps.
+	step := &BatchFft{FrameSize: 4, WindowFunction: FftWindowHann, FatalErrors: true}
+	_, samples, err := step.ProcessBatch(&bitflow.Header{Fields: []string{"a"}}, s.signal(9, func(float64) float64 { return 2 }))
+	s.NoError(err)
+	s.Len(samples, 2*3)
+	s.InDelta(2, float64(samples[3].Values[1]), 1e-12, "DC component of the second frame")
+	s.InDelta(32.0/3/4, float64(samples[4].Values[0]), 1e-12, "4 samples within 3/8 seconds")
+
+	_, _, err = step.ProcessBatch(&bitflow.Header{Fields: []string{"a"}}, s.signal(3, math.Sin))
+	s.Error(err, "Not enough samples for one frame")
+}
+
+func (s *fftTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterFFT(b)
+	analysis, _ := b.GetAnalysis("fft")
+	s.NoError(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"window_function": FftWindowHamming, "frame": "16", "overlap": "0.75"}))
+	for _, params := range []map[string]string{
+		{"window_function": "blackman"},
+		{"frame": "12"},
+		{"frame": "-4"},
+		{"frame": "8", "overlap": "1"},
+	} {
+		s.Error(analysis.Func(new(bitflow.SamplePipeline), params), "Parameters %v", params)
+	}
+	s.Equal("FFT spectrogram (frame size 16, overlap 0.5, hann window, auto sample frequency)",
+		(&BatchFft{FrameSize: 16, Overlap: 0.5, WindowFunction: FftWindowHann}).String())
+}
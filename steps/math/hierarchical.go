@@ -0,0 +1,404 @@
+package math
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	log "github.com/sirupsen/logrus"
+)
+
+type Linkage string
+
+const (
+	SingleLinkage   = Linkage("single")
+	CompleteLinkage = Linkage("complete")
+	AverageLinkage  = Linkage("average")
+	WardLinkage     = Linkage("ward")
+
+	DendrogramJson     = "json"
+	DendrogramGraphviz = "dot"
+)
+
+var allLinkages = []Linkage{SingleLinkage, CompleteLinkage, AverageLinkage, WardLinkage}
+
+func RegisterHierarchicalClustering(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("hierarchical_clustering",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &HierarchicalClustering{
+				Linkage:    Linkage(reg.StrParam(params, "linkage", string(AverageLinkage), true, &err)),
+				K:          reg.IntParam(params, "k", 0, true, &err),
+				Height:     reg.FloatParam(params, "height", 0, true, &err),
+				Tag:        reg.StrParam(params, "tag", ClusterTag, true, &err),
+				LabelTag:   reg.StrParam(params, "label", "", true, &err),
+				Dendrogram: reg.StrParam(params, "dendrogram", "", true, &err),
+				Format:     reg.StrParam(params, "format", "", true, &err),
+			}
+			if err == nil {
+				err = step.validate()
+			}
+			if err == nil {
+				p.Batch(step)
+			}
+			return
+		},
+		fmt.Sprintf("Cluster a batch of samples using agglomerative hierarchical clustering with the given linkage (one of %v). ", allLinkages)+
+			"The dendrogram is cut either into k clusters, or at the given merge height, and the cluster index of every sample is stored in the given tag. "+
+			fmt.Sprintf("If the dendrogram parameter is given, the full dendrogram is written to that file, either as JSON or as Graphviz (format=%v, default for .dot and .gv files). ", DendrogramGraphviz)+
+			"The leaves of the dendrogram are labeled with the value of the label tag, or with the sample timestamp. "+
+			"The distance matrix is kept in memory, so the memory consumption is quadratic in the number of samples.",
+		reg.OptionalParams("linkage", "k", "height", "tag", "label", "dendrogram", "format"), reg.SupportBatch())
+}
+
+// DendrogramMerge describes one step of an agglomerative clustering. Clusters 0..n-1 are the individual samples,
+// the cluster created by merge i receives the index n+i.
+type DendrogramMerge struct {
+	Left   int
+	Right  int
+	Height float64
+	Size   int
+}
+
+// Dendrogram contains all merges of an agglomerative clustering, sorted by height.
+type Dendrogram struct {
+	Leaves int
+	Merges []DendrogramMerge
+}
+
+// Agglomerate computes the dendrogram of the given points using the nearest-neighbor-chain algorithm. All supported
+// linkages are reducible, so the algorithm produces the same result as the naive algorithm in quadratic time.
+func Agglomerate(points [][]float64, linkage Linkage) *Dendrogram {
+	n := len(points)
+	dist := make([][]float64, n)
+	for i := range dist {
+		dist[i] = make([]float64, n)
+		for j := 0; j < i; j++ {
+			dist[i][j] = euclideanDistance(points[i], points[j])
+			dist[j][i] = dist[i][j]
+		}
+	}
+	sizes := make([]int, n)
+	active := make([]bool, n)
+	for i := range sizes {
+		sizes[i] = 1
+		active[i] = true
+	}
+
+	// Merges are recorded with the index of one contained point for every cluster, and relabeled afterwards
+	type rawMerge struct {
+		a, b   int
+		height float64
+	}
+	merges := make([]rawMerge, 0, n)
+	var chain []int
+	for len(merges) < n-1 {
+		if len(chain) == 0 {
+			for i, isActive := range active {
+				if isActive {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+		a := chain[len(chain)-1]
+		b, bestDist := -1, math.Inf(1)
+		if len(chain) > 1 {
+			// Prefer the previous element of the chain in case of ties
+			b = chain[len(chain)-2]
+			bestDist = dist[a][b]
+		}
+		for i, isActive := range active {
+			if isActive && i != a && dist[a][i] < bestDist {
+				b, bestDist = i, dist[a][i]
+			}
+		}
+		if len(chain) < 2 || b != chain[len(chain)-2] {
+			chain = append(chain, b)
+			continue
+		}
+
+		chain = chain[:len(chain)-2]
+		merges = append(merges, rawMerge{a, b, bestDist})
+		// The merged cluster is stored at index a
+		for k, isActive := range active {
+			if isActive && k != a && k != b {
+				dist[a][k] = linkage.update(dist[k][a], dist[k][b], dist[a][b], sizes[a], sizes[b], sizes[k])
+				dist[k][a] = dist[a][k]
+			}
+		}
+		sizes[a] += sizes[b]
+		active[b] = false
+	}
+
+	sort.SliceStable(merges, func(i, j int) bool {
+		return merges[i].height < merges[j].height
+	})
+	res := &Dendrogram{Leaves: n, Merges: make([]DendrogramMerge, len(merges))}
+	sets := newUnionFind(n)
+	clusterIds := make([]int, n)
+	for i := range clusterIds {
+		clusterIds[i] = i
+	}
+	for i, merge := range merges {
+		rootA, rootB := sets.find(merge.a), sets.find(merge.b)
+		root := sets.union(rootA, rootB)
+		res.Merges[i] = DendrogramMerge{
+			Left:   clusterIds[rootA],
+			Right:  clusterIds[rootB],
+			Height: merge.height,
+			Size:   sets.size[root],
+		}
+		clusterIds[root] = n + i
+	}
+	return res
+}
+
+// update computes the distance between cluster k and the union of clusters a and b (Lance-Williams formula)
+func (l Linkage) update(distKA, distKB, distAB float64, sizeA, sizeB, sizeK int) float64 {
+	switch l {
+	case SingleLinkage:
+		return math.Min(distKA, distKB)
+	case CompleteLinkage:
+		return math.Max(distKA, distKB)
+	case WardLinkage:
+		a, b, k := float64(sizeA), float64(sizeB), float64(sizeK)
+		return math.Sqrt(((a+k)*distKA*distKA + (b+k)*distKB*distKB - k*distAB*distAB) / (a + b + k))
+	default: // AverageLinkage
+		return (float64(sizeA)*distKA + float64(sizeB)*distKB) / float64(sizeA+sizeB)
+	}
+}
+
+// Cut assigns a cluster index to every leaf, by applying all merges up to the given height, but at most
+// Leaves-k merges. Parameters <= 0 are ignored.
+func (d *Dendrogram) Cut(k int, height float64) []int {
+	numMerges := len(d.Merges)
+	if k > 0 && d.Leaves-k < numMerges {
+		numMerges = d.Leaves - k
+	}
+	sets := newUnionFind(d.Leaves)
+	representatives := make([]int, d.Leaves+len(d.Merges)) // One leaf contained in every cluster
+	for i := 0; i < d.Leaves; i++ {
+		representatives[i] = i
+	}
+	for i := 0; i < numMerges; i++ {
+		merge := d.Merges[i]
+		if height > 0 && merge.Height > height {
+			break
+		}
+		left, right := representatives[merge.Left], representatives[merge.Right]
+		sets.union(sets.find(left), sets.find(right))
+		representatives[d.Leaves+i] = left
+	}
+
+	labels := make(map[int]int)
+	res := make([]int, d.Leaves)
+	for i := range res {
+		root := sets.find(i)
+		label, ok := labels[root]
+		if !ok {
+			label = len(labels)
+			labels[root] = label
+		}
+		res[i] = label
+	}
+	return res
+}
+
+type dendrogramNode struct {
+	Id       int               `json:"id"`
+	Label    string            `json:"label,omitempty"`
+	Height   float64           `json:"height"`
+	Size     int               `json:"size"`
+	Children []*dendrogramNode `json:"children,omitempty"`
+}
+
+func (d *Dendrogram) tree(labels []string) *dendrogramNode {
+	nodes := make([]*dendrogramNode, d.Leaves+len(d.Merges))
+	for i := 0; i < d.Leaves; i++ {
+		nodes[i] = &dendrogramNode{Id: i, Label: labels[i], Size: 1}
+	}
+	for i, merge := range d.Merges {
+		nodes[d.Leaves+i] = &dendrogramNode{
+			Id:       d.Leaves + i,
+			Height:   merge.Height,
+			Size:     merge.Size,
+			Children: []*dendrogramNode{nodes[merge.Left], nodes[merge.Right]},
+		}
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[len(nodes)-1]
+}
+
+// WriteJson writes the dendrogram as a nested JSON object. The leaves are labeled with the given labels.
+func (d *Dendrogram) WriteJson(writer io.Writer, labels []string) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d.tree(labels))
+}
+
+// WriteGraphviz writes the dendrogram as a Graphviz digraph. The leaves are labeled with the given labels.
+func (d *Dendrogram) WriteGraphviz(writer io.Writer, labels []string) error {
+	w := bufio.NewWriter(writer)
+	fmt.Fprintln(w, "digraph dendrogram {")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for i, label := range labels {
+		fmt.Fprintf(w, "  n%v [label=%v];\n", i, strconv.Quote(label))
+	}
+	for i, merge := range d.Merges {
+		id := d.Leaves + i
+		fmt.Fprintf(w, "  n%v [shape=ellipse, label=%v];\n", id, strconv.Quote(fmt.Sprintf("%.4g (%v)", merge.Height, merge.Size)))
+		fmt.Fprintf(w, "  n%v -> n%v;\n  n%v -> n%v;\n", id, merge.Left, id, merge.Right)
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
+
+type unionFind struct {
+	parent []int
+	size   []int
+}
+
+func newUnionFind(n int) *unionFind {
+	res := &unionFind{parent: make([]int, n), size: make([]int, n)}
+	for i := range res.parent {
+		res.parent[i] = i
+		res.size[i] = 1
+	}
+	return res
+}
+
+func (u *unionFind) find(i int) int {
+	for u.parent[i] != i {
+		u.parent[i] = u.parent[u.parent[i]]
+		i = u.parent[i]
+	}
+	return i
+}
+
+// union merges the sets of the two given roots and returns the new root
+func (u *unionFind) union(rootA, rootB int) int {
+	if rootA == rootB {
+		return rootA
+	}
+	if u.size[rootA] < u.size[rootB] {
+		rootA, rootB = rootB, rootA
+	}
+	u.parent[rootB] = rootA
+	u.size[rootA] += u.size[rootB]
+	return rootA
+}
+
+// HierarchicalClustering clusters a batch of samples using agglomerative clustering. The resulting dendrogram
+// can optionally be written to a file.
+type HierarchicalClustering struct {
+	Linkage    Linkage
+	K          int     // Cut the dendrogram into K clusters, if > 0
+	Height     float64 // Cut the dendrogram at the given height, if > 0
+	Tag        string
+	LabelTag   string // Tag for labeling the leaves of the dendrogram. If empty, the timestamp is used.
+	Dendrogram string // Output file for the dendrogram, optional
+	Format     string
+
+	counter int
+}
+
+func (h *HierarchicalClustering) validate() error {
+	validLinkage := false
+	for _, linkage := range allLinkages {
+		validLinkage = validLinkage || linkage == h.Linkage
+	}
+	if !validLinkage {
+		return reg.ParameterError("linkage", fmt.Errorf("Must be one of %v", allLinkages))
+	}
+	if h.K <= 0 && h.Height <= 0 && h.Dendrogram == "" {
+		return fmt.Errorf("At least one of the parameters k, height and dendrogram is required")
+	}
+	if h.Format == "" {
+		switch filepath.Ext(h.Dendrogram) {
+		case ".dot", ".gv":
+			h.Format = DendrogramGraphviz
+		default:
+			h.Format = DendrogramJson
+		}
+	}
+	if h.Format != DendrogramJson && h.Format != DendrogramGraphviz {
+		return reg.ParameterError("format", fmt.Errorf("Must be %v or %v", DendrogramJson, DendrogramGraphviz))
+	}
+	return nil
+}
+
+func (h *HierarchicalClustering) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	points := make([][]float64, len(samples))
+	for i, sample := range samples {
+		points[i] = steps.SampleToVector(sample)
+	}
+	dendrogram := Agglomerate(points, h.Linkage)
+	if h.Dendrogram != "" {
+		if err := h.writeDendrogram(dendrogram, samples); err != nil {
+			return nil, nil, err
+		}
+	}
+	if h.K > 0 || h.Height > 0 {
+		for i, cluster := range dendrogram.Cut(h.K, h.Height) {
+			samples[i].SetTag(h.Tag, strconv.Itoa(cluster))
+		}
+	}
+	return header, samples, nil
+}
+
+func (h *HierarchicalClustering) writeDendrogram(dendrogram *Dendrogram, samples []*bitflow.Sample) error {
+	labels := make([]string, len(samples))
+	for i, sample := range samples {
+		if h.LabelTag != "" {
+			labels[i] = sample.Tag(h.LabelTag)
+		} else {
+			labels[i] = sample.Time.Format(bitflow.TextMarshallerDateFormat)
+		}
+	}
+	group := bitflow.NewFileGroup(h.Dendrogram)
+	file, err := group.OpenNewFile(&h.counter)
+	if err != nil {
+		return err
+	}
+	log.Println("Writing dendrogram to", file.Name())
+	if h.Format == DendrogramGraphviz {
+		err = dendrogram.WriteGraphviz(file, labels)
+	} else {
+		err = dendrogram.WriteJson(file, labels)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (h *HierarchicalClustering) String() string {
+	res := fmt.Sprintf("Hierarchical clustering (%v linkage", h.Linkage)
+	if h.K > 0 {
+		res += fmt.Sprintf(", k=%v", h.K)
+	}
+	if h.Height > 0 {
+		res += fmt.Sprintf(", height %v", h.Height)
+	}
+	if h.K > 0 || h.Height > 0 {
+		res += fmt.Sprintf(", tag %v", h.Tag)
+	}
+	res += ")"
+	if h.Dendrogram != "" {
+		res += fmt.Sprintf(" (dendrogram to %v as %v)", h.Dendrogram, h.Format)
+	}
+	return res
+}
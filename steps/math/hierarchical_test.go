@@ -0,0 +1,113 @@
+package math
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type hierarchicalTestSuite struct {
+	testsupport.Suite
+}
+
+func TestHierarchicalClustering(t *testing.T) {
+	suite.Run(t, new(hierarchicalTestSuite))
+}
+
+var hierarchicalTestPoints = [][]float64{{0}, {1}, {3}, {7}}
+
+func (s *hierarchicalTestSuite) heights(d *Dendrogram) []float64 {
+	res := make([]float64, len(d.Merges))
+	for i, merge := range d.Merges {
+		res[i] = merge.Height
+	}
+	return res
+}
+
+func (s *hierarchicalTestSuite) TestLinkages() {
+	for linkage, expected := range map[Linkage][]float64{
+		SingleLinkage:   {1, 2, 4},
+		CompleteLinkage: {1, 3, 7},
+		AverageLinkage:  {1, 2.5, 17.0 / 3},
+		// Ward distances: sqrt(2*|A|*|B| / (|A|+|B|)) * <distance of the centroids>
+		WardLinkage: {1, math.Sqrt(4.0/3) * 2.5, math.Sqrt(1.5) * 17 / 3},
+	} {
+		d := Agglomerate(hierarchicalTestPoints, linkage)
+		s.Equal(4, d.Leaves)
+		s.Len(d.Merges, 3)
+		s.InDeltaSlice(expected, s.heights(d), 1e-9, "%v linkage", linkage)
+		s.Equal([]int{2, 3, 4}, []int{d.Merges[0].Size, d.Merges[1].Size, d.Merges[2].Size})
+		s.ElementsMatch([]int{0, 1}, []int{d.Merges[0].Left, d.Merges[0].Right})
+		s.ElementsMatch([]int{2, 4}, []int{d.Merges[1].Left, d.Merges[1].Right}, "The second merge must reference the first merged cluster")
+		s.ElementsMatch([]int{3, 5}, []int{d.Merges[2].Left, d.Merges[2].Right})
+	}
+}
+
+func (s *hierarchicalTestSuite) TestCut() {
+	d := Agglomerate(hierarchicalTestPoints, SingleLinkage)
+	s.Equal([]int{0, 0, 0, 1}, d.Cut(2, 0))
+	s.Equal([]int{0, 0, 1, 2}, d.Cut(0, 1.5))
+	s.Equal([]int{0, 0, 1, 2}, d.Cut(2, 1.5), "Both limits must be applied")
+	s.Equal([]int{0, 0, 0, 0}, d.Cut(1, 0))
+	s.Equal([]int{0, 1, 2, 3}, d.Cut(10, 0))
+}
+
+func (s *hierarchicalTestSuite) TestDendrogramOutput() {
+	d := Agglomerate(hierarchicalTestPoints, SingleLinkage)
+	labels := []string{"a", "b", "c", "d"}
+	var buf bytes.Buffer
+	s.NoError(d.WriteJson(&buf, labels))
+	var root dendrogramNode
+	s.NoError(json.Unmarshal(buf.Bytes(), &root))
+	s.Equal(4, root.Size)
+	s.Equal(4.0, root.Height)
+	s.Len(root.Children, 2)
+
+	buf.Reset()
+	s.NoError(d.WriteGraphviz(&buf, labels))
+	s.Contains(buf.String(), "digraph dendrogram {")
+	s.Contains(buf.String(), "n0 [label=\"a\"];")
+	s.Contains(buf.String(), "n6 -> n3;")
+}
+
+func (s *hierarchicalTestSuite) TestProcessBatch() {
+	dir, err := ioutil.TempDir("", "bitflow-dendrogram-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "dendrogram.dot")
+
+	step := &HierarchicalClustering{Linkage: CompleteLinkage, K: 2, Tag: ClusterTag, LabelTag: "name", Dendrogram: file}
+	s.NoError(step.validate())
+	s.Equal(DendrogramGraphviz, step.Format)
+	var samples []*bitflow.Sample
+	for i, point := range hierarchicalTestPoints {
+		samples = append(samples, testsupport.NewSample(0, "name="+string(rune('a'+i)), bitflow.Value(point[0])))
+	}
+	_, samples, err = step.ProcessBatch(&bitflow.Header{Fields: []string{"x"}}, samples)
+	s.NoError(err)
+	var clusters []string
+	for _, sample := range samples {
+		clusters = append(clusters, sample.Tag(ClusterTag))
+	}
+	s.Equal([]string{"0", "0", "0", "1"}, clusters)
+	content, err := ioutil.ReadFile(file)
+	s.NoError(err)
+	s.Contains(string(content), "n3 [label=\"d\"];")
+}
+
+func (s *hierarchicalTestSuite) TestValidate() {
+	s.Error((&HierarchicalClustering{Linkage: "centroid", K: 2}).validate())
+	s.Error((&HierarchicalClustering{Linkage: SingleLinkage}).validate())
+	s.Error((&HierarchicalClustering{Linkage: SingleLinkage, K: 2, Format: "xml"}).validate())
+	step := &HierarchicalClustering{Linkage: SingleLinkage, Dendrogram: "out.json"}
+	s.NoError(step.validate())
+	s.Equal(DendrogramJson, step.Format)
+}
@@ -0,0 +1,470 @@
+package math
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// ClusterTag is the tag that clustering steps use to store the cluster of a sample, unless configured otherwise.
+const ClusterTag = "cluster"
+
+const (
+	DefaultKMeansIterations = 100
+	DefaultKMeansMaxK       = 10
+	DefaultKMeansBatchSize  = 100
+
+	KSelectionElbow      = "elbow"
+	KSelectionSilhouette = "silhouette"
+)
+
+func RegisterKMeans(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("kmeans",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &BatchKMeans{
+				K:          reg.IntParam(params, "k", 0, true, &err),
+				MaxK:       reg.IntParam(params, "max_k", DefaultKMeansMaxK, true, &err),
+				Selection:  reg.StrParam(params, "select", KSelectionSilhouette, true, &err),
+				Iterations: reg.IntParam(params, "iterations", DefaultKMeansIterations, true, &err),
+				Tag:        reg.StrParam(params, "tag", ClusterTag, true, &err),
+				Seed:       int64(reg.IntParam(params, "seed", 1, true, &err)),
+			}
+			file := reg.StrParam(params, "store", "", true, &err)
+			if err == nil {
+				err = step.validate()
+			}
+			if err == nil {
+				if file != "" {
+					step.Store = models.NewStore(file)
+				}
+				p.Batch(step)
+			}
+			return
+		},
+		fmt.Sprintf("Cluster a batch of samples using k-means (with k-means++ initialization) and store the cluster index of every sample in the given tag (default '%v'). ", ClusterTag)+
+			fmt.Sprintf("If k is not given, it is selected automatically up to max_k, using either the %v or the %v method. ", KSelectionElbow, KSelectionSilhouette)+
+			"If the store parameter is given, the cluster centers are stored to that model location, to be used by kmeans_load_stream.",
+		reg.OptionalParams("k", "max_k", "select", "iterations", "tag", "seed", "store"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("kmeans_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &StreamingKMeans{
+				K:         reg.IntParam(params, "k", 0, false, &err),
+				BatchSize: reg.IntParam(params, "batch", DefaultKMeansBatchSize, true, &err),
+				Tag:       reg.StrParam(params, "tag", ClusterTag, true, &err),
+				Seed:      int64(reg.IntParam(params, "seed", 1, true, &err)),
+			}
+			if err == nil && step.K < 1 {
+				err = reg.ParameterError("k", fmt.Errorf("Must be positive"))
+			}
+			if err == nil && step.BatchSize < step.K {
+				err = reg.ParameterError("batch", fmt.Errorf("Must be at least k (%v)", step.K))
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		"Cluster samples using mini-batch k-means and store the cluster index of every sample in the given tag. "+
+			"The first mini-batch is buffered to initialize the cluster centers. Afterwards, every sample is assigned to the nearest center immediately "+
+			"and the centers are updated whenever a mini-batch of samples is complete.",
+		reg.RequiredParams("k"), reg.OptionalParams("batch", "tag", "seed"))
+
+	b.RegisterAnalysisParamsErr("kmeans_load_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			tag := reg.StrParam(params, "tag", ClusterTag, true, &err)
+			if err == nil {
+				model := new(KMeansModel)
+				if _, err = models.Load(params["file"], KMeansModelType, model); err == nil {
+					p.Add(&KMeansClassifier{Model: model, Tag: tag})
+				}
+			}
+			return
+		},
+		"Load a k-means model stored by the kmeans step and store the index of the nearest cluster center of every sample in the given tag.",
+		reg.RequiredParams("file"), reg.OptionalParams("tag"))
+}
+
+// KMeansModel contains the cluster centers computed by k-means.
+type KMeansModel struct {
+	Fields  []string
+	Centers [][]float64
+}
+
+// Nearest returns the index of the nearest cluster center and the distance to it.
+func (m *KMeansModel) Nearest(point []float64) (int, float64) {
+	best, bestDist := -1, math.Inf(1)
+	for i, center := range m.Centers {
+		if dist := euclideanDistance(point, center); dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best, bestDist
+}
+
+// Inertia returns the sum of squared distances of all points to their nearest center.
+func (m *KMeansModel) Inertia(points [][]float64) float64 {
+	var sum float64
+	for _, point := range points {
+		_, dist := m.Nearest(point)
+		sum += dist * dist
+	}
+	return sum
+}
+
+func (m *KMeansModel) String() string {
+	return fmt.Sprintf("k-means model (%v clusters, %v metrics)", len(m.Centers), len(m.Fields))
+}
+
+// initCenters chooses initial cluster centers using k-means++
+func (m *KMeansModel) initCenters(points [][]float64, k int, rnd *rand.Rand) {
+	m.Centers = [][]float64{copyVector(points[rnd.Intn(len(points))])}
+	distances := make([]float64, len(points))
+	for len(m.Centers) < k {
+		var sum float64
+		for i, point := range points {
+			_, dist := m.Nearest(point)
+			distances[i] = dist * dist
+			sum += distances[i]
+		}
+		if sum == 0 {
+			// Less distinct points than clusters
+			break
+		}
+		target := rnd.Float64() * sum
+		next := len(points) - 1
+		for i, dist := range distances {
+			if target -= dist; target < 0 {
+				next = i
+				break
+			}
+		}
+		m.Centers = append(m.Centers, copyVector(points[next]))
+	}
+}
+
+// Train runs Lloyd's algorithm until the assignments do not change, or the maximum number of iterations is reached.
+// The result contains the cluster index of every point.
+func (m *KMeansModel) Train(points [][]float64, k int, iterations int, rnd *rand.Rand) []int {
+	m.initCenters(points, k, rnd)
+	assignments := make([]int, len(points))
+	for iteration := 0; iteration < iterations; iteration++ {
+		changed := false
+		for i, point := range points {
+			if cluster, _ := m.Nearest(point); iteration == 0 || cluster != assignments[i] {
+				changed = true
+				assignments[i] = cluster
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([][]float64, len(m.Centers))
+		counts := make([]int, len(m.Centers))
+		for i, point := range points {
+			cluster := assignments[i]
+			if sums[cluster] == nil {
+				sums[cluster] = make([]float64, len(point))
+			}
+			for j, val := range point {
+				sums[cluster][j] += val
+			}
+			counts[cluster]++
+		}
+		for cluster, sum := range sums {
+			if counts[cluster] > 0 {
+				for j := range sum {
+					m.Centers[cluster][j] = sum[j] / float64(counts[cluster])
+				}
+			}
+		}
+	}
+	return assignments
+}
+
+func copyVector(vec []float64) []float64 {
+	return append([]float64(nil), vec...)
+}
+
+// Silhouette computes the mean silhouette coefficient of a clustering. The runtime is quadratic in the number of points.
+func Silhouette(points [][]float64, assignments []int, k int) float64 {
+	var total float64
+	counts := make([]int, k)
+	for _, cluster := range assignments {
+		counts[cluster]++
+	}
+	for i, point := range points {
+		own := assignments[i]
+		if counts[own] <= 1 {
+			continue // Silhouette is 0 for singleton clusters
+		}
+		sums := make([]float64, k)
+		for j, other := range points {
+			if i != j {
+				sums[assignments[j]] += euclideanDistance(point, other)
+			}
+		}
+		a := sums[own] / float64(counts[own]-1)
+		b := math.Inf(1)
+		for cluster, sum := range sums {
+			if cluster != own && counts[cluster] > 0 {
+				b = math.Min(b, sum/float64(counts[cluster]))
+			}
+		}
+		if !math.IsInf(b, 1) {
+			total += (b - a) / math.Max(a, b)
+		}
+	}
+	return total / float64(len(points))
+}
+
+// BatchKMeans clusters a batch of samples using k-means. If K is <= 0, it is selected automatically.
+type BatchKMeans struct {
+	K          int
+	MaxK       int
+	Selection  string
+	Iterations int
+	Tag        string
+	Seed       int64
+	Store      *models.Store // Optional
+}
+
+func (s *BatchKMeans) validate() error {
+	if s.K <= 0 && s.MaxK < 2 {
+		return reg.ParameterError("max_k", fmt.Errorf("Must be at least 2"))
+	}
+	if s.Selection != KSelectionElbow && s.Selection != KSelectionSilhouette {
+		return reg.ParameterError("select", fmt.Errorf("Must be %v or %v", KSelectionElbow, KSelectionSilhouette))
+	}
+	if s.Iterations < 1 {
+		return reg.ParameterError("iterations", fmt.Errorf("Must be positive"))
+	}
+	return nil
+}
+
+func (s *BatchKMeans) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	points := make([][]float64, len(samples))
+	for i, sample := range samples {
+		points[i] = steps.SampleToVector(sample)
+	}
+	rnd := rand.New(rand.NewSource(s.Seed))
+	var model *KMeansModel
+	var assignments []int
+	if s.K > 0 {
+		model = &KMeansModel{Fields: header.Fields}
+		assignments = model.Train(points, s.K, s.Iterations, rnd)
+	} else {
+		model, assignments = s.selectK(header, points, rnd)
+	}
+
+	if s.Store != nil {
+		if err := storeModel(s.Store, KMeansModelType, header.Fields, model); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, sample := range samples {
+		sample.SetTag(s.Tag, strconv.Itoa(assignments[i]))
+	}
+	return header, samples, nil
+}
+
+func (s *BatchKMeans) selectK(header *bitflow.Header, points [][]float64, rnd *rand.Rand) (*KMeansModel, []int) {
+	maxK := s.MaxK
+	if maxK > len(points) {
+		maxK = len(points)
+	}
+	var models []*KMeansModel
+	var allAssignments [][]int
+	scores := make([]float64, 0, maxK)
+	for k := 1; k <= maxK; k++ {
+		model := &KMeansModel{Fields: header.Fields}
+		assignments := model.Train(points, k, s.Iterations, rnd)
+		models = append(models, model)
+		allAssignments = append(allAssignments, assignments)
+		if s.Selection == KSelectionElbow {
+			scores = append(scores, model.Inertia(points))
+		} else if k == 1 {
+			scores = append(scores, math.Inf(-1)) // The silhouette is not defined for one cluster
+		} else {
+			scores = append(scores, Silhouette(points, assignments, k))
+		}
+	}
+
+	best := 0
+	if s.Selection == KSelectionElbow {
+		best = elbow(scores)
+	} else {
+		for i, score := range scores {
+			if score > scores[best] {
+				best = i
+			}
+		}
+	}
+	log.Debugf("%v: selected k=%v (%v scores: %v)", s, best+1, s.Selection, scores)
+	return models[best], allAssignments[best]
+}
+
+// elbow returns the index of the point of the curve with the largest distance to the straight line between
+// the first and last point.
+func elbow(curve []float64) int {
+	last := len(curve) - 1
+	if last < 2 {
+		return last
+	}
+	best, bestDist := 0, math.Inf(-1)
+	for i, val := range curve {
+		// Since the x-values are equidistant, the vertical distance is proportional to the perpendicular distance
+		expected := curve[0] + (curve[last]-curve[0])*float64(i)/float64(last)
+		if dist := expected - val; dist > bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+func (s *BatchKMeans) String() string {
+	var res string
+	if s.K > 0 {
+		res = fmt.Sprintf("k-means (k=%v", s.K)
+	} else {
+		res = fmt.Sprintf("k-means (select k <= %v by %v", s.MaxK, s.Selection)
+	}
+	res += fmt.Sprintf(", tag %v)", s.Tag)
+	if s.Store != nil {
+		res += fmt.Sprintf(" (store model to %v)", s.Store)
+	}
+	return res
+}
+
+// StreamingKMeans implements mini-batch k-means (Sculley, 2010). The first BatchSize samples are buffered to
+// initialize the centers, afterwards every sample is forwarded immediately.
+type StreamingKMeans struct {
+	bitflow.NoopProcessor
+	K         int
+	BatchSize int
+	Tag       string
+	Seed      int64
+
+	model   *KMeansModel
+	rnd     *rand.Rand
+	checker bitflow.HeaderChecker
+	counts  []int
+	batch   [][]float64
+
+	initSamples []*bitflow.Sample
+	initHeader  *bitflow.Header
+}
+
+func (s *StreamingKMeans) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if s.checker.HeaderChanged(header) {
+		if err := s.flushInitSamples(); err != nil {
+			return err
+		}
+		s.model = nil
+		s.batch = nil
+		s.rnd = rand.New(rand.NewSource(s.Seed))
+	}
+	point := steps.SampleToVector(sample)
+	if s.model == nil {
+		s.initSamples = append(s.initSamples, sample)
+		s.initHeader = header
+		s.batch = append(s.batch, point)
+		if len(s.batch) < s.BatchSize {
+			return nil
+		}
+		return s.flushInitSamples()
+	}
+
+	cluster, _ := s.model.Nearest(point)
+	sample.SetTag(s.Tag, strconv.Itoa(cluster))
+	s.batch = append(s.batch, point)
+	if len(s.batch) >= s.BatchSize {
+		s.update()
+	}
+	return s.NoopProcessor.Sample(sample, header)
+}
+
+// flushInitSamples initializes the model with the buffered samples, and forwards them.
+func (s *StreamingKMeans) flushInitSamples() error {
+	if len(s.initSamples) == 0 {
+		return nil
+	}
+	s.model = &KMeansModel{Fields: s.initHeader.Fields}
+	s.model.initCenters(s.batch, s.K, s.rnd)
+	s.counts = make([]int, len(s.model.Centers))
+	s.update()
+
+	samples, header := s.initSamples, s.initHeader
+	s.initSamples, s.initHeader = nil, nil
+	for _, sample := range samples {
+		cluster, _ := s.model.Nearest(steps.SampleToVector(sample))
+		sample.SetTag(s.Tag, strconv.Itoa(cluster))
+		if err := s.NoopProcessor.Sample(sample, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update moves the centers towards the points of the current mini-batch, with a per-center learning rate
+func (s *StreamingKMeans) update() {
+	assignments := make([]int, len(s.batch))
+	for i, point := range s.batch {
+		assignments[i], _ = s.model.Nearest(point)
+	}
+	for i, point := range s.batch {
+		cluster := assignments[i]
+		s.counts[cluster]++
+		rate := 1 / float64(s.counts[cluster])
+		center := s.model.Centers[cluster]
+		for j, val := range point {
+			center[j] = (1-rate)*center[j] + rate*val
+		}
+	}
+	s.batch = s.batch[:0]
+}
+
+func (s *StreamingKMeans) Close() {
+	if err := s.flushInitSamples(); err != nil {
+		s.Error(err)
+	}
+	s.NoopProcessor.Close()
+}
+
+func (s *StreamingKMeans) String() string {
+	return fmt.Sprintf("Streaming mini-batch k-means (k=%v, batch %v, tag %v)", s.K, s.BatchSize, s.Tag)
+}
+
+// KMeansClassifier assigns every sample to the nearest center of a previously trained k-means model.
+type KMeansClassifier struct {
+	bitflow.NoopProcessor
+	Model *KMeansModel
+	Tag   string
+
+	checker bitflow.HeaderChecker
+}
+
+func (c *KMeansClassifier) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if c.checker.HeaderChanged(header) {
+		if err := checkModelFields(c.Model.Fields, header); err != nil {
+			return fmt.Errorf("%v: %v", c, err)
+		}
+	}
+	cluster, _ := c.Model.Nearest(steps.SampleToVector(sample))
+	sample.SetTag(c.Tag, strconv.Itoa(cluster))
+	return c.NoopProcessor.Sample(sample, header)
+}
+
+func (c *KMeansClassifier) String() string {
+	return fmt.Sprintf("Assign samples to clusters of %v (tag %v)", c.Model, c.Tag)
+}
@@ -0,0 +1,123 @@
+package math
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type kmeansTestSuite struct {
+	testsupport.Suite
+}
+
+func TestKMeans(t *testing.T) {
+	suite.Run(t, new(kmeansTestSuite))
+}
+
+var (
+	kmeansTestHeader = &bitflow.Header{Fields: []string{"x", "y"}}
+	kmeansTestBlobs  = [][]float64{{0, 0}, {10, 10}, {20, 0}}
+)
+
+// blobSamples returns num samples per blob, scattered around the blob centers and tagged with the index of their blob.
+// The blobs alternate, so that every prefix of the samples contains all blobs.
+func (s *kmeansTestSuite) blobSamples(num int) []*bitflow.Sample {
+	rnd := rand.New(rand.NewSource(2))
+	var res []*bitflow.Sample
+	for i := 0; i < num; i++ {
+		for blob, center := range kmeansTestBlobs {
+			sample := testsupport.NewSample(0, "", bitflow.Value(center[0]+rnd.Float64()-0.5), bitflow.Value(center[1]+rnd.Float64()-0.5))
+			sample.SetTag("blob", string(rune('a'+blob)))
+			res = append(res, sample)
+		}
+	}
+	return res
+}
+
+// assertClusters checks that samples are in the same cluster exactly if they belong to the same blob
+func (s *kmeansTestSuite) assertClusters(samples []*bitflow.Sample, clusterTag string) {
+	blobClusters := make(map[string]string)
+	clusterBlobs := make(map[string]string)
+	for _, sample := range samples {
+		blob, cluster := sample.Tag("blob"), sample.Tag(clusterTag)
+		s.NotEmpty(cluster)
+		if expected, ok := blobClusters[blob]; ok {
+			s.Equal(expected, cluster, "Samples of blob %v must be in the same cluster", blob)
+		}
+		if expected, ok := clusterBlobs[cluster]; ok {
+			s.Equal(expected, blob, "Cluster %v must contain only one blob", cluster)
+		}
+		blobClusters[blob] = cluster
+		clusterBlobs[cluster] = blob
+	}
+	s.Len(blobClusters, len(kmeansTestBlobs))
+}
+
+func (s *kmeansTestSuite) TestFixedK() {
+	step := &BatchKMeans{K: 3, Iterations: DefaultKMeansIterations, Tag: ClusterTag, Seed: 1, Selection: KSelectionSilhouette}
+	s.NoError(step.validate())
+	_, samples, err := step.ProcessBatch(kmeansTestHeader, s.blobSamples(20))
+	s.NoError(err)
+	s.assertClusters(samples, ClusterTag)
+}
+
+func (s *kmeansTestSuite) TestSelectK() {
+	for _, selection := range []string{KSelectionSilhouette, KSelectionElbow} {
+		step := &BatchKMeans{MaxK: 6, Selection: selection, Iterations: DefaultKMeansIterations, Tag: "c", Seed: 1}
+		s.NoError(step.validate())
+		_, samples, err := step.ProcessBatch(kmeansTestHeader, s.blobSamples(20))
+		s.NoError(err)
+		s.assertClusters(samples, "c")
+	}
+}
+
+func (s *kmeansTestSuite) TestTrain() {
+	var points [][]float64
+	for _, sample := range s.blobSamples(50) {
+		points = append(points, []float64{float64(sample.Values[0]), float64(sample.Values[1])})
+	}
+	model := new(KMeansModel)
+	model.Train(points, 3, DefaultKMeansIterations, rand.New(rand.NewSource(1)))
+	s.Len(model.Centers, 3)
+	for _, blob := range kmeansTestBlobs {
+		index, dist := model.Nearest(blob)
+		s.True(dist < 0.2, "Blob center %v is too far from the nearest cluster center %v", blob, model.Centers[index])
+	}
+
+	// Less distinct points than clusters
+	model.Train([][]float64{{1}, {1}, {2}}, 3, DefaultKMeansIterations, rand.New(rand.NewSource(1)))
+	s.Len(model.Centers, 2)
+}
+
+func (s *kmeansTestSuite) TestSilhouetteAndElbow() {
+	points := [][]float64{{0}, {1}, {10}, {11}}
+	expected := (2*9.5/10.5 + 2*8.5/9.5) / 4
+	s.InDelta(expected, Silhouette(points, []int{0, 0, 1, 1}, 2), 1e-9)
+	s.True(Silhouette(points, []int{0, 1, 0, 1}, 2) < 0, "A bad clustering must have a negative silhouette")
+	s.Equal(0.0, Silhouette(points, []int{0, 1, 2, 3}, 4), "Singleton clusters have a silhouette of 0")
+
+	s.Equal(1, elbow([]float64{100, 20, 10, 8}))
+	s.Equal(2, elbow([]float64{100, 90, 10, 8, 7}))
+	s.Equal(1, elbow([]float64{5, 3}))
+}
+
+func (s *kmeansTestSuite) TestStreaming() {
+	step := &StreamingKMeans{K: 3, BatchSize: 30, Tag: ClusterTag, Seed: 1}
+	out := s.Process(step, kmeansTestHeader, s.blobSamples(100)...)
+	out.AssertCount(s.T(), 300)
+	s.assertClusters(out.Samples(), ClusterTag)
+
+	// Samples buffered for the initialization are forwarded when the input ends
+	out = s.Process(&StreamingKMeans{K: 2, BatchSize: 100, Tag: ClusterTag, Seed: 1}, kmeansTestHeader, s.blobSamples(2)...)
+	out.AssertCount(s.T(), 6)
+	s.NotContains(out.Tags(ClusterTag), "")
+}
+
+func (s *kmeansTestSuite) TestValidate() {
+	s.Error((&BatchKMeans{MaxK: 1, Selection: KSelectionElbow, Iterations: 1}).validate())
+	s.Error((&BatchKMeans{K: 2, Selection: "other", Iterations: 1}).validate())
+	s.Error((&BatchKMeans{K: 2, Selection: KSelectionElbow}).validate())
+}
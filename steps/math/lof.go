@@ -0,0 +1,199 @@
+package math
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+)
+
+const (
+	LofField            = "lof"
+	DefaultLofNeighbors = 10
+	DefaultLofThreshold = 1.5
+)
+
+func RegisterLOF(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("lof",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			neighbors := reg.IntParam(params, "k", DefaultLofNeighbors, true, &err)
+			scorer := parseOutlierScorerParams(params, DefaultLofThreshold, &err)
+			file := reg.StrParam(params, "store", "", true, &err)
+			if err == nil && neighbors < 1 {
+				err = reg.ParameterError("k", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				step := &BatchLOF{Neighbors: neighbors, Scorer: scorer}
+				if file != "" {
+					step.Store = models.NewStore(file)
+				}
+				p.Batch(step)
+			}
+			return
+		},
+		"Compute the Local Outlier Factor of every sample in a batch, based on the k nearest neighbors, and append it as metric '"+LofField+"'. "+
+			"Values considerably larger than 1 indicate outliers. If the tag parameter is given, samples with a score above the threshold are tagged as '"+OutlierTagValue+"', all others as '"+InlierTagValue+"'. "+
+			"If the store parameter is given, the model is stored to that model location, to be used by lof_load_stream.",
+		reg.OptionalParams("k", "threshold", "tag", "store"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("lof_load_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			scorer := parseOutlierScorerParams(params, DefaultLofThreshold, &err)
+			if err == nil {
+				model := new(LOFModel)
+				if _, err = models.Load(params["file"], LofModelType, model); err == nil {
+					scorer.Model = model
+					p.Add(scorer)
+				}
+			}
+			return
+		},
+		"Load a Local Outlier Factor model stored by the lof step and score every incoming sample against it. The parameters are the same as for the lof step.",
+		reg.RequiredParams("file"), reg.OptionalParams("threshold", "tag"))
+}
+
+// LOFModel contains the training data for the Local Outlier Factor, along with the precomputed
+// k-distance and local reachability density of every training point.
+type LOFModel struct {
+	Fields     []string
+	Neighbors  int
+	Points     [][]float64
+	KDistances []float64
+	Densities  []float64
+}
+
+type lofNeighbor struct {
+	index    int
+	distance float64
+}
+
+func (m *LOFModel) Compute(header *bitflow.Header, samples []*bitflow.Sample, neighbors int) {
+	m.Fields = header.Fields
+	m.Points = make([][]float64, len(samples))
+	for i, sample := range samples {
+		m.Points[i] = steps.SampleToVector(sample)
+	}
+	m.Neighbors = neighbors
+	if m.Neighbors > len(m.Points)-1 {
+		m.Neighbors = len(m.Points) - 1
+	}
+
+	allNeighbors := make([][]lofNeighbor, len(m.Points))
+	m.KDistances = make([]float64, len(m.Points))
+	for i, point := range m.Points {
+		allNeighbors[i] = m.nearestNeighbors(point, i)
+		if len(allNeighbors[i]) > 0 {
+			m.KDistances[i] = allNeighbors[i][len(allNeighbors[i])-1].distance
+		}
+	}
+	m.Densities = make([]float64, len(m.Points))
+	for i, neighbors := range allNeighbors {
+		m.Densities[i] = m.density(neighbors)
+	}
+}
+
+// nearestNeighbors returns the k nearest training points, excluding the point with the given index (-1 to include all)
+func (m *LOFModel) nearestNeighbors(point []float64, exclude int) []lofNeighbor {
+	neighbors := make([]lofNeighbor, 0, len(m.Points))
+	for i, other := range m.Points {
+		if i != exclude {
+			neighbors = append(neighbors, lofNeighbor{index: i, distance: euclideanDistance(point, other)})
+		}
+	}
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].distance < neighbors[j].distance
+	})
+	if len(neighbors) > m.Neighbors {
+		neighbors = neighbors[:m.Neighbors]
+	}
+	return neighbors
+}
+
+// density computes the local reachability density of a point with the given neighbors
+func (m *LOFModel) density(neighbors []lofNeighbor) float64 {
+	if len(neighbors) == 0 {
+		return math.NaN()
+	}
+	var sum float64
+	for _, neighbor := range neighbors {
+		sum += math.Max(neighbor.distance, m.KDistances[neighbor.index])
+	}
+	// Avoid infinite densities for duplicate points
+	return 1 / (sum/float64(len(neighbors)) + 1e-10)
+}
+
+func (m *LOFModel) factor(density float64, neighbors []lofNeighbor) float64 {
+	if len(neighbors) == 0 {
+		return math.NaN()
+	}
+	var sum float64
+	for _, neighbor := range neighbors {
+		sum += m.Densities[neighbor.index]
+	}
+	return sum / float64(len(neighbors)) / density
+}
+
+// TrainingScore returns the Local Outlier Factor of the training point with the given index.
+func (m *LOFModel) TrainingScore(index int) float64 {
+	return m.factor(m.Densities[index], m.nearestNeighbors(m.Points[index], index))
+}
+
+// Score returns the Local Outlier Factor of a new point with respect to the training points.
+func (m *LOFModel) Score(point []float64) float64 {
+	neighbors := m.nearestNeighbors(point, -1)
+	return m.factor(m.density(neighbors), neighbors)
+}
+
+func (m *LOFModel) ScoreField() string {
+	return LofField
+}
+
+func (m *LOFModel) ModelFields() []string {
+	return m.Fields
+}
+
+func (m *LOFModel) IsOutlier(score float64, threshold float64) bool {
+	return score > threshold
+}
+
+func (m *LOFModel) String() string {
+	return fmt.Sprintf("LOF model (k=%v, %v points, %v metrics)", m.Neighbors, len(m.Points), len(m.Fields))
+}
+
+// BatchLOF computes the Local Outlier Factor of every sample in a batch, relative to the other samples in the batch.
+type BatchLOF struct {
+	Neighbors int
+	Scorer    *OutlierScorer
+	Store     *models.Store // Optional
+}
+
+func (l *BatchLOF) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	model := new(LOFModel)
+	model.Compute(header, samples, l.Neighbors)
+	if l.Store != nil {
+		if err := storeModel(l.Store, LofModelType, header.Fields, model); err != nil {
+			return nil, nil, err
+		}
+	}
+	scores := make([]float64, len(samples))
+	for i := range samples {
+		scores[i] = model.TrainingScore(i)
+	}
+	outHeader := l.Scorer.apply(model, header, samples, scores)
+	return outHeader, samples, nil
+}
+
+func (l *BatchLOF) String() string {
+	res := fmt.Sprintf("Local Outlier Factor (k=%v, %v)", l.Neighbors, l.Scorer.paramString())
+	if l.Store != nil {
+		res += fmt.Sprintf(" (store model to %v)", l.Store)
+	}
+	return res
+}
@@ -0,0 +1,48 @@
+package math
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+)
+
+// Types of the models that can be stored and loaded through the models package
+const (
+	PcaModelType        = "pca"
+	LofModelType        = "lof"
+	OcsvmModelType      = "ocsvm"
+	KMeansModelType     = "kmeans"
+	RegressionModelType = "linear_regression"
+	ArimaModelType      = "arima"
+)
+
+func storeModel(store *models.Store, modelType string, fields []string, model fmt.Stringer) error {
+	return store.Save(models.Metadata{
+		Type:        modelType,
+		Fields:      fields,
+		Description: model.String(),
+	}, model)
+}
+
+func checkModelFields(modelFields []string, header *bitflow.Header) error {
+	if len(modelFields) != len(header.Fields) {
+		return fmt.Errorf("The model was trained on %v metrics, but the samples have %v", len(modelFields), len(header.Fields))
+	}
+	for i, field := range modelFields {
+		if header.Fields[i] != field {
+			return fmt.Errorf("Metric %v of the samples (%v) does not match the model (%v)", i, header.Fields[i], field)
+		}
+	}
+	return nil
+}
+
+func euclideanDistance(a, b []float64) float64 {
+	var sum float64
+	for i, val := range a {
+		diff := val - b[i]
+		sum += diff * diff
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,246 @@
+package math
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	OcsvmField            = "ocsvm"
+	DefaultOcsvmNu        = 0.1
+	DefaultOcsvmTolerance = 1e-3
+	DefaultOcsvmMaxIter   = 100000
+)
+
+func RegisterOneClassSVM(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("ocsvm",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &BatchOneClassSVM{
+				Nu:    reg.FloatParam(params, "nu", DefaultOcsvmNu, true, &err),
+				Gamma: reg.FloatParam(params, "gamma", 0, true, &err),
+			}
+			step.Scorer = parseOutlierScorerParams(params, 0, &err)
+			file := reg.StrParam(params, "store", "", true, &err)
+			if err == nil && (step.Nu <= 0 || step.Nu > 1) {
+				err = reg.ParameterError("nu", fmt.Errorf("Must be in ]0..1]"))
+			}
+			if err == nil && step.Gamma < 0 {
+				err = reg.ParameterError("gamma", fmt.Errorf("Must not be negative"))
+			}
+			if err == nil {
+				if file != "" {
+					step.Store = models.NewStore(file)
+				}
+				p.Batch(step)
+			}
+			return
+		},
+		"Train a one-class SVM with an RBF kernel on a batch of samples and append the decision value of every sample as metric '"+OcsvmField+"'. "+
+			"Negative values indicate outliers. The parameter nu is an upper bound for the fraction of outliers in the training data. "+
+			"The kernel parameter gamma defaults to 1/<number of metrics>, the metrics should be scaled beforehand. "+
+			"If the tag parameter is given, samples with a decision value below the threshold (default 0) are tagged as '"+OutlierTagValue+"', all others as '"+InlierTagValue+"'. "+
+			"If the store parameter is given, the model is stored to that model location, to be used by ocsvm_load_stream.",
+		reg.OptionalParams("nu", "gamma", "threshold", "tag", "store"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("ocsvm_load_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			scorer := parseOutlierScorerParams(params, 0, &err)
+			if err == nil {
+				model := new(OneClassSVMModel)
+				if _, err = models.Load(params["file"], OcsvmModelType, model); err == nil {
+					scorer.Model = model
+					p.Add(scorer)
+				}
+			}
+			return
+		},
+		"Load a one-class SVM model stored by the ocsvm step and score every incoming sample against it. The parameters are the same as for the ocsvm step.",
+		reg.RequiredParams("file"), reg.OptionalParams("threshold", "tag"))
+}
+
+// OneClassSVMModel is a trained one-class SVM (Schölkopf et al.) with a Gaussian RBF kernel.
+// Only the support vectors are stored.
+type OneClassSVMModel struct {
+	Fields         []string
+	Gamma          float64
+	Rho            float64
+	SupportVectors [][]float64
+	Coefficients   []float64
+}
+
+func (m *OneClassSVMModel) kernel(a, b []float64) float64 {
+	var sum float64
+	for i, val := range a {
+		diff := val - b[i]
+		sum += diff * diff
+	}
+	return math.Exp(-m.Gamma * sum)
+}
+
+// Train solves the dual problem of the one-class SVM using sequential minimal optimization (SMO) with
+// maximal violating pairs, following the formulation of LIBSVM: minimize 0.5 a'Ka subject to 0 <= a_i <= 1 and
+// sum(a) = nu * n. The full kernel matrix is computed, so the memory consumption is quadratic in the number of samples.
+func (m *OneClassSVMModel) Train(header *bitflow.Header, samples []*bitflow.Sample, nu float64, gamma float64) {
+	m.Fields = header.Fields
+	m.Gamma = gamma
+	if m.Gamma <= 0 {
+		m.Gamma = 1 / float64(len(header.Fields))
+	}
+	n := len(samples)
+	points := make([][]float64, n)
+	for i, sample := range samples {
+		points[i] = steps.SampleToVector(sample)
+	}
+	kernel := make([][]float64, n)
+	for i := range kernel {
+		kernel[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			kernel[i][j] = m.kernel(points[i], points[j])
+			kernel[j][i] = kernel[i][j]
+		}
+	}
+
+	// Initial feasible solution
+	alpha := make([]float64, n)
+	remaining := nu * float64(n)
+	for i := 0; i < n && remaining > 0; i++ {
+		alpha[i] = math.Min(1, remaining)
+		remaining -= alpha[i]
+	}
+	gradient := make([]float64, n)
+	for i := range gradient {
+		for j, a := range alpha {
+			gradient[i] += kernel[i][j] * a
+		}
+	}
+
+	iteration := 0
+	for ; iteration < DefaultOcsvmMaxIter; iteration++ {
+		// i: increasing alpha decreases the objective the most, j: decreasing alpha decreases the objective the most
+		i, j := -1, -1
+		for k, a := range alpha {
+			if a < 1 && (i < 0 || gradient[k] < gradient[i]) {
+				i = k
+			}
+			if a > 0 && (j < 0 || gradient[k] > gradient[j]) {
+				j = k
+			}
+		}
+		if i < 0 || j < 0 || gradient[j]-gradient[i] < DefaultOcsvmTolerance {
+			break
+		}
+		quad := kernel[i][i] + kernel[j][j] - 2*kernel[i][j]
+		if quad <= 0 {
+			quad = 1e-12
+		}
+		step := (gradient[j] - gradient[i]) / quad
+		step = math.Min(step, math.Min(1-alpha[i], alpha[j]))
+		alpha[i] += step
+		alpha[j] -= step
+		for k := range gradient {
+			gradient[k] += step * (kernel[k][i] - kernel[k][j])
+		}
+	}
+	if iteration == DefaultOcsvmMaxIter {
+		log.Warnf("One-class SVM did not converge after %v iterations", iteration)
+	}
+
+	// Rho is the gradient at free support vectors, or the middle of the feasible interval if there are none
+	var freeSum float64
+	var freeNum int
+	upper, lower := math.Inf(1), math.Inf(-1)
+	for k, a := range alpha {
+		switch {
+		case a > 0 && a < 1:
+			freeSum += gradient[k]
+			freeNum++
+		case a == 0:
+			upper = math.Min(upper, gradient[k])
+		default:
+			lower = math.Max(lower, gradient[k])
+		}
+	}
+	if freeNum > 0 {
+		m.Rho = freeSum / float64(freeNum)
+	} else {
+		m.Rho = (upper + lower) / 2
+	}
+
+	m.SupportVectors = nil
+	m.Coefficients = nil
+	for k, a := range alpha {
+		if a > 0 {
+			m.SupportVectors = append(m.SupportVectors, points[k])
+			m.Coefficients = append(m.Coefficients, a)
+		}
+	}
+}
+
+// Score returns the decision value of the given point: negative values indicate outliers.
+func (m *OneClassSVMModel) Score(point []float64) float64 {
+	var sum float64
+	for i, vector := range m.SupportVectors {
+		sum += m.Coefficients[i] * m.kernel(vector, point)
+	}
+	return sum - m.Rho
+}
+
+func (m *OneClassSVMModel) ScoreField() string {
+	return OcsvmField
+}
+
+func (m *OneClassSVMModel) ModelFields() []string {
+	return m.Fields
+}
+
+func (m *OneClassSVMModel) IsOutlier(score float64, threshold float64) bool {
+	return score < threshold
+}
+
+func (m *OneClassSVMModel) String() string {
+	return fmt.Sprintf("one-class SVM model (gamma %v, %v support vectors, %v metrics)", m.Gamma, len(m.SupportVectors), len(m.Fields))
+}
+
+// BatchOneClassSVM trains a one-class SVM on a batch of samples and scores every sample with the resulting model.
+type BatchOneClassSVM struct {
+	Nu     float64
+	Gamma  float64 // If <= 0, 1/<number of metrics> is used
+	Scorer *OutlierScorer
+	Store  *models.Store // Optional
+}
+
+func (s *BatchOneClassSVM) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	model := new(OneClassSVMModel)
+	model.Train(header, samples, s.Nu, s.Gamma)
+	if s.Store != nil {
+		if err := storeModel(s.Store, OcsvmModelType, header.Fields, model); err != nil {
+			return nil, nil, err
+		}
+	}
+	scores := make([]float64, len(samples))
+	for i, sample := range samples {
+		scores[i] = model.Score(steps.SampleToVector(sample))
+	}
+	return s.Scorer.apply(model, header, samples, scores), samples, nil
+}
+
+func (s *BatchOneClassSVM) String() string {
+	res := fmt.Sprintf("One-class SVM (nu %v", s.Nu)
+	if s.Gamma > 0 {
+		res += fmt.Sprintf(", gamma %v", s.Gamma)
+	}
+	res += ", " + s.Scorer.paramString() + ")"
+	if s.Store != nil {
+		res += fmt.Sprintf(" (store model to %v)", s.Store)
+	}
+	return res
+}
@@ -0,0 +1,95 @@
+package math
+
+import (
+	"fmt"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+)
+
+const (
+	OutlierTagValue = "anomaly"
+	InlierTagValue  = "normal"
+)
+
+// OutlierModel is a trained outlier detector, that computes an outlier score for a vector of metric values.
+type OutlierModel interface {
+	Score(point []float64) float64
+	IsOutlier(score float64, threshold float64) bool
+	ScoreField() string
+	ModelFields() []string
+	String() string
+}
+
+// OutlierScorer appends the outlier score of a model to every sample. If Tag is set, the samples are additionally tagged
+// with OutlierTagValue or InlierTagValue, depending on the threshold.
+type OutlierScorer struct {
+	bitflow.NoopProcessor
+	Model     OutlierModel
+	Threshold float64
+	Tag       string
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+}
+
+func parseOutlierScorerParams(params map[string]string, defaultThreshold float64, err *error) *OutlierScorer {
+	return &OutlierScorer{
+		Threshold: reg.FloatParam(params, "threshold", defaultThreshold, true, err),
+		Tag:       reg.StrParam(params, "tag", "", true, err),
+	}
+}
+
+func (s *OutlierScorer) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if s.checker.HeaderChanged(header) {
+		if err := checkModelFields(s.Model.ModelFields(), header); err != nil {
+			return fmt.Errorf("%v: %v", s, err)
+		}
+		s.outHeader = s.scoreHeader(s.Model, header)
+	}
+	score := s.Model.Score(steps.SampleToVector(sample))
+	s.scoreSample(s.Model, sample, score)
+	return s.NoopProcessor.Sample(sample, s.outHeader)
+}
+
+// apply adds the given scores to a batch of samples and returns the resulting header.
+func (s *OutlierScorer) apply(model OutlierModel, header *bitflow.Header, samples []*bitflow.Sample, scores []float64) *bitflow.Header {
+	for i, sample := range samples {
+		s.scoreSample(model, sample, scores[i])
+	}
+	return s.scoreHeader(model, header)
+}
+
+func (s *OutlierScorer) scoreHeader(model OutlierModel, header *bitflow.Header) *bitflow.Header {
+	fields := make([]string, len(header.Fields), len(header.Fields)+1)
+	copy(fields, header.Fields)
+	return header.Clone(append(fields, model.ScoreField()))
+}
+
+func (s *OutlierScorer) scoreSample(model OutlierModel, sample *bitflow.Sample, score float64) {
+	steps.AppendToSample(sample, []float64{score})
+	if s.Tag != "" {
+		if model.IsOutlier(score, s.Threshold) {
+			sample.SetTag(s.Tag, OutlierTagValue)
+		} else {
+			sample.SetTag(s.Tag, InlierTagValue)
+		}
+	}
+}
+
+func (s *OutlierScorer) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 1
+}
+
+func (s *OutlierScorer) paramString() string {
+	res := fmt.Sprintf("threshold %v", s.Threshold)
+	if s.Tag != "" {
+		res += fmt.Sprintf(", tag %v", s.Tag)
+	}
+	return res
+}
+
+func (s *OutlierScorer) String() string {
+	return fmt.Sprintf("Score samples with %v (%v)", s.Model, s.paramString())
+}
@@ -0,0 +1,126 @@
+package math
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+	"github.com/stretchr/testify/suite"
+)
+
+type outliersTestSuite struct {
+	testsupport.Suite
+}
+
+func TestOutliers(t *testing.T) {
+	suite.Run(t, new(outliersTestSuite))
+}
+
+var outlierTestHeader = &bitflow.Header{Fields: []string{"x"}}
+
+func (s *outliersTestSuite) samples(values ...float64) []*bitflow.Sample {
+	res := make([]*bitflow.Sample, len(values))
+	for i, val := range values {
+		res[i] = testsupport.NewSample(0, "", bitflow.Value(val))
+	}
+	return res
+}
+
+// gaussianSamples returns samples with two metrics, normally distributed around zero
+func (s *outliersTestSuite) gaussianSamples(num int) []*bitflow.Sample {
+	rnd := rand.New(rand.NewSource(1))
+	res := make([]*bitflow.Sample, num)
+	for i := range res {
+		res[i] = testsupport.NewSample(0, "", bitflow.Value(rnd.NormFloat64()), bitflow.Value(rnd.NormFloat64()))
+	}
+	return res
+}
+
+func (s *outliersTestSuite) TestLOF() {
+	// Reference values computed with the textbook definition of the Local Outlier Factor (Breunig et al.)
+	model := new(LOFModel)
+	model.Compute(outlierTestHeader, s.samples(0, 1, 2.5, 4.5, 20), 2)
+	for i, expected := range []float64{0.8444444444444444, 1.0125, 1.0101010101010102, 1.1611111111111111, 6.3} {
+		s.InDelta(expected, model.TrainingScore(i), 1e-6, "Point %v", i)
+	}
+	s.InDelta(1.05, model.Score([]float64{3}), 1e-6)
+
+	// The number of neighbors is limited by the number of training points
+	model.Compute(outlierTestHeader, s.samples(0, 1), 5)
+	s.Equal(1, model.Neighbors)
+}
+
+func (s *outliersTestSuite) TestBatchLOF() {
+	step := &BatchLOF{Neighbors: 2, Scorer: &OutlierScorer{Threshold: 2, Tag: "outlier"}}
+	header, samples, err := step.ProcessBatch(outlierTestHeader, s.samples(0, 1, 2.5, 4.5, 20))
+	s.NoError(err)
+	s.Equal([]string{"x", LofField}, header.Fields)
+	s.InDelta(6.3, float64(samples[4].Values[1]), 1e-6)
+	for i, sample := range samples {
+		expected := InlierTagValue
+		if i == 4 {
+			expected = OutlierTagValue
+		}
+		s.Equal(expected, sample.Tag("outlier"), "Sample %v", i)
+	}
+}
+
+func (s *outliersTestSuite) TestOneClassSVM() {
+	const nu = 0.1
+	training := s.gaussianSamples(200)
+	model := new(OneClassSVMModel)
+	model.Train(&bitflow.Header{Fields: []string{"a", "b"}}, training, nu, 0)
+	s.Equal(0.5, model.Gamma, "Gamma must default to 1/<number of metrics>")
+	model.Train(&bitflow.Header{Fields: []string{"a", "b"}}, training, nu, 0.1)
+	s.Equal(0.1, model.Gamma)
+
+	var sum float64
+	for _, coeff := range model.Coefficients {
+		s.True(coeff > 0 && coeff <= 1, "Invalid coefficient %v", coeff)
+		sum += coeff
+	}
+	s.InDelta(nu*float64(len(training)), sum, 1e-6, "The coefficients must sum up to nu * n")
+	s.True(len(model.SupportVectors) >= int(nu*float64(len(training))), "Nu is a lower bound for the fraction of support vectors")
+
+	outliers := 0
+	for _, sample := range training {
+		// Samples within the tolerance of the convergence criterion are not counted
+		if model.Score([]float64{float64(sample.Values[0]), float64(sample.Values[1])}) < -DefaultOcsvmTolerance {
+			outliers++
+		}
+	}
+	s.True(outliers <= int(nu*float64(len(training))), "Nu is an upper bound for the fraction of training outliers, but %v samples are outliers", outliers)
+	s.True(model.Score([]float64{0, 0}) > 0, "The center of the distribution must be an inlier")
+	s.True(model.Score([]float64{5, -5}) < 0, "Distant points must be outliers")
+}
+
+func (s *outliersTestSuite) TestStreamScoring() {
+	model := new(OneClassSVMModel)
+	model.Train(&bitflow.Header{Fields: []string{"a", "b"}}, s.gaussianSamples(100), 0.1, 0.1)
+
+	// Models are persisted through the models package
+	var buf bytes.Buffer
+	s.NoError(models.Encode(&buf, models.Metadata{Type: OcsvmModelType, Fields: model.Fields}, model))
+	loaded := new(OneClassSVMModel)
+	_, err := models.Decode(buf.Bytes(), OcsvmModelType, loaded)
+	s.NoError(err)
+	s.Equal(model, loaded)
+	_, err = models.Decode(buf.Bytes(), LofModelType, new(LOFModel))
+	s.Error(err, "The model type must be checked")
+
+	scorer := &OutlierScorer{Model: loaded, Tag: "outlier"}
+	out := s.Process(scorer, &bitflow.Header{Fields: []string{"a", "b"}},
+		testsupport.NewSample(0, "", 0, 0),
+		testsupport.NewSample(0, "", 6, 6))
+	out.AssertFields(s.T(), "a", "b", OcsvmField)
+	s.Equal([]string{InlierTagValue, OutlierTagValue}, out.Tags("outlier"))
+	s.False(math.IsNaN(out.Values()[0][2]))
+
+	scorer = &OutlierScorer{Model: loaded}
+	scorer.SetSink(new(bitflow.DroppingSampleProcessor))
+	s.Error(scorer.Sample(testsupport.NewSample(0, "", 1, 2), &bitflow.Header{Fields: []string{"b", "a"}}), "The metrics must match the model")
+}
@@ -6,12 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strconv"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
 	log "github.com/sirupsen/logrus"
 	"gonum.org/v1/gonum/mat"
 	"gonum.org/v1/gonum/stat"
@@ -35,6 +35,15 @@ func SamplesToMatrix(samples []*bitflow.Sample) mat.Matrix {
 	return mat.NewDense(len(samples), cols, values)
 }
 
+// ColumnsToMatrix returns a matrix with one row per sample and one column per metric. The matrix refers to the
+// values of the batch and does not copy them.
+func ColumnsToMatrix(batch *bitflow.ColumnBatch) mat.Matrix {
+	if batch.Len() < 1 || len(batch.Header.Fields) < 1 {
+		return mat.NewDense(0, 0, nil)
+	}
+	return mat.NewDense(len(batch.Header.Fields), batch.Len(), batch.Values).T()
+}
+
 type PCAModel struct {
 	Vectors            *mat.Dense
 	RawVariances       []float64
@@ -42,14 +51,18 @@ type PCAModel struct {
 }
 
 func (model *PCAModel) ComputeModel(samples []*bitflow.Sample) error {
-	matrix := SamplesToMatrix(samples)
+	return model.ComputeMatrixModel(SamplesToMatrix(samples))
+}
+
+// ComputeMatrixModel computes the model from a matrix with one row per sample, see SamplesToMatrix() and ColumnsToMatrix().
+func (model *PCAModel) ComputeMatrixModel(matrix mat.Matrix) error {
 	pc := new(stat.PC)
 	ok := pc.PrincipalComponents(matrix, nil)
 	if !ok {
 		return errors.New("PCA model could not be computed")
 	}
-	pc.VarsTo(model.RawVariances)
-	pc.VectorsTo(model.Vectors)
+	model.RawVariances = pc.VarsTo(nil)
+	model.Vectors = pc.VectorsTo(nil)
 
 	model.ContainedVariances = make([]float64, len(model.RawVariances))
 	var sum float64
@@ -63,8 +76,12 @@ func (model *PCAModel) ComputeModel(samples []*bitflow.Sample) error {
 }
 
 func (model *PCAModel) ComputeAndReport(samples []*bitflow.Sample) error {
+	return model.ComputeAndReportMatrix(SamplesToMatrix(samples))
+}
+
+func (model *PCAModel) ComputeAndReportMatrix(matrix mat.Matrix) error {
 	log.Println("Computing PCA model")
-	if err := model.ComputeModel(samples); err != nil {
+	if err := model.ComputeMatrixModel(matrix); err != nil {
 		outErr := fmt.Errorf("Error computing PCA model: %v", err)
 		log.Errorln(outErr)
 		return outErr
@@ -108,15 +125,10 @@ func (model *PCAModel) WriteModel(writer io.Writer) error {
 	return err
 }
 
-func (model *PCAModel) Load(filename string) (err error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	if err := gob.NewDecoder(file).Decode(model); err != nil {
-		return err
-	}
-	return file.Close()
+// Load loads a PCA model from the given location (see the models package).
+func (model *PCAModel) Load(location string) error {
+	_, err := models.Load(location, PcaModelType, model)
+	return err
 }
 
 func (model *PCAModel) Project(numComponents int) *PCAProjection {
@@ -159,6 +171,22 @@ func (model *PCAProjection) Matrix(matrix mat.Matrix) *mat.Dense {
 	return &result
 }
 
+// Columns projects the given matrix (one row per sample) and returns the result in the columnar layout of
+// bitflow.ColumnBatch. The transposed result is computed directly, so it does not have to be rearranged.
+func (model *PCAProjection) Columns(matrix mat.Matrix) []float64 {
+	var result mat.Dense
+	result.Mul(model.Vectors.T(), matrix.T())
+	raw := result.RawMatrix()
+	if raw.Stride == raw.Cols {
+		return raw.Data[:raw.Rows*raw.Cols]
+	}
+	values := make([]float64, 0, raw.Rows*raw.Cols)
+	for i := 0; i < raw.Rows; i++ {
+		values = append(values, result.RawRowView(i)...)
+	}
+	return values
+}
+
 func (model *PCAProjection) Vector(vec []float64) []float64 {
 	matrix := model.Matrix(mat.NewDense(1, len(vec), vec))
 	return matrix.RawRowView(0)
@@ -172,25 +200,15 @@ func (model *PCAProjection) Sample(sample *bitflow.Sample) (result *bitflow.Samp
 	return
 }
 
-func StorePCAModel(filename string) bitflow.BatchProcessingStep {
-	var counter int
-	group := bitflow.NewFileGroup(filename)
-
+func StorePCAModel(location string) bitflow.BatchProcessingStep {
+	store := models.NewStore(location)
 	return &bitflow.SimpleBatchProcessingStep{
-		Description: fmt.Sprintf("Compute & store PCA model to %v", filename),
+		Description: fmt.Sprintf("Compute & store PCA model to %v", location),
 		Process: func(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
 			var model PCAModel
 			err := model.ComputeAndReport(samples)
 			if err == nil {
-				var file *os.File
-				file, err = group.OpenNewFile(&counter)
-				if err == nil {
-					log.Println("Storing PCA model to", file.Name())
-					err = model.WriteModel(file)
-					if err == nil {
-						err = file.Close()
-					}
-				}
+				err = storeModel(store, PcaModelType, header.Fields, &model)
 			}
 			return header, samples, err
 		},
@@ -203,19 +221,10 @@ func LoadBatchPCAModel(filename string, containedVariance float64) (bitflow.Batc
 		return nil, err
 	}
 
-	return &bitflow.SimpleBatchProcessingStep{
+	return &bitflow.SimpleColumnBatchProcessingStep{
 		Description: fmt.Sprintf("Project PCA (model loaded from %v)", filename),
-		Process: func(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
-			projection, header, err := model.ProjectHeader(containedVariance, header)
-			if err != nil {
-				return nil, nil, err
-			}
-
-			// Convert sample slice to matrix, do the projection, then fill the new values back into the same sample slice
-			// Should minimize allocations, since the value slices have the same length before and after projection
-			matrix := projection.Matrix(SamplesToMatrix(samples))
-			steps.FillSamplesFromMatrix(samples, matrix)
-			return header, samples, nil
+		Process: func(batch *bitflow.ColumnBatch) (*bitflow.ColumnBatch, error) {
+			return projectColumns(&model, containedVariance, batch)
 		},
 	}, nil
 }
@@ -250,27 +259,30 @@ func LoadStreamingPCAModel(filename string, containedVariance float64) (bitflow.
 }
 
 func ComputeAndProjectPCA(containedVariance float64) bitflow.BatchProcessingStep {
-	return &bitflow.SimpleBatchProcessingStep{
+	return &bitflow.SimpleColumnBatchProcessingStep{
 		Description: fmt.Sprintf("Compute & project PCA (%v variance)", containedVariance),
-		Process: func(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+		Process: func(batch *bitflow.ColumnBatch) (*bitflow.ColumnBatch, error) {
 			var model PCAModel
-			if err := model.ComputeAndReport(samples); err != nil {
-				return nil, nil, err
-			}
-			projection, header, err := model.ProjectHeader(containedVariance, header)
-			if err != nil {
-				return nil, nil, err
+			if err := model.ComputeAndReportMatrix(ColumnsToMatrix(batch)); err != nil {
+				return nil, err
 			}
-
-			// Convert sample slice to matrix, do the projection, then fill the new values back into the same sample slice
-			// Should minimize allocations, since the value slices have the same length before and after projection
-			matrix := projection.Matrix(SamplesToMatrix(samples))
-			steps.FillSamplesFromMatrix(samples, matrix)
-			return header, samples, nil
+			return projectColumns(&model, containedVariance, batch)
 		},
 	}
 }
 
+// projectColumns projects the batch without converting it to samples. The Values slices of the samples are
+// reused when converting the batch back, since they have the same length or are shorter after the projection.
+func projectColumns(model *PCAModel, containedVariance float64, batch *bitflow.ColumnBatch) (*bitflow.ColumnBatch, error) {
+	projection, header, err := model.ProjectHeader(containedVariance, batch.Header)
+	if err != nil {
+		return nil, err
+	}
+	batch.Values = projection.Columns(ColumnsToMatrix(batch))
+	batch.Header = header
+	return batch, nil
+}
+
 func RegisterPCA(b reg.ProcessorRegistry) {
 	create := func(p *bitflow.SamplePipeline, params map[string]string) error {
 		variance, err := parse_pca_variance(params)
@@ -288,7 +300,7 @@ func RegisterPCAStore(b reg.ProcessorRegistry) {
 		func(p *bitflow.SamplePipeline, params map[string]string) {
 			p.Batch(StorePCAModel(params["file"]))
 		},
-		"Create a PCA model of a batch of samples and store it to the given file",
+		"Create a PCA model of a batch of samples and store it to the given model location (a file, an http:// URL, or model://<name> for the model repository)",
 		reg.RequiredParams("file"), reg.SupportBatch())
 }
 
@@ -304,7 +316,7 @@ func RegisterPCALoad(b reg.ProcessorRegistry) {
 		}
 		return err
 	}
-	b.RegisterAnalysisParamsErr("pca_load", create, "Load a PCA model from the given file and project all samples into a number of principal components with a total contained variance given by the parameter",
+	b.RegisterAnalysisParamsErr("pca_load", create, "Load a PCA model from the given model location and project all samples into a number of principal components with a total contained variance given by the parameter",
 		reg.RequiredParams("var", "file"), reg.SupportBatch())
 }
 
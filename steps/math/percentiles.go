@@ -0,0 +1,177 @@
+package math
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+)
+
+func RegisterPercentiles(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("percentiles",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			quantiles, compression, err := parsePercentileParams(params)
+			if err == nil {
+				p.Batch(&BatchPercentiles{
+					Quantiles:   quantiles,
+					Compression: compression,
+				})
+			}
+			return err
+		},
+		"Estimate the given quantiles (comma-separated, e.g. 0.5,0.95,0.99) of every metric in a batch of samples using a t-digest. Output a single sample with one metric per input metric and quantile.",
+		reg.OptionalParams("quantiles", "compression"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("percentiles_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			quantiles, compression, err := parsePercentileParams(params)
+			interval := reg.DurationParam(params, "interval", 0, true, &err)
+			if err == nil {
+				p.Add(&StreamingPercentiles{
+					Quantiles:   quantiles,
+					Compression: compression,
+					Interval:    interval,
+				})
+			}
+			return err
+		},
+		"Like percentiles, but process every sample individually and append the current quantile estimates of every metric. "+
+			"If the interval parameter is set, the estimation restarts whenever the sample timestamps enter a new interval.",
+		reg.OptionalParams("quantiles", "compression", "interval"))
+}
+
+func parsePercentileParams(params map[string]string) (quantiles []float64, compression float64, err error) {
+	quantilesStr := reg.StrParam(params, "quantiles", "0.5,0.95,0.99", true, &err)
+	compression = reg.FloatParam(params, "compression", DefaultTDigestCompression, true, &err)
+	if err != nil {
+		return
+	}
+	for _, str := range strings.Split(quantilesStr, ",") {
+		q, parseErr := strconv.ParseFloat(str, 64)
+		if parseErr == nil && (q < 0 || q > 1) {
+			parseErr = fmt.Errorf("Quantile must be in 0..1: %v", q)
+		}
+		if parseErr != nil {
+			err = reg.ParameterError("quantiles", parseErr)
+			return
+		}
+		quantiles = append(quantiles, q)
+	}
+	return
+}
+
+func percentileSuffixes(quantiles []float64) []string {
+	res := make([]string, len(quantiles))
+	for i, q := range quantiles {
+		res[i] = "_p" + strconv.FormatFloat(q*100, 'f', -1, 64)
+	}
+	return res
+}
+
+func percentileHeader(header *bitflow.Header, quantiles []float64, keepInputFields bool) *bitflow.Header {
+	var fields []string
+	if keepInputFields {
+		fields = append(fields, header.Fields...)
+	}
+	suffixes := percentileSuffixes(quantiles)
+	for _, field := range header.Fields {
+		for _, suffix := range suffixes {
+			fields = append(fields, field+suffix)
+		}
+	}
+	return &bitflow.Header{Fields: fields}
+}
+
+func percentilesString(quantiles []float64) string {
+	return strings.Join(percentileSuffixes(quantiles), ",")
+}
+
+// BatchPercentiles estimates quantiles of every metric in a batch of samples.
+type BatchPercentiles struct {
+	Quantiles   []float64
+	Compression float64
+}
+
+func (b *BatchPercentiles) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	values := make([]float64, 0, len(header.Fields)*len(b.Quantiles))
+	for i := range header.Fields {
+		digest := NewTDigest(b.Compression)
+		for _, sample := range samples {
+			digest.Add(float64(sample.Values[i]))
+		}
+		for _, q := range b.Quantiles {
+			values = append(values, digest.Quantile(q))
+		}
+	}
+	out := new(bitflow.Sample)
+	steps.FillSample(out, values)
+	out.CopyMetadataFrom(samples[len(samples)-1])
+	return percentileHeader(header, b.Quantiles, false), []*bitflow.Sample{out}, nil
+}
+
+func (b *BatchPercentiles) OutputSampleSize(sampleSize int) int {
+	return sampleSize * len(b.Quantiles)
+}
+
+func (b *BatchPercentiles) String() string {
+	return fmt.Sprintf("Percentiles (%v)", percentilesString(b.Quantiles))
+}
+
+// StreamingPercentiles appends the current quantile estimates of every metric to every sample.
+type StreamingPercentiles struct {
+	bitflow.NoopProcessor
+	Quantiles   []float64
+	Compression float64
+	Interval    time.Duration // If > 0, reset the estimation when sample timestamps enter a new interval
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	digests   []*TDigest
+	current   time.Time
+}
+
+func (s *StreamingPercentiles) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if s.checker.HeaderChanged(header) {
+		s.outHeader = percentileHeader(header, s.Quantiles, true)
+		s.digests = make([]*TDigest, len(header.Fields))
+		for i := range s.digests {
+			s.digests[i] = NewTDigest(s.Compression)
+		}
+	}
+	if s.Interval > 0 {
+		if start := sample.Time.Truncate(s.Interval); !start.Equal(s.current) {
+			s.current = start
+			for _, digest := range s.digests {
+				digest.Reset()
+			}
+		}
+	}
+	values := make([]float64, 0, len(header.Fields)*len(s.Quantiles))
+	for i, digest := range s.digests {
+		digest.Add(float64(sample.Values[i]))
+		for _, q := range s.Quantiles {
+			values = append(values, digest.Quantile(q))
+		}
+	}
+	steps.AppendToSample(sample, values)
+	return s.NoopProcessor.Sample(sample, s.outHeader)
+}
+
+func (s *StreamingPercentiles) OutputSampleSize(sampleSize int) int {
+	return sampleSize * (1 + len(s.Quantiles))
+}
+
+func (s *StreamingPercentiles) String() string {
+	res := fmt.Sprintf("Streaming percentiles (%v)", percentilesString(s.Quantiles))
+	if s.Interval > 0 {
+		res += fmt.Sprintf(" (reset every %v)", s.Interval)
+	}
+	return res
+}
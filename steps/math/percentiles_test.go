@@ -0,0 +1,91 @@
+package math
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type percentilesTestSuite struct {
+	testsupport.Suite
+}
+
+func TestPercentiles(t *testing.T) {
+	suite.Run(t, new(percentilesTestSuite))
+}
+
+func (s *percentilesTestSuite) TestSmallDigest() {
+	digest := NewTDigest(0)
+	s.Equal(float64(DefaultTDigestCompression), digest.Compression)
+	s.True(math.IsNaN(digest.Quantile(0.5)), "An empty digest has no quantiles")
+	for _, val := range []float64{5, 1, math.NaN(), 4, 2, 3} {
+		digest.Add(val)
+	}
+	s.Equal(5.0, digest.Count(), "NaN values must be ignored")
+	s.Equal(1.0, digest.Quantile(0))
+	s.Equal(3.0, digest.Quantile(0.5))
+	s.Equal(5.0, digest.Quantile(1))
+
+	digest.Reset()
+	s.Equal(0.0, digest.Count())
+	digest.Add(7)
+	s.Equal(7.0, digest.Quantile(0.3))
+}
+
+func (s *percentilesTestSuite) TestAccuracy() {
+	const num = 100000
+	rnd := rand.New(rand.NewSource(1))
+	digest := NewTDigest(100)
+	for _, i := range rnd.Perm(num) {
+		digest.Add(float64(i) / num)
+	}
+	s.Equal(float64(num), digest.Count())
+	for _, q := range []float64{0.1, 0.25, 0.5, 0.75, 0.9} {
+		s.InDelta(q, digest.Quantile(q), 0.01, "Quantile %v", q)
+	}
+	for _, q := range []float64{0.001, 0.01, 0.99, 0.999} {
+		s.InDelta(q, digest.Quantile(q), 0.001, "The extreme quantile %v must be more accurate", q)
+	}
+	s.True(len(digest.centroids) <= int(digest.Compression), "The digest must be compressed, but has %v centroids", len(digest.centroids))
+}
+
+func (s *percentilesTestSuite) TestBatch() {
+	var samples []*bitflow.Sample
+	for i := 1; i <= 5; i++ {
+		samples = append(samples, testsupport.NewSample(time.Duration(i)*time.Second, "host=x", bitflow.Value(i), bitflow.Value(10*i)))
+	}
+	header, out, err := (&BatchPercentiles{Quantiles: []float64{0, 0.5, 1}}).ProcessBatch(&bitflow.Header{Fields: []string{"a", "b"}}, samples)
+	s.NoError(err)
+	s.Equal([]string{"a_p0", "a_p50", "a_p100", "b_p0", "b_p50", "b_p100"}, header.Fields)
+	s.Len(out, 1)
+	s.Equal([]bitflow.Value{1, 3, 5, 10, 30, 50}, out[0].Values)
+	s.Equal("x", out[0].Tag("host"))
+	s.Equal(testsupport.StartTime.Add(5*time.Second), out[0].Time)
+}
+
+func (s *percentilesTestSuite) TestStreaming() {
+	step := &StreamingPercentiles{Quantiles: []float64{0.5, 1}, Interval: 10 * time.Second}
+	out := s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(0, "", 1),
+		testsupport.NewSample(time.Second, "", 3),
+		testsupport.NewSample(2*time.Second, "", 2),
+		testsupport.NewSample(10*time.Second, "", 8)) // Restarts the estimation
+	out.AssertFields(s.T(), "a", "a_p50", "a_p100")
+	out.AssertValues(s.T(), [][]float64{{1, 1, 1}, {3, 2, 3}, {2, 2, 3}, {8, 8, 8}})
+}
+
+func (s *percentilesTestSuite) TestParameters() {
+	quantiles, compression, err := parsePercentileParams(map[string]string{})
+	s.NoError(err)
+	s.Equal([]float64{0.5, 0.95, 0.99}, quantiles)
+	s.Equal(float64(DefaultTDigestCompression), compression)
+	for _, q := range []string{"1.5", "-0.1", "x", "0.5,"} {
+		_, _, err := parsePercentileParams(map[string]string{"quantiles": q})
+		s.Error(err, q)
+	}
+}
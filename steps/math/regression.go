@@ -3,6 +3,7 @@ package math
 import (
 	"bytes"
 	"fmt"
+	gomath "math"
 	"runtime"
 	"sort"
 	"sync"
@@ -14,6 +15,7 @@ import (
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -25,6 +27,7 @@ type LinearRegression struct {
 }
 
 type LinearRegressionBatchProcessor struct {
+	Store *models.Store // Optional
 }
 
 func (reg *LinearRegressionBatchProcessor) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
@@ -40,10 +43,18 @@ func (reg *LinearRegressionBatchProcessor) ProcessBatch(header *bitflow.Header,
 	} else {
 		log.Printf("Linear Regression MSE %g: %v", mse, regression.FormulaString())
 	}
+	if reg.Store != nil {
+		if err := storeModel(reg.Store, RegressionModelType, header.Fields, regression.ExportModel()); err != nil {
+			return nil, nil, err
+		}
+	}
 	return header, samples, nil
 }
 
 func (reg *LinearRegressionBatchProcessor) String() string {
+	if reg.Store != nil {
+		return fmt.Sprintf("Linear Regression (store model to %v)", reg.Store)
+	}
 	return "Linear Regression"
 }
 
@@ -168,10 +179,33 @@ func NewLinearRegression(header *bitflow.Header, fieldNames []string) (LinearReg
 }
 
 func RegisterLinearRegression(b reg.ProcessorRegistry) {
-	create := func(p *bitflow.SamplePipeline) {
-		p.Batch(new(LinearRegressionBatchProcessor))
+	create := func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+		store := reg.StrParam(params, "store", "", true, &err)
+		if err == nil {
+			step := new(LinearRegressionBatchProcessor)
+			if store != "" {
+				step.Store = models.NewStore(store)
+			}
+			p.Batch(step)
+		}
+		return
 	}
-	b.RegisterAnalysis("regression", create, "Perform a linear regression analysis on a batch of samples", reg.SupportBatch())
+	b.RegisterAnalysisParamsErr("regression", create,
+		"Perform a linear regression analysis on a batch of samples, predicting the first metric from all other metrics. "+
+			"If the store parameter is given, the model is stored to that model location, to be used by regression_load_stream.",
+		reg.OptionalParams("store"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("regression_load_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			model := new(LinearRegressionModel)
+			_, err := models.Load(params["file"], RegressionModelType, model)
+			if err == nil {
+				p.Add(&LinearRegressionPredictor{Model: model})
+			}
+			return err
+		},
+		"Load a linear regression model stored by the regression step and append the predicted value of every sample (suffix "+PredictedSuffix+")",
+		reg.RequiredParams("file"))
 }
 
 func RegisterLinearRegressionBruteForce(b reg.ProcessorRegistry) {
@@ -316,3 +350,97 @@ func (header SubHeader) BuildFilledInstances(samples []*bitflow.Sample, classAtt
 	header.FillInstances(samples, data)
 	return data, nil
 }
+
+const PredictedSuffix = "_predicted"
+
+// LinearRegressionModel is the serializable form of a trained LinearRegression. If Logistic is set,
+// the linear combination of the inputs is passed through the logistic function.
+type LinearRegressionModel struct {
+	Target       string
+	Inputs       []string
+	Intercept    float64
+	Coefficients []float64
+	Logistic     bool
+}
+
+// Predict computes the prediction for the given input values, which must be ordered like the Inputs.
+func (m *LinearRegressionModel) Predict(inputs []float64) float64 {
+	res := m.Intercept
+	for i, val := range inputs {
+		res += m.Coefficients[i] * val
+	}
+	if m.Logistic {
+		res = 1 / (1 + gomath.Exp(-res))
+	}
+	return res
+}
+
+func (reg *LinearRegression) ExportModel() *LinearRegressionModel {
+	res := &LinearRegressionModel{
+		Target:       reg.Model.Cls.GetName(),
+		Intercept:    reg.Model.Disturbance,
+		Coefficients: reg.Model.RegressionCoefficients,
+	}
+	for _, attr := range reg.Model.Attrs {
+		res.Inputs = append(res.Inputs, attr.GetName())
+	}
+	return res
+}
+
+func (m *LinearRegressionModel) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v = ", m.Target)
+	if m.Logistic {
+		buf.WriteString("logistic(")
+	}
+	fmt.Fprintf(&buf, "%g", m.Intercept)
+	for i, input := range m.Inputs {
+		fmt.Fprintf(&buf, " + %g %v", m.Coefficients[i], input)
+	}
+	if m.Logistic {
+		buf.WriteString(")")
+	}
+	return buf.String()
+}
+
+// LinearRegressionPredictor appends the prediction of a LinearRegressionModel to every sample.
+// The input metrics of the model are looked up by name.
+type LinearRegressionPredictor struct {
+	bitflow.NoopProcessor
+	Model *LinearRegressionModel
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	indices   []int
+}
+
+func (p *LinearRegressionPredictor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		fieldIndices := header.BuildIndex()
+		p.indices = make([]int, len(p.Model.Inputs))
+		for i, input := range p.Model.Inputs {
+			index, ok := fieldIndices[input]
+			if !ok {
+				return fmt.Errorf("%v: Input metric %v is missing", p, input)
+			}
+			p.indices[i] = index
+		}
+		fields := make([]string, len(header.Fields), len(header.Fields)+1)
+		copy(fields, header.Fields)
+		p.outHeader = header.Clone(append(fields, p.Model.Target+PredictedSuffix))
+	}
+	inputs := make([]float64, len(p.indices))
+	for i, index := range p.indices {
+		inputs[i] = float64(sample.Values[index])
+	}
+	steps.AppendToSample(sample, []float64{p.Model.Predict(inputs)})
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *LinearRegressionPredictor) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 1
+}
+
+func (p *LinearRegressionPredictor) String() string {
+	return fmt.Sprintf("Linear regression prediction (%v)", p.Model)
+}
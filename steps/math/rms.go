@@ -20,23 +20,27 @@ type BatchRms struct {
 }
 
 func (r *BatchRms) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
-	if len(samples) == 0 {
-		return header, samples, nil
+	return bitflow.ProcessColumnsAsBatch(r, header, samples)
+}
+
+func (r *BatchRms) ProcessColumns(batch *bitflow.ColumnBatch) (*bitflow.ColumnBatch, error) {
+	if batch.Len() == 0 {
+		return batch, nil
 	}
-	res := make([]bitflow.Value, len(header.Fields))
-	num := float64(len(samples))
-	for i := range header.Fields {
+	res := make([]float64, len(batch.Header.Fields))
+	num := float64(batch.Len())
+	for i, col := range batch.Columns() {
 		rms := float64(0)
-		for _, sample := range samples {
-			val := float64(sample.Values[i])
+		for _, val := range col {
 			rms += val * val / num
 		}
-		rms = math.Sqrt(rms)
-		res[i] = bitflow.Value(rms)
+		res[i] = math.Sqrt(rms)
 	}
-	outSample := samples[0].Clone() // Use the first sample as the reference for metadata (timestamp and tags)
-	outSample.Values = res
-	return header, []*bitflow.Sample{outSample}, nil
+	outSample := batch.Samples[0].Clone() // Use the first sample as the reference for metadata (timestamp and tags)
+	outSample.Values = nil                // Filled when converting the batch back to samples
+	batch.Samples = []*bitflow.Sample{outSample}
+	batch.Values = res
+	return batch, nil
 }
 
 func (r *BatchRms) String() string {
@@ -1,9 +1,15 @@
 package math
 
 import (
+	"errors"
+	"fmt"
+	"math"
+
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/steps"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
 )
 
 type MinMaxScaling struct {
@@ -34,14 +40,20 @@ func RegisterStandardizationScaling(b reg.ProcessorRegistry) {
 }
 
 func (s *MinMaxScaling) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
-	min, max := steps.GetMinMax(header, samples)
-	for _, sample := range samples {
-		for i, val := range sample.Values {
-			res := steps.ScaleMinMax(float64(val), min[i], max[i], s.Min, s.Max)
-			sample.Values[i] = bitflow.Value(res)
+	return bitflow.ProcessColumnsAsBatch(s, header, samples)
+}
+
+func (s *MinMaxScaling) ProcessColumns(batch *bitflow.ColumnBatch) (*bitflow.ColumnBatch, error) {
+	if batch.Len() == 0 {
+		return batch, nil
+	}
+	for _, col := range batch.Columns() {
+		min, max := floats.Min(col), floats.Max(col)
+		for i, val := range col {
+			col[i] = steps.ScaleMinMax(val, min, max, s.Min, s.Max)
 		}
 	}
-	return header, samples, nil
+	return batch, nil
 }
 
 func (s *MinMaxScaling) String() string {
@@ -52,16 +64,227 @@ type StandardizationScaling struct {
 }
 
 func (s *StandardizationScaling) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
-	stats := steps.GetStats(header, samples)
-	for _, sample := range samples {
-		for i, val := range sample.Values {
-			res := stats[i].ScaleStddev(float64(val))
-			sample.Values[i] = bitflow.Value(res)
+	return bitflow.ProcessColumnsAsBatch(s, header, samples)
+}
+
+func (s *StandardizationScaling) ProcessColumns(batch *bitflow.ColumnBatch) (*bitflow.ColumnBatch, error) {
+	if batch.Len() == 0 {
+		return batch, nil
+	}
+	for _, col := range batch.Columns() {
+		mean, stddev := stat.MeanStdDev(col, nil)
+		min, max := floats.Min(col), floats.Max(col)
+		for i, val := range col {
+			col[i] = steps.ScaleStddev(val, mean, stddev, min, max)
 		}
 	}
-	return header, samples, nil
+	return batch, nil
 }
 
 func (s *StandardizationScaling) String() string {
 	return "Standardization scaling"
 }
+
+func RegisterStreamingScaling(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("scale_min_max_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			scaling := &StreamingMinMaxScaling{
+				Min:         reg.FloatParam(params, "min", 0, true, &err),
+				Max:         reg.FloatParam(params, "max", 1, true, &err),
+				Quantile:    reg.FloatParam(params, "quantile", 0, true, &err),
+				Compression: reg.FloatParam(params, "compression", DefaultTDigestCompression, true, &err),
+			}
+			statsFile := reg.StrParam(params, "stats", "", true, &err)
+			if err == nil && (scaling.Quantile < 0 || scaling.Quantile >= 0.5) {
+				err = reg.ParameterError("quantile", fmt.Errorf("Must be in 0..0.5: %v", scaling.Quantile))
+			}
+			if err == nil && statsFile != "" {
+				if scaling.Quantile > 0 {
+					err = reg.ParameterError("stats", errors.New("Cannot be combined with the quantile parameter"))
+				} else if scaling.Stats, err = steps.LoadStats(statsFile); err != nil {
+					err = reg.ParameterError("stats", err)
+				}
+			}
+			if err == nil {
+				p.Add(scaling)
+			}
+			return
+		},
+		"Like scale_min_max, but scale every sample individually based on running estimates of the minimum and maximum of every metric. "+
+			"If quantile is set, estimate the given lower and upper quantile instead of the extreme values and clip the outliers. "+
+			"Alternatively, load the statistics from a file written by the stats step.",
+		reg.OptionalParams("min", "max", "quantile", "compression", "stats"),
+		reg.ParamDetails("quantile", reg.TypeFloat, "0", "Use the quantiles quantile and 1-quantile (estimated with a t-digest) instead of the minimum and maximum"),
+		reg.ParamDetails("stats", reg.TypeString, "", "Statistics file written by the stats step"))
+
+	b.RegisterAnalysisParamsErr("standardize_stream",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			scaling := new(StreamingStandardizationScaling)
+			statsFile := reg.StrParam(params, "stats", "", true, &err)
+			if err == nil && statsFile != "" {
+				if scaling.Stats, err = steps.LoadStats(statsFile); err != nil {
+					err = reg.ParameterError("stats", err)
+				}
+			}
+			if err == nil {
+				p.Add(scaling)
+			}
+			return
+		},
+		"Like standardize, but scale every sample individually based on the running mean and std-deviation of every metric, or based on the statistics loaded from a file written by the stats step",
+		reg.OptionalParams("stats"),
+		reg.ParamDetails("stats", reg.TypeString, "", "Statistics file written by the stats step"))
+}
+
+// streamingFeature contains the running statistics of one metric, or the statistics loaded from a file.
+type streamingFeature struct {
+	stats  *steps.FeatureStats
+	digest *TDigest
+	stored *steps.StoredFeatureStats
+}
+
+func (f *streamingFeature) push(val float64) {
+	if f.stored == nil {
+		f.stats.Push(val)
+		if f.digest != nil {
+			f.digest.Add(val)
+		}
+	}
+}
+
+func (f *streamingFeature) bounds(quantile float64) (float64, float64) {
+	switch {
+	case f.stored != nil:
+		return f.stored.Min, f.stored.Max
+	case f.digest != nil:
+		return f.digest.Quantile(quantile), f.digest.Quantile(1 - quantile)
+	default:
+		return f.stats.Min, f.stats.Max
+	}
+}
+
+func (f *streamingFeature) meanStddev() (float64, float64) {
+	if f.stored != nil {
+		return f.stored.Mean, f.stored.Stddev
+	}
+	return f.stats.Mean(), f.stats.Stddev()
+}
+
+// streamingFeatures keeps the statistics of every metric by name, so they are retained when the header changes.
+type streamingFeatures struct {
+	checker  bitflow.HeaderChecker
+	features map[string]*streamingFeature
+	current  []*streamingFeature
+}
+
+func (s *streamingFeatures) update(header *bitflow.Header, stats map[string]steps.StoredFeatureStats, digestCompression float64) error {
+	if !s.checker.HeaderChanged(header) {
+		return nil
+	}
+	if s.features == nil {
+		s.features = make(map[string]*streamingFeature)
+	}
+	s.current = make([]*streamingFeature, len(header.Fields))
+	for i, field := range header.Fields {
+		feature, ok := s.features[field]
+		if !ok {
+			feature = new(streamingFeature)
+			if stats != nil {
+				stored, ok := stats[field]
+				if !ok {
+					return fmt.Errorf("No statistics loaded for metric '%v'", field)
+				}
+				feature.stored = &stored
+			} else {
+				feature.stats = steps.NewFeatureStats()
+				if digestCompression > 0 {
+					feature.digest = NewTDigest(digestCompression)
+				}
+			}
+			s.features[field] = feature
+		}
+		s.current[i] = feature
+	}
+	return nil
+}
+
+// StreamingMinMaxScaling scales every sample based on the minimum and maximum of every metric, without batching
+// the samples. The statistics are either estimated from the samples seen so far, or loaded from a file written by
+// StoreStats. If Quantile is set, the lower and upper quantiles are estimated instead of the extreme values, which makes
+// the scaling robust against outliers. Values outside the quantiles are clipped to the output range.
+type StreamingMinMaxScaling struct {
+	bitflow.NoopProcessor
+	Min         float64
+	Max         float64
+	Quantile    float64
+	Compression float64
+	Stats       map[string]steps.StoredFeatureStats
+
+	features streamingFeatures
+}
+
+func (s *StreamingMinMaxScaling) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	compression := 0.0
+	if s.Quantile > 0 {
+		compression = s.Compression
+		if compression <= 0 {
+			compression = DefaultTDigestCompression
+		}
+	}
+	if err := s.features.update(header, s.Stats, compression); err != nil {
+		return fmt.Errorf("%v: %v", s, err)
+	}
+	for i, val := range sample.Values {
+		feature := s.features.current[i]
+		feature.push(float64(val))
+		min, max := feature.bounds(s.Quantile)
+		res := steps.ScaleMinMax(float64(val), min, max, s.Min, s.Max)
+		if s.Quantile > 0 {
+			res = math.Max(math.Min(res, math.Max(s.Min, s.Max)), math.Min(s.Min, s.Max))
+		}
+		sample.Values[i] = bitflow.Value(res)
+	}
+	return s.NoopProcessor.Sample(sample, header)
+}
+
+func (s *StreamingMinMaxScaling) String() string {
+	res := "Streaming min-max scaling"
+	if s.Quantile > 0 {
+		res += fmt.Sprintf(" (quantiles %v and %v)", s.Quantile, 1-s.Quantile)
+	}
+	if s.Stats != nil {
+		res += " (loaded statistics)"
+	}
+	return res
+}
+
+// StreamingStandardizationScaling scales every sample based on the mean and standard deviation of every metric,
+// without batching the samples. The statistics are either estimated from the samples seen so far, or loaded from a
+// file written by StoreStats.
+type StreamingStandardizationScaling struct {
+	bitflow.NoopProcessor
+	Stats map[string]steps.StoredFeatureStats
+
+	features streamingFeatures
+}
+
+func (s *StreamingStandardizationScaling) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if err := s.features.update(header, s.Stats, 0); err != nil {
+		return fmt.Errorf("%v: %v", s, err)
+	}
+	for i, val := range sample.Values {
+		feature := s.features.current[i]
+		feature.push(float64(val))
+		mean, stddev := feature.meanStddev()
+		min, max := feature.bounds(0)
+		sample.Values[i] = bitflow.Value(steps.ScaleStddev(float64(val), mean, stddev, min, max))
+	}
+	return s.NoopProcessor.Sample(sample, header)
+}
+
+func (s *StreamingStandardizationScaling) String() string {
+	if s.Stats != nil {
+		return "Streaming standardization scaling (loaded statistics)"
+	}
+	return "Streaming standardization scaling"
+}
@@ -0,0 +1,82 @@
+package math
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/stretchr/testify/suite"
+)
+
+type scalingTestSuite struct {
+	testsupport.Suite
+}
+
+func TestScaling(t *testing.T) {
+	suite.Run(t, new(scalingTestSuite))
+}
+
+// scale sends one sample per value through the processor and returns the scaled values.
+// The processor keeps its statistics, when it is used multiple times.
+func (s *scalingTestSuite) scale(processor bitflow.SampleProcessor, field string, values ...bitflow.Value) []bitflow.Value {
+	samples := make([]*bitflow.Sample, len(values))
+	for i, val := range values {
+		samples[i] = testsupport.NewSample(0, "", val)
+	}
+	var res []bitflow.Value
+	for _, values := range s.Process(processor, &bitflow.Header{Fields: []string{field}}, samples...).Values() {
+		res = append(res, bitflow.Value(values[0]))
+	}
+	return res
+}
+
+func (s *scalingTestSuite) TestStreamingMinMax() {
+	scaling := &StreamingMinMaxScaling{Min: 0, Max: 10}
+	s.Equal([]bitflow.Value{5, 10, 0, 5, 10}, s.scale(scaling, "a", 4, 6, 2, 4, 8))
+
+	// The statistics are kept for metrics with the same name after a header change
+	s.Equal([]bitflow.Value{5}, s.scale(scaling, "a", 5))
+}
+
+func (s *scalingTestSuite) TestStreamingQuantile() {
+	scaling := &StreamingMinMaxScaling{Min: -1, Max: 1, Quantile: 0.1}
+	values := make([]bitflow.Value, 100)
+	for i := range values {
+		values[i] = bitflow.Value(i)
+	}
+	s.scale(scaling, "a", values...)
+	res := s.scale(scaling, "a", 50, 1000, -1000)
+	s.InDelta(0, float64(res[0]), 0.05)
+	s.Equal([]bitflow.Value{1, -1}, res[1:], "Outliers must be clipped")
+}
+
+func (s *scalingTestSuite) TestStoredStats() {
+	dir, err := ioutil.TempDir("", "bitflow-stats-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "stats.ini")
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	s.Process(steps.NewStoreStats(file), header,
+		testsupport.NewSample(0, "", 2, 1), testsupport.NewSample(0, "", 4, 1), testsupport.NewSample(0, "", 6, 1))
+
+	stats, err := steps.LoadStats(file)
+	s.NoError(err)
+	s.Equal(steps.StoredFeatureStats{Mean: 4, Stddev: 2, Min: 2, Max: 6, Count: 3}, stats["a"])
+	s.Len(stats, 2)
+
+	standardize := &StreamingStandardizationScaling{Stats: stats}
+	s.Equal([]bitflow.Value{-1, 3}, s.scale(standardize, "a", 2, 10))
+	minMax := &StreamingMinMaxScaling{Min: 0, Max: 1, Stats: stats}
+	s.Equal([]bitflow.Value{0.5, 2}, s.scale(minMax, "a", 4, 10))
+
+	minMax.SetSink(new(bitflow.DroppingSampleProcessor))
+	s.Error(minMax.Sample(testsupport.NewSample(0, "", 1), &bitflow.Header{Fields: []string{"c"}}), "Missing statistics must be reported")
+
+	_, err = steps.LoadStats(filepath.Join(dir, "missing.ini"))
+	s.Error(err)
+}
@@ -0,0 +1,310 @@
+package math
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SGDLinear   = "linear"
+	SGDLogistic = "logistic"
+
+	DefaultSGDLearningRate   = 0.01
+	DefaultSGDDriftWindow    = 100
+	DefaultSGDDriftThreshold = 2
+
+	DriftTagValue  = "drift"
+	StableTagValue = "stable"
+)
+
+func RegisterSGDRegression(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("sgd_regression",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &SGDRegression{
+				Target:           reg.StrParam(params, "target", "", false, &err),
+				LearningRate:     reg.FloatParam(params, "rate", DefaultSGDLearningRate, true, &err),
+				L2:               reg.FloatParam(params, "l2", 0, true, &err),
+				SnapshotInterval: reg.DurationParam(params, "snapshot_interval", 0, true, &err),
+				DriftWindow:      reg.IntParam(params, "drift_window", DefaultSGDDriftWindow, true, &err),
+				DriftThreshold:   reg.FloatParam(params, "drift_threshold", DefaultSGDDriftThreshold, true, &err),
+				DriftTag:         reg.StrParam(params, "drift_tag", "", true, &err),
+			}
+			if inputs := params["inputs"]; inputs != "" {
+				step.Inputs = strings.Split(inputs, ",")
+			}
+			kind := reg.StrParam(params, "kind", SGDLinear, true, &err)
+			snapshot := reg.StrParam(params, "snapshot", "", true, &err)
+			if err != nil {
+				return
+			}
+			switch kind {
+			case SGDLinear:
+			case SGDLogistic:
+				step.Logistic = true
+			default:
+				return reg.ParameterError("kind", fmt.Errorf("Must be %v or %v", SGDLinear, SGDLogistic))
+			}
+			switch {
+			case step.LearningRate <= 0:
+				err = reg.ParameterError("rate", fmt.Errorf("Must be positive"))
+			case step.L2 < 0:
+				err = reg.ParameterError("l2", fmt.Errorf("Must not be negative"))
+			case step.DriftWindow < 0:
+				err = reg.ParameterError("drift_window", fmt.Errorf("Must not be negative"))
+			case step.DriftThreshold <= 1:
+				err = reg.ParameterError("drift_threshold", fmt.Errorf("Must be greater than 1"))
+			case step.SnapshotInterval > 0 && snapshot == "":
+				err = reg.ParameterError("snapshot_interval", fmt.Errorf("Requires the snapshot parameter"))
+			}
+			if err == nil {
+				if snapshot != "" {
+					step.Store = models.NewStore(snapshot)
+				}
+				p.Add(step)
+			}
+			return
+		},
+		"Incrementally train a linear or logistic (kind="+SGDLogistic+") regression model using stochastic gradient descent, predicting the target metric from the inputs (default: all other metrics). "+
+			"Every sample is first used for a prediction (appended with suffix "+PredictedSuffix+") and afterwards for updating the model. "+
+			"For logistic regression, target values above 0.5 are treated as 1, all others as 0. The input metrics should be scaled beforehand. "+
+			"If the snapshot parameter is given, the model is stored to that model location every snapshot_interval (measured in sample time) and when the input ends. "+
+			"Snapshots can be used by regression_load_stream. "+
+			"The step reports concept drift when the average loss over the last drift_window/10 samples exceeds drift_threshold times the average loss over the last drift_window samples. "+
+			"If drift_tag is given, samples are tagged with '"+DriftTagValue+"' or '"+StableTagValue+"'. A drift_window of 0 disables drift detection.",
+		reg.RequiredParams("target"),
+		reg.OptionalParams("inputs", "kind", "rate", "l2", "snapshot", "snapshot_interval", "drift_window", "drift_threshold", "drift_tag"))
+}
+
+// SGDRegression trains a LinearRegressionModel on a stream of samples using stochastic gradient descent.
+// The model is kept when the header changes, as long as the target and input metrics are still present.
+type SGDRegression struct {
+	bitflow.NoopProcessor
+	Target       string
+	Inputs       []string // If empty, all metrics except the target are used
+	Logistic     bool
+	LearningRate float64
+	L2           float64 // Strength of the L2 regularization of the coefficients
+
+	Store            *models.Store // Optional
+	SnapshotInterval time.Duration // If zero, a snapshot is only stored when the input ends
+
+	DriftWindow    int // Number of samples in the long-term loss average, disabled if zero
+	DriftThreshold float64
+	DriftTag       string // Optional
+
+	model        *LinearRegressionModel
+	checker      bitflow.HeaderChecker
+	outHeader    *bitflow.Header
+	targetIndex  int
+	indices      []int
+	inputs       []float64
+	numSamples   int
+	lastSnapshot time.Time
+	changed      bool // Model was updated since the last snapshot
+
+	shortLoss float64
+	longLoss  float64
+	drifting  bool
+}
+
+func (r *SGDRegression) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if r.checker.HeaderChanged(header) {
+		if err := r.headerChanged(header); err != nil {
+			return err
+		}
+	}
+	for i, index := range r.indices {
+		r.inputs[i] = float64(sample.Values[index])
+	}
+	prediction := r.model.Predict(r.inputs)
+	steps.AppendToSample(sample, []float64{prediction})
+
+	target := float64(sample.Values[r.targetIndex])
+	if !math.IsNaN(target) && !math.IsNaN(prediction) && !math.IsInf(prediction, 0) {
+		if r.Logistic {
+			if target > 0.5 {
+				target = 1
+			} else {
+				target = 0
+			}
+		}
+		r.update(prediction, target)
+		r.detectDrift(r.loss(prediction, target))
+	}
+	if r.DriftTag != "" {
+		if r.drifting {
+			sample.SetTag(r.DriftTag, DriftTagValue)
+		} else {
+			sample.SetTag(r.DriftTag, StableTagValue)
+		}
+	}
+
+	if r.SnapshotInterval > 0 {
+		if r.lastSnapshot.IsZero() {
+			r.lastSnapshot = sample.Time
+		} else if sample.Time.Sub(r.lastSnapshot) >= r.SnapshotInterval {
+			r.lastSnapshot = sample.Time
+			if err := r.snapshot(); err != nil {
+				return err
+			}
+		}
+	}
+	return r.NoopProcessor.Sample(sample, r.outHeader)
+}
+
+func (r *SGDRegression) headerChanged(header *bitflow.Header) error {
+	fieldIndices := header.BuildIndex()
+	targetIndex, ok := fieldIndices[r.Target]
+	if !ok {
+		return fmt.Errorf("%v: Target metric %v is missing", r, r.Target)
+	}
+	inputs := r.Inputs
+	if len(inputs) == 0 {
+		inputs = make([]string, 0, len(header.Fields)-1)
+		for _, field := range header.Fields {
+			if field != r.Target {
+				inputs = append(inputs, field)
+			}
+		}
+	}
+	indices := make([]int, len(inputs))
+	for i, input := range inputs {
+		index, ok := fieldIndices[input]
+		if !ok {
+			return fmt.Errorf("%v: Input metric %v is missing", r, input)
+		}
+		indices[i] = index
+	}
+	if r.model == nil || !golib.EqualStrings(r.model.Inputs, inputs) {
+		if r.model != nil {
+			log.Warnf("%v: Input metrics changed, training a new model after %v samples: %v", r, r.numSamples, r.model)
+		}
+		r.model = &LinearRegressionModel{
+			Target:       r.Target,
+			Inputs:       inputs,
+			Coefficients: make([]float64, len(inputs)),
+			Logistic:     r.Logistic,
+		}
+		r.numSamples = 0
+		r.shortLoss, r.longLoss, r.drifting = 0, 0, false
+	}
+	r.targetIndex = targetIndex
+	r.indices = indices
+	r.inputs = make([]float64, len(inputs))
+	fields := make([]string, len(header.Fields), len(header.Fields)+1)
+	copy(fields, header.Fields)
+	r.outHeader = header.Clone(append(fields, r.Target+PredictedSuffix))
+	return nil
+}
+
+// update performs one gradient descent step. For both the squared error of the linear model and the log-loss of
+// the logistic model, the gradient of the loss with respect to the coefficients is (prediction - target) * inputs.
+func (r *SGDRegression) update(prediction, target float64) {
+	diff := prediction - target
+	for i, val := range r.inputs {
+		if !math.IsNaN(val) {
+			r.model.Coefficients[i] -= r.LearningRate * (diff*val + r.L2*r.model.Coefficients[i])
+		}
+	}
+	r.model.Intercept -= r.LearningRate * diff
+	r.numSamples++
+	r.changed = true
+}
+
+func (r *SGDRegression) loss(prediction, target float64) float64 {
+	if r.Logistic {
+		const epsilon = 1e-15
+		prediction = math.Max(epsilon, math.Min(1-epsilon, prediction))
+		return -target*math.Log(prediction) - (1-target)*math.Log(1-prediction)
+	}
+	diff := prediction - target
+	return diff * diff
+}
+
+// detectDrift compares a short-term and a long-term exponentially weighted average of the prediction loss.
+// Drift is only reported after the long-term average has seen a full window of samples.
+func (r *SGDRegression) detectDrift(loss float64) {
+	if r.DriftWindow <= 0 {
+		return
+	}
+	shortWindow := r.DriftWindow / 10
+	if shortWindow < 1 {
+		shortWindow = 1
+	}
+	shortAlpha := 2 / (float64(shortWindow) + 1)
+	longAlpha := 2 / (float64(r.DriftWindow) + 1)
+	if r.numSamples == 1 {
+		r.shortLoss, r.longLoss = loss, loss
+	} else {
+		r.shortLoss += shortAlpha * (loss - r.shortLoss)
+		r.longLoss += longAlpha * (loss - r.longLoss)
+	}
+	if r.numSamples < r.DriftWindow {
+		return
+	}
+	drifting := r.shortLoss > r.DriftThreshold*r.longLoss
+	if drifting != r.drifting {
+		r.drifting = drifting
+		if drifting {
+			log.Warnf("%v: Concept drift detected after %v samples (recent loss %.4g, long-term loss %.4g)", r, r.numSamples, r.shortLoss, r.longLoss)
+		} else {
+			log.Printf("%v: Concept drift ended after %v samples (recent loss %.4g, long-term loss %.4g)", r, r.numSamples, r.shortLoss, r.longLoss)
+		}
+	}
+}
+
+func (r *SGDRegression) snapshot() error {
+	if r.Store == nil || !r.changed {
+		return nil
+	}
+	r.changed = false
+	fields := append([]string{r.model.Target}, r.model.Inputs...)
+	return storeModel(r.Store, RegressionModelType, fields, r.model)
+}
+
+func (r *SGDRegression) Close() {
+	if r.model != nil {
+		log.Printf("%v: Final model after %v samples: %v", r, r.numSamples, r.model)
+		if err := r.snapshot(); err != nil {
+			r.Error(err)
+		}
+	}
+	r.NoopProcessor.Close()
+}
+
+func (r *SGDRegression) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 1
+}
+
+func (r *SGDRegression) String() string {
+	kind := SGDLinear
+	if r.Logistic {
+		kind = SGDLogistic
+	}
+	res := fmt.Sprintf("SGD %v regression of %v", kind, r.Target)
+	if len(r.Inputs) > 0 {
+		res += fmt.Sprintf(" from %v", strings.Join(r.Inputs, ", "))
+	}
+	res += fmt.Sprintf(" (rate %v", r.LearningRate)
+	if r.L2 > 0 {
+		res += fmt.Sprintf(", l2 %v", r.L2)
+	}
+	res += ")"
+	if r.Store != nil {
+		res += fmt.Sprintf(" (snapshots to %v", r.Store)
+		if r.SnapshotInterval > 0 {
+			res += fmt.Sprintf(" every %v", r.SnapshotInterval)
+		}
+		res += ")"
+	}
+	return res
+}
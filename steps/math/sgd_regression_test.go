@@ -0,0 +1,163 @@
+package math
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps/models"
+	"github.com/stretchr/testify/suite"
+)
+
+type sgdRegressionTestSuite struct {
+	testsupport.Suite
+}
+
+func TestSGDRegression(t *testing.T) {
+	suite.Run(t, new(sgdRegressionTestSuite))
+}
+
+var sgdTestHeader = &bitflow.Header{Fields: []string{"x", "y"}}
+
+// linearSamples returns samples with a uniformly distributed x in [-1, 1] and y = f(x), one sample per second
+func (s *sgdRegressionTestSuite) linearSamples(num int, seed int64, f func(x float64) float64) []*bitflow.Sample {
+	rnd := rand.New(rand.NewSource(seed))
+	res := make([]*bitflow.Sample, num)
+	for i := range res {
+		x := rnd.Float64()*2 - 1
+		res[i] = testsupport.NewSample(time.Duration(i)*time.Second, "", bitflow.Value(x), bitflow.Value(f(x)))
+	}
+	return res
+}
+
+func (s *sgdRegressionTestSuite) TestUpdate() {
+	step := &SGDRegression{Target: "y", LearningRate: 0.1}
+	out := s.Process(step, sgdTestHeader,
+		testsupport.NewSample(0, "", 1, 2),
+		testsupport.NewSample(0, "", 2, 4),
+		testsupport.NewSample(0, "", 1, bitflow.Value(math.NaN())), // Not used for training
+		testsupport.NewSample(0, "", 1, 0))
+	out.AssertFields(s.T(), "x", "y", "y"+PredictedSuffix)
+	// First step: diff -2, coefficient and intercept 0.2. Second step: prediction 0.6, diff -3.4.
+	predictions := []float64{out.Values()[0][2], out.Values()[1][2], out.Values()[2][2], out.Values()[3][2]}
+	s.InDeltaSlice([]float64{0, 0.6, 1.42, 1.42}, predictions, 1e-12)
+	s.Equal(3, step.numSamples)
+}
+
+func (s *sgdRegressionTestSuite) TestLinear() {
+	step := &SGDRegression{Target: "y", LearningRate: 0.1}
+	s.Process(step, sgdTestHeader, s.linearSamples(2000, 1, func(x float64) float64 { return 2*x + 1 })...)
+	s.Equal([]string{"x"}, step.model.Inputs)
+	s.InDelta(2, step.model.Coefficients[0], 1e-3)
+	s.InDelta(1, step.model.Intercept, 1e-3)
+	s.False(step.model.Logistic)
+}
+
+func (s *sgdRegressionTestSuite) TestLogistic() {
+	step := &SGDRegression{Target: "y", LearningRate: 0.5, Logistic: true}
+	out := s.Process(step, sgdTestHeader, s.linearSamples(2000, 1, func(x float64) float64 {
+		if x > 0.2 {
+			return 5 // Target values above 0.5 are treated as 1
+		}
+		return 0
+	})...)
+	s.True(step.model.Logistic)
+	for _, values := range out.Values()[1000:] {
+		if values[0] > 0.4 {
+			s.True(values[2] > 0.8, "Wrong prediction %v for %v", values[2], values[0])
+		} else if values[0] < 0 {
+			s.True(values[2] < 0.2, "Wrong prediction %v for %v", values[2], values[0])
+		}
+	}
+	boundary := -step.model.Intercept / step.model.Coefficients[0]
+	s.InDelta(0.2, boundary, 0.1, "The decision boundary must be learned")
+}
+
+func (s *sgdRegressionTestSuite) TestDrift() {
+	samples := s.linearSamples(400, 1, func(x float64) float64 { return 2 * x })
+	for _, sample := range samples[200:] {
+		sample.Values[1] = -sample.Values[1]
+	}
+	out := s.Process(&SGDRegression{Target: "y", LearningRate: 0.05, DriftWindow: 50, DriftThreshold: 2, DriftTag: "drift"},
+		sgdTestHeader, samples...)
+	tags := out.Tags("drift")
+	for i, tag := range tags[:200] {
+		s.Equal(StableTagValue, tag, "Sample %v", i)
+	}
+	s.Contains(tags[200:220], DriftTagValue, "The changed relation must be detected as drift")
+	s.Equal(StableTagValue, tags[399], "The drift must end after the model adapted")
+}
+
+func (s *sgdRegressionTestSuite) TestSnapshots() {
+	dir, err := ioutil.TempDir("", "bitflow-sgd-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+
+	step := &SGDRegression{Target: "y", LearningRate: 0.1, Store: models.NewStore(filepath.Join(dir, "model.json")), SnapshotInterval: 10 * time.Second}
+	s.Process(step, sgdTestHeader, s.linearSamples(25, 1, func(x float64) float64 { return x })...)
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	s.NoError(err)
+	s.Len(files, 3, "Snapshots after 10s and 20s and at the end of the input")
+
+	// The final snapshot can be used by regression_load_stream
+	b := reg.NewProcessorRegistry()
+	RegisterLinearRegression(b)
+	analysis, ok := b.GetAnalysis("regression_load_stream")
+	s.True(ok)
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, map[string]string{"file": filepath.Join(dir, "model-2.json")}))
+	s.Equal(step.model, pipe.Processors[0].(*LinearRegressionPredictor).Model)
+	out := s.Process(pipe.Processors[0], &bitflow.Header{Fields: []string{"x"}}, testsupport.NewSample(0, "", 0.5))
+	s.Equal(step.model.Predict([]float64{0.5}), out.Values()[0][1])
+}
+
+func (s *sgdRegressionTestSuite) TestHeaderChange() {
+	step := &SGDRegression{Target: "y", LearningRate: 0.1}
+	step.SetSink(new(bitflow.DroppingSampleProcessor))
+	s.NoError(step.Sample(testsupport.NewSample(0, "", 1, 2), sgdTestHeader))
+	model := step.model
+
+	// Reordered metrics keep the model
+	s.NoError(step.Sample(testsupport.NewSample(0, "", 2, 1), &bitflow.Header{Fields: []string{"y", "x"}}))
+	s.True(model == step.model)
+	s.Equal(2, step.numSamples)
+
+	// Changed inputs reset the model
+	s.NoError(step.Sample(testsupport.NewSample(0, "", 2, 1, 3), &bitflow.Header{Fields: []string{"y", "x", "z"}}))
+	s.False(model == step.model)
+	s.Equal([]string{"x", "z"}, step.model.Inputs)
+	s.Equal(1, step.numSamples)
+
+	s.Error(step.Sample(testsupport.NewSample(0, "", 1), &bitflow.Header{Fields: []string{"x"}}), "The target metric is missing")
+	step = &SGDRegression{Target: "y", Inputs: []string{"z"}}
+	s.Error(step.Sample(testsupport.NewSample(0, "", 1, 2), sgdTestHeader), "The input metric is missing")
+}
+
+func (s *sgdRegressionTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterSGDRegression(b)
+	analysis, _ := b.GetAnalysis("sgd_regression")
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, map[string]string{"target": "y", "inputs": "a,b", "kind": SGDLogistic, "l2": "0.1"}))
+	step := pipe.Processors[0].(*SGDRegression)
+	s.Equal([]string{"a", "b"}, step.Inputs)
+	s.True(step.Logistic)
+	s.Equal("SGD logistic regression of y from a, b (rate 0.01, l2 0.1)", step.String())
+
+	for _, params := range []map[string]string{
+		{"target": "y", "kind": "poisson"},
+		{"target": "y", "rate": "0"},
+		{"target": "y", "l2": "-1"},
+		{"target": "y", "drift_threshold": "1"},
+		{"target": "y", "snapshot_interval": "1m"},
+	} {
+		s.Error(analysis.Func(new(bitflow.SamplePipeline), params), "Parameters %v", params)
+	}
+}
@@ -0,0 +1,231 @@
+package math
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+)
+
+func RegisterSmoothing(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("ewma",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			alpha := reg.FloatParam(params, "alpha", 0.3, true, &err)
+			if err == nil {
+				if alpha <= 0 || alpha > 1 {
+					err = reg.ParameterError("alpha", fmt.Errorf("Must be in ]0..1]"))
+				} else {
+					p.Add(&SmoothingProcessor{Smoothing: &EWMA{Alpha: alpha}})
+				}
+			}
+			return
+		},
+		"Append the exponentially weighted moving average of every metric (suffix _ewma). A higher alpha gives more weight to recent values.",
+		reg.OptionalParams("alpha"))
+
+	b.RegisterAnalysisParamsErr("holt_winters",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			hw := &HoltWinters{
+				Alpha:   reg.FloatParam(params, "alpha", 0.5, true, &err),
+				Beta:    reg.FloatParam(params, "beta", 0.1, true, &err),
+				Gamma:   reg.FloatParam(params, "gamma", 0.1, true, &err),
+				Season:  reg.IntParam(params, "season", 0, true, &err),
+				Horizon: reg.IntParam(params, "horizon", 1, true, &err),
+			}
+			if err == nil {
+				err = hw.Validate()
+			}
+			if err == nil {
+				p.Add(&SmoothingProcessor{Smoothing: hw})
+			}
+			return
+		},
+		"Apply Holt-Winters (triple exponential) smoothing to every metric and append the smoothed value (suffix _hw) and a forecast for the given number of samples ahead (suffix _hw_forecast). "+
+			"The season parameter is the length of one season in samples. Without a season, only level and trend are modelled (double exponential smoothing).",
+		reg.OptionalParams("alpha", "beta", "gamma", "season", "horizon"))
+}
+
+// Smoothing computes one or more output values for every value of one metric. For every metric, a separate
+// instance of the smoothing state is created through NewState().
+type Smoothing interface {
+	NewState() SmoothingState
+	Suffixes() []string
+	String() string
+}
+
+type SmoothingState interface {
+	Update(value float64) []float64
+}
+
+// SmoothingProcessor applies a Smoothing to every metric and appends the resulting values to the sample.
+// The state of all metrics is reset when the header changes.
+type SmoothingProcessor struct {
+	bitflow.NoopProcessor
+	Smoothing Smoothing
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	states    []SmoothingState
+}
+
+func (p *SmoothingProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	suffixes := p.Smoothing.Suffixes()
+	if p.checker.HeaderChanged(header) {
+		fields := make([]string, len(header.Fields), len(header.Fields)*(1+len(suffixes)))
+		copy(fields, header.Fields)
+		for _, field := range header.Fields {
+			for _, suffix := range suffixes {
+				fields = append(fields, field+suffix)
+			}
+		}
+		p.outHeader = &bitflow.Header{Fields: fields}
+		p.states = make([]SmoothingState, len(header.Fields))
+		for i := range p.states {
+			p.states[i] = p.Smoothing.NewState()
+		}
+	}
+	values := make([]float64, 0, len(header.Fields)*len(suffixes))
+	for i, state := range p.states {
+		values = append(values, state.Update(float64(sample.Values[i]))...)
+	}
+	steps.AppendToSample(sample, values)
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *SmoothingProcessor) OutputSampleSize(sampleSize int) int {
+	return sampleSize * (1 + len(p.Smoothing.Suffixes()))
+}
+
+func (p *SmoothingProcessor) String() string {
+	return p.Smoothing.String()
+}
+
+// EWMA is the exponentially weighted moving average: s = alpha * x + (1 - alpha) * s
+type EWMA struct {
+	Alpha float64
+}
+
+func (e *EWMA) NewState() SmoothingState {
+	return &ewmaState{alpha: e.Alpha}
+}
+
+func (e *EWMA) Suffixes() []string {
+	return []string{"_ewma"}
+}
+
+func (e *EWMA) String() string {
+	return fmt.Sprintf("EWMA (alpha %v)", e.Alpha)
+}
+
+type ewmaState struct {
+	alpha       float64
+	value       float64
+	initialized bool
+}
+
+func (s *ewmaState) Update(value float64) []float64 {
+	if !s.initialized {
+		s.value = value
+		s.initialized = true
+	} else {
+		s.value = s.alpha*value + (1-s.alpha)*s.value
+	}
+	return []float64{s.value}
+}
+
+// HoltWinters implements additive triple exponential smoothing. Alpha, Beta and Gamma control the smoothing of the
+// level, trend and seasonal components. Season is the number of samples in one season; if it is zero, the
+// seasonal component is omitted. The first season is used to initialize the seasonal component, during this
+// time the forecast is NaN.
+type HoltWinters struct {
+	Alpha   float64
+	Beta    float64
+	Gamma   float64
+	Season  int
+	Horizon int
+}
+
+func (h *HoltWinters) Validate() error {
+	for _, param := range []struct {
+		name  string
+		value float64
+	}{{"alpha", h.Alpha}, {"beta", h.Beta}, {"gamma", h.Gamma}} {
+		if param.value < 0 || param.value > 1 {
+			return reg.ParameterError(param.name, fmt.Errorf("Must be in [0..1]"))
+		}
+	}
+	if h.Season < 0 {
+		return reg.ParameterError("season", fmt.Errorf("Must not be negative"))
+	}
+	if h.Horizon < 1 {
+		return reg.ParameterError("horizon", fmt.Errorf("Must be positive"))
+	}
+	return nil
+}
+
+func (h *HoltWinters) NewState() SmoothingState {
+	return &holtWintersState{HoltWinters: h, seasonal: make([]float64, h.Season)}
+}
+
+func (h *HoltWinters) Suffixes() []string {
+	return []string{"_hw", "_hw_forecast"}
+}
+
+func (h *HoltWinters) String() string {
+	return fmt.Sprintf("Holt-Winters (alpha %v, beta %v, gamma %v, season %v, horizon %v)", h.Alpha, h.Beta, h.Gamma, h.Season, h.Horizon)
+}
+
+type holtWintersState struct {
+	*HoltWinters
+	level    float64
+	trend    float64
+	seasonal []float64
+	initial  []float64 // Values of the first season, used for initialization
+	num      int
+}
+
+func (s *holtWintersState) Update(value float64) []float64 {
+	defer func() { s.num++ }()
+	if s.num < s.Season {
+		s.initial = append(s.initial, value)
+		if len(s.initial) == s.Season {
+			var sum float64
+			for _, val := range s.initial {
+				sum += val
+			}
+			s.level = sum / float64(s.Season)
+			for i, val := range s.initial {
+				s.seasonal[i] = val - s.level
+			}
+			s.initial = nil
+		}
+		return []float64{value, math.NaN()}
+	}
+	if s.num == 0 {
+		s.level = value
+		return []float64{value, s.forecast()}
+	}
+
+	var season float64
+	if s.Season > 0 {
+		season = s.seasonal[s.num%s.Season]
+	}
+	level := s.Alpha*(value-season) + (1-s.Alpha)*(s.level+s.trend)
+	s.trend = s.Beta*(level-s.level) + (1-s.Beta)*s.trend
+	s.level = level
+	if s.Season > 0 {
+		season = s.Gamma*(value-level) + (1-s.Gamma)*season
+		s.seasonal[s.num%s.Season] = season
+	}
+	return []float64{level + season, s.forecast()}
+}
+
+func (s *holtWintersState) forecast() float64 {
+	res := s.level + float64(s.Horizon)*s.trend
+	if s.Season > 0 {
+		res += s.seasonal[(s.num+s.Horizon)%s.Season]
+	}
+	return res
+}
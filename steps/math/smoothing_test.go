@@ -0,0 +1,69 @@
+package math
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type smoothingTestSuite struct {
+	testsupport.Suite
+}
+
+func TestSmoothing(t *testing.T) {
+	suite.Run(t, new(smoothingTestSuite))
+}
+
+// update feeds the values into a new state of the smoothing and returns all outputs
+func (s *smoothingTestSuite) update(smoothing Smoothing, values ...float64) [][]float64 {
+	state := smoothing.NewState()
+	res := make([][]float64, len(values))
+	for i, val := range values {
+		res[i] = state.Update(val)
+	}
+	return res
+}
+
+func (s *smoothingTestSuite) TestEWMA() {
+	s.Equal([][]float64{{4}, {6}, {3}, {3}}, s.update(&EWMA{Alpha: 0.5}, 4, 8, 0, 3))
+	s.Equal([][]float64{{4}, {8}, {0}}, s.update(&EWMA{Alpha: 1}, 4, 8, 0))
+}
+
+func (s *smoothingTestSuite) TestHolt() {
+	s.Equal([][]float64{{10, 10}, {11, 11.5}, {12.75, 13.875}},
+		s.update(&HoltWinters{Alpha: 0.5, Beta: 0.5, Horizon: 1}, 10, 12, 14))
+
+	// Without smoothing, a linear trend is forecasted exactly
+	s.Equal([][]float64{{10, 10}, {12, 14}, {14, 16}, {16, 18}},
+		s.update(&HoltWinters{Alpha: 1, Beta: 1, Horizon: 1}, 10, 12, 14, 16))
+	s.Equal([]float64{16, 22}, s.update(&HoltWinters{Alpha: 1, Beta: 1, Horizon: 3}, 10, 12, 14, 16)[3])
+}
+
+func (s *smoothingTestSuite) TestHoltWinters() {
+	res := s.update(&HoltWinters{Alpha: 0.5, Gamma: 0.5, Season: 2, Horizon: 1}, 1, 3, 1, 3, 1)
+	s.Equal(1.0, res[0][0])
+	s.Equal(3.0, res[1][0])
+	s.True(math.IsNaN(res[0][1]) && math.IsNaN(res[1][1]), "No forecasts during the first season")
+	// The seasonal pattern is forecasted exactly
+	s.Equal([][]float64{{1, 3}, {3, 1}, {1, 3}}, res[2:])
+}
+
+func (s *smoothingTestSuite) TestProcessor() {
+	step := &SmoothingProcessor{Smoothing: &EWMA{Alpha: 0.5}}
+	out := s.Process(step, &bitflow.Header{Fields: []string{"a", "b"}},
+		testsupport.NewSample(0, "", 2, 10),
+		testsupport.NewSample(0, "", 4, 20))
+	out.AssertFields(s.T(), "a", "b", "a_ewma", "b_ewma")
+	out.AssertValues(s.T(), [][]float64{{2, 10, 2, 10}, {4, 20, 3, 15}})
+}
+
+func (s *smoothingTestSuite) TestValidate() {
+	s.NoError((&HoltWinters{Alpha: 0.5, Horizon: 1}).Validate())
+	s.Error((&HoltWinters{Alpha: 1.5, Horizon: 1}).Validate())
+	s.Error((&HoltWinters{Beta: -0.1, Horizon: 1}).Validate())
+	s.Error((&HoltWinters{Season: -1, Horizon: 1}).Validate())
+	s.Error((&HoltWinters{Horizon: 0}).Validate())
+}
@@ -0,0 +1,135 @@
+package math
+
+import (
+	"math"
+	"sort"
+)
+
+// TDigest is a compact summary of a distribution of values, which allows estimating quantiles with a bounded
+// amount of memory. The accuracy is highest for extreme quantiles (e.g. 0.99). This is an implementation of the
+// merging t-digest by Ted Dunning. Higher values for Compression increase the accuracy and the memory consumption.
+type TDigest struct {
+	Compression float64
+
+	centroids []tdigestCentroid // Sorted by mean
+	buffer    []tdigestCentroid
+	total     float64
+	min, max  float64
+}
+
+type tdigestCentroid struct {
+	mean   float64
+	weight float64
+}
+
+const DefaultTDigestCompression = 100
+
+func NewTDigest(compression float64) *TDigest {
+	if compression <= 0 {
+		compression = DefaultTDigestCompression
+	}
+	return &TDigest{
+		Compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Add adds one value to the digest. NaN values are ignored.
+func (t *TDigest) Add(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	t.buffer = append(t.buffer, tdigestCentroid{mean: value, weight: 1})
+	t.min = math.Min(t.min, value)
+	t.max = math.Max(t.max, value)
+	if len(t.buffer) >= int(t.Compression)*5 {
+		t.merge()
+	}
+}
+
+// Count returns the number of values added to the digest.
+func (t *TDigest) Count() float64 {
+	return t.total + float64(len(t.buffer))
+}
+
+// Reset removes all values from the digest.
+func (t *TDigest) Reset() {
+	*t = *NewTDigest(t.Compression)
+}
+
+func (t *TDigest) merge() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buffer...)
+	t.buffer = t.buffer[:0]
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].mean < all[j].mean
+	})
+	t.total = 0
+	for _, c := range all {
+		t.total += c.weight
+	}
+
+	merged := make([]tdigestCentroid, 0, len(t.centroids)+1)
+	current := all[0]
+	weightSoFar := 0.0
+	kLeft := t.scale(0)
+	for _, c := range all[1:] {
+		q := (weightSoFar + current.weight + c.weight) / t.total
+		if t.scale(q)-kLeft <= 1 {
+			newWeight := current.weight + c.weight
+			current.mean += (c.mean - current.mean) * c.weight / newWeight
+			current.weight = newWeight
+		} else {
+			weightSoFar += current.weight
+			merged = append(merged, current)
+			current = c
+			kLeft = t.scale(weightSoFar / t.total)
+		}
+	}
+	t.centroids = append(merged, current)
+}
+
+// scale is the k1 scale function of the t-digest. Every centroid covers at most one unit of the scale, which
+// limits the number of centroids to Compression, while keeping the centroids at the tails small.
+func (t *TDigest) scale(q float64) float64 {
+	return t.Compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// Quantile estimates the value at the given quantile (0..1). The result is NaN, if no values were added.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.merge()
+	switch {
+	case len(t.centroids) == 0:
+		return math.NaN()
+	case q <= 0:
+		return t.min
+	case q >= 1:
+		return t.max
+	case len(t.centroids) == 1:
+		return t.centroids[0].mean
+	}
+
+	target := q * t.total
+	// Every centroid represents the values around its mean, the center is located at half of its weight
+	prevCenter, prevMean := 0.0, t.min
+	cumulative := 0.0
+	for _, c := range t.centroids {
+		center := cumulative + c.weight/2
+		if target < center {
+			if center == prevCenter {
+				return c.mean
+			}
+			return prevMean + (c.mean-prevMean)*(target-prevCenter)/(center-prevCenter)
+		}
+		cumulative += c.weight
+		prevCenter, prevMean = center, c.mean
+	}
+	// Between the center of the last centroid and the maximum
+	if t.total == prevCenter {
+		return t.max
+	}
+	return prevMean + (t.max-prevMean)*(target-prevCenter)/(t.total-prevCenter)
+}
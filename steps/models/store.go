@@ -0,0 +1,315 @@
+// Package models provides a generic way for storing and loading trained models, which allows training models in one
+// pipeline and using them in another one.
+//
+// Models are addressed through locations, which can have one of the following forms:
+//
+//	model://<name>         A model in the model repository (see Repository). When storing, a new version is created. When loading, the latest version is used.
+//	model://<name>@<num>   A specific version of a model in the model repository.
+//	http://... https://... Models are loaded with a GET request and stored with a PUT request.
+//	<anything else>        A file. When storing, a new numbered file is created for every model (see bitflow.FileGroup).
+//
+// Every model is stored together with its Metadata, encoded as binary gob.
+package models
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FormatVersion is incremented when the encoding of stored models changes in an incompatible way.
+	FormatVersion = 1
+
+	LocationPrefix  = "model://"
+	VersionSep      = "@"
+	LatestVersion   = "latest"
+	ModelFileSuffix = ".model"
+)
+
+// Repository is the base location for model://<name> locations. It can be a directory, or the base URL of an HTTP server.
+// Models in a directory are stored as <Repository>/<name>/<version>.model. An HTTP server must accept
+// POST requests to <Repository>/<name> for storing a new version, and GET requests to <Repository>/<name>/<version>,
+// where the version can also be 'latest'.
+var Repository = "models"
+
+// Metadata is stored together with every model.
+type Metadata struct {
+	Type          string // Identifies the kind of model, for example "pca". Checked when loading a model.
+	FormatVersion int
+	Version       int // Version inside the model repository, 0 for other locations.
+	Created       time.Time
+	Fields        []string // The metrics used for training the model
+	Description   string
+}
+
+func (m *Metadata) String() string {
+	res := m.Type + " model"
+	if m.Version > 0 {
+		res += fmt.Sprintf(" version %v", m.Version)
+	}
+	if !m.Created.IsZero() {
+		res += fmt.Sprintf(" (created %v)", m.Created.Format(time.RFC3339))
+	}
+	return res
+}
+
+// Store saves models to a location. For file locations, every model is stored in a new numbered file.
+type Store struct {
+	Location string
+
+	group   bitflow.FileGroup
+	counter int
+}
+
+func NewStore(location string) *Store {
+	return &Store{Location: location, group: bitflow.NewFileGroup(location)}
+}
+
+func (s *Store) String() string {
+	return s.Location
+}
+
+// Save stores the given model. The metadata is completed with the creation time, format version
+// and model version (for repository locations).
+func (s *Store) Save(metadata Metadata, model interface{}) error {
+	metadata.FormatVersion = FormatVersion
+	metadata.Created = time.Now()
+	var err error
+	var target string
+	switch {
+	case strings.HasPrefix(s.Location, LocationPrefix):
+		target, err = s.saveToRepository(&metadata, model)
+	case isHttp(s.Location):
+		target = s.Location
+		err = saveHttp(http.MethodPut, target, metadata, model)
+	default:
+		target, err = s.saveToFile(metadata, model)
+	}
+	if err == nil {
+		log.Printf("Stored %v to %v", &metadata, target)
+	} else {
+		err = fmt.Errorf("Failed to store %v model to %v: %v", metadata.Type, s.Location, err)
+	}
+	return err
+}
+
+func (s *Store) saveToFile(metadata Metadata, model interface{}) (string, error) {
+	file, err := s.group.OpenNewFile(&s.counter)
+	if err != nil {
+		return "", err
+	}
+	err = Encode(file, metadata, model)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return file.Name(), err
+}
+
+func (s *Store) saveToRepository(metadata *Metadata, model interface{}) (string, error) {
+	name, version, err := parseRepositoryLocation(s.Location)
+	if err != nil {
+		return "", err
+	}
+	if version != "" {
+		return "", fmt.Errorf("Cannot store a specific version of a model, a new version is created automatically")
+	}
+	if isHttp(Repository) {
+		target := strings.TrimSuffix(Repository, "/") + "/" + name
+		return target, saveHttp(http.MethodPost, target, *metadata, model)
+	}
+
+	dir := filepath.Join(Repository, name)
+	if err := os.MkdirAll(dir, bitflow.MkdirsPermissions); err != nil {
+		return "", err
+	}
+	versions, err := listVersions(dir)
+	if err != nil {
+		return "", err
+	}
+	metadata.Version = 1
+	if len(versions) > 0 {
+		metadata.Version = versions[len(versions)-1] + 1
+	}
+	filename := filepath.Join(dir, strconv.Itoa(metadata.Version)+ModelFileSuffix)
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	err = Encode(file, *metadata, model)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return filename, err
+}
+
+func saveHttp(method string, url string, metadata Metadata, model interface{}) error {
+	var buf bytes.Buffer
+	if err := Encode(&buf, metadata, model); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Load loads a model from the given location into the model parameter, which must be a pointer.
+// The type stored in the metadata must match the given model type. Files that were stored without metadata
+// (before the model store was introduced) can still be loaded; in that case, the metadata only contains the type.
+func Load(location string, modelType string, model interface{}) (*Metadata, error) {
+	data, err := readLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v model from %v: %v", modelType, location, err)
+	}
+	metadata, err := Decode(data, modelType, model)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v model from %v: %v", modelType, location, err)
+	}
+	log.Printf("Loaded %v from %v", metadata, location)
+	return metadata, nil
+}
+
+func readLocation(location string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(location, LocationPrefix):
+		name, version, err := parseRepositoryLocation(location)
+		if err != nil {
+			return nil, err
+		}
+		if isHttp(Repository) {
+			if version == "" {
+				version = LatestVersion
+			}
+			return readHttp(strings.TrimSuffix(Repository, "/") + "/" + name + "/" + version)
+		}
+		dir := filepath.Join(Repository, name)
+		if version == "" {
+			versions, err := listVersions(dir)
+			if err != nil {
+				return nil, err
+			}
+			if len(versions) == 0 {
+				return nil, fmt.Errorf("No versions of model %v found in %v", name, dir)
+			}
+			version = strconv.Itoa(versions[len(versions)-1])
+		}
+		return ioutil.ReadFile(filepath.Join(dir, version+ModelFileSuffix))
+	case isHttp(location):
+		return readHttp(location)
+	default:
+		return ioutil.ReadFile(location)
+	}
+}
+
+func readHttp(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("HTTP %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return body, err
+}
+
+// Encode writes the metadata, followed by the model.
+func Encode(writer io.Writer, metadata Metadata, model interface{}) error {
+	encoder := gob.NewEncoder(writer)
+	if err := encoder.Encode(metadata); err != nil {
+		return fmt.Errorf("Failed to marshal model metadata to binary gob: %v", err)
+	}
+	if err := encoder.Encode(model); err != nil {
+		return fmt.Errorf("Failed to marshal %T to binary gob: %v", model, err)
+	}
+	return nil
+}
+
+// Decode reads data written by Encode. If the data does not start with metadata, it is decoded as a plain model.
+func Decode(data []byte, modelType string, model interface{}) (*Metadata, error) {
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	var metadata Metadata
+	if err := decoder.Decode(&metadata); err != nil || metadata.Type == "" {
+		// Model stored without metadata
+		if legacyErr := gob.NewDecoder(bytes.NewReader(data)).Decode(model); legacyErr != nil {
+			return nil, legacyErr
+		}
+		return &Metadata{Type: modelType}, nil
+	}
+	if metadata.Type != modelType {
+		return nil, fmt.Errorf("Expected a %v model, but found a %v model", modelType, metadata.Type)
+	}
+	if metadata.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("Unsupported model format version %v (supported up to %v)", metadata.FormatVersion, FormatVersion)
+	}
+	if err := decoder.Decode(model); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func parseRepositoryLocation(location string) (name string, version string, err error) {
+	name = strings.TrimPrefix(location, LocationPrefix)
+	if index := strings.LastIndex(name, VersionSep); index >= 0 {
+		name, version = name[:index], name[index+1:]
+		if _, parseErr := strconv.Atoi(version); parseErr != nil && version != LatestVersion {
+			err = fmt.Errorf("Invalid model version in %v: %v", location, version)
+		}
+		if version == LatestVersion {
+			version = ""
+		}
+	}
+	if err == nil && (name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".")) {
+		err = fmt.Errorf("Invalid model name in %v", location)
+	}
+	return
+}
+
+// listVersions returns the sorted versions of all models in the given repository directory
+func listVersions(dir string) ([]int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var versions []int
+	for _, file := range files {
+		if name := file.Name(); !file.IsDir() && strings.HasSuffix(name, ModelFileSuffix) {
+			if version, err := strconv.Atoi(strings.TrimSuffix(name, ModelFileSuffix)); err == nil {
+				versions = append(versions, version)
+			}
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func isHttp(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
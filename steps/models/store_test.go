@@ -0,0 +1,71 @@
+package models
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type storeTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+type testModel struct {
+	Values []float64
+}
+
+func TestModelStore(t *testing.T) {
+	suite.Run(t, new(storeTestSuite))
+}
+
+func (s *storeTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "bitflow-models")
+	s.NoError(err)
+	s.dir = dir
+	Repository = filepath.Join(dir, "repo")
+}
+
+func (s *storeTestSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func (s *storeTestSuite) TestRepositoryVersions() {
+	store := NewStore("model://test")
+	s.NoError(store.Save(Metadata{Type: "test"}, &testModel{Values: []float64{1}}))
+	s.NoError(store.Save(Metadata{Type: "test"}, &testModel{Values: []float64{2}}))
+
+	var model testModel
+	metadata, err := Load("model://test", "test", &model)
+	s.NoError(err)
+	s.Equal(2, metadata.Version)
+	s.Equal([]float64{2}, model.Values)
+
+	metadata, err = Load("model://test@1", "test", &model)
+	s.NoError(err)
+	s.Equal(1, metadata.Version)
+	s.Equal([]float64{1}, model.Values)
+
+	_, err = Load("model://test", "other", &model)
+	s.Error(err)
+	_, err = Load("model://missing", "test", &model)
+	s.Error(err)
+}
+
+func (s *storeTestSuite) TestLegacyFile() {
+	var buf bytes.Buffer
+	s.NoError(gob.NewEncoder(&buf).Encode(&testModel{Values: []float64{3}}))
+	file := filepath.Join(s.dir, "legacy.bin")
+	s.NoError(ioutil.WriteFile(file, buf.Bytes(), 0644))
+
+	var model testModel
+	metadata, err := Load(file, "test", &model)
+	s.NoError(err)
+	s.Equal("test", metadata.Type)
+	s.Equal([]float64{3}, model.Values)
+}
@@ -0,0 +1,272 @@
+package msgqueue
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultNatsPort = 4222
+
+	// DefaultAckWait is the time after which unacknowledged JetStream messages are delivered again
+	DefaultAckWait = 30 * time.Second
+
+	natsRequestTimeout = 10 * time.Second
+)
+
+// JetStream stores messages in a NATS JetStream stream (https://docs.nats.io/nats-concepts/jetstream). Messages
+// are published to Subject and received through a durable pull consumer, which is shared by all consumers
+// using the same Durable name. The connection is handled by the nats.go client, which reconnects indefinitely after
+// connection failures.
+type JetStream struct {
+	Address  string
+	User     string
+	Password string
+	Token    string
+	TLS      *tls.Config // Optional
+
+	Stream  string
+	Subject string
+	Durable string
+
+	// Create makes the stream created with the default configuration, if it does not exist.
+	Create bool
+
+	// StartNew makes a newly created consumer only receive messages that are added afterwards.
+	// By default, the consumer receives all messages stored in the stream.
+	StartNew bool
+
+	// AckWait is used when creating the durable consumer. Default: DefaultAckWait
+	AckWait time.Duration
+
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	consumer jetstream.Consumer
+
+	// ctx is canceled by Close to interrupt a waiting Receive call
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// received contains the messages of the last Receive call, until they are acknowledged
+	received map[string]jetstream.Msg
+}
+
+// NewJetStream parses a target like [user:password@]host[:port]/stream. Supported parameters: subject (the subject
+// of published messages and the filter of the consumer, default: the stream name), group (name of the durable
+// consumer, default DefaultGroup), token (authentication token), create (create the stream if necessary,
+// default true), start (all or new, see StartNew), ack-wait (see AckWait) and the TLS parameters described in
+// RegisterMessageQueues.
+func NewJetStream(target string, params map[string]string) (*JetStream, error) {
+	u, err := url.Parse("nats://" + target)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid NATS endpoint '%v': %v", target, err)
+	}
+	js := &JetStream{
+		Address: u.Host,
+		Stream:  strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.Port() == "" {
+		js.Address = net.JoinHostPort(u.Hostname(), strconv.Itoa(DefaultNatsPort))
+	}
+	if u.User != nil {
+		js.User = u.User.Username()
+		js.Password, _ = u.User.Password()
+	}
+	if u.Hostname() == "" || js.Stream == "" || strings.ContainsAny(js.Stream, ".*> ") {
+		return nil, fmt.Errorf("JetStream endpoint must have the format [user:password@]host[:port]/stream, not '%v'", target)
+	}
+	js.Subject = reg.StrParam(params, "subject", js.Stream, true, &err)
+	js.Durable = reg.StrParam(params, "group", DefaultGroup, true, &err)
+	js.Token = reg.StrParam(params, "token", "", true, &err)
+	js.Create = reg.BoolParam(params, "create", true, true, &err)
+	start := reg.StrParam(params, "start", "all", true, &err)
+	js.AckWait = reg.DurationParam(params, "ack-wait", DefaultAckWait, true, &err)
+	js.TLS = tlsParams(params, u.Hostname(), &err)
+	if err == nil {
+		err = checkUnknownParams(params, append([]string{"subject", "group", "token", "create", "start", "ack-wait"}, tlsParamNames...)...)
+	}
+	if err == nil && start != "all" && start != "new" {
+		err = reg.ParameterError("start", errors.New("Must be 'all' or 'new'"))
+	}
+	js.StartNew = start == "new"
+	return js, err
+}
+
+func (js *JetStream) String() string {
+	return fmt.Sprintf("jetstream://%v/%v (subject %v, group %v)", js.Address, js.Stream, js.Subject, js.Durable)
+}
+
+// Open implements the Queue interface.
+func (js *JetStream) Open(consume bool) error {
+	options := []nats.Option{
+		nats.Name("bitflow"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Warnln("Disconnected from", js, err)
+			}
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			log.Println("Reconnected to", js)
+		}),
+	}
+	if js.User != "" {
+		options = append(options, nats.UserInfo(js.User, js.Password))
+	}
+	if js.Token != "" {
+		options = append(options, nats.Token(js.Token))
+	}
+	if js.TLS != nil {
+		options = append(options, nats.Secure(js.TLS))
+	}
+	conn, err := nats.Connect("nats://"+js.Address, options...)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to NATS server %v: %v", js.Address, err)
+	}
+	js.conn = conn
+	js.ctx, js.cancel = context.WithCancel(context.Background())
+	if js.js, err = jetstream.New(conn); err != nil {
+		return js.openFailed(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), natsRequestTimeout)
+	defer cancel()
+	if js.Create {
+		if err := js.createStream(ctx); err != nil {
+			return js.openFailed(err)
+		}
+	}
+	if consume {
+		if err := js.createConsumer(ctx); err != nil {
+			return js.openFailed(err)
+		}
+	}
+	return nil
+}
+
+func (js *JetStream) openFailed(err error) error {
+	_ = js.Close()
+	return fmt.Errorf("Failed to initialize %v: %v", js, err)
+}
+
+func (js *JetStream) createStream(ctx context.Context) error {
+	_, err := js.js.Stream(ctx, js.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		log.Println("Creating JetStream stream", js.Stream, "for subject", js.Subject)
+		_, err = js.js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     js.Stream,
+			Subjects: []string{js.Subject},
+		})
+	}
+	return err
+}
+
+func (js *JetStream) createConsumer(ctx context.Context) error {
+	deliver := jetstream.DeliverAllPolicy
+	if js.StartNew {
+		deliver = jetstream.DeliverNewPolicy
+	}
+	ackWait := js.AckWait
+	if ackWait <= 0 {
+		ackWait = DefaultAckWait
+	}
+	var err error
+	js.consumer, err = js.js.CreateOrUpdateConsumer(ctx, js.Stream, jetstream.ConsumerConfig{
+		Durable:       js.Durable,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		DeliverPolicy: deliver,
+		FilterSubject: js.Subject,
+		AckWait:       ackWait,
+	})
+	return err
+}
+
+// Publish implements the Queue interface. It waits for the acknowledgement of the stream.
+func (js *JetStream) Publish(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), natsRequestTimeout)
+	defer cancel()
+	_, err := js.js.Publish(ctx, js.Subject, data)
+	return err
+}
+
+// Receive implements the Queue interface. It fetches the available messages from the durable consumer, or waits
+// for the next message, if there are none.
+func (js *JetStream) Receive(max int, timeout time.Duration) ([]Message, error) {
+	js.received = make(map[string]jetstream.Msg, max)
+	messages, err := js.fetch(js.consumer.FetchNoWait(max))
+	if err != nil || len(messages) > 0 {
+		return messages, err
+	}
+	// Heartbeats of the server detect pull requests that were lost, e.g. when the server restarted
+	ctx, cancel := context.WithTimeout(js.ctx, timeout)
+	defer cancel()
+	return js.fetch(js.consumer.Fetch(1, jetstream.FetchContext(ctx), jetstream.FetchHeartbeat(timeout/4)))
+}
+
+func (js *JetStream) fetch(batch jetstream.MessageBatch, err error) ([]Message, error) {
+	if err != nil {
+		return nil, natsConnError(err)
+	}
+	var result []Message
+	for msg := range batch.Messages() {
+		js.received[msg.Reply()] = msg
+		result = append(result, Message{Id: msg.Reply(), Data: msg.Data()})
+	}
+	if err := batch.Error(); err != nil {
+		// The received messages are not acknowledged and delivered again
+		return nil, natsConnError(err)
+	}
+	return result, nil
+}
+
+// Ack implements the Queue interface. It waits until the acknowledgement is confirmed by the server.
+func (js *JetStream) Ack(msg Message) error {
+	received, ok := js.received[msg.Id]
+	if !ok {
+		return fmt.Errorf("Message %v was not received by the last call to Receive", msg.Id)
+	}
+	delete(js.received, msg.Id)
+	ctx, cancel := context.WithTimeout(context.Background(), natsRequestTimeout)
+	defer cancel()
+	err := received.DoubleAck(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// The acknowledgement was not confirmed, e.g. because the connection was lost
+		return unavailable(err)
+	}
+	return natsConnError(err)
+}
+
+// Close implements the Queue interface.
+func (js *JetStream) Close() error {
+	if js.conn == nil {
+		return nil
+	}
+	js.cancel()
+	js.conn.Close()
+	return nil
+}
+
+// natsConnError marks the errors caused by a lost connection as ErrUnavailable
+func natsConnError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, connErr := range []error{nats.ErrConnectionReconnecting, nats.ErrDisconnected, nats.ErrTimeout, nats.ErrNoResponders,
+		jetstream.ErrNoHeartbeat, jetstream.ErrServerShutdown} {
+		if errors.Is(err, connErr) {
+			return unavailable(err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package msgqueue
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/suite"
+)
+
+type jetStreamTestSuite struct {
+	messageQueueTestSuite
+}
+
+func TestJetStream(t *testing.T) {
+	suite.Run(t, new(jetStreamTestSuite))
+}
+
+// runServer starts a NATS server with JetStream enabled. A port of -1 selects a random port.
+func (s *jetStreamTestSuite) runServer(port int, storeDir string, tlsConfig *tls.Config) *server.Server {
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      port,
+		JetStream: true,
+		StoreDir:  storeDir,
+		TLSConfig: tlsConfig,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	s.NoError(err)
+	go srv.Start()
+	s.True(srv.ReadyForConnections(5*time.Second), "NATS server not ready")
+	return srv
+}
+
+func (s *jetStreamTestSuite) stream(srv *server.Server) jetstream.Stream {
+	conn, err := nats.Connect(srv.ClientURL())
+	s.NoError(err)
+	s.T().Cleanup(conn.Close)
+	js, err := jetstream.New(conn)
+	s.NoError(err)
+	stream, err := js.Stream(context.Background(), "SAMPLES")
+	s.NoError(err)
+	return stream
+}
+
+// numUnacked returns the number of messages that were not delivered or not acknowledged
+func (s *jetStreamTestSuite) numUnacked(srv *server.Server) uint64 {
+	consumer, err := s.stream(srv).Consumer(context.Background(), DefaultGroup)
+	s.NoError(err)
+	info, err := consumer.Info(context.Background())
+	s.NoError(err)
+	return info.NumPending + uint64(info.NumAckPending)
+}
+
+func (s *jetStreamTestSuite) TestParams() {
+	js, err := NewJetStream("user:pass@localhost/SAMPLES", map[string]string{"subject": "samples.in", "ack-wait": "1m", "create": "false"})
+	s.NoError(err)
+	s.Equal("localhost:4222", js.Address)
+	s.Equal("user", js.User)
+	s.Equal("pass", js.Password)
+	s.Equal("SAMPLES", js.Stream)
+	s.Equal("samples.in", js.Subject)
+	s.Equal(DefaultGroup, js.Durable)
+	s.Equal(time.Minute, js.AckWait)
+	s.False(js.Create)
+	s.Nil(js.TLS)
+
+	js, err = NewJetStream("localhost/SAMPLES", map[string]string{"tls": "true"})
+	s.NoError(err)
+	s.Equal("localhost", js.TLS.ServerName)
+
+	_, err = NewJetStream("localhost/invalid.stream", nil)
+	s.Error(err)
+	_, err = NewJetStream("localhost/S", map[string]string{"unknown": "x"})
+	s.Error(err)
+}
+
+func (s *jetStreamTestSuite) TestConsumers() {
+	srv := s.runServer(-1, s.T().TempDir(), nil)
+	defer srv.Shutdown()
+	endpoint := "jetstream://" + srv.Addr().String() + "/SAMPLES?subject=samples.test&ack-wait=50ms"
+
+	s.publish(endpoint+"&batch=10", valueRange(0, 25)...)
+	info, err := s.stream(srv).Info(context.Background())
+	s.NoError(err)
+	s.Equal([]string{"samples.test"}, info.Config.Subjects, "The stream must be created")
+	s.Equal(uint64(3), info.State.Msgs, "The samples must be published in batches")
+
+	// The failed message is not acknowledged and is delivered again after the ack wait time
+	failing := s.consume(endpoint+"&batch=1", 3)
+	<-failing.done
+	failing.wg.Wait()
+	s.Error(failing.stopped)
+
+	first := s.consume(endpoint+"&batch=1", 0)
+	second := s.consume(endpoint+"&batch=1", 0)
+	s.publish(endpoint+"&batch=10&format=csv", valueRange(25, 50)...)
+	for start := time.Now(); len(first.out.received())+len(second.out.received()) < 50 && time.Since(start) < 5*time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	first.stop()
+	second.stop()
+	s.NoError(first.stopped)
+	s.NoError(second.stopped)
+	// Every message is delivered to only one of the consumers
+	s.ElementsMatch(valueRange(0, 50), append(first.out.received(), second.out.received()...))
+	s.Equal(uint64(0), s.numUnacked(srv), "All messages must be acknowledged")
+}
+
+func (s *jetStreamTestSuite) TestReconnect() {
+	storeDir := s.T().TempDir()
+	srv := s.runServer(-1, storeDir, nil)
+	address := srv.Addr().String()
+	endpoint := "jetstream://" + address + "/SAMPLES"
+	source := s.consume(endpoint, 0)
+	s.publish(endpoint, valueRange(0, 10)...)
+	s.waitFor(source, 10)
+	for start := time.Now(); s.numUnacked(srv) > 0 && time.Since(start) < 5*time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The source keeps receiving while the server is restarted, the stream and consumer are persisted
+	srv.Shutdown()
+	srv.WaitForShutdown()
+	srv = s.runServer(s.port(address), storeDir, nil)
+	defer srv.Shutdown()
+	s.publish(endpoint, valueRange(10, 20)...)
+	for start := time.Now(); len(source.out.received()) < 20 && time.Since(start) < 10*time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	source.stop()
+	s.NoError(source.stopped)
+	s.Equal(valueRange(0, 20), source.out.received())
+}
+
+func (s *jetStreamTestSuite) port(address string) int {
+	_, port, err := net.SplitHostPort(address)
+	s.NoError(err)
+	res, err := strconv.Atoi(port)
+	s.NoError(err)
+	return res
+}
+
+func (s *jetStreamTestSuite) TestTLS() {
+	config, file := s.certificate()
+	srv := s.runServer(-1, s.T().TempDir(), config)
+	defer srv.Shutdown()
+	endpoint := "jetstream://" + srv.Addr().String() + "/SAMPLES?tls-ca=" + file
+
+	s.publish(endpoint, valueRange(0, 10)...)
+	source := s.consume(endpoint, 0)
+	s.waitFor(source, 10)
+	source.stop()
+	s.NoError(source.stopped)
+
+	// Without the CA certificate, the certificate of the server is rejected
+	b := reg.NewProcessorRegistry()
+	RegisterMessageQueues(b)
+	sink, err := b.Endpoints.CreateOutput("jetstream://" + srv.Addr().String() + "/SAMPLES?tls=true")
+	s.NoError(err)
+	var wg sync.WaitGroup
+	s.Error(sink.Start(&wg).Err())
+}
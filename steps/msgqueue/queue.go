@@ -0,0 +1,454 @@
+// Package msgqueue provides data sources and sinks that exchange samples through message brokers: Redis Streams
+// (redis://) and NATS JetStream (jetstream://). Both are used with consumer groups, so that multiple
+// pipelines reading from the same endpoint share the load, and messages are acknowledged only after all their
+// samples were forwarded. Unacknowledged messages are delivered again, e.g. to another consumer after a crash.
+//
+// Every message contains a batch of samples, marshalled like a file: the header followed by the samples.
+// The connections are handled by the go-redis and nats.go client libraries, which reconnect after connection
+// failures.
+package msgqueue
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RedisEndpoint     = bitflow.EndpointType("redis")
+	JetStreamEndpoint = bitflow.EndpointType("jetstream")
+
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+	DefaultGroup         = "bitflow"
+
+	// receiveTimeout limits how long a blocking receive waits for new messages
+	receiveTimeout = 5 * time.Second
+
+	// retryInterval is the delay before receiving again, after the broker was unavailable
+	retryInterval = time.Second
+)
+
+// ErrUnavailable is wrapped by the errors of Queue.Receive and Queue.Ack, when the connection to the broker failed.
+// The client libraries reconnect automatically, so the Source keeps receiving messages after such errors. Messages
+// that could not be acknowledged are delivered again.
+var ErrUnavailable = errors.New("Message broker unavailable")
+
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+// Message is one message received from a Queue.
+type Message struct {
+	// Id identifies the message when acknowledging it. For JetStream, this is the acknowledgement subject.
+	Id   string
+	Data []byte
+}
+
+// Queue is implemented by the supported message brokers.
+type Queue interface {
+	String() string
+
+	// Open connects to the broker. If consume is true, the consumer group is created, if necessary.
+	Open(consume bool) error
+
+	// Publish sends one message and waits until it is stored by the broker.
+	Publish(data []byte) error
+
+	// Receive waits for up to the given timeout for messages of the consumer group. It returns at most max
+	// messages, or nil after the timeout.
+	Receive(max int, timeout time.Duration) ([]Message, error)
+
+	// Ack acknowledges that the given message was processed and must not be delivered again.
+	Ack(msg Message) error
+
+	// Close closes the connection, which also interrupts a blocking Receive call.
+	Close() error
+}
+
+// RegisterMessageQueues registers the redis:// and jetstream:// endpoints as data sources and data sinks.
+// See NewRedisStream and NewJetStream for the format of the targets. Additional query parameters:
+//
+// As data sink: batch (number of samples per message, default DefaultBatchSize), flush (maximum time to delay
+// buffered samples, default DefaultFlushInterval) and format (marshalling format, default binary).
+// As data source: batch (maximum number of messages to receive at once, default DefaultBatchSize).
+// For both: tls (true to connect with TLS), tls-ca (file with the CA certificates that verify the server, default:
+// the system certificates), tls-cert and tls-key (files with the client certificate and key). The tls-* parameters
+// also enable TLS.
+func RegisterMessageQueues(b reg.ProcessorRegistry) {
+	factories := map[bitflow.EndpointType]func(target string, params map[string]string) (Queue, error){
+		RedisEndpoint: func(target string, params map[string]string) (Queue, error) {
+			return NewRedisStream(target, params)
+		},
+		JetStreamEndpoint: func(target string, params map[string]string) (Queue, error) {
+			return NewJetStream(target, params)
+		},
+	}
+	for endpointType, factory := range factories {
+		endpointType, factory := endpointType, factory
+		b.Endpoints.CustomDataSources[endpointType] = func(target string) (bitflow.SampleSource, error) {
+			target, params, err := splitParams(target)
+			if err != nil {
+				return nil, err
+			}
+			source := new(Source)
+			source.BatchSize = reg.IntParam(params, "batch", DefaultBatchSize, true, &err)
+			delete(params, "batch")
+			if err != nil {
+				return nil, err
+			}
+			if source.Queue, err = factory(target, params); err != nil {
+				return nil, err
+			}
+			source.Reader = b.Endpoints.Reader(nil)
+			return source, nil
+		}
+		b.Endpoints.CustomDataSinks[endpointType] = func(target string) (bitflow.SampleProcessor, error) {
+			target, params, err := splitParams(target)
+			if err != nil {
+				return nil, err
+			}
+			sink := new(Sink)
+			sink.BatchSize = reg.IntParam(params, "batch", DefaultBatchSize, true, &err)
+			sink.FlushInterval = reg.DurationParam(params, "flush", DefaultFlushInterval, true, &err)
+			format := reg.StrParam(params, "format", string(bitflow.BinaryFormat), true, &err)
+			for _, name := range []string{"batch", "flush", "format"} {
+				delete(params, name)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if sink.Marshaller, err = b.Endpoints.CreateMarshaller(bitflow.MarshallingFormat(format)); err != nil {
+				return nil, err
+			}
+			if sink.Queue, err = factory(target, params); err != nil {
+				return nil, err
+			}
+			return sink, nil
+		}
+	}
+}
+
+// Sink publishes the received samples to a Queue. The samples are marshalled in batches of BatchSize samples,
+// every batch is sent as one message.
+type Sink struct {
+	bitflow.AbstractMarshallingSampleOutput
+
+	Queue Queue
+
+	// BatchSize is the maximum number of samples per message. Default: DefaultBatchSize
+	BatchSize int
+
+	// FlushInterval is the maximum time that received samples are buffered before they are sent, even if the
+	// batch is not complete. Default: DefaultFlushInterval
+	FlushInterval time.Duration
+
+	lock    sync.Mutex
+	loop    *golib.LoopTask
+	buf     bytes.Buffer
+	checker bitflow.HeaderChecker
+	samples int
+	closed  bool
+}
+
+// String implements the SampleSink interface.
+func (sink *Sink) String() string {
+	return fmt.Sprintf("Publish to %v (batch %v, format %v)", sink.Queue, sink.batchSize(), sink.Marshaller)
+}
+
+func (sink *Sink) batchSize() int {
+	if sink.BatchSize > 0 {
+		return sink.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+// Start implements the SampleSink interface. It connects to the broker and starts a goroutine that sends
+// incomplete batches in the FlushInterval.
+func (sink *Sink) Start(wg *sync.WaitGroup) golib.StopChan {
+	if err := sink.Queue.Open(false); err != nil {
+		return golib.NewStoppedChan(err)
+	}
+	interval := sink.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	sink.loop = &golib.LoopTask{
+		Description: sink.String(),
+		Loop: func(stop golib.StopChan) error {
+			stop.WaitTimeout(interval)
+			sink.lock.Lock()
+			defer sink.lock.Unlock()
+			return sink.flush()
+		},
+	}
+	return sink.loop.Start(wg)
+}
+
+// Close implements the SampleSink interface. It sends the remaining buffered samples and closes the connection.
+func (sink *Sink) Close() {
+	if sink.loop != nil {
+		sink.loop.Stop()
+	}
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.closed {
+		return
+	}
+	sink.closed = true
+	if err := sink.flush(); err != nil {
+		log.Errorln("Error publishing samples:", err)
+	}
+	if err := sink.Queue.Close(); err != nil {
+		log.Errorln("Error closing connection to", sink.Queue, err)
+	}
+	sink.CloseSink()
+}
+
+// Sample implements the SampleSink interface.
+func (sink *Sink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	err := sink.addSample(sample, header)
+	return sink.AbstractMarshallingSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *Sink) addSample(sample *bitflow.Sample, header *bitflow.Header) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.closed {
+		return errors.New(sink.String() + " is closed")
+	}
+	// Every message starts with a header, so that it can be decoded independently of other messages
+	if sink.checker.HeaderChanged(header) {
+		if err := sink.Marshaller.WriteHeader(header, true, &sink.buf); err != nil {
+			return err
+		}
+	}
+	if err := sink.Marshaller.WriteSample(sample, header, true, &sink.buf); err != nil {
+		return err
+	}
+	sink.samples++
+	if sink.samples >= sink.batchSize() {
+		return sink.flush()
+	}
+	return nil
+}
+
+func (sink *Sink) flush() error {
+	if sink.samples == 0 {
+		return nil
+	}
+	err := sink.Queue.Publish(sink.buf.Bytes())
+	sink.buf.Reset()
+	sink.samples = 0
+	sink.checker = bitflow.HeaderChecker{}
+	return err
+}
+
+// Source receives messages from a Queue and forwards the contained samples. Every message is acknowledged after
+// all its samples were forwarded. If an error occurs, the Source stops and the current message remains
+// unacknowledged, so that it is delivered again.
+type Source struct {
+	bitflow.AbstractUnmarshallingSampleSource
+
+	Queue Queue
+
+	// BatchSize is the maximum number of messages received at once. Default: DefaultBatchSize
+	BatchSize int
+
+	closed  golib.StopChan
+	decoder messageDecoder
+}
+
+// String implements the SampleSource interface.
+func (source *Source) String() string {
+	return fmt.Sprintf("Consume from %v", source.Queue)
+}
+
+// Start implements the SampleSource interface. It connects to the broker and starts receiving messages in a
+// background goroutine.
+func (source *Source) Start(wg *sync.WaitGroup) golib.StopChan {
+	if err := source.Queue.Open(true); err != nil {
+		source.CloseSinkParallel(wg)
+		return golib.NewStoppedChan(err)
+	}
+	source.closed = golib.NewStopChan()
+	source.decoder = messageDecoder{reader: source.Reader, sink: source.GetSink(), name: source.Queue.String()}
+	log.WithField("format", source.Reader.Format()).Println("Receiving samples from", source.Queue)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer source.CloseSinkParallel(wg)
+		err := source.receive()
+		if source.closed.Stopped() {
+			err = nil
+		}
+		source.closed.StopErr(err)
+	}()
+	return source.closed
+}
+
+// Close implements the SampleSource interface. It closes the connection to the broker. The message that is
+// currently processed is not acknowledged.
+func (source *Source) Close() {
+	source.closed.StopFunc(func() {
+		if err := source.Queue.Close(); err != nil {
+			log.Errorln("Error closing connection to", source.Queue, err)
+		}
+	})
+}
+
+func (source *Source) receive() error {
+	batch := source.BatchSize
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+	for !source.closed.Stopped() {
+		messages, err := source.Queue.Receive(batch, receiveTimeout)
+		if errors.Is(err, ErrUnavailable) {
+			log.Warnln("Error receiving from", source.Queue, err)
+			source.closed.WaitTimeout(retryInterval)
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			if err := source.decoder.decode(msg.Data); err != nil {
+				return fmt.Errorf("Error processing message %v: %v", msg.Id, err)
+			}
+			if err := source.Queue.Ack(msg); errors.Is(err, ErrUnavailable) {
+				log.Warnf("Failed to acknowledge message %v, it will be delivered again: %v", msg.Id, err)
+			} else if err != nil {
+				return fmt.Errorf("Error acknowledging message %v: %v", msg.Id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// messageDecoder unmarshalls the samples in one message and forwards them. The Header instance is reused
+// as long as the received headers do not change.
+type messageDecoder struct {
+	reader bitflow.SampleReader
+	sink   bitflow.SampleSink
+	name   string
+	header *bitflow.Header
+}
+
+func (d *messageDecoder) decode(data []byte) error {
+	input := bufio.NewReader(bytes.NewReader(data))
+	um := d.reader.Unmarshaller
+	if um == nil {
+		start, err := input.Peek(len("time"))
+		if err != nil {
+			return fmt.Errorf("Failed to detect the message format: %v", err)
+		}
+		if um, err = bitflow.DetectFormatFrom(string(start)); err != nil {
+			return err
+		}
+	}
+	var header *bitflow.UnmarshalledHeader
+	for {
+		newHeader, sampleData, err := um.Read(input, header)
+		if newHeader != nil {
+			header = newHeader
+			if !d.header.Equals(&header.Header) {
+				d.header = &bitflow.Header{Fields: append([]string(nil), header.Fields...)}
+			}
+		}
+		if sampleData != nil {
+			sample, parseErr := um.ParseSample(header, bitflow.RequiredValues(len(header.Fields), d.sink), sampleData)
+			if parseErr != nil {
+				return parseErr
+			}
+			if handler := d.reader.Handler; handler != nil {
+				handler.HandleSample(sample, d.name)
+			}
+			if sinkErr := d.sink.Sample(sample, d.header); sinkErr != nil {
+				return sinkErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// splitParams separates the query parameters from an endpoint target
+func splitParams(target string) (string, map[string]string, error) {
+	params := make(map[string]string)
+	if index := strings.IndexRune(target, '?'); index >= 0 {
+		values, err := url.ParseQuery(target[index+1:])
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid query parameters in '%v': %v", target, err)
+		}
+		target = target[:index]
+		for name := range values {
+			params[name] = values.Get(name)
+		}
+	}
+	return target, params, nil
+}
+
+var tlsParamNames = []string{"tls", "tls-ca", "tls-cert", "tls-key"}
+
+// tlsParams parses the TLS parameters described in RegisterMessageQueues. The result is nil, if TLS is not enabled.
+func tlsParams(params map[string]string, host string, err *error) *tls.Config {
+	enabled := reg.BoolParam(params, "tls", false, true, err)
+	caFile := reg.StrParam(params, "tls-ca", "", true, err)
+	certFile := reg.StrParam(params, "tls-cert", "", true, err)
+	keyFile := reg.StrParam(params, "tls-key", "", true, err)
+	if *err != nil || !(enabled || caFile != "" || certFile != "" || keyFile != "") {
+		return nil
+	}
+	config := &tls.Config{ServerName: host}
+	if caFile != "" {
+		ca, readErr := ioutil.ReadFile(caFile)
+		if readErr != nil {
+			*err = reg.ParameterError("tls-ca", readErr)
+			return nil
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			*err = reg.ParameterError("tls-ca", fmt.Errorf("No valid certificates in %v", caFile))
+			return nil
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, loadErr := tls.LoadX509KeyPair(certFile, keyFile)
+		if loadErr != nil {
+			*err = reg.ParameterError("tls-cert", loadErr)
+			return nil
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config
+}
+
+func checkUnknownParams(params map[string]string, known ...string) error {
+	for name := range params {
+		isKnown := false
+		for _, knownName := range known {
+			isKnown = isKnown || name == knownName
+		}
+		if !isKnown {
+			return fmt.Errorf("Unknown parameter '%v'", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package msgqueue
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultRedisPort = 6379
+
+	redisDataField = "data"
+)
+
+// RedisStream stores messages in a Redis stream (https://redis.io/docs/data-types/streams/), which is consumed
+// through a consumer group. Messages are added with XADD and received with XREADGROUP. After a restart,
+// the pending (unacknowledged) messages of the consumer are received first. If ClaimIdle is set, pending messages
+// of other consumers of the group are taken over, after they were not acknowledged for the given time.
+// The connection is handled by the go-redis client, which reconnects after connection errors.
+type RedisStream struct {
+	Address  string
+	Username string
+	Password string
+	DB       int
+	TLS      *tls.Config // Optional
+
+	Stream   string
+	Group    string
+	Consumer string
+
+	// MaxLen can be set to > 0 to trim the stream to approximately the given number of messages when publishing.
+	MaxLen int
+
+	// StartNew makes a newly created consumer group only receive messages that are added afterwards.
+	// By default, the group receives all messages stored in the stream.
+	StartNew bool
+
+	// ClaimIdle enables claiming pending messages of other consumers that were not acknowledged for
+	// the given duration, e.g. because the consumer crashed. Requires Redis 6.2.
+	ClaimIdle time.Duration
+
+	client      *redis.Client
+	pendingDone bool
+	claimCursor string
+	lastClaim   time.Time
+}
+
+// NewRedisStream parses a target like [user:password@]host[:port]/stream. Supported parameters:
+// group (consumer group, default DefaultGroup), consumer (unique name of the consumer, default <hostname>-<pid>),
+// db (database number), maxlen (see MaxLen), start (all or new, see StartNew), claim-idle (see ClaimIdle) and the
+// TLS parameters described in RegisterMessageQueues.
+func NewRedisStream(target string, params map[string]string) (*RedisStream, error) {
+	u, err := url.Parse("redis://" + target)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Redis endpoint '%v': %v", target, err)
+	}
+	stream := &RedisStream{
+		Address: u.Host,
+		Stream:  strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.Port() == "" {
+		stream.Address = net.JoinHostPort(u.Hostname(), strconv.Itoa(DefaultRedisPort))
+	}
+	if u.User != nil {
+		stream.Username = u.User.Username()
+		stream.Password, _ = u.User.Password()
+		if stream.Password == "" {
+			// redis://password@host is a common shortcut when there is no user
+			stream.Username, stream.Password = "", stream.Username
+		}
+	}
+	if u.Hostname() == "" || stream.Stream == "" {
+		return nil, fmt.Errorf("Redis endpoint must have the format [user:password@]host[:port]/stream, not '%v'", target)
+	}
+	stream.Group = reg.StrParam(params, "group", DefaultGroup, true, &err)
+	stream.Consumer = reg.StrParam(params, "consumer", defaultConsumerName(), true, &err)
+	stream.DB = reg.IntParam(params, "db", 0, true, &err)
+	stream.MaxLen = reg.IntParam(params, "maxlen", 0, true, &err)
+	start := reg.StrParam(params, "start", "all", true, &err)
+	stream.ClaimIdle = reg.DurationParam(params, "claim-idle", 0, true, &err)
+	stream.TLS = tlsParams(params, u.Hostname(), &err)
+	if err == nil {
+		err = checkUnknownParams(params, append([]string{"group", "consumer", "db", "maxlen", "start", "claim-idle"}, tlsParamNames...)...)
+	}
+	if err == nil && start != "all" && start != "new" {
+		err = reg.ParameterError("start", errors.New("Must be 'all' or 'new'"))
+	}
+	stream.StartNew = start == "new"
+	return stream, err
+}
+
+func defaultConsumerName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%v-%v", host, os.Getpid())
+}
+
+func (r *RedisStream) String() string {
+	return fmt.Sprintf("redis://%v/%v (group %v)", r.Address, r.Stream, r.Group)
+}
+
+// Open implements the Queue interface.
+func (r *RedisStream) Open(consume bool) error {
+	r.client = redis.NewClient(&redis.Options{
+		Addr:      r.Address,
+		Username:  r.Username,
+		Password:  r.Password,
+		DB:        r.DB,
+		TLSConfig: r.TLS,
+	})
+	ctx := context.Background()
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return r.openFailed(err)
+	}
+	if consume {
+		start := "0"
+		if r.StartNew {
+			start = "$"
+		}
+		err := r.client.XGroupCreateMkStream(ctx, r.Stream, r.Group, start).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return r.openFailed(err)
+		}
+		r.pendingDone = false
+		r.claimCursor = "0-0"
+	}
+	return nil
+}
+
+func (r *RedisStream) openFailed(err error) error {
+	_ = r.client.Close()
+	return fmt.Errorf("Failed to initialize %v: %v", r, err)
+}
+
+// Publish implements the Queue interface.
+func (r *RedisStream) Publish(data []byte) error {
+	args := &redis.XAddArgs{Stream: r.Stream, Values: []interface{}{redisDataField, data}}
+	if r.MaxLen > 0 {
+		args.MaxLen, args.Approx = int64(r.MaxLen), true
+	}
+	return r.client.XAdd(context.Background(), args).Err()
+}
+
+// Receive implements the Queue interface.
+func (r *RedisStream) Receive(max int, timeout time.Duration) ([]Message, error) {
+	ctx := context.Background()
+	if r.ClaimIdle > 0 && time.Since(r.lastClaim) >= r.ClaimIdle {
+		claimed, cursor, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   r.Stream,
+			Group:    r.Group,
+			Consumer: r.Consumer,
+			MinIdle:  r.ClaimIdle,
+			Start:    r.claimCursor,
+			Count:    int64(max),
+		}).Result()
+		if err != nil {
+			return nil, redisConnError(err)
+		}
+		r.claimCursor = cursor
+		if r.claimCursor == "0-0" {
+			// The complete pending list was checked, wait for ClaimIdle before checking again
+			r.lastClaim = time.Now()
+		}
+		if messages, err := r.messages(claimed); err != nil || len(messages) > 0 {
+			if len(messages) > 0 {
+				log.Printf("Claimed %v pending message(s) of other consumers in %v", len(messages), r)
+			}
+			return messages, err
+		}
+	}
+	if !r.pendingDone {
+		// Receive the messages that were delivered to this consumer before, but not acknowledged
+		messages, err := r.readGroup(ctx, max, -1, "0")
+		if err != nil || len(messages) > 0 {
+			return messages, err
+		}
+		r.pendingDone = true
+	}
+	if r.ClaimIdle > 0 && r.ClaimIdle < timeout {
+		timeout = r.ClaimIdle
+	}
+	return r.readGroup(ctx, max, timeout, ">")
+}
+
+// readGroup reads the messages of the consumer with XREADGROUP, starting at the given ID. A negative timeout
+// disables blocking.
+func (r *RedisStream) readGroup(ctx context.Context, max int, timeout time.Duration, start string) ([]Message, error) {
+	streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    r.Group,
+		Consumer: r.Consumer,
+		Streams:  []string{r.Stream, start},
+		Count:    int64(max),
+		Block:    timeout,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, redisConnError(err)
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	return r.messages(streams[0].Messages)
+}
+
+// messages converts the stream entries. Entries without data were deleted from the stream, and are acknowledged
+// immediately.
+func (r *RedisStream) messages(entries []redis.XMessage) ([]Message, error) {
+	result := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		msg := Message{Id: entry.ID}
+		if data, ok := entry.Values[redisDataField].(string); ok {
+			msg.Data = []byte(data)
+		}
+		if msg.Data == nil {
+			log.Warnf("Dropping message %v without data from %v", msg.Id, r)
+			if err := r.Ack(msg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+// Ack implements the Queue interface.
+func (r *RedisStream) Ack(msg Message) error {
+	return redisConnError(r.client.XAck(context.Background(), r.Stream, r.Group, msg.Id).Err())
+}
+
+// Close implements the Queue interface.
+func (r *RedisStream) Close() error {
+	if r.client == nil {
+		return nil
+	}
+	return r.client.Close()
+}
+
+// redisConnError marks connection errors as ErrUnavailable, the client reconnects with the next command
+func redisConnError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, redis.ErrPoolTimeout) {
+		return unavailable(err)
+	}
+	return err
+}
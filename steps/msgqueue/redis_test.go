@@ -0,0 +1,318 @@
+package msgqueue
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+// valueCollector stores the first value of all received samples and can fail after a number of samples
+type valueCollector struct {
+	bitflow.DroppingSampleProcessor
+	lock      sync.Mutex
+	values    []int
+	failAfter int
+}
+
+func (c *valueCollector) Sample(sample *bitflow.Sample, _ *bitflow.Header) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.failAfter > 0 && len(c.values) >= c.failAfter {
+		return fmt.Errorf("Test error")
+	}
+	c.values = append(c.values, int(sample.Values[0]))
+	return nil
+}
+
+func (c *valueCollector) received() []int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int(nil), c.values...)
+}
+
+// messageQueueTestSuite provides helpers to publish and consume samples through message queue endpoints.
+type messageQueueTestSuite struct {
+	testsupport.Suite
+}
+
+// publish sends one sample per value to the output endpoint.
+func (s *messageQueueTestSuite) publish(endpoint string, values ...int) {
+	b := reg.NewProcessorRegistry()
+	RegisterMessageQueues(b)
+	sink, err := b.Endpoints.CreateOutput(endpoint)
+	s.NoError(err)
+	samples := make([]*bitflow.Sample, len(values))
+	for i, val := range values {
+		samples[i] = testsupport.NewSample(0, "", bitflow.Value(val))
+	}
+	s.Process(sink, &bitflow.Header{Fields: []string{"a"}}, samples...)
+}
+
+type runningSource struct {
+	source  bitflow.SampleSource
+	out     *valueCollector
+	wg      sync.WaitGroup
+	stopped error
+	done    chan struct{}
+}
+
+// consume starts the input endpoint. The sink of the source fails after the given number of samples, if it is greater than zero.
+func (s *messageQueueTestSuite) consume(endpoint string, failAfter int) *runningSource {
+	b := reg.NewProcessorRegistry()
+	RegisterMessageQueues(b)
+	source, err := b.Endpoints.CreateInput(endpoint)
+	s.NoError(err)
+	r := &runningSource{source: source, out: &valueCollector{failAfter: failAfter}, done: make(chan struct{})}
+	source.SetSink(r.out)
+	stopped := source.Start(&r.wg)
+	go func() {
+		stopped.Wait()
+		r.stopped = stopped.Err()
+		close(r.done)
+	}()
+	return r
+}
+
+func (s *messageQueueTestSuite) waitFor(r *runningSource, num int) {
+	for start := time.Now(); len(r.out.received()) < num && time.Since(start) < 5*time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.Len(r.out.received(), num)
+}
+
+func (r *runningSource) stop() {
+	r.source.Close()
+	r.wg.Wait()
+	<-r.done
+}
+
+func valueRange(from, to int) []int {
+	var res []int
+	for i := from; i < to; i++ {
+		res = append(res, i)
+	}
+	return res
+}
+
+// certificate creates a self-signed certificate for 127.0.0.1 and returns the TLS configuration of the server
+// and the PEM file containing the certificate.
+func (s *messageQueueTestSuite) certificate() (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test broker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	s.NoError(err)
+	file := filepath.Join(s.T().TempDir(), "ca.pem")
+	s.NoError(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, file
+}
+
+// connectionProxy forwards TCP connections to a target address and can interrupt all open connections.
+type connectionProxy struct {
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+}
+
+func (s *messageQueueTestSuite) proxy(target string) *connectionProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.NoError(err)
+	p := &connectionProxy{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			targetConn, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			p.lock.Lock()
+			p.conns = append(p.conns, conn, targetConn)
+			p.lock.Unlock()
+			go io.Copy(conn, targetConn) // Drop error
+			go io.Copy(targetConn, conn) // Drop error
+		}
+	}()
+	return p
+}
+
+func (p *connectionProxy) interrupt() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+type redisTestSuite struct {
+	messageQueueTestSuite
+}
+
+// numPending returns the number of unacknowledged messages of the consumer group
+func (s *redisTestSuite) numPending(server *miniredis.Miniredis, password string) int64 {
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), Password: password})
+	defer client.Close()
+	pending, err := client.XPending(context.Background(), "stream", DefaultGroup).Result()
+	s.NoError(err)
+	return pending.Count
+}
+
+func TestRedis(t *testing.T) {
+	suite.Run(t, new(redisTestSuite))
+}
+
+func (s *redisTestSuite) TestParams() {
+	stream, err := NewRedisStream("secret@localhost/samples", map[string]string{"group": "g", "start": "new", "claim-idle": "1m"})
+	s.NoError(err)
+	s.Equal("localhost:6379", stream.Address)
+	s.Equal("secret", stream.Password)
+	s.Equal("samples", stream.Stream)
+	s.Equal("g", stream.Group)
+	s.True(stream.StartNew)
+	s.Equal(time.Minute, stream.ClaimIdle)
+	s.Nil(stream.TLS)
+
+	_, file := s.certificate()
+	stream, err = NewRedisStream("localhost/samples", map[string]string{"tls-ca": file})
+	s.NoError(err)
+	s.Equal("localhost", stream.TLS.ServerName)
+	s.NotNil(stream.TLS.RootCAs)
+
+	_, err = NewRedisStream("localhost/s", map[string]string{"tls-ca": "/missing"})
+	s.Error(err)
+	_, err = NewRedisStream("localhost", nil)
+	s.Error(err)
+	_, err = NewRedisStream("localhost/s", map[string]string{"unknown": "x"})
+	s.Error(err)
+	_, err = NewRedisStream("localhost/s", map[string]string{"start": "middle"})
+	s.Error(err)
+}
+
+func (s *redisTestSuite) TestLoadSharing() {
+	server := miniredis.RunT(s.T())
+	server.RequireAuth("secret")
+	endpoint := "redis://secret@" + server.Addr() + "/stream"
+
+	s.publish(endpoint+"?batch=10", valueRange(0, 25)...)
+	entries, err := server.Stream("stream")
+	s.NoError(err)
+	s.Len(entries, 3, "The samples must be published in batches")
+
+	first := s.consume(endpoint+"?consumer=first&batch=1", 0)
+	second := s.consume(endpoint+"?consumer=second&batch=1", 0)
+	s.publish(endpoint+"?batch=10&format=csv", valueRange(25, 50)...)
+	for start := time.Now(); len(first.out.received())+len(second.out.received()) < 50 && time.Since(start) < 5*time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	first.stop()
+	second.stop()
+	s.NoError(first.stopped)
+	s.NoError(second.stopped)
+	// Every message is delivered to only one of the consumers
+	s.ElementsMatch(valueRange(0, 50), append(first.out.received(), second.out.received()...))
+	s.Equal(int64(0), s.numPending(server, "secret"), "All messages must be acknowledged")
+}
+
+func (s *redisTestSuite) TestRedelivery() {
+	server := miniredis.RunT(s.T())
+	endpoint := "redis://" + server.Addr() + "/stream"
+	s.publish(endpoint+"?batch=5", valueRange(0, 20)...)
+
+	// The failing message remains pending and is delivered again to the same consumer
+	failing := s.consume(endpoint+"?consumer=c", 7)
+	<-failing.done
+	failing.wg.Wait()
+	s.Error(failing.stopped)
+	s.Equal(valueRange(0, 7), failing.out.received())
+	s.Equal(int64(3), s.numPending(server, ""))
+
+	restarted := s.consume(endpoint+"?consumer=c", 0)
+	s.waitFor(restarted, 15)
+	restarted.stop()
+	s.Equal(valueRange(5, 20), restarted.out.received())
+
+	// Pending messages of a failed consumer are claimed by other consumers
+	s.publish(endpoint+"?batch=5", valueRange(20, 30)...)
+	failing = s.consume(endpoint+"?consumer=crashed", 1)
+	<-failing.done
+	failing.wg.Wait()
+	time.Sleep(20 * time.Millisecond)
+	claiming := s.consume(endpoint+"?consumer=other&claim-idle=10ms", 0)
+	s.waitFor(claiming, 10)
+	claiming.stop()
+	s.ElementsMatch(valueRange(20, 30), claiming.out.received())
+	s.Equal(int64(0), s.numPending(server, ""))
+}
+
+func (s *redisTestSuite) TestReconnect() {
+	server := miniredis.RunT(s.T())
+	proxy := s.proxy(server.Addr())
+	defer proxy.listener.Close()
+	endpoint := "redis://" + proxy.listener.Addr().String() + "/stream"
+	source := s.consume(endpoint, 0)
+	s.publish(endpoint, valueRange(0, 10)...)
+	s.waitFor(source, 10)
+
+	// The source keeps receiving after the connection is interrupted
+	proxy.interrupt()
+	s.publish(endpoint, valueRange(10, 20)...)
+	s.waitFor(source, 20)
+	source.stop()
+	s.NoError(source.stopped)
+	s.Equal(valueRange(0, 20), source.out.received())
+}
+
+func (s *redisTestSuite) TestTLS() {
+	config, file := s.certificate()
+	server, err := miniredis.RunTLS(config)
+	s.NoError(err)
+	defer server.Close()
+	endpoint := "redis://" + server.Addr() + "/stream?tls-ca=" + file
+
+	s.publish(endpoint, valueRange(0, 10)...)
+	source := s.consume(endpoint, 0)
+	s.waitFor(source, 10)
+	source.stop()
+	s.NoError(source.stopped)
+
+	// Without the CA certificate, the certificate of the server is rejected
+	b := reg.NewProcessorRegistry()
+	RegisterMessageQueues(b)
+	sink, err := b.Endpoints.CreateOutput("redis://" + server.Addr() + "/stream?tls=true")
+	s.NoError(err)
+	var wg sync.WaitGroup
+	s.Error(sink.Start(&wg).Err())
+}
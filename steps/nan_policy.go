@@ -0,0 +1,222 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+// Policies for NaN and infinite values handled by the InvalidValueHandler. Alternatively, a numeric replacement value
+// can be configured.
+const (
+	InvalidKeep = "keep" // Forward the value unchanged
+	InvalidDrop = "drop" // Drop the entire sample
+	InvalidLast = "last" // Replace the value with the last valid value of the metric
+	InvalidTag  = "tag"  // Forward the value unchanged, but add the metric name to a tag of the sample
+
+	DefaultInvalidTag = "invalid"
+)
+
+// InvalidValueRule applies a policy to the NaN and infinite values of all metrics whose name matches the Pattern.
+type InvalidValueRule struct {
+	Pattern *regexp.Regexp
+	Policy  string // One of InvalidKeep, InvalidDrop, InvalidLast, InvalidTag, or empty to replace invalid values with Value
+	Value   float64
+}
+
+// ParseInvalidValueRule parses one of the policies InvalidKeep, InvalidDrop, InvalidLast, InvalidTag, or a numeric replacement value.
+func ParseInvalidValueRule(pattern string, policy string) (InvalidValueRule, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return InvalidValueRule{}, err
+	}
+	rule := InvalidValueRule{Pattern: regex, Policy: policy}
+	switch policy {
+	case InvalidKeep, InvalidDrop, InvalidLast, InvalidTag:
+	default:
+		rule.Policy = ""
+		if rule.Value, err = strconv.ParseFloat(policy, 64); err != nil {
+			return rule, fmt.Errorf("Policy must be %v, %v, %v, %v or a number, not '%v'", InvalidKeep, InvalidDrop, InvalidLast, InvalidTag, policy)
+		}
+	}
+	return rule, nil
+}
+
+func (r InvalidValueRule) String() string {
+	if r.Policy == "" {
+		return fmt.Sprintf("%v -> %v", r.Pattern, r.Value)
+	}
+	return fmt.Sprintf("%v -> %v", r.Pattern, r.Policy)
+}
+
+func RegisterInvalidValueHandler(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("handle_nan",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			handler := &InvalidValueHandler{
+				Tag:       reg.StrParam(params, "tag", DefaultInvalidTag, true, &err),
+				IgnoreInf: !reg.BoolParam(params, "inf", true, true, &err),
+			}
+			if keys := params["key"]; keys != "" {
+				handler.KeyTags = strings.Split(keys, ",")
+			}
+			if err != nil {
+				return
+			}
+			delete(params, "tag")
+			delete(params, "inf")
+			delete(params, "key")
+			if len(params) == 0 {
+				return errors.New("Need at least one regex=policy parameter, e.g. handle_nan('.*'=last)")
+			}
+			patterns := make([]string, 0, len(params))
+			for pattern := range params {
+				patterns = append(patterns, pattern)
+			}
+			sort.Strings(patterns)
+			for _, pattern := range patterns {
+				rule, err := ParseInvalidValueRule(pattern, params[pattern])
+				if err != nil {
+					return reg.ParameterError(pattern, err)
+				}
+				handler.Rules = append(handler.Rules, rule)
+			}
+			p.Add(handler)
+			return nil
+		},
+		"Handle NaN and infinite values. Every parameter maps a regex, that is matched against the metric names, to a policy: "+
+			"keep (forward unchanged), drop (drop the sample), last (replace with the last valid value of the metric), "+
+			"tag (add the metric name to the tag given by the reserved parameter tag, default 'invalid'), or a numeric replacement value. "+
+			"Metrics not matching any regex are not modified. With the reserved parameter inf=false, infinite values are not handled. "+
+			"The reserved parameter key (comma-separated tags) keeps separate last values for every combination of tag values.")
+}
+
+// InvalidValueHandler applies the first matching rule in Rules to every NaN or infinite value. A warning with the number
+// of handled values is logged when the processor is closed.
+type InvalidValueHandler struct {
+	bitflow.NoopProcessor
+	Rules     []InvalidValueRule
+	Tag       string   // Tag for the InvalidTag policy. The value is a comma-separated list of the affected metrics.
+	IgnoreInf bool     // If true, only NaN values are handled
+	KeyTags   []string // If set, the last valid values are stored separately for every combination of values of these tags
+
+	checker    bitflow.HeaderChecker
+	fieldRules []*InvalidValueRule // For every field, the applied rule, or nil
+	lastValues map[string][]bitflow.Value
+	dropped    int
+	handled    int
+}
+
+func (h *InvalidValueHandler) String() string {
+	rules := make([]string, len(h.Rules))
+	for i, rule := range h.Rules {
+		rules[i] = rule.String()
+	}
+	res := "Handle NaN"
+	if !h.IgnoreInf {
+		res += "/Inf"
+	}
+	res += " values (" + strings.Join(rules, ", ") + ")"
+	if len(h.KeyTags) > 0 {
+		res += fmt.Sprintf(" per %v", h.KeyTags)
+	}
+	return res
+}
+
+func (h *InvalidValueHandler) isInvalid(val bitflow.Value) bool {
+	f := float64(val)
+	return math.IsNaN(f) || (!h.IgnoreInf && math.IsInf(f, 0))
+}
+
+func (h *InvalidValueHandler) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if h.checker.HeaderChanged(header) {
+		h.updateHeader(header)
+	}
+	var last []bitflow.Value
+	var tagged []string
+	for i, rule := range h.fieldRules {
+		if rule == nil || !h.isInvalid(sample.Values[i]) {
+			continue
+		}
+		h.handled++
+		switch rule.Policy {
+		case InvalidKeep:
+		case InvalidDrop:
+			h.dropped++
+			return nil
+		case InvalidLast:
+			if last == nil {
+				last = h.lastSample(sample, len(header.Fields))
+			}
+			sample.Values[i] = last[i] // NaN, if there was no valid value yet
+		case InvalidTag:
+			tagged = append(tagged, header.Fields[i])
+		default:
+			sample.Values[i] = bitflow.Value(rule.Value)
+		}
+	}
+	if len(tagged) > 0 {
+		sample.SetTag(h.Tag, strings.Join(tagged, ","))
+	}
+	h.storeLastValues(sample, len(header.Fields))
+	return h.NoopProcessor.Sample(sample, header)
+}
+
+func (h *InvalidValueHandler) Close() {
+	if h.handled > 0 {
+		log.Warnf("%v: Handled %v invalid value(s), dropped %v sample(s)", h, h.handled, h.dropped)
+	}
+	h.NoopProcessor.Close()
+}
+
+func (h *InvalidValueHandler) lastSample(sample *bitflow.Sample, numFields int) []bitflow.Value {
+	key := ""
+	if len(h.KeyTags) > 0 {
+		values := make([]string, len(h.KeyTags))
+		for i, tag := range h.KeyTags {
+			values[i] = sample.Tag(tag)
+		}
+		key = strings.Join(values, ",")
+	}
+	last, ok := h.lastValues[key]
+	if !ok {
+		last = make([]bitflow.Value, numFields)
+		for i := range last {
+			last[i] = bitflow.Value(math.NaN())
+		}
+		h.lastValues[key] = last
+	}
+	return last
+}
+
+func (h *InvalidValueHandler) storeLastValues(sample *bitflow.Sample, numFields int) {
+	var last []bitflow.Value
+	for i, rule := range h.fieldRules {
+		if rule != nil && rule.Policy == InvalidLast && !h.isInvalid(sample.Values[i]) {
+			if last == nil {
+				last = h.lastSample(sample, numFields)
+			}
+			last[i] = sample.Values[i]
+		}
+	}
+}
+
+func (h *InvalidValueHandler) updateHeader(header *bitflow.Header) {
+	h.lastValues = make(map[string][]bitflow.Value)
+	h.fieldRules = make([]*InvalidValueRule, len(header.Fields))
+	for i, field := range header.Fields {
+		for j, rule := range h.Rules {
+			if rule.Pattern.MatchString(field) {
+				h.fieldRules[i] = &h.Rules[j]
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package steps
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type invalidValueTestSuite struct {
+	testsupport.Suite
+}
+
+func TestInvalidValueHandler(t *testing.T) {
+	suite.Run(t, new(invalidValueTestSuite))
+}
+
+func (s *invalidValueTestSuite) rule(pattern, policy string) InvalidValueRule {
+	r, err := ParseInvalidValueRule(pattern, policy)
+	s.NoError(err)
+	return r
+}
+
+func (s *invalidValueTestSuite) TestPolicies() {
+	nan := bitflow.Value(math.NaN())
+	inf := bitflow.Value(math.Inf(1))
+	handler := &InvalidValueHandler{
+		Rules: []InvalidValueRule{s.rule("^a$", InvalidLast), s.rule("^b$", "-1"), s.rule("^c$", InvalidTag), s.rule("^d$", InvalidDrop)},
+		Tag:   DefaultInvalidTag,
+	}
+	out := s.Process(handler, &bitflow.Header{Fields: []string{"a", "b", "c", "d", "e"}},
+		testsupport.NewSample(0, "", nan, 1, 1, 1, nan),
+		testsupport.NewSample(0, "", 1, inf, 1, 1, 1),
+		testsupport.NewSample(0, "", nan, 2, nan, 1, 1),
+		testsupport.NewSample(0, "", 2, 2, 2, nan, 1),
+		testsupport.NewSample(0, "", inf, 2, 2, 1, 1))
+
+	values := out.Values()
+	s.Len(values, 4, "the sample with an invalid value of d must be dropped")
+	s.True(math.IsNaN(values[0][0]), "there is no last value for the first sample")
+	s.True(math.IsNaN(values[0][4]), "e must not be modified")
+	s.Equal(-1.0, values[1][1])
+	s.Equal(1.0, values[2][0])
+	s.Equal([]string{"", "", "c", ""}, out.Tags(DefaultInvalidTag))
+	s.Equal(1.0, values[3][0], "the value of the dropped sample must not be used")
+	s.Equal(6, handler.handled)
+
+	_, err := ParseInvalidValueRule(".*", "invalid")
+	s.Error(err)
+}
+
+func (s *invalidValueTestSuite) TestIgnoreInf() {
+	handler := &InvalidValueHandler{Rules: []InvalidValueRule{s.rule(".*", "0")}, IgnoreInf: true}
+	out := s.Process(handler, &bitflow.Header{Fields: []string{"a", "b"}},
+		testsupport.NewSample(0, "", bitflow.Value(math.Inf(-1)), bitflow.Value(math.NaN())))
+	values := out.Values()
+	s.True(math.IsInf(values[0][0], -1))
+	s.Equal(0.0, values[0][1])
+}
@@ -20,3 +20,13 @@ type NoopProcessor struct {
 func (*NoopProcessor) String() string {
 	return "noop"
 }
+
+// IsReadOnly implements the bitflow.ReadOnlyProcessor interface.
+func (*NoopProcessor) IsReadOnly() bool {
+	return true
+}
+
+// RetainsSamples implements the bitflow.RetainingProcessor interface.
+func (*NoopProcessor) RetainsSamples() bool {
+	return false
+}
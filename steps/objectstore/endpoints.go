@@ -0,0 +1,274 @@
+package objectstore
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const globCharacters = "*?[\\"
+
+// RegisterObjectStorage registers the s3:// and gs:// endpoints as data sources and data sinks. The target contains
+// the bucket and the object key, optionally followed by the query parameters described in ParseTarget, e.g.:
+//
+//	s3://my-bucket/recordings/2020-*/*.bin?region=eu-central-1
+//	gs://my-bucket/results/output.csv.gz?kms-key=projects/p/locations/l/keyRings/r/cryptoKeys/k
+//
+// As data source, the key can be a prefix or a glob pattern (see path.Match) that selects the objects to read.
+// As data sink, the output format is derived from the key (.bin for binary, CSV otherwise), unless the format
+// parameter is given. Keys ending with .gz are compressed/decompressed with gzip.
+func RegisterObjectStorage(b reg.ProcessorRegistry) {
+	for _, provider := range []string{S3Endpoint, GcsEndpoint} {
+		provider := provider
+		b.Endpoints.CustomDataSources[bitflow.EndpointType(provider)] = func(target string) (bitflow.SampleSource, error) {
+			store, key, format, err := ParseTarget(provider, target)
+			if err != nil {
+				return nil, err
+			}
+			if format != "" {
+				return nil, reg.ParameterError("format", errors.New("Only supported for data sinks, the input format is detected automatically"))
+			}
+			source := &Source{Store: store, Key: key}
+			source.Reader = b.Endpoints.Reader(nil)
+			return source, nil
+		}
+		b.Endpoints.CustomDataSinks[bitflow.EndpointType(provider)] = func(target string) (bitflow.SampleProcessor, error) {
+			store, key, format, err := ParseTarget(provider, target)
+			if err != nil {
+				return nil, err
+			}
+			if key == "" || strings.HasSuffix(key, "/") {
+				return nil, fmt.Errorf("Missing object key in output %v://%v", provider, target)
+			}
+			outputFormat := bitflow.MarshallingFormat(format)
+			if format == "" {
+				outputFormat = bitflow.EndpointDescription{Target: strings.TrimSuffix(key, ".gz"), Type: bitflow.FileEndpoint}.DefaultOutputFormat()
+			}
+			marshaller, err := b.Endpoints.CreateMarshaller(outputFormat)
+			if err != nil {
+				return nil, err
+			}
+			sink := &Sink{Store: store, Key: key}
+			sink.Marshaller = marshaller
+			sink.Writer = b.Endpoints.Writer()
+			return sink, nil
+		}
+	}
+}
+
+func objectName(store *Store, key string) string {
+	return store.String() + "/" + key
+}
+
+// Source reads samples from all objects selected by Key. The objects are read sequentially in lexical order.
+type Source struct {
+	bitflow.AbstractUnmarshallingSampleSource
+
+	Store *Store
+
+	// Key is the key of a single object, a key prefix, or a glob pattern. When the key does not contain any glob
+	// characters and an object with the exact key exists, only that object is read. Otherwise, all objects with
+	// the given prefix are read.
+	Key string
+
+	// IoBuffer configures the buffer size for read objects.
+	IoBuffer int
+
+	stream *bitflow.SampleInputStream
+	closed golib.StopChan
+}
+
+// String implements the SampleSource interface.
+func (source *Source) String() string {
+	return fmt.Sprintf("ObjectSource(%v)", objectName(source.Store, source.Key))
+}
+
+// Start implements the SampleSource interface. The selected objects are listed and read in a background goroutine.
+func (source *Source) Start(wg *sync.WaitGroup) golib.StopChan {
+	source.closed = golib.NewStopChan()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer source.CloseSinkParallel(wg)
+		source.closed.StopErr(source.readObjects())
+	}()
+	return source.closed
+}
+
+// Close implements the SampleSource interface. It stops reading the current object.
+func (source *Source) Close() {
+	source.closed.StopFunc(func() {
+		if source.stream != nil {
+			if err := source.stream.Close(); err != nil {
+				log.Errorln("Error closing input object:", err)
+			}
+		}
+	})
+}
+
+func (source *Source) readObjects() error {
+	keys, err := source.listObjects()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("No objects found for %v", objectName(source.Store, source.Key))
+	}
+	log.WithField("format", source.Reader.Format()).Printf("Reading %v object(s) from %v", len(keys), source.Store)
+	for _, key := range keys {
+		if err := source.readObject(key); err != nil {
+			if source.closed.Stopped() {
+				return nil
+			}
+			return fmt.Errorf("Error reading %v: %v", objectName(source.Store, key), err)
+		}
+	}
+	return nil
+}
+
+func (source *Source) listObjects() ([]string, error) {
+	prefix, pattern := source.Key, ""
+	if index := strings.IndexAny(source.Key, globCharacters); index >= 0 {
+		prefix, pattern = source.Key[:index], source.Key
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid object key pattern '%v': %v", pattern, err)
+		}
+	}
+	objects, err := source.Store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		if pattern == "" && object.Key == source.Key {
+			return []string{object.Key}, nil
+		}
+		if pattern != "" {
+			if match, _ := path.Match(pattern, object.Key); !match {
+				continue
+			}
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+func (source *Source) readObject(key string) error {
+	body, err := source.Store.Get(key)
+	if err != nil {
+		return err
+	}
+	var input io.ReadCloser = body
+	if strings.HasSuffix(key, ".gz") {
+		decompressed, err := gzip.NewReader(body)
+		if err != nil {
+			_ = body.Close()
+			return err
+		}
+		input = &readCloser{Reader: decompressed, Closer: body}
+	}
+	var stream *bitflow.SampleInputStream
+	source.closed.IfNotStopped(func() {
+		stream = source.Reader.OpenBuffered(input, source.GetSink(), source.IoBuffer)
+		source.stream = stream
+	})
+	if stream == nil {
+		return input.Close()
+	}
+	defer stream.Close() // Drop error
+	return stream.ReadNamedSamples(objectName(source.Store, key))
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Sink uploads all received samples into one object.
+type Sink struct {
+	bitflow.AbstractMarshallingSampleOutput
+
+	Store *Store
+	Key   string
+
+	// IoBuffer configures the buffer size of the marshalled samples.
+	IoBuffer int
+
+	stream *bitflow.SampleOutputStream
+	closed golib.StopChan
+}
+
+// String implements the SampleSink interface.
+func (sink *Sink) String() string {
+	return fmt.Sprintf("ObjectSink(%v)", objectName(sink.Store, sink.Key))
+}
+
+// Start implements the SampleSink interface. It does not start any goroutines, the upload is started
+// with the first sample.
+func (sink *Sink) Start(_ *sync.WaitGroup) (_ golib.StopChan) {
+	log.WithFields(log.Fields{"object": objectName(sink.Store, sink.Key), "format": sink.Marshaller}).Println("Writing samples")
+	sink.closed = golib.NewStopChan()
+	return
+}
+
+// Close implements the SampleSink interface. It uploads the remaining data and completes the upload.
+func (sink *Sink) Close() {
+	sink.closed.StopFunc(func() {
+		if sink.stream != nil {
+			if err := sink.stream.Close(); err != nil {
+				log.Errorln("Error uploading output object:", err)
+			} else {
+				log.WithField("object", objectName(sink.Store, sink.Key)).Println("Uploaded object")
+			}
+		}
+		sink.CloseSink()
+	})
+}
+
+// RetainsSamples implements the RetainingProcessor interface. The SampleOutputStream retains the samples
+// only until they are marshalled.
+func (sink *Sink) RetainsSamples() bool {
+	return false
+}
+
+// Sample implements the SampleSink interface.
+func (sink *Sink) Sample(sample *bitflow.Sample, header *bitflow.Header) (err error) {
+	sink.closed.IfElseStopped(func() {
+		err = errors.New(sink.String() + " is closed")
+	}, func() {
+		if sink.stream == nil {
+			var output io.WriteCloser = sink.Store.NewUpload(sink.Key)
+			if strings.HasSuffix(sink.Key, ".gz") {
+				output = &gzipWriteCloser{Writer: gzip.NewWriter(output), output: output}
+			}
+			sink.stream = sink.Writer.OpenBuffered(output, sink.Marshaller, sink.IoBuffer)
+		}
+		err = sink.stream.Sample(sample, header)
+	})
+	return sink.AbstractMarshallingSampleOutput.Sample(err, sample, header)
+}
+
+type gzipWriteCloser struct {
+	*gzip.Writer
+	output io.WriteCloser
+}
+
+func (w *gzipWriteCloser) Close() error {
+	err := w.Writer.Close()
+	if closeErr := w.output.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type gcsClient struct {
+	store  *Store
+	bucket *storage.BucketHandle
+}
+
+func newGcsClient(ctx context.Context, store *Store) (*gcsClient, error) {
+	options := []option.ClientOption{storage.WithJSONReads()}
+	if store.Endpoint != "" {
+		options = append(options, option.WithEndpoint(store.endpointUrl()))
+	}
+	if store.Anonymous {
+		options = append(options, option.WithoutAuthentication())
+	}
+	client, err := storage.NewClient(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsClient{store: store, bucket: client.Bucket(store.Bucket)}, nil
+}
+
+func (c *gcsClient) list(ctx context.Context, prefix string) ([]Object, error) {
+	var result []Object
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated"}); err != nil {
+		return nil, err
+	}
+	objects := c.bucket.Objects(ctx, query)
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, Object{Key: attrs.Name, Size: attrs.Size, LastModified: attrs.Updated})
+	}
+}
+
+func (c *gcsClient) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return c.bucket.Object(key).NewReader(ctx)
+}
+
+func (c *gcsClient) upload(ctx context.Context, key string) io.WriteCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	writer := c.bucket.Object(key).NewWriter(ctx)
+	writer.ChunkSize = c.store.partSize()
+	writer.KMSKeyName = c.store.KmsKey
+	return &gcsUpload{Writer: writer, cancel: cancel}
+}
+
+// gcsUpload cancels the context of the storage.Writer after closing it. If the upload failed, this aborts
+// the resumable upload session.
+type gcsUpload struct {
+	*storage.Writer
+	cancel context.CancelFunc
+}
+
+func (u *gcsUpload) Close() error {
+	defer u.cancel()
+	return u.Writer.Close()
+}
@@ -0,0 +1,390 @@
+package objectstore
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// fakeStorage implements the subset of the S3 API used by Store, for the bucket "bucket" using path-style URLs
+type fakeStorage struct {
+	lock     sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	requests []*http.Request
+	failPart int
+}
+
+func newFakeStorage() (*fakeStorage, *httptest.Server) {
+	storage := &fakeStorage{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+	return storage, httptest.NewServer(storage)
+}
+
+func (f *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests = append(f.requests, r)
+	if r.URL.Path != "/bucket" && !strings.HasPrefix(r.URL.Path, "/bucket/") {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/bucket"), "/")
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+	uploadId := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, query.Get("prefix"), query.Get("continuation-token"))
+	case r.Method == http.MethodGet:
+		if data, ok := f.objects[key]; ok {
+			_, _ = w.Write(data)
+		} else {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+		}
+	case r.Method == http.MethodPut && uploadId != "":
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		if part == f.failPart {
+			f.error(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		f.uploads[uploadId][part] = body
+		w.Header().Set("ETag", fmt.Sprintf("\"etag-%v\"", part))
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodPost && uploadId == "":
+		uploadId = fmt.Sprintf("upload-%v", len(f.uploads))
+		f.uploads[uploadId] = make(map[int][]byte)
+		_, _ = fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%v</UploadId></InitiateMultipartUploadResult>", uploadId)
+	case r.Method == http.MethodPost:
+		var complete struct {
+			Part []completedPart
+		}
+		_ = xml.Unmarshal(body, &complete)
+		var data []byte
+		for i, part := range complete.Part {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf("\"etag-%v\"", i+1) {
+				f.error(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, f.uploads[uploadId][part.PartNumber]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, uploadId)
+		_, _ = fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%v</Key></CompleteMultipartUploadResult>", key)
+	case r.Method == http.MethodDelete && uploadId != "":
+		delete(f.uploads, uploadId)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusBadRequest, "InvalidRequest")
+	}
+}
+
+func (f *fakeStorage) list(w http.ResponseWriter, prefix string, token string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > token {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	truncated := len(keys) > 2 // Small pages to test the continuation of listings
+	if truncated {
+		keys = keys[:2]
+	}
+	_, _ = fmt.Fprintf(w, "<ListBucketResult><IsTruncated>%v</IsTruncated>", truncated)
+	if truncated {
+		_, _ = fmt.Fprintf(w, "<NextContinuationToken>%v</NextContinuationToken>", keys[1])
+	}
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(w, "<Contents><Key>%v</Key><Size>%v</Size></Contents>", key, len(f.objects[key]))
+	}
+	_, _ = fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func (f *fakeStorage) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%v</Code><Message>Test error</Message></Error>", code)
+}
+
+func (f *fakeStorage) put(key string, values ...int) {
+	data := "time,a\n"
+	for _, val := range values {
+		data += fmt.Sprintf("2020-01-01 00:00:00,%v\n", val)
+	}
+	f.objects[key] = []byte(data)
+}
+
+// fakeGcs implements the subset of the Cloud Storage JSON API used by Store, for the bucket "bucket"
+type fakeGcs struct {
+	lock     sync.Mutex
+	objects  map[string][]byte
+	requests []*http.Request
+}
+
+func (f *fakeGcs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests = append(f.requests, r)
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/bucket/o":
+		f.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		if data, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")]; ok {
+			_, _ = w.Write(data)
+		} else {
+			http.Error(w, `{"error": {"code": 404, "message": "No such object"}}`, http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o" && r.URL.Query().Get("uploadType") == "multipart":
+		f.upload(w, r)
+	default:
+		http.Error(w, `{"error": {"code": 400, "message": "Invalid request"}}`, http.StatusBadRequest)
+	}
+}
+
+func (f *fakeGcs) list(w http.ResponseWriter, prefix string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	items := make([]map[string]string, len(keys))
+	for i, key := range keys {
+		items[i] = map[string]string{"name": key, "size": strconv.Itoa(len(f.objects[key])), "updated": "2020-01-01T00:00:00Z"}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#objects", "items": items})
+}
+
+func (f *fakeGcs) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+	var metadata struct {
+		Name string
+	}
+	part, err := parts.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&metadata)
+	}
+	if err == nil {
+		part, err = parts.NextPart()
+	}
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(part)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.objects[metadata.Name] = data
+	_ = json.NewEncoder(w).Encode(map[string]string{"bucket": "bucket", "name": metadata.Name, "size": strconv.Itoa(len(data))})
+}
+
+type objectStoreTestSuite struct {
+	testsupport.Suite
+	storage *fakeStorage
+	server  *httptest.Server
+}
+
+func TestObjectStore(t *testing.T) {
+	suite.Run(t, new(objectStoreTestSuite))
+}
+
+func (s *objectStoreTestSuite) SetupTest() {
+	s.storage, s.server = newFakeStorage()
+
+	// Credentials are resolved by the default credential chain of the AWS SDK
+	s.T().Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	s.T().Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s.T().Setenv("AWS_CONFIG_FILE", os.DevNull)
+	s.T().Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+	s.T().Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func (s *objectStoreTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+// read reads the objects matching the key from the fake storage and returns the first value of every sample.
+func (s *objectStoreTestSuite) read(key string) ([]int, error) {
+	return s.readTarget("s3://bucket/" + key + "?endpoint=" + s.server.URL)
+}
+
+func (s *objectStoreTestSuite) readTarget(target string) ([]int, error) {
+	b := reg.NewProcessorRegistry()
+	RegisterObjectStorage(b)
+	source, err := b.Endpoints.CreateInput(target)
+	s.NoError(err)
+	out := testsupport.NewCapturingSink()
+	source.SetSink(out)
+	var wg sync.WaitGroup
+	stopped := source.Start(&wg)
+	wg.Wait()
+	var values []int
+	for _, vals := range out.Values() {
+		values = append(values, int(vals[0]))
+	}
+	return values, stopped.Err()
+}
+
+// write sends one sample per value to the sink and closes it.
+func (s *objectStoreTestSuite) write(sink bitflow.SampleProcessor, values ...int) {
+	samples := make([]*bitflow.Sample, len(values))
+	for i, val := range values {
+		samples[i] = testsupport.NewSample(0, "", bitflow.Value(val))
+	}
+	s.Process(sink, &bitflow.Header{Fields: []string{"a"}}, samples...)
+}
+
+func (s *objectStoreTestSuite) TestParseTarget() {
+	store, key, format, err := ParseTarget(S3Endpoint, "bucket/dir/file.csv?region=eu-west-1&sse=aws:kms&kms-key=k&part-size=8&format=bin&anonymous=true")
+	s.NoError(err)
+	s.Equal("dir/file.csv", key)
+	s.Equal("bin", format)
+	s.Equal("eu-west-1", store.Region)
+	s.Equal("aws:kms", store.Encryption)
+	s.Equal("k", store.KmsKey)
+	s.True(store.Anonymous)
+	s.Equal(8*1024*1024, store.PartSize)
+
+	store, key, _, err = ParseTarget(GcsEndpoint, "bucket?kms-key=projects/p/keys/k&endpoint=localhost:9000")
+	s.NoError(err)
+	s.Equal("", key)
+	s.Equal("projects/p/keys/k", store.KmsKey)
+	s.Equal("https://localhost:9000", store.endpointUrl())
+	s.Equal(DefaultPartSize, store.partSize())
+
+	for _, target := range []string{"/key", "bucket?part-size=1", "bucket?sse=invalid", "bucket?unknown=1", "bucket?path-style=x"} {
+		_, _, _, err = ParseTarget(S3Endpoint, target)
+		s.Error(err, target)
+	}
+	_, _, _, err = ParseTarget(GcsEndpoint, "bucket?sse=AES256")
+	s.Error(err)
+}
+
+func (s *objectStoreTestSuite) TestListObjects() {
+	s.storage.put("data/1.csv", 1)
+	s.storage.put("data/2.csv", 2)
+	s.storage.put("data/2.csv.bak", 100)
+	s.storage.put("data/sub/3.csv", 3)
+	s.storage.put("data/sub/", 100)
+	s.storage.put("other.csv", 4)
+
+	values, err := s.read("data/*.csv")
+	s.NoError(err)
+	s.Equal([]int{1, 2}, values)
+
+	values, err = s.read("data/")
+	s.NoError(err)
+	s.Equal([]int{1, 2, 100, 3}, values)
+
+	values, err = s.read("data/2.csv")
+	s.NoError(err)
+	s.Equal([]int{2}, values, "Only the object with the exact key must be read")
+
+	_, err = s.read("missing/")
+	s.Error(err)
+}
+
+func (s *objectStoreTestSuite) TestWriteAndRead() {
+	b := reg.NewProcessorRegistry()
+	RegisterObjectStorage(b)
+
+	for _, key := range []string{"out.csv", "out.bin", "out.csv.gz"} {
+		sink, err := b.Endpoints.CreateOutput("s3://bucket/" + key + "?sse=AES256&endpoint=" + s.server.URL)
+		s.NoError(err)
+		s.write(sink, 1, 2, 3)
+		request := s.storage.requests[len(s.storage.requests)-1]
+		s.Equal("AES256", request.Header.Get("X-Amz-Server-Side-Encryption"))
+		s.Contains(request.Header.Get("Authorization"), "Credential=AKIDTEST/")
+		values, err := s.read(key)
+		s.NoError(err, key)
+		s.Equal([]int{1, 2, 3}, values, key)
+	}
+	s.True(strings.HasPrefix(string(s.storage.objects["out.csv"]), "time,"))
+	s.True(strings.HasPrefix(string(s.storage.objects["out.bin"]), "timB"))
+
+	// Anonymous requests are not signed
+	_, err := s.readTarget("s3://bucket/out.csv?anonymous=true&endpoint=" + s.server.URL)
+	s.NoError(err)
+	s.Empty(s.storage.requests[len(s.storage.requests)-1].Header.Get("Authorization"))
+}
+
+func (s *objectStoreTestSuite) TestMultipartUpload() {
+	store := &Store{Provider: S3Endpoint, Bucket: "bucket", Endpoint: s.server.URL, PartSize: MinPartSize}
+	data := make([]byte, 2*MinPartSize+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	upload := store.NewUpload("multipart")
+	for i := 0; i < len(data); i += 1000 {
+		_, err := upload.Write(data[i:min(i+1000, len(data))])
+		s.NoError(err)
+	}
+	s.NoError(upload.Close())
+	s.Equal(data, s.storage.objects["multipart"])
+	s.Empty(s.storage.uploads, "The multipart upload must be completed")
+	s.True(len(s.storage.requests) >= 5, "The object must be uploaded in multiple parts")
+
+	// A failed part aborts the upload
+	s.storage.failPart = 2
+	upload = store.NewUpload("failed")
+	_, err := upload.Write(data)
+	if err == nil {
+		err = upload.Close()
+	}
+	s.Error(err)
+	s.Contains(err.Error(), "InternalError")
+	s.Error(upload.Close())
+	s.Empty(s.storage.uploads, "The multipart upload must be aborted")
+	s.NotContains(s.storage.objects, "failed")
+}
+
+func (s *objectStoreTestSuite) TestGcs() {
+	storage := &fakeGcs{objects: make(map[string][]byte)}
+	server := httptest.NewServer(storage)
+	defer server.Close()
+	params := "?anonymous=true&endpoint=" + server.URL + "/storage/v1/"
+
+	b := reg.NewProcessorRegistry()
+	RegisterObjectStorage(b)
+	sink, err := b.Endpoints.CreateOutput("gs://bucket/data/out.csv" + params + "&kms-key=projects/p/keys/k")
+	s.NoError(err)
+	s.write(sink, 1, 2, 3)
+	s.True(strings.HasPrefix(string(storage.objects["data/out.csv"]), "time,"))
+	s.Equal("projects/p/keys/k", storage.requests[len(storage.requests)-1].URL.Query().Get("kmsKeyName"))
+
+	storage.objects["data/other.csv"] = []byte("time,a\n2020-01-01 00:00:00,4\n")
+	values, err := s.readTarget("gs://bucket/data/" + params)
+	s.NoError(err)
+	s.Equal([]int{4, 1, 2, 3}, values)
+
+	_, err = s.readTarget("gs://bucket/missing" + params)
+	s.Error(err)
+}
@@ -0,0 +1,98 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type s3Client struct {
+	store  *Store
+	client *s3.Client
+}
+
+func newS3Client(ctx context.Context, store *Store) (*s3Client, error) {
+	var options []func(*config.LoadOptions) error
+	if store.Region != "" {
+		options = append(options, config.WithRegion(store.Region))
+	}
+	if store.Anonymous {
+		options = append(options, config.WithCredentialsProvider(aws.AnonymousCredentials{}))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = DefaultS3Region
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if store.Endpoint != "" {
+			o.BaseEndpoint = aws.String(store.endpointUrl())
+		}
+		o.UsePathStyle = store.PathStyle || o.BaseEndpoint != nil
+		// S3-compatible services do not necessarily support the additional checksums of the SDK
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+	return &s3Client{store: store, client: client}, nil
+}
+
+func (c *s3Client) list(ctx context.Context, prefix string) ([]Object, error) {
+	var result []Object
+	pages := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.store.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			result = append(result, Object{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return result, nil
+}
+
+func (c *s3Client) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.store.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (c *s3Client) upload(ctx context.Context, key string) io.WriteCloser {
+	uploader := manager.NewUploader(c.client, func(u *manager.Uploader) {
+		u.PartSize = int64(c.store.partSize())
+	})
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(c.store.Bucket),
+		Key:    aws.String(key),
+	}
+	if c.store.Encryption != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(c.store.Encryption)
+		if c.store.KmsKey != "" {
+			input.SSEKMSKeyId = aws.String(c.store.KmsKey)
+		}
+	}
+	return newPipeUpload(func(body io.Reader) error {
+		input.Body = body
+		_, err := uploader.Upload(ctx, input)
+		return err
+	})
+}
@@ -0,0 +1,213 @@
+// Package objectstore provides data sources and sinks for reading and writing samples from/to object storage
+// services: Amazon S3 and S3-compatible services (s3://bucket/key) through the AWS SDK, and Google Cloud Storage
+// (gs://bucket/key) through the Cloud Storage client library.
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	S3Endpoint  = "s3"
+	GcsEndpoint = "gs"
+
+	DefaultS3Region = "us-east-1"
+
+	// MinPartSize is the minimum size of all parts of a multipart upload, except for the last part
+	MinPartSize     = 5 * 1024 * 1024
+	DefaultPartSize = 16 * 1024 * 1024
+)
+
+// Store contains the connection parameters for one bucket. The client of the provider is created on first use.
+//
+// Credentials are resolved by the SDK of the provider. For S3, the default credential chain of the AWS SDK is
+// used: environment variables (AWS_ACCESS_KEY_ID etc.), the shared config and credentials files (including
+// AWS_PROFILE and SSO), web identity tokens, and the ECS/EC2 instance roles. For GCS, the Application Default
+// Credentials are used: GOOGLE_APPLICATION_CREDENTIALS, the gcloud user credentials, or the metadata server.
+type Store struct {
+	Provider string // S3Endpoint or GcsEndpoint
+	Bucket   string
+
+	// Endpoint is the base URL of the storage service, e.g. https://minio.local:9000. If empty, the default
+	// endpoint of the provider is used.
+	Endpoint string
+	Region   string
+
+	// PathStyle addresses S3 buckets in the URL path instead of the host name. This is always the case
+	// for custom endpoints.
+	PathStyle bool
+
+	// Anonymous disables authentication, e.g. for public buckets.
+	Anonymous bool
+
+	// Encryption selects the server-side encryption of uploaded objects: "AES256" or "aws:kms" for S3.
+	// KmsKey optionally defines the key for aws:kms (S3), or the Cloud KMS key name (GCS).
+	Encryption string
+	KmsKey     string
+
+	// PartSize is the size of the parts of multipart uploads (S3) or the chunk size of resumable uploads (GCS).
+	// Objects smaller than PartSize are uploaded with a single request. Default: DefaultPartSize
+	PartSize int
+
+	lock      sync.Mutex
+	client    storageClient
+	clientErr error
+}
+
+// storageClient implements the operations of Store for one provider.
+type storageClient interface {
+	list(ctx context.Context, prefix string) ([]Object, error)
+	get(ctx context.Context, key string) (io.ReadCloser, error)
+	upload(ctx context.Context, key string) io.WriteCloser
+}
+
+// Object describes one object returned by List().
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ParseTarget parses an endpoint target like bucket/key?region=eu-central-1 for the given provider and
+// returns the configured Store, the object key and the optional output format. Supported query parameters:
+// region, endpoint, path-style, anonymous, sse, kms-key, part-size (in MB) and format. The settings of the
+// SDKs are used as defaults, e.g. AWS_REGION and AWS_ENDPOINT_URL for S3, or STORAGE_EMULATOR_HOST for GCS.
+func ParseTarget(provider string, target string) (store *Store, key string, format string, err error) {
+	query := ""
+	if index := strings.IndexRune(target, '?'); index >= 0 {
+		target, query = target[:index], target[index+1:]
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Invalid query parameters in '%v': %v", target, err)
+	}
+	params := make(map[string]string, len(values))
+	for name := range values {
+		switch name {
+		case "region", "endpoint", "path-style", "anonymous", "sse", "kms-key", "part-size", "format":
+			params[name] = values.Get(name)
+		default:
+			return nil, "", "", fmt.Errorf("Unknown parameter '%v' for %v://%v", name, provider, target)
+		}
+	}
+	bucket := target
+	if index := strings.IndexRune(target, '/'); index >= 0 {
+		bucket, key = target[:index], target[index+1:]
+	}
+	if bucket == "" {
+		return nil, "", "", fmt.Errorf("Missing bucket name in '%v'", target)
+	}
+
+	store = &Store{Provider: provider, Bucket: bucket}
+	store.Region = reg.StrParam(params, "region", "", true, &err)
+	store.Endpoint = reg.StrParam(params, "endpoint", "", true, &err)
+	store.PathStyle = reg.BoolParam(params, "path-style", false, true, &err)
+	store.Anonymous = reg.BoolParam(params, "anonymous", false, true, &err)
+	store.Encryption = reg.StrParam(params, "sse", "", true, &err)
+	store.KmsKey = reg.StrParam(params, "kms-key", "", true, &err)
+	partSize := reg.IntParam(params, "part-size", 0, true, &err)
+	format = reg.StrParam(params, "format", "", true, &err)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if partSize != 0 && partSize*1024*1024 < MinPartSize {
+		return nil, "", "", reg.ParameterError("part-size", fmt.Errorf("Must be at least %v (MB)", MinPartSize/1024/1024))
+	}
+	store.PartSize = partSize * 1024 * 1024
+
+	switch provider {
+	case S3Endpoint:
+		if store.Encryption != "" && store.Encryption != "AES256" && store.Encryption != "aws:kms" {
+			return nil, "", "", reg.ParameterError("sse", fmt.Errorf("Must be AES256 or aws:kms, not '%v'", store.Encryption))
+		}
+	case GcsEndpoint:
+		if store.Encryption != "" {
+			return nil, "", "", reg.ParameterError("sse", fmt.Errorf("Not supported for GCS, use kms-key to define a customer-managed encryption key"))
+		}
+	default:
+		return nil, "", "", fmt.Errorf("Unknown object storage provider '%v'", provider)
+	}
+	return store, key, format, nil
+}
+
+func (s *Store) String() string {
+	return s.Provider + "://" + s.Bucket
+}
+
+func (s *Store) partSize() int {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return DefaultPartSize
+}
+
+// endpointUrl returns the Endpoint as URL, defaulting to https if the scheme is missing
+func (s *Store) endpointUrl() string {
+	if s.Endpoint == "" || strings.Contains(s.Endpoint, "://") {
+		return s.Endpoint
+	}
+	return "https://" + s.Endpoint
+}
+
+func (s *Store) getClient(ctx context.Context) (storageClient, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.client == nil && s.clientErr == nil {
+		switch s.Provider {
+		case S3Endpoint:
+			s.client, s.clientErr = newS3Client(ctx, s)
+		case GcsEndpoint:
+			s.client, s.clientErr = newGcsClient(ctx, s)
+		default:
+			s.clientErr = fmt.Errorf("Unknown object storage provider '%v'", s.Provider)
+		}
+		if s.clientErr != nil {
+			s.clientErr = fmt.Errorf("Failed to create client for %v: %v", s, s.clientErr)
+		}
+	}
+	return s.client, s.clientErr
+}
+
+// List returns all objects with the given key prefix, in lexical order.
+func (s *Store) List(prefix string) ([]Object, error) {
+	ctx := context.Background()
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := client.list(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list objects in %v: %v", s, err)
+	}
+	return objects, nil
+}
+
+// Get returns the content of the given object. The result must be closed by the caller.
+func (s *Store) Get(key string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.get(ctx, key)
+}
+
+// NewUpload returns an io.WriteCloser that uploads the written data to the given object key. The object is created
+// when the upload is closed, and Close returns the error of the upload, if any. Failed multipart or resumable
+// uploads are aborted, so that no partial object remains.
+func (s *Store) NewUpload(key string) io.WriteCloser {
+	ctx := context.Background()
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return &failedUpload{err: err}
+	}
+	return client.upload(ctx, key)
+}
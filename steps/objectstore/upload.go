@@ -0,0 +1,54 @@
+package objectstore
+
+import (
+	"io"
+	"sync"
+)
+
+// pipeUpload is an io.WriteCloser that passes the written data to an upload function running in a background
+// goroutine. If the upload fails, the error is returned from all further calls to Write and Close.
+type pipeUpload struct {
+	writer    *io.PipeWriter
+	done      chan error
+	closeOnce sync.Once
+	err       error
+}
+
+func newPipeUpload(upload func(body io.Reader) error) *pipeUpload {
+	reader, writer := io.Pipe()
+	u := &pipeUpload{writer: writer, done: make(chan error, 1)}
+	go func() {
+		err := upload(reader)
+		// Unblock pending writes, if the upload stopped reading
+		_ = reader.CloseWithError(err)
+		u.done <- err
+	}()
+	return u
+}
+
+// Write implements the io.Writer interface.
+func (u *pipeUpload) Write(data []byte) (int, error) {
+	return u.writer.Write(data)
+}
+
+// Close implements the io.Closer interface. It waits for the upload to finish.
+func (u *pipeUpload) Close() error {
+	u.closeOnce.Do(func() {
+		_ = u.writer.Close()
+		u.err = <-u.done
+	})
+	return u.err
+}
+
+// failedUpload returns the error from creating the client of the Store.
+type failedUpload struct {
+	err error
+}
+
+func (u *failedUpload) Write([]byte) (int, error) {
+	return 0, u.err
+}
+
+func (u *failedUpload) Close() error {
+	return u.err
+}
@@ -0,0 +1,101 @@
+package steps
+
+import (
+	"fmt"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// onnxScript is executed by the Python interpreter to apply an ONNX model to CSV samples on stdin.
+// Arguments: model file, comma-separated input metrics, tag name, output prefix
+const onnxScript = `
+import sys
+import numpy as np
+import onnxruntime
+
+model_file, inputs_param, tag, prefix = sys.argv[1:5]
+session = onnxruntime.InferenceSession(model_file)
+model_input = session.get_inputs()[0]
+dtype = np.float64 if model_input.type == "tensor(double)" else np.float32
+output_names = [output.name for output in session.get_outputs()]
+selected = [field for field in inputs_param.split(",") if field]
+
+def flatten(value):
+    if isinstance(value, dict):
+        return [value[key] for key in sorted(value)]
+    if isinstance(value, (list, tuple)) and len(value) > 0 and isinstance(value[0], dict):
+        return flatten(value[0])
+    return np.asarray(value).ravel().tolist()
+
+header, indices, has_tags, header_written = None, None, False, False
+for line in sys.stdin:
+    fields = line.rstrip("\n").split(",")
+    if fields[0] == "time":
+        has_tags = len(fields) > 1 and fields[1] == "tags"
+        offset = 2 if has_tags else 1
+        header, metrics = fields, fields[offset:]
+        names = selected or metrics
+        missing = [name for name in names if name not in metrics]
+        if missing:
+            sys.exit("Input metrics missing in header: %s" % missing)
+        indices = [metrics.index(name) + offset for name in names]
+        header_written = False
+        continue
+
+    results = session.run(None, {model_input.name: np.array([[float(fields[i]) for i in indices]], dtype=dtype)})
+    tag_value, out_names, out_values = None, [], []
+    for name, result in zip(output_names, results):
+        flat = flatten(result)
+        if tag and tag_value is None:
+            tag_value = str(flat[0] if len(flat) > 0 else "").replace(" ", "_").replace("=", "_")
+            continue
+        if len(flat) == 1:
+            out_names.append(prefix + name)
+        else:
+            out_names.extend("%s%s_%d" % (prefix, name, i) for i in range(len(flat)))
+        out_values.extend(flat)
+
+    if tag:
+        tags = fields[1] if has_tags else ""
+        fields = [fields[0], (tags + " " if tags else "") + tag + "=" + tag_value] + fields[2 if has_tags else 1:]
+    if not header_written:
+        out_header = list(header)
+        if tag and not has_tags:
+            out_header.insert(1, "tags")
+        sys.stdout.write(",".join(out_header + out_names) + "\n")
+        header_written = True
+    sys.stdout.write(",".join(fields + [repr(float(value)) for value in out_values]) + "\n")
+    sys.stdout.flush()
+`
+
+func RegisterOnnxModel(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("onnx",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			model := reg.StrParam(params, "model", "", false, &err)
+			inputs := reg.StrParam(params, "inputs", "", true, &err)
+			tag := reg.StrParam(params, "tag", "", true, &err)
+			prefix := reg.StrParam(params, "prefix", "", true, &err)
+			python := reg.StrParam(params, "python", "python3", true, &err)
+			if err != nil {
+				return
+			}
+			runner := &SubprocessRunner{
+				Cmd:         python,
+				Args:        []string{"-c", onnxScript, model, inputs, tag, prefix},
+				Description: fmt.Sprintf("ONNX model %v", model),
+			}
+			if inputs != "" {
+				runner.Description += fmt.Sprintf(" (inputs %v)", inputs)
+			}
+			if err = runner.Configure("csv", &bitflow.DefaultEndpointFactory); err == nil {
+				p.Add(runner)
+			}
+			return
+		},
+		"Apply an ONNX model to every sample and append the model outputs as new metrics (optionally with a name prefix). "+
+			"The inputs parameter (comma-separated) selects the metrics that are passed to the first model input, in that order. By default, all metrics are used. "+
+			"If the tag parameter is given, the first model output (e.g. the predicted class label) is stored in that tag instead of a metric. "+
+			"The model is executed by a Python subprocess, which requires the numpy and onnxruntime packages.",
+		reg.RequiredParams("model"), reg.OptionalParams("inputs", "tag", "prefix", "python"))
+}
@@ -0,0 +1,145 @@
+package steps
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+// The test replaces numpy and onnxruntime with minimal Python modules, so that the onnx script can be executed
+// without the real packages. The fake model computes a weighted sum of its inputs, with the weights read from the
+// model file. It outputs a label (1 if the sum is positive) and the scores [sum, -sum].
+const (
+	fakeNumpyModule = `
+float32 = "float32"
+float64 = "float64"
+
+class ndarray(object):
+    def __init__(self, data):
+        self.data = data
+    def ravel(self):
+        flat = []
+        _flatten(self.data, flat)
+        return ndarray(flat)
+    def tolist(self):
+        return self.data
+
+def _flatten(value, out):
+    if isinstance(value, (list, tuple)):
+        for v in value:
+            _flatten(v, out)
+    else:
+        out.append(value)
+
+def array(data, dtype=None):
+    return ndarray(data)
+
+def asarray(value):
+    return value if isinstance(value, ndarray) else ndarray(value)
+`
+	fakeOnnxruntimeModule = `
+class _Node(object):
+    def __init__(self, name):
+        self.name = name
+        self.type = "tensor(float)"
+
+class InferenceSession(object):
+    def __init__(self, model_file):
+        with open(model_file) as f:
+            self.weights = [float(w) for w in f.read().split()]
+    def get_inputs(self):
+        return [_Node("input")]
+    def get_outputs(self):
+        return [_Node("label"), _Node("scores")]
+    def run(self, names, feed):
+        row = feed["input"].data[0]
+        score = sum(w * x for w, x in zip(self.weights, row))
+        return [[int(score > 0)], [[score, -score]]]
+`
+)
+
+type onnxTestSuite struct {
+	testsupport.Suite
+	dir string
+}
+
+func TestOnnxModel(t *testing.T) {
+	suite.Run(t, new(onnxTestSuite))
+}
+
+func (s *onnxTestSuite) SetupTest() {
+	if _, err := exec.LookPath("python3"); err != nil {
+		s.T().Skip("python3 is not available")
+	}
+	var err error
+	s.dir, err = ioutil.TempDir("", "bitflow-onnx-test")
+	s.NoError(err)
+	s.NoError(ioutil.WriteFile(filepath.Join(s.dir, "numpy.py"), []byte(fakeNumpyModule), 0644))
+	s.NoError(ioutil.WriteFile(filepath.Join(s.dir, "onnxruntime.py"), []byte(fakeOnnxruntimeModule), 0644))
+	s.T().Setenv("PYTHONPATH", s.dir)
+}
+
+func (s *onnxTestSuite) TearDownTest() {
+	if s.dir != "" {
+		s.NoError(os.RemoveAll(s.dir))
+	}
+}
+
+func (s *onnxTestSuite) onnx(weights string, params map[string]string) bitflow.SampleProcessor {
+	model := filepath.Join(s.dir, "model.onnx")
+	s.NoError(ioutil.WriteFile(model, []byte(weights), 0644))
+	params["model"] = model
+	b := reg.NewProcessorRegistry()
+	RegisterOnnxModel(b)
+	analysis, ok := b.GetAnalysis("onnx")
+	s.True(ok)
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, params))
+	s.Len(pipe.Processors, 1)
+	return pipe.Processors[0]
+}
+
+func (s *onnxTestSuite) TestAllInputs() {
+	out := s.Process(s.onnx("1 -1 0.5", map[string]string{}), &bitflow.Header{Fields: []string{"a", "b", "c"}},
+		testsupport.NewSample(0, "host=x", 3, 1, 2),
+		testsupport.NewSample(0, "host=y", 1, 4, 0))
+	out.AssertFields(s.T(), "a", "b", "c", "label", "scores_0", "scores_1")
+	out.AssertValues(s.T(), [][]float64{{3, 1, 2, 1, 3, -3}, {1, 4, 0, 0, -3, 3}})
+	s.Equal([]string{"x", "y"}, out.Tags("host"))
+}
+
+func (s *onnxTestSuite) TestSelectedInputsWithTag() {
+	step := s.onnx("1 -1", map[string]string{"inputs": "b,a", "tag": "class", "prefix": "p_"})
+	s.Contains(step.String(), "(inputs b,a)")
+	out := s.Process(step, &bitflow.Header{Fields: []string{"a", "b", "c"}},
+		testsupport.NewSample(0, "", 1, 3, 0),
+		testsupport.NewSample(0, "host=y", 5, 2, 0))
+	out.AssertFields(s.T(), "a", "b", "c", "p_scores_0", "p_scores_1")
+	out.AssertValues(s.T(), [][]float64{{1, 3, 0, 2, -2}, {5, 2, 0, -3, 3}})
+	s.Equal([]string{"1", "0"}, out.Tags("class"))
+	s.Equal([]string{"", "y"}, out.Tags("host"))
+}
+
+func (s *onnxTestSuite) TestMissingInput() {
+	step := s.onnx("1", map[string]string{"inputs": "x"})
+	out := testsupport.NewCapturingSink()
+	step.SetSink(out)
+	runner := step.(*SubprocessRunner)
+	runner.MaxRestarts = 0
+	var wg sync.WaitGroup
+	stopper := runner.Start(&wg)
+	s.NoError(runner.Sample(testsupport.NewSample(0, "", 1), &bitflow.Header{Fields: []string{"a"}}))
+	stopper.Wait()
+	s.Error(stopper.Err(), "The script must fail when input metrics are missing")
+	runner.Close()
+	wg.Wait()
+	out.AssertCount(s.T(), 0)
+}
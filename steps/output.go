@@ -19,14 +19,17 @@ func RegisterOutputFiles(b reg.ProcessorRegistry) {
 
 		var err error
 		parallelize := reg.IntParam(params, "parallelize", 0, true, &err)
+		idleTimeout := reg.DurationParam(params, "idle-timeout", 0, true, &err)
 		if err != nil {
 			return err
 		}
 		delete(params, "parallelize")
+		delete(params, "idle-timeout")
 
 		distributor, err := _make_multi_file_pipeline_builder(params)
 		if err == nil {
 			distributor.Template = filename
+			distributor.IdleTimeout = idleTimeout
 			if parallelize > 0 {
 				distributor.ExtendSubpipelines = func(fileName string, pipe *bitflow.SamplePipeline) {
 					pipe.Add(&DecouplingProcessor{ChannelBuffer: parallelize})
@@ -37,7 +40,8 @@ func RegisterOutputFiles(b reg.ProcessorRegistry) {
 		return err
 	}
 
-	b.RegisterAnalysisParamsErr("output_files", create, "Output samples to multiple files, filenames are built from the given template, where placeholders like ${xxx} will be replaced with tag values")
+	b.RegisterAnalysisParamsErr("output_files", create, "Output samples to multiple files, filenames are built from the given template, where placeholders like ${xxx} will be replaced with tag values. "+
+		"Files that did not receive samples for the duration idle-timeout are closed. If their name occurs again, a new file with an incremented suffix is opened, or the file is appended to (file output parameter append)")
 }
 
 func _make_multi_file_pipeline_builder(params map[string]string) (*fork.MultiFileDistributor, error) {
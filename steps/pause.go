@@ -0,0 +1,71 @@
+package steps
+
+import (
+	"sync"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+)
+
+// PausingProcessor forwards samples unchanged, but blocks all incoming samples while it is paused.
+// Since the Sample() method blocks, the preceding steps and the data source are also halted.
+// Pause() and Resume() can be called concurrently from any goroutine.
+type PausingProcessor struct {
+	bitflow.NoopProcessor
+
+	cond   *sync.Cond
+	paused bool
+	closed bool
+}
+
+func NewPausingProcessor() *PausingProcessor {
+	return &PausingProcessor{
+		cond: sync.NewCond(new(sync.Mutex)),
+	}
+}
+
+func (p *PausingProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	p.cond.L.Lock()
+	for p.paused && !p.closed {
+		p.cond.Wait()
+	}
+	p.cond.L.Unlock()
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+// Pause makes all subsequent calls to Sample() block until Resume() is called.
+func (p *PausingProcessor) Pause() {
+	p.setPaused(true)
+}
+
+// Resume releases all samples blocked in Sample().
+func (p *PausingProcessor) Resume() {
+	p.setPaused(false)
+}
+
+func (p *PausingProcessor) IsPaused() bool {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	return p.paused
+}
+
+func (p *PausingProcessor) setPaused(paused bool) {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	p.paused = paused
+	p.cond.Broadcast()
+}
+
+func (p *PausingProcessor) Close() {
+	p.cond.L.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.cond.L.Unlock()
+	p.NoopProcessor.Close()
+}
+
+func (p *PausingProcessor) String() string {
+	if p.IsPaused() {
+		return "Pause gate (paused)"
+	}
+	return "Pause gate"
+}
@@ -1,9 +1,10 @@
 package steps
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
@@ -39,13 +40,15 @@ func RegisterPickHead(b reg.ProcessorRegistry) {
 			doClose := reg.BoolParam(params, "close", false, true, &err)
 			num := reg.IntParam(params, "num", 0, false, &err)
 			if err == nil {
-				processed := 0
+				var processed int64 // Accessed atomically, because the state can be stored concurrently
 				proc := &bitflow.SimpleProcessor{
-					Description: "Pick first " + strconv.Itoa(num) + " samples",
+					Description:      "Pick first " + strconv.Itoa(num) + " samples",
+					StoreStateFunc:   storeCounterState(&processed),
+					RestoreStateFunc: restoreCounterState(&processed),
 				}
 				proc.Process = func(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Sample, *bitflow.Header, error) {
-					if num > processed {
-						processed++
+					if int64(num) > atomic.LoadInt64(&processed) {
+						atomic.AddInt64(&processed, 1)
 						return sample, header, nil
 					} else {
 						if doClose {
@@ -58,7 +61,9 @@ func RegisterPickHead(b reg.ProcessorRegistry) {
 			}
 			return
 		},
-		"Forward only a number of the first processed samples. The whole pipeline is closed afterwards, unless close=false is given.", reg.RequiredParams("num"), reg.OptionalParams("close"))
+		"Forward only a number of the first processed samples. The whole pipeline is closed afterwards, unless close=false is given.", reg.RequiredParams("num"), reg.OptionalParams("close"),
+		reg.ParamDetails("num", reg.TypeInt, "", "Number of samples to forward"),
+		reg.ParamDetails("close", reg.TypeBool, "false", "Close the pipeline after the samples are forwarded"))
 }
 
 func RegisterSkipHead(b reg.ProcessorRegistry) {
@@ -66,14 +71,16 @@ func RegisterSkipHead(b reg.ProcessorRegistry) {
 		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
 			num := reg.IntParam(params, "num", 0, false, &err)
 			if err == nil {
-				dropped := 0
+				var dropped int64 // Accessed atomically, because the state can be stored concurrently
 				p.Add(&bitflow.SimpleProcessor{
-					Description: "Drop first " + strconv.Itoa(num) + " samples",
+					Description:      "Drop first " + strconv.Itoa(num) + " samples",
+					StoreStateFunc:   storeCounterState(&dropped),
+					RestoreStateFunc: restoreCounterState(&dropped),
 					Process: func(sample *bitflow.Sample, header *bitflow.Header) (*bitflow.Sample, *bitflow.Header, error) {
-						if dropped >= num {
+						if atomic.LoadInt64(&dropped) >= int64(num) {
 							return sample, header, nil
 						} else {
-							dropped++
+							atomic.AddInt64(&dropped, 1)
 							return nil, nil, nil
 						}
 					},
@@ -84,6 +91,23 @@ func RegisterSkipHead(b reg.ProcessorRegistry) {
 		"Drop a number of samples in the beginning", reg.OptionalParams("num"))
 }
 
+func storeCounterState(counter *int64) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return json.Marshal(atomic.LoadInt64(counter))
+	}
+}
+
+func restoreCounterState(counter *int64) func([]byte) error {
+	return func(state []byte) error {
+		var value int64
+		if err := json.Unmarshal(state, &value); err != nil {
+			return err
+		}
+		atomic.StoreInt64(counter, value)
+		return nil
+	}
+}
+
 func RegisterPickTail(b reg.ProcessorRegistry) {
 	b.RegisterAnalysisParamsErr("tail",
 		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
@@ -99,7 +123,7 @@ func RegisterPickTail(b reg.ProcessorRegistry) {
 				}
 				proc.OnClose = func() {
 					flush := ring.Get()
-					log.Printf("%v: Reached end of stream, now flushing %v samples", proc, len(flush))
+					proc.Log().Printf("Reached end of stream, now flushing %v samples", len(flush))
 					for _, sample := range flush {
 						if err := proc.NoopProcessor.Sample(sample.Sample, sample.Header); err != nil {
 							proc.Error(err)
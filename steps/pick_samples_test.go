@@ -0,0 +1,97 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type pickSamplesTestSuite struct {
+	testsupport.Suite
+}
+
+func TestPickSamples(t *testing.T) {
+	suite.Run(t, new(pickSamplesTestSuite))
+}
+
+var pickTestHeader = &bitflow.Header{Fields: []string{"a"}}
+
+func (s *pickSamplesTestSuite) step(name string, params map[string]string) bitflow.StatefulProcessor {
+	b := reg.NewProcessorRegistry()
+	RegisterPickHead(b)
+	RegisterSkipHead(b)
+	analysis, ok := b.GetAnalysis(name)
+	s.True(ok)
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, params))
+	s.Len(pipe.Processors, 1)
+	proc, ok := pipe.Processors[0].(bitflow.StatefulProcessor)
+	s.True(ok, "%v must store its state", name)
+	return proc
+}
+
+func (s *pickSamplesTestSuite) samples(values ...bitflow.Value) []*bitflow.Sample {
+	samples := make([]*bitflow.Sample, len(values))
+	for i, val := range values {
+		samples[i] = testsupport.NewSample(time.Duration(i)*time.Second, "", val)
+	}
+	return samples
+}
+
+func (s *pickSamplesTestSuite) TestHeadState() {
+	head := s.step("head", map[string]string{"num": "3", "close": "false"})
+	s.Process(head, pickTestHeader, s.samples(1, 2)...).AssertValues(s.T(), [][]float64{{1}, {2}})
+	state, err := head.StoreState()
+	s.NoError(err)
+	s.Equal("2", string(state))
+
+	restored := s.step("head", map[string]string{"num": "3", "close": "false"})
+	s.NoError(restored.RestoreState(state))
+	s.Process(restored, pickTestHeader, s.samples(3, 4, 5)...).AssertValues(s.T(), [][]float64{{3}})
+	s.Error(restored.RestoreState([]byte("invalid")))
+}
+
+func (s *pickSamplesTestSuite) TestSkipState() {
+	skip := s.step("skip", map[string]string{"num": "3"})
+	s.Process(skip, pickTestHeader, s.samples(1, 2)...).AssertCount(s.T(), 0)
+	state, err := skip.StoreState()
+	s.NoError(err)
+
+	restored := s.step("skip", map[string]string{"num": "3"})
+	s.NoError(restored.RestoreState(state))
+	s.Process(restored, pickTestHeader, s.samples(3, 4, 5)...).AssertValues(s.T(), [][]float64{{4}, {5}})
+}
+
+// TestConcurrentStoreState should be executed with the -race flag
+func (s *pickSamplesTestSuite) TestConcurrentStoreState() {
+	for _, name := range []string{"head", "skip"} {
+		proc := s.step(name, map[string]string{"num": "500", "close": "false"})
+		values := make([]bitflow.Value, 1000)
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_, err := proc.StoreState()
+					s.NoError(err)
+				}
+			}
+		}()
+		s.Process(proc, pickTestHeader, s.samples(values...)...).AssertCount(s.T(), 500)
+		close(done)
+		wg.Wait()
+		state, err := proc.StoreState()
+		s.NoError(err)
+		s.Equal("500", string(state))
+	}
+}
@@ -0,0 +1,196 @@
+package steps
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const PipelineMetricsEndpoint = bitflow.EndpointType("pipeline_metrics")
+
+func RegisterPipelineMetrics(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("pipeline_metrics",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			interval := reg.DurationParam(params, "interval", time.Second, true, &err)
+			if err == nil {
+				p.Add(&PipelineMetricsProcessor{
+					Interval: interval,
+				})
+			}
+			return
+		},
+		"Forward all incoming samples and additionally emit samples containing operational metrics of the running pipeline (throughput and queue length per step, goroutines, GC stats) in regular intervals",
+		reg.OptionalParams("interval"))
+
+	b.Endpoints.CustomDataSources[PipelineMetricsEndpoint] = func(intervalStr string) (bitflow.SampleSource, error) {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse interval '%v': %v", intervalStr, err)
+		}
+		return &PipelineMetricsSource{Interval: interval}, nil
+	}
+}
+
+// PipelineMetricsCollector produces samples containing the operational metrics of all pipelines
+// registered in a bitflow.PipelineStatistics instance, as well as runtime metrics of the current process.
+type PipelineMetricsCollector struct {
+	// Statistics defaults to bitflow.DefaultPipelineStatistics
+	Statistics *bitflow.PipelineStatistics
+
+	lastTime    time.Time
+	lastSamples map[*bitflow.ProcessorStatistics]uint64
+	processors  []*bitflow.ProcessorStatistics
+	header      *bitflow.Header
+}
+
+var pipelineRuntimeMetrics = []string{
+	"goroutines", "heap/alloc", "heap/objects", "heap/sys", "gc/num", "gc/pause-total",
+}
+
+func (c *PipelineMetricsCollector) Collect() (*bitflow.Sample, *bitflow.Header) {
+	stats := c.Statistics
+	if stats == nil {
+		stats = bitflow.DefaultPipelineStatistics
+	}
+	processors := stats.Processors()
+
+	now := time.Now()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	values := []float64{
+		float64(runtime.NumGoroutine()),
+		float64(memStats.HeapAlloc),
+		float64(memStats.HeapObjects),
+		float64(memStats.HeapSys),
+		float64(memStats.NumGC),
+		float64(memStats.PauseTotalNs),
+	}
+
+	if c.header == nil || !c.sameProcessors(processors) {
+		fields := append([]string(nil), pipelineRuntimeMetrics...)
+		for i := range processors {
+			prefix := "steps/" + strconv.Itoa(i) + "/"
+			fields = append(fields, prefix+"samples", prefix+"throughput", prefix+"queue")
+		}
+		c.header = &bitflow.Header{Fields: fields}
+		c.processors = processors
+	}
+
+	elapsed := now.Sub(c.lastTime).Seconds()
+	lastSamples := make(map[*bitflow.ProcessorStatistics]uint64, len(processors))
+	for _, proc := range processors {
+		samples := proc.Samples()
+		var throughput float64
+		if last, ok := c.lastSamples[proc]; ok && elapsed > 0 {
+			throughput = float64(samples-last) / elapsed
+		}
+		lastSamples[proc] = samples
+		values = append(values, float64(samples), throughput, float64(proc.QueueLength()))
+	}
+	c.lastSamples = lastSamples
+	c.lastTime = now
+
+	sample := &bitflow.Sample{Time: now}
+	FillSample(sample, values)
+	return sample, c.header
+}
+
+func (c *PipelineMetricsCollector) sameProcessors(processors []*bitflow.ProcessorStatistics) bool {
+	if len(processors) != len(c.processors) {
+		return false
+	}
+	for i, proc := range processors {
+		if c.processors[i] != proc {
+			return false
+		}
+	}
+	return true
+}
+
+// PipelineMetricsSource is a data source emitting samples produced by a PipelineMetricsCollector in regular intervals.
+type PipelineMetricsSource struct {
+	bitflow.AbstractSampleSource
+	PipelineMetricsCollector
+	Interval time.Duration
+
+	task *golib.LoopTask
+}
+
+func (s *PipelineMetricsSource) String() string {
+	return fmt.Sprintf("Pipeline metrics (every %v)", s.Interval)
+}
+
+func (s *PipelineMetricsSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	s.task = &golib.LoopTask{
+		Description: s.String(),
+		StopHook:    func() { s.CloseSinkParallel(wg) },
+		Loop: func(stop golib.StopChan) error {
+			if err := s.GetSink().Sample(s.Collect()); err != nil {
+				return err
+			}
+			stop.WaitTimeout(s.Interval)
+			return nil
+		},
+	}
+	return s.task.Start(wg)
+}
+
+func (s *PipelineMetricsSource) Close() {
+	s.task.Stop()
+}
+
+// PipelineMetricsProcessor forwards all incoming samples and additionally emits samples produced by
+// a PipelineMetricsCollector in regular intervals.
+type PipelineMetricsProcessor struct {
+	bitflow.NoopProcessor
+	PipelineMetricsCollector
+	Interval time.Duration
+
+	lock sync.Mutex
+}
+
+func (p *PipelineMetricsProcessor) String() string {
+	return fmt.Sprintf("Emit pipeline metrics (every %v)", p.Interval)
+}
+
+func (p *PipelineMetricsProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
+	stopper := p.NoopProcessor.Start(wg)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for stopper.WaitTimeout(p.Interval) {
+			if err := p.emit(); err != nil {
+				p.Error(fmt.Errorf("%v: Error emitting pipeline metrics: %v", p, err))
+				return
+			}
+		}
+	}()
+	return stopper
+}
+
+func (p *PipelineMetricsProcessor) emit() (err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.StopChan.IfNotStopped(func() {
+		err = p.NoopProcessor.Sample(p.Collect())
+	})
+	return
+}
+
+func (p *PipelineMetricsProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *PipelineMetricsProcessor) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.NoopProcessor.Close()
+}
@@ -0,0 +1,39 @@
+package steps
+
+import (
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type pipelineMetricsTestSuite struct {
+	testsupport.Suite
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	suite.Run(t, new(pipelineMetricsTestSuite))
+}
+
+func (s *pipelineMetricsTestSuite) TestCollect() {
+	stats := new(bitflow.PipelineStatistics)
+	first := stats.Register(new(bitflow.NoopProcessor))
+	stats.Register(&DecouplingProcessor{})
+	collector := &PipelineMetricsCollector{Statistics: stats}
+
+	sample, header := collector.Collect()
+	s.Len(header.Fields, len(pipelineRuntimeMetrics)+6)
+	s.Equal([]string{"steps/1/samples", "steps/1/throughput", "steps/1/queue"}, header.Fields[len(header.Fields)-3:])
+	s.Equal(bitflow.Value(-1), sample.Values[len(pipelineRuntimeMetrics)+2], "the NoopProcessor has no queue")
+	s.Equal(bitflow.Value(0), sample.Values[len(pipelineRuntimeMetrics)+5])
+
+	_, sameHeader := collector.Collect()
+	s.True(header == sameHeader, "the header must be reused while the processors do not change")
+
+	// Finished processors are removed from the output
+	stats.Unregister(first)
+	sample, header = collector.Collect()
+	s.Len(header.Fields, len(pipelineRuntimeMetrics)+3)
+	s.Len(sample.Values, len(header.Fields))
+}
@@ -0,0 +1,79 @@
+package plot
+
+import (
+	"image/color"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	plotLib "gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+var (
+	EventRegionColor = color.NRGBA{R: 255, A: 40}
+	EventMarkerColor = color.NRGBA{R: 200, A: 255}
+)
+
+// PlotEvent is a region on the X axis that is highlighted in a plot. If Start and End are equal, a vertical line is drawn.
+type PlotEvent struct {
+	Start, End float64
+	Label      string
+}
+
+// EventCollector creates PlotEvents from consecutive samples that carry an event tag. If Value is set, only samples
+// with that value of the tag are events. Otherwise, every non-empty tag value is an event, and a change of the value starts a new event.
+type EventCollector struct {
+	Tag   string
+	Value string // Optional
+
+	events  []PlotEvent
+	current *PlotEvent
+}
+
+// Add extends the current event, or starts a new one. The x parameter is the position of the sample on the X axis.
+func (e *EventCollector) Add(sample *bitflow.Sample, x float64) {
+	value := sample.Tag(e.Tag)
+	isEvent := value != "" && (e.Value == "" || value == e.Value)
+	if !isEvent {
+		e.current = nil
+		return
+	}
+	if e.current != nil && e.current.Label == value {
+		e.current.End = x
+		return
+	}
+	e.events = append(e.events, PlotEvent{Start: x, End: x, Label: value})
+	e.current = &e.events[len(e.events)-1]
+}
+
+func (e *EventCollector) Events() []PlotEvent {
+	return e.events
+}
+
+// eventsPlotter draws PlotEvents across the entire height of the plot. It does not influence the ranges of the plot axes.
+type eventsPlotter struct {
+	events []PlotEvent
+}
+
+func (e *eventsPlotter) Plot(c draw.Canvas, plt *plotLib.Plot) {
+	trX, _ := plt.Transforms(&c)
+	font, err := vg.MakeFont(plotLib.DefaultFont, vg.Points(8))
+	textStyle := draw.TextStyle{Color: EventMarkerColor, Font: font}
+	lineStyle := draw.LineStyle{Color: EventMarkerColor, Width: vg.Points(1)}
+	for _, event := range e.events {
+		if event.End < plt.X.Min || event.Start > plt.X.Max {
+			continue
+		}
+		start, end := trX(event.Start), trX(event.End)
+		if end-start < vg.Points(1) {
+			c.StrokeLine2(lineStyle, start, c.Min.Y, start, c.Max.Y)
+		} else {
+			c.FillPolygon(EventRegionColor, c.ClipPolygonXY([]vg.Point{
+				{X: start, Y: c.Min.Y}, {X: start, Y: c.Max.Y}, {X: end, Y: c.Max.Y}, {X: end, Y: c.Min.Y},
+			}))
+		}
+		if err == nil && event.Label != "" {
+			c.FillText(textStyle, vg.Point{X: start + vg.Points(2), Y: c.Max.Y - textStyle.Height(event.Label)}, event.Label)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package plot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+	"gonum.org/v1/plot/plotter"
+)
+
+type annotationsTestSuite struct {
+	testsupport.Suite
+}
+
+func TestAnnotations(t *testing.T) {
+	suite.Run(t, new(annotationsTestSuite))
+}
+
+func (s *annotationsTestSuite) collect(collector *EventCollector, tags ...string) []PlotEvent {
+	for i, tag := range tags {
+		collector.Add(testsupport.NewSample(0, tag), float64(i))
+	}
+	return collector.Events()
+}
+
+func (s *annotationsTestSuite) TestCollector() {
+	tags := []string{"", "e=crash", "e=crash", "e=restart", "", "e=crash", "e=other"}
+	s.Equal([]PlotEvent{
+		{Start: 1, End: 2, Label: "crash"},
+		{Start: 3, End: 3, Label: "restart"},
+		{Start: 5, End: 5, Label: "crash"},
+		{Start: 6, End: 6, Label: "other"},
+	}, s.collect(&EventCollector{Tag: "e"}, tags...))
+	s.Equal([]PlotEvent{
+		{Start: 1, End: 2, Label: "crash"},
+		{Start: 5, End: 5, Label: "crash"},
+	}, s.collect(&EventCollector{Tag: "e", Value: "crash"}, tags...), "Other values interrupt the event")
+	s.Empty(s.collect(&EventCollector{Tag: "x"}, tags...))
+}
+
+func (s *annotationsTestSuite) TestTimePlot() {
+	dir, err := ioutil.TempDir("", "bitflow-annotations-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	step := &PlotProcessor{
+		Type:       LinePlot,
+		AxisX:      PlotAxisTime,
+		AxisY:      0,
+		OutputFile: filepath.Join(dir, "plot.svg"),
+		Events:     &EventCollector{Tag: "event"},
+	}
+	s.Process(step, &bitflow.Header{Fields: []string{"a"}},
+		testsupport.NewSample(0, "", 1),
+		testsupport.NewSample(time.Second, "event=deploy", 2),
+		testsupport.NewSample(2*time.Second, "event=deploy", 3),
+		testsupport.NewSample(3*time.Second, "", 4),
+		testsupport.NewSample(4*time.Second, "event=alarm", 5))
+	start := float64(testsupport.StartTime.Unix())
+	s.Equal([]PlotEvent{{Start: start + 1, End: start + 2, Label: "deploy"}, {Start: start + 4, End: start + 4, Label: "alarm"}}, step.Events.Events())
+
+	content, err := ioutil.ReadFile(step.OutputFile)
+	s.NoError(err)
+	s.Contains(string(content), ">deploy</text>")
+	s.Contains(string(content), ">alarm</text>")
+}
+
+func (s *annotationsTestSuite) TestEventsOutsideRange() {
+	dir, err := ioutil.TempDir("", "bitflow-annotations-test")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "plot.svg")
+	plot := &Plot{Type: LinePlot, Events: []PlotEvent{{Start: 2, End: 3, Label: "inside"}, {Start: 20, End: 30, Label: "outside"}}}
+	s.NoError(plot.Save(map[string]plotter.XYs{"": {{X: 0, Y: 0}, {X: 10, Y: 1}}}, file))
+	content, err := ioutil.ReadFile(file)
+	s.NoError(err)
+	s.Contains(string(content), ">inside</text>")
+	s.NotContains(string(content), ">outside</text>", "Events must not extend the axis range")
+}
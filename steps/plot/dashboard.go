@@ -0,0 +1,369 @@
+package plot
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultDashboardChart     = "default"
+	DefaultDashboardRetention = 10 * time.Minute
+	DefaultDashboardMaxPoints = 1000
+)
+
+func RegisterDashboard(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("dashboard",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			chart := &DashboardChart{
+				Name:      reg.StrParam(params, "chart", DefaultDashboardChart, true, &err),
+				Retention: reg.DurationParam(params, "retention", DefaultDashboardRetention, true, &err),
+				MaxPoints: reg.IntParam(params, "max_points", DefaultDashboardMaxPoints, true, &err),
+			}
+			endpoint := reg.StrParam(params, "endpoint", "", false, &err)
+			if series := params["series"]; series != "" {
+				chart.SeriesTags = strings.Split(series, ",")
+			}
+			if metrics := params["metrics"]; metrics != "" {
+				chart.Metrics = strings.Split(metrics, ",")
+			}
+			if err == nil && chart.MaxPoints < 1 {
+				err = reg.ParameterError("max_points", fmt.Errorf("Must be at least 1"))
+			}
+			if err == nil {
+				p.Add(&DashboardProcessor{
+					Dashboard: GetDashboard(endpoint),
+					Chart:     chart,
+				})
+			}
+			return
+		},
+		"Show the metrics of the processed samples in a live chart on a web dashboard served on the given endpoint. "+
+			"Multiple dashboard steps with the same endpoint and different chart names add multiple charts to the same dashboard. "+
+			"Every combination of values of the series tags forms a separate data series, which can be selected in the browser. "+
+			"The chart keeps the data of the given retention period (based on the sample timestamps), but at most max_points samples per series. "+
+			"The metrics parameter restricts the chart to a comma-separated list of metrics. "+
+			"The current data is available as JSON under /api/charts and /api/charts/<chart>, optionally filtered by the query parameters metric and series.",
+		reg.RequiredParams("endpoint"),
+		reg.OptionalParams("chart", "series", "metrics", "retention", "max_points"))
+}
+
+var (
+	dashboards     = make(map[string]*Dashboard)
+	dashboardsLock sync.Mutex
+)
+
+// GetDashboard returns the dashboard for the given endpoint, which is shared by all DashboardProcessors with that endpoint.
+func GetDashboard(endpoint string) *Dashboard {
+	dashboardsLock.Lock()
+	defer dashboardsLock.Unlock()
+	dashboard, ok := dashboards[endpoint]
+	if !ok {
+		dashboard = &Dashboard{
+			Endpoint: endpoint,
+			charts:   make(map[string]*DashboardChart),
+		}
+		dashboards[endpoint] = dashboard
+	}
+	return dashboard
+}
+
+// Dashboard serves multiple charts on one HTTP endpoint. The HTTP server is started when the first chart is added.
+type Dashboard struct {
+	Endpoint string
+
+	lock    sync.RWMutex
+	charts  map[string]*DashboardChart
+	started bool
+}
+
+func (d *Dashboard) AddChart(chart *DashboardChart) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.charts[chart.Name]; ok {
+		return fmt.Errorf("Dashboard on %v already contains a chart named '%v'", d.Endpoint, chart.Name)
+	}
+	d.charts[chart.Name] = chart
+	if !d.started {
+		d.started = true
+		go func() {
+			// This routine cannot be interrupted gracefully
+			if err := d.serve(); err != nil {
+				log.Errorf("Dashboard on %v failed: %v", d.Endpoint, err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (d *Dashboard) chartNames() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	names := make([]string, 0, len(d.charts))
+	for name := range d.charts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *Dashboard) chart(name string) *DashboardChart {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.charts[name]
+}
+
+func (d *Dashboard) serve() error {
+	return d.engine().Run(d.Endpoint)
+}
+
+func (d *Dashboard) engine() *gin.Engine {
+	engine := golib.NewGinEngine()
+	engine.GET("/", d.serveIndex)
+	engine.GET("/api/charts", d.serveCharts)
+	engine.GET("/api/charts/:chart", d.serveChartData)
+	return engine
+}
+
+func (d *Dashboard) serveIndex(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(dashboardPage))
+}
+
+func (d *Dashboard) serveCharts(c *gin.Context) {
+	var result []DashboardChartInfo
+	for _, name := range d.chartNames() {
+		if chart := d.chart(name); chart != nil {
+			result = append(result, chart.Info())
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (d *Dashboard) serveChartData(c *gin.Context) {
+	chart := d.chart(c.Param("chart"))
+	if chart == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No such chart: " + c.Param("chart")})
+		return
+	}
+	c.JSON(http.StatusOK, chart.Data(c.QueryArray("metric"), c.QueryArray("series")))
+}
+
+// DashboardPoint is one value of a metric, with the timestamp in milliseconds since the Unix epoch.
+type DashboardPoint [2]float64
+
+type dashboardSeries struct {
+	tags     map[string]string
+	lastTime time.Time
+	values   map[string][]DashboardPoint
+}
+
+// DashboardChart stores the recent values of all metrics, separated into series by the values of the SeriesTags.
+type DashboardChart struct {
+	Name       string
+	SeriesTags []string // Optional
+	Metrics    []string // Optional, if empty all metrics are stored
+	Retention  time.Duration
+	MaxPoints  int
+
+	lock      sync.RWMutex
+	metricSet map[string]bool
+	seen      map[string]bool
+	metrics   []string // All metrics that have been seen, in order of appearance
+	series    map[string]*dashboardSeries
+}
+
+// DashboardChartInfo describes the metrics and series of a chart.
+type DashboardChartInfo struct {
+	Name       string   `json:"name"`
+	SeriesTags []string `json:"series_tags"`
+	Metrics    []string `json:"metrics"`
+	Series     []string `json:"series"`
+	Retention  string   `json:"retention"`
+}
+
+// DashboardSeriesData contains the data of one series of a chart.
+type DashboardSeriesData struct {
+	Name string                      `json:"name"`
+	Tags map[string]string           `json:"tags"`
+	Data map[string][]DashboardPoint `json:"data"`
+}
+
+func (c *DashboardChart) seriesName(sample *bitflow.Sample) string {
+	parts := make([]string, len(c.SeriesTags))
+	for i, tag := range c.SeriesTags {
+		parts[i] = tag + "=" + sample.Tag(tag)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *DashboardChart) Add(sample *bitflow.Sample, header *bitflow.Header) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.series == nil {
+		c.series = make(map[string]*dashboardSeries)
+		c.metricSet = make(map[string]bool)
+		c.seen = make(map[string]bool)
+		for _, metric := range c.Metrics {
+			c.metricSet[metric] = true
+		}
+	}
+	name := c.seriesName(sample)
+	series, ok := c.series[name]
+	if !ok {
+		series = &dashboardSeries{
+			tags:   make(map[string]string, len(c.SeriesTags)),
+			values: make(map[string][]DashboardPoint),
+		}
+		for _, tag := range c.SeriesTags {
+			series.tags[tag] = sample.Tag(tag)
+		}
+		c.series[name] = series
+	}
+	timestamp := float64(sample.Time.UnixNano()) / float64(time.Millisecond)
+	for i, field := range header.Fields {
+		if len(c.Metrics) > 0 && !c.metricSet[field] {
+			continue
+		}
+		if !c.seen[field] {
+			c.seen[field] = true
+			c.metrics = append(c.metrics, field)
+		}
+		series.values[field] = append(series.values[field], DashboardPoint{timestamp, float64(sample.Values[i])})
+	}
+	series.lastTime = sample.Time
+	c.prune(series, sample.Time)
+}
+
+// prune removes points that are older than the retention period, or exceed the maximum number of points.
+// Series that did not receive any samples during the retention period are removed.
+func (c *DashboardChart) prune(series *dashboardSeries, now time.Time) {
+	minTime := float64(now.Add(-c.Retention).UnixNano()) / float64(time.Millisecond)
+	for metric, points := range series.values {
+		drop := 0
+		if len(points) > c.MaxPoints {
+			drop = len(points) - c.MaxPoints
+		}
+		for drop < len(points) && c.Retention > 0 && points[drop][0] < minTime {
+			drop++
+		}
+		if drop > 0 {
+			series.values[metric] = points[drop:]
+		}
+	}
+	if c.Retention > 0 {
+		for name, other := range c.series {
+			if now.Sub(other.lastTime) > c.Retention {
+				delete(c.series, name)
+			}
+		}
+	}
+}
+
+func (c *DashboardChart) seriesNames() []string {
+	names := make([]string, 0, len(c.series))
+	for name := range c.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *DashboardChart) Info() DashboardChartInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	metrics := make([]string, len(c.metrics))
+	copy(metrics, c.metrics)
+	return DashboardChartInfo{
+		Name:       c.Name,
+		SeriesTags: c.SeriesTags,
+		Metrics:    metrics,
+		Series:     c.seriesNames(),
+		Retention:  c.Retention.String(),
+	}
+}
+
+// TagValues returns the sorted values of the given series tag.
+func (c *DashboardChart) TagValues(tag string) []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	set := make(map[string]bool)
+	for _, series := range c.series {
+		if value, ok := series.tags[tag]; ok {
+			set[value] = true
+		}
+	}
+	values := make([]string, 0, len(set))
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Data returns copies of the stored values. If metrics or series are not empty, only the given metrics and series are returned.
+func (c *DashboardChart) Data(metrics []string, series []string) []DashboardSeriesData {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	names := series
+	if len(names) == 0 {
+		names = c.seriesNames()
+	}
+	if len(metrics) == 0 {
+		metrics = c.metrics
+	}
+	result := make([]DashboardSeriesData, 0, len(names))
+	for _, name := range names {
+		s, ok := c.series[name]
+		if !ok {
+			continue
+		}
+		data := DashboardSeriesData{Name: name, Tags: s.tags, Data: make(map[string][]DashboardPoint, len(metrics))}
+		for _, metric := range metrics {
+			if points, ok := s.values[metric]; ok {
+				data.Data[metric] = append([]DashboardPoint(nil), points...)
+			}
+		}
+		result = append(result, data)
+	}
+	return result
+}
+
+// DashboardProcessor feeds the processed samples into one chart of a Dashboard.
+type DashboardProcessor struct {
+	bitflow.NoopProcessor
+	Dashboard *Dashboard
+	Chart     *DashboardChart
+}
+
+func (p *DashboardProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
+	if err := p.Dashboard.AddChart(p.Chart); err != nil {
+		return golib.NewStoppedChan(err)
+	}
+	return p.NoopProcessor.Start(wg)
+}
+
+func (p *DashboardProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	p.Chart.Add(sample, header)
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *DashboardProcessor) String() string {
+	endpoint := p.Dashboard.Endpoint
+	if strings.HasPrefix(endpoint, ":") {
+		endpoint = "0.0.0.0" + endpoint
+	}
+	res := fmt.Sprintf("Dashboard chart '%v' on %v (retention %v, max %v points", p.Chart.Name, endpoint, p.Chart.Retention, p.Chart.MaxPoints)
+	if len(p.Chart.SeriesTags) > 0 {
+		res += ", series tags " + strings.Join(p.Chart.SeriesTags, ",")
+	}
+	return res + ")"
+}
@@ -0,0 +1,128 @@
+package plot
+
+// dashboardPage is a self-contained page that periodically loads the data of all charts from the JSON API
+// and draws one canvas per chart and metric.
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Bitflow Dashboard</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+.chart { border: 1px solid #ccc; margin-bottom: 1.5em; padding: 0.5em; }
+.chart h2 { margin: 0 0 0.3em 0; font-size: 1.2em; }
+.series label { margin-right: 1em; white-space: nowrap; }
+.metric { display: inline-block; margin: 0.5em; }
+.metric canvas { border: 1px solid #eee; }
+.metric .name { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<div id="charts"></div>
+<noscript>JavaScript is disabled.</noscript>
+<script>
+var hidden = {}; // chart -> series -> true
+
+function color(name) {
+	var hash = 0;
+	for (var i = 0; i < name.length; i++) hash = (hash * 31 + name.charCodeAt(i)) | 0;
+	return "hsl(" + (Math.abs(hash) % 360) + ",70%,45%)";
+}
+
+function element(parent, tag, cls) {
+	var e = document.createElement(tag);
+	if (cls) e.className = cls;
+	parent.appendChild(e);
+	return e;
+}
+
+function chartElement(info) {
+	var id = "chart-" + info.name;
+	var div = document.getElementById(id);
+	if (!div) {
+		div = element(document.getElementById("charts"), "div", "chart");
+		div.id = id;
+		element(div, "h2").textContent = info.name + " (retention " + info.retention + ")";
+		element(div, "div", "series");
+		element(div, "div", "metrics");
+		hidden[info.name] = hidden[info.name] || {};
+	}
+	var seriesDiv = div.querySelector(".series");
+	seriesDiv.innerHTML = "";
+	info.series.forEach(function(series) {
+		var label = element(seriesDiv, "label");
+		var box = element(label, "input");
+		box.type = "checkbox";
+		box.checked = !hidden[info.name][series];
+		box.onchange = function() { hidden[info.name][series] = !box.checked; };
+		var text = element(label, "span");
+		text.textContent = series || "(all)";
+		text.style.color = color(series);
+	});
+	return div;
+}
+
+function draw(canvas, series, metric) {
+	var ctx = canvas.getContext("2d");
+	ctx.clearRect(0, 0, canvas.width, canvas.height);
+	var minX = Infinity, maxX = -Infinity, minY = Infinity, maxY = -Infinity;
+	series.forEach(function(s) {
+		(s.data[metric] || []).forEach(function(p) {
+			minX = Math.min(minX, p[0]); maxX = Math.max(maxX, p[0]);
+			minY = Math.min(minY, p[1]); maxY = Math.max(maxY, p[1]);
+		});
+	});
+	if (minX > maxX) return;
+	if (maxX == minX) maxX = minX + 1;
+	if (maxY == minY) { maxY += 0.5; minY -= 0.5; }
+	var pad = 25;
+	var scaleX = function(x) { return pad + (x - minX) / (maxX - minX) * (canvas.width - 2 * pad); };
+	var scaleY = function(y) { return canvas.height - pad - (y - minY) / (maxY - minY) * (canvas.height - 2 * pad); };
+	ctx.fillStyle = "#666";
+	ctx.font = "10px sans-serif";
+	ctx.fillText(maxY.toPrecision(4), 2, pad - 5);
+	ctx.fillText(minY.toPrecision(4), 2, canvas.height - pad + 12);
+	ctx.fillText(new Date(minX).toLocaleTimeString(), pad, canvas.height - 3);
+	var last = new Date(maxX).toLocaleTimeString();
+	ctx.fillText(last, canvas.width - pad - ctx.measureText(last).width, canvas.height - 3);
+	series.forEach(function(s) {
+		var points = s.data[metric] || [];
+		ctx.strokeStyle = color(s.name);
+		ctx.beginPath();
+		points.forEach(function(p, i) {
+			if (i == 0) ctx.moveTo(scaleX(p[0]), scaleY(p[1]));
+			else ctx.lineTo(scaleX(p[0]), scaleY(p[1]));
+		});
+		ctx.stroke();
+	});
+}
+
+function updateChart(info) {
+	var div = chartElement(info);
+	fetch("api/charts/" + encodeURIComponent(info.name)).then(function(r) { return r.json(); }).then(function(data) {
+		var visible = data.filter(function(s) { return !hidden[info.name][s.name]; });
+		var metricsDiv = div.querySelector(".metrics");
+		info.metrics.forEach(function(metric) {
+			var canvas = metricsDiv.querySelector("canvas[data-metric='" + metric + "']");
+			if (!canvas) {
+				var m = element(metricsDiv, "div", "metric");
+				element(m, "div", "name").textContent = metric;
+				canvas = element(m, "canvas");
+				canvas.width = 400;
+				canvas.height = 200;
+				canvas.setAttribute("data-metric", metric);
+			}
+			draw(canvas, visible, metric);
+		});
+	});
+}
+
+function update() {
+	fetch("api/charts").then(function(r) { return r.json(); }).then(function(charts) {
+		(charts || []).forEach(updateChart);
+	}).finally(function() { setTimeout(update, 2000); });
+}
+update();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,117 @@
+package plot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type dashboardTestSuite struct {
+	testsupport.Suite
+}
+
+func TestDashboard(t *testing.T) {
+	suite.Run(t, new(dashboardTestSuite))
+}
+
+func (s *dashboardTestSuite) millis(seconds int) float64 {
+	return float64(testsupport.StartTime.Add(time.Duration(seconds)*time.Second).UnixNano()) / float64(time.Millisecond)
+}
+
+func (s *dashboardTestSuite) add(chart *DashboardChart, seconds int, tags string, values ...bitflow.Value) {
+	chart.Add(testsupport.NewSample(time.Duration(seconds)*time.Second, tags, values...), &bitflow.Header{Fields: []string{"cpu", "mem"}})
+}
+
+func (s *dashboardTestSuite) TestSeries() {
+	chart := &DashboardChart{Name: "c", SeriesTags: []string{"host"}, Metrics: []string{"mem"}, Retention: time.Minute, MaxPoints: 10}
+	s.add(chart, 0, "host=a", 1, 10)
+	s.add(chart, 1, "host=b", 2, 20)
+	s.add(chart, 2, "host=a", 3, 30)
+	s.Equal(DashboardChartInfo{Name: "c", SeriesTags: []string{"host"}, Metrics: []string{"mem"}, Series: []string{"host=a", "host=b"}, Retention: "1m0s"}, chart.Info())
+	s.Equal([]string{"a", "b"}, chart.TagValues("host"))
+	s.Empty(chart.TagValues("other"))
+
+	data := chart.Data(nil, []string{"host=a", "host=x"})
+	s.Equal([]DashboardSeriesData{{
+		Name: "host=a",
+		Tags: map[string]string{"host": "a"},
+		Data: map[string][]DashboardPoint{"mem": {{s.millis(0), 10}, {s.millis(2), 30}}},
+	}}, data)
+	data[0].Data["mem"][0][1] = 99
+	s.Equal(10.0, chart.Data([]string{"mem"}, []string{"host=a"})[0].Data["mem"][0][1], "Data must return copies")
+	s.Empty(chart.Data([]string{"cpu"}, nil)[0].Data)
+}
+
+func (s *dashboardTestSuite) TestPruning() {
+	chart := &DashboardChart{Retention: 10 * time.Second, MaxPoints: 3}
+	for i := 0; i < 5; i++ {
+		s.add(chart, i, "", bitflow.Value(i), 0)
+	}
+	s.Equal([]DashboardPoint{{s.millis(2), 2}, {s.millis(3), 3}, {s.millis(4), 4}}, chart.Data([]string{"cpu"}, nil)[0].Data["cpu"], "At most MaxPoints are kept")
+
+	s.add(chart, 13, "", 13, 0)
+	s.Equal([]DashboardPoint{{s.millis(3), 3}, {s.millis(4), 4}, {s.millis(13), 13}}, chart.Data([]string{"cpu"}, nil)[0].Data["cpu"])
+	s.add(chart, 14, "", 14, 0)
+	s.Equal([]DashboardPoint{{s.millis(4), 4}, {s.millis(13), 13}, {s.millis(14), 14}}, chart.Data([]string{"cpu"}, nil)[0].Data["cpu"], "Points older than the retention period are dropped")
+
+	chart = &DashboardChart{SeriesTags: []string{"host"}, Retention: 10 * time.Second, MaxPoints: 10}
+	s.add(chart, 0, "host=a", 1, 1)
+	s.add(chart, 5, "host=b", 1, 1)
+	s.add(chart, 11, "host=b", 1, 1)
+	s.Equal([]string{"host=b"}, chart.Info().Series, "Inactive series are removed")
+}
+
+func (s *dashboardTestSuite) get(d *Dashboard, path string, result interface{}) int {
+	recorder := httptest.NewRecorder()
+	d.engine().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if result != nil {
+		s.NoError(json.Unmarshal(recorder.Body.Bytes(), result))
+	}
+	return recorder.Code
+}
+
+func (s *dashboardTestSuite) TestHttpApi() {
+	d := &Dashboard{Endpoint: "test", charts: make(map[string]*DashboardChart)}
+	d.charts["load"] = &DashboardChart{Name: "load", SeriesTags: []string{"host"}, Retention: time.Minute, MaxPoints: 10}
+	d.charts["disk"] = &DashboardChart{Name: "disk", Retention: time.Minute, MaxPoints: 10}
+	s.add(d.charts["load"], 0, "host=a", 1, 10)
+	s.add(d.charts["load"], 0, "host=b", 2, 20)
+
+	var infos []DashboardChartInfo
+	s.Equal(http.StatusOK, s.get(d, "/api/charts", &infos))
+	s.Len(infos, 2)
+	s.Equal("disk", infos[0].Name)
+	s.Equal([]string{"host=a", "host=b"}, infos[1].Series)
+
+	var data []DashboardSeriesData
+	s.Equal(http.StatusOK, s.get(d, "/api/charts/load?metric=cpu&series=host%3Db", &data))
+	s.Equal([]DashboardSeriesData{{Name: "host=b", Tags: map[string]string{"host": "b"}, Data: map[string][]DashboardPoint{"cpu": {{s.millis(0), 2}}}}}, data)
+
+	s.Equal(http.StatusNotFound, s.get(d, "/api/charts/missing", nil))
+	s.Equal(http.StatusOK, s.get(d, "/", nil))
+}
+
+func (s *dashboardTestSuite) TestSharedDashboard() {
+	d := GetDashboard("127.0.0.1:0")
+	s.True(d == GetDashboard("127.0.0.1:0"), "Steps with the same endpoint share a dashboard")
+	s.NoError(d.AddChart(&DashboardChart{Name: "x"}))
+	s.Error(d.AddChart(&DashboardChart{Name: "x"}))
+	s.Equal([]string{"x"}, d.chartNames())
+}
+
+func (s *dashboardTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterDashboard(b)
+	analysis, _ := b.GetAnalysis("dashboard")
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"endpoint": ":1", "max_points": "0"}))
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, map[string]string{"endpoint": ":7000", "series": "host,zone", "max_points": "5"}))
+	s.Equal("Dashboard chart 'default' on 0.0.0.0:7000 (retention 10m0s, max 5 points, series tags host,zone)", pipe.Processors[0].String())
+}
@@ -0,0 +1,266 @@
+package plot
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultGrafanaRetention = time.Hour
+	DefaultGrafanaMaxPoints = 10000
+)
+
+func RegisterGrafanaDatasource(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("grafana",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			endpoint := reg.StrParam(params, "endpoint", "", false, &err)
+			chart := &DashboardChart{
+				Name:      "grafana",
+				Retention: reg.DurationParam(params, "retention", DefaultGrafanaRetention, true, &err),
+				MaxPoints: reg.IntParam(params, "max_points", DefaultGrafanaMaxPoints, true, &err),
+			}
+			if series := params["series"]; series != "" {
+				chart.SeriesTags = strings.Split(series, ",")
+			}
+			if err == nil && chart.MaxPoints < 1 {
+				err = reg.ParameterError("max_points", fmt.Errorf("Must be at least 1"))
+			}
+			if err == nil {
+				p.Add(&GrafanaDatasource{
+					Endpoint: endpoint,
+					Data:     chart,
+				})
+			}
+			return
+		},
+		"Buffer the recent samples and serve them on the given endpoint through the API of the Grafana SimpleJSON datasource (/search, /query, /tag-keys and /tag-values). "+
+			"Every metric is a query target. Every combination of values of the series tags forms a separate time series, and the series tags can be used as ad-hoc filters in Grafana. "+
+			"The samples of the given retention period are kept, but at most max_points samples per series.",
+		reg.RequiredParams("endpoint"),
+		reg.OptionalParams("series", "retention", "max_points"))
+}
+
+// GrafanaDatasource serves buffered samples through the API of the Grafana SimpleJSON datasource.
+type GrafanaDatasource struct {
+	bitflow.NoopProcessor
+	Endpoint string
+	Data     *DashboardChart
+}
+
+func (g *GrafanaDatasource) Start(wg *sync.WaitGroup) golib.StopChan {
+	go func() {
+		// This routine cannot be interrupted gracefully
+		if err := g.serve(); err != nil {
+			g.Error(err)
+		}
+	}()
+	return g.NoopProcessor.Start(wg)
+}
+
+func (g *GrafanaDatasource) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	g.Data.Add(sample, header)
+	return g.NoopProcessor.Sample(sample, header)
+}
+
+func (g *GrafanaDatasource) String() string {
+	res := fmt.Sprintf("Grafana datasource on %v (retention %v, max %v points", g.Endpoint, g.Data.Retention, g.Data.MaxPoints)
+	if len(g.Data.SeriesTags) > 0 {
+		res += ", series tags " + strings.Join(g.Data.SeriesTags, ",")
+	}
+	return res + ")"
+}
+
+func (g *GrafanaDatasource) serve() error {
+	return g.engine().Run(g.Endpoint)
+}
+
+func (g *GrafanaDatasource) engine() *gin.Engine {
+	engine := golib.NewGinEngine()
+	engine.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+	engine.POST("/search", g.serveSearch)
+	engine.POST("/query", g.serveQuery)
+	engine.POST("/annotations", func(c *gin.Context) {
+		c.JSON(http.StatusOK, []interface{}{})
+	})
+	engine.POST("/tag-keys", g.serveTagKeys)
+	engine.POST("/tag-values", g.serveTagValues)
+	return engine
+}
+
+func (g *GrafanaDatasource) serveSearch(c *gin.Context) {
+	var request struct {
+		Target string `json:"target"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result := []string{}
+	for _, metric := range g.Data.Info().Metrics {
+		if strings.Contains(metric, request.Target) {
+			result = append(result, metric)
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GrafanaFilter is an ad-hoc filter on a series tag.
+type GrafanaFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+func (f GrafanaFilter) matcher() (func(tags map[string]string) bool, error) {
+	var match func(string) bool
+	switch f.Operator {
+	case "=", "":
+		match = func(val string) bool { return val == f.Value }
+	case "!=":
+		match = func(val string) bool { return val != f.Value }
+	case "=~", "!~":
+		regex, err := regexp.Compile(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		negate := f.Operator == "!~"
+		match = func(val string) bool { return regex.MatchString(val) != negate }
+	default:
+		return nil, fmt.Errorf("Unsupported filter operator: %v", f.Operator)
+	}
+	return func(tags map[string]string) bool {
+		return match(tags[f.Key])
+	}, nil
+}
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	MaxDataPoints int `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+	AdhocFilters []GrafanaFilter `json:"adhocFilters"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // Value and timestamp in milliseconds
+}
+
+func (g *GrafanaDatasource) serveQuery(c *gin.Context) {
+	var query grafanaQuery
+	if err := c.ShouldBindJSON(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var filters []func(map[string]string) bool
+	for _, filter := range query.AdhocFilters {
+		match, err := filter.matcher()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filters = append(filters, match)
+	}
+	metrics := make([]string, len(query.Targets))
+	for i, target := range query.Targets {
+		metrics[i] = target.Target
+	}
+	from, to := math.Inf(-1), math.Inf(1)
+	if !query.Range.From.IsZero() {
+		from = float64(query.Range.From.UnixNano()) / float64(time.Millisecond)
+	}
+	if !query.Range.To.IsZero() {
+		to = float64(query.Range.To.UnixNano()) / float64(time.Millisecond)
+	}
+
+	result := []grafanaSeries{}
+	data := g.Data.Data(metrics, nil)
+	for _, metric := range metrics {
+		for _, series := range data {
+			if !matchesAll(filters, series.Tags) {
+				continue
+			}
+			points, ok := series.Data[metric]
+			if !ok {
+				continue
+			}
+			target := metric
+			if series.Name != "" {
+				target += " " + series.Name
+			}
+			result = append(result, grafanaSeries{
+				Target:     target,
+				Datapoints: grafanaDatapoints(points, from, to, query.MaxDataPoints),
+			})
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func matchesAll(filters []func(map[string]string) bool, tags map[string]string) bool {
+	for _, filter := range filters {
+		if !filter(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// grafanaDatapoints selects the points within the time range. If there are more than maxPoints points, every n-th point is selected.
+func grafanaDatapoints(points []DashboardPoint, from, to float64, maxPoints int) [][2]float64 {
+	var selected []DashboardPoint
+	for _, point := range points {
+		if point[0] >= from && point[0] <= to {
+			selected = append(selected, point)
+		}
+	}
+	step := 1
+	if maxPoints > 0 && len(selected) > maxPoints {
+		step = (len(selected) + maxPoints - 1) / maxPoints
+	}
+	result := make([][2]float64, 0, len(selected)/step+1)
+	for i := 0; i < len(selected); i += step {
+		result = append(result, [2]float64{selected[i][1], selected[i][0]})
+	}
+	return result
+}
+
+func (g *GrafanaDatasource) serveTagKeys(c *gin.Context) {
+	result := make([]gin.H, len(g.Data.SeriesTags))
+	for i, tag := range g.Data.SeriesTags {
+		result[i] = gin.H{"type": "string", "text": tag}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (g *GrafanaDatasource) serveTagValues(c *gin.Context) {
+	var request struct {
+		Key string `json:"key"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	values := g.Data.TagValues(request.Key)
+	result := make([]gin.H, len(values))
+	for i, value := range values {
+		result[i] = gin.H{"text": value}
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,129 @@
+package plot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type grafanaTestSuite struct {
+	testsupport.Suite
+}
+
+func TestGrafana(t *testing.T) {
+	suite.Run(t, new(grafanaTestSuite))
+}
+
+func (s *grafanaTestSuite) millis(seconds int) float64 {
+	return float64(testsupport.StartTime.Add(time.Duration(seconds)*time.Second).UnixNano()) / float64(time.Millisecond)
+}
+
+func (s *grafanaTestSuite) datasource() *GrafanaDatasource {
+	g := &GrafanaDatasource{Data: &DashboardChart{SeriesTags: []string{"host"}, Retention: time.Hour, MaxPoints: 100}}
+	header := &bitflow.Header{Fields: []string{"cpu_user", "cpu_system", "mem"}}
+	for i := 0; i < 4; i++ {
+		g.Data.Add(testsupport.NewSample(time.Duration(i)*time.Second, "host=a", bitflow.Value(i), 0, 0), header)
+		g.Data.Add(testsupport.NewSample(time.Duration(i)*time.Second, "host=b", bitflow.Value(10+i), 0, 0), header)
+	}
+	return g
+}
+
+func (s *grafanaTestSuite) post(g *GrafanaDatasource, path string, body string, result interface{}) int {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	g.engine().ServeHTTP(recorder, request)
+	if result != nil && recorder.Code == http.StatusOK {
+		s.NoError(json.Unmarshal(recorder.Body.Bytes(), result))
+	}
+	return recorder.Code
+}
+
+func (s *grafanaTestSuite) TestSearch() {
+	g := s.datasource()
+	var result []string
+	s.Equal(http.StatusOK, s.post(g, "/search", `{"target": "cpu"}`, &result))
+	s.Equal([]string{"cpu_user", "cpu_system"}, result)
+	s.Equal(http.StatusOK, s.post(g, "/search", `{}`, &result))
+	s.Len(result, 3)
+	s.Equal(http.StatusBadRequest, s.post(g, "/search", `not json`, nil))
+}
+
+func (s *grafanaTestSuite) TestQuery() {
+	g := s.datasource()
+	var result []grafanaSeries
+	s.Equal(http.StatusOK, s.post(g, "/query", `{"targets": [{"target": "cpu_user"}, {"target": "missing"}]}`, &result))
+	s.Equal([]grafanaSeries{
+		{Target: "cpu_user host=a", Datapoints: [][2]float64{{0, s.millis(0)}, {1, s.millis(1)}, {2, s.millis(2)}, {3, s.millis(3)}}},
+		{Target: "cpu_user host=b", Datapoints: [][2]float64{{10, s.millis(0)}, {11, s.millis(1)}, {12, s.millis(2)}, {13, s.millis(3)}}},
+	}, result)
+
+	from := testsupport.StartTime.Add(time.Second).Format(time.RFC3339)
+	to := testsupport.StartTime.Add(2 * time.Second).Format(time.RFC3339)
+	s.Equal(http.StatusOK, s.post(g, "/query", `{"range": {"from": "`+from+`", "to": "`+to+`"},
+		"targets": [{"target": "cpu_user"}], "adhocFilters": [{"key": "host", "operator": "!=", "value": "a"}]}`, &result))
+	s.Equal([]grafanaSeries{{Target: "cpu_user host=b", Datapoints: [][2]float64{{11, s.millis(1)}, {12, s.millis(2)}}}}, result)
+
+	s.Equal(http.StatusOK, s.post(g, "/query", `{"maxDataPoints": 2, "targets": [{"target": "cpu_user"}], "adhocFilters": [{"key": "host", "operator": "=~", "value": "^a$"}]}`, &result))
+	s.Equal([]grafanaSeries{{Target: "cpu_user host=a", Datapoints: [][2]float64{{0, s.millis(0)}, {2, s.millis(2)}}}}, result)
+
+	s.Equal(http.StatusBadRequest, s.post(g, "/query", `{"adhocFilters": [{"key": "host", "operator": "<", "value": "a"}]}`, nil))
+	s.Equal(http.StatusBadRequest, s.post(g, "/query", `{"adhocFilters": [{"key": "host", "operator": "=~", "value": "("}]}`, nil))
+}
+
+func (s *grafanaTestSuite) TestDatapoints() {
+	points := []DashboardPoint{{1, 10}, {2, 20}, {3, 30}, {4, 40}, {5, 50}}
+	s.Equal([][2]float64{{20, 2}, {30, 3}, {40, 4}}, grafanaDatapoints(points, 2, 4, 0))
+	s.Equal([][2]float64{{10, 1}, {30, 3}, {50, 5}}, grafanaDatapoints(points, 0, 10, 3))
+	s.Equal([][2]float64{{10, 1}, {40, 4}}, grafanaDatapoints(points, 0, 10, 2))
+	s.Empty(grafanaDatapoints(points, 6, 10, 0))
+}
+
+func (s *grafanaTestSuite) TestFilters() {
+	for _, test := range []struct {
+		filter   GrafanaFilter
+		expected bool
+	}{
+		{GrafanaFilter{Key: "host", Value: "a"}, true},
+		{GrafanaFilter{Key: "host", Operator: "=", Value: "b"}, false},
+		{GrafanaFilter{Key: "host", Operator: "!=", Value: "b"}, true},
+		{GrafanaFilter{Key: "zone", Operator: "=~", Value: "^eu-"}, true},
+		{GrafanaFilter{Key: "zone", Operator: "!~", Value: "^eu-"}, false},
+		{GrafanaFilter{Key: "missing", Operator: "=", Value: ""}, true},
+	} {
+		match, err := test.filter.matcher()
+		s.NoError(err)
+		s.Equal(test.expected, match(map[string]string{"host": "a", "zone": "eu-west"}), "Filter %v", test.filter)
+	}
+}
+
+func (s *grafanaTestSuite) TestTags() {
+	g := s.datasource()
+	var keys []map[string]string
+	s.Equal(http.StatusOK, s.post(g, "/tag-keys", `{}`, &keys))
+	s.Equal([]map[string]string{{"type": "string", "text": "host"}}, keys)
+	var values []map[string]string
+	s.Equal(http.StatusOK, s.post(g, "/tag-values", `{"key": "host"}`, &values))
+	s.Equal([]map[string]string{{"text": "a"}, {"text": "b"}}, values)
+	var annotations []interface{}
+	s.Equal(http.StatusOK, s.post(g, "/annotations", `{}`, &annotations))
+	s.Empty(annotations)
+}
+
+func (s *grafanaTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterGrafanaDatasource(b)
+	analysis, _ := b.GetAnalysis("grafana")
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"endpoint": ":1", "max_points": "0"}))
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, map[string]string{"endpoint": ":3000", "series": "host", "retention": "5m"}))
+	s.Equal("Grafana datasource on :3000 (retention 5m0s, max 10000 points, series tags host)", pipe.Processors[0].String())
+}
@@ -0,0 +1,292 @@
+package plot
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+	plotLib "gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+const SubplotHeight = 7 * vg.Centimeter
+
+// MetricsPlotProcessor plots multiple metrics over time, either in one plot or in a grid of subplots with a shared time axis.
+type MetricsPlotProcessor struct {
+	bitflow.NoopProcessor
+
+	OutputFile string
+	Metrics    []string // If empty, all metrics are plotted
+	ColorTag   string
+	Type       PlotType // ScatterPlot, LinePlot or LinePointPlot
+	NoLegend   bool
+	Grid       bool            // If true, every metric is plotted in a separate subplot
+	Columns    int             // Number of subplot columns, if Grid is true
+	Events     *EventCollector // Optional
+
+	metricSet map[string]bool
+	data      map[string]map[string]plotter.XYs // Metric -> ColorTag value -> time series
+	order     []string                          // Metrics in the order in which they appeared
+}
+
+func (p *MetricsPlotProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
+	if p.Type != ScatterPlot && p.Type != LinePlot && p.Type != LinePointPlot {
+		return golib.NewStoppedChan(fmt.Errorf("Invalid PlotType for metrics plot: %v", p.Type))
+	}
+	if p.OutputFile == "" {
+		return golib.NewStoppedChan(errors.New("MetricsPlotProcessor.OutputFile must be configured"))
+	}
+	if p.Columns < 1 {
+		p.Columns = 1
+	}
+	p.data = make(map[string]map[string]plotter.XYs)
+	p.metricSet = make(map[string]bool, len(p.Metrics))
+	for _, metric := range p.Metrics {
+		p.metricSet[metric] = true
+	}
+	if file, err := os.Create(p.OutputFile); err != nil {
+		// Check if file can be created to quickly fail
+		return golib.NewStoppedChan(err)
+	} else {
+		_ = file.Close() // Drop error
+	}
+	return p.NoopProcessor.Start(wg)
+}
+
+func (p *MetricsPlotProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	key := ""
+	if p.ColorTag != "" {
+		key = sample.Tag(p.ColorTag)
+		if key == "" {
+			key = "(none)"
+		}
+	}
+	t := float64(sample.Time.Unix())
+	if p.Events != nil {
+		p.Events.Add(sample, t)
+	}
+	for i, field := range header.Fields {
+		if len(p.metricSet) > 0 && !p.metricSet[field] {
+			continue
+		}
+		series, ok := p.data[field]
+		if !ok {
+			series = make(map[string]plotter.XYs)
+			p.data[field] = series
+			p.order = append(p.order, field)
+		}
+		series[key] = append(series[key], plotter.XY{X: t, Y: float64(sample.Values[i])})
+	}
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *MetricsPlotProcessor) Close() {
+	defer p.CloseSink()
+	if len(p.data) == 0 {
+		log.Warnf("%s: No data received for plotting", p)
+		return
+	}
+	metrics := p.order
+	if len(p.Metrics) > 0 {
+		// Use the order of the parameter
+		metrics = nil
+		for _, metric := range p.Metrics {
+			if _, ok := p.data[metric]; ok {
+				metrics = append(metrics, metric)
+			} else {
+				p.Log().Warnf("Metric %v not found", metric)
+			}
+		}
+	}
+	var err error
+	if p.Grid {
+		err = p.saveGrid(metrics)
+	} else {
+		err = p.saveSingle(metrics)
+	}
+	if err != nil {
+		p.Error(err)
+	}
+}
+
+func (p *MetricsPlotProcessor) saveSingle(metrics []string) error {
+	plotData := make(map[string]plotter.XYs)
+	for _, metric := range metrics {
+		for key, data := range p.data[metric] {
+			name := metric
+			if key != "" {
+				name += " " + key
+			}
+			plotData[name] = data
+		}
+	}
+	plot := Plot{LabelX: plotTimeLabel, LabelY: "value", Type: p.Type, NoLegend: p.NoLegend, Events: p.events()}
+	return plot.Save(plotData, p.OutputFile)
+}
+
+// saveGrid creates one subplot per metric. All subplots contain the same set of series (one per color tag value),
+// so that every color tag value has the same color in all subplots.
+func (p *MetricsPlotProcessor) saveGrid(metrics []string) error {
+	keySet := make(map[string]bool)
+	xMin, xMax := math.Inf(1), math.Inf(-1)
+	for _, metric := range metrics {
+		for key, data := range p.data[metric] {
+			keySet[key] = true
+			for _, point := range data {
+				xMin = math.Min(xMin, point.X)
+				xMax = math.Max(xMax, point.X)
+			}
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	colorGen, err := NewColorGenerator(len(keys))
+	if err != nil {
+		return err
+	}
+	colors := make(map[string]color.Color, len(keys))
+	for _, key := range keys {
+		colors[key] = colorGen.Next()
+	}
+
+	rows := (len(metrics) + p.Columns - 1) / p.Columns
+	plots := make([][]*plotLib.Plot, rows)
+	for i := range plots {
+		plots[i] = make([]*plotLib.Plot, p.Columns)
+	}
+	for i, metric := range metrics {
+		plotData := make(map[string]plotter.XYs, len(keys))
+		for _, key := range keys {
+			plotData[key] = p.data[metric][key]
+		}
+		// Only the first subplot shows the legend
+		plot := Plot{LabelX: plotTimeLabel, LabelY: metric, Type: p.Type, NoLegend: p.NoLegend || i > 0, Colors: colors, Events: p.events()}
+		subplot, err := plot.createPlot(plotData, nil, &xMin, &xMax, nil, nil)
+		if err != nil {
+			return err
+		}
+		plots[i/p.Columns][i%p.Columns] = subplot
+	}
+
+	width := PlotWidth * vg.Length(p.Columns)
+	height := SubplotHeight * vg.Length(rows)
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(p.OutputFile)), ".")
+	canvas, err := draw.NewFormattedCanvas(width, height, format)
+	if err != nil {
+		return err
+	}
+	tiles := draw.Tiles{
+		Rows:      rows,
+		Cols:      p.Columns,
+		PadTop:    vg.Millimeter,
+		PadBottom: vg.Millimeter,
+		PadLeft:   vg.Millimeter,
+		PadRight:  vg.Millimeter,
+		PadX:      2 * vg.Millimeter,
+		PadY:      2 * vg.Millimeter,
+	}
+	canvases := plotLib.Align(plots, tiles, draw.New(canvas))
+	for row := range plots {
+		for col, subplot := range plots[row] {
+			if subplot != nil {
+				subplot.Draw(canvases[row][col])
+			}
+		}
+	}
+
+	file, err := os.Create(p.OutputFile)
+	if err != nil {
+		return err
+	}
+	_, err = canvas.WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.New("Error saving plot: " + err.Error())
+	}
+	return err
+}
+
+func (p *MetricsPlotProcessor) events() []PlotEvent {
+	if p.Events == nil {
+		return nil
+	}
+	return p.Events.Events()
+}
+
+func (p *MetricsPlotProcessor) String() string {
+	metrics := "all metrics"
+	if len(p.Metrics) > 0 {
+		metrics = "metrics " + strings.Join(p.Metrics, ", ")
+	}
+	if p.Grid {
+		metrics += fmt.Sprintf(", grid with %v columns", p.Columns)
+	}
+	if p.ColorTag != "" {
+		metrics += ", color: " + p.ColorTag
+	}
+	return fmt.Sprintf("Metrics plotter (%v)(file: %v)", metrics, p.OutputFile)
+}
+
+func RegisterMetricsPlot(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("plot_metrics",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			plot := &MetricsPlotProcessor{
+				OutputFile: reg.StrParam(params, "file", "", false, &err),
+				ColorTag:   reg.StrParam(params, "color", "", true, &err),
+				Columns:    reg.IntParam(params, "columns", 1, true, &err),
+				Type:       LinePlot,
+			}
+			if metrics := params["metrics"]; metrics != "" {
+				plot.Metrics = strings.Split(metrics, ",")
+			}
+			if eventTag := params["event_tag"]; eventTag != "" {
+				plot.Events = &EventCollector{Tag: eventTag, Value: params["event_value"]}
+			}
+			if err == nil && plot.Columns < 1 {
+				err = reg.ParameterError("columns", errors.New("Must be at least 1"))
+			}
+			if flagsStr := params["flags"]; err == nil && flagsStr != "" {
+				for _, flag := range strings.Split(flagsStr, ",") {
+					switch flag {
+					case "grid":
+						plot.Grid = true
+					case "nolegend":
+						plot.NoLegend = true
+					case "scatter":
+						plot.Type = ScatterPlot
+					case "linepoint":
+						plot.Type = LinePointPlot
+					default:
+						return fmt.Errorf("Unkown flag: '%v'. The 'flags' parameter is a comma-separated list of flags: %v", flag, []string{"grid", "nolegend", "scatter", "linepoint"})
+					}
+				}
+			}
+			if err == nil {
+				p.Add(plot)
+			}
+			return
+		},
+		"Plot all metrics (or the comma-separated list of metrics) over time to the given file. The file ending denotes the file type. "+
+			"By default, all metrics are drawn as lines in one plot. With the grid flag, every metric is drawn in a separate subplot with a shared time axis, "+
+			"arranged in the given number of columns. If the color tag is given, every value of that tag is drawn in a separate color, which is the same in all subplots. "+
+			"Consecutive samples with the event_tag (and the event_value, if given) are highlighted as events in all plots.",
+		reg.RequiredParams("file"),
+		reg.OptionalParams("metrics", "color", "columns", "flags", "event_tag", "event_value"))
+}
@@ -0,0 +1,102 @@
+package plot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+	"gonum.org/v1/plot/plotter"
+)
+
+type metricsPlotTestSuite struct {
+	testsupport.Suite
+	dir string
+}
+
+func TestMetricsPlot(t *testing.T) {
+	suite.Run(t, new(metricsPlotTestSuite))
+}
+
+func (s *metricsPlotTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "bitflow-metrics-plot-test")
+	s.NoError(err)
+	s.dir = dir
+}
+
+func (s *metricsPlotTestSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func (s *metricsPlotTestSuite) process(step *MetricsPlotProcessor) {
+	s.Process(step, &bitflow.Header{Fields: []string{"cpu", "mem", "disk"}},
+		testsupport.NewSample(0, "host=a", 1, 10, 100),
+		testsupport.NewSample(time.Second, "host=b", 2, 20, 200),
+		testsupport.NewSample(2*time.Second, "host=a", 3, 30, 300))
+}
+
+func (s *metricsPlotTestSuite) fileContent(name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	s.NoError(err)
+	return string(content)
+}
+
+func (s *metricsPlotTestSuite) TestSinglePlot() {
+	step := &MetricsPlotProcessor{Type: LinePlot, OutputFile: filepath.Join(s.dir, "metrics.svg")}
+	s.process(step)
+	s.Equal([]string{"cpu", "mem", "disk"}, step.order)
+	start := float64(testsupport.StartTime.Unix())
+	s.Equal(plotter.XYs{{X: start, Y: 10}, {X: start + 1, Y: 20}, {X: start + 2, Y: 30}}, step.data["mem"][""])
+	svg := s.fileContent("metrics.svg")
+	s.Contains(svg, "<svg")
+	for _, metric := range step.order {
+		s.Contains(svg, ">"+metric+"</text>", "Legend entry for %v", metric)
+	}
+}
+
+func (s *metricsPlotTestSuite) TestGrid() {
+	step := &MetricsPlotProcessor{
+		Type:       LinePointPlot,
+		OutputFile: filepath.Join(s.dir, "grid.svg"),
+		Metrics:    []string{"disk", "cpu", "missing"},
+		ColorTag:   "host",
+		Grid:       true,
+		Columns:    2,
+	}
+	s.process(step)
+	s.Len(step.data, 2, "Unselected metrics are not stored")
+	s.Len(step.data["cpu"]["a"], 2)
+	s.Len(step.data["cpu"]["b"], 1)
+	svg := s.fileContent("grid.svg")
+	s.Contains(svg, ">disk</text>")
+	s.Contains(svg, ">cpu</text>")
+	s.NotContains(svg, ">mem</text>")
+	s.Equal(1, strings.Count(svg, ">a</text>"), "Only the first subplot shows the legend")
+	s.Equal("Metrics plotter (metrics disk, cpu, missing, grid with 2 columns, color: host)(file: "+step.OutputFile+")", step.String())
+}
+
+func (s *metricsPlotTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterMetricsPlot(b)
+	analysis, _ := b.GetAnalysis("plot_metrics")
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, map[string]string{"file": "x.png", "metrics": "a,b", "flags": "grid,scatter", "columns": "3", "event_tag": "e"}))
+	plot := pipe.Processors[0].(*MetricsPlotProcessor)
+	s.Equal([]string{"a", "b"}, plot.Metrics)
+	s.True(plot.Grid)
+	s.Equal(ScatterPlot, plot.Type)
+	s.Equal(3, plot.Columns)
+	s.Equal("e", plot.Events.Tag)
+	for _, params := range []map[string]string{
+		{"file": "x.png", "columns": "0"},
+		{"file": "x.png", "flags": "box"},
+	} {
+		s.Error(analysis.Func(new(bitflow.SamplePipeline), params), "Parameters %v", params)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"image/color"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,6 +17,7 @@ import (
 	"github.com/lucasb-eyer/go-colorful"
 	log "github.com/sirupsen/logrus"
 	plotLib "gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/plotutil"
 	"gonum.org/v1/plot/vg"
@@ -31,6 +34,7 @@ const (
 	PlotHeight = PlotWidth
 
 	numColors      = 100
+	DefaultBins    = 50
 	plotTimeFormat = "02.01.2006 15:04:05"
 	plotTimeLabel  = "time"
 	plotNumLabel   = "num"
@@ -40,6 +44,9 @@ const (
 	LinePointPlot
 	ClusterPlot
 	BoxPlot
+	HeatmapPlot    // Density of the X/Y points
+	HistogramPlot  // One histogram per metric
+	GroupedBoxPlot // One box of the Y values per ColorTag value
 	InvalidPlotType
 )
 
@@ -56,7 +63,9 @@ type PlotProcessor struct {
 	RadiusDimension int
 	OutputFile      string
 	ColorTag        string
-	SeparatePlots   bool // If true, every ColorTag value will create a new plot
+	SeparatePlots   bool            // If true, every ColorTag value will create a new plot
+	Bins            int             // Number of bins per axis for HeatmapPlot and HistogramPlot
+	Events          *EventCollector // Optional, only used when the X axis is the time
 
 	// If not nil, will override the automatically suggested bounds for the respective axis
 	ForceXmin *float64
@@ -66,6 +75,7 @@ type PlotProcessor struct {
 
 	data         map[string]plotter.XYs
 	radiuses     map[string][]float64
+	histograms   map[string]map[string]plotter.XYs // Metric name -> ColorTag value -> values
 	x, y, radius int
 	xName, yName string
 }
@@ -85,6 +95,10 @@ func (p *PlotProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
 	}
 	p.data = make(map[string]plotter.XYs)
 	p.radiuses = make(map[string][]float64)
+	p.histograms = make(map[string]map[string]plotter.XYs)
+	if p.Bins <= 0 {
+		p.Bins = DefaultBins
+	}
 
 	if file, err := os.Create(p.OutputFile); err != nil {
 		// Check if file can be created to quickly fail
@@ -101,7 +115,11 @@ func (p *PlotProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) e
 			return err
 		}
 	}
-	p.storeSample(sample)
+	if p.Type == HistogramPlot {
+		p.storeHistogramSample(sample, header)
+	} else {
+		p.storeSample(sample)
+	}
 	return p.NoopProcessor.Sample(sample, header)
 }
 
@@ -164,7 +182,7 @@ func (p *PlotProcessor) needsRadius() bool {
 	return p.Type == ClusterPlot
 }
 
-func (p *PlotProcessor) storeSample(sample *bitflow.Sample) {
+func (p *PlotProcessor) colorKey(sample *bitflow.Sample) string {
 	key := ""
 	if p.ColorTag != "" {
 		key = sample.Tag(p.ColorTag)
@@ -172,7 +190,15 @@ func (p *PlotProcessor) storeSample(sample *bitflow.Sample) {
 			key = "(none)"
 		}
 	}
+	return key
+}
+
+func (p *PlotProcessor) storeSample(sample *bitflow.Sample) {
+	key := p.colorKey(sample)
 	x := p.getVal(p.x, key, sample)
+	if p.Events != nil && p.x == PlotAxisTime {
+		p.Events.Add(sample, x)
+	}
 	y := p.getVal(p.y, key, sample)
 	p.data[key] = append(p.data[key], struct{ X, Y float64 }{x, y})
 	if p.needsRadius() {
@@ -180,6 +206,18 @@ func (p *PlotProcessor) storeSample(sample *bitflow.Sample) {
 	}
 }
 
+func (p *PlotProcessor) storeHistogramSample(sample *bitflow.Sample, header *bitflow.Header) {
+	key := p.colorKey(sample)
+	for i, field := range header.Fields {
+		data, ok := p.histograms[field]
+		if !ok {
+			data = make(map[string]plotter.XYs)
+			p.histograms[field] = data
+		}
+		data[key] = append(data[key], plotter.XY{X: float64(sample.Values[i]), Y: 1})
+	}
+}
+
 func (p *PlotProcessor) getVal(index int, key string, sample *bitflow.Sample) (res float64) {
 	if index == PlotAxisTime {
 		res = float64(sample.Time.Unix())
@@ -206,9 +244,22 @@ func (p *PlotProcessor) Close() {
 		LabelY:   p.yName,
 		Type:     p.Type,
 		NoLegend: p.NoLegend,
+		Bins:     p.Bins,
+	}
+	if p.Type == GroupedBoxPlot {
+		plot.LabelX = p.ColorTag
+	}
+	if p.Events != nil {
+		if p.x == PlotAxisTime {
+			plot.Events = p.Events.Events()
+		} else {
+			p.Log().Warnln("Events can only be shown when the X axis is the time")
+		}
 	}
 	var err error
-	if p.SeparatePlots {
+	if p.Type == HistogramPlot {
+		err = p.saveHistograms(plot)
+	} else if p.SeparatePlots {
 		_ = os.Remove(p.OutputFile) // Delete file created in Start(), drop error.
 		err = plot.saveSeparatePlots(p.data, p.radiuses, p.OutputFile, p.ForceXmin, p.ForceXmax, p.ForceYmin, p.ForceYmax)
 	} else {
@@ -219,6 +270,26 @@ func (p *PlotProcessor) Close() {
 	}
 }
 
+// saveHistograms creates one plot per metric. With multiple metrics, the metric name is appended to the output file name.
+func (p *PlotProcessor) saveHistograms(plot Plot) error {
+	group := bitflow.NewFileGroup(p.OutputFile)
+	if len(p.histograms) > 1 {
+		_ = os.Remove(p.OutputFile) // Delete file created in Start(), drop error.
+	}
+	for metric, data := range p.histograms {
+		plot.LabelX = metric
+		plot.LabelY = "count"
+		file := p.OutputFile
+		if len(p.histograms) > 1 {
+			file = group.BuildFilenameStr(metric)
+		}
+		if err := plot.savePlot(data, nil, file, p.ForceXmin, p.ForceXmax, p.ForceYmin, p.ForceYmax); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *PlotProcessor) String() string {
 	colorTag := "not colored"
 	if p.ColorTag != "" {
@@ -239,6 +310,9 @@ type Plot struct {
 	LabelX, LabelY string
 	Type           PlotType
 	NoLegend       bool
+	Bins           int                    // Used for HeatmapPlot and HistogramPlot, DefaultBins if not set
+	Colors         map[string]color.Color // Optional, fixed colors for the series with the given names
+	Events         []PlotEvent            // Optional, highlighted regions on the X axis
 }
 
 func (p *Plot) saveSeparatePlots(plotData map[string]plotter.XYs, radiuses map[string][]float64, targetFile string, xMin, xMax, yMin, yMax *float64) error {
@@ -264,6 +338,11 @@ func (p *Plot) saveSeparatePlots(plotData map[string]plotter.XYs, radiuses map[s
 	return nil
 }
 
+// Save creates a plot of the given data series and stores it in the given file. The file format is derived from the file extension.
+func (p *Plot) Save(plotData map[string]plotter.XYs, targetFile string) error {
+	return p.savePlot(plotData, nil, targetFile, nil, nil, nil, nil)
+}
+
 func (p *Plot) savePlot(plotData map[string]plotter.XYs, radiuses map[string][]float64, targetFile string, xMin, xMax, yMin, yMax *float64) error {
 	plot, err := p.createPlot(plotData, radiuses, xMin, xMax, yMin, yMax)
 	if err != nil {
@@ -294,6 +373,10 @@ func (p *Plot) createPlot(plotData map[string]plotter.XYs, radiuses map[string][
 		plot.Y.Max = *yMax
 	}
 	p.configureAxes(plot)
+	if len(p.Events) > 0 {
+		// Add the events first to draw them behind the data
+		plot.Add(&eventsPlotter{events: p.Events})
+	}
 	return plot, p.fillPlot(plot, plotData, radiuses)
 }
 
@@ -314,12 +397,27 @@ func (p *Plot) fillPlot(plot *plotLib.Plot, plotData map[string]plotter.XYs, rad
 		return err
 	}
 
-	if p.Type == BoxPlot {
+	switch p.Type {
+	case BoxPlot:
 		return p.fillBoxPlot(plot, plotData)
+	case GroupedBoxPlot:
+		return p.fillGroupedBoxPlot(plot, plotData)
+	case HeatmapPlot:
+		return p.fillHeatmap(plot, plotData)
 	}
 
-	for name, data := range plotData {
+	// Sort the series to assign the same colors to the same series names in every plot
+	names := make([]string, 0, len(plotData))
+	for name := range plotData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data := plotData[name]
 		plotColor := shape.Colors.Next()
+		if fixedColor, ok := p.Colors[name]; ok {
+			plotColor = fixedColor
+		}
 		legend := name != "" && !p.NoLegend
 
 		var scatter *plotter.Scatter
@@ -331,6 +429,18 @@ func (p *Plot) fillPlot(plot *plotLib.Plot, plotData map[string]plotter.XYs, rad
 			line, err = plotter.NewLine(data)
 		case LinePointPlot:
 			line, scatter, err = plotter.NewLinePoints(data)
+		case HistogramPlot:
+			var hist *plotter.Histogram
+			hist, err = plotter.NewHistogram(data, p.bins())
+			if err == nil {
+				hist.FillColor = nil
+				hist.LineStyle.Color = plotColor
+				plot.Add(hist)
+				if legend {
+					plot.Legend.Add(name, &boxThumbnail{color: plotColor})
+					legend = false
+				}
+			}
 		case ClusterPlot:
 			errorBars := &plotutil.ErrorPoints{
 				XYs:     data,
@@ -424,6 +534,135 @@ func (p *Plot) fillBoxPlot(plot *plotLib.Plot, plotData map[string]plotter.XYs)
 	return nil
 }
 
+// fillGroupedBoxPlot creates one box of the Y values for every data series, ordered by the name of the series.
+func (p *Plot) fillGroupedBoxPlot(plot *plotLib.Plot, plotData map[string]plotter.XYs) error {
+	names := make([]string, 0, len(plotData))
+	for name := range plotData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		data := plotData[name]
+		values := make(plotter.Values, len(data))
+		for j, point := range data {
+			values[j] = point.Y
+		}
+		box, err := plotter.NewBoxPlot(vg.Points(20), float64(i), values)
+		if err != nil {
+			return err
+		}
+		plot.Add(box)
+	}
+	plot.NominalX(names...)
+	plot.X.Min = math.Min(plot.X.Min, -0.5)
+	plot.X.Max = math.Max(plot.X.Max, float64(len(names))-0.5)
+	return nil
+}
+
+// fillHeatmap counts the X/Y points of all data series in a grid of Bins x Bins cells, and colors every
+// cell based on the logarithm of the number of points. Empty cells are not colored.
+func (p *Plot) fillHeatmap(plot *plotLib.Plot, plotData map[string]plotter.XYs) error {
+	var all plotter.XYs
+	for _, data := range plotData {
+		all = append(all, data...)
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	grid := newDensityGrid(all, p.bins())
+	heatmap := plotter.NewHeatMap(grid, palette.Heat(numColors, 1))
+	heatmap.Min, heatmap.Max = grid.zRange()
+	plot.Add(heatmap)
+	return nil
+}
+
+func (p *Plot) bins() int {
+	if p.Bins <= 0 {
+		return DefaultBins
+	}
+	return p.Bins
+}
+
+// densityGrid implements plotter.GridXYZ
+type densityGrid struct {
+	minX, minY   float64
+	stepX, stepY float64
+	counts       [][]float64 // Indexed by column, then row
+}
+
+func newDensityGrid(points plotter.XYs, bins int) *densityGrid {
+	finite := make(plotter.XYs, 0, len(points))
+	for _, point := range points {
+		if !math.IsNaN(point.X) && !math.IsNaN(point.Y) && !math.IsInf(point.X, 0) && !math.IsInf(point.Y, 0) {
+			finite = append(finite, point)
+		}
+	}
+	minX, maxX, minY, maxY := 0.0, 0.0, 0.0, 0.0
+	if len(finite) > 0 {
+		minX, maxX, minY, maxY = plotter.XYRange(finite)
+	}
+	grid := &densityGrid{
+		minX:   minX,
+		minY:   minY,
+		stepX:  (maxX - minX) / float64(bins),
+		stepY:  (maxY - minY) / float64(bins),
+		counts: make([][]float64, bins),
+	}
+	if grid.stepX == 0 {
+		grid.stepX = 1
+	}
+	if grid.stepY == 0 {
+		grid.stepY = 1
+	}
+	for i := range grid.counts {
+		grid.counts[i] = make([]float64, bins)
+	}
+	index := func(val, min, step float64) int {
+		i := int((val - min) / step)
+		if i >= bins {
+			i = bins - 1
+		}
+		return i
+	}
+	for _, point := range finite {
+		grid.counts[index(point.X, grid.minX, grid.stepX)][index(point.Y, grid.minY, grid.stepY)]++
+	}
+	return grid
+}
+
+func (g *densityGrid) Dims() (c, r int) {
+	return len(g.counts), len(g.counts[0])
+}
+
+func (g *densityGrid) Z(c, r int) float64 {
+	if count := g.counts[c][r]; count > 0 {
+		return math.Log10(count)
+	}
+	return math.NaN()
+}
+
+func (g *densityGrid) X(c int) float64 {
+	return g.minX + (float64(c)+0.5)*g.stepX
+}
+
+func (g *densityGrid) Y(r int) float64 {
+	return g.minY + (float64(r)+0.5)*g.stepY
+}
+
+func (g *densityGrid) zRange() (min, max float64) {
+	for _, column := range g.counts {
+		for _, count := range column {
+			if count > 0 && math.Log10(count) > max {
+				max = math.Log10(count)
+			}
+		}
+	}
+	if max == min {
+		max = min + 1
+	}
+	return
+}
+
 type boxThumbnail struct {
 	color color.Color
 }
@@ -562,7 +801,11 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 		if colorName, hasColor := params["color"]; hasColor {
 			plot.ColorTag = colorName
 		}
+		if eventTag, hasEvents := params["event_tag"]; hasEvents {
+			plot.Events = &EventCollector{Tag: eventTag, Value: params["event_value"]}
+		}
 		var err error
+		plot.Bins = reg.IntParam(params, "bins", DefaultBins, true, &err)
 		setPlotBoundParam(&err, params, "xMin", &plot.ForceXmin)
 		setPlotBoundParam(&err, params, "xMax", &plot.ForceXmax)
 		setPlotBoundParam(&err, params, "yMin", &plot.ForceYmin)
@@ -591,6 +834,14 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 					plot.Type = BoxPlot
 					plot.AxisX = PlotAxisNum
 					plot.AxisY = 0
+				case "box_groups":
+					plot.Type = GroupedBoxPlot
+					plot.AxisX = PlotAxisNum
+					plot.AxisY = 0
+				case "heatmap":
+					plot.Type = HeatmapPlot
+				case "histogram":
+					plot.Type = HistogramPlot
 				case "separate":
 					plot.SeparatePlots = true
 				case "force_scatter":
@@ -600,7 +851,7 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 					plot.AxisX = PlotAxisTime
 					plot.AxisY = 0
 				default:
-					all_flags := []string{"nolegend", "line", "linepoint", "cluster", "separate", "force_scatter", "force_time"}
+					all_flags := []string{"nolegend", "line", "linepoint", "cluster", "box", "box_groups", "heatmap", "histogram", "separate", "force_scatter", "force_time"}
 					return fmt.Errorf("Unkown flag: '%v'. The 'flags' parameter is a comma-separated list of flags: %v", part, all_flags)
 				}
 			}
@@ -609,5 +860,7 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 		return nil
 	}
 
-	b.RegisterAnalysisParamsErr("plot", create, "Plot a batch of samples to a given filename. The file ending denotes the file type", reg.RequiredParams("file"), reg.OptionalParams("color", "flags", "xMin", "xMax", "yMin", "yMax"))
+	b.RegisterAnalysisParamsErr("plot", create, "Plot a batch of samples to a given filename. The file ending denotes the file type. "+
+		"The flags heatmap (density of X/Y points), histogram (one file per metric) and box_groups (one box per value of the color tag) select special plot types, the bins parameter configures heatmaps and histograms. "+
+		"If the X axis is the time, consecutive samples with the event_tag (and the event_value, if given) are highlighted as events", reg.RequiredParams("file"), reg.OptionalParams("color", "flags", "bins", "event_tag", "event_value", "xMin", "xMax", "yMin", "yMax"))
 }
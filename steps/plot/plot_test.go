@@ -0,0 +1,127 @@
+package plot
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+	"gonum.org/v1/plot/plotter"
+)
+
+type plotTestSuite struct {
+	testsupport.Suite
+	dir string
+}
+
+func TestPlot(t *testing.T) {
+	suite.Run(t, new(plotTestSuite))
+}
+
+func (s *plotTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "bitflow-plot-test")
+	s.NoError(err)
+	s.dir = dir
+}
+
+func (s *plotTestSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func (s *plotTestSuite) samples() []*bitflow.Sample {
+	return []*bitflow.Sample{
+		testsupport.NewSample(0, "class=a", 1, 10),
+		testsupport.NewSample(0, "class=a", 2, 20),
+		testsupport.NewSample(0, "class=b", 3, 30),
+		testsupport.NewSample(0, "", 4, 40),
+	}
+}
+
+func (s *plotTestSuite) assertFiles(names ...string) {
+	files, err := ioutil.ReadDir(s.dir)
+	s.NoError(err)
+	var found []string
+	for _, file := range files {
+		s.NotZero(file.Size(), "File %v is empty", file.Name())
+		found = append(found, file.Name())
+	}
+	s.Equal(names, found)
+}
+
+func (s *plotTestSuite) TestDensityGrid() {
+	grid := newDensityGrid(plotter.XYs{{X: 0, Y: 0}, {X: 0.1, Y: 0.1}, {X: 1, Y: 1}, {X: 0.9, Y: 0.2}, {X: math.NaN(), Y: 0}}, 2)
+	c, r := grid.Dims()
+	s.Equal(2, c)
+	s.Equal(2, r)
+	s.Equal([][]float64{{2, 0}, {1, 1}}, grid.counts, "The maximum values fall into the last bin, NaN is ignored")
+	s.Equal(math.Log10(2), grid.Z(0, 0))
+	s.True(math.IsNaN(grid.Z(0, 1)), "Empty cells are not colored")
+	s.Equal(0.25, grid.X(0))
+	s.Equal(0.75, grid.Y(1))
+	min, max := grid.zRange()
+	s.Equal(0.0, min)
+	s.Equal(math.Log10(2), max)
+
+	// A single point creates a valid grid and Z range
+	grid = newDensityGrid(plotter.XYs{{X: 5, Y: 5}}, 3)
+	s.Equal(1.0, grid.counts[0][0])
+	min, max = grid.zRange()
+	s.Equal(1.0, max-min)
+}
+
+func (s *plotTestSuite) TestHeatmap() {
+	step := &PlotProcessor{Type: HeatmapPlot, AxisX: PlotAxisAuto, AxisY: PlotAxisAuto, Bins: 4, OutputFile: filepath.Join(s.dir, "heatmap.png")}
+	s.Process(step, &bitflow.Header{Fields: []string{"x", "y"}}, s.samples()...)
+	s.Equal("x", step.xName)
+	s.Equal("y", step.yName)
+	s.assertFiles("heatmap.png")
+}
+
+func (s *plotTestSuite) TestHistograms() {
+	step := &PlotProcessor{Type: HistogramPlot, AxisX: PlotAxisAuto, AxisY: PlotAxisAuto, ColorTag: "class", OutputFile: filepath.Join(s.dir, "hist.png")}
+	s.Process(step, &bitflow.Header{Fields: []string{"x", "y"}}, s.samples()...)
+	s.Equal(plotter.XYs{{X: 10, Y: 1}, {X: 20, Y: 1}}, step.histograms["y"]["a"])
+	s.Equal(plotter.XYs{{X: 4, Y: 1}}, step.histograms["x"]["(none)"])
+	s.assertFiles("hist-x.png", "hist-y.png")
+}
+
+func (s *plotTestSuite) TestSingleHistogram() {
+	step := &PlotProcessor{Type: HistogramPlot, AxisX: PlotAxisAuto, AxisY: PlotAxisAuto, Bins: 2, OutputFile: filepath.Join(s.dir, "hist.png")}
+	s.Process(step, &bitflow.Header{Fields: []string{"x"}},
+		testsupport.NewSample(0, "", 1), testsupport.NewSample(0, "", 2))
+	s.assertFiles("hist.png")
+}
+
+func (s *plotTestSuite) TestGroupedBoxPlot() {
+	step := &PlotProcessor{Type: GroupedBoxPlot, AxisX: PlotAxisNum, AxisY: 1, ColorTag: "class", OutputFile: filepath.Join(s.dir, "box.png")}
+	s.Process(step, &bitflow.Header{Fields: []string{"x", "y"}}, s.samples()...)
+	s.Len(step.data, 3)
+	s.Equal(plotter.XYs{{X: 0, Y: 10}, {X: 1, Y: 20}}, step.data["a"])
+
+	plot := &Plot{Type: GroupedBoxPlot}
+	p, err := plot.createPlot(step.data, nil, nil, nil, nil, nil)
+	s.NoError(err)
+	s.Equal(-0.5, p.X.Min)
+	s.Equal(2.5, p.X.Max)
+	s.assertFiles("box.png")
+}
+
+func (s *plotTestSuite) TestFlags() {
+	b := reg.NewProcessorRegistry()
+	RegisterPlot(b)
+	analysis, _ := b.GetAnalysis("plot")
+	for flags, expected := range map[string]PlotType{"heatmap": HeatmapPlot, "histogram": HistogramPlot, "box_groups": GroupedBoxPlot, "nolegend,line": LinePlot} {
+		pipe := new(bitflow.SamplePipeline)
+		s.NoError(analysis.Func(pipe, map[string]string{"file": "x.png", "flags": flags, "bins": "7"}))
+		plot := pipe.Processors[0].(*PlotProcessor)
+		s.Equal(expected, plot.Type, "Flags %v", flags)
+		s.Equal(7, plot.Bins)
+	}
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"file": "x.png", "flags": "pie"}))
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"file": "x.png", "bins": "many"}))
+}
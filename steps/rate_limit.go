@@ -0,0 +1,104 @@
+package steps
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+func RegisterRateLimiter(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("rate_limit",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			limiter := &RateLimiter{
+				Rate:  reg.FloatParam(params, "samples_per_second", 0, false, &err),
+				Burst: reg.IntParam(params, "burst", 1, true, &err),
+				Drop:  reg.BoolParam(params, "drop", false, true, &err),
+			}
+			if err == nil {
+				if limiter.Rate <= 0 {
+					err = reg.ParameterError("samples_per_second", fmt.Errorf("Must be positive"))
+				} else if limiter.Burst < 1 {
+					err = reg.ParameterError("burst", fmt.Errorf("Must be positive"))
+				} else {
+					p.Add(limiter)
+				}
+			}
+			return
+		},
+		"Limit the number of forwarded samples per second (wall time), allowing bursts of the given size. Samples exceeding the rate are delayed, or dropped with drop=true.",
+		reg.RequiredParams("samples_per_second"), reg.OptionalParams("burst", "drop"))
+}
+
+// RateLimiter limits the rate of forwarded samples using a token bucket. The bucket holds up to Burst tokens and
+// is refilled with Rate tokens per second. Every forwarded sample consumes one token. When no token is available,
+// the sample is either delayed until a token is available, or dropped (if Drop is true).
+type RateLimiter struct {
+	bitflow.NoopProcessor
+	Rate  float64 // Samples per second
+	Burst int
+	Drop  bool
+
+	lock       sync.Mutex
+	tokens     float64
+	lastRefill time.Time
+	dropped    int
+}
+
+func (r *RateLimiter) String() string {
+	behavior := "delay"
+	if r.Drop {
+		behavior = "drop"
+	}
+	return fmt.Sprintf("Rate limit to %v samples/s (burst %v, %v)", r.Rate, r.Burst, behavior)
+}
+
+func (r *RateLimiter) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	for {
+		wait, ok := r.takeToken()
+		if ok {
+			return r.NoopProcessor.Sample(sample, header)
+		}
+		if r.Drop {
+			r.lock.Lock()
+			r.dropped++
+			r.lock.Unlock()
+			return nil
+		}
+		if !r.StopChan.WaitTimeout(wait) {
+			// Shutting down: forward the remaining samples without delay
+			return r.NoopProcessor.Sample(sample, header)
+		}
+	}
+}
+
+func (r *RateLimiter) Close() {
+	if r.dropped > 0 {
+		log.Warnf("%v: Dropped %v sample(s)", r, r.dropped)
+	}
+	r.NoopProcessor.Close()
+}
+
+// takeToken consumes one token, if available. Otherwise, the time until the next token is available is returned.
+func (r *RateLimiter) takeToken() (time.Duration, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	if r.lastRefill.IsZero() {
+		r.tokens = float64(r.Burst)
+	} else {
+		r.tokens += now.Sub(r.lastRefill).Seconds() * r.Rate
+		if r.tokens > float64(r.Burst) {
+			r.tokens = float64(r.Burst)
+		}
+	}
+	r.lastRefill = now
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - r.tokens) / r.Rate * float64(time.Second)), false
+}
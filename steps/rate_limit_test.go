@@ -0,0 +1,74 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type rateLimitTestSuite struct {
+	testsupport.Suite
+}
+
+func TestRateLimit(t *testing.T) {
+	suite.Run(t, new(rateLimitTestSuite))
+}
+
+var rateLimitTestHeader = &bitflow.Header{Fields: []string{"a"}}
+
+func (s *rateLimitTestSuite) samples(num int) []*bitflow.Sample {
+	res := make([]*bitflow.Sample, num)
+	for i := range res {
+		res[i] = testsupport.NewSample(0, "", bitflow.Value(i))
+	}
+	return res
+}
+
+func (s *rateLimitTestSuite) TestDrop() {
+	limiter := &RateLimiter{Rate: 1, Burst: 3, Drop: true}
+	out := s.Process(limiter, rateLimitTestHeader, s.samples(10)...)
+	out.AssertValues(s.T(), [][]float64{{0}, {1}, {2}})
+	s.Equal(7, limiter.dropped)
+}
+
+func (s *rateLimitTestSuite) TestDelay() {
+	limiter := &RateLimiter{Rate: 50, Burst: 2}
+	start := time.Now()
+	out := s.Process(limiter, rateLimitTestHeader, s.samples(6)...)
+	elapsed := time.Since(start)
+	out.AssertCount(s.T(), 6)
+	// The burst is forwarded immediately, the remaining 4 samples are delayed by 20ms each
+	s.True(elapsed >= 70*time.Millisecond, "Samples were forwarded too fast: %v", elapsed)
+	s.True(elapsed < 2*time.Second, "Samples were forwarded too slow: %v", elapsed)
+	s.Equal(0, limiter.dropped)
+}
+
+func (s *rateLimitTestSuite) TestForwardOnShutdown() {
+	limiter := &RateLimiter{Rate: 0.001, Burst: 1}
+	out := testsupport.NewCapturingSink()
+	limiter.SetSink(out)
+	limiter.Start(new(sync.WaitGroup))
+	s.NoError(limiter.Sample(testsupport.NewSample(0, "", 1), rateLimitTestHeader))
+
+	done := make(chan error)
+	go func() {
+		done <- limiter.Sample(testsupport.NewSample(0, "", 2), rateLimitTestHeader)
+	}()
+	select {
+	case <-done:
+		s.Fail("The second sample must be delayed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	limiter.StopChan.Stop()
+	select {
+	case err := <-done:
+		s.NoError(err)
+	case <-time.After(time.Second):
+		s.Fail("The delayed sample must be forwarded when the pipeline shuts down")
+	}
+	out.AssertValues(s.T(), [][]float64{{1}, {2}})
+}
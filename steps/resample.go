@@ -0,0 +1,224 @@
+package steps
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ResampleLinear = "linear"
+	ResampleLast   = "last"
+	ResampleMean   = "mean"
+)
+
+func RegisterResampling(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("resample",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			resampler := &Resampler{
+				Interval: reg.DurationParam(params, "interval", 0, false, &err),
+				Method:   reg.StrParam(params, "method", ResampleLinear, true, &err),
+			}
+			if group := reg.StrParam(params, "group", "", true, &err); group != "" {
+				resampler.GroupTags = strings.Split(group, ",")
+			}
+			if err == nil {
+				switch {
+				case resampler.Interval <= 0:
+					err = reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+				case resampler.Method != ResampleLinear && resampler.Method != ResampleLast && resampler.Method != ResampleMean:
+					err = reg.ParameterError("method", fmt.Errorf("Must be one of %v, %v, %v", ResampleLinear, ResampleLast, ResampleMean))
+				default:
+					p.Add(resampler)
+				}
+			}
+			return
+		},
+		"Re-grid the incoming samples onto timestamps that are multiples of the given interval. "+
+			"The method 'linear' interpolates between neighboring samples, 'last' repeats the last sample before every point in time, 'mean' averages all samples inside every interval. "+
+			"If group is set (comma-separated tags), every combination of values of these tags is resampled separately.",
+		reg.RequiredParams("interval"), reg.OptionalParams("method", "group"))
+}
+
+// Resampler converts an irregularly timestamped sample stream into a stream with samples at fixed intervals.
+// The output timestamps are multiples of Interval. If GroupTags is set, the samples are grouped by the values of these
+// tags and every group is resampled independently, otherwise all samples form one stream.
+// Samples with a timestamp older than the previous sample of the same group are dropped.
+// A header change restarts the resampling. The output samples receive the tags of the most recent input sample of their group.
+type Resampler struct {
+	bitflow.NoopProcessor
+	Interval  time.Duration
+	Method    string
+	GroupTags []string
+
+	checker    bitflow.HeaderChecker
+	groups     map[string]*resampleGroup
+	groupOrder []string // Keys of the groups in the order of their first sample, for deterministic output on flush
+	outOfOrder int
+}
+
+type resampleGroup struct {
+	previous *bitflow.Sample
+	next     time.Time // Next output timestamp
+	sum      []float64 // For ResampleMean: sum of all values in the current interval
+	count    int
+}
+
+func (r *Resampler) String() string {
+	res := fmt.Sprintf("Resample to %v (%v)", r.Interval, r.Method)
+	if len(r.GroupTags) > 0 {
+		res += fmt.Sprintf(" grouped by %v", r.GroupTags)
+	}
+	return res
+}
+
+func (r *Resampler) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if oldHeader := r.checker.LastHeader; r.checker.HeaderChanged(header) {
+		if err := r.flushGroups(oldHeader); err != nil {
+			return err
+		}
+	}
+	group := r.group(sample)
+	if group.previous != nil && sample.Time.Before(group.previous.Time) {
+		r.outOfOrder++
+		log.Debugf("%v: Dropping out-of-order sample with timestamp %v", r, sample.Time)
+		return nil
+	}
+
+	var err error
+	if r.Method == ResampleMean {
+		err = r.sampleMean(group, sample, header)
+	} else {
+		err = r.sampleInterpolate(group, sample, header)
+	}
+	group.previous = sample
+	return err
+}
+
+func (r *Resampler) Close() {
+	if err := r.flushGroups(r.checker.LastHeader); err != nil {
+		r.Error(err)
+	}
+	if r.outOfOrder > 0 {
+		log.Warnf("%v: Dropped %v out-of-order sample(s)", r, r.outOfOrder)
+	}
+	r.NoopProcessor.Close()
+}
+
+func (r *Resampler) group(sample *bitflow.Sample) *resampleGroup {
+	var key strings.Builder
+	for _, tag := range r.GroupTags {
+		key.WriteString(sample.Tag(tag))
+		key.WriteByte(0)
+	}
+	group, ok := r.groups[key.String()]
+	if !ok {
+		if r.groups == nil {
+			r.groups = make(map[string]*resampleGroup)
+		}
+		group = new(resampleGroup)
+		r.groups[key.String()] = group
+		r.groupOrder = append(r.groupOrder, key.String())
+	}
+	return group
+}
+
+// flushGroups outputs the pending mean values of all groups and forgets all groups
+func (r *Resampler) flushGroups(header *bitflow.Header) error {
+	var err error
+	if r.Method == ResampleMean {
+		for _, key := range r.groupOrder {
+			if err = r.flushMean(r.groups[key], header); err != nil {
+				break
+			}
+		}
+	}
+	r.groups = nil
+	r.groupOrder = nil
+	return err
+}
+
+func (r *Resampler) sampleInterpolate(group *resampleGroup, sample *bitflow.Sample, header *bitflow.Header) error {
+	if group.previous == nil {
+		group.next = ceilTime(sample.Time, r.Interval)
+	}
+	for !group.next.After(sample.Time) {
+		var values []bitflow.Value
+		switch {
+		case group.next.Equal(sample.Time):
+			values = append(values, sample.Values...)
+		case r.Method == ResampleLinear:
+			values = InterpolateValues(group.previous, sample, group.next)
+		default: // ResampleLast
+			values = append(values, group.previous.Values...)
+		}
+		out := &bitflow.Sample{Values: values}
+		out.CopyMetadataFrom(sample)
+		out.Time = group.next
+		group.next = group.next.Add(r.Interval)
+		if err := r.NoopProcessor.Sample(out, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Resampler) sampleMean(group *resampleGroup, sample *bitflow.Sample, header *bitflow.Header) error {
+	start := sample.Time.Truncate(r.Interval)
+	if group.count > 0 && !start.Equal(group.next) {
+		if err := r.flushMean(group, header); err != nil {
+			return err
+		}
+	}
+	if group.count == 0 {
+		group.next = start
+		group.sum = make([]float64, len(sample.Values))
+	}
+	for i, val := range sample.Values {
+		group.sum[i] += float64(val)
+	}
+	group.count++
+	return nil
+}
+
+func (r *Resampler) flushMean(group *resampleGroup, header *bitflow.Header) error {
+	if group.count == 0 || group.previous == nil {
+		return nil
+	}
+	out := &bitflow.Sample{Values: make([]bitflow.Value, len(group.sum))}
+	for i, sum := range group.sum {
+		out.Values[i] = bitflow.Value(sum / float64(group.count))
+	}
+	out.CopyMetadataFrom(group.previous)
+	out.Time = group.next
+	group.count = 0
+	return r.NoopProcessor.Sample(out, header)
+}
+
+// InterpolateValues linearly interpolates the values of two samples at the given point in time.
+// Both samples must have the same number of values.
+func InterpolateValues(before, after *bitflow.Sample, t time.Time) []bitflow.Value {
+	values := make([]bitflow.Value, len(after.Values))
+	total := after.Time.Sub(before.Time)
+	if total <= 0 {
+		copy(values, after.Values)
+		return values
+	}
+	factor := bitflow.Value(float64(t.Sub(before.Time)) / float64(total))
+	for i, val := range after.Values {
+		values[i] = before.Values[i] + (val-before.Values[i])*factor
+	}
+	return values
+}
+
+func ceilTime(t time.Time, interval time.Duration) time.Time {
+	res := t.Truncate(interval)
+	if res.Before(t) {
+		res = res.Add(interval)
+	}
+	return res
+}
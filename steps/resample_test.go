@@ -0,0 +1,124 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type resampleTestSuite struct {
+	testsupport.Suite
+}
+
+func TestResample(t *testing.T) {
+	suite.Run(t, new(resampleTestSuite))
+}
+
+var resampleTestHeader = &bitflow.Header{Fields: []string{"a"}}
+
+func (s *resampleTestSuite) resampler(params map[string]string) *Resampler {
+	b := reg.NewProcessorRegistry()
+	RegisterResampling(b)
+	analysis, ok := b.GetAnalysis("resample")
+	s.True(ok)
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, params))
+	s.Len(pipe.Processors, 1)
+	return pipe.Processors[0].(*Resampler)
+}
+
+func (s *resampleTestSuite) irregularSamples() []*bitflow.Sample {
+	return []*bitflow.Sample{
+		testsupport.NewSample(500*time.Millisecond, "", 0),
+		testsupport.NewSample(2500*time.Millisecond, "", 20),
+		testsupport.NewSample(3*time.Second, "", 30),
+	}
+}
+
+func (s *resampleTestSuite) TestLinear() {
+	out := s.Process(s.resampler(map[string]string{"interval": "1s"}), resampleTestHeader, s.irregularSamples()...)
+	out.AssertValues(s.T(), [][]float64{{5}, {15}, {30}})
+	s.Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, out.Offsets())
+}
+
+func (s *resampleTestSuite) TestLast() {
+	out := s.Process(s.resampler(map[string]string{"interval": "1s", "method": "last"}), resampleTestHeader, s.irregularSamples()...)
+	out.AssertValues(s.T(), [][]float64{{0}, {0}, {30}})
+	s.Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, out.Offsets())
+}
+
+func (s *resampleTestSuite) TestMean() {
+	out := s.Process(s.resampler(map[string]string{"interval": "1s", "method": "mean"}), resampleTestHeader,
+		testsupport.NewSample(200*time.Millisecond, "", 1),
+		testsupport.NewSample(700*time.Millisecond, "", 3),
+		testsupport.NewSample(1500*time.Millisecond, "", 10),
+		testsupport.NewSample(3100*time.Millisecond, "", 4))
+	out.AssertValues(s.T(), [][]float64{{2}, {10}, {4}})
+	s.Equal([]time.Duration{0, time.Second, 3 * time.Second}, out.Offsets())
+}
+
+func (s *resampleTestSuite) TestOutOfOrderAndHeaderChange() {
+	resampler := s.resampler(map[string]string{"interval": "1s", "method": "last"})
+	out := testsupport.NewCapturingSink()
+	resampler.SetSink(out)
+	s.NoError(resampler.Sample(testsupport.NewSample(0, "", 1), resampleTestHeader))
+	s.NoError(resampler.Sample(testsupport.NewSample(2*time.Second, "", 2), resampleTestHeader))
+	s.NoError(resampler.Sample(testsupport.NewSample(time.Second, "", 3), resampleTestHeader))
+	s.Equal(1, resampler.outOfOrder)
+	out.AssertValues(s.T(), [][]float64{{1}, {1}, {2}})
+
+	// After a header change, older timestamps are accepted again
+	newHeader := &bitflow.Header{Fields: []string{"a", "b"}}
+	s.NoError(resampler.Sample(testsupport.NewSample(time.Second, "", 4, 5), newHeader))
+	out.AssertValues(s.T(), [][]float64{{1}, {1}, {2}, {4, 5}})
+	s.True(out.Headers()[3] == newHeader)
+}
+
+func (s *resampleTestSuite) TestGroups() {
+	samples := []*bitflow.Sample{
+		testsupport.NewSample(500*time.Millisecond, "host=a", 0),
+		testsupport.NewSample(500*time.Millisecond, "host=b", 100),
+		testsupport.NewSample(1500*time.Millisecond, "host=a", 10),
+		testsupport.NewSample(2500*time.Millisecond, "host=b", 300),
+		testsupport.NewSample(2*time.Second, "host=a", 20), // Not out-of-order, because the previous sample belongs to another group
+	}
+	resampler := s.resampler(map[string]string{"interval": "1s", "group": "host"})
+	s.Equal([]string{"host"}, resampler.GroupTags)
+	out := s.Process(resampler, resampleTestHeader, samples...)
+	out.AssertValues(s.T(), [][]float64{{5}, {150}, {250}, {20}})
+	s.Equal([]string{"a", "b", "b", "a"}, out.Tags("host"))
+	s.Equal([]time.Duration{time.Second, time.Second, 2 * time.Second, 2 * time.Second}, out.Offsets())
+	s.Equal(0, resampler.outOfOrder)
+
+	// Without grouping, the streams are mixed and the interleaved samples are out of order
+	resampler = s.resampler(map[string]string{"interval": "1s"})
+	s.Process(resampler, resampleTestHeader, samples...)
+	s.Equal(1, resampler.outOfOrder)
+}
+
+func (s *resampleTestSuite) TestMeanGroups() {
+	out := s.Process(s.resampler(map[string]string{"interval": "1s", "method": "mean", "group": "host,dc"}), resampleTestHeader,
+		testsupport.NewSample(100*time.Millisecond, "host=a dc=1", 1),
+		testsupport.NewSample(200*time.Millisecond, "host=a dc=2", 10),
+		testsupport.NewSample(300*time.Millisecond, "host=a dc=1", 3),
+		testsupport.NewSample(400*time.Millisecond, "host=a dc=2", 20))
+	out.AssertValues(s.T(), [][]float64{{2}, {15}})
+	s.Equal([]string{"1", "2"}, out.Tags("dc"), "Pending groups must be flushed in the order of their first sample")
+}
+
+func (s *resampleTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterResampling(b)
+	analysis, _ := b.GetAnalysis("resample")
+	for _, params := range []map[string]string{
+		{"interval": "0s"},
+		{"interval": "1s", "method": "median"},
+		{"interval": "x"},
+	} {
+		s.Error(analysis.Func(new(bitflow.SamplePipeline), params), "%v", params)
+	}
+}
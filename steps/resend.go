@@ -2,6 +2,8 @@ package steps
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
@@ -74,42 +76,115 @@ func SendPeriodically(sample *bitflow.Sample, header *bitflow.Header, receiver b
 	return stopper
 }
 
+const (
+	FillForward = "forward"
+	FillLinear  = "linear"
+	FillNaN     = "nan"
+)
+
 func RegisterFillUpStep(b reg.ProcessorRegistry) {
 	b.RegisterAnalysisParamsErr("fill-up",
 		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
 			interval := reg.DurationParam(params, "interval", 0, false, &err)
 			stepInterval := reg.DurationParam(params, "step-interval", interval, true, &err)
-			p.Add(&FillUpProcessor{
+			proc := &FillUpProcessor{
 				MinMissingInterval: interval,
 				StepInterval:       stepInterval,
-			})
+				Method:             reg.StrParam(params, "method", FillForward, true, &err),
+				MaxFilled:          reg.IntParam(params, "max", 0, true, &err),
+				FilledTag:          params["tag"],
+			}
+			if keys := params["key"]; keys != "" {
+				proc.KeyTags = strings.Split(keys, ",")
+			}
+			if err == nil {
+				if proc.Method != FillForward && proc.Method != FillLinear && proc.Method != FillNaN {
+					err = reg.ParameterError("method", fmt.Errorf("Must be one of %v, %v, %v", FillForward, FillLinear, FillNaN))
+				} else if proc.StepInterval <= 0 {
+					err = reg.ParameterError("step-interval", fmt.Errorf("Must be positive"))
+				} else {
+					p.Add(proc)
+				}
+			}
 			return
 		},
-		"If the timestamp different between two consecutive samples is larger than the given interval, send copies of the first sample to fill the gap",
-		reg.RequiredParams("interval"), reg.OptionalParams("step-interval"))
+		"If the timestamp different between two consecutive samples is larger than the given interval, send synthetic samples to fill the gap. "+
+			"The synthetic samples are copies of the first sample (method=forward), linearly interpolated (method=linear), or contain only NaN values (method=nan). "+
+			"With the key parameter (comma-separated tags), gaps are detected separately for every combination of tag values. "+
+			"The tag parameter marks synthetic samples with the given tag, the max parameter limits the number of synthetic samples per gap.",
+		reg.RequiredParams("interval"), reg.OptionalParams("step-interval", "method", "key", "max", "tag"))
 }
 
 type FillUpProcessor struct {
 	bitflow.NoopProcessor
 	MinMissingInterval time.Duration
 	StepInterval       time.Duration
-	previous           *bitflow.Sample
+	Method             string   // FillForward (default), FillLinear or FillNaN
+	KeyTags            []string // If set, gaps are detected separately for every combination of values of these tags
+	MaxFilled          int      // If > 0, at most this number of samples is filled into one gap
+	FilledTag          string   // If set, filled samples receive this tag with the value "true"
+
+	checker  bitflow.HeaderChecker
+	previous map[string]*bitflow.Sample
 }
 
 func (p *FillUpProcessor) String() string {
-	return fmt.Sprintf("Fill up samples missing for %v (in intervals of %v)", p.MinMissingInterval, p.StepInterval)
+	method := p.Method
+	if method == "" {
+		method = FillForward
+	}
+	res := fmt.Sprintf("Fill up samples missing for %v (in intervals of %v, %v)", p.MinMissingInterval, p.StepInterval, method)
+	if len(p.KeyTags) > 0 {
+		res += fmt.Sprintf(" per %v", p.KeyTags)
+	}
+	return res
 }
 
 func (p *FillUpProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
-	if p.previous != nil && !p.previous.Time.Add(p.MinMissingInterval).After(sample.Time) {
-		for t := p.previous.Time.Add(p.StepInterval); t.Before(sample.Time); t = t.Add(p.StepInterval) {
-			clone := p.previous.DeepClone()
-			clone.Time = t
-			if err := p.NoopProcessor.Sample(clone, header); err != nil {
+	if p.checker.HeaderChanged(header) || p.previous == nil {
+		p.previous = make(map[string]*bitflow.Sample)
+	}
+	key := p.key(sample)
+	if previous, ok := p.previous[key]; ok && !previous.Time.Add(p.MinMissingInterval).After(sample.Time) {
+		filled := 0
+		for t := previous.Time.Add(p.StepInterval); t.Before(sample.Time); t = t.Add(p.StepInterval) {
+			if p.MaxFilled > 0 && filled >= p.MaxFilled {
+				break
+			}
+			if err := p.NoopProcessor.Sample(p.fill(previous, sample, t), header); err != nil {
 				return err
 			}
+			filled++
 		}
 	}
-	p.previous = sample.DeepClone() // Clone necessary because follow-up steps might modify the sample in-place
+	p.previous[key] = sample.DeepClone() // Clone necessary because follow-up steps might modify the sample in-place
 	return p.NoopProcessor.Sample(sample, header)
 }
+
+func (p *FillUpProcessor) key(sample *bitflow.Sample) string {
+	if len(p.KeyTags) == 0 {
+		return ""
+	}
+	values := make([]string, len(p.KeyTags))
+	for i, tag := range p.KeyTags {
+		values[i] = sample.Tag(tag)
+	}
+	return strings.Join(values, ",")
+}
+
+func (p *FillUpProcessor) fill(previous, next *bitflow.Sample, t time.Time) *bitflow.Sample {
+	res := previous.DeepClone()
+	res.Time = t
+	switch p.Method {
+	case FillLinear:
+		res.Values = InterpolateValues(previous, next, t)
+	case FillNaN:
+		for i := range res.Values {
+			res.Values[i] = bitflow.Value(math.NaN())
+		}
+	}
+	if p.FilledTag != "" {
+		res.SetTag(p.FilledTag, "true")
+	}
+	return res
+}
@@ -0,0 +1,85 @@
+package steps
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type fillUpTestSuite struct {
+	testsupport.Suite
+}
+
+func TestFillUp(t *testing.T) {
+	suite.Run(t, new(fillUpTestSuite))
+}
+
+var fillUpTestHeader = &bitflow.Header{Fields: []string{"a"}}
+
+func (s *fillUpTestSuite) fillUp(params map[string]string) bitflow.SampleProcessor {
+	b := reg.NewProcessorRegistry()
+	RegisterFillUpStep(b)
+	analysis, ok := b.GetAnalysis("fill-up")
+	s.True(ok)
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(analysis.Func(pipe, params))
+	s.Len(pipe.Processors, 1)
+	return pipe.Processors[0]
+}
+
+func (s *fillUpTestSuite) TestForward() {
+	out := s.Process(s.fillUp(map[string]string{"interval": "2s", "step-interval": "1s"}), fillUpTestHeader,
+		testsupport.NewSample(0, "", 1),
+		testsupport.NewSample(time.Second, "", 2), // No gap
+		testsupport.NewSample(4*time.Second, "", 5))
+	out.AssertValues(s.T(), [][]float64{{1}, {2}, {2}, {2}, {5}})
+	s.Equal([]time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second}, out.Offsets())
+}
+
+func (s *fillUpTestSuite) TestLinearWithTag() {
+	out := s.Process(s.fillUp(map[string]string{"interval": "1s", "method": "linear", "tag": "filled"}), fillUpTestHeader,
+		testsupport.NewSample(0, "", 0),
+		testsupport.NewSample(4*time.Second, "", 8))
+	out.AssertValues(s.T(), [][]float64{{0}, {2}, {4}, {6}, {8}})
+	s.Equal([]string{"", "true", "true", "true", ""}, out.Tags("filled"))
+}
+
+func (s *fillUpTestSuite) TestNaNAndMax() {
+	out := s.Process(s.fillUp(map[string]string{"interval": "1s", "method": "nan", "max": "2"}), fillUpTestHeader,
+		testsupport.NewSample(0, "", 1),
+		testsupport.NewSample(10*time.Second, "", 2))
+	values := out.Values()
+	s.Len(values, 4, "At most 2 samples must be filled into the gap")
+	s.True(math.IsNaN(values[1][0]) && math.IsNaN(values[2][0]))
+	s.Equal([]time.Duration{0, time.Second, 2 * time.Second, 10 * time.Second}, out.Offsets())
+}
+
+func (s *fillUpTestSuite) TestKeys() {
+	out := s.Process(s.fillUp(map[string]string{"interval": "2s", "step-interval": "1s", "key": "host"}), fillUpTestHeader,
+		testsupport.NewSample(0, "host=a", 1),
+		testsupport.NewSample(time.Second, "host=b", 10),
+		testsupport.NewSample(2*time.Second, "host=b", 20),
+		testsupport.NewSample(3*time.Second, "host=a", 3))
+	out.AssertValues(s.T(), [][]float64{{1}, {10}, {20}, {1}, {1}, {3}})
+	s.Equal([]string{"a", "b", "b", "a", "a", "a"}, out.Tags("host"))
+
+	// Without key tags, the interleaved samples do not leave a gap
+	out = s.Process(s.fillUp(map[string]string{"interval": "2s", "step-interval": "1s"}), fillUpTestHeader,
+		testsupport.NewSample(0, "host=a", 1),
+		testsupport.NewSample(time.Second, "host=b", 10),
+		testsupport.NewSample(3*time.Second, "host=a", 3))
+	out.AssertValues(s.T(), [][]float64{{1}, {10}, {10}, {3}})
+}
+
+func (s *fillUpTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterFillUpStep(b)
+	analysis, _ := b.GetAnalysis("fill-up")
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"interval": "1s", "method": "spline"}))
+	s.Error(analysis.Func(new(bitflow.SamplePipeline), map[string]string{"interval": "0s"}))
+}
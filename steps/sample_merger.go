@@ -180,7 +180,7 @@ func (p *SynchronizedStreamMerger) logQueueLengths() {
 			min = l
 		}
 	}
-	log.Printf("%v: Outputting merged sample, avg queue length: %v (min: %v max: %v)", p, avg, min, max)
+	p.Log().Printf("Outputting merged sample, avg queue length: %v (min: %v max: %v)", avg, min, max)
 }
 
 func (p *SynchronizedStreamMerger) logWaitingQueues() {
@@ -311,3 +311,157 @@ func (m *mergeQueue) popYoungerThan(endTime time.Time) queueElem {
 	}
 	return queueElem{}
 }
+
+func (m *mergeQueue) pop() queueElem {
+	if elem := m.queue.Front(); elem != nil {
+		return m.queue.Remove(elem).(queueElem)
+	}
+	return queueElem{}
+}
+
+func RegisterOrderedStreamMerger(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("merge_ordered",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			merger := &TimeOrderedMerger{
+				StreamTag:       reg.StrParam(params, "tag", "", false, &err),
+				ExpectedStreams: reg.IntParam(params, "num", 0, true, &err),
+				MaxBuffer:       reg.IntParam(params, "buffer", DefaultMergeBuffer, true, &err),
+				Lateness:        reg.DurationParam(params, "lateness", 0, true, &err),
+				ForwardLate:     reg.BoolParam(params, "forward_late", false, true, &err),
+			}
+			if err == nil && merger.MaxBuffer <= 0 {
+				err = reg.ParameterError("buffer", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				p.Add(merger)
+			}
+			return
+		},
+		"Merge multiple streams, identified by the given tag, into a single stream ordered by timestamps. Every stream is buffered until all streams (at least num, if given) have delivered a sample, "+
+			"so that the oldest sample can be forwarded. The buffer of every stream is bounded by the buffer parameter. "+
+			"If lateness is set, samples older than the newest timestamp minus lateness are forwarded without waiting for the other streams. "+
+			"Samples older than the last forwarded sample are dropped, unless forward_late=true.",
+		reg.RequiredParams("tag"), reg.OptionalParams("num", "buffer", "lateness", "forward_late"))
+}
+
+const DefaultMergeBuffer = 1000
+
+// TimeOrderedMerger merges the samples of multiple input streams, identified by the StreamTag, into one stream
+// ordered by timestamps. Every stream is expected to be ordered by itself. The oldest buffered sample is forwarded when
+// every stream has at least one buffered sample, when the buffer of a stream exceeds MaxBuffer, or when the sample is older than
+// the newest received timestamp minus Lateness (if Lateness is set). Samples older than the last forwarded sample are late:
+// they are dropped, or forwarded immediately if ForwardLate is set.
+type TimeOrderedMerger struct {
+	bitflow.NoopProcessor
+	StreamTag       string
+	ExpectedStreams int // Do not forward samples based on complete buffers, before this number of streams was seen
+	MaxBuffer       int
+	Lateness        time.Duration
+	ForwardLate     bool
+
+	streams      []*mergeQueue
+	streamIndex  map[string]*mergeQueue
+	lastEmitted  time.Time
+	newest       time.Time
+	lateSamples  int
+	forcedOutput int
+}
+
+func (m *TimeOrderedMerger) String() string {
+	res := fmt.Sprintf("Merge streams ordered by time (tag %v, buffer %v", m.StreamTag, m.MaxBuffer)
+	if m.ExpectedStreams > 0 {
+		res += fmt.Sprintf(", %v streams", m.ExpectedStreams)
+	}
+	if m.Lateness > 0 {
+		res += fmt.Sprintf(", lateness %v", m.Lateness)
+	}
+	return res + ")"
+}
+
+func (m *TimeOrderedMerger) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if !m.lastEmitted.IsZero() && sample.Time.Before(m.lastEmitted) {
+		m.lateSamples++
+		if m.ForwardLate {
+			return m.NoopProcessor.Sample(sample, header)
+		}
+		return nil
+	}
+	if m.streamIndex == nil {
+		m.streamIndex = make(map[string]*mergeQueue)
+	}
+	name := sample.Tag(m.StreamTag)
+	queue, ok := m.streamIndex[name]
+	if !ok {
+		queue = new(mergeQueue)
+		queue.queue.Init()
+		m.streamIndex[name] = queue
+		m.streams = append(m.streams, queue)
+	}
+	queue.push(sample, header)
+	if sample.Time.After(m.newest) {
+		m.newest = sample.Time
+	}
+	return m.flush(false)
+}
+
+func (m *TimeOrderedMerger) Close() {
+	if err := m.flush(true); err != nil {
+		m.Error(err)
+	}
+	if m.lateSamples > 0 {
+		action := "Dropped"
+		if m.ForwardLate {
+			action = "Forwarded"
+		}
+		m.Log().Warnf("%v %v late sample(s)", action, m.lateSamples)
+	}
+	if m.forcedOutput > 0 {
+		m.Log().Warnf("Forwarded %v sample(s) before all streams delivered data, because of the lateness or buffer limit", m.forcedOutput)
+	}
+	m.NoopProcessor.Close()
+}
+
+// flush forwards buffered samples in time order, as long as the oldest sample can be forwarded. If all is true, all samples are forwarded.
+func (m *TimeOrderedMerger) flush(all bool) error {
+	for {
+		oldest, complete, full := m.oldestQueue()
+		if oldest == nil {
+			return nil
+		}
+		elem := oldest.peek()
+		late := m.Lateness > 0 && !elem.sample.Time.After(m.newest.Add(-m.Lateness))
+		if !all && !complete && !full && !late {
+			return nil
+		}
+		if !all && !complete {
+			m.forcedOutput++
+		}
+		oldest.pop()
+		m.lastEmitted = elem.sample.Time
+		if err := m.NoopProcessor.Sample(elem.sample, elem.header); err != nil {
+			return err
+		}
+	}
+}
+
+// oldestQueue returns the stream with the oldest buffered sample, whether every stream has buffered samples,
+// and whether any stream exceeds the buffer limit.
+func (m *TimeOrderedMerger) oldestQueue() (oldest *mergeQueue, complete bool, full bool) {
+	complete = len(m.streams) >= m.ExpectedStreams
+	var oldestTime time.Time
+	for _, queue := range m.streams {
+		elem := queue.peek()
+		if elem.sample == nil {
+			complete = false
+			continue
+		}
+		if queue.queue.Len() > m.MaxBuffer {
+			full = true
+		}
+		if oldest == nil || elem.sample.Time.Before(oldestTime) {
+			oldest = queue
+			oldestTime = elem.sample.Time
+		}
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type timeOrderedMergerTestSuite struct {
+	testsupport.Suite
+}
+
+func TestTimeOrderedMerger(t *testing.T) {
+	suite.Run(t, new(timeOrderedMergerTestSuite))
+}
+
+type mergeInput struct {
+	stream string
+	second int
+}
+
+// merge sends one sample per input through the merger and returns the streams and timestamps (in seconds) of the output samples.
+func (s *timeOrderedMergerTestSuite) merge(merger *TimeOrderedMerger, inputs ...mergeInput) (streams []string, seconds []int) {
+	out := testsupport.NewCapturingSink()
+	merger.SetSink(out)
+	for _, input := range inputs {
+		_ = merger.Sample(testsupport.NewSample(time.Duration(input.second)*time.Second, "src="+input.stream), &bitflow.Header{})
+	}
+	merger.Close()
+	for _, offset := range out.Offsets() {
+		seconds = append(seconds, int(offset/time.Second))
+	}
+	return out.Tags("src"), seconds
+}
+
+func (s *timeOrderedMergerTestSuite) TestOrder() {
+	inputs := []mergeInput{{"a", 1}, {"a", 3}, {"b", 2}, {"a", 5}, {"b", 4}, {"c", 3}, {"b", 6}, {"c", 7}, {"a", 1}}
+
+	streams, seconds := s.merge(&TimeOrderedMerger{StreamTag: "src", MaxBuffer: 10}, inputs...)
+	// Without the number of streams, the samples of a are forwarded before the other streams are known
+	s.Equal([]int{1, 3, 5, 6, 7}, seconds, "late samples must be dropped")
+	s.Equal([]string{"a", "a", "a", "b", "c"}, streams)
+
+	_, seconds = s.merge(&TimeOrderedMerger{StreamTag: "src", MaxBuffer: 10, ExpectedStreams: 3}, inputs...)
+	s.Equal([]int{1, 2, 3, 3, 4, 5, 6, 7}, seconds)
+
+	merger := &TimeOrderedMerger{StreamTag: "src", MaxBuffer: 10, ExpectedStreams: 3, ForwardLate: true}
+	_, seconds = s.merge(merger, inputs...)
+	s.Equal([]int{1, 2, 3, 3, 4, 5, 1, 6, 7}, seconds)
+	s.Equal(1, merger.lateSamples)
+}
+
+func (s *timeOrderedMergerTestSuite) TestBounds() {
+	inputs := []mergeInput{{"a", 1}, {"a", 2}, {"a", 3}, {"a", 4}, {"b", 1}, {"b", 5}}
+
+	// Without a bound, stream a waits for stream b
+	_, seconds := s.merge(&TimeOrderedMerger{StreamTag: "src", MaxBuffer: 10, ExpectedStreams: 2}, inputs...)
+	s.Equal([]int{1, 1, 2, 3, 4, 5}, seconds)
+
+	merger := &TimeOrderedMerger{StreamTag: "src", MaxBuffer: 2, ExpectedStreams: 2}
+	_, seconds = s.merge(merger, inputs...)
+	s.Equal([]int{1, 2, 3, 4, 5}, seconds, "the bounded buffer forwards samples of a, so the first sample of b is late")
+	s.Equal(2, merger.forcedOutput)
+
+	merger = &TimeOrderedMerger{StreamTag: "src", MaxBuffer: 10, ExpectedStreams: 2, Lateness: 2 * time.Second}
+	_, seconds = s.merge(merger, inputs...)
+	s.Equal([]int{1, 2, 3, 4, 5}, seconds)
+	s.Equal(2, merger.forcedOutput)
+}
@@ -0,0 +1,224 @@
+package steps
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterSampling(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("sample_reservoir",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			sampler := &ReservoirSampler{
+				Size: reg.IntParam(params, "size", 0, false, &err),
+				Seed: int64(reg.IntParam(params, "seed", 1, true, &err)),
+				Tag:  reg.StrParam(params, "tag", "", true, &err),
+			}
+			if err == nil && sampler.Size <= 0 {
+				err = reg.ParameterError("size", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				p.Add(sampler)
+			}
+			return
+		},
+		"Read until the end of the stream and forward a uniformly random selection of the given number of samples (reservoir sampling). "+
+			"If the tag parameter is given, size samples are selected for every value of that tag. The selection is reproducible through the seed parameter, "+
+			"the selected samples are forwarded in their original order.",
+		reg.RequiredParams("size"), reg.OptionalParams("seed", "tag"))
+
+	b.RegisterAnalysisParamsErr("sample_stratified",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			sampler := &StratifiedSampler{
+				Fraction: reg.FloatParam(params, "fraction", 0, false, &err),
+				Seed:     int64(reg.IntParam(params, "seed", 1, true, &err)),
+				Tag:      reg.StrParam(params, "tag", "", false, &err),
+			}
+			if err == nil && (sampler.Fraction <= 0 || sampler.Fraction > 1) {
+				err = reg.ParameterError("fraction", fmt.Errorf("Must be in ]0..1]"))
+			}
+			if err == nil {
+				p.Add(sampler)
+			}
+			return
+		},
+		"Randomly forward the given fraction of samples for every value of the given tag, so that every value is represented with the same proportion as in the input. "+
+			"The selection is reproducible through the seed parameter.",
+		reg.RequiredParams("fraction", "tag"), reg.OptionalParams("seed"))
+
+	b.RegisterAnalysisParamsErr("sample_every",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			sampler := &SystematicSampler{
+				N:      reg.IntParam(params, "n", 0, false, &err),
+				Offset: reg.IntParam(params, "offset", 0, true, &err),
+				Tag:    reg.StrParam(params, "tag", "", true, &err),
+			}
+			if err == nil && sampler.N <= 0 {
+				err = reg.ParameterError("n", fmt.Errorf("Must be positive"))
+			}
+			if err == nil && (sampler.Offset < 0 || sampler.Offset >= sampler.N) {
+				err = reg.ParameterError("offset", fmt.Errorf("Must be in [0..n["))
+			}
+			if err == nil {
+				p.Add(sampler)
+			}
+			return
+		},
+		"Forward every n-th sample, starting with the sample at the given offset (systematic sampling). If the tag parameter is given, the samples are counted separately for every value of that tag.",
+		reg.RequiredParams("n"), reg.OptionalParams("offset", "tag"))
+}
+
+// ReservoirSampler selects a uniformly random subset of Size samples from the entire stream, or Size samples for
+// every value of Tag, if it is set. The samples are buffered and forwarded in their original order when the stream ends.
+type ReservoirSampler struct {
+	bitflow.NoopProcessor
+	Size int
+	Seed int64
+	Tag  string
+
+	rnd        *rand.Rand
+	reservoirs map[string]*sampleReservoir
+	seen       int
+}
+
+type sampleReservoir struct {
+	seen    int
+	samples []reservoirSample
+}
+
+type reservoirSample struct {
+	index int
+	bitflow.SampleAndHeader
+}
+
+func (s *ReservoirSampler) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if s.reservoirs == nil {
+		s.rnd = rand.New(rand.NewSource(s.Seed))
+		s.reservoirs = make(map[string]*sampleReservoir)
+	}
+	stratum := ""
+	if s.Tag != "" {
+		stratum = sample.Tag(s.Tag)
+	}
+	res, ok := s.reservoirs[stratum]
+	if !ok {
+		res = new(sampleReservoir)
+		s.reservoirs[stratum] = res
+	}
+	entry := reservoirSample{index: s.seen, SampleAndHeader: bitflow.SampleAndHeader{Sample: sample, Header: header}}
+	s.seen++
+	res.seen++
+	if len(res.samples) < s.Size {
+		res.samples = append(res.samples, entry)
+	} else if i := s.rnd.Intn(res.seen); i < s.Size {
+		res.samples[i] = entry
+	}
+	return nil
+}
+
+func (s *ReservoirSampler) Close() {
+	defer s.NoopProcessor.Close()
+	var samples []reservoirSample
+	for _, res := range s.reservoirs {
+		samples = append(samples, res.samples...)
+	}
+	s.reservoirs = nil
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].index < samples[j].index
+	})
+	s.Log().Printf("Reached end of stream, forwarding %v of %v samples", len(samples), s.seen)
+	for _, sample := range samples {
+		if err := s.NoopProcessor.Sample(sample.Sample, sample.Header); err != nil {
+			s.Error(err)
+			break
+		}
+	}
+}
+
+func (s *ReservoirSampler) String() string {
+	res := fmt.Sprintf("Reservoir sampling (%v samples", s.Size)
+	if s.Tag != "" {
+		res += " per value of " + s.Tag
+	}
+	return res + fmt.Sprintf(", seed %v)", s.Seed)
+}
+
+// StratifiedSampler forwards a random Fraction of the samples of every value of Tag. Every sample is forwarded with
+// a probability that is adjusted to the number of already forwarded samples of its stratum, so that the number of forwarded
+// samples of every stratum never deviates from the expected number by more than one sample.
+type StratifiedSampler struct {
+	bitflow.NoopProcessor
+	Fraction float64
+	Seed     int64
+	Tag      string
+
+	rnd    *rand.Rand
+	strata map[string]*stratumCounter
+}
+
+type stratumCounter struct {
+	seen     int
+	selected int
+}
+
+func (s *StratifiedSampler) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if s.strata == nil {
+		s.rnd = rand.New(rand.NewSource(s.Seed))
+		s.strata = make(map[string]*stratumCounter)
+	}
+	value := sample.Tag(s.Tag)
+	counter, ok := s.strata[value]
+	if !ok {
+		counter = new(stratumCounter)
+		s.strata[value] = counter
+	}
+	counter.seen++
+	probability := s.Fraction*float64(counter.seen) - float64(counter.selected)
+	if s.rnd.Float64() < probability {
+		counter.selected++
+		return s.NoopProcessor.Sample(sample, header)
+	}
+	return nil
+}
+
+func (s *StratifiedSampler) String() string {
+	return fmt.Sprintf("Stratified sampling (fraction %v per value of %v, seed %v)", s.Fraction, s.Tag, s.Seed)
+}
+
+// SystematicSampler forwards every N-th sample, starting at Offset. If Tag is set, the samples are counted separately
+// for every value of the tag.
+type SystematicSampler struct {
+	bitflow.NoopProcessor
+	N      int
+	Offset int
+	Tag    string
+
+	counters map[string]int
+}
+
+func (s *SystematicSampler) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if s.counters == nil {
+		s.counters = make(map[string]int)
+	}
+	stratum := ""
+	if s.Tag != "" {
+		stratum = sample.Tag(s.Tag)
+	}
+	num := s.counters[stratum]
+	s.counters[stratum] = (num + 1) % s.N
+	if num == s.Offset {
+		return s.NoopProcessor.Sample(sample, header)
+	}
+	return nil
+}
+
+func (s *SystematicSampler) String() string {
+	res := fmt.Sprintf("Systematic sampling (every %v. sample, offset %v", s.N, s.Offset)
+	if s.Tag != "" {
+		res += ", counted per value of " + s.Tag
+	}
+	return res + ")"
+}
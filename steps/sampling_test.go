@@ -0,0 +1,75 @@
+package steps
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type samplingTestSuite struct {
+	testsupport.Suite
+}
+
+func TestSampling(t *testing.T) {
+	suite.Run(t, new(samplingTestSuite))
+}
+
+// sample sends num samples with increasing values and alternating values of the "class" tag ("a", "b", "b")
+// and returns the values of the forwarded samples
+func (s *samplingTestSuite) sample(proc bitflow.SampleProcessor, num int) (values []float64, classes map[string]int) {
+	samples := make([]*bitflow.Sample, num)
+	for i := range samples {
+		class := "b"
+		if i%3 == 0 {
+			class = "a"
+		}
+		samples[i] = testsupport.NewSample(0, "class="+class, bitflow.Value(i))
+	}
+	out := s.Process(proc, &bitflow.Header{Fields: []string{"val"}}, samples...)
+	classes = make(map[string]int)
+	for _, class := range out.Tags("class") {
+		classes[class]++
+	}
+	for _, vals := range out.Values() {
+		values = append(values, vals[0])
+	}
+	return
+}
+
+func (s *samplingTestSuite) TestReservoir() {
+	values, _ := s.sample(&ReservoirSampler{Size: 10, Seed: 1}, 1000)
+	s.Len(values, 10)
+	s.True(sort.Float64sAreSorted(values), "the original order must be kept")
+	again, _ := s.sample(&ReservoirSampler{Size: 10, Seed: 1}, 1000)
+	s.Equal(values, again, "the same seed must select the same samples")
+	other, _ := s.sample(&ReservoirSampler{Size: 10, Seed: 2}, 1000)
+	s.NotEqual(values, other)
+
+	_, classes := s.sample(&ReservoirSampler{Size: 5, Seed: 1, Tag: "class"}, 100)
+	s.Equal(map[string]int{"a": 5, "b": 5}, classes)
+
+	values, _ = s.sample(&ReservoirSampler{Size: 10, Seed: 1}, 3)
+	s.Equal([]float64{0, 1, 2}, values)
+}
+
+func (s *samplingTestSuite) TestStratified() {
+	values, classes := s.sample(&StratifiedSampler{Fraction: 0.1, Seed: 1, Tag: "class"}, 3000)
+	s.InDelta(100, classes["a"], 1)
+	s.InDelta(200, classes["b"], 1)
+	again, _ := s.sample(&StratifiedSampler{Fraction: 0.1, Seed: 1, Tag: "class"}, 3000)
+	s.Equal(values, again, "the same seed must select the same samples")
+
+	// The selected samples must be spread over the entire input
+	s.True(values[0] < 100)
+	s.True(values[len(values)-1] > 2900)
+}
+
+func (s *samplingTestSuite) TestSystematic() {
+	values, _ := s.sample(&SystematicSampler{N: 4, Offset: 1}, 12)
+	s.Equal([]float64{1, 5, 9}, values)
+	values, _ = s.sample(&SystematicSampler{N: 2, Tag: "class"}, 9)
+	s.Equal([]float64{0, 1, 4, 6, 7}, values)
+}
@@ -8,18 +8,45 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func NewSampleShuffler() *bitflow.SimpleBatchProcessingStep {
-	return &bitflow.SimpleBatchProcessingStep{
-		Description: "sample shuffler",
-		Process: func(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
-			log.Println("Shuffling", len(samples), "samples")
-			for i := range samples {
-				j := rand.Intn(i + 1)
-				samples[i], samples[j] = samples[j], samples[i]
-			}
-			return header, samples, nil
-		},
+// SampleShuffler shuffles a batch of samples to a random ordering.
+type SampleShuffler struct {
+}
+
+func NewSampleShuffler() *SampleShuffler {
+	return new(SampleShuffler)
+}
+
+func (s *SampleShuffler) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	log.Println("Shuffling", len(samples), "samples")
+	for i := range samples {
+		j := rand.Intn(i + 1)
+		samples[i], samples[j] = samples[j], samples[i]
+	}
+	return header, samples, nil
+}
+
+// NextRun implements bitflow.SpillingBatchProcessingStep. Every spilled run is shuffled, so picking the next run
+// with a probability proportional to its remaining samples results in a uniformly random ordering of all samples.
+func (s *SampleShuffler) NextRun(_ []*bitflow.Sample, remaining []int) int {
+	total := 0
+	for _, num := range remaining {
+		total += num
+	}
+	if total == 0 {
+		return -1
 	}
+	pick := rand.Intn(total)
+	for i, num := range remaining {
+		if pick < num {
+			return i
+		}
+		pick -= num
+	}
+	return -1
+}
+
+func (s *SampleShuffler) String() string {
+	return "sample shuffler"
 }
 
 func RegisterSampleShuffler(b reg.ProcessorRegistry) {
@@ -9,7 +9,9 @@ import (
 )
 
 func RegisterSleep(b reg.ProcessorRegistry) {
-	b.RegisterAnalysisParamsErr("sleep", _create_sleep_processor, "Between every two samples, sleep the time difference between their timestamps", reg.OptionalParams("time", "onChangedTag"))
+	b.RegisterAnalysisParamsErr("sleep", _create_sleep_processor, "Between every two samples, sleep the time difference between their timestamps", reg.OptionalParams("time", "onChangedTag"),
+		reg.ParamDetails("time", reg.TypeDuration, "", "Fixed time to sleep, instead of the timestamp difference"),
+		reg.ParamDetails("onChangedTag", reg.TypeString, "", "Only sleep when the value of this tag changes"))
 }
 
 func _create_sleep_processor(p *bitflow.SamplePipeline, params map[string]string) error {
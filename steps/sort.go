@@ -24,9 +24,15 @@ func (s SampleSlice) Len() int {
 }
 
 func (s SampleSlice) Less(i, j int) bool {
-	a := s.samples[i]
-	b := s.samples[j]
-	for _, tag := range s.sorter.Tags {
+	return s.sorter.Less(s.samples[i], s.samples[j])
+}
+
+func (s SampleSlice) Swap(i, j int) {
+	s.samples[i], s.samples[j] = s.samples[j], s.samples[i]
+}
+
+func (sorter *SampleSorter) Less(a, b *bitflow.Sample) bool {
+	for _, tag := range sorter.Tags {
 		tagA := a.Tag(tag)
 		tagB := b.Tag(tag)
 		if tagA == tagB {
@@ -37,16 +43,24 @@ func (s SampleSlice) Less(i, j int) bool {
 	return a.Time.Before(b.Time)
 }
 
-func (s SampleSlice) Swap(i, j int) {
-	s.samples[i], s.samples[j] = s.samples[j], s.samples[i]
-}
-
 func (sorter *SampleSorter) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
 	log.Println("Sorting", len(samples), "samples")
 	sort.Sort(SampleSlice{samples, sorter})
 	return header, samples, nil
 }
 
+// NextRun implements bitflow.SpillingBatchProcessingStep. The spilled runs are sorted, so the smallest head is
+// the next sample. Ties are resolved in the order of the runs, which keeps the sorting stable for equal samples.
+func (sorter *SampleSorter) NextRun(heads []*bitflow.Sample, _ []int) int {
+	next := -1
+	for i, head := range heads {
+		if head != nil && (next < 0 || sorter.Less(head, heads[next])) {
+			next = i
+		}
+	}
+	return next
+}
+
 func (sorter *SampleSorter) String() string {
 	all := make([]string, len(sorter.Tags)+1)
 	copy(all, sorter.Tags)
@@ -1,6 +1,7 @@
 package steps
 
 import (
+	"fmt"
 	"sort"
 	"strconv"
 
@@ -75,6 +76,53 @@ func (stats *StoreStats) StoreStatistics() error {
 	return cfg.SaveTo(stats.TargetFile)
 }
 
+// StoredFeatureStats contains the statistics of one metric, as written by StoreStats.
+type StoredFeatureStats struct {
+	Mean   float64
+	Stddev float64
+	Min    float64
+	Max    float64
+	Count  uint64
+}
+
+// LoadStats reads a file written by StoreStats and returns the statistics of every metric.
+func LoadStats(filename string) (map[string]StoredFeatureStats, error) {
+	cfg, err := ini.Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]StoredFeatureStats)
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DEFAULT_SECTION {
+			continue
+		}
+		var stats StoredFeatureStats
+		var multiErr golib.MultiError
+		floatKey := func(name string, target *float64) {
+			key, err := section.GetKey(name)
+			if err == nil {
+				*target, err = key.Float64()
+			}
+			multiErr.Add(err)
+		}
+		floatKey("avg", &stats.Mean)
+		floatKey("stddev", &stats.Stddev)
+		floatKey("min", &stats.Min)
+		floatKey("max", &stats.Max)
+		if key, err := section.GetKey("count"); err != nil {
+			multiErr.Add(err)
+		} else {
+			stats.Count, err = key.Uint64()
+			multiErr.Add(err)
+		}
+		if err := multiErr.NilOrError(); err != nil {
+			return nil, fmt.Errorf("Invalid statistics for metric '%v' in %v: %v", section.Name(), filename, err)
+		}
+		result[section.Name()] = stats
+	}
+	return result, nil
+}
+
 func (stats *StoreStats) sortedFeatures() []string {
 	features := make([]string, 0, len(stats.stats))
 	for name := range stats.stats {
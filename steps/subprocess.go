@@ -3,10 +3,13 @@ package steps
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/bitflow-stream/go-bitflow/bitflow"
@@ -14,54 +17,94 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	DefaultSubprocessMaxRestarts  = 3
+	DefaultSubprocessRestartDelay = time.Second
+	DefaultSubprocessKillTimeout  = 10 * time.Second
+)
+
+// SubprocessRunner sends all incoming samples to the standard input of a child process and forwards the samples that
+// the child process writes to its standard output. Both directions use the configured marshalling format. Every line
+// written to the standard error of the child process is logged, prefixed with the String() of the SubprocessRunner.
+//
+// When the child process exits or fails before Close() is called, it is restarted up to MaxRestarts times. Samples that
+// were sent to the failed process, but not yet processed, are lost. After Close(), the standard input of the child
+// process is closed and the child process is expected to exit after writing all remaining output. It is killed
+// if it does not exit within KillTimeout, unless KillTimeout is zero.
 type SubprocessRunner struct {
 	bitflow.NoopProcessor
 	Cmd  string
 	Args []string
 
+	// Description optionally replaces the command line in String()
+	Description string
+
 	// Configurations for the input/output marshalling
 
 	Reader     bitflow.SampleReader
 	Writer     bitflow.SampleWriter
 	Marshaller bitflow.Marshaller
 
-	cmd    *exec.Cmd
-	output *bitflow.WriterSink
-	input  *bitflow.ReaderSource
-	stderr bytes.Buffer
+	// Configuration of the process supervision. MaxRestarts < 0 allows unlimited restarts.
+
+	MaxRestarts  int
+	RestartDelay time.Duration
+	KillTimeout  time.Duration
+
+	lock        sync.Mutex
+	process     *subprocess
+	closing     golib.StopChan
+	parseOutput bool
 }
 
 func RegisterSubprocessRunner(b reg.ProcessorRegistry) {
-	create := func(p *bitflow.SamplePipeline, params map[string]string) error {
-		cmd := SplitShellCommand(params["cmd"])
-		format, ok := params["format"]
-		if !ok {
-			format = "bin"
+	create := func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+		cmd := SplitShellCommand(reg.StrParam(params, "cmd", "", false, &err))
+		format := reg.StrParam(params, "format", "bin", true, &err)
+		maxRestarts := reg.IntParam(params, "max-restarts", DefaultSubprocessMaxRestarts, true, &err)
+		restartDelay := reg.DurationParam(params, "restart-delay", DefaultSubprocessRestartDelay, true, &err)
+		killTimeout := reg.DurationParam(params, "kill-timeout", DefaultSubprocessKillTimeout, true, &err)
+		if err != nil {
+			return
+		}
+		if len(cmd) == 0 {
+			return reg.ParameterError("cmd", errors.New("Empty command"))
+		}
+		for _, param := range []string{"cmd", "format", "max-restarts", "restart-delay", "kill-timeout"} {
+			delete(params, param)
 		}
-		delete(params, "cmd")
-		delete(params, "format")
 
-		if err := bitflow.DefaultEndpointFactory.ParseParameters(params); err != nil {
+		if err := b.Endpoints.ParseParameters(params); err != nil {
 			return fmt.Errorf("Error parsing parameters: %v", err)
 		}
 
 		runner := &SubprocessRunner{
-			Cmd:  cmd[0],
-			Args: cmd[1:],
+			Cmd:          cmd[0],
+			Args:         cmd[1:],
+			MaxRestarts:  maxRestarts,
+			RestartDelay: restartDelay,
+			KillTimeout:  killTimeout,
 		}
-		if err := runner.Configure(format, &bitflow.DefaultEndpointFactory); err != nil {
+		if err := runner.Configure(format, &b.Endpoints); err != nil {
 			return err
 		}
 		p.Add(runner)
 		return nil
 	}
-	b.RegisterAnalysisParamsErr("subprocess", create, "Start a subprocess for processing samples. Samples will be sent/received over std in/out in the given format (default: binary)", reg.RequiredParams("cmd"), reg.OptionalParams("format"))
+	b.RegisterAnalysisParamsErr("subprocess", create,
+		"Start a subprocess for processing samples. Samples will be sent/received over std in/out in the given format (default: binary, all registered formats are supported). "+
+			"The subprocess is restarted when it exits unexpectedly (max-restarts < 0 allows unlimited restarts), and killed when it does not exit within kill-timeout after the input is closed. "+
+			"Lines written to std err are logged.",
+		reg.RequiredParams("cmd"), reg.OptionalParams("format", "max-restarts", "restart-delay", "kill-timeout"),
+		reg.ParamDetails("max-restarts", reg.TypeInt, fmt.Sprint(DefaultSubprocessMaxRestarts), "Number of restarts after the subprocess exited unexpectedly"),
+		reg.ParamDetails("restart-delay", reg.TypeDuration, DefaultSubprocessRestartDelay.String(), "Pause before restarting the subprocess"),
+		reg.ParamDetails("kill-timeout", reg.TypeDuration, DefaultSubprocessKillTimeout.String(), "Time to wait for the subprocess to exit after closing its input"))
 }
 
 func (r *SubprocessRunner) Configure(marshallingFormat string, f *bitflow.EndpointFactory) error {
 	format := bitflow.MarshallingFormat(marshallingFormat)
 	var err error
-	r.Marshaller, err = bitflow.DefaultEndpointFactory.CreateMarshaller(format)
+	r.Marshaller, err = f.CreateMarshaller(format)
 	if err != nil {
 		return err
 	}
@@ -74,94 +117,187 @@ func (r *SubprocessRunner) Configure(marshallingFormat string, f *bitflow.Endpoi
 }
 
 func (r *SubprocessRunner) Start(wg *sync.WaitGroup) golib.StopChan {
-	if err := r.createProcess(); err != nil {
-		return golib.NewStoppedChan(err)
+	stopper := r.NoopProcessor.Start(wg)
+	r.closing = golib.NewStopChan()
+	_, isEmpty := r.GetSink().(*bitflow.DroppingSampleProcessor)
+	r.parseOutput = r.GetSink() != nil && !isEmpty
+	if !r.parseOutput {
+		log.Printf("%v: Not parsing subprocess output", r)
 	}
 
-	var tasks golib.TaskGroup
-	if r.input != nil {
-		// (Optionally) start the input first
-		tasks.Add(&bitflow.SourceTaskWrapper{r.input})
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	process, err := r.startProcess()
+	if err != nil {
+		r.Error(err)
+		r.CloseSink()
+		return stopper
 	}
-	tasks.Add(&golib.NoopTask{
-		Description: "",
-		Chan:        golib.WaitErrFunc(wg, r.runProcess),
-	}, &bitflow.ProcessorTaskWrapper{r.output})
-
-	channels := tasks.StartTasks(wg)
-	return golib.WaitErrFunc(wg, func() error {
-		golib.WaitForAny(channels)
+	r.process = process
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer r.CloseSink()
+		if err := r.supervise(); err != nil {
+			r.Error(err)
+		}
+	}()
+	return stopper
+}
 
-		// Try to stop everything
-		if r.input != nil {
-			r.input.Close()
+// supervise waits for the current process to exit and restarts it, if necessary.
+func (r *SubprocessRunner) supervise() error {
+	restarts := 0
+	for {
+		err := r.waitForProcess()
+		if r.closing.Stopped() {
+			return err
+		}
+		if err == nil {
+			err = errors.New("Subprocess exited before the input was closed")
+		}
+		if r.MaxRestarts >= 0 && restarts >= r.MaxRestarts {
+			return fmt.Errorf("%v: %v (not restarting after %v restart(s))", r, err, restarts)
+		}
+		restarts++
+		log.Warnf("%v: %v, restarting in %v (restart %v)", r, err, r.RestartDelay, restarts)
+		if !r.restart() {
+			return nil
 		}
-		r.Close()
+	}
+}
 
-		err := tasks.CollectMultiError(channels)
+// restart blocks incoming samples, until the new process is started. The result is false, if Close()
+// was called in the meantime.
+func (r *SubprocessRunner) restart() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.process = nil
+	if r.closing.WaitTimeout(r.RestartDelay) {
+		process, err := r.startProcess()
+		if err != nil {
+			log.Errorf("%v: Failed to restart subprocess: %v", r, err)
+			return true // Restart again, if allowed
+		}
+		r.process = process
+		return true
+	}
+	return false
+}
 
-		// After everything is shut down: forward the close call
-		r.CloseSink()
-		return err.NilOrError()
-	})
+func (r *SubprocessRunner) waitForProcess() error {
+	r.lock.Lock()
+	process := r.process
+	r.lock.Unlock()
+	if process == nil {
+		return errors.New("Subprocess could not be started")
+	}
+	select {
+	case <-process.exited:
+	case <-r.closing.WaitChan():
+		if r.KillTimeout <= 0 {
+			<-process.exited
+			break
+		}
+		select {
+		case <-process.exited:
+		case <-time.After(r.KillTimeout):
+			log.Warnf("%v: Subprocess did not exit within %v after closing its input, killing it", r, r.KillTimeout)
+			process.kill()
+			<-process.exited
+		}
+	}
+	return process.err
 }
 
-func (r *SubprocessRunner) createProcess() error {
-	r.cmd = exec.Command(r.Cmd, r.Args...)
-	r.cmd.Stderr = &r.stderr
+func (r *SubprocessRunner) startProcess() (*subprocess, error) {
+	p := &subprocess{
+		cmd:    exec.Command(r.Cmd, r.Args...),
+		exited: make(chan struct{}),
+	}
 	desc := r.String()
-
-	writePipe, err := r.cmd.StdinPipe()
+	stdin, err := p.cmd.StdinPipe()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stdout io.ReadCloser
+	if r.parseOutput {
+		if stdout, err = p.cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
 	}
-	r.output = &bitflow.WriterSink{
-		Output:      writePipe,
-		Description: desc,
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start subprocess '%v': %v", r.Cmd, err)
 	}
-	r.output.Writer = r.Writer
-	r.output.Marshaller = r.Marshaller
+	p.output = r.Writer.Open(stdin, r.Marshaller)
 
-	if _, isEmpty := r.GetSink().(*bitflow.DroppingSampleProcessor); r.GetSink() != nil && !isEmpty {
-		readPipe, err := r.cmd.StdoutPipe()
-		if err != nil {
-			return err
+	var readers sync.WaitGroup
+	var readErr error
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Warnf("%v (stderr): %v", desc, scanner.Text())
 		}
-		r.input = &bitflow.ReaderSource{
-			Input:       readPipe,
-			Description: desc,
+	}()
+	if stdout != nil {
+		input := r.Reader.Open(stdout, r.GetSink())
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			if _, err := input.ReadSamples(desc); err != nil && err != io.EOF {
+				// io.EOF is returned if the process exits without output
+				readErr = fmt.Errorf("Failed to read subprocess output: %v", err)
+				p.kill()
+			}
+		}()
+	}
+	go func() {
+		defer close(p.exited)
+		// All output must be read before calling Wait()
+		readers.Wait()
+		p.err = p.cmd.Wait()
+		if exitErr, ok := p.err.(*exec.ExitError); ok {
+			p.err = fmt.Errorf("Subprocess '%v' exited abnormally (%v)", r.Cmd, exitErr.ProcessState.String())
 		}
-		r.input.Reader = r.Reader
-		r.input.SetSink(r.GetSink())
-	} else {
-		log.Printf("%v: Not parsing subprocess output", r)
+		if readErr != nil {
+			p.err = readErr
+		}
+	}()
+	return p, nil
+}
+
+type subprocess struct {
+	cmd    *exec.Cmd
+	output *bitflow.SampleOutputStream
+	exited chan struct{}
+	err    error
+}
+
+func (p *subprocess) kill() {
+	if err := p.cmd.Process.Kill(); err != nil {
+		log.Debugf("Failed to kill subprocess %v: %v", p.cmd.Path, err)
 	}
-	return nil
 }
 
-func (r *SubprocessRunner) runProcess() error {
-	err := r.cmd.Run()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if exitErr.Success() {
-				err = nil
-			} else {
-				if r.stderr.Len() > 0 {
-					log.Warnf("Stderr output of %v:", r)
-					scanner := bufio.NewScanner(&r.stderr)
-					scanner.Split(bufio.ScanLines)
-					for scanner.Scan() {
-						log.Warnln(" > " + scanner.Text())
-					}
-				}
-				return fmt.Errorf("Subprocess '%v' exited abnormally (%v)", r.Cmd, exitErr.ProcessState.String())
-			}
-		}
+func (p *subprocess) hasExited() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
 	}
-	return err
 }
 
 func (r *SubprocessRunner) String() string {
+	if r.Description != "" {
+		return r.Description
+	}
 	var args bytes.Buffer
 	for _, arg := range r.Args {
 		if !strings.ContainsRune(arg, ' ') {
@@ -181,11 +317,28 @@ func (r *SubprocessRunner) String() string {
 }
 
 func (r *SubprocessRunner) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
-	return r.output.Sample(sample, header)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.process == nil {
+		return fmt.Errorf("%v: Subprocess is not running", r)
+	}
+	err := r.process.output.Sample(sample, header)
+	if err != nil && r.process.hasExited() {
+		// The process will be restarted, the sample is lost
+		log.Debugf("%v: Dropping sample, subprocess has exited: %v", r, err)
+		err = nil
+	}
+	return err
 }
 
+// Close closes the input of the subprocess. The subsequent processor is closed after the subprocess has exited.
 func (r *SubprocessRunner) Close() {
-	r.output.Close()
-	// TODO if the process won't close, try to kill it
-	// r.cmd.Process.Kill()
+	r.closing.Stop()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.process != nil {
+		if err := r.process.output.Close(); err != nil {
+			log.Debugf("%v: Error closing subprocess input: %v", r, err)
+		}
+	}
 }
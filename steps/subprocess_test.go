@@ -0,0 +1,66 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/assert"
+)
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+	closed  bool
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, _ *bitflow.Header) error {
+	s.samples = append(s.samples, sample)
+	return nil
+}
+
+func (s *collectingSink) Close() {
+	s.closed = true
+}
+
+func runSubprocess(t *testing.T, runner *SubprocessRunner, numSamples int, waitForError bool) (*collectingSink, error) {
+	assert.NoError(t, runner.Configure("csv", bitflow.NewEndpointFactory()))
+	out := new(collectingSink)
+	runner.SetSink(out)
+	var wg sync.WaitGroup
+	stopper := runner.Start(&wg)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	for i := 0; i < numSamples; i++ {
+		sample := &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i), 1}, Time: time.Unix(int64(i), 0)}
+		assert.NoError(t, runner.Sample(sample, header))
+	}
+	if waitForError {
+		stopper.Wait()
+	}
+	runner.Close()
+	wg.Wait()
+	assert.True(t, out.closed)
+	return out, stopper.Err()
+}
+
+func TestSubprocessRunner(t *testing.T) {
+	out, err := runSubprocess(t, &SubprocessRunner{Cmd: "cat"}, 10, false)
+	assert.NoError(t, err)
+	assert.Len(t, out.samples, 10)
+	assert.Equal(t, []bitflow.Value{9, 1}, out.samples[9].Values)
+}
+
+func TestSubprocessRunner_restart(t *testing.T) {
+	runner := &SubprocessRunner{Cmd: "sh", Args: []string{"-c", "echo failing >&2; exit 1"}, MaxRestarts: 2}
+	out, err := runSubprocess(t, runner, 0, true)
+	assert.EqualError(t, err, "Subprocess [sh -c \"echo failing >&2; exit 1\"]: "+
+		"Subprocess 'sh' exited abnormally (exit status 1) (not restarting after 2 restart(s))")
+	assert.Empty(t, out.samples)
+}
+
+func TestSubprocessRunner_kill(t *testing.T) {
+	runner := &SubprocessRunner{Cmd: "sleep", Args: []string{"60"}, KillTimeout: 10 * time.Millisecond}
+	_, err := runSubprocess(t, runner, 0, false)
+	assert.EqualError(t, err, "Subprocess 'sleep' exited abnormally (signal: killed)")
+}
@@ -0,0 +1,198 @@
+package steps
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultOneHotMaxValues = 100
+	DefaultHashBuckets     = 16
+	DefaultHashPrefix      = "tag_hash_"
+)
+
+func RegisterTagFeatures(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("one_hot",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			tag := reg.StrParam(params, "tag", "", false, &err)
+			encoder := &OneHotEncoder{
+				Tag:       tag,
+				Prefix:    reg.StrParam(params, "prefix", tag+"_", true, &err),
+				MaxValues: reg.IntParam(params, "max_values", DefaultOneHotMaxValues, true, &err),
+			}
+			if values := params["values"]; values != "" {
+				encoder.Values = strings.Split(values, ",")
+			}
+			if err == nil && encoder.MaxValues < 1 {
+				err = reg.ParameterError("max_values", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				p.Add(encoder)
+			}
+			return
+		},
+		"Append one metric for every value of the given tag (named <prefix><value>, the prefix defaults to '<tag>_'). The metric of the current tag value is 1, all others are 0. "+
+			"If the values parameter (comma-separated) is given, only these values are encoded and the header does not change. "+
+			"Otherwise, a new metric is appended whenever a new tag value is encountered, up to max_values metrics.",
+		reg.RequiredParams("tag"), reg.OptionalParams("values", "prefix", "max_values"))
+
+	b.RegisterAnalysisParamsErr("hash_tags",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			hasher := &TagHasher{
+				Buckets: reg.IntParam(params, "buckets", DefaultHashBuckets, true, &err),
+				Prefix:  reg.StrParam(params, "prefix", DefaultHashPrefix, true, &err),
+				Signed:  reg.BoolParam(params, "signed", false, true, &err),
+			}
+			if tags := params["tags"]; tags != "" {
+				hasher.Tags = strings.Split(tags, ",")
+			}
+			if err == nil && hasher.Buckets < 1 {
+				err = reg.ParameterError("buckets", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				p.Add(hasher)
+			}
+			return
+		},
+		"Encode the values of the given tags (comma-separated, default: all tags) as a fixed number of metrics (named <prefix><index>) using the hashing trick. "+
+			"Every tag/value pair is hashed to one of the buckets, which is incremented by 1. "+
+			"If signed is true, a second hash decides whether the bucket is incremented or decremented, which reduces the bias caused by hash collisions.",
+		reg.OptionalParams("tags", "buckets", "prefix", "signed"))
+}
+
+// OneHotEncoder appends a binary metric for every value of a tag. If Values is empty, the encoded values are
+// collected from the incoming samples, which changes the outgoing header whenever a new value is encountered.
+type OneHotEncoder struct {
+	bitflow.NoopProcessor
+	Tag       string
+	Prefix    string
+	Values    []string // Optional
+	MaxValues int      // Only used if Values is empty
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	indices   map[string]int
+	warned    bool
+}
+
+func (e *OneHotEncoder) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if e.indices == nil {
+		e.indices = make(map[string]int, len(e.Values))
+		for i, value := range e.Values {
+			e.indices[value] = i
+		}
+	}
+	value := sample.Tag(e.Tag)
+	index, ok := e.indices[value]
+	changed := e.checker.HeaderChanged(header)
+	if !ok && len(e.Values) == 0 && value != "" {
+		if len(e.indices) < e.MaxValues {
+			index, ok = len(e.indices), true
+			e.indices[value] = index
+			changed = true
+		} else if !e.warned {
+			e.warned = true
+			log.Warnf("%v: Reached the maximum number of %v encoded values, ignoring new values (such as '%v'). This warning is printed once.", e, e.MaxValues, value)
+		}
+	}
+	if changed {
+		e.updateHeader(header)
+	}
+	values := make([]float64, len(e.indices))
+	if ok {
+		values[index] = 1
+	}
+	AppendToSample(sample, values)
+	return e.NoopProcessor.Sample(sample, e.outHeader)
+}
+
+func (e *OneHotEncoder) updateHeader(header *bitflow.Header) {
+	fields := make([]string, len(header.Fields), len(header.Fields)+len(e.indices))
+	copy(fields, header.Fields)
+	fields = fields[:len(header.Fields)+len(e.indices)]
+	for value, index := range e.indices {
+		fields[len(header.Fields)+index] = e.Prefix + value
+	}
+	e.outHeader = header.Clone(fields)
+}
+
+func (e *OneHotEncoder) String() string {
+	res := fmt.Sprintf("One-hot encoding of tag %v", e.Tag)
+	if len(e.Values) > 0 {
+		res += fmt.Sprintf(" (values %v)", strings.Join(e.Values, ", "))
+	} else {
+		res += fmt.Sprintf(" (max %v values)", e.MaxValues)
+	}
+	return res
+}
+
+// TagHasher appends a fixed number of metrics that encode the tags of every sample using feature hashing.
+type TagHasher struct {
+	bitflow.NoopProcessor
+	Tags    []string // If empty, all tags are encoded
+	Buckets int
+	Prefix  string
+	Signed  bool
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+}
+
+func (h *TagHasher) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if h.checker.HeaderChanged(header) {
+		fields := make([]string, len(header.Fields), len(header.Fields)+h.Buckets)
+		copy(fields, header.Fields)
+		for i := 0; i < h.Buckets; i++ {
+			fields = append(fields, fmt.Sprintf("%v%v", h.Prefix, i))
+		}
+		h.outHeader = header.Clone(fields)
+	}
+	values := make([]float64, h.Buckets)
+	if len(h.Tags) == 0 {
+		for _, pair := range sample.SortedTags() {
+			h.add(values, pair.Key, pair.Value)
+		}
+	} else {
+		for _, tag := range h.Tags {
+			if sample.HasTag(tag) {
+				h.add(values, tag, sample.Tag(tag))
+			}
+		}
+	}
+	AppendToSample(sample, values)
+	return h.NoopProcessor.Sample(sample, h.outHeader)
+}
+
+func (h *TagHasher) add(values []float64, tag, value string) {
+	hash := fnv.New64a()
+	hash.Write([]byte(tag))
+	hash.Write([]byte{'='})
+	hash.Write([]byte(value))
+	sum := hash.Sum64()
+	delta := 1.0
+	if h.Signed && sum>>63 == 1 {
+		delta = -1
+	}
+	values[(sum&(1<<63-1))%uint64(h.Buckets)] += delta
+}
+
+func (h *TagHasher) OutputSampleSize(sampleSize int) int {
+	return sampleSize + h.Buckets
+}
+
+func (h *TagHasher) String() string {
+	tags := "all tags"
+	if len(h.Tags) > 0 {
+		tags = "tags " + strings.Join(h.Tags, ", ")
+	}
+	res := fmt.Sprintf("Feature hashing of %v (%v buckets", tags, h.Buckets)
+	if h.Signed {
+		res += ", signed"
+	}
+	return res + ")"
+}
@@ -0,0 +1,129 @@
+package steps
+
+import (
+	"hash/fnv"
+	"math"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type tagFeaturesTestSuite struct {
+	testsupport.Suite
+}
+
+func TestTagFeatures(t *testing.T) {
+	suite.Run(t, new(tagFeaturesTestSuite))
+}
+
+var tagFeaturesTestHeader = &bitflow.Header{Fields: []string{"a"}}
+
+func (s *tagFeaturesTestSuite) TestOneHotFixedValues() {
+	out := s.Process(&OneHotEncoder{Tag: "color", Prefix: "c_", Values: []string{"red", "green"}}, tagFeaturesTestHeader,
+		testsupport.NewSample(0, "color=green", 1),
+		testsupport.NewSample(0, "color=blue", 2),
+		testsupport.NewSample(0, "color=red", 3),
+		testsupport.NewSample(0, "", 4))
+	out.AssertFields(s.T(), "a", "c_red", "c_green")
+	out.AssertValues(s.T(), [][]float64{{1, 0, 1}, {2, 0, 0}, {3, 1, 0}, {4, 0, 0}})
+}
+
+func (s *tagFeaturesTestSuite) TestOneHotCollectedValues() {
+	out := s.Process(&OneHotEncoder{Tag: "color", Prefix: "c_", MaxValues: 2}, tagFeaturesTestHeader,
+		testsupport.NewSample(0, "color=green", 1),
+		testsupport.NewSample(0, "", 2),
+		testsupport.NewSample(0, "color=red", 3),
+		testsupport.NewSample(0, "color=blue", 4), // Exceeds the maximum number of values
+		testsupport.NewSample(0, "color=green", 5))
+	headers := out.Headers()
+	s.Equal([]string{"a", "c_green"}, headers[0].Fields)
+	s.Equal([]string{"a", "c_green"}, headers[1].Fields)
+	for _, header := range headers[2:] {
+		s.Equal([]string{"a", "c_green", "c_red"}, header.Fields)
+	}
+	out.AssertValues(s.T(), [][]float64{{1, 1}, {2, 0}, {3, 0, 1}, {4, 0, 0}, {5, 1, 0}})
+}
+
+func (s *tagFeaturesTestSuite) TestOneHotHeaderChange() {
+	step := &OneHotEncoder{Tag: "t", Prefix: "t_", MaxValues: 10}
+	out := testsupport.NewCapturingSink()
+	step.SetSink(out)
+	s.NoError(step.Sample(testsupport.NewSample(0, "t=x", 1), tagFeaturesTestHeader))
+	s.NoError(step.Sample(testsupport.NewSample(0, "t=x", 1, 2), &bitflow.Header{Fields: []string{"a", "b"}}))
+	s.Equal([]string{"a", "b", "t_x"}, out.Headers()[1].Fields)
+	s.Equal([]float64{1, 2, 1}, out.Values()[1])
+}
+
+// expectedBucket computes the bucket and sign of a tag/value pair independently of the TagHasher
+func (s *tagFeaturesTestSuite) expectedBucket(tag, value string, buckets int) (int, float64) {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(tag + "=" + value))
+	sum := hash.Sum64()
+	sign := 1.0
+	if sum&(1<<63) != 0 {
+		sign = -1
+	}
+	return int((sum << 1 >> 1) % uint64(buckets)), sign
+}
+
+func (s *tagFeaturesTestSuite) TestHashTags() {
+	for _, signed := range []bool{false, true} {
+		out := s.Process(&TagHasher{Buckets: 8, Prefix: "h", Signed: signed, Tags: []string{"host", "service"}}, tagFeaturesTestHeader,
+			testsupport.NewSample(0, "host=a service=web other=x", 1),
+			testsupport.NewSample(0, "service=db", 2),
+			testsupport.NewSample(0, "", 3))
+		out.AssertFields(s.T(), "a", "h0", "h1", "h2", "h3", "h4", "h5", "h6", "h7")
+		for i, tags := range [][][2]string{{{"host", "a"}, {"service", "web"}}, {{"service", "db"}}, nil} {
+			expected := make([]float64, 9)
+			expected[0] = float64(i + 1)
+			for _, tag := range tags {
+				bucket, sign := s.expectedBucket(tag[0], tag[1], 8)
+				if !signed {
+					sign = 1
+				}
+				expected[bucket+1] += sign
+			}
+			s.Equal(expected, out.Values()[i], "Sample %v (signed %v)", i, signed)
+		}
+	}
+}
+
+func (s *tagFeaturesTestSuite) TestHashAllTags() {
+	out := s.Process(&TagHasher{Buckets: 1, Prefix: "h"}, tagFeaturesTestHeader,
+		testsupport.NewSample(0, "x=1 y=2 z=3", 0),
+		testsupport.NewSample(0, "", 0))
+	out.AssertValues(s.T(), [][]float64{{0, 3}, {0, 0}})
+
+	// Collisions can cancel out with signed hashing, but the magnitude is bounded by the number of tags
+	out = s.Process(&TagHasher{Buckets: 4, Prefix: "h", Signed: true}, tagFeaturesTestHeader,
+		testsupport.NewSample(0, "x=1 y=2 z=3", 0))
+	var sum float64
+	for _, val := range out.Values()[0][1:] {
+		sum += math.Abs(val)
+	}
+	s.True(sum <= 3 && int(sum)%2 == 1, "Invalid bucket values %v", out.Values()[0])
+}
+
+func (s *tagFeaturesTestSuite) TestParameters() {
+	b := reg.NewProcessorRegistry()
+	RegisterTagFeatures(b)
+	oneHot, _ := b.GetAnalysis("one_hot")
+	pipe := new(bitflow.SamplePipeline)
+	s.NoError(oneHot.Func(pipe, map[string]string{"tag": "color", "values": "red,green"}))
+	encoder := pipe.Processors[0].(*OneHotEncoder)
+	s.Equal("color_", encoder.Prefix)
+	s.Equal([]string{"red", "green"}, encoder.Values)
+	s.Error(oneHot.Func(new(bitflow.SamplePipeline), map[string]string{"tag": "color", "max_values": "0"}))
+
+	hash, _ := b.GetAnalysis("hash_tags")
+	pipe = new(bitflow.SamplePipeline)
+	s.NoError(hash.Func(pipe, map[string]string{"tags": "a,b", "signed": "true"}))
+	hasher := pipe.Processors[0].(*TagHasher)
+	s.Equal(DefaultHashBuckets, hasher.Buckets)
+	s.Equal(DefaultHashPrefix, hasher.Prefix)
+	s.Equal("Feature hashing of tags a, b (16 buckets, signed)", hasher.String())
+	s.Error(hash.Func(new(bitflow.SamplePipeline), map[string]string{"buckets": "0"}))
+}
@@ -0,0 +1,139 @@
+package steps
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterTagRewriter(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("set_tag",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			var rewriter *TagRewriter
+			key := reg.StrParam(params, "key", "", false, &err)
+			value := reg.StrParam(params, "value", "", true, &err)
+			regex := reg.StrParam(params, "regex", "", true, &err)
+			replace := reg.StrParam(params, "replace", "$0", true, &err)
+			source := reg.StrParam(params, "source", key, true, &err)
+			if err != nil {
+				return
+			}
+			_, hasValue := params["value"]
+			if hasValue == (regex != "") {
+				return fmt.Errorf("Exactly one of the parameters 'value' and 'regex' must be defined")
+			}
+			if hasValue {
+				rewriter, err = NewTagTemplateRewriter(key, value)
+			} else {
+				rewriter, err = NewTagRegexRewriter(key, source, regex, replace)
+			}
+			if err == nil {
+				p.Add(rewriter)
+			}
+			return
+		},
+		"Set a tag to the result of a Go template (value parameter, e.g. {{.Tags.host}}-{{.Time.Format \"2006-01\"}}), "+
+			"or to a regex substitution of a tag (regex, replace and source parameters, e.g. regex='^(\\w+)-\\d+$' and replace='$1'). "+
+			"The template has access to .Tags, .Fields and .Time, and the functions lower, upper, trim and replace.",
+		reg.RequiredParams("key"), reg.OptionalParams("value", "regex", "replace", "source"),
+		reg.ParamDetails("key", reg.TypeString, "", "Tag to set"),
+		reg.ParamDetails("value", reg.TypeString, "", "Go template producing the tag value"),
+		reg.ParamDetails("regex", reg.TypeString, "", "Regular expression matched against the source tag. Samples where it does not match are not modified"),
+		reg.ParamDetails("replace", reg.TypeString, "$0", "Replacement for the matched part of the source tag, can refer to capture groups like $1 or ${name}"),
+		reg.ParamDetails("source", reg.TypeString, "", "Tag that the regex is applied to (default: the key parameter)"))
+}
+
+// TagTemplateData is the data available in the templates of TagRewriter. Tags with special characters in the name
+// can be accessed through the index function, e.g. {{index .Tags "container-id"}}.
+type TagTemplateData struct {
+	Tags   map[string]string
+	Fields map[string]float64
+	Time   time.Time
+}
+
+var tagTemplateFunctions = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+}
+
+// TagRewriter sets a tag to the result of a Go template, or to a regex substitution of another tag.
+type TagRewriter struct {
+	bitflow.NoopProcessor
+	Key string
+
+	template     *template.Template
+	templateText string
+	withFields   bool // Only build the map of fields if the template refers to it
+
+	source  string
+	regex   *regexp.Regexp
+	replace string
+}
+
+// NewTagTemplateRewriter returns a TagRewriter that sets the tag key to the result of the Go template, which is
+// executed with a TagTemplateData value.
+func NewTagTemplateRewriter(key, value string) (*TagRewriter, error) {
+	tmpl, err := template.New(key).Option("missingkey=zero").Funcs(tagTemplateFunctions).Parse(value)
+	if err != nil {
+		return nil, reg.ParameterError("value", err)
+	}
+	return &TagRewriter{Key: key, template: tmpl, templateText: value, withFields: strings.Contains(value, ".Fields")}, nil
+}
+
+// NewTagRegexRewriter returns a TagRewriter that applies the regex to the tag source. If it matches, the tag key
+// is set to the source value with the matched part replaced by replace, which can refer to capture groups.
+func NewTagRegexRewriter(key, source, regex, replace string) (*TagRewriter, error) {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, reg.ParameterError("regex", err)
+	}
+	return &TagRewriter{Key: key, source: source, regex: compiled, replace: replace}, nil
+}
+
+func (r *TagRewriter) String() string {
+	if r.regex != nil {
+		return fmt.Sprintf("Set tag %v to tag %v with regex %v replaced by '%v'", r.Key, r.source, r.regex, r.replace)
+	}
+	return fmt.Sprintf("Set tag %v to template %v", r.Key, r.templateText)
+}
+
+func (r *TagRewriter) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if r.regex != nil {
+		value := sample.Tag(r.source)
+		if r.regex.MatchString(value) {
+			sample.SetTag(r.Key, r.regex.ReplaceAllString(value, r.replace))
+		}
+	} else {
+		value, err := r.execute(sample, header)
+		if err != nil {
+			return fmt.Errorf("%v: %v", r, err)
+		}
+		sample.SetTag(r.Key, value)
+	}
+	return r.NoopProcessor.Sample(sample, header)
+}
+
+func (r *TagRewriter) execute(sample *bitflow.Sample, header *bitflow.Header) (string, error) {
+	data := TagTemplateData{Tags: sample.TagMap(), Time: sample.Time}
+	if r.withFields {
+		data.Fields = make(map[string]float64, len(header.Fields))
+		for i, field := range header.Fields {
+			if i < len(sample.Values) {
+				data.Fields[field] = float64(sample.Values[i])
+			}
+		}
+	}
+	var result bytes.Buffer
+	err := r.template.Execute(&result, data)
+	return result.String(), err
+}
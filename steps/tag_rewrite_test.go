@@ -0,0 +1,58 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type tagRewriteTestSuite struct {
+	testsupport.Suite
+}
+
+func TestTagRewrite(t *testing.T) {
+	suite.Run(t, new(tagRewriteTestSuite))
+}
+
+func (s *tagRewriteTestSuite) rewrite(rewriter *TagRewriter, err error) *testsupport.CapturingSink {
+	s.NoError(err)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	return s.Process(rewriter, header, testsupport.NewSample(0, "host=web-01 container-id=abc", 1, 2))
+}
+
+func (s *tagRewriteTestSuite) TestTemplate() {
+	out := s.rewrite(NewTagTemplateRewriter("bucket", `{{.Tags.host}}-{{.Time.Format "2006-01"}}`))
+	s.Equal([]string{"web-01-2000-01"}, out.Tags("bucket"))
+
+	out = s.rewrite(NewTagTemplateRewriter("host", `{{upper (index .Tags "container-id")}}{{.Tags.missing}}/{{if gt .Fields.b 1.0}}high{{else}}low{{end}}/{{replace "-" "_" .Tags.host}}`))
+	s.Equal([]string{"ABC/high/web_01"}, out.Tags("host"))
+
+	_, err := NewTagTemplateRewriter("x", "{{.Tags.host")
+	s.Error(err)
+}
+
+func (s *tagRewriteTestSuite) TestTemplateError() {
+	rewriter, err := NewTagTemplateRewriter("x", "{{.Other}}")
+	s.NoError(err)
+	rewriter.SetSink(new(bitflow.DroppingSampleProcessor))
+	s.Error(rewriter.Sample(testsupport.NewSample(0, ""), &bitflow.Header{}))
+}
+
+func (s *tagRewriteTestSuite) TestRegex() {
+	out := s.rewrite(NewTagRegexRewriter("role", "host", `^(?P<role>\w+)-(\d+)$`, "${role}/$2"))
+	s.Equal([]string{"web/01"}, out.Tags("role"))
+
+	// Samples where the regex does not match are not modified
+	out = s.rewrite(NewTagRegexRewriter("host", "host", `^db-`, "database-"))
+	s.Equal([]string{"web-01"}, out.Tags("host"))
+
+	out = s.rewrite(NewTagRegexRewriter("host", "host", `-\d+`, ""))
+	s.Equal([]string{"web"}, out.Tags("host"))
+	s.Equal([]time.Duration{0}, out.Offsets())
+
+	_, err := NewTagRegexRewriter("host", "host", `(`, "")
+	s.EqualError(err, "Failed to parse 'regex' parameter: error parsing regexp: missing closing ): `(`")
+}
@@ -0,0 +1,154 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterTopK(b reg.ProcessorRegistry) {
+	create := func(bottom bool) reg.AnalysisFunc {
+		return func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &TopK{
+				Metric: reg.StrParam(params, "metric", "", false, &err),
+				K:      reg.IntParam(params, "k", 0, false, &err),
+				Bottom: bottom,
+			}
+			group := reg.StrParam(params, "group", "", true, &err)
+			aggName := reg.StrParam(params, "agg", "max", true, &err)
+			if err != nil {
+				return
+			}
+			if step.K <= 0 {
+				return reg.ParameterError("k", errors.New("Must be positive"))
+			}
+			if group != "" {
+				step.GroupTags = strings.Split(group, ",")
+				step.Aggregation = aggName
+				if step.aggregate, err = GetWindowAggregationFunc(aggName); err != nil {
+					return reg.ParameterError("agg", err)
+				}
+			}
+			p.Batch(step)
+			return
+		}
+	}
+	b.RegisterAnalysisParamsErr("top_k", create(false),
+		"Keep only the k samples with the highest values of the given metric in every window. "+
+			"If group is set (comma-separated tags), the samples are grouped by these tags and all samples of the k groups with the highest aggregated value (agg, default max) are kept",
+		reg.RequiredParams("metric", "k"), reg.OptionalParams("group", "agg"), reg.EnforceBatch())
+	b.RegisterAnalysisParamsErr("bottom_k", create(true),
+		"Like top_k, but keep the samples or groups with the lowest values of the given metric",
+		reg.RequiredParams("metric", "k"), reg.OptionalParams("group", "agg"), reg.EnforceBatch())
+}
+
+// TopK is a batch processing step that keeps the K samples with the highest (or lowest, if Bottom is set) values
+// of a metric. If GroupTags is set, the samples are grouped by the values of these tags, every group is ranked by
+// the aggregated metric value, and all samples of the K best groups are kept. Samples with a NaN value are ranked last.
+// The order of the remaining samples is not changed.
+type TopK struct {
+	Metric      string
+	K           int
+	Bottom      bool
+	GroupTags   []string
+	Aggregation string
+
+	aggregate WindowAggregationFunc
+}
+
+type topKGroup struct {
+	score   float64
+	samples []int
+}
+
+func (t *TopK) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	index, ok := header.BuildIndex()[t.Metric]
+	if !ok {
+		return nil, nil, fmt.Errorf("%v: Metric %v not found in header", t, t.Metric)
+	}
+	groups := t.groups(samples, index)
+	sort.SliceStable(groups, func(i, j int) bool {
+		return t.better(groups[i].score, groups[j].score)
+	})
+	if len(groups) > t.K {
+		groups = groups[:t.K]
+	}
+	var selected []int
+	for _, group := range groups {
+		selected = append(selected, group.samples...)
+	}
+	sort.Ints(selected)
+	result := make([]*bitflow.Sample, len(selected))
+	for i, sampleIndex := range selected {
+		result[i] = samples[sampleIndex]
+	}
+	return header, result, nil
+}
+
+func (t *TopK) groups(samples []*bitflow.Sample, index int) []*topKGroup {
+	if len(t.GroupTags) == 0 {
+		groups := make([]*topKGroup, len(samples))
+		for i, sample := range samples {
+			groups[i] = &topKGroup{score: float64(sample.Values[index]), samples: []int{i}}
+		}
+		return groups
+	}
+	var groups []*topKGroup
+	var values [][]float64
+	groupIndices := make(map[string]int)
+	for i, sample := range samples {
+		key := t.groupKey(sample)
+		groupIndex, ok := groupIndices[key]
+		if !ok {
+			groupIndex = len(groups)
+			groupIndices[key] = groupIndex
+			groups = append(groups, new(topKGroup))
+			values = append(values, nil)
+		}
+		groups[groupIndex].samples = append(groups[groupIndex].samples, i)
+		values[groupIndex] = append(values[groupIndex], float64(sample.Values[index]))
+	}
+	for i, group := range groups {
+		group.score = t.aggregate(values[i])
+	}
+	return groups
+}
+
+func (t *TopK) groupKey(sample *bitflow.Sample) string {
+	var key strings.Builder
+	for _, tag := range t.GroupTags {
+		key.WriteString(sample.Tag(tag))
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+func (t *TopK) better(a, b float64) bool {
+	switch {
+	case math.IsNaN(a):
+		return false
+	case math.IsNaN(b):
+		return true
+	case t.Bottom:
+		return a < b
+	default:
+		return a > b
+	}
+}
+
+func (t *TopK) String() string {
+	kind := "Top"
+	if t.Bottom {
+		kind = "Bottom"
+	}
+	res := fmt.Sprintf("%v-%v samples by %v", kind, t.K, t.Metric)
+	if len(t.GroupTags) > 0 {
+		res = fmt.Sprintf("%v-%v groups of %v by %v(%v)", kind, t.K, strings.Join(t.GroupTags, ","), t.Aggregation, t.Metric)
+	}
+	return res
+}
@@ -0,0 +1,67 @@
+package steps
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type topKTestSuite struct {
+	testsupport.Suite
+}
+
+func TestTopK(t *testing.T) {
+	suite.Run(t, new(topKTestSuite))
+}
+
+// topK runs the step on one sample per value with the metric cpu, and the host tag from the hosts parameter.
+// It returns the cpu values of the output samples.
+func (s *topKTestSuite) topK(step *TopK, hosts []string, values ...float64) ([]float64, error) {
+	header := &bitflow.Header{Fields: []string{"cpu"}}
+	samples := make([]*bitflow.Sample, len(values))
+	for i, value := range values {
+		samples[i] = testsupport.NewSample(0, "host="+hosts[i], bitflow.Value(value))
+	}
+	_, out, err := step.ProcessBatch(header, samples)
+	res := make([]float64, len(out))
+	for i, sample := range out {
+		res[i] = float64(sample.Values[0])
+	}
+	return res, err
+}
+
+func (s *topKTestSuite) TestSamples() {
+	hosts := []string{"a", "b", "c", "d", "e"}
+
+	out, err := s.topK(&TopK{Metric: "cpu", K: 2}, hosts, 3, math.NaN(), 5, 1, 4)
+	s.NoError(err)
+	s.Equal([]float64{5, 4}, out, "the input order must be kept")
+
+	out, err = s.topK(&TopK{Metric: "cpu", K: 3, Bottom: true}, hosts, 3, math.NaN(), 5, 1, 4)
+	s.NoError(err)
+	s.Equal([]float64{3, 1, 4}, out, "NaN values must be ranked last")
+
+	out, err = s.topK(&TopK{Metric: "cpu", K: 10}, hosts, 1, 2)
+	s.NoError(err)
+	s.Len(out, 2)
+
+	_, err = s.topK(&TopK{Metric: "mem", K: 1}, hosts, 1)
+	s.Error(err)
+}
+
+func (s *topKTestSuite) TestGroups() {
+	hosts := []string{"a", "b", "a", "c", "b", "c"}
+
+	step := &TopK{Metric: "cpu", K: 2, GroupTags: []string{"host"}, Aggregation: "max", aggregate: WindowAggregationFuncs["max"]}
+	out, err := s.topK(step, hosts, 1, 5, 9, 2, 4, 3)
+	s.NoError(err)
+	s.Equal([]float64{1, 5, 9, 4}, out, "all samples of hosts a and b must be kept")
+
+	step = &TopK{Metric: "cpu", K: 1, Bottom: true, GroupTags: []string{"host"}, Aggregation: "avg", aggregate: WindowAggregationFuncs["avg"]}
+	out, err = s.topK(step, hosts, 1, 5, 9, 2, 4, 3)
+	s.NoError(err)
+	s.Equal([]float64{2, 3}, out)
+}
@@ -0,0 +1,278 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// Unit is a unit of measurement. Values can be converted between units of the same Dimension by their Factor,
+// which is relative to the base unit of the dimension.
+type Unit struct {
+	Name      string
+	Dimension string
+	Factor    float64
+}
+
+const (
+	DimensionTime  = "time"
+	DimensionData  = "data"
+	DimensionRatio = "ratio"
+	DimensionCount = "count"
+)
+
+// Units contains the units that can be used in unit conversions. The base units are seconds, bytes, ratio (0..1) and count.
+var Units = map[string]Unit{}
+
+func init() {
+	add := func(dimension string, factor float64, names ...string) {
+		for _, name := range names {
+			Units[name] = Unit{Name: name, Dimension: dimension, Factor: factor}
+		}
+	}
+	add(DimensionTime, 1e-9, "ns")
+	add(DimensionTime, 1e-6, "us")
+	add(DimensionTime, 1e-3, "ms")
+	add(DimensionTime, 1, "s", "sec", "seconds")
+	add(DimensionTime, 60, "min", "minutes")
+	add(DimensionTime, 3600, "h", "hours")
+	add(DimensionData, 1.0/8, "bits")
+	add(DimensionData, 1, "bytes")
+	add(DimensionData, 1e3, "kb")
+	add(DimensionData, 1e6, "mb")
+	add(DimensionData, 1e9, "gb")
+	add(DimensionData, 1e12, "tb")
+	add(DimensionData, 1<<10, "kib")
+	add(DimensionData, 1<<20, "mib")
+	add(DimensionData, 1<<30, "gib")
+	add(DimensionData, 1<<40, "tib")
+	add(DimensionRatio, 1, "ratio")
+	add(DimensionRatio, 0.01, "percent")
+	add(DimensionRatio, 0.001, "permille")
+	add(DimensionCount, 1, "count")
+}
+
+// UnitConversion converts values from one unit to another. If RateUnit is set, the input values are monotonically
+// increasing counters, which are converted to their rate of change per RateUnit.
+type UnitConversion struct {
+	From     Unit
+	To       Unit
+	RateUnit *Unit
+}
+
+// ParseUnitConversion parses a conversion like 'bytes:mb' or 'ns:ms'. A time unit can be appended to the target unit
+// to convert a counter to a rate, e.g. 'bytes:mb/s' or 'count:count/min'.
+func ParseUnitConversion(spec string) (UnitConversion, error) {
+	var conv UnitConversion
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return conv, fmt.Errorf("Unit conversion must have the format <from>:<to>[/<time unit>], not '%v'", spec)
+	}
+	from, to := parts[0], parts[1]
+	if slash := strings.IndexByte(to, '/'); slash >= 0 {
+		rateUnit, ok := Units[to[slash+1:]]
+		if !ok || rateUnit.Dimension != DimensionTime {
+			return conv, fmt.Errorf("Unknown time unit '%v' in unit conversion '%v'", to[slash+1:], spec)
+		}
+		conv.RateUnit = &rateUnit
+		to = to[:slash]
+	}
+	var ok bool
+	if conv.From, ok = Units[from]; !ok {
+		return conv, fmt.Errorf("Unknown unit '%v' in unit conversion '%v'", from, spec)
+	}
+	if conv.To, ok = Units[to]; !ok {
+		return conv, fmt.Errorf("Unknown unit '%v' in unit conversion '%v'", to, spec)
+	}
+	if conv.From.Dimension != conv.To.Dimension {
+		return conv, fmt.Errorf("Cannot convert %v (%v) to %v (%v)", from, conv.From.Dimension, to, conv.To.Dimension)
+	}
+	return conv, nil
+}
+
+// Convert converts a value, or the difference between two counter values, to the target unit.
+func (c UnitConversion) Convert(value float64) float64 {
+	return value * c.From.Factor / c.To.Factor
+}
+
+// TargetName returns the name of the target unit, as used in metric names, e.g. 'mb' or 'mb_per_s'.
+func (c UnitConversion) TargetName() string {
+	if c.RateUnit != nil {
+		return c.To.Name + "_per_" + c.RateUnit.Name
+	}
+	return c.To.Name
+}
+
+// Rename replaces the suffix of the given metric name that names the source unit with the target unit,
+// e.g. net_rx_bytes becomes net_rx_mb. If the name has no such suffix, the target unit is appended.
+func (c UnitConversion) Rename(field string) string {
+	for _, sep := range []string{"_", "-", "/", "."} {
+		if strings.HasSuffix(field, sep+c.From.Name) {
+			return strings.TrimSuffix(field, c.From.Name) + c.TargetName()
+		}
+	}
+	return field + "_" + c.TargetName()
+}
+
+func (c UnitConversion) String() string {
+	res := c.From.Name + ":" + c.To.Name
+	if c.RateUnit != nil {
+		res += "/" + c.RateUnit.Name
+	}
+	return res
+}
+
+// UnitRule applies a UnitConversion to all metrics whose name matches the Pattern.
+type UnitRule struct {
+	Pattern    *regexp.Regexp
+	Conversion UnitConversion
+}
+
+func RegisterUnitConversion(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("convert_units",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			proc := new(UnitConverter)
+			if keys := params["key"]; keys != "" {
+				proc.KeyTags = strings.Split(keys, ",")
+			}
+			delete(params, "key")
+			if len(params) == 0 {
+				return errors.New("Need at least one regex=conversion parameter, e.g. convert_units('_bytes$'='bytes:mb')")
+			}
+			patterns := make([]string, 0, len(params))
+			for pattern := range params {
+				patterns = append(patterns, pattern)
+			}
+			sort.Strings(patterns)
+			for _, pattern := range patterns {
+				regex, err := regexp.Compile(pattern)
+				if err != nil {
+					return reg.ParameterError(pattern, err)
+				}
+				conv, err := ParseUnitConversion(params[pattern])
+				if err != nil {
+					return reg.ParameterError(pattern, err)
+				}
+				proc.Rules = append(proc.Rules, UnitRule{Pattern: regex, Conversion: conv})
+			}
+			p.Add(proc)
+			return nil
+		},
+		"Convert metrics to a different unit and rename them accordingly. Every parameter maps a regex, that is matched against the metric names, "+
+			"to a conversion like 'bytes:mb', 'ns:ms' or 'percent:ratio'. If a time unit is appended to the target unit, like in 'bytes:mb/s', "+
+			"the metrics are treated as counters and converted to rates (the first sample yields NaN). A unit suffix of the metric name (e.g. _bytes) is replaced by the target unit, "+
+			"otherwise the target unit is appended. The reserved parameter key (comma-separated tags) keeps separate counter states for every combination of tag values. "+
+			"Units: ns, us, ms, s, min, h, bits, bytes, kb, mb, gb, tb, kib, mib, gib, tib, ratio, percent, permille, count")
+}
+
+// UnitConverter converts every metric matching one of the Rules. Only the first matching rule is applied, in the order of Rules.
+// Metrics that do not match any rule are forwarded unchanged.
+type UnitConverter struct {
+	bitflow.NoopProcessor
+	Rules   []UnitRule
+	KeyTags []string // If set, the counter states are kept separately for every combination of values of these tags
+
+	checker     bitflow.HeaderChecker
+	outHeader   *bitflow.Header
+	conversions []*UnitConversion // For every input field, the applied conversion, or nil
+	counters    map[string]*unitCounterState
+}
+
+type unitCounterState struct {
+	time   time.Time
+	values []float64
+}
+
+func (u *UnitConverter) String() string {
+	rules := make([]string, len(u.Rules))
+	for i, rule := range u.Rules {
+		rules[i] = fmt.Sprintf("%v -> %v", rule.Pattern, rule.Conversion)
+	}
+	res := "Convert units (" + strings.Join(rules, ", ") + ")"
+	if len(u.KeyTags) > 0 {
+		res += fmt.Sprintf(" per %v", u.KeyTags)
+	}
+	return res
+}
+
+func (u *UnitConverter) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if u.checker.HeaderChanged(header) {
+		u.updateHeader(header)
+	}
+	var state *unitCounterState
+	for i, conv := range u.conversions {
+		if conv == nil {
+			continue
+		}
+		if conv.RateUnit == nil {
+			sample.Values[i] = bitflow.Value(conv.Convert(float64(sample.Values[i])))
+			continue
+		}
+		if state == nil {
+			state = u.counterState(sample, len(header.Fields))
+		}
+		counter := float64(sample.Values[i])
+		sample.Values[i] = bitflow.Value(u.rate(conv, state, i, counter, sample.Time))
+		state.values[i] = counter
+	}
+	if state != nil {
+		state.time = sample.Time
+	}
+	return u.NoopProcessor.Sample(sample, u.outHeader)
+}
+
+func (u *UnitConverter) rate(conv *UnitConversion, state *unitCounterState, index int, counter float64, t time.Time) float64 {
+	units := t.Sub(state.time).Seconds() / conv.RateUnit.Factor
+	prev := state.values[index]
+	if state.time.IsZero() || units <= 0 || math.IsNaN(prev) {
+		return math.NaN()
+	}
+	diff := counter - prev
+	if diff < 0 {
+		// Counter reset: assume the counter restarted from zero
+		diff = counter
+	}
+	return conv.Convert(diff) / units
+}
+
+func (u *UnitConverter) counterState(sample *bitflow.Sample, numFields int) *unitCounterState {
+	key := ""
+	if len(u.KeyTags) > 0 {
+		values := make([]string, len(u.KeyTags))
+		for i, tag := range u.KeyTags {
+			values[i] = sample.Tag(tag)
+		}
+		key = strings.Join(values, ",")
+	}
+	state, ok := u.counters[key]
+	if !ok {
+		state = &unitCounterState{values: make([]float64, numFields)}
+		u.counters[key] = state
+	}
+	return state
+}
+
+func (u *UnitConverter) updateHeader(header *bitflow.Header) {
+	u.counters = make(map[string]*unitCounterState)
+	u.conversions = make([]*UnitConversion, len(header.Fields))
+	fields := make([]string, len(header.Fields))
+	for i, field := range header.Fields {
+		fields[i] = field
+		for _, rule := range u.Rules {
+			if rule.Pattern.MatchString(field) {
+				conv := rule.Conversion
+				u.conversions[i] = &conv
+				fields[i] = conv.Rename(field)
+				break
+			}
+		}
+	}
+	u.outHeader = header.Clone(fields)
+}
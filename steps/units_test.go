@@ -0,0 +1,68 @@
+package steps
+
+import (
+	"math"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/stretchr/testify/suite"
+)
+
+type unitsTestSuite struct {
+	testsupport.Suite
+}
+
+func TestUnits(t *testing.T) {
+	suite.Run(t, new(unitsTestSuite))
+}
+
+func (s *unitsTestSuite) rule(pattern, spec string) UnitRule {
+	conv, err := ParseUnitConversion(spec)
+	s.NoError(err)
+	return UnitRule{Pattern: regexp.MustCompile(pattern), Conversion: conv}
+}
+
+func (s *unitsTestSuite) TestParseConversion() {
+	conv, err := ParseUnitConversion("bytes:mib")
+	s.NoError(err)
+	s.Equal(2.0, conv.Convert(2*1024*1024))
+	s.Equal("net_rx_mib", conv.Rename("net_rx_bytes"))
+	s.Equal("net_rx_mib", conv.Rename("net_rx"))
+
+	conv, err = ParseUnitConversion("ns:ms")
+	s.NoError(err)
+	s.Equal(1.5, conv.Convert(1500000))
+	s.Equal("latency_ms", conv.Rename("latency_ns"))
+
+	conv, err = ParseUnitConversion("bytes:kb/s")
+	s.NoError(err)
+	s.Equal("io_kb_per_s", conv.Rename("io_bytes"))
+
+	for _, invalid := range []string{"bytes", "bytes:ms", "xyz:mb", "bytes:mb/kb", "bytes:mb/x"} {
+		_, err = ParseUnitConversion(invalid)
+		s.Error(err, invalid)
+	}
+}
+
+func (s *unitsTestSuite) TestConverter() {
+	conv := &UnitConverter{
+		Rules:   []UnitRule{s.rule("^rx_bytes$", "bytes:kb/s"), s.rule("_bytes$", "bytes:kb"), s.rule("^cpu$", "percent:ratio")},
+		KeyTags: []string{"host"},
+	}
+	out := s.Process(conv, &bitflow.Header{Fields: []string{"rx_bytes", "mem_bytes", "cpu", "other"}},
+		testsupport.NewSample(0, "host=a", 1000, 2000, 50, 1),
+		testsupport.NewSample(0, "host=b", 5000, 2000, 50, 1),
+		testsupport.NewSample(2*time.Second, "host=a", 5000, 3000, 20, 2),
+		testsupport.NewSample(3*time.Second, "host=a", 1000, 3000, 20, 2)) // Counter reset
+
+	values := out.Values()
+	s.Equal([]string{"rx_kb_per_s", "mem_kb", "cpu_ratio", "other"}, out.Headers()[0].Fields)
+	s.True(math.IsNaN(values[0][0]))
+	s.Equal([]float64{2, 0.5, 1}, values[0][1:])
+	s.True(math.IsNaN(values[1][0]), "the counter state must be kept per host")
+	s.Equal([]float64{2, 3, 0.2, 2}, values[2])
+	s.Equal(1.0, values[3][0])
+}
@@ -0,0 +1,349 @@
+// Package wasm executes WebAssembly modules with the wazero runtime, so that user-defined transformations can be deployed
+// as processing steps without recompiling bitflow. Modules cannot import anything from the host, so they can only operate
+// on their own memory. Every invocation of the module is aborted after a timeout, see NewTransform().
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Names of the exports that make up the sample transformation ABI
+const (
+	BufferFunction    = "bitflow_buffer"
+	TransformFunction = "bitflow_transform"
+	MemoryExport      = "memory"
+)
+
+const (
+	// DefaultMaxPages limits the memory of a module to 16 MB.
+	DefaultMaxPages = 256
+
+	// DefaultTimeout limits the execution time of one invocation of the module.
+	DefaultTimeout = 10 * time.Second
+
+	maxPages = 65536
+)
+
+// Transform executes a WebAssembly module that implements the sample transformation ABI. The module must export:
+//
+//	memory: the linear memory, which is used to exchange the sample values.
+//	bitflow_buffer(capacity i32) -> i32: returns the address of a buffer that can hold at least capacity f64 values.
+//	bitflow_transform(buffer i32, rows i32, cols i32) -> i32: transforms the values in the buffer in place.
+//
+// The buffer contains one row of values for every sample (only one in stream mode, the entire batch in batch mode),
+// in row-major order. The transform function returns the number of columns written back into the buffer, which
+// must be either the number of input columns, or the number of configured output fields. In stream mode, a negative
+// result drops the sample.
+type Transform struct {
+	Description string
+
+	// Outputs optionally renames the output columns. If it is empty, the output has the same fields as the input.
+	Outputs []string
+
+	runtime   wazero.Runtime
+	module    api.Module
+	memory    api.Memory
+	timeout   time.Duration
+	buffer    uint32
+	capacity  int
+	inHeader  *bitflow.Header
+	outHeader *bitflow.Header
+}
+
+// NewTransform compiles and instantiates the given module and checks that it implements the ABI. The memory of the module
+// is limited to maxPages pages of 64 KB. If timeout is not zero, every invocation that runs longer than the timeout
+// is aborted. This closes the module instance, so the Transform cannot be used afterwards.
+func NewTransform(code []byte, maxPages uint32, timeout time.Duration) (*Transform, error) {
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().WithMemoryLimitPages(maxPages).WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	t := &Transform{runtime: runtime, timeout: timeout}
+	if err := t.instantiate(ctx, code); err != nil {
+		_ = runtime.Close(ctx) // Drop error
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Transform) instantiate(ctx context.Context, code []byte) error {
+	compiled, err := t.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return err
+	}
+	if len(compiled.ImportedFunctions()) > 0 || len(compiled.ImportedMemories()) > 0 {
+		return errors.New("Imports are not supported, the module must be self-contained")
+	}
+	expected := map[string]string{
+		BufferFunction:    "(i32) -> (i32)",
+		TransformFunction: "(i32, i32, i32) -> (i32)",
+	}
+	functions := compiled.ExportedFunctions()
+	for name, expectedSignature := range expected {
+		if function, ok := functions[name]; !ok {
+			return fmt.Errorf("The WebAssembly module does not export the function %v", name)
+		} else if signature := fmt.Sprintf("(%v) -> (%v)", formatTypes(function.ParamTypes()), formatTypes(function.ResultTypes())); signature != expectedSignature {
+			return fmt.Errorf("Exported function %v has signature %v, expected %v", name, signature, expectedSignature)
+		}
+	}
+	if _, ok := compiled.ExportedMemories()[MemoryExport]; !ok {
+		return fmt.Errorf("The WebAssembly module does not export its memory as '%v'", MemoryExport)
+	}
+	t.module, err = t.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return err
+	}
+	t.memory = t.module.ExportedMemory(MemoryExport)
+	return nil
+}
+
+func formatTypes(types []api.ValueType) string {
+	var b bytes.Buffer
+	for i, typ := range types {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(api.ValueTypeName(typ))
+	}
+	return b.String()
+}
+
+// Close releases the compiled module and the memory of the instance.
+func (t *Transform) Close() error {
+	return t.runtime.Close(context.Background())
+}
+
+// call invokes an exported function with i32 arguments and returns its i32 result.
+func (t *Transform) call(name string, args ...int) (int32, error) {
+	params := make([]uint64, len(args))
+	for i, arg := range args {
+		params[i] = api.EncodeI32(int32(arg))
+	}
+	ctx := context.Background()
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	results, err := t.module.ExportedFunction(name).Call(ctx, params...)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("Timeout of %v exceeded", t.timeout)
+		}
+		return 0, fmt.Errorf("%v: %v", t, err)
+	}
+	return api.DecodeI32(results[0]), nil
+}
+
+func (t *Transform) String() string {
+	return t.Description
+}
+
+// Execute runs the transformation on the values of the given samples and replaces their values with the results.
+// The result is the header of the output samples, or nil if the module asked to drop the samples.
+func (t *Transform) Execute(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, error) {
+	rows, cols := len(samples), len(header.Fields)
+	if rows == 0 {
+		return header, nil
+	}
+	width := cols
+	if len(t.Outputs) > width {
+		width = len(t.Outputs)
+	}
+	if err := t.allocate(rows * width); err != nil {
+		return nil, err
+	}
+	mem, _ := t.memory.Read(t.buffer, uint32(rows*width*8)) // Checked by allocate()
+	for i, sample := range samples {
+		if len(sample.Values) != cols {
+			return nil, fmt.Errorf("%v: Sample has %v values, but the header has %v fields", t, len(sample.Values), cols)
+		}
+		for j, value := range sample.Values {
+			binary.LittleEndian.PutUint64(mem[(i*cols+j)*8:], math.Float64bits(float64(value)))
+		}
+	}
+
+	result, err := t.call(TransformFunction, int(t.buffer), rows, cols)
+	if err != nil {
+		return nil, err
+	}
+	outCols := int(result)
+	if outCols < 0 {
+		return nil, nil
+	}
+	outHeader, err := t.outputHeader(header, outCols)
+	if err != nil {
+		return nil, err
+	}
+	// The memory might have grown during the call
+	mem, ok := t.memory.Read(t.buffer, uint32(rows*outCols*8))
+	if !ok {
+		return nil, fmt.Errorf("%v: The output values exceed the memory of the module", t)
+	}
+	for i, sample := range samples {
+		values := sample.Values
+		if cap(values) < outCols {
+			values = make([]bitflow.Value, outCols)
+		}
+		values = values[:outCols]
+		for j := range values {
+			values[j] = bitflow.Value(math.Float64frombits(binary.LittleEndian.Uint64(mem[(i*outCols+j)*8:])))
+		}
+		sample.Values = values
+	}
+	return outHeader, nil
+}
+
+// allocate asks the module for a buffer of the given number of values, if the current buffer is too small.
+func (t *Transform) allocate(values int) error {
+	if values <= t.capacity {
+		return nil
+	}
+	if values > math.MaxInt32/8 {
+		return fmt.Errorf("%v: Cannot allocate a buffer for %v values", t, values)
+	}
+	result, err := t.call(BufferFunction, values)
+	if err != nil {
+		return err
+	}
+	buffer := uint32(result)
+	if _, ok := t.memory.Read(buffer, uint32(values*8)); !ok {
+		return fmt.Errorf("%v: %v returned an invalid buffer address %v for %v values", t, BufferFunction, buffer, values)
+	}
+	t.buffer, t.capacity = buffer, values
+	return nil
+}
+
+func (t *Transform) outputHeader(header *bitflow.Header, cols int) (*bitflow.Header, error) {
+	if len(t.Outputs) == 0 {
+		if cols != len(header.Fields) {
+			return nil, fmt.Errorf("%v: The module returned %v columns for %v input fields, but no output fields are configured",
+				t, cols, len(header.Fields))
+		}
+		return header, nil
+	}
+	if cols != len(t.Outputs) {
+		return nil, fmt.Errorf("%v: The module returned %v columns, but %v output fields are configured", t, cols, len(t.Outputs))
+	}
+	if t.inHeader != header {
+		t.inHeader = header
+		t.outHeader = header.Clone(t.Outputs)
+	}
+	return t.outHeader, nil
+}
+
+// Processor applies a Transform to every incoming sample.
+type Processor struct {
+	bitflow.NoopProcessor
+	*Transform
+}
+
+func (p *Processor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	outHeader, err := p.Execute(header, []*bitflow.Sample{sample})
+	if err != nil || outHeader == nil {
+		return err
+	}
+	return p.NoopProcessor.Sample(sample, outHeader)
+}
+
+func (p *Processor) Close() {
+	if err := p.Transform.Close(); err != nil {
+		p.Log().Warnf("Failed to close WebAssembly runtime: %v", err)
+	}
+	p.NoopProcessor.Close()
+}
+
+func (p *Processor) String() string {
+	return p.Transform.String()
+}
+
+// BatchProcessor applies a Transform to an entire batch of samples. The Transform is closed together with
+// the bitflow.BatchProcessor containing this step.
+type BatchProcessor struct {
+	*Transform
+}
+
+func (p *BatchProcessor) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	outHeader, err := p.Execute(header, samples)
+	if err != nil {
+		return nil, nil, err
+	}
+	if outHeader == nil {
+		return header, nil, nil
+	}
+	return outHeader, samples, nil
+}
+
+func RegisterWasm(b reg.ProcessorRegistry) {
+	load := func(params map[string]string) (*Transform, error) {
+		var err error
+		file := reg.StrParam(params, "module", "", false, &err)
+		outputs := reg.StrParam(params, "outputs", "", true, &err)
+		maxMemory := reg.IntParam(params, "max-memory", DefaultMaxPages, true, &err)
+		timeout := reg.DurationParam(params, "timeout", DefaultTimeout, true, &err)
+		if err != nil {
+			return nil, err
+		}
+		if maxMemory <= 0 || maxMemory > maxPages {
+			return nil, reg.ParameterError("max-memory", fmt.Errorf("Must be between 1 and %v", maxPages))
+		}
+		if timeout < 0 {
+			return nil, reg.ParameterError("timeout", fmt.Errorf("Must not be negative"))
+		}
+		code, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		transform, err := NewTransform(code, uint32(maxMemory), timeout)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load WebAssembly module %v: %v", file, err)
+		}
+		transform.Description = fmt.Sprintf("WebAssembly transform %v", file)
+		if outputs != "" {
+			transform.Outputs = strings.Split(outputs, ",")
+		}
+		return transform, nil
+	}
+	options := []reg.Option{
+		reg.RequiredParams("module"), reg.OptionalParams("outputs", "max-memory", "timeout"),
+		reg.ParamDetails("module", reg.TypeString, "", "Path to the WebAssembly module (binary format)"),
+		reg.ParamDetails("outputs", reg.TypeString, "", "Comma-separated names of the output fields, if the module changes the number of fields"),
+		reg.ParamDetails("max-memory", reg.TypeInt, fmt.Sprint(DefaultMaxPages), "Maximum memory of the module, in pages of 64 KB"),
+		reg.ParamDetails("timeout", reg.TypeDuration, DefaultTimeout.String(), "Maximum execution time of one invocation of the module (0 means unlimited)"),
+	}
+
+	b.RegisterAnalysisParamsErr("wasm",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			transform, err := load(params)
+			if err == nil {
+				p.Add(&Processor{Transform: transform})
+			}
+			return err
+		},
+		"Transform every sample with a WebAssembly module. The module must export its memory, a function bitflow_buffer(capacity i32) -> i32 "+
+			"returning the address of a buffer for the given number of f64 values, and a function bitflow_transform(buffer i32, rows i32, cols i32) -> i32, "+
+			"which transforms the row-major values in the buffer in place and returns the number of output columns, or a negative value to drop the sample.",
+		options...)
+	b.RegisterAnalysisParamsErr("wasm_batch",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			transform, err := load(params)
+			if err == nil {
+				p.Batch(&BatchProcessor{Transform: transform})
+			}
+			return err
+		},
+		"Like wasm(), but pass an entire batch of samples to the WebAssembly module, one row per sample. A negative result drops the entire batch.",
+		append(options, reg.EnforceBatch())...)
+}
@@ -0,0 +1,274 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/stretchr/testify/suite"
+)
+
+type wasmTestSuite struct {
+	suite.Suite
+}
+
+func TestWasm(t *testing.T) {
+	suite.Run(t, new(wasmTestSuite))
+}
+
+func leb(n int) []byte {
+	var result []byte
+	for ; n >= 0x80; n >>= 7 {
+		result = append(result, byte(n&0x7f|0x80))
+	}
+	return append(result, byte(n))
+}
+
+func vec(items ...[]byte) []byte {
+	result := leb(len(items))
+	for _, item := range items {
+		result = append(result, item...)
+	}
+	return result
+}
+
+func section(id byte, content []byte) []byte {
+	return cat([]byte{id}, leb(len(content)), content)
+}
+
+func cat(parts ...[]byte) []byte {
+	var result []byte
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
+}
+
+func name(n string) []byte {
+	return append([]byte{byte(len(n))}, n...)
+}
+
+func f64Const(v float64) []byte {
+	result := []byte{0x44, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(result[1:], math.Float64bits(v))
+	return result
+}
+
+// transformModule assembles a module implementing the transformation ABI. The buffer is always located at address 1024.
+// The local variables and body of bitflow_transform are given as parameters.
+func transformModule(locals []byte, body []byte) []byte {
+	code := cat(locals, body, []byte{0x0b})
+	return cat(
+		[]byte{0, 'a', 's', 'm', 1, 0, 0, 0},
+		section(1, vec(
+			[]byte{0x60, 1, 0x7f, 1, 0x7f},
+			[]byte{0x60, 3, 0x7f, 0x7f, 0x7f, 1, 0x7f})),
+		section(3, vec([]byte{0}, []byte{1})),
+		section(5, vec([]byte{0, 1})),
+		section(7, vec(
+			cat(name("memory"), []byte{2, 0}),
+			cat(name("bitflow_buffer"), []byte{0, 0}),
+			cat(name("bitflow_transform"), []byte{0, 1}))),
+		section(10, vec(
+			[]byte{5, 0, 0x41, 0x80, 0x08, 0x0b}, // i32.const 1024
+			cat(leb(len(code)), code))),
+	)
+}
+
+// doubleModule doubles all values and drops the samples where the first value is negative.
+var doubleModule = transformModule([]byte{1, 1, 0x7f}, cat(
+	[]byte{0x20, 0, 0x2b, 3, 0}, f64Const(0), []byte{0x63, 0x04, 0x40, 0x41, 0x7f, 0x0f, 0x0b}, // if (buf[0] < 0) return -1
+	[]byte{0x20, 1, 0x20, 2, 0x6c, 0x21, 1}, // rows = rows * cols
+	[]byte{0x02, 0x40, 0x03, 0x40},
+	[]byte{0x20, 3, 0x20, 1, 0x4f, 0x0d, 1}, // break if i >= rows
+	[]byte{0x20, 0, 0x20, 3, 0x41, 3, 0x74, 0x6a},
+	[]byte{0x20, 0, 0x20, 3, 0x41, 3, 0x74, 0x6a, 0x2b, 3, 0}, f64Const(2), []byte{0xa2, 0x39, 3, 0}, // buf[i] *= 2
+	[]byte{0x20, 3, 0x41, 1, 0x6a, 0x21, 3, 0x0c, 0}, // i++
+	[]byte{0x0b, 0x0b},
+	[]byte{0x20, 2}, // return cols
+))
+
+// sumModule replaces every row with the sum of its values.
+var sumModule = transformModule([]byte{2, 2, 0x7f, 1, 0x7c}, cat(
+	[]byte{0x02, 0x40, 0x03, 0x40},
+	[]byte{0x20, 3, 0x20, 1, 0x4f, 0x0d, 1}, // break if row >= rows
+	f64Const(0), []byte{0x21, 5, 0x41, 0, 0x21, 4},
+	[]byte{0x02, 0x40, 0x03, 0x40},
+	[]byte{0x20, 4, 0x20, 2, 0x4f, 0x0d, 1}, // break if col >= cols
+	[]byte{0x20, 5, 0x20, 0, 0x20, 3, 0x20, 2, 0x6c, 0x20, 4, 0x6a, 0x41, 3, 0x74, 0x6a, 0x2b, 3, 0, 0xa0, 0x21, 5}, // sum += buf[row*cols+col]
+	[]byte{0x20, 4, 0x41, 1, 0x6a, 0x21, 4, 0x0c, 0},
+	[]byte{0x0b, 0x0b},
+	[]byte{0x20, 0, 0x20, 3, 0x41, 3, 0x74, 0x6a, 0x20, 5, 0x39, 3, 0}, // buf[row] = sum
+	[]byte{0x20, 3, 0x41, 1, 0x6a, 0x21, 3, 0x0c, 0},
+	[]byte{0x0b, 0x0b},
+	[]byte{0x41, 1}, // return 1
+))
+
+// loopModule never terminates.
+var loopModule = transformModule([]byte{0}, []byte{0x03, 0x40, 0x0c, 0, 0x0b, 0x41, 0})
+
+func samples(values ...[]bitflow.Value) []*bitflow.Sample {
+	result := make([]*bitflow.Sample, len(values))
+	for i, v := range values {
+		result[i] = &bitflow.Sample{Values: v}
+	}
+	return result
+}
+
+func (s *wasmTestSuite) TestStream() {
+	transform, err := NewTransform(doubleModule, DefaultMaxPages, 0)
+	s.Require().NoError(err)
+	proc := &Processor{Transform: transform}
+	out := new(collectingSink)
+	proc.SetSink(out)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+
+	for _, sample := range samples([]bitflow.Value{1, 2}, []bitflow.Value{-1, 2}, []bitflow.Value{3, 0.5}) {
+		s.NoError(proc.Sample(sample, header))
+	}
+	s.Equal([][]bitflow.Value{{2, 4}, {6, 1}}, out.values)
+	s.Equal(header, out.header)
+}
+
+func (s *wasmTestSuite) TestBatchOutputs() {
+	transform, err := NewTransform(sumModule, DefaultMaxPages, 0)
+	s.Require().NoError(err)
+	transform.Outputs = []string{"sum"}
+	header := &bitflow.Header{Fields: []string{"a", "b", "c"}}
+
+	outHeader, result, err := (&BatchProcessor{Transform: transform}).ProcessBatch(header,
+		samples([]bitflow.Value{1, 2, 3}, []bitflow.Value{4, 5, 6}))
+	s.NoError(err)
+	s.Equal([]string{"sum"}, outHeader.Fields)
+	s.Len(result, 2)
+	s.Equal([]bitflow.Value{6}, result[0].Values)
+	s.Equal([]bitflow.Value{15}, result[1].Values)
+
+	// Without output fields, the number of columns must not change
+	transform.Outputs = nil
+	_, err = transform.Execute(header, samples([]bitflow.Value{1, 2, 3}))
+	s.EqualError(err, ": The module returned 1 columns for 3 input fields, but no output fields are configured")
+}
+
+func (s *wasmTestSuite) TestTimeout() {
+	transform, err := NewTransform(loopModule, DefaultMaxPages, 50*time.Millisecond)
+	s.Require().NoError(err)
+	defer transform.Close()
+	transform.Description = "loop"
+	_, err = transform.Execute(&bitflow.Header{Fields: []string{"a"}}, samples([]bitflow.Value{1}))
+	s.EqualError(err, "loop: Timeout of 50ms exceeded")
+
+	// The timeout applies to every invocation separately
+	transform, err = NewTransform(doubleModule, DefaultMaxPages, 50*time.Millisecond)
+	s.Require().NoError(err)
+	defer transform.Close()
+	header := &bitflow.Header{Fields: make([]string, 10)}
+	for i := 0; i < 3; i++ {
+		_, err = transform.Execute(header, samples(make([]bitflow.Value, 10)))
+		s.NoError(err)
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (s *wasmTestSuite) TestGlobals() {
+	// bitflow_transform increments and returns a global
+	module := cat(
+		[]byte{0, 'a', 's', 'm', 1, 0, 0, 0},
+		section(1, vec(
+			[]byte{0x60, 1, 0x7f, 1, 0x7f},
+			[]byte{0x60, 3, 0x7f, 0x7f, 0x7f, 1, 0x7f})),
+		section(3, vec([]byte{0}, []byte{1})),
+		section(5, vec([]byte{0, 1})),
+		section(6, vec([]byte{0x7f, 1, 0x41, 0, 0x0b})),
+		section(7, vec(
+			cat(name("memory"), []byte{2, 0}),
+			cat(name("bitflow_buffer"), []byte{0, 0}),
+			cat(name("bitflow_transform"), []byte{0, 1}))),
+		section(10, vec(
+			[]byte{5, 0, 0x41, 0x80, 0x08, 0x0b},
+			[]byte{11, 0, 0x23, 0, 0x41, 1, 0x6a, 0x24, 0, 0x23, 0, 0x0b})),
+	)
+	transform, err := NewTransform(module, DefaultMaxPages, DefaultTimeout)
+	s.Require().NoError(err)
+	defer transform.Close()
+	header := &bitflow.Header{Fields: []string{"a"}}
+	_, err = transform.Execute(header, samples([]bitflow.Value{1}))
+	s.NoError(err)
+	_, err = transform.Execute(header, samples([]bitflow.Value{1}))
+	s.EqualError(err, ": The module returned 2 columns for 1 input fields, but no output fields are configured")
+}
+
+func (s *wasmTestSuite) TestTrap() {
+	// bitflow_transform divides by zero
+	module := transformModule([]byte{0}, []byte{0x41, 1, 0x41, 0, 0x6d})
+	transform, err := NewTransform(module, DefaultMaxPages, DefaultTimeout)
+	s.Require().NoError(err)
+	defer transform.Close()
+	_, err = transform.Execute(&bitflow.Header{Fields: []string{"a"}}, samples([]bitflow.Value{1}))
+	s.Error(err)
+	s.Contains(err.Error(), "integer divide by zero")
+}
+
+func (s *wasmTestSuite) TestCloseBatch() {
+	transform, err := NewTransform(doubleModule, DefaultMaxPages, DefaultTimeout)
+	s.Require().NoError(err)
+	batch := new(bitflow.BatchProcessor)
+	batch.Add(&BatchProcessor{Transform: transform})
+	out := new(collectingSink)
+	batch.SetSink(out)
+	var wg sync.WaitGroup
+	batch.Start(&wg)
+	s.NoError(batch.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, &bitflow.Header{Fields: []string{"a", "b"}}))
+	batch.Close()
+	wg.Wait()
+	s.Equal([][]bitflow.Value{{2, 4}}, out.values)
+
+	// The runtime was closed together with the batch processor
+	_, err = transform.Execute(&bitflow.Header{Fields: []string{"a"}}, samples([]bitflow.Value{1}))
+	s.Error(err)
+}
+
+func (s *wasmTestSuite) TestInvalidModules() {
+	_, err := NewTransform([]byte("not wasm"), DefaultMaxPages, DefaultTimeout)
+	s.Error(err)
+
+	// Requiring more memory than allowed
+	_, err = NewTransform(doubleModule, 0, DefaultTimeout)
+	s.Error(err)
+
+	// Module without the ABI functions
+	_, err = NewTransform([]byte{0, 'a', 's', 'm', 1, 0, 0, 0}, DefaultMaxPages, DefaultTimeout)
+	s.Error(err)
+	s.Contains(err.Error(), "The WebAssembly module does not export the function")
+
+	// Wrong signature
+	module := cat(
+		[]byte{0, 'a', 's', 'm', 1, 0, 0, 0},
+		section(1, vec([]byte{0x60, 0, 1, 0x7f})),
+		section(3, vec([]byte{0}, []byte{0})),
+		section(5, vec([]byte{0, 1})),
+		section(7, vec(
+			cat(name("memory"), []byte{2, 0}),
+			cat(name("bitflow_buffer"), []byte{0, 0}),
+			cat(name("bitflow_transform"), []byte{0, 1}))),
+		section(10, vec([]byte{4, 0, 0x41, 0, 0x0b}, []byte{4, 0, 0x41, 0, 0x0b})),
+	)
+	_, err = NewTransform(module, DefaultMaxPages, 0)
+	s.Error(err)
+	s.Contains(err.Error(), "has signature () -> (i32)")
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	values [][]bitflow.Value
+	header *bitflow.Header
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.values = append(s.values, sample.Values)
+	s.header = header
+	return nil
+}
@@ -0,0 +1,189 @@
+package steps
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// WindowAggregationFunc computes one value from all values of a metric inside a window. The values are never empty.
+type WindowAggregationFunc func(values []float64) float64
+
+var WindowAggregationFuncs = map[string]WindowAggregationFunc{
+	"avg": func(values []float64) float64 {
+		return sumValues(values) / float64(len(values))
+	},
+	"sum": sumValues,
+	"min": func(values []float64) float64 {
+		res := values[0]
+		for _, val := range values[1:] {
+			res = math.Min(res, val)
+		}
+		return res
+	},
+	"max": func(values []float64) float64 {
+		res := values[0]
+		for _, val := range values[1:] {
+			res = math.Max(res, val)
+		}
+		return res
+	},
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+	"first": func(values []float64) float64 {
+		return values[0]
+	},
+	"last": func(values []float64) float64 {
+		return values[len(values)-1]
+	},
+}
+
+// GetWindowAggregationFunc returns the aggregation function with the given name. In addition to the names in
+// WindowAggregationFuncs, percentiles are supported in the form pNN, e.g. p50, p95 or p99.9.
+func GetWindowAggregationFunc(name string) (WindowAggregationFunc, error) {
+	if f, ok := WindowAggregationFuncs[name]; ok {
+		return f, nil
+	}
+	if strings.HasPrefix(name, "p") {
+		if percentile, err := strconv.ParseFloat(name[1:], 64); err == nil && percentile >= 0 && percentile <= 100 {
+			return func(values []float64) float64 {
+				return Percentile(values, percentile/100)
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown aggregation function '%v'", name)
+}
+
+// Percentile computes the given quantile (0..1) of the values, interpolating linearly between the closest ranks.
+// The values are not modified.
+func Percentile(values []float64, quantile float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	rank := quantile * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func sumValues(values []float64) float64 {
+	var sum float64
+	for _, val := range values {
+		sum += val
+	}
+	return sum
+}
+
+func RegisterWindowAggregation(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("aggregate",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			funcs := reg.StrParam(params, "func", "avg", true, &err)
+			if err == nil {
+				var agg *WindowAggregation
+				agg, err = NewWindowAggregation(strings.Split(funcs, ","))
+				if err != nil {
+					err = reg.ParameterError("func", err)
+				} else {
+					p.Batch(agg)
+				}
+			}
+			return
+		},
+		"Aggregate all samples in a window into one sample. The func parameter is a comma-separated list of aggregation functions (avg, sum, min, max, count, first, last, or percentiles like p95). With multiple functions, the output metrics are suffixed with the function name.",
+		reg.OptionalParams("func"), reg.EnforceBatch())
+
+	b.RegisterAnalysisParamsErr("aggregate_by",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			window := &bitflow.WindowProcessor{
+				Mode:            bitflow.TumblingWindow,
+				Size:            reg.DurationParam(params, "interval", 0, false, &err),
+				AllowedLateness: reg.DurationParam(params, "lateness", 0, true, &err),
+				KeyTags:         strings.Split(reg.StrParam(params, "tag", "", false, &err), ","),
+			}
+			funcs := reg.StrParam(params, "funcs", "avg", true, &err)
+			if err == nil {
+				err = window.Validate()
+			}
+			if err == nil {
+				var agg *WindowAggregation
+				agg, err = NewWindowAggregation(strings.Split(funcs, ","))
+				if err != nil {
+					err = reg.ParameterError("funcs", err)
+				} else {
+					p.Add(window.Add(agg))
+				}
+			}
+			return
+		},
+		"Maintain separate aggregation state for every value of the given tag(s) (comma-separated), and emit one aggregated sample per tag value and time interval. "+
+			"The funcs parameter is a comma-separated list of aggregation functions (avg, sum, min, max, count, first, last, or percentiles like p95).",
+		reg.RequiredParams("tag", "interval"), reg.OptionalParams("funcs", "lateness"))
+}
+
+// WindowAggregation is a batch processing step that reduces a batch of samples to a single sample.
+// The resulting sample has the timestamp and tags of the last sample in the batch.
+type WindowAggregation struct {
+	Funcs []string
+
+	funcs []WindowAggregationFunc
+}
+
+func NewWindowAggregation(funcNames []string) (*WindowAggregation, error) {
+	res := &WindowAggregation{Funcs: funcNames}
+	for _, name := range funcNames {
+		f, err := GetWindowAggregationFunc(name)
+		if err != nil {
+			return nil, err
+		}
+		res.funcs = append(res.funcs, f)
+	}
+	return res, nil
+}
+
+func (a *WindowAggregation) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	outHeader := header
+	if len(a.Funcs) > 1 {
+		fields := make([]string, 0, len(header.Fields)*len(a.Funcs))
+		for _, field := range header.Fields {
+			for _, name := range a.Funcs {
+				fields = append(fields, field+"_"+name)
+			}
+		}
+		outHeader = &bitflow.Header{Fields: fields}
+	}
+
+	values := make([]bitflow.Value, 0, len(outHeader.Fields))
+	metric := make([]float64, len(samples))
+	for i := range header.Fields {
+		for j, sample := range samples {
+			metric[j] = float64(sample.Values[i])
+		}
+		for _, f := range a.funcs {
+			values = append(values, bitflow.Value(f(metric)))
+		}
+	}
+	last := samples[len(samples)-1]
+	out := &bitflow.Sample{Values: values}
+	out.CopyMetadataFrom(last)
+	return outHeader, []*bitflow.Sample{out}, nil
+}
+
+func (a *WindowAggregation) OutputSampleSize(sampleSize int) int {
+	return sampleSize * len(a.Funcs)
+}
+
+func (a *WindowAggregation) String() string {
+	return fmt.Sprintf("Aggregate (%v)", strings.Join(a.Funcs, ", "))
+}
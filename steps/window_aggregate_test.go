@@ -0,0 +1,89 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/testsupport"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/stretchr/testify/suite"
+)
+
+type windowAggregateTestSuite struct {
+	testsupport.Suite
+}
+
+func TestWindowAggregate(t *testing.T) {
+	suite.Run(t, new(windowAggregateTestSuite))
+}
+
+func (s *windowAggregateTestSuite) aggregateBy(params map[string]string) (bitflow.SampleProcessor, error) {
+	b := reg.NewProcessorRegistry()
+	RegisterWindowAggregation(b)
+	analysis, ok := b.GetAnalysis("aggregate_by")
+	s.True(ok)
+	pipe := new(bitflow.SamplePipeline)
+	if err := analysis.Func(pipe, params); err != nil {
+		return nil, err
+	}
+	s.Len(pipe.Processors, 1)
+	return pipe.Processors[0], nil
+}
+
+func (s *windowAggregateTestSuite) TestAggregateBy() {
+	step, err := s.aggregateBy(map[string]string{"tag": "host", "interval": "10s", "funcs": "avg,p50,max"})
+	s.NoError(err)
+	out := s.Process(step, &bitflow.Header{Fields: []string{"cpu"}},
+		testsupport.NewSample(0, "host=a", 1),
+		testsupport.NewSample(time.Second, "host=b", 10),
+		testsupport.NewSample(2*time.Second, "host=a", 3),
+		testsupport.NewSample(5*time.Second, "host=a", 8),
+		testsupport.NewSample(12*time.Second, "host=b", 20),
+		testsupport.NewSample(13*time.Second, "host=a", 7))
+	out.AssertFields(s.T(), "cpu_avg", "cpu_p50", "cpu_max")
+	out.AssertValues(s.T(), [][]float64{{4, 3, 8}, {10, 10, 10}, {7, 7, 7}, {20, 20, 20}})
+	s.Equal([]string{"a", "b", "a", "b"}, out.Tags("host"))
+	s.Equal([]time.Duration{5 * time.Second, time.Second, 13 * time.Second, 12 * time.Second}, out.Offsets(),
+		"Aggregated samples must have the timestamp of the last sample in their window")
+}
+
+func (s *windowAggregateTestSuite) TestAggregateByLateness() {
+	step, err := s.aggregateBy(map[string]string{"tag": "host", "interval": "10s", "funcs": "sum", "lateness": "5s"})
+	s.NoError(err)
+	out := s.Process(step, &bitflow.Header{Fields: []string{"cpu"}},
+		testsupport.NewSample(0, "host=a", 1),
+		testsupport.NewSample(12*time.Second, "host=a", 2),
+		testsupport.NewSample(3*time.Second, "host=a", 4)) // Late, but within the allowed lateness
+	out.AssertValues(s.T(), [][]float64{{5}, {2}})
+}
+
+func (s *windowAggregateTestSuite) TestAggregateByParameters() {
+	for _, params := range []map[string]string{
+		{"tag": "host"},
+		{"interval": "10s"},
+		{"tag": "host", "interval": "0s"},
+		{"tag": "host", "interval": "10s", "funcs": "avg,median"},
+		{"tag": "host", "interval": "10s", "lateness": "-1s"},
+	} {
+		_, err := s.aggregateBy(params)
+		s.Error(err, "%v", params)
+	}
+}
+
+func (s *windowAggregateTestSuite) TestPercentile() {
+	values := []float64{5, 1, 4, 2, 3}
+	s.Equal(1.0, Percentile(values, 0))
+	s.Equal(3.0, Percentile(values, 0.5))
+	s.Equal(5.0, Percentile(values, 1))
+	s.InDelta(4.6, Percentile(values, 0.9), 1e-9)
+	s.Equal([]float64{5, 1, 4, 2, 3}, values, "The values must not be modified")
+
+	p95, err := GetWindowAggregationFunc("p95")
+	s.NoError(err)
+	s.InDelta(4.8, p95(values), 1e-9)
+	for _, name := range []string{"p", "p101", "p-1", "px", "median"} {
+		_, err := GetWindowAggregationFunc(name)
+		s.Error(err, name)
+	}
+}